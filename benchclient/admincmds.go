@@ -0,0 +1,84 @@
+package benchclient
+
+import (
+	"time"
+
+	"github.com/mit-dci/opencx/cxauctionrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PauseAuctions pauses auctions for a pair, the client's key must be the admin key
+func (cl *BenchClient) PauseAuctions(pair *match.Pair) (pauseAuctionsReply *cxauctionrpc.PauseAuctionsReply, err error) {
+	pauseAuctionsReply = new(cxauctionrpc.PauseAuctionsReply)
+	pauseAuctionsArgs := &cxauctionrpc.PauseAuctionsArgs{
+		Pair: *pair,
+	}
+
+	if pauseAuctionsArgs.Request, err = cl.signRequest("OpencxAuctionRPC.PauseAuctions", pauseAuctionsArgs.ArgsHash()); err != nil {
+		err = match.Wrapf(err, "Error signing admin command: %s", err)
+		return
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.PauseAuctions", pauseAuctionsArgs, pauseAuctionsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// ResumeAuctions resumes auctions for a pair, the client's key must be the admin key
+func (cl *BenchClient) ResumeAuctions(pair *match.Pair) (resumeAuctionsReply *cxauctionrpc.ResumeAuctionsReply, err error) {
+	resumeAuctionsReply = new(cxauctionrpc.ResumeAuctionsReply)
+	resumeAuctionsArgs := &cxauctionrpc.ResumeAuctionsArgs{
+		Pair: *pair,
+	}
+
+	if resumeAuctionsArgs.Request, err = cl.signRequest("OpencxAuctionRPC.ResumeAuctions", resumeAuctionsArgs.ArgsHash()); err != nil {
+		err = match.Wrapf(err, "Error signing admin command: %s", err)
+		return
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.ResumeAuctions", resumeAuctionsArgs, resumeAuctionsReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// SetAuctionSchedule sets the auction schedule for a pair, the client's key must be the admin key
+func (cl *BenchClient) SetAuctionSchedule(pair *match.Pair, duration time.Duration, aligned bool, recovery string, maxRetries uint64, retryDelay time.Duration) (setAuctionScheduleReply *cxauctionrpc.SetAuctionScheduleReply, err error) {
+	setAuctionScheduleReply = new(cxauctionrpc.SetAuctionScheduleReply)
+	setAuctionScheduleArgs := &cxauctionrpc.SetAuctionScheduleArgs{
+		Pair:       *pair,
+		Duration:   duration,
+		Aligned:    aligned,
+		Recovery:   recovery,
+		MaxRetries: maxRetries,
+		RetryDelay: retryDelay,
+	}
+
+	if setAuctionScheduleArgs.Request, err = cl.signRequest("OpencxAuctionRPC.SetAuctionSchedule", setAuctionScheduleArgs.ArgsHash()); err != nil {
+		err = match.Wrapf(err, "Error signing admin command: %s", err)
+		return
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.SetAuctionSchedule", setAuctionScheduleArgs, setAuctionScheduleReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetAuctionSchedule gets the auction schedule for a pair
+func (cl *BenchClient) GetAuctionSchedule(pair *match.Pair) (getAuctionScheduleReply *cxauctionrpc.GetAuctionScheduleReply, err error) {
+	getAuctionScheduleReply = new(cxauctionrpc.GetAuctionScheduleReply)
+	getAuctionScheduleArgs := &cxauctionrpc.GetAuctionScheduleArgs{
+		Pair: *pair,
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.GetAuctionSchedule", getAuctionScheduleArgs, getAuctionScheduleReply); err != nil {
+		return
+	}
+
+	return
+}
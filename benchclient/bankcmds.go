@@ -3,10 +3,8 @@ package benchclient
 import (
 	"fmt"
 
-	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxrpc"
 	"github.com/mit-dci/opencx/match"
-	"golang.org/x/crypto/sha3"
 )
 
 // GetBalance calls the getbalance rpc command
@@ -22,16 +20,9 @@ func (cl *BenchClient) GetBalance(asset string) (getBalanceReply *cxrpc.GetBalan
 		Asset: asset,
 	}
 
-	// create e = hash(m)
-	sha3 := sha3.New256()
-	sha3.Write([]byte(asset))
-	e := sha3.Sum(nil)
-
-	// Sign
-	compactSig, err := koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false)
-
-	// set signature in args
-	getBalanceArgs.Signature = compactSig
+	if getBalanceArgs.Request, err = cl.userRequest("OpencxRPC.GetBalance", getBalanceArgs.ArgsHash()); err != nil {
+		return
+	}
 
 	if err = cl.Call("OpencxRPC.GetBalance", getBalanceArgs, getBalanceReply); err != nil {
 		return
@@ -53,16 +44,9 @@ func (cl *BenchClient) GetDepositAddress(asset string) (getDepositAddressReply *
 		Asset: asset,
 	}
 
-	// create e = hash(m)
-	sha3 := sha3.New256()
-	sha3.Write([]byte(asset))
-	e := sha3.Sum(nil)
-
-	// Sign order
-	compactSig, err := koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false)
-
-	// set signature in args
-	getDepositAddressArgs.Signature = compactSig
+	if getDepositAddressArgs.Request, err = cl.userRequest("OpencxRPC.GetDepositAddress", getDepositAddressArgs.ArgsHash()); err != nil {
+		return
+	}
 
 	if err = cl.Call("OpencxRPC.GetDepositAddress", getDepositAddressArgs, getDepositAddressReply); err != nil {
 		return
@@ -118,16 +102,9 @@ func (cl *BenchClient) Withdraw(amount uint64, asset match.Asset, address string
 		},
 	}
 
-	// create e = hash(m)
-	sha3 := sha3.New256()
-	sha3.Write(withdrawArgs.Withdrawal.Serialize())
-	e := sha3.Sum(nil)
-
-	// Sign order
-	compactSig, err := koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false)
-
-	// set signature in args
-	withdrawArgs.Signature = compactSig
+	if withdrawArgs.Request, err = cl.userRequest("OpencxRPC.Withdraw", withdrawArgs.ArgsHash()); err != nil {
+		return
+	}
 
 	if err = cl.Call("OpencxRPC.Withdraw", withdrawArgs, withdrawReply); err != nil {
 		return
@@ -158,16 +135,9 @@ func (cl *BenchClient) WithdrawLightning(amount uint64, asset match.Asset) (with
 		},
 	}
 
-	// create e = hash(m)
-	sha3 := sha3.New256()
-	sha3.Write(withdrawArgs.Withdrawal.Serialize())
-	e := sha3.Sum(nil)
-
-	// Sign order
-	compactSig, err := koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false)
-
-	// set signature in args
-	withdrawArgs.Signature = compactSig
+	if withdrawArgs.Request, err = cl.userRequest("OpencxRPC.Withdraw", withdrawArgs.ArgsHash()); err != nil {
+		return
+	}
 
 	if err = cl.Call("OpencxRPC.Withdraw", withdrawArgs, withdrawReply); err != nil {
 		return
@@ -11,6 +11,8 @@ type BenchClient struct {
 	port      uint16
 	RPCClient cxrpc.OpencxClient
 	PrivKey   *koblitz.PrivateKey
+	// ServerPubKey pins the server's pubkey for noise clients, if set
+	ServerPubKey *koblitz.PublicKey
 }
 
 // SetupBenchClient creates a new BenchClient for use as an RPC Client
@@ -36,6 +38,13 @@ func (cl *BenchClient) SetupBenchNoiseClient(server string, port uint16) (err er
 		return
 	}
 
+	// If we know the server's pubkey, only connect to a server that has it
+	if cl.ServerPubKey != nil {
+		if err = noiseClient.SetServerPubkey(cl.ServerPubKey); err != nil {
+			return
+		}
+	}
+
 	// Now that the key is set we can start doing stuff.
 	cl.RPCClient = noiseClient
 	cl.hostname = server
@@ -49,6 +58,13 @@ func (cl *BenchClient) SetupBenchNoiseClient(server string, port uint16) (err er
 	return
 }
 
+// UsingNoise returns true if the client is connected over noise. The server already knows who a
+// noise client is, so commands don't need to be signed.
+func (cl *BenchClient) UsingNoise() (usingNoise bool) {
+	_, usingNoise = cl.RPCClient.(*cxrpc.OpencxNoiseClient)
+	return
+}
+
 // Call calls a method from the rpc client
 func (cl *BenchClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
 	return cl.RPCClient.Call(serviceMethod, args, reply)
@@ -0,0 +1,47 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// GetLiabilityRoot gets the latest published liability root for an asset
+func (cl *BenchClient) GetLiabilityRoot(asset string) (getLiabilityRootReply *cxrpc.GetLiabilityRootReply, err error) {
+	getLiabilityRootReply = new(cxrpc.GetLiabilityRootReply)
+	getLiabilityRootArgs := &cxrpc.GetLiabilityRootArgs{
+		Asset: asset,
+	}
+
+	if err = cl.Call("OpencxRPC.GetLiabilityRoot", getLiabilityRootArgs, getLiabilityRootReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetLiabilityProof gets the proof that our balance is included in the latest published liability
+// root for an asset
+func (cl *BenchClient) GetLiabilityProof(asset string) (getLiabilityProofReply *cxrpc.GetLiabilityProofReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getLiabilityProofReply = new(cxrpc.GetLiabilityProofReply)
+	getLiabilityProofArgs := &cxrpc.GetLiabilityProofArgs{
+		Asset: asset,
+	}
+
+	if getLiabilityProofArgs.Request, err = cl.userRequest("OpencxRPC.GetLiabilityProof", getLiabilityProofArgs.ArgsHash()); err != nil {
+		err = match.Wrapf(err, "Error signing liability proof request: %s", err)
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetLiabilityProof", getLiabilityProofArgs, getLiabilityProofReply); err != nil {
+		return
+	}
+
+	return
+}
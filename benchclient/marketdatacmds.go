@@ -0,0 +1,49 @@
+package benchclient
+
+import (
+	"time"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SubscribeMarketData calls the SubscribeMarketData rpc command, getting the orderbook snapshot to
+// start polling for market data from
+func (cl *BenchClient) SubscribeMarketData(assetPair string) (subscribeReply *cxrpc.SubscribeMarketDataReply, err error) {
+	subscribeReply = new(cxrpc.SubscribeMarketDataReply)
+	subscribeArgs := &cxrpc.SubscribeMarketDataArgs{
+		TradingPair: new(match.Pair),
+	}
+
+	if err = subscribeArgs.TradingPair.FromString(assetPair); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.SubscribeMarketData", subscribeArgs, subscribeReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// PollMarketData calls the PollMarketData rpc command, waiting up to wait for market events after
+// afterSeq in the epoch from the snapshot
+func (cl *BenchClient) PollMarketData(assetPair string, epoch uint64, afterSeq uint64, wait time.Duration) (pollReply *cxrpc.PollMarketDataReply, err error) {
+	pollReply = new(cxrpc.PollMarketDataReply)
+	pollArgs := &cxrpc.PollMarketDataArgs{
+		TradingPair: new(match.Pair),
+		Epoch:       epoch,
+		AfterSeq:    afterSeq,
+		Wait:        wait,
+	}
+
+	if err = pollArgs.TradingPair.FromString(assetPair); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.PollMarketData", pollArgs, pollReply); err != nil {
+		return
+	}
+
+	return
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"golang.org/x/crypto/sha3"
 
+	"github.com/mit-dci/opencx/crypto/threshold"
 	"github.com/mit-dci/opencx/cxauctionrpc"
 	"github.com/mit-dci/opencx/cxrpc"
 	"github.com/mit-dci/opencx/logging"
@@ -59,28 +60,19 @@ func (cl *BenchClient) OrderAsync(pubkey *koblitz.PublicKey, side match.Side, pa
 		newOrder.AmountHave = amountHave
 		newOrder.AmountWant = uint64(price * float64(amountHave))
 
-		var newOrderBytes []byte
-		if newOrderBytes, err = newOrder.Serialize(); err != nil {
-			err = fmt.Errorf("Error serializing new order: %s", err)
+		orderArgs.Order = &newOrder
+
+		var argsHash [32]byte
+		if argsHash, err = orderArgs.ArgsHash(); err != nil {
 			return
 		}
 
-		// create e = hash(m)
-		sha3 := sha3.New256()
-		sha3.Write(newOrderBytes)
-		e := sha3.Sum(nil)
-
-		// Sign order
-		var compactSig []byte
-		if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		if orderArgs.Request, err = cl.userRequest("OpencxRPC.SubmitOrder", argsHash); err != nil {
 			return
 		}
 
-		orderArgs.Signature = compactSig
-		orderArgs.Order = &newOrder
-
 		if err = cl.Call("OpencxRPC.SubmitOrder", orderArgs, orderReply); err != nil {
-			err = fmt.Errorf("Error calling 'SubmitOrder' service method:\n%s", err)
+			err = match.Wrapf(err, "Error calling 'SubmitOrder' service method:\n%s", err)
 			return
 		}
 
@@ -144,15 +136,9 @@ func (cl *BenchClient) CancelOrder(orderID string) (cancelOrderReply *cxrpc.Canc
 		OrderID: orderID,
 	}
 
-	// create e = hash(m)
-	sha3 := sha3.New256()
-	sha3.Write([]byte(cancelOrderArgs.OrderID))
-	e := sha3.Sum(nil)
-
-	// Sign order
-	compactSig, err := koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false)
-
-	cancelOrderArgs.Signature = compactSig
+	if cancelOrderArgs.Request, err = cl.userRequest("OpencxRPC.CancelOrder", cancelOrderArgs.ArgsHash()); err != nil {
+		return
+	}
 
 	// Actually use the RPC Client to call the method
 	if err = cl.Call("OpencxRPC.CancelOrder", cancelOrderArgs, cancelOrderReply); err != nil {
@@ -174,11 +160,16 @@ func (cl *BenchClient) GetPairs() (getPairsReply *cxrpc.GetPairsReply, err error
 	return
 }
 
-// AuctionOrderCommand submits an order synchronously. Uses asynchronous order function
+// AuctionOrderCommand submits an order synchronously, encrypted with the default scheme. Uses asynchronous order function
 func (cl *BenchClient) AuctionOrderCommand(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, t uint64, auctionID [32]byte) (reply *cxauctionrpc.SubmitPuzzledOrderReply, err error) {
+	return cl.AuctionOrderCommandWithScheme(pubkey, side, pair, amountHave, price, t, auctionID, match.DefaultEncryptionScheme)
+}
+
+// AuctionOrderCommandWithScheme submits an order synchronously, encrypted with the scheme. Uses asynchronous order function
+func (cl *BenchClient) AuctionOrderCommandWithScheme(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, t uint64, auctionID [32]byte, scheme match.EncryptionScheme) (reply *cxauctionrpc.SubmitPuzzledOrderReply, err error) {
 	errorChannel := make(chan error, 1)
 	replyChannel := make(chan *cxauctionrpc.SubmitPuzzledOrderReply, 1)
-	go cl.AuctionOrderAsync(pubkey, side, pair, amountHave, price, t, auctionID, replyChannel, errorChannel)
+	go cl.AuctionOrderAsyncWithScheme(pubkey, side, pair, amountHave, price, t, auctionID, scheme, replyChannel, errorChannel)
 	// wait on either the reply or error, whichever comes first. If error is nil wait for reply. That's why the for loop is there. We don't care if the reply is nil, it shouldn't be, but that's sort of just so go-vet doesn't yell at us for having an unreachable return.
 	for reply == nil {
 		select {
@@ -194,8 +185,53 @@ func (cl *BenchClient) AuctionOrderCommand(pubkey *koblitz.PublicKey, side strin
 	return
 }
 
-// AuctionOrderAsync is supposed to be run in a separate goroutine, AuctionOrderCommand makes this synchronous however
+// AuctionOrderAsync is supposed to be run in a separate goroutine, AuctionOrderCommand makes this synchronous however.
+// The order is encrypted with the default scheme.
 func (cl *BenchClient) AuctionOrderAsync(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, t uint64, auctionID [32]byte, replyChan chan *cxauctionrpc.SubmitPuzzledOrderReply, errChan chan error) {
+	cl.AuctionOrderAsyncWithScheme(pubkey, side, pair, amountHave, price, t, auctionID, match.DefaultEncryptionScheme, replyChan, errChan)
+	return
+}
+
+// AuctionOrderAsyncWithScheme is the same as AuctionOrderAsync, but the order is encrypted with the scheme.
+func (cl *BenchClient) AuctionOrderAsyncWithScheme(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, t uint64, auctionID [32]byte, scheme match.EncryptionScheme, replyChan chan *cxauctionrpc.SubmitPuzzledOrderReply, errChan chan error) {
+	logging.Infof("Order time: %d", t)
+	cl.auctionOrderAsync(pubkey, side, pair, amountHave, price, auctionID, func(order *match.AuctionOrder) (*match.EncryptedAuctionOrder, error) {
+		return order.TurnIntoEncryptedOrderWithScheme(t, scheme)
+	}, replyChan, errChan)
+	return
+}
+
+// AuctionOrderCommandToCommittee submits an order synchronously, encrypted to the exchange's
+// decryption committee with the scheme. Uses asynchronous order function
+func (cl *BenchClient) AuctionOrderCommandToCommittee(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, auctionID [32]byte, scheme match.EncryptionScheme, committee *threshold.CommitteeKey) (reply *cxauctionrpc.SubmitPuzzledOrderReply, err error) {
+	errorChannel := make(chan error, 1)
+	replyChannel := make(chan *cxauctionrpc.SubmitPuzzledOrderReply, 1)
+	go cl.AuctionOrderAsyncToCommittee(pubkey, side, pair, amountHave, price, auctionID, scheme, committee, replyChannel, errorChannel)
+	for reply == nil {
+		select {
+		case reply = <-replyChannel:
+			return
+		case err = <-errorChannel:
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// AuctionOrderAsyncToCommittee is the same as AuctionOrderAsync, but the order is encrypted to the
+// decryption committee with the scheme.
+func (cl *BenchClient) AuctionOrderAsyncToCommittee(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, auctionID [32]byte, scheme match.EncryptionScheme, committee *threshold.CommitteeKey, replyChan chan *cxauctionrpc.SubmitPuzzledOrderReply, errChan chan error) {
+	cl.auctionOrderAsync(pubkey, side, pair, amountHave, price, auctionID, func(order *match.AuctionOrder) (*match.EncryptedAuctionOrder, error) {
+		return order.TurnIntoCommitteeEncryptedOrder(scheme, committee)
+	}, replyChan, errChan)
+	return
+}
+
+// auctionOrderAsync creates and signs an auction order, encrypts it with encrypt, and submits it
+func (cl *BenchClient) auctionOrderAsync(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, auctionID [32]byte, encrypt func(order *match.AuctionOrder) (*match.EncryptedAuctionOrder, error), replyChan chan *cxauctionrpc.SubmitPuzzledOrderReply, errChan chan error) {
 
 	if cl.PrivKey == nil {
 		errChan <- fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
@@ -207,45 +243,13 @@ func (cl *BenchClient) AuctionOrderAsync(pubkey *koblitz.PublicKey, side string,
 		orderArgs := new(cxauctionrpc.SubmitPuzzledOrderArgs)
 		orderReply := new(cxauctionrpc.SubmitPuzzledOrderReply)
 
-		var newAuctionOrder match.AuctionOrder
-		copy(newAuctionOrder.Pubkey[:], pubkey.SerializeCompressed())
-		newAuctionOrder.Side = side
-
-		// check that the sides are correct
-		if newAuctionOrder.Side != "buy" && newAuctionOrder.Side != "sell" {
-			err = fmt.Errorf("AuctionOrder's side isn't buy or sell, try again")
-			return
-		}
-
-		// get the trading pair string from the shell input - third parameter
-		if err = newAuctionOrder.TradingPair.FromString(pair); err != nil {
-			err = fmt.Errorf("Error getting asset pair from string: \n%s", err)
-			return
-		}
-
-		logging.Infof("client trading pair: %s", newAuctionOrder.TradingPair.PrettyString())
-
-		newAuctionOrder.AmountHave = amountHave
-		newAuctionOrder.AuctionID = auctionID
-
-		newAuctionOrder.SetAmountWant(price)
-
-		// create e = hash(m)
-		sha3 := sha3.New256()
-		sha3.Write(newAuctionOrder.SerializeSignable())
-		e := sha3.Sum(nil)
-
-		// Sign order
-		var compactSig []byte
-		if compactSig, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		var newAuctionOrder *match.AuctionOrder
+		if newAuctionOrder, err = cl.signedAuctionOrder(pubkey, side, pair, amountHave, price, auctionID); err != nil {
 			return
 		}
 
-		logging.Infof("Order time: %d", t)
-
-		newAuctionOrder.Signature = compactSig
 		var order *match.EncryptedAuctionOrder
-		if order, err = newAuctionOrder.TurnIntoEncryptedOrder(t); err != nil {
+		if order, err = encrypt(newAuctionOrder); err != nil {
 			err = fmt.Errorf("Error turning order into puzzle before submitting: %s", err)
 			return
 		}
@@ -256,7 +260,7 @@ func (cl *BenchClient) AuctionOrderAsync(pubkey *koblitz.PublicKey, side string,
 		}
 
 		if err = cl.Call("OpencxAuctionRPC.SubmitPuzzledOrder", orderArgs, orderReply); err != nil {
-			err = fmt.Errorf("Error calling 'SubmitPuzzledOrder' service method:\n%s", err)
+			err = match.Wrapf(err, "Error calling 'SubmitPuzzledOrder' service method:\n%s", err)
 			return
 		}
 
@@ -267,3 +271,96 @@ func (cl *BenchClient) AuctionOrderAsync(pubkey *koblitz.PublicKey, side string,
 
 	return
 }
+
+// signedAuctionOrder creates an auction order and signs it with the client's key
+func (cl *BenchClient) signedAuctionOrder(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, auctionID [32]byte) (newAuctionOrder *match.AuctionOrder, err error) {
+	newAuctionOrder = new(match.AuctionOrder)
+	copy(newAuctionOrder.Pubkey[:], pubkey.SerializeCompressed())
+	newAuctionOrder.Side = side
+
+	// check that the sides are correct
+	if newAuctionOrder.Side != "buy" && newAuctionOrder.Side != "sell" {
+		err = fmt.Errorf("AuctionOrder's side isn't buy or sell, try again")
+		return
+	}
+
+	// get the trading pair string from the shell input - third parameter
+	if err = newAuctionOrder.TradingPair.FromString(pair); err != nil {
+		err = fmt.Errorf("Error getting asset pair from string: \n%s", err)
+		return
+	}
+
+	logging.Infof("client trading pair: %s", newAuctionOrder.TradingPair.PrettyString())
+
+	newAuctionOrder.AmountHave = amountHave
+	newAuctionOrder.AuctionID = auctionID
+
+	newAuctionOrder.SetAmountWant(price)
+
+	// create e = hash(m)
+	sha3 := sha3.New256()
+	sha3.Write(newAuctionOrder.SerializeSignable())
+	e := sha3.Sum(nil)
+
+	// Sign order
+	if newAuctionOrder.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		return
+	}
+
+	return
+}
+
+// AuctionOrderCommitCommand commits to an order for a commit-reveal auction, putting up the bond
+// for it. The reveal has to be sent with RevealAuctionOrderCommand once the auction is over, or the
+// bond is lost.
+func (cl *BenchClient) AuctionOrderCommitCommand(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, auctionID [32]byte) (reveal *match.OrderReveal, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	var order *match.AuctionOrder
+	if order, err = cl.signedAuctionOrder(pubkey, side, pair, amountHave, price, auctionID); err != nil {
+		return
+	}
+
+	var commitment *match.OrderCommitment
+	if commitment, reveal, err = order.TurnIntoCommitment(); err != nil {
+		err = fmt.Errorf("Error committing to order: %s", err)
+		return
+	}
+
+	sha3 := sha3.New256()
+	sha3.Write(commitment.SerializeSignable())
+	e := sha3.Sum(nil)
+
+	if commitment.Signature, err = koblitz.SignCompact(koblitz.S256(), cl.PrivKey, e, false); err != nil {
+		err = fmt.Errorf("Error signing order commitment: %s", err)
+		return
+	}
+
+	commitArgs := &cxauctionrpc.SubmitOrderCommitmentArgs{
+		Commitment: *commitment,
+	}
+	commitReply := new(cxauctionrpc.SubmitOrderCommitmentReply)
+	if err = cl.Call("OpencxAuctionRPC.SubmitOrderCommitment", commitArgs, commitReply); err != nil {
+		err = match.Wrapf(err, "Error calling 'SubmitOrderCommitment' service method:\n%s", err)
+		return
+	}
+
+	return
+}
+
+// RevealAuctionOrderCommand reveals an order committed to with AuctionOrderCommitCommand
+func (cl *BenchClient) RevealAuctionOrderCommand(reveal *match.OrderReveal) (err error) {
+	revealArgs := &cxauctionrpc.RevealOrderArgs{
+		Reveal: *reveal,
+	}
+	revealReply := new(cxauctionrpc.RevealOrderReply)
+	if err = cl.Call("OpencxAuctionRPC.RevealOrder", revealArgs, revealReply); err != nil {
+		err = match.Wrapf(err, "Error calling 'RevealOrder' service method:\n%s", err)
+		return
+	}
+
+	return
+}
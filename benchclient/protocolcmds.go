@@ -19,3 +19,18 @@ func (cl *BenchClient) GetPublicParameters(pair *match.Pair) (getPublicParameter
 
 	return
 }
+
+// GetBatchProofs returns the proofs that every order in the batch for an auction was decrypted
+// correctly
+func (cl *BenchClient) GetBatchProofs(auctionID [32]byte) (getBatchProofsReply *cxauctionrpc.GetBatchProofsReply, err error) {
+	getBatchProofsReply = new(cxauctionrpc.GetBatchProofsReply)
+	getBatchProofsArgs := &cxauctionrpc.GetBatchProofsArgs{
+		AuctionID: auctionID,
+	}
+
+	if err = cl.Call("OpencxAuctionRPC.GetBatchProofs", getBatchProofsArgs, getBatchProofsReply); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,72 @@
+package benchclient
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/opencx/cxauctionrpc"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// getNonce asks a service for a nonce to sign one request with
+func (cl *BenchClient) getNonce(service string) (nonce [32]byte, expiry time.Time, err error) {
+	var owner [33]byte
+	copy(owner[:], cl.PrivKey.PubKey().SerializeCompressed())
+
+	switch service {
+	case "OpencxAuctionRPC":
+		getNonceReply := new(cxauctionrpc.GetNonceReply)
+		if err = cl.Call("OpencxAuctionRPC.GetNonce", &cxauctionrpc.GetNonceArgs{Pubkey: owner}, getNonceReply); err != nil {
+			return
+		}
+		nonce, expiry = getNonceReply.Nonce, getNonceReply.Expiry
+	case "OpencxRPC":
+		getNonceReply := new(cxrpc.GetNonceReply)
+		if err = cl.Call("OpencxRPC.GetNonce", &cxrpc.GetNonceArgs{Pubkey: owner}, getNonceReply); err != nil {
+			return
+		}
+		nonce, expiry = getNonceReply.Nonce, getNonceReply.Expiry
+	default:
+		err = fmt.Errorf("Unknown service %s, cannot get nonce", service)
+	}
+	return
+}
+
+// signRequest gets a nonce from the service the method belongs to, and signs a request for the
+// method and args hash with it. The request expires when the nonce does.
+func (cl *BenchClient) signRequest(method string, argsHash [32]byte) (req *cxauth.SignedRequest, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	var nonce [32]byte
+	var expiry time.Time
+	if nonce, expiry, err = cl.getNonce(strings.SplitN(method, ".", 2)[0]); err != nil {
+		err = match.Wrapf(err, "Error getting nonce for %s: %s", method, err)
+		return
+	}
+
+	if req, err = cxauth.SignRequest(cl.PrivKey, method, argsHash, nonce, expiry); err != nil {
+		return
+	}
+
+	return
+}
+
+// userRequest signs a request for a user command. Over noise the session already proves who we
+// are, so there's nothing to sign and the request is nil.
+func (cl *BenchClient) userRequest(method string, argsHash [32]byte) (req *cxauth.SignedRequest, err error) {
+	if cl.UsingNoise() {
+		return
+	}
+
+	if req, err = cl.signRequest(method, argsHash); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,68 @@
+package benchclient
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/match"
+)
+
+// ProveSolvency asks the exchange to prove solvency for an asset, the client's key must be the
+// admin key
+func (cl *BenchClient) ProveSolvency(asset string, anonKeys []cxrpc.AnonKey) (proveSolvencyReply *cxrpc.ProveSolvencyReply, err error) {
+	proveSolvencyReply = new(cxrpc.ProveSolvencyReply)
+	proveSolvencyArgs := &cxrpc.ProveSolvencyArgs{
+		Asset:    asset,
+		AnonKeys: anonKeys,
+	}
+
+	if proveSolvencyArgs.Request, err = cl.signRequest("OpencxRPC.ProveSolvency", proveSolvencyArgs.ArgsHash()); err != nil {
+		err = match.Wrapf(err, "Error signing admin command: %s", err)
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.ProveSolvency", proveSolvencyArgs, proveSolvencyReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetSolvencyProof gets the latest proof of solvency for an asset
+func (cl *BenchClient) GetSolvencyProof(asset string) (getSolvencyProofReply *cxrpc.GetSolvencyProofReply, err error) {
+	getSolvencyProofReply = new(cxrpc.GetSolvencyProofReply)
+	getSolvencyProofArgs := &cxrpc.GetSolvencyProofArgs{
+		Asset: asset,
+	}
+
+	if err = cl.Call("OpencxRPC.GetSolvencyProof", getSolvencyProofArgs, getSolvencyProofReply); err != nil {
+		return
+	}
+
+	return
+}
+
+// GetSolvencyOpening gets the opening of our balance commitment in the latest proof of solvency
+// for an asset
+func (cl *BenchClient) GetSolvencyOpening(asset string) (getSolvencyOpeningReply *cxrpc.GetSolvencyOpeningReply, err error) {
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	getSolvencyOpeningReply = new(cxrpc.GetSolvencyOpeningReply)
+	getSolvencyOpeningArgs := &cxrpc.GetSolvencyOpeningArgs{
+		Asset: asset,
+	}
+
+	if getSolvencyOpeningArgs.Request, err = cl.userRequest("OpencxRPC.GetSolvencyOpening", getSolvencyOpeningArgs.ArgsHash()); err != nil {
+		err = match.Wrapf(err, "Error signing solvency opening request: %s", err)
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.GetSolvencyOpening", getSolvencyOpeningArgs, getSolvencyOpeningReply); err != nil {
+		return
+	}
+
+	return
+}
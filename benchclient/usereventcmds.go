@@ -0,0 +1,35 @@
+package benchclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/cxrpc"
+)
+
+// PollUserEvents calls the PollUserEvents rpc command, waiting up to wait for events on our orders
+// and balances after afterSeq in afterEpoch
+func (cl *BenchClient) PollUserEvents(afterEpoch uint64, afterSeq uint64, wait time.Duration) (pollReply *cxrpc.PollUserEventsReply, err error) {
+
+	if cl.PrivKey == nil {
+		err = fmt.Errorf("Private key nonexistent, set or specify private key so the client can sign commands")
+		return
+	}
+
+	pollReply = new(cxrpc.PollUserEventsReply)
+	pollArgs := &cxrpc.PollUserEventsArgs{
+		AfterEpoch: afterEpoch,
+		AfterSeq:   afterSeq,
+		Wait:       wait,
+	}
+
+	if pollArgs.Request, err = cl.userRequest("OpencxRPC.PollUserEvents", pollArgs.ArgsHash()); err != nil {
+		return
+	}
+
+	if err = cl.Call("OpencxRPC.PollUserEvents", pollArgs, pollReply); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/hex"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxbatcherrpc"
+	"github.com/mit-dci/opencx/logging"
+)
+
+type batcherdConfig struct {
+	ConfigFile string
+
+	// stuff for files and directories
+	LogFilename     string `long:"logFilename" description:"Filename for output log file"`
+	BatcherdHomeDir string `long:"dir" description:"Location of the root directory relative to home directory"`
+
+	// stuff for ports
+	Rpcport uint16 `short:"p" long:"rpcport" description:"Set RPC port to listen on"`
+	Rpchost string `long:"rpchost" description:"Set RPC host to listen to"`
+
+	// logging and debug parameters
+	LogLevel []bool `short:"v" description:"Set verbosity level to verbose (-v), very verbose (-vv) or very very verbose (-vvv)"`
+
+	// filename for key
+	KeyFileName string `long:"keyfilename" short:"k" description:"Filename for private key within root batcherd directory used for the noise handshake"`
+
+	// Batcher options
+	MaxBatchSize   uint64   `long:"maxbatchsize" description:"Maximum number of orders that can go in a batch"`
+	SolverWorkers  uint64   `long:"solverworkers" description:"Number of puzzles that can be solved at once, defaults to the number of CPUs"`
+	SolverQueue    uint64   `long:"solverqueue" description:"Maximum number of puzzles waiting to be solved before new orders are rejected"`
+	AuthorizedKeys []string `long:"authorizedkey" description:"Hex encoded compressed pubkey of an exchange allowed to use this batcher, can be specified multiple times"`
+}
+
+var (
+	defaultHomeDir = os.Getenv("HOME")
+
+	// used as defaults before putting into parser
+	defaultBatcherdHomeDirName = defaultHomeDir + "/.opencx/batcherd/"
+	defaultRpcport             = uint16(12347)
+	defaultRpchost             = "localhost"
+
+	// default batcher options
+	defaultMaxBatchSize = uint64(1000)
+	defaultSolverQueue  = uint64(10000)
+)
+
+// newConfigParser returns a new command line flags parser.
+func newConfigParser(conf *batcherdConfig, options flags.Options) *flags.Parser {
+	parser := flags.NewParser(conf, options)
+	return parser
+}
+
+func main() {
+	var err error
+
+	conf := batcherdConfig{
+		BatcherdHomeDir: defaultBatcherdHomeDirName,
+		LogFilename:     defaultLogFilename,
+		Rpcport:         defaultRpcport,
+		Rpchost:         defaultRpchost,
+		MaxBatchSize:    defaultMaxBatchSize,
+		SolverWorkers:   uint64(runtime.NumCPU()),
+		SolverQueue:     defaultSolverQueue,
+	}
+
+	// Check and load config params
+	key := batcherdSetup(&conf)
+
+	var authorizedKeys []*koblitz.PublicKey
+	for _, keyStr := range conf.AuthorizedKeys {
+		var keyBytes []byte
+		if keyBytes, err = hex.DecodeString(keyStr); err != nil {
+			logging.Fatalf("Error decoding authorized key %s: %s", keyStr, err)
+		}
+
+		var authKey *koblitz.PublicKey
+		if authKey, err = koblitz.ParsePubKey(keyBytes, koblitz.S256()); err != nil {
+			logging.Fatalf("Error parsing authorized key %s: %s", keyStr, err)
+		}
+		authorizedKeys = append(authorizedKeys, authKey)
+	}
+
+	var solverPool *cxauctionserver.SolverPool
+	if solverPool, err = cxauctionserver.NewSolverPool(conf.SolverWorkers, conf.SolverQueue); err != nil {
+		logging.Fatalf("Error creating solver pool for batcher: %s", err)
+	}
+
+	var rpcListener *cxbatcherrpc.BatcherRPCCaller
+	if rpcListener, err = cxbatcherrpc.CreateRPCForBatcher(conf.MaxBatchSize, solverPool, authorizedKeys); err != nil {
+		logging.Fatalf("Error creating rpc caller for batcher: %s", err)
+	}
+
+	// SIGINT and SIGTERM and SIGQUIT handler for CTRL-c, KILL, CTRL-/, etc.
+	go func() {
+		logging.Infof("Notifying signals")
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGQUIT)
+		signal.Notify(sigs, syscall.SIGTERM)
+		signal.Notify(sigs, syscall.SIGINT)
+		for {
+			signal := <-sigs
+			logging.Infof("Received %s signal, Stopping batcher gracefully...", signal.String())
+
+			if err = rpcListener.Stop(); err != nil {
+				logging.Fatalf("Error stopping batcher: %s", err)
+			}
+			solverPool.Stop()
+
+			return
+		}
+	}()
+
+	privkey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), key[:])
+	logging.Infof(" === will start to listen on noise-rpc ===")
+	if err = rpcListener.NoiseListen(privkey, conf.Rpchost, conf.Rpcport); err != nil {
+		logging.Fatalf("Error listening for noise rpc for batcher: %s", err)
+	}
+
+	// wait until the listener dies
+	rpcListener.WaitUntilDead()
+
+	return
+}
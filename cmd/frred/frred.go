@@ -1,19 +1,30 @@
 package main
 
 import (
+	"encoding/hex"
+	"io/ioutil"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 
 	flags "github.com/jessevdk/go-flags"
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/crypto/threshold"
 	"github.com/mit-dci/opencx/cxauctionrpc"
 	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxbatcherrpc"
 	"github.com/mit-dci/opencx/cxdb"
 	"github.com/mit-dci/opencx/cxdb/cxdbsql"
+	"github.com/mit-dci/opencx/cxkeyholderrpc"
+	"github.com/mit-dci/opencx/cxlimit"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
@@ -29,6 +40,9 @@ type frredConfig struct {
 	Rpcport uint16 `short:"p" long:"rpcport" description:"Set RPC port to connect to"`
 	Rpchost string `long:"rpchost" description:"Set RPC host to listen to"`
 
+	// JSON-RPC gateway for web clients
+	JSONRPCPort uint16 `long:"jsonrpcport" description:"Set port to serve JSON-RPC over HTTP and WebSocket on, 0 to not serve it"`
+
 	// logging and debug parameters
 	LogLevel []bool `short:"v" description:"Set verbosity level to verbose (-v), very verbose (-vv) or very very verbose (-vvv)"`
 
@@ -67,6 +81,52 @@ type frredConfig struct {
 	// Auction server options
 	AuctionTime  uint64 `long:"auctiontime" description:"Time it should take to generate a timelock puzzle protected order"`
 	MaxBatchSize uint64 `long:"maxbatchsize" description:"Maximum number of orders that can go in a batch"`
+
+	// Calibration options, to derive auctiontime from a real amount of time
+	TargetAuctionDuration time.Duration `long:"targetauctionduration" description:"How long nobody should be able to solve puzzles for, if set auctiontime is derived from it by calibrating this machine"`
+	SafetyFactor          float64       `long:"safetyfactor" description:"How many times faster than this machine we assume anyone could solve puzzles, when deriving auctiontime"`
+	CalibrationTime       time.Duration `long:"calibrationtime" description:"How long to measure squarings per second for, when deriving auctiontime"`
+
+	// Allowed timelock and cipher combinations, like rsw2048a2-rc5
+	AllowedSchemes []string `long:"allowscheme" description:"Timelock and cipher combination orders can be encrypted with, like rsw2048a2-aes, can be specified multiple times"`
+
+	// Solver pool options
+	SolverWorkers uint64 `long:"solverworkers" description:"Number of puzzles that can be solved at once, defaults to the number of CPUs"`
+	SolverQueue   uint64 `long:"solverqueue" description:"Maximum number of puzzles waiting to be solved before new orders are rejected"`
+
+	// Auction schedule options
+	AuctionDuration   time.Duration `long:"auctionduration" description:"How long each auction runs before it is committed to, defaults to auctiontime microseconds"`
+	AlignAuctions     bool          `long:"alignauctions" description:"Whether or not auctions should start at multiples of the auction duration since the unix epoch"`
+	AuctionRecovery   string        `long:"auctionrecovery" description:"What to do when committing to an auction fails, skip or retry"`
+	AuctionRetries    uint64        `long:"auctionretries" description:"How many times to retry committing to an auction with auctionrecovery=retry"`
+	AuctionRetryDelay time.Duration `long:"auctionretrydelay" description:"How long to wait between retries with auctionrecovery=retry"`
+
+	// Decryption committee options, either key holder daemons or key holders in this process for testing
+	KeyHolders         []string `long:"keyholder" description:"Address of a key holder daemon, like host:port, to encrypt orders to their committee instead of timelocking them, can be specified multiple times"`
+	CommitteeFile      string   `long:"committeefile" description:"Filename for the hex encoded committee key within root frred directory, dealt by keyholderd"`
+	CommitteeSize      int      `long:"committeesize" description:"Run a decryption committee with this many local key holders instead of timelocking orders, for testing only"`
+	CommitteeThreshold int      `long:"committeethreshold" description:"How many of the committee's key holders it takes to decrypt orders"`
+
+	// Commit-reveal options, for pairs that take hash commitments instead of timelocked orders
+	CommitRevealPairs []string      `long:"commitreveal" description:"Pair that takes order commitments backed by a bond instead of timelocked orders, like btc/vtc, can be specified multiple times"`
+	BondAsset         string        `long:"bondasset" description:"Asset that commit-reveal bonds are held in"`
+	BondAmount        uint64        `long:"bondamount" description:"Bond held for every order commitment, kept if the order isn't revealed"`
+	RevealWindow      time.Duration `long:"revealwindow" description:"How long after a commit-reveal auction ends orders can be revealed"`
+
+	// Remote batcher options
+	RemoteBatcher bool   `long:"remotebatcher" description:"Whether or not to solve puzzles on a remote batcher daemon instead of in process"`
+	BatcherHost   string `long:"batcherhost" description:"Host of the remote batcher daemon"`
+	BatcherPort   uint16 `long:"batcherport" description:"Port of the remote batcher daemon"`
+
+	// rate limits for RPC clients, by address
+	RateLimit          float64 `long:"ratelimit" description:"Requests a second each address can make, 0 to not limit them"`
+	RateBurst          float64 `long:"rateburst" description:"Requests each address can make at once"`
+	ExpensiveRateLimit float64 `long:"expensiveratelimit" description:"Orders, commitments and reveals a second each address can make, 0 to not limit them"`
+	ExpensiveRateBurst float64 `long:"expensiverateburst" description:"Orders, commitments and reveals each address can make at once"`
+
+	// Shutdown options
+	ShutdownTimeout time.Duration `long:"shutdowntimeout" description:"How long to wait for requests and auctions to finish when shutting down, before exiting anyway"`
+	FinishAuctions  bool          `long:"finishauctions" description:"Whether to end the active auctions and wait for their results when shutting down, instead of aborting them"`
 }
 
 var (
@@ -90,6 +150,37 @@ var (
 	// default auction options
 	defaultAuctionTime  = uint64(30000)
 	defaultMaxBatchSize = uint64(1000)
+
+	// default calibration options
+	defaultSafetyFactor    = cxauctionserver.DefaultSafetyFactor
+	defaultCalibrationTime = 2 * time.Second
+
+	// default solver pool options
+	defaultSolverQueue = uint64(10000)
+
+	// default auction schedule options
+	defaultAuctionRecovery   = "skip"
+	defaultAuctionRetries    = uint64(3)
+	defaultAuctionRetryDelay = time.Second
+
+	// default commit-reveal options
+	defaultRevealWindow = 30 * time.Second
+
+	// default committee options
+	defaultCommitteeFile = "committee.hex"
+
+	// default remote batcher options
+	defaultBatcherHost = "localhost"
+	defaultBatcherPort = uint16(12347)
+
+	// default rate limits for RPC clients, puzzled orders cost a lot to solve
+	defaultRateLimit          = float64(20)
+	defaultRateBurst          = float64(40)
+	defaultExpensiveRateLimit = float64(1)
+	defaultExpensiveRateBurst = float64(5)
+
+	// default shutdown options, auctions take a while to solve so give them a few minutes
+	defaultShutdownTimeout = 5 * time.Minute
 )
 
 // newConfigParser returns a new command line flags parser.
@@ -102,17 +193,34 @@ func main() {
 	var err error
 
 	conf := frredConfig{
-		FrredHomeDir:     defaultfrredHomeDirName,
-		Rpcport:          defaultRpcport,
-		Rpchost:          defaultRpchost,
-		MaxPeers:         defaultMaxPeers,
-		MinPeerPort:      defaultMinPeerPort,
-		Lithost:          defaultLithost,
-		Litport:          defaultLitport,
-		AuthenticatedRPC: defaultAuthenticatedRPC,
-		LightningSupport: defaultLightningSupport,
-		AuctionTime:      defaultAuctionTime,
-		MaxBatchSize:     defaultMaxBatchSize,
+		FrredHomeDir:      defaultfrredHomeDirName,
+		Rpcport:           defaultRpcport,
+		Rpchost:           defaultRpchost,
+		MaxPeers:          defaultMaxPeers,
+		MinPeerPort:       defaultMinPeerPort,
+		Lithost:           defaultLithost,
+		Litport:           defaultLitport,
+		AuthenticatedRPC:  defaultAuthenticatedRPC,
+		LightningSupport:  defaultLightningSupport,
+		AuctionTime:       defaultAuctionTime,
+		MaxBatchSize:      defaultMaxBatchSize,
+		SafetyFactor:      defaultSafetyFactor,
+		CalibrationTime:   defaultCalibrationTime,
+		SolverWorkers:     uint64(runtime.NumCPU()),
+		SolverQueue:       defaultSolverQueue,
+		AuctionRecovery:   defaultAuctionRecovery,
+		AuctionRetries:    defaultAuctionRetries,
+		AuctionRetryDelay: defaultAuctionRetryDelay,
+		RevealWindow:      defaultRevealWindow,
+		CommitteeFile:     defaultCommitteeFile,
+		BatcherHost:       defaultBatcherHost,
+		BatcherPort:       defaultBatcherPort,
+		ShutdownTimeout:   defaultShutdownTimeout,
+
+		RateLimit:          defaultRateLimit,
+		RateBurst:          defaultRateBurst,
+		ExpensiveRateLimit: defaultExpensiveRateLimit,
+		ExpensiveRateBurst: defaultExpensiveRateBurst,
 	}
 
 	// Check and load config params
@@ -137,6 +245,11 @@ func main() {
 		logging.Fatalf("Error creating settlement engine map: %s", err)
 	}
 
+	var setStores map[*coinparam.Params]cxdb.SettlementStore
+	if setStores, err = cxdbsql.CreateSettlementStoreMap(coinList); err != nil {
+		logging.Fatalf("Error creating settlement store map: %s", err)
+	}
+
 	var auctionBooks map[match.Pair]match.AuctionOrderbook
 	if auctionBooks, err = cxdbsql.CreateAuctionOrderbookMap(pairList); err != nil {
 		logging.Fatalf("Error creating auction orderbook map: %s", err)
@@ -147,17 +260,146 @@ func main() {
 		logging.Fatalf("Error creating puzzle store map: %s", err)
 	}
 
+	// The key that the exchange runs with is also the admin key, and the key we use to
+	// authenticate with a remote batcher
+	privkey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), key[:])
+
 	var batchers map[match.Pair]match.AuctionBatcher
-	if batchers, err = cxauctionserver.CreateAuctionBatcherMap(pairList, conf.MaxBatchSize); err != nil {
-		logging.Fatalf("Error creating batcher map: %s", err)
+	if conf.RemoteBatcher {
+		if batchers, err = cxbatcherrpc.CreateRemoteBatcherMap(pairList, privkey, conf.BatcherHost, conf.BatcherPort); err != nil {
+			logging.Fatalf("Error creating remote batcher map: %s", err)
+		}
+	} else {
+		var solverPool *cxauctionserver.SolverPool
+		if solverPool, err = cxauctionserver.NewSolverPool(conf.SolverWorkers, conf.SolverQueue); err != nil {
+			logging.Fatalf("Error creating solver pool: %s", err)
+		}
+		if batchers, err = cxauctionserver.CreateAuctionBatcherMapWithPool(pairList, conf.MaxBatchSize, solverPool); err != nil {
+			logging.Fatalf("Error creating batcher map: %s", err)
+		}
 	}
 
 	// Anyways, here's where we set the server
 	var frredServer *cxauctionserver.OpencxAuctionServer
-	if frredServer, err = cxauctionserver.InitServer(setEngines, mengines, auctionBooks, puzzleStores, batchers, 100, conf.AuctionTime); err != nil {
+	if frredServer, err = cxauctionserver.InitServer(setEngines, mengines, auctionBooks, puzzleStores, setStores, batchers, 100, conf.AuctionTime); err != nil {
 		logging.Fatalf("Error initializing server: \n%s", err)
 	}
 
+	if err = frredServer.SetAdminPubkey(privkey.PubKey()); err != nil {
+		logging.Fatalf("Error setting admin key: %s", err)
+	}
+
+	if conf.TargetAuctionDuration != 0 {
+		logging.Infof("Calibrating squarings per second for %s", conf.CalibrationTime)
+		var calibration *rsw.Calibration
+		if calibration, err = rsw.Calibrate(conf.CalibrationTime); err != nil {
+			logging.Fatalf("Error calibrating: %s", err)
+		}
+		logging.Infof("Measured %.0f squarings per second with big.Int, %.0f with GMP", calibration.BigSquaringsPerSecond, calibration.GMPSquaringsPerSecond)
+
+		if err = frredServer.SetCalibratedAuctionTime(calibration.SquaringsPerSecond(), conf.TargetAuctionDuration, conf.SafetyFactor); err != nil {
+			logging.Fatalf("Error setting calibrated auction time: %s", err)
+		}
+	}
+
+	if conf.CommitteeSize != 0 && len(conf.KeyHolders) != 0 {
+		logging.Fatalf("Cannot run a local committee and use key holder daemons at the same time, use either committeesize or keyholder")
+	}
+
+	if len(conf.KeyHolders) != 0 {
+		var committeeHex []byte
+		if committeeHex, err = ioutil.ReadFile(filepath.Join(conf.FrredHomeDir, conf.CommitteeFile)); err != nil {
+			logging.Fatalf("Error reading committee key: %s", err)
+		}
+
+		var committeeBytes []byte
+		if committeeBytes, err = hex.DecodeString(strings.TrimSpace(string(committeeHex))); err != nil {
+			logging.Fatalf("Error decoding committee key: %s", err)
+		}
+
+		var committee *threshold.CommitteeKey
+		if committee, err = threshold.DeserializeCommitteeKey(cxauctionserver.CommitteeCurve, committeeBytes); err != nil {
+			logging.Fatalf("Error parsing committee key: %s", err)
+		}
+
+		var holders []cxauctionserver.KeyHolder
+		if holders, err = cxkeyholderrpc.DialKeyHolders(privkey, conf.KeyHolders); err != nil {
+			logging.Fatalf("Error dialing key holders: %s", err)
+		}
+
+		if err = frredServer.UseCommittee(committee, holders, conf.MaxBatchSize, privkey); err != nil {
+			logging.Fatalf("Error putting server in committee mode: %s", err)
+		}
+	}
+
+	if conf.CommitteeSize != 0 {
+		logging.Warnf("Dealing a %d of %d decryption committee in this process, only use this for testing", conf.CommitteeThreshold, conf.CommitteeSize)
+		var committee *threshold.CommitteeKey
+		var holders []cxauctionserver.KeyHolder
+		if committee, holders, err = cxauctionserver.DealLocalCommittee(conf.CommitteeThreshold, conf.CommitteeSize, privkey.PubKey()); err != nil {
+			logging.Fatalf("Error dealing local committee: %s", err)
+		}
+
+		if err = frredServer.UseCommittee(committee, holders, conf.MaxBatchSize, privkey); err != nil {
+			logging.Fatalf("Error putting server in committee mode: %s", err)
+		}
+	}
+
+	if len(conf.AllowedSchemes) != 0 {
+		var allowedSchemes []match.EncryptionScheme
+		for _, schemeStr := range conf.AllowedSchemes {
+			var scheme match.EncryptionScheme
+			if scheme, err = match.EncryptionSchemeFromString(schemeStr); err != nil {
+				logging.Fatalf("Error parsing allowed scheme: %s", err)
+			}
+			allowedSchemes = append(allowedSchemes, scheme)
+		}
+		// In committee mode this only takes committee schemes, so it can't allow timelocked orders
+		// that the committee batchers can't decrypt
+		if err = frredServer.SetAllowedSchemes(allowedSchemes); err != nil {
+			logging.Fatalf("Error setting allowed schemes: %s", err)
+		}
+	}
+
+	if len(conf.CommitRevealPairs) != 0 {
+		params := cxauctionserver.CommitRevealParams{
+			BondAmount:   conf.BondAmount,
+			RevealWindow: conf.RevealWindow,
+		}
+		if params.BondAsset, err = match.AssetFromString(conf.BondAsset); err != nil {
+			logging.Fatalf("Error parsing bond asset: %s", err)
+		}
+
+		for _, pairStr := range conf.CommitRevealPairs {
+			pair := new(match.Pair)
+			if err = pair.FromString(pairStr); err != nil {
+				logging.Fatalf("Error parsing commit-reveal pair: %s", err)
+			}
+			if err = frredServer.SetCommitReveal(pair, params); err != nil {
+				logging.Fatalf("Error setting commit-reveal for pair %s: %s", pair.String(), err)
+			}
+		}
+	}
+
+	// Set up the schedule for every pair before starting the clock
+	schedule := cxauctionserver.DefaultAuctionSchedule(conf.AuctionTime)
+	if conf.AuctionDuration != 0 {
+		schedule.Duration = conf.AuctionDuration
+	} else if conf.TargetAuctionDuration != 0 {
+		schedule.Duration = conf.TargetAuctionDuration
+	}
+	schedule.Aligned = conf.AlignAuctions
+	schedule.MaxRetries = conf.AuctionRetries
+	schedule.RetryDelay = conf.AuctionRetryDelay
+	if schedule.Recovery, err = cxauctionserver.RecoveryPolicyFromString(conf.AuctionRecovery); err != nil {
+		logging.Fatalf("Error parsing auction recovery option: %s", err)
+	}
+	for _, pair := range pairList {
+		if err = frredServer.SetAuctionSchedule(pair, schedule); err != nil {
+			logging.Fatalf("Error setting auction schedule for pair %s: %s", pair.String(), err)
+		}
+	}
+
 	if err = frredServer.StartClockRandomAuction(); err != nil {
 		logging.Fatalf("Error starting clock: %s", err)
 	}
@@ -168,6 +410,15 @@ func main() {
 		logging.Fatalf("Error creating rpc caller for server: %s", err)
 	}
 
+	if err = rpcListener.SetRateLimits(cxlimit.Config{
+		Rate:           conf.RateLimit,
+		Burst:          conf.RateBurst,
+		ExpensiveRate:  conf.ExpensiveRateLimit,
+		ExpensiveBurst: conf.ExpensiveRateBurst,
+	}); err != nil {
+		logging.Fatalf("Error setting rate limits for server: %s", err)
+	}
+
 	// SIGINT and SIGTERM and SIGQUIT handler for CTRL-c, KILL, CTRL-/, etc.
 	go func() {
 		logging.Infof("Notifying signals")
@@ -179,9 +430,22 @@ func main() {
 			signal := <-sigs
 			logging.Infof("Received %s signal, Stopping server gracefully...", signal.String())
 
-			// send off button to off button
-			if err = rpcListener.KillServerNoWait(); err != nil {
-				logging.Fatalf("Error killing server: %s", err)
+			// every stage of shutting down shares one deadline, and we exit anyway if it passes,
+			// or we get another signal
+			deadline := time.Now().Add(conf.ShutdownTimeout)
+			go func() {
+				select {
+				case <-time.After(time.Until(deadline)):
+					logging.Errorf("Shutdown took longer than %s, exiting", conf.ShutdownTimeout)
+				case again := <-sigs:
+					logging.Errorf("Received %s signal again, exiting without waiting", again.String())
+				}
+				os.Exit(1)
+			}()
+
+			// stop taking orders, stop the clock, finish or abort auctions, and close the database
+			if err = rpcListener.Shutdown(deadline, conf.FinishAuctions); err != nil {
+				logging.Fatalf("Error shutting down server: %s", err)
 			}
 
 			return
@@ -195,7 +459,6 @@ func main() {
 			logging.Fatalf("Error listening for rpc for auction serer: %s", err)
 		}
 	} else {
-		privkey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), key[:])
 		// this tells us when the rpclisten is done
 		logging.Infof(" === will start to listen on noise-rpc ===")
 		if err = rpcListener.NoiseListen(privkey, conf.Rpchost, conf.Rpcport); err != nil {
@@ -203,6 +466,13 @@ func main() {
 		}
 	}
 
+	if conf.JSONRPCPort != 0 {
+		logging.Infof(" === will start to listen on json-rpc ===")
+		if err = rpcListener.JSONRPCListen(conf.Rpchost, conf.JSONRPCPort); err != nil {
+			logging.Fatalf("Error listening for json-rpc for auction server: %s", err)
+		}
+	}
+
 	// wait until the listener dies
 	rpcListener.WaitUntilDead()
 
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxkeyholderrpc"
+	"github.com/mit-dci/opencx/logging"
+)
+
+type keyholderdConfig struct {
+	ConfigFile string
+
+	// stuff for files and directories
+	LogFilename       string `long:"logFilename" description:"Filename for output log file"`
+	KeyholderdHomeDir string `long:"dir" description:"Location of the root directory relative to home directory"`
+
+	// stuff for ports
+	Rpcport uint16 `short:"p" long:"rpcport" description:"Set RPC port to listen on"`
+	Rpchost string `long:"rpchost" description:"Set RPC host to listen to"`
+
+	// logging and debug parameters
+	LogLevel []bool `short:"v" description:"Set verbosity level to verbose (-v), very verbose (-vv) or very very verbose (-vvv)"`
+
+	// filename for key
+	KeyFileName string `long:"keyfilename" short:"k" description:"Filename for private key within root keyholderd directory used for the noise handshake"`
+
+	// Committee options
+	CommitteeFile  string   `long:"committeefile" description:"Filename for the hex encoded committee key within root keyholderd directory"`
+	ShareFile      string   `long:"sharefile" description:"Filename for this key holder's hex encoded key share within root keyholderd directory"`
+	AuthorizedKeys []string `long:"authorizedkey" description:"Hex encoded compressed pubkey of an exchange allowed to ask for decryption shares, can be specified multiple times"`
+
+	// Dealing options
+	Deal          int `long:"deal" description:"Deal a committee with this many key holders into the root keyholderd directory and exit"`
+	DealThreshold int `long:"dealthreshold" description:"How many of the dealt committee's key holders it takes to decrypt orders"`
+}
+
+var (
+	defaultHomeDir = os.Getenv("HOME")
+
+	// used as defaults before putting into parser
+	defaultKeyholderdHomeDirName = defaultHomeDir + "/.opencx/keyholderd/"
+	defaultRpcport               = uint16(12348)
+	defaultRpchost               = "localhost"
+
+	// default committee files
+	defaultCommitteeFile = "committee.hex"
+	defaultShareFile     = "share.hex"
+)
+
+// newConfigParser returns a new command line flags parser.
+func newConfigParser(conf *keyholderdConfig, options flags.Options) *flags.Parser {
+	parser := flags.NewParser(conf, options)
+	return parser
+}
+
+// readHexFile reads a hex encoded file
+func readHexFile(path string) (raw []byte, err error) {
+	var hexBytes []byte
+	if hexBytes, err = ioutil.ReadFile(path); err != nil {
+		err = fmt.Errorf("Error reading %s: %s", path, err)
+		return
+	}
+
+	if raw, err = hex.DecodeString(strings.TrimSpace(string(hexBytes))); err != nil {
+		err = fmt.Errorf("Error decoding %s: %s", path, err)
+		return
+	}
+	return
+}
+
+// dealCommittee deals a committee into the directory, writing the committee key and a file for
+// every share. Each share file should be given to its key holder and deleted.
+func dealCommittee(dir string, committeeThreshold int, numHolders int) (err error) {
+	var committee *threshold.CommitteeKey
+	var shares []*threshold.KeyShare
+	if committee, shares, err = threshold.Deal(cxauctionserver.CommitteeCurve, committeeThreshold, numHolders); err != nil {
+		err = fmt.Errorf("Error dealing committee: %s", err)
+		return
+	}
+
+	var raw []byte
+	if raw, err = committee.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing committee key: %s", err)
+		return
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, defaultCommitteeFile), []byte(hex.EncodeToString(raw)), 0644); err != nil {
+		err = fmt.Errorf("Error writing committee key: %s", err)
+		return
+	}
+
+	for _, share := range shares {
+		if raw, err = share.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing key share %d: %s", share.Index, err)
+			return
+		}
+
+		sharePath := filepath.Join(dir, fmt.Sprintf("share%d.hex", share.Index))
+		if err = ioutil.WriteFile(sharePath, []byte(hex.EncodeToString(raw)), 0600); err != nil {
+			err = fmt.Errorf("Error writing key share %d: %s", share.Index, err)
+			return
+		}
+	}
+
+	return
+}
+
+func main() {
+	var err error
+
+	conf := keyholderdConfig{
+		KeyholderdHomeDir: defaultKeyholderdHomeDirName,
+		LogFilename:       defaultLogFilename,
+		Rpcport:           defaultRpcport,
+		Rpchost:           defaultRpchost,
+		CommitteeFile:     defaultCommitteeFile,
+		ShareFile:         defaultShareFile,
+	}
+
+	// Check and load config params
+	key := keyholderdSetup(&conf)
+
+	if conf.Deal != 0 {
+		if err = dealCommittee(conf.KeyholderdHomeDir, conf.DealThreshold, conf.Deal); err != nil {
+			logging.Fatalf("Error dealing committee: %s", err)
+		}
+		logging.Infof("Dealt a %d of %d committee into %s, give each share file to its key holder and delete it", conf.DealThreshold, conf.Deal, conf.KeyholderdHomeDir)
+		return
+	}
+
+	var committeeBytes []byte
+	if committeeBytes, err = readHexFile(filepath.Join(conf.KeyholderdHomeDir, conf.CommitteeFile)); err != nil {
+		logging.Fatalf("Error reading committee key: %s", err)
+	}
+
+	var committee *threshold.CommitteeKey
+	if committee, err = threshold.DeserializeCommitteeKey(cxauctionserver.CommitteeCurve, committeeBytes); err != nil {
+		logging.Fatalf("Error parsing committee key: %s", err)
+	}
+
+	var shareBytes []byte
+	if shareBytes, err = readHexFile(filepath.Join(conf.KeyholderdHomeDir, conf.ShareFile)); err != nil {
+		logging.Fatalf("Error reading key share: %s", err)
+	}
+
+	share := new(threshold.KeyShare)
+	if err = share.Deserialize(shareBytes); err != nil {
+		logging.Fatalf("Error parsing key share: %s", err)
+	}
+
+	var authorizedKeys []*koblitz.PublicKey
+	for _, keyStr := range conf.AuthorizedKeys {
+		var keyBytes []byte
+		if keyBytes, err = hex.DecodeString(keyStr); err != nil {
+			logging.Fatalf("Error decoding authorized key %s: %s", keyStr, err)
+		}
+
+		var authKey *koblitz.PublicKey
+		if authKey, err = koblitz.ParsePubKey(keyBytes, koblitz.S256()); err != nil {
+			logging.Fatalf("Error parsing authorized key %s: %s", keyStr, err)
+		}
+		authorizedKeys = append(authorizedKeys, authKey)
+	}
+
+	var rpcListener *cxkeyholderrpc.KeyHolderRPCCaller
+	if rpcListener, err = cxkeyholderrpc.CreateRPCForKeyHolder(share, committee, authorizedKeys); err != nil {
+		logging.Fatalf("Error creating rpc caller for key holder: %s", err)
+	}
+
+	// SIGINT and SIGTERM and SIGQUIT handler for CTRL-c, KILL, CTRL-/, etc.
+	go func() {
+		logging.Infof("Notifying signals")
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGQUIT)
+		signal.Notify(sigs, syscall.SIGTERM)
+		signal.Notify(sigs, syscall.SIGINT)
+		for {
+			signal := <-sigs
+			logging.Infof("Received %s signal, Stopping key holder gracefully...", signal.String())
+
+			if err = rpcListener.Stop(); err != nil {
+				logging.Fatalf("Error stopping key holder: %s", err)
+			}
+
+			return
+		}
+	}()
+
+	privkey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), key[:])
+	logging.Infof("Key holder %d of a %d of %d committee", share.Index, committee.Threshold, len(committee.ShareKeys))
+	logging.Infof(" === will start to listen on noise-rpc ===")
+	if err = rpcListener.NoiseListen(privkey, conf.Rpchost, conf.Rpcport); err != nil {
+		logging.Fatalf("Error listening for noise rpc for key holder: %s", err)
+	}
+
+	// wait until the listener dies
+	rpcListener.WaitUntilDead()
+
+	return
+}
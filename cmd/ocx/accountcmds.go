@@ -4,7 +4,6 @@ import (
 	"fmt"
 
 	"github.com/mit-dci/lit/lnutil"
-	"github.com/mit-dci/opencx/cxrpc"
 	"github.com/mit-dci/opencx/logging"
 )
 
@@ -22,19 +21,10 @@ func (cl *ocxClient) Register(args []string) (err error) {
 	if err = cl.UnlockKey(); err != nil {
 		logging.Fatalf("Could not unlock key! Fatal!")
 	}
-	var regStringReply *cxrpc.GetRegistrationStringReply
-	if regStringReply, err = cl.RPCClient.GetRegistrationString(); err != nil {
-		return
-	}
-
-	var sig []byte
-	if sig, err = cl.SignBytes([]byte(regStringReply.RegistrationString)); err != nil {
-		return
-	}
 
 	// if there is ever a reply for register uncomment this and replace the _
 	// var registerReply *cxrpc.RegisterReply
-	if _, err = cl.RPCClient.Register(sig); err != nil {
+	if _, err = cl.RPCClient.Register(); err != nil {
 		return
 	}
 
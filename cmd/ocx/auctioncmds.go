@@ -3,18 +3,26 @@ package main
 import (
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/crypto/threshold"
 	"github.com/mit-dci/opencx/cxauctionrpc"
+	"github.com/mit-dci/opencx/cxauctionserver"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
 
+// commitRevealPollInterval is how often we check whether a commit-reveal auction is over
+const commitRevealPollInterval = time.Second
+
 var placeAuctionOrderCommand = &Command{
-	Format: fmt.Sprintf("%s%s%s%s%s\n", lnutil.Red("placeauctionorder"), lnutil.ReqColor("side"), lnutil.ReqColor("pair"), lnutil.ReqColor("amounthave"), lnutil.ReqColor("price")),
-	Description: fmt.Sprintf("%s\n%s\n",
+	Format: fmt.Sprintf("%s%s%s%s%s%s\n", lnutil.Red("placeauctionorder"), lnutil.ReqColor("side"), lnutil.ReqColor("pair"), lnutil.ReqColor("amounthave"), lnutil.ReqColor("price"), lnutil.OptColor("scheme")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n%s\n",
 		"Submit a front-running resistant auction order with side \"buy\" or side \"sell\", for pair \"asset1\"/\"asset2\", where you give up amounthave of \"asset1\" (if on buy side) or \"asset2\" if on sell side, for the other token at a specific price.",
+		"The order is encrypted with scheme, like \"rsw2048a2-aes\" or \"committee-aesgcm\", which must be allowed by the exchange. By default the exchange's first allowed scheme is used.",
+		"If the pair takes commitments instead, a bond is put up and this waits for the auction to end to reveal the order.",
 		"This will return an order ID which can be used as input to cancelorder, or getorder.",
 	),
 	ShortDescription: fmt.Sprintf("%s\n", "Place a front-running resistant order on the exchange."),
@@ -54,13 +62,63 @@ func (cl *ocxClient) AuctionOrderCommand(args []string) (err error) {
 
 	var paramreply *cxauctionrpc.GetPublicParametersReply
 	if paramreply, err = cl.RPCClient.GetPublicParameters(pairParam); err != nil {
-		err = fmt.Errorf("Error getting public parameters before placing auction order: %s", err)
+		err = match.Wrapf(err, "Error getting public parameters before placing auction order: %s", err)
+		return
+	}
+
+	if paramreply.IntakeMode == cxauctionserver.IntakeCommitReveal {
+		err = cl.commitRevealAuctionOrder(pubkey, side, pair, amountHave, price, pairParam, paramreply)
+		return
+	}
+
+	// orders are created with 2048 bit puzzles, so the exchange would reject anything we send
+	if paramreply.MinModulusBits > 2048 {
+		err = fmt.Errorf("Exchange requires puzzles with at least a %d bit modulus, orders are created with a 2048 bit modulus", paramreply.MinModulusBits)
+		return
+	}
+
+	if len(paramreply.AllowedSchemes) == 0 {
+		err = fmt.Errorf("Exchange does not allow any encryption schemes, cannot place auction order")
+		return
+	}
+
+	scheme := paramreply.AllowedSchemes[0]
+	if len(args) > 4 {
+		if scheme, err = match.EncryptionSchemeFromString(args[4]); err != nil {
+			err = fmt.Errorf("Error parsing scheme, please enter something valid: %s", err)
+			return
+		}
+
+		allowed := false
+		for _, allowedScheme := range paramreply.AllowedSchemes {
+			if scheme == allowedScheme {
+				allowed = true
+			}
+		}
+		if !allowed {
+			err = fmt.Errorf("Exchange does not allow scheme %s", scheme.String())
+			return
+		}
+	}
+
+	if scheme.Timelock == match.TimelockCommittee {
+		var committee *threshold.CommitteeKey
+		if committee, err = threshold.ParsePubKey(cxauctionserver.CommitteeCurve, paramreply.CommitteePubKey); err != nil {
+			err = fmt.Errorf("Error parsing exchange's committee public key: %s", err)
+			return
+		}
+
+		if _, err = cl.RPCClient.AuctionOrderCommandToCommittee(pubkey, side, pair, amountHave, price, paramreply.AuctionID, scheme, committee); err != nil {
+			return
+		}
+
+		logging.Infof("Successfully placed auction order")
 		return
 	}
 
 	// we ignore reply because there's nothing in it and we don't use it
 	// var reply *cxauctionrpc.SubmitPuzzledOrderReply
-	if _, err = cl.RPCClient.AuctionOrderCommand(pubkey, side, pair, amountHave, price, paramreply.AuctionTime, paramreply.AuctionID); err != nil {
+	if _, err = cl.RPCClient.AuctionOrderCommandWithScheme(pubkey, side, pair, amountHave, price, paramreply.AuctionTime, paramreply.AuctionID, scheme); err != nil {
 		return
 	}
 
@@ -68,3 +126,186 @@ func (cl *ocxClient) AuctionOrderCommand(args []string) (err error) {
 
 	return
 }
+
+// commitRevealAuctionOrder commits to an order, waits for the auction to end, and reveals the order
+// so the bond is given back
+func (cl *ocxClient) commitRevealAuctionOrder(pubkey *koblitz.PublicKey, side string, pair string, amountHave uint64, price float64, pairParam *match.Pair, paramreply *cxauctionrpc.GetPublicParametersReply) (err error) {
+	logging.Infof("Pair takes commitments, putting up a bond of %d %s", paramreply.CommitReveal.BondAmount, paramreply.CommitReveal.BondAsset.String())
+
+	var reveal *match.OrderReveal
+	if reveal, err = cl.RPCClient.AuctionOrderCommitCommand(pubkey, side, pair, amountHave, price, paramreply.AuctionID); err != nil {
+		return
+	}
+
+	logging.Infof("Committed to auction order, waiting for auction %x to end to reveal it", paramreply.AuctionID)
+
+	// The auction is over once the exchange has moved on to the next one
+	var currReply *cxauctionrpc.GetPublicParametersReply
+	for {
+		time.Sleep(commitRevealPollInterval)
+		if currReply, err = cl.RPCClient.GetPublicParameters(pairParam); err != nil {
+			err = match.Wrapf(err, "Error getting public parameters while waiting to reveal: %s", err)
+			return
+		}
+		if currReply.AuctionID != paramreply.AuctionID {
+			break
+		}
+	}
+
+	if err = cl.RPCClient.RevealAuctionOrderCommand(reveal); err != nil {
+		return
+	}
+
+	logging.Infof("Successfully revealed auction order")
+	return
+}
+
+var pauseAuctionsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("pauseauctions"), lnutil.ReqColor("pair")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Pause the auction clock for pair \"asset1\"/\"asset2\". The current auction stays open until auctions are resumed.",
+		"This is an admin command, so it must be signed with the exchange's key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Pause auctions for a pair (admin only)."),
+}
+
+// PauseAuctions pauses the auction clock for a pair
+func (cl *ocxClient) PauseAuctions(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	pairParam := new(match.Pair)
+	if err = pairParam.FromString(args[0]); err != nil {
+		err = fmt.Errorf("Error parsing pair, please enter something valid: %s", err)
+		return
+	}
+
+	if _, err = cl.RPCClient.PauseAuctions(pairParam); err != nil {
+		return
+	}
+
+	logging.Infof("Paused auctions for %s", pairParam.PrettyString())
+	return
+}
+
+var resumeAuctionsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("resumeauctions"), lnutil.ReqColor("pair")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Resume the auction clock for pair \"asset1\"/\"asset2\".",
+		"This is an admin command, so it must be signed with the exchange's key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Resume auctions for a pair (admin only)."),
+}
+
+// ResumeAuctions resumes the auction clock for a pair
+func (cl *ocxClient) ResumeAuctions(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	pairParam := new(match.Pair)
+	if err = pairParam.FromString(args[0]); err != nil {
+		err = fmt.Errorf("Error parsing pair, please enter something valid: %s", err)
+		return
+	}
+
+	if _, err = cl.RPCClient.ResumeAuctions(pairParam); err != nil {
+		return
+	}
+
+	logging.Infof("Resumed auctions for %s", pairParam.PrettyString())
+	return
+}
+
+var setAuctionScheduleCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s%s%s%s%s\n", lnutil.Red("setauctionschedule"), lnutil.ReqColor("pair"), lnutil.ReqColor("duration"), lnutil.ReqColor("aligned"), lnutil.ReqColor("recovery"), lnutil.OptColor("retries"), lnutil.OptColor("retrydelay")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Set the auction schedule for pair \"asset1\"/\"asset2\". Auctions run for duration (e.g. 30s or 5m), and start at multiples of the duration if aligned is true.",
+		fmt.Sprintf("If committing to an auction fails, recovery \"skip\" keeps the auction open until the next tick, and recovery \"retry\" retries up to retries times (default %d) first, waiting retrydelay (e.g. 500ms, default %s) between attempts.", cxauctionserver.DefaultMaxRetries, cxauctionserver.DefaultRetryDelay),
+		"This is an admin command, so it must be signed with the exchange's key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Set the auction schedule for a pair (admin only)."),
+}
+
+// SetAuctionSchedule sets the auction schedule for a pair
+func (cl *ocxClient) SetAuctionSchedule(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	pairParam := new(match.Pair)
+	if err = pairParam.FromString(args[0]); err != nil {
+		err = fmt.Errorf("Error parsing pair, please enter something valid: %s", err)
+		return
+	}
+
+	var duration time.Duration
+	if duration, err = time.ParseDuration(args[1]); err != nil {
+		err = fmt.Errorf("Error parsing duration, please enter something valid: %s", err)
+		return
+	}
+
+	var aligned bool
+	if aligned, err = strconv.ParseBool(args[2]); err != nil {
+		err = fmt.Errorf("Error parsing aligned, please enter true or false: %s", err)
+		return
+	}
+
+	recovery := args[3]
+	if _, err = cxauctionserver.RecoveryPolicyFromString(recovery); err != nil {
+		err = fmt.Errorf("Error parsing recovery, please enter something valid: %s", err)
+		return
+	}
+
+	retries := cxauctionserver.DefaultMaxRetries
+	if len(args) > 4 {
+		if retries, err = strconv.ParseUint(args[4], 10, 64); err != nil {
+			err = fmt.Errorf("Error parsing retries, please enter something valid: %s", err)
+			return
+		}
+	}
+
+	retryDelay := cxauctionserver.DefaultRetryDelay
+	if len(args) > 5 {
+		if retryDelay, err = time.ParseDuration(args[5]); err != nil {
+			err = fmt.Errorf("Error parsing retrydelay, please enter something valid: %s", err)
+			return
+		}
+	}
+
+	if _, err = cl.RPCClient.SetAuctionSchedule(pairParam, duration, aligned, recovery, retries, retryDelay); err != nil {
+		return
+	}
+
+	logging.Infof("Set auction schedule for %s", pairParam.PrettyString())
+	return
+}
+
+var getAuctionScheduleCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("getauctionschedule"), lnutil.ReqColor("pair")),
+	Description: fmt.Sprintf("%s\n",
+		"Get the auction schedule for pair \"asset1\"/\"asset2\", including whether or not auctions are paused.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Get the auction schedule for a pair."),
+}
+
+// GetAuctionSchedule gets the auction schedule for a pair
+func (cl *ocxClient) GetAuctionSchedule(args []string) (err error) {
+	pairParam := new(match.Pair)
+	if err = pairParam.FromString(args[0]); err != nil {
+		err = fmt.Errorf("Error parsing pair, please enter something valid: %s", err)
+		return
+	}
+
+	var scheduleReply *cxauctionrpc.GetAuctionScheduleReply
+	if scheduleReply, err = cl.RPCClient.GetAuctionSchedule(pairParam); err != nil {
+		return
+	}
+
+	logging.Infof("Auctions for %s run every %s, aligned: %t, paused: %t, recovery: %s (%d retries)", pairParam.PrettyString(), scheduleReply.Duration, scheduleReply.Aligned, scheduleReply.Paused, scheduleReply.Recovery, scheduleReply.MaxRetries)
+	if scheduleReply.Aligned {
+		logging.Infof("Next auction ends at %s", scheduleReply.NextTick)
+	}
+	return
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/cxauctionrpc"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+var auditBatchCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("auditbatch"), lnutil.ReqColor("auctionid")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Check that the exchange decrypted every order in the batch for the hex encoded auctionid honestly.",
+		"The exchange proves the solution to each accepted order's puzzle, which can be checked without solving the puzzle. The first audit of a batch waits for the proofs to be made.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Audit the decryption of an auction batch."),
+}
+
+// AuditBatch gets the decryption proofs for a batch and checks every one of them
+func (cl *ocxClient) AuditBatch(args []string) (err error) {
+	var idBytes []byte
+	if idBytes, err = hex.DecodeString(args[0]); err != nil {
+		err = fmt.Errorf("Error decoding auction ID, please enter something valid: %s", err)
+		return
+	}
+	if len(idBytes) != 32 {
+		err = fmt.Errorf("Auction ID should be 32 bytes, got %d", len(idBytes))
+		return
+	}
+
+	var auctionID [32]byte
+	copy(auctionID[:], idBytes)
+
+	var getBatchProofsReply *cxauctionrpc.GetBatchProofsReply
+	if getBatchProofsReply, err = cl.RPCClient.GetBatchProofs(auctionID); err != nil {
+		return
+	}
+
+	for i, entry := range getBatchProofsReply.Proofs {
+		encrypted := new(match.EncryptedAuctionOrder)
+		if err = encrypted.Deserialize(entry.EncryptedOrderBytes); err != nil {
+			err = fmt.Errorf("Error deserializing encrypted order %d: %s", i, err)
+			return
+		}
+
+		if encrypted.IntendedAuction != auctionID {
+			err = fmt.Errorf("Encrypted order %d is for auction %x, not %x", i, encrypted.IntendedAuction, auctionID)
+			return
+		}
+
+		var order *match.AuctionOrder
+		if order, err = encrypted.DecryptFromProof(entry.Proof); err != nil {
+			err = fmt.Errorf("Decryption proof for order %d does not verify: %s", i, err)
+			return
+		}
+
+		logging.Infof("Order %d by %x verified: %s", i, order.Pubkey, order.String())
+	}
+
+	logging.Infof("Verified decryption of all %d orders in auction %x", len(getBatchProofsReply.Proofs), auctionID)
+	return
+}
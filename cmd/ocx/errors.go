@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// errorHints are what to tell the user when the exchange returns an error with a code
+var errorHints = map[match.ErrorCode]string{
+	match.ErrInsufficientFunds: "You don't have enough balance for this, check it with getbalance or deposit more",
+	match.ErrUnknownPair:       "The exchange doesn't trade that pair, see getpairs for the ones it does",
+	match.ErrUnknownAsset:      "The exchange doesn't have that asset, see getpairs for the ones it trades",
+	match.ErrPriceOutOfBounds:  "The price is too high or too low for the exchange to take",
+	match.ErrInvalidAmount:     "The amount is zero, too small, or too large",
+	match.ErrInvalidOrder:      "The order is malformed, or isn't the kind of order the pair takes",
+	match.ErrUnknownOrder:      "There's no order with that ID, it may have been filled or cancelled",
+	match.ErrNotOwner:          "That order belongs to someone else",
+	match.ErrUnauthorized:      "The exchange couldn't authorize the request, make sure you're registered and using the right key",
+	match.ErrAuctionClosed:     "The auction isn't taking orders right now, try the next one",
+	match.ErrAuctionFull:       "The auction is full, try the next one",
+	match.ErrServerBusy:        "The exchange is busy, try again later",
+	match.ErrRateLimited:       "You're making requests too quickly, wait a bit and try again",
+}
+
+// explainError logs a hint for an error from the exchange, if it has a code we know
+func explainError(err error) {
+	code := match.CodeOf(err)
+	if hint, ok := errorHints[code]; ok {
+		logging.Errorf("%s: %s", code, hint)
+	}
+	return
+}
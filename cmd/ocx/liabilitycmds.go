@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/logging"
+)
+
+var getLiabilityProofCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("getliabilityproof"), lnutil.ReqColor("asset"), lnutil.OptColor("roothash")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Get and verify the proof that your balance of asset is included in the exchange's latest published liability root.",
+		"The root commits to the total the exchange owes every user. The proof is checked against the root anyone can get without signing in, not one sent along with the proof.",
+		"Give the root hash the exchange published somewhere else, or compare the printed root with other users, to make sure everyone was given the same root.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Verify that your balance is included in the exchange's liabilities."),
+}
+
+// GetLiabilityProof gets and verifies the proof that our balance is included in the published
+// liabilities. The proof is checked against the root that's given to everyone, and against the
+// root hash we were given, if any.
+func (cl *ocxClient) GetLiabilityProof(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var publishedHash []byte
+	if len(args) > 1 {
+		if publishedHash, err = hex.DecodeString(args[1]); err != nil {
+			err = fmt.Errorf("Error decoding published root hash: %s", err)
+			return
+		}
+		if len(publishedHash) != 32 {
+			err = fmt.Errorf("Published root hash should be 32 bytes")
+			return
+		}
+	}
+
+	// the root everyone is given, asked for without saying who we are
+	var getLiabilityRootReply *cxrpc.GetLiabilityRootReply
+	if getLiabilityRootReply, err = cl.RPCClient.GetLiabilityRoot(asset); err != nil {
+		return
+	}
+
+	root := getLiabilityRootReply.Root
+	if publishedHash != nil && !bytes.Equal(root.Hash[:], publishedHash) {
+		err = fmt.Errorf("Exchange gave root %x, which is not the published root %x", root.Hash, publishedHash)
+		return
+	}
+
+	var getLiabilityProofReply *cxrpc.GetLiabilityProofReply
+	if getLiabilityProofReply, err = cl.RPCClient.GetLiabilityProof(asset); err != nil {
+		return
+	}
+
+	if !getLiabilityProofReply.Published.Equal(getLiabilityRootReply.Published) {
+		err = fmt.Errorf("Proof is not for the root we were given, the exchange may have published a new root, try again")
+		return
+	}
+
+	pubkeyBytes := cl.RPCClient.PrivKey.PubKey().SerializeCompressed()
+	if err = getLiabilityProofReply.Proof.Verify(pubkeyBytes, root); err != nil {
+		err = fmt.Errorf("Liability proof does not verify against the published root: %s", err)
+		return
+	}
+
+	logging.Infof("Liability root for %s published at %s: %x", asset, getLiabilityRootReply.Published.String(), root.Hash)
+	logging.Infof("Total liabilities: %d over %d users", root.Sum, getLiabilityRootReply.NumUsers)
+	logging.Infof("Your balance of %d %s is included in the liability root", getLiabilityProofReply.Proof.Balance, asset)
+	if publishedHash == nil {
+		logging.Warnf("No published root hash given, compare the root above with the one the exchange published")
+	}
+	return
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/cxserver"
+	"github.com/mit-dci/opencx/logging"
+)
+
+var watchMarketCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("watchmarket"), lnutil.ReqColor("pair"), lnutil.OptColor("count")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Subscribe to market data for a pair, and print book deltas and trades as they happen.",
+		"Stops after count events if count is specified, otherwise runs until interrupted.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Print book deltas and trades for a pair as they happen."),
+}
+
+// WatchMarket subscribes to market data for a pair and prints the events
+func (cl *ocxClient) WatchMarket(args []string) (err error) {
+	pair := args[0]
+
+	var count uint64
+	if len(args) > 1 {
+		if count, err = strconv.ParseUint(args[1], 10, 64); err != nil {
+			err = fmt.Errorf("Error parsing count, must be a positive integer: %s", err)
+			return
+		}
+	}
+
+	var subscribeReply *cxrpc.SubscribeMarketDataReply
+	if subscribeReply, err = cl.RPCClient.SubscribeMarketData(pair); err != nil {
+		return
+	}
+	logging.Infof("Subscribed to %s with %d orders on the book at seq %d", pair, len(subscribeReply.Orders), subscribeReply.Seq)
+
+	epoch, seq := subscribeReply.Epoch, subscribeReply.Seq
+	var printed uint64
+	for count == 0 || printed < count {
+		var pollReply *cxrpc.PollMarketDataReply
+		if pollReply, err = cl.RPCClient.PollMarketData(pair, epoch, seq, cxserver.MaxMarketEventWait); err != nil {
+			return
+		}
+
+		// we missed some events, or the server restarted, so start over from a new snapshot
+		if pollReply.Gap {
+			if subscribeReply, err = cl.RPCClient.SubscribeMarketData(pair); err != nil {
+				return
+			}
+			logging.Warnf("Missed market events after seq %d, resubscribed at seq %d", seq, subscribeReply.Seq)
+			epoch, seq = subscribeReply.Epoch, subscribeReply.Seq
+			continue
+		}
+
+		for _, event := range pollReply.Events {
+			logging.Infof("%d %s %x %s price %f have %d want %d", event.Seq, event.Type, event.OrderID, event.Side, event.Price, event.AmountHave, event.AmountWant)
+			seq = event.Seq
+			printed++
+			if count != 0 && printed >= count {
+				break
+			}
+		}
+	}
+
+	return
+}
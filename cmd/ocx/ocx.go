@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -46,6 +47,9 @@ type ocxConfig struct {
 
 	// auth or unauth rpc?
 	AuthenticatedRPC bool `long:"authrpc" description:"Whether or not to use authenticated RPC"`
+
+	// pinned server pubkey for authenticated rpc
+	ServerPubkey string `long:"serverpubkey" description:"Hex compressed pubkey of the OpenCX Server. If set, authenticated RPC will only connect to a server with this key"`
 }
 
 // Let these be turned into config things at some point
@@ -108,6 +112,11 @@ func main() {
 		if err = client.UnlockKey(); err != nil {
 			return
 		}
+		if conf.ServerPubkey != "" {
+			if client.RPCClient.ServerPubKey, err = parseServerPubkey(conf.ServerPubkey); err != nil {
+				logging.Fatalf("Error parsing server pubkey: \n%s", err)
+			}
+		}
 		if err = client.RPCClient.SetupBenchNoiseClient(conf.Rpchost, conf.Rpcport); err != nil {
 			logging.Fatalf("Error setting up OpenCX RPC Client: \n%s", err)
 		}
@@ -115,10 +124,25 @@ func main() {
 	}
 
 	if err = client.parseCommands(os.Args[1:]); err != nil {
+		explainError(err)
 		logging.Fatalf("%s", err)
 	}
 }
 
+// parseServerPubkey parses the hex compressed pubkey of the server from the config
+func parseServerPubkey(pubkeyHex string) (pubkey *koblitz.PublicKey, err error) {
+	var pubkeyBytes []byte
+	if pubkeyBytes, err = hex.DecodeString(pubkeyHex); err != nil {
+		err = fmt.Errorf("Error decoding server pubkey hex: %s", err)
+		return
+	}
+	if pubkey, err = koblitz.ParsePubKey(pubkeyBytes, koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing server pubkey: %s", err)
+		return
+	}
+	return
+}
+
 func (cl *ocxClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
 	return cl.RPCClient.Call(serviceMethod, args, reply)
 }
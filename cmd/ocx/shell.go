@@ -3,6 +3,7 @@ package main
 import (
 	"github.com/fatih/color"
 	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/match"
 
 	"fmt"
 )
@@ -64,7 +65,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.GetBalance(args); err != nil {
-			return fmt.Errorf("Error getting balance: \n%s", err)
+			return match.Wrapf(err, "Error getting balance: \n%s", err)
 		}
 	}
 	if cmd == "getallbalances" {
@@ -76,7 +77,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.GetAllBalances(args); err != nil {
-			return fmt.Errorf("Error getting balance: \n%s", err)
+			return match.Wrapf(err, "Error getting balance: \n%s", err)
 		}
 	}
 	if cmd == "getdepositaddress" {
@@ -88,7 +89,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.GetDepositAddress(args); err != nil {
-			return fmt.Errorf("Error getting deposit address: \n%s", err)
+			return match.Wrapf(err, "Error getting deposit address: \n%s", err)
 		}
 	}
 	if cmd == "placeorder" {
@@ -100,7 +101,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.OrderCommand(args); err != nil {
-			return fmt.Errorf("Error calling order command: \n%s", err)
+			return match.Wrapf(err, "Error calling order command: \n%s", err)
 		}
 	}
 	if cmd == "vieworderbook" {
@@ -112,7 +113,31 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.ViewOrderbook(args); err != nil {
-			return fmt.Errorf("Error calling vieworderbook command: \n%s", err)
+			return match.Wrapf(err, "Error calling vieworderbook command: \n%s", err)
+		}
+	}
+	if cmd == "watchmarket" {
+		if getHelpForCommand(watchMarketCommand, args) {
+			return nil
+		}
+		if len(args) != 1 && len(args) != 2 {
+			return fmt.Errorf("Must specify from 1 to 2 arguments: pair [count]")
+		}
+
+		if err := cl.WatchMarket(args); err != nil {
+			return match.Wrapf(err, "Error calling watchmarket command: \n%s", err)
+		}
+	}
+	if cmd == "watchevents" {
+		if getHelpForCommand(watchEventsCommand, args) {
+			return nil
+		}
+		if len(args) > 1 {
+			return fmt.Errorf("Must specify at most 1 argument: [epoch:afterseq]")
+		}
+
+		if err := cl.WatchEvents(args); err != nil {
+			return match.Wrapf(err, "Error calling watchevents command: \n%s", err)
 		}
 	}
 	if cmd == "getprice" {
@@ -124,7 +149,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.GetPrice(args); err != nil {
-			return fmt.Errorf("Error calling getprice command: \n%s", err)
+			return match.Wrapf(err, "Error calling getprice command: \n%s", err)
 		}
 	}
 	if cmd == "withdraw" {
@@ -136,7 +161,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.Withdraw(args); err != nil {
-			return fmt.Errorf("Error calling withdraw command: \n%s", err)
+			return match.Wrapf(err, "Error calling withdraw command: \n%s", err)
 		}
 	}
 	if cmd == "litwithdraw" {
@@ -148,7 +173,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.LitWithdraw(args); err != nil {
-			return fmt.Errorf("Error calling withdraw command: \n%s", err)
+			return match.Wrapf(err, "Error calling withdraw command: \n%s", err)
 		}
 	}
 	if cmd == "cancelorder" {
@@ -160,7 +185,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.CancelOrder(args); err != nil {
-			return fmt.Errorf("Error calling cancel command: \n%s", err)
+			return match.Wrapf(err, "Error calling cancel command: \n%s", err)
 		}
 	}
 	if cmd == "getpairs" {
@@ -172,7 +197,7 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.GetPairs(); err != nil {
-			return fmt.Errorf("Error getting pairs: \n%s", err)
+			return match.Wrapf(err, "Error getting pairs: \n%s", err)
 		}
 	}
 	if cmd == "getlitconnection" {
@@ -184,19 +209,115 @@ func (cl *ocxClient) parseCommands(commands []string) error {
 		}
 
 		if err := cl.GetLitConnection(args); err != nil {
-			return fmt.Errorf("Error getting lit connection: \n%s", err)
+			return match.Wrapf(err, "Error getting lit connection: \n%s", err)
 		}
 	}
 	if cmd == "placeauctionorder" {
 		if getHelpForCommand(placeAuctionOrderCommand, args) {
 			return nil
 		}
-		if len(args) != 4 {
-			return fmt.Errorf("Must specify 4 arguments: side, pair, amounthave, price")
+		if len(args) != 4 && len(args) != 5 {
+			return fmt.Errorf("Must specify 4 or 5 arguments: side, pair, amounthave, price, and optionally scheme")
 		}
 
 		if err := cl.AuctionOrderCommand(args); err != nil {
-			return fmt.Errorf("Error placing auction order: \n%s", err)
+			return match.Wrapf(err, "Error placing auction order: \n%s", err)
+		}
+	}
+	if cmd == "pauseauctions" {
+		if getHelpForCommand(pauseAuctionsCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: pair")
+		}
+
+		if err := cl.PauseAuctions(args); err != nil {
+			return match.Wrapf(err, "Error pausing auctions: \n%s", err)
+		}
+	}
+	if cmd == "resumeauctions" {
+		if getHelpForCommand(resumeAuctionsCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: pair")
+		}
+
+		if err := cl.ResumeAuctions(args); err != nil {
+			return match.Wrapf(err, "Error resuming auctions: \n%s", err)
+		}
+	}
+	if cmd == "setauctionschedule" {
+		if getHelpForCommand(setAuctionScheduleCommand, args) {
+			return nil
+		}
+		if len(args) < 4 || len(args) > 6 {
+			return fmt.Errorf("Must specify from 4 to 6 arguments: pair duration aligned recovery [retries] [retrydelay]")
+		}
+
+		if err := cl.SetAuctionSchedule(args); err != nil {
+			return match.Wrapf(err, "Error setting auction schedule: \n%s", err)
+		}
+	}
+	if cmd == "getauctionschedule" {
+		if getHelpForCommand(getAuctionScheduleCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: pair")
+		}
+
+		if err := cl.GetAuctionSchedule(args); err != nil {
+			return match.Wrapf(err, "Error getting auction schedule: \n%s", err)
+		}
+	}
+	if cmd == "auditbatch" {
+		if getHelpForCommand(auditBatchCommand, args) {
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("Must specify 1 argument: auctionid")
+		}
+
+		if err := cl.AuditBatch(args); err != nil {
+			return match.Wrapf(err, "Error auditing batch: \n%s", err)
+		}
+	}
+	if cmd == "provesolvency" {
+		if getHelpForCommand(proveSolvencyCommand, args) {
+			return nil
+		}
+		if len(args) < 1 {
+			return fmt.Errorf("Must specify at least 1 argument: asset [pubkey:balance...]")
+		}
+
+		if err := cl.ProveSolvency(args); err != nil {
+			return match.Wrapf(err, "Error proving solvency: \n%s", err)
+		}
+	}
+	if cmd == "verifyliabilities" {
+		if getHelpForCommand(verifyLiabilitiesCommand, args) {
+			return nil
+		}
+		if len(args) < 1 {
+			return fmt.Errorf("Must specify at least 1 argument: asset [pubkey:balance...]")
+		}
+
+		if err := cl.VerifyLiabilities(args); err != nil {
+			return match.Wrapf(err, "Error verifying liabilities: \n%s", err)
+		}
+	}
+	if cmd == "getliabilityproof" {
+		if getHelpForCommand(getLiabilityProofCommand, args) {
+			return nil
+		}
+		if len(args) < 1 || len(args) > 2 {
+			return fmt.Errorf("Must specify 1 or 2 arguments: asset [roothash]")
+		}
+
+		if err := cl.GetLiabilityProof(args); err != nil {
+			return match.Wrapf(err, "Error getting liability proof: \n%s", err)
 		}
 	}
 	return nil
@@ -212,7 +333,7 @@ func (cl *ocxClient) Help(textArgs []string) error {
 	if len(textArgs) == 0 {
 
 		fmt.Fprintf(color.Output, lnutil.Header("Commands:\n"))
-		listofCommands := []*Command{helpCommand, registerCommand, getBalanceCommand, getDepositAddressCommand, getAllBalancesCommand, withdrawCommand, litWithdrawCommand, getLitConnectionCommand, placeOrderCommand, getPriceCommand, viewOrderbookCommand, cancelOrderCommand, getPairsCommand, placeAuctionOrderCommand}
+		listofCommands := []*Command{helpCommand, registerCommand, getBalanceCommand, getDepositAddressCommand, getAllBalancesCommand, withdrawCommand, litWithdrawCommand, getLitConnectionCommand, placeOrderCommand, getPriceCommand, viewOrderbookCommand, watchMarketCommand, watchEventsCommand, cancelOrderCommand, getPairsCommand, placeAuctionOrderCommand, getAuctionScheduleCommand, setAuctionScheduleCommand, pauseAuctionsCommand, resumeAuctionsCommand, auditBatchCommand, proveSolvencyCommand, verifyLiabilitiesCommand, getLiabilityProofCommand}
 		printHelp(listofCommands)
 		return nil
 	}
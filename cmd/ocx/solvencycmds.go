@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/crypto/provisions"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/logging"
+)
+
+var proveSolvencyCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("provesolvency"), lnutil.ReqColor("asset"), lnutil.OptColor("pubkey:balance...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Make the exchange prove that it holds at least as much asset as it owes its customers, without revealing which keys it owns or any balances.",
+		"Every pubkey:balance is a hex encoded compressed pubkey that the exchange does not own, and its balance on chain, which hides the exchange's keys.",
+		"This is an admin command, so it must be signed with the exchange's key.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Create a proof of solvency for an asset (admin only)."),
+}
+
+// ProveSolvency asks the exchange to prove solvency for an asset
+func (cl *ocxClient) ProveSolvency(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var anonBalances map[[33]byte]uint64
+	if anonBalances, err = parseKeyBalances(args[1:]); err != nil {
+		return
+	}
+
+	var anonKeys []cxrpc.AnonKey
+	for pubkey, balance := range anonBalances {
+		anonKeys = append(anonKeys, cxrpc.AnonKey{
+			PubKey:  pubkey,
+			Balance: balance,
+		})
+	}
+
+	var proveSolvencyReply *cxrpc.ProveSolvencyReply
+	if proveSolvencyReply, err = cl.RPCClient.ProveSolvency(asset, anonKeys); err != nil {
+		return
+	}
+
+	var params *provisions.Params
+	if params, err = provisions.NewParams(koblitz.S256()); err != nil {
+		return
+	}
+
+	if err = proveSolvencyReply.Proof.Verify(params); err != nil {
+		err = fmt.Errorf("Exchange created a solvency proof that does not verify: %s", err)
+		return
+	}
+
+	// The keys we gave should be in the proof with the balances we gave. The exchange's own keys
+	// get checked against the chain by whoever verifies the proof.
+	proofBalances := make(map[[33]byte]uint64)
+	for _, entry := range proveSolvencyReply.Proof.Assets.Entries {
+		var pubkey [33]byte
+		copy(pubkey[:], (&koblitz.PublicKey{Curve: koblitz.S256(), X: entry.PubKey.X, Y: entry.PubKey.Y}).SerializeCompressed())
+		proofBalances[pubkey] = entry.Balance
+	}
+	for pubkey, balance := range anonBalances {
+		if proofBalance, found := proofBalances[pubkey]; !found || proofBalance != balance {
+			err = fmt.Errorf("Exchange created a solvency proof without anonymity set key %x and its balance %d", pubkey, balance)
+			return
+		}
+	}
+
+	logging.Infof("Proved solvency for %s with %d keys in the anonymity set and %d customers", asset, len(proveSolvencyReply.Proof.Assets.Entries), len(proveSolvencyReply.Proof.Liabilities.Entries))
+	return
+}
+
+// parseKeyBalances parses pubkey:balance arguments, where the pubkey is hex encoded and compressed
+func parseKeyBalances(args []string) (balances map[[33]byte]uint64, err error) {
+	balances = make(map[[33]byte]uint64)
+	for _, arg := range args {
+		splitArg := strings.Split(arg, ":")
+		if len(splitArg) != 2 {
+			err = fmt.Errorf("Key %s should be pubkey:balance", arg)
+			return
+		}
+
+		var pubkeyBytes []byte
+		if pubkeyBytes, err = hex.DecodeString(splitArg[0]); err != nil {
+			err = fmt.Errorf("Error decoding pubkey: %s", err)
+			return
+		}
+		if len(pubkeyBytes) != 33 {
+			err = fmt.Errorf("Pubkey should be 33 bytes, compressed")
+			return
+		}
+
+		var pubkey [33]byte
+		copy(pubkey[:], pubkeyBytes)
+		if _, found := balances[pubkey]; found {
+			err = fmt.Errorf("Pubkey %x is given more than once", pubkey)
+			return
+		}
+
+		if balances[pubkey], err = strconv.ParseUint(splitArg[1], 10, 64); err != nil {
+			err = fmt.Errorf("Error parsing balance: %s", err)
+			return
+		}
+	}
+	return
+}
+
+var verifyLiabilitiesCommand = &Command{
+	Format: fmt.Sprintf("%s%s%s\n", lnutil.Red("verifyliabilities"), lnutil.ReqColor("asset"), lnutil.OptColor("pubkey:balance...")),
+	Description: fmt.Sprintf("%s\n%s\n%s\n",
+		"Verify the exchange's latest proof of solvency for an asset, and check that your balance was included in it.",
+		"Every pubkey:balance is a hex encoded compressed pubkey in the anonymity set, and its balance on chain, which you get from the blockchain yourself.",
+		"The proof only passes if every key in it is given, with the balance it claims. Run it without any to see which keys are in the anonymity set.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Verify the latest proof of solvency for an asset."),
+}
+
+// VerifyLiabilities verifies the latest proof of solvency for an asset, and checks that our balance
+// is in it. ocx can't see the chain, so the balance on chain of every key in the anonymity set is
+// given, and the proof fails if any of them are missing or different.
+func (cl *ocxClient) VerifyLiabilities(args []string) (err error) {
+	if err = cl.UnlockKey(); err != nil {
+		logging.Fatalf("Could not unlock key! Fatal!")
+	}
+
+	asset := args[0]
+
+	var chainBalances map[[33]byte]uint64
+	if chainBalances, err = parseKeyBalances(args[1:]); err != nil {
+		return
+	}
+
+	var getSolvencyProofReply *cxrpc.GetSolvencyProofReply
+	if getSolvencyProofReply, err = cl.RPCClient.GetSolvencyProof(asset); err != nil {
+		return
+	}
+	proof := getSolvencyProofReply.Proof
+
+	var params *provisions.Params
+	if params, err = provisions.NewParams(koblitz.S256()); err != nil {
+		return
+	}
+
+	if err = proof.Verify(params); err != nil {
+		err = fmt.Errorf("Solvency proof for %s does not verify: %s", asset, err)
+		return
+	}
+
+	logging.Infof("Solvency proof for %s is consistent, with %d keys in the anonymity set and %d customers", asset, len(proof.Assets.Entries), len(proof.Liabilities.Entries))
+	if len(chainBalances) == 0 {
+		for _, entry := range proof.Assets.Entries {
+			compressed := (&koblitz.PublicKey{Curve: koblitz.S256(), X: entry.PubKey.X, Y: entry.PubKey.Y}).SerializeCompressed()
+			logging.Infof("Anonymity set key %x claims balance %d", compressed, entry.Balance)
+		}
+		err = fmt.Errorf("Give the balance on chain of all %d keys in the anonymity set to check the proof", len(proof.Assets.Entries))
+		return
+	}
+
+	if err = proof.Assets.CheckBalances(params, chainBalances); err != nil {
+		err = fmt.Errorf("Solvency proof for %s does not match the chain: %s", asset, err)
+		return
+	}
+	logging.Infof("Every key in the anonymity set has the balance it has on chain")
+
+	var getSolvencyOpeningReply *cxrpc.GetSolvencyOpeningReply
+	if getSolvencyOpeningReply, err = cl.RPCClient.GetSolvencyOpening(asset); err != nil {
+		return
+	}
+
+	if err = getSolvencyOpeningReply.Opening.Verify(params, proof.Liabilities); err != nil {
+		err = fmt.Errorf("Your balance was not included correctly in the solvency proof: %s", err)
+		return
+	}
+
+	logging.Infof("Your balance of %d %s is included in the solvency proof", getSolvencyOpeningReply.Opening.Balance, asset)
+	return
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mit-dci/lit/lnutil"
+	"github.com/mit-dci/opencx/cxrpc"
+	"github.com/mit-dci/opencx/cxserver"
+	"github.com/mit-dci/opencx/logging"
+)
+
+var watchEventsCommand = &Command{
+	Format: fmt.Sprintf("%s%s\n", lnutil.Red("watchevents"), lnutil.OptColor("epoch:afterseq")),
+	Description: fmt.Sprintf("%s\n%s\n",
+		"Print fills, cancels, deposits and withdrawals for your key as they happen, until interrupted.",
+		"Pass the last epoch and seq you saw as epoch:afterseq to pick up where you left off.",
+	),
+	ShortDescription: fmt.Sprintf("%s\n", "Print events for your orders and balances as they happen."),
+}
+
+// WatchEvents polls for events for our pubkey and prints them
+func (cl *ocxClient) WatchEvents(args []string) (err error) {
+
+	var epoch, seq uint64
+	if len(args) > 0 {
+		splitArg := strings.Split(args[0], ":")
+		if len(splitArg) != 2 {
+			err = fmt.Errorf("Cursor %s should be epoch:afterseq", args[0])
+			return
+		}
+		if epoch, err = strconv.ParseUint(splitArg[0], 10, 64); err != nil {
+			err = fmt.Errorf("Error parsing epoch, must be a positive integer: %s", err)
+			return
+		}
+		if seq, err = strconv.ParseUint(splitArg[1], 10, 64); err != nil {
+			err = fmt.Errorf("Error parsing afterseq, must be a positive integer: %s", err)
+			return
+		}
+	}
+
+	for {
+		var pollReply *cxrpc.PollUserEventsReply
+		if pollReply, err = cl.RPCClient.PollUserEvents(epoch, seq, cxserver.MaxUserEventWait); err != nil {
+			return
+		}
+
+		if pollReply.Gap {
+			logging.Warnf("Missed events after %d:%d, check your orders and balances. Continuing from %d:%d", epoch, seq, pollReply.Epoch, pollReply.LastSeq)
+			epoch, seq = pollReply.Epoch, pollReply.LastSeq
+			continue
+		}
+		epoch = pollReply.Epoch
+
+		for _, event := range pollReply.Events {
+			switch event.Type {
+			case cxserver.DepositConfirmed, cxserver.WithdrawalSent:
+				logging.Infof("%d:%d %s %d %s %s", epoch, event.Seq, event.Type, event.Amount, event.Asset, event.Txid)
+			default:
+				logging.Infof("%d:%d %s %x %s %s price %f have %d want %d filled %t", epoch, event.Seq, event.Type, event.OrderID, event.Pair, event.Side, event.Price, event.AmountHave, event.AmountWant, event.Filled)
+			}
+			seq = event.Seq
+		}
+	}
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/hex"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
+	"time"
 
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
@@ -14,6 +17,8 @@ import (
 	"github.com/mit-dci/opencx/cxdb"
 	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
 	"github.com/mit-dci/opencx/cxdb/cxdbsql"
+	"github.com/mit-dci/opencx/cxfix"
+	"github.com/mit-dci/opencx/cxlimit"
 	"github.com/mit-dci/opencx/cxrpc"
 	"github.com/mit-dci/opencx/cxserver"
 	"github.com/mit-dci/opencx/logging"
@@ -31,6 +36,21 @@ type opencxConfig struct {
 	Rpcport uint16 `short:"p" long:"rpcport" description:"Set RPC port to connect to"`
 	Rpchost string `long:"rpchost" description:"Set RPC host to listen to"`
 
+	// JSON-RPC gateway for web clients
+	JSONRPCPort uint16 `long:"jsonrpcport" description:"Set port to serve JSON-RPC over HTTP and WebSocket on, 0 to not serve it"`
+
+	// rate limits for RPC clients, by pubkey and by address
+	RateLimit          float64 `long:"ratelimit" description:"Requests a second each pubkey and each address can make, 0 to not limit them"`
+	RateBurst          float64 `long:"rateburst" description:"Requests each pubkey and each address can make at once"`
+	ExpensiveRateLimit float64 `long:"expensiveratelimit" description:"Orders, cancels and withdrawals a second each pubkey and each address can make, 0 to not limit them"`
+	ExpensiveRateBurst float64 `long:"expensiverateburst" description:"Orders, cancels and withdrawals each pubkey and each address can make at once"`
+
+	// FIX order entry gateway
+	FIXPort   uint16 `long:"fixport" description:"Set port to accept FIX 4.4 sessions on, 0 to not accept them"`
+	FIXCompID string `long:"fixcompid" description:"Set the CompID of the FIX acceptor, which sessions use as their TargetCompID"`
+	FIXCert   string `long:"fixcert" description:"Filename for the PEM certificate within root opencxd directory that FIX sessions connect over TLS with"`
+	FIXKey    string `long:"fixkey" description:"Filename for the PEM key of the FIX certificate within root opencxd directory"`
+
 	// logging and debug parameters
 	LogLevel []bool `short:"v" description:"Set verbosity level to verbose (-v), very verbose (-vv) or very very verbose (-vvv)"`
 
@@ -66,6 +86,12 @@ type opencxConfig struct {
 
 	// support lightning or not to support lightning?
 	LightningSupport bool `long:"lightning" description:"Whether or not to support lightning on the exchange"`
+
+	// how often to publish the merkle sum tree of balances
+	LiabilityInterval time.Duration `long:"liabilityinterval" description:"How often to publish the liability root for every asset, 0 to never publish"`
+
+	// how long to wait for things to finish when shutting down
+	ShutdownTimeout time.Duration `long:"shutdowntimeout" description:"How long to wait for requests, matching and settlement to finish when shutting down, before exiting anyway"`
 }
 
 var (
@@ -79,12 +105,27 @@ var (
 	defaultMinPeerPort       = uint16(25565)
 	defaultLithost           = "localhost"
 	defaultLitport           = uint16(12346)
+	defaultFIXCompID         = "OPENCX"
+	defaultFIXCert           = "fixcert.pem"
+	defaultFIXKey            = "fixkey.pem"
+
+	// default rate limits for RPC clients
+	defaultRateLimit          = float64(20)
+	defaultRateBurst          = float64(40)
+	defaultExpensiveRateLimit = float64(2)
+	defaultExpensiveRateBurst = float64(10)
 
 	// Yes we want to use noise-rpc
 	defaultAuthenticatedRPC = true
 
 	// Yes we want lightning
 	defaultLightningSupport = true
+
+	// Publish liabilities every hour
+	defaultLiabilityInterval = time.Hour
+
+	// Give everything 30 seconds to finish when shutting down
+	defaultShutdownTimeout = 30 * time.Second
 )
 
 // newConfigParser returns a new command line flags parser.
@@ -97,15 +138,25 @@ func main() {
 	var err error
 
 	conf := opencxConfig{
-		OpencxHomeDir:    defaultOpencxHomeDirName,
-		Rpcport:          defaultRpcport,
-		Rpchost:          defaultRpchost,
-		MaxPeers:         defaultMaxPeers,
-		MinPeerPort:      defaultMinPeerPort,
-		Lithost:          defaultLithost,
-		Litport:          defaultLitport,
-		AuthenticatedRPC: defaultAuthenticatedRPC,
-		LightningSupport: defaultLightningSupport,
+		OpencxHomeDir:     defaultOpencxHomeDirName,
+		Rpcport:           defaultRpcport,
+		Rpchost:           defaultRpchost,
+		MaxPeers:          defaultMaxPeers,
+		MinPeerPort:       defaultMinPeerPort,
+		Lithost:           defaultLithost,
+		Litport:           defaultLitport,
+		AuthenticatedRPC:  defaultAuthenticatedRPC,
+		LightningSupport:  defaultLightningSupport,
+		LiabilityInterval: defaultLiabilityInterval,
+		ShutdownTimeout:   defaultShutdownTimeout,
+		FIXCompID:         defaultFIXCompID,
+		FIXCert:           defaultFIXCert,
+		FIXKey:            defaultFIXKey,
+
+		RateLimit:          defaultRateLimit,
+		RateBurst:          defaultRateBurst,
+		ExpensiveRateLimit: defaultExpensiveRateLimit,
+		ExpensiveRateBurst: defaultExpensiveRateBurst,
 	}
 
 	// Check and load config params
@@ -191,6 +242,12 @@ func main() {
 		logging.Fatalf("Error setting up server keys: \n%s", err)
 	}
 
+	// The exchange's key is the admin key, for things like proving solvency
+	adminPrivkey, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), key[:])
+	if err = ocxServer.SetAdminPubkey(adminPrivkey.PubKey()); err != nil {
+		logging.Fatalf("Error setting admin key: %s", err)
+	}
+
 	// Generate the host param list
 	// the host params are all of the coinparams / coins we support
 	// this coinparam list is generated from the configuration file with generateHostParams
@@ -241,11 +298,38 @@ func main() {
 
 	}
 
+	// closed when shutting down, so liability roots stop being published
+	liabilityQuit := make(chan struct{})
+	if conf.LiabilityInterval != 0 {
+		go ocxServer.PublishLiabilityRootsEvery(conf.LiabilityInterval, liabilityQuit)
+	}
+
 	var rpcListener *cxrpc.OpencxRPCCaller
 	if rpcListener, err = cxrpc.CreateRPCForServer(ocxServer); err != nil {
 		logging.Fatalf("Error creating rpc caller for server: %s", err)
 	}
 
+	if err = rpcListener.SetRateLimits(cxlimit.Config{
+		Rate:           conf.RateLimit,
+		Burst:          conf.RateBurst,
+		ExpensiveRate:  conf.ExpensiveRateLimit,
+		ExpensiveBurst: conf.ExpensiveRateBurst,
+	}); err != nil {
+		logging.Fatalf("Error setting rate limits for server: %s", err)
+	}
+
+	var fixAcceptor *cxfix.Acceptor
+	var fixTLSConfig *tls.Config
+	if conf.FIXPort != 0 {
+		if fixTLSConfig, err = cxfix.LoadTLSConfig(filepath.Join(conf.OpencxHomeDir, conf.FIXCert), filepath.Join(conf.OpencxHomeDir, conf.FIXKey)); err != nil {
+			logging.Fatalf("Error loading TLS certificate for fix acceptor, FIX sessions are only accepted over TLS: %s", err)
+		}
+
+		if fixAcceptor, err = cxfix.NewAcceptor(ocxServer, conf.FIXCompID, filepath.Join(conf.OpencxHomeDir, "fix")); err != nil {
+			logging.Fatalf("Error creating fix acceptor for server: %s", err)
+		}
+	}
+
 	// SIGINT and SIGTERM and SIGQUIT handler for CTRL-c, KILL, CTRL-/, etc.
 	go func() {
 		logging.Infof("Notifying signals")
@@ -257,9 +341,31 @@ func main() {
 			signal := <-sigs
 			logging.Infof("Received %s signal, Stopping server gracefully...", signal.String())
 
-			// stop rpc listener
-			if err = rpcListener.Stop(); err != nil {
-				logging.Fatalf("Error killing server: %s", err)
+			// every stage of shutting down shares one deadline, and we exit anyway if it passes,
+			// or we get another signal
+			deadline := time.Now().Add(conf.ShutdownTimeout)
+			go func() {
+				select {
+				case <-time.After(time.Until(deadline)):
+					logging.Errorf("Shutdown took longer than %s, exiting", conf.ShutdownTimeout)
+				case again := <-sigs:
+					logging.Errorf("Received %s signal again, exiting without waiting", again.String())
+				}
+				os.Exit(1)
+			}()
+
+			close(liabilityQuit)
+
+			// log out fix sessions
+			if fixAcceptor != nil {
+				if err = fixAcceptor.Close(); err != nil {
+					logging.Errorf("Error closing fix acceptor: %s", err)
+				}
+			}
+
+			// stop taking requests, finish what's in flight, and close the database
+			if err = rpcListener.Shutdown(deadline); err != nil {
+				logging.Fatalf("Error shutting down server: %s", err)
 			}
 
 			return
@@ -283,6 +389,21 @@ func main() {
 
 	}
 
+	if conf.JSONRPCPort != 0 {
+		logging.Infof(" === will start to listen on json-rpc ===")
+		if err = rpcListener.JSONRPCListen(conf.Rpchost, conf.JSONRPCPort); err != nil {
+			logging.Fatalf("Error listening for json-rpc for server: %s", err)
+		}
+	}
+
+	if fixAcceptor != nil {
+		logging.Infof(" === will start to listen on fix ===")
+		if err = fixAcceptor.Listen(conf.Rpchost, conf.FIXPort, fixTLSConfig); err != nil {
+			logging.Fatalf("Error listening for fix for server: %s", err)
+		}
+		logging.Infof("Running FIX acceptor on %s as %s", fixAcceptor.Addr().String(), conf.FIXCompID)
+	}
+
 	// wait until the listener dies - this does not return anything
 	rpcListener.WaitUntilDead()
 
@@ -0,0 +1,247 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// This is the canonical wire format for puzzles and anything that contains them. Every encoding
+// starts with a header of the magic bytes, the encoding version, and a type tag. Integers are
+// big endian, byte strings and big integers are prefixed with their length as a uint32, and big
+// integers are minimal, so there is exactly one encoding for every value.
+
+const (
+	// EncodingVersion is the version of the canonical encoding
+	EncodingVersion = uint8(1)
+
+	// TypeRSWPuzzle is the type tag for RSW puzzles
+	TypeRSWPuzzle = uint8(1)
+	// TypeHashPuzzle is the type tag for hash timelock puzzles
+	TypeHashPuzzle = uint8(2)
+	// TypeEncryptedOrder is the type tag for encrypted auction orders
+	TypeEncryptedOrder = uint8(3)
+	// TypeCommitteeCapsule is the type tag for capsules hiding a key for a decryption committee
+	TypeCommitteeCapsule = uint8(4)
+	// TypeCommitteeKey is the type tag for a decryption committee's public key and share keys
+	TypeCommitteeKey = uint8(5)
+	// TypeKeyShare is the type tag for a key holder's share of a committee private key
+	TypeKeyShare = uint8(6)
+
+	// maxEncodedLength is the longest byte string we'll decode, so a bad length can't make us
+	// allocate too much
+	maxEncodedLength = 1 << 24
+)
+
+// encodingMagic starts every canonical encoding, so it can't be confused with the gob encoding we
+// used before
+var encodingMagic = []byte{'o', 'c', 'x'}
+
+// headerLength is the length of the magic, version and type tag
+var headerLength = len(encodingMagic) + 2
+
+// IsCanonical returns whether or not the raw bytes start with the canonical encoding magic
+func IsCanonical(raw []byte) (canonical bool) {
+	canonical = len(raw) >= headerLength && bytes.Equal(raw[:len(encodingMagic)], encodingMagic)
+	return
+}
+
+// EncodedType returns the type tag of a canonical encoding
+func EncodedType(raw []byte) (typeTag uint8, err error) {
+	if !IsCanonical(raw) {
+		err = fmt.Errorf("Bytes are not a canonical encoding")
+		return
+	}
+
+	if raw[len(encodingMagic)] != EncodingVersion {
+		err = fmt.Errorf("Unknown encoding version %d", raw[len(encodingMagic)])
+		return
+	}
+
+	typeTag = raw[len(encodingMagic)+1]
+	return
+}
+
+// Encoder writes the canonical encoding of a value
+type Encoder struct {
+	buf bytes.Buffer
+}
+
+// NewEncoder creates an encoder and writes the header for the type
+func NewEncoder(typeTag uint8) (enc *Encoder) {
+	enc = new(Encoder)
+	enc.buf.Write(encodingMagic)
+	enc.buf.WriteByte(EncodingVersion)
+	enc.buf.WriteByte(typeTag)
+	return
+}
+
+// WriteUint8 writes a single byte
+func (enc *Encoder) WriteUint8(v uint8) {
+	enc.buf.WriteByte(v)
+	return
+}
+
+// WriteUint64 writes a big endian uint64
+func (enc *Encoder) WriteUint64(v uint64) {
+	var vBytes [8]byte
+	binary.BigEndian.PutUint64(vBytes[:], v)
+	enc.buf.Write(vBytes[:])
+	return
+}
+
+// WriteFixed writes bytes without a length, for fields that are always the same length
+func (enc *Encoder) WriteFixed(b []byte) {
+	enc.buf.Write(b)
+	return
+}
+
+// WriteBytes writes a byte string prefixed with its length
+func (enc *Encoder) WriteBytes(b []byte) (err error) {
+	if len(b) > maxEncodedLength {
+		err = fmt.Errorf("Byte string of length %d is too long to encode", len(b))
+		return
+	}
+
+	var lenBytes [4]byte
+	binary.BigEndian.PutUint32(lenBytes[:], uint32(len(b)))
+	enc.buf.Write(lenBytes[:])
+	enc.buf.Write(b)
+	return
+}
+
+// WriteBigInt writes a nonnegative big integer as its minimal big endian bytes, prefixed with
+// their length
+func (enc *Encoder) WriteBigInt(n *big.Int) (err error) {
+	if n == nil {
+		err = fmt.Errorf("Cannot encode nil big integer")
+		return
+	}
+
+	if n.Sign() < 0 {
+		err = fmt.Errorf("Cannot encode negative big integer")
+		return
+	}
+
+	err = enc.WriteBytes(n.Bytes())
+	return
+}
+
+// Bytes returns everything written to the encoder
+func (enc *Encoder) Bytes() (raw []byte) {
+	raw = enc.buf.Bytes()
+	return
+}
+
+// Decoder reads the canonical encoding of a value
+type Decoder struct {
+	raw []byte
+	pos int
+}
+
+// NewDecoder creates a decoder, checking that the header is for the type
+func NewDecoder(raw []byte, typeTag uint8) (dec *Decoder, err error) {
+	var encodedType uint8
+	if encodedType, err = EncodedType(raw); err != nil {
+		return
+	}
+
+	if encodedType != typeTag {
+		err = fmt.Errorf("Encoding is for type %d, expected type %d", encodedType, typeTag)
+		return
+	}
+
+	dec = &Decoder{
+		raw: raw,
+		pos: headerLength,
+	}
+	return
+}
+
+// next returns the next n bytes
+func (dec *Decoder) next(n int) (b []byte, err error) {
+	if n < 0 || n > len(dec.raw)-dec.pos {
+		err = fmt.Errorf("Encoding ended early, needed %d more bytes but only %d are left", n, len(dec.raw)-dec.pos)
+		return
+	}
+
+	b = dec.raw[dec.pos : dec.pos+n]
+	dec.pos += n
+	return
+}
+
+// ReadUint8 reads a single byte
+func (dec *Decoder) ReadUint8() (v uint8, err error) {
+	var b []byte
+	if b, err = dec.next(1); err != nil {
+		return
+	}
+
+	v = b[0]
+	return
+}
+
+// ReadUint64 reads a big endian uint64
+func (dec *Decoder) ReadUint64() (v uint64, err error) {
+	var b []byte
+	if b, err = dec.next(8); err != nil {
+		return
+	}
+
+	v = binary.BigEndian.Uint64(b)
+	return
+}
+
+// ReadFixed reads n bytes that were written without a length
+func (dec *Decoder) ReadFixed(n int) (b []byte, err error) {
+	var fixed []byte
+	if fixed, err = dec.next(n); err != nil {
+		return
+	}
+
+	b = append([]byte{}, fixed...)
+	return
+}
+
+// ReadBytes reads a byte string prefixed with its length
+func (dec *Decoder) ReadBytes() (b []byte, err error) {
+	var lenBytes []byte
+	if lenBytes, err = dec.next(4); err != nil {
+		return
+	}
+
+	length := binary.BigEndian.Uint32(lenBytes)
+	if length > maxEncodedLength {
+		err = fmt.Errorf("Byte string of length %d is too long to decode", length)
+		return
+	}
+
+	b, err = dec.ReadFixed(int(length))
+	return
+}
+
+// ReadBigInt reads a nonnegative big integer, which must be minimal
+func (dec *Decoder) ReadBigInt() (n *big.Int, err error) {
+	var b []byte
+	if b, err = dec.ReadBytes(); err != nil {
+		return
+	}
+
+	if len(b) > 0 && b[0] == 0 {
+		err = fmt.Errorf("Big integer has leading zeros, encoding is not canonical")
+		return
+	}
+
+	n = new(big.Int).SetBytes(b)
+	return
+}
+
+// Finish checks that everything was read, since extra bytes would make the encoding not canonical
+func (dec *Decoder) Finish() (err error) {
+	if dec.pos != len(dec.raw) {
+		err = fmt.Errorf("Encoding has %d extra bytes", len(dec.raw)-dec.pos)
+		return
+	}
+	return
+}
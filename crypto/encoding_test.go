@@ -0,0 +1,147 @@
+package crypto
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	var err error
+
+	bigNum, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	enc := NewEncoder(TypeRSWPuzzle)
+	enc.WriteUint8(7)
+	enc.WriteUint64(1 << 40)
+	enc.WriteFixed([]byte{1, 2, 3})
+	if err = enc.WriteBytes([]byte("opencx")); err != nil {
+		t.Errorf("Error writing bytes: %s", err)
+		return
+	}
+	if err = enc.WriteBigInt(bigNum); err != nil {
+		t.Errorf("Error writing big int: %s", err)
+		return
+	}
+	if err = enc.WriteBigInt(big.NewInt(0)); err != nil {
+		t.Errorf("Error writing zero: %s", err)
+		return
+	}
+	raw := enc.Bytes()
+
+	var typeTag uint8
+	if typeTag, err = EncodedType(raw); err != nil || typeTag != TypeRSWPuzzle {
+		t.Errorf("Encoded type should be %d, got %d, err %v", TypeRSWPuzzle, typeTag, err)
+		return
+	}
+
+	if _, err = NewDecoder(raw, TypeHashPuzzle); err == nil {
+		t.Errorf("Decoder should not accept the wrong type")
+		return
+	}
+
+	var dec *Decoder
+	if dec, err = NewDecoder(raw, TypeRSWPuzzle); err != nil {
+		t.Errorf("Error creating decoder: %s", err)
+		return
+	}
+
+	var small uint8
+	var large uint64
+	var fixed, str []byte
+	var num, zero *big.Int
+	if small, err = dec.ReadUint8(); err != nil || small != 7 {
+		t.Errorf("Expected 7, got %d, err %v", small, err)
+		return
+	}
+	if large, err = dec.ReadUint64(); err != nil || large != 1<<40 {
+		t.Errorf("Expected %d, got %d, err %v", uint64(1<<40), large, err)
+		return
+	}
+	if fixed, err = dec.ReadFixed(3); err != nil || !bytes.Equal(fixed, []byte{1, 2, 3}) {
+		t.Errorf("Expected fixed bytes 010203, got %x, err %v", fixed, err)
+		return
+	}
+	if str, err = dec.ReadBytes(); err != nil || string(str) != "opencx" {
+		t.Errorf("Expected opencx, got %s, err %v", str, err)
+		return
+	}
+	if num, err = dec.ReadBigInt(); err != nil || num.Cmp(bigNum) != 0 {
+		t.Errorf("Expected %s, got %s, err %v", bigNum, num, err)
+		return
+	}
+	if zero, err = dec.ReadBigInt(); err != nil || zero.Sign() != 0 {
+		t.Errorf("Expected 0, got %s, err %v", zero, err)
+		return
+	}
+	if err = dec.Finish(); err != nil {
+		t.Errorf("Decoder should have read everything: %s", err)
+		return
+	}
+
+	return
+}
+
+func TestNonCanonicalEncodings(t *testing.T) {
+	var err error
+
+	// a big integer with a leading zero
+	enc := NewEncoder(TypeRSWPuzzle)
+	if err = enc.WriteBytes([]byte{0x00, 0x01}); err != nil {
+		t.Errorf("Error writing bytes: %s", err)
+		return
+	}
+
+	var dec *Decoder
+	if dec, err = NewDecoder(enc.Bytes(), TypeRSWPuzzle); err != nil {
+		t.Errorf("Error creating decoder: %s", err)
+		return
+	}
+	if _, err = dec.ReadBigInt(); err == nil {
+		t.Errorf("Big integer with a leading zero should not decode")
+		return
+	}
+
+	// trailing bytes
+	enc = NewEncoder(TypeRSWPuzzle)
+	enc.WriteUint8(1)
+	enc.WriteUint8(2)
+	if dec, err = NewDecoder(enc.Bytes(), TypeRSWPuzzle); err != nil {
+		t.Errorf("Error creating decoder: %s", err)
+		return
+	}
+	if _, err = dec.ReadUint8(); err != nil {
+		t.Errorf("Error reading byte: %s", err)
+		return
+	}
+	if err = dec.Finish(); err == nil {
+		t.Errorf("Encoding with trailing bytes should not finish")
+		return
+	}
+
+	// a length longer than the encoding
+	enc = NewEncoder(TypeRSWPuzzle)
+	enc.WriteFixed([]byte{0x00, 0x00, 0x01, 0x00, 0xff})
+	if dec, err = NewDecoder(enc.Bytes(), TypeRSWPuzzle); err != nil {
+		t.Errorf("Error creating decoder: %s", err)
+		return
+	}
+	if _, err = dec.ReadBytes(); err == nil {
+		t.Errorf("Byte string longer than the encoding should not decode")
+		return
+	}
+
+	// a different version
+	raw := NewEncoder(TypeRSWPuzzle).Bytes()
+	raw[len(encodingMagic)] = EncodingVersion + 1
+	if _, err = NewDecoder(raw, TypeRSWPuzzle); err == nil {
+		t.Errorf("Encoding with an unknown version should not decode")
+		return
+	}
+
+	if _, err = NewDecoder([]byte("oc"), TypeRSWPuzzle); err == nil {
+		t.Errorf("Encoding shorter than the header should not decode")
+		return
+	}
+
+	return
+}
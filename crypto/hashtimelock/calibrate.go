@@ -0,0 +1,42 @@
+package hashtimelock
+
+import (
+	"fmt"
+	"hash"
+	"time"
+)
+
+// calibrationBatch is how many hashes we do between checks of the clock, so reading the clock
+// doesn't slow down what we're measuring
+const calibrationBatch = 1 << 12
+
+// Calibrate measures how many sequential hashes per second this machine can do with the hash
+// function, by hashing the same way Solve does for at least the duration. The time parameter t of
+// a hash puzzle is a number of hashes, so this is what turns t into an amount of real time.
+func Calibrate(hashFunction hash.Hash, duration time.Duration) (hashesPerSecond float64, err error) {
+	if hashFunction == nil {
+		err = fmt.Errorf("Cannot calibrate with a nil hash function")
+		return
+	}
+
+	if duration <= 0 {
+		err = fmt.Errorf("Calibration duration must be positive")
+		return
+	}
+
+	answer := make([]byte, hashFunction.Size())
+	var hashes uint64
+	start := time.Now()
+	for time.Since(start) < duration {
+		for i := 0; i < calibrationBatch; i++ {
+			hashFunction.Reset()
+			hashFunction.Write(answer[:])
+			copy(answer[:], hashFunction.Sum(nil))
+		}
+		hashes += calibrationBatch
+	}
+	hashFunction.Reset()
+
+	hashesPerSecond = float64(hashes) / time.Since(start).Seconds()
+	return
+}
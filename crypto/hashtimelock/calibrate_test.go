@@ -0,0 +1,28 @@
+package hashtimelock
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+func TestCalibrateSHA256(t *testing.T) {
+	var err error
+	var hashesPerSecond float64
+	if hashesPerSecond, err = Calibrate(sha256.New(), 20*time.Millisecond); err != nil {
+		t.Errorf("Error calibrating sha256: %s", err)
+		return
+	}
+
+	if hashesPerSecond <= 0 {
+		t.Errorf("Calibration should measure a positive rate, got %f", hashesPerSecond)
+		return
+	}
+
+	if _, err = Calibrate(nil, time.Millisecond); err == nil {
+		t.Errorf("Should not calibrate with a nil hash function")
+		return
+	}
+
+	return
+}
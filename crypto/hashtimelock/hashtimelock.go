@@ -2,7 +2,8 @@ package hashtimelock
 
 import (
 	"bytes"
-	"encoding/gob"
+	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
 	"hash"
 
@@ -63,44 +64,100 @@ func (ht *HashTimelock) Solve() (answer []byte, err error) {
 	return
 }
 
-// Serialize turns the hash timelock puzzle into something that can be sent over the wire
-func (ht *HashTimelock) Serialize() (raw []byte, err error) {
-	var b bytes.Buffer
+// hashIDProbe is what we hash to figure out which hash function a puzzle uses
+const hashIDProbe = "opencx-hash-puzzle"
+
+// hashFunctions are the hash functions that hash puzzles can be serialized with, by ID
+var hashFunctions = map[uint8]func() hash.Hash{
+	1: sha256.New,
+	2: sha512.New,
+}
 
-	// register hashTimelock interface
-	gob.Register(HashTimelock{})
+// hashID finds the ID of the puzzle's hash function, by comparing what it outputs with the
+// hash functions we know
+func (ht *HashTimelock) hashID() (id uint8, err error) {
+	if ht.hashFunction == nil {
+		err = fmt.Errorf("Hash puzzle has no hash function")
+		return
+	}
 
-	// create a new encoder writing to the buffer
-	enc := gob.NewEncoder(&b)
+	ht.hashFunction.Reset()
+	ht.hashFunction.Write([]byte(hashIDProbe))
+	probe := ht.hashFunction.Sum(nil)
+	ht.hashFunction.Reset()
 
-	// encode the puzzle in the buffer
-	if err = enc.Encode(ht); err != nil {
+	for currID, newHash := range hashFunctions {
+		knownHash := newHash()
+		knownHash.Write([]byte(hashIDProbe))
+		if bytes.Equal(probe, knownHash.Sum(nil)) {
+			id = currID
+			return
+		}
+	}
+
+	err = fmt.Errorf("Hash puzzle uses a hash function that can't be serialized")
+	return
+}
+
+// Serialize turns the hash timelock puzzle into something that can be sent over the wire, using
+// the canonical encoding
+func (ht *HashTimelock) Serialize() (raw []byte, err error) {
+	var id uint8
+	if id, err = ht.hashID(); err != nil {
 		err = fmt.Errorf("Error encoding puzzle: %s", err)
 		return
 	}
 
-	// Get the bytes from the buffer
-	raw = b.Bytes()
+	enc := crypto.NewEncoder(crypto.TypeHashPuzzle)
+	enc.WriteUint8(id)
+	if err = enc.WriteBytes(ht.timelockSeed); err != nil {
+		err = fmt.Errorf("Error encoding puzzle: %s", err)
+		return
+	}
+	enc.WriteUint64(ht.timeToRun)
 
+	raw = enc.Bytes()
 	return
 }
 
 // Deserialize turns the hash timelock puzzle into something that can be sent over the wire
 func (ht *HashTimelock) Deserialize(raw []byte) (err error) {
-	var b *bytes.Buffer
-	b = bytes.NewBuffer(raw)
+	var dec *crypto.Decoder
+	if dec, err = crypto.NewDecoder(raw, crypto.TypeHashPuzzle); err != nil {
+		err = fmt.Errorf("Error decoding puzzle: %s", err)
+		return
+	}
+
+	var id uint8
+	if id, err = dec.ReadUint8(); err != nil {
+		err = fmt.Errorf("Error decoding puzzle: %s", err)
+		return
+	}
+
+	newHash, ok := hashFunctions[id]
+	if !ok {
+		err = fmt.Errorf("Error decoding puzzle: unknown hash function %d", id)
+		return
+	}
 
-	// register hashTimelock interface
-	gob.Register(HashTimelock{})
+	var seed []byte
+	if seed, err = dec.ReadBytes(); err != nil {
+		err = fmt.Errorf("Error decoding puzzle: %s", err)
+		return
+	}
 
-	// create a new encoder writing to the buffer
-	dec := gob.NewDecoder(b)
+	var timeToRun uint64
+	if timeToRun, err = dec.ReadUint64(); err != nil {
+		err = fmt.Errorf("Error decoding puzzle: %s", err)
+		return
+	}
 
-	// encode the puzzle in the buffer
-	if err = dec.Decode(ht); err != nil {
-		err = fmt.Errorf("Error encoding puzzle: %s", err)
+	if err = dec.Finish(); err != nil {
+		err = fmt.Errorf("Error decoding puzzle: %s", err)
 		return
 	}
 
+	ht.setupHashPuzzle(seed, newHash())
+	ht.timeToRun = timeToRun
 	return
 }
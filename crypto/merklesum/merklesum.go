@@ -0,0 +1,250 @@
+// Package merklesum is a Merkle sum tree over user balances, which an exchange can publish the
+// root of as a proof of liabilities. Every node commits to the sum of the balances below it, and
+// both child sums are hashed into their parent, so a user who checks their inclusion proof knows
+// their balance is counted in the total at the root. Leaves only contain H(pubkey || nonce), so
+// the tree doesn't reveal who has an account.
+package merklesum
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	// nonceSize is the size of the nonce that hides the pubkey in each leaf
+	nonceSize = 32
+
+	// domain separation for the different types of nodes
+	leafPrefix  = byte(0x00)
+	innerPrefix = byte(0x01)
+	emptyPrefix = byte(0x02)
+)
+
+// Node is a node in the tree, with the sum of all of the balances below it
+type Node struct {
+	Hash [32]byte
+	Sum  uint64
+}
+
+// Balance is how much the exchange owes a user
+type Balance struct {
+	PubKey  []byte
+	Balance uint64
+}
+
+// leaf is a user's balance in the tree, along with the nonce that hides their pubkey
+type leaf struct {
+	pubkey  []byte
+	nonce   []byte
+	balance uint64
+	cid     [32]byte
+}
+
+// InclusionProof proves that a user's balance is included in the tree. The siblings go from the
+// bottom of the tree to the top, and bit k of the index is 1 if we are the right child at level k.
+type InclusionProof struct {
+	Nonce    []byte
+	Balance  uint64
+	Index    uint64
+	Siblings []Node
+}
+
+// Tree is a Merkle sum tree
+type Tree struct {
+	levels [][]Node
+	leaves []*leaf
+	// indexes maps a pubkey to the index of its leaf
+	indexes map[string]int
+}
+
+// customerID computes CID = H(pubkey || nonce)
+func customerID(pubkey []byte, nonce []byte) (cid [32]byte) {
+	cid = sha256.Sum256(append(append([]byte{}, pubkey...), nonce...))
+	return
+}
+
+// leafNode computes the node for a leaf, H(0x00 || CID || balance)
+func leafNode(cid [32]byte, balance uint64) (node Node) {
+	balBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(balBytes, balance)
+
+	hasher := sha256.New()
+	hasher.Write([]byte{leafPrefix})
+	hasher.Write(cid[:])
+	hasher.Write(balBytes)
+	copy(node.Hash[:], hasher.Sum(nil))
+	node.Sum = balance
+	return
+}
+
+// emptyNode is the node used to pad the tree to a power of two
+func emptyNode() (node Node) {
+	node.Hash = sha256.Sum256([]byte{emptyPrefix})
+	return
+}
+
+// parentNode computes H(0x01 || left hash || left sum || right hash || right sum), with the sum of
+// both children
+func parentNode(left Node, right Node) (parent Node, err error) {
+	if left.Sum+right.Sum < left.Sum {
+		err = fmt.Errorf("Sum of children overflows")
+		return
+	}
+
+	sumBytes := make([]byte, 8)
+	hasher := sha256.New()
+	hasher.Write([]byte{innerPrefix})
+	hasher.Write(left.Hash[:])
+	binary.BigEndian.PutUint64(sumBytes, left.Sum)
+	hasher.Write(sumBytes)
+	hasher.Write(right.Hash[:])
+	binary.BigEndian.PutUint64(sumBytes, right.Sum)
+	hasher.Write(sumBytes)
+	copy(parent.Hash[:], hasher.Sum(nil))
+	parent.Sum = left.Sum + right.Sum
+	return
+}
+
+// NewTree creates a Merkle sum tree over the balances. Every user gets a fresh nonce, and leaves
+// are sorted by CID so their position doesn't leak anything either.
+func NewTree(balances []*Balance) (tree *Tree, err error) {
+	tree = &Tree{
+		indexes: make(map[string]int),
+	}
+
+	for _, bal := range balances {
+		if _, exists := tree.indexes[string(bal.PubKey)]; exists {
+			err = fmt.Errorf("Duplicate pubkey %x in balances for merkle sum tree", bal.PubKey)
+			return
+		}
+		tree.indexes[string(bal.PubKey)] = 0
+
+		currLeaf := &leaf{
+			pubkey:  bal.PubKey,
+			nonce:   make([]byte, nonceSize),
+			balance: bal.Balance,
+		}
+		if _, err = rand.Read(currLeaf.nonce); err != nil {
+			err = fmt.Errorf("Error getting nonce for merkle sum tree leaf: %s", err)
+			return
+		}
+		currLeaf.cid = customerID(currLeaf.pubkey, currLeaf.nonce)
+		tree.leaves = append(tree.leaves, currLeaf)
+	}
+
+	sort.Slice(tree.leaves, func(i, j int) bool {
+		return bytes.Compare(tree.leaves[i].cid[:], tree.leaves[j].cid[:]) < 0
+	})
+
+	// pad to a power of two, with at least one leaf
+	width := 1
+	for width < len(tree.leaves) {
+		width *= 2
+	}
+
+	bottom := make([]Node, width)
+	for i := range bottom {
+		if i < len(tree.leaves) {
+			bottom[i] = leafNode(tree.leaves[i].cid, tree.leaves[i].balance)
+			tree.indexes[string(tree.leaves[i].pubkey)] = i
+		} else {
+			bottom[i] = emptyNode()
+		}
+	}
+	tree.levels = append(tree.levels, bottom)
+
+	for len(tree.levels[len(tree.levels)-1]) > 1 {
+		prev := tree.levels[len(tree.levels)-1]
+		next := make([]Node, len(prev)/2)
+		for i := range next {
+			if next[i], err = parentNode(prev[2*i], prev[2*i+1]); err != nil {
+				err = fmt.Errorf("Error computing merkle sum tree: %s", err)
+				return
+			}
+		}
+		tree.levels = append(tree.levels, next)
+	}
+
+	return
+}
+
+// Root returns the root of the tree, whose sum is the total of every balance
+func (tree *Tree) Root() (root Node) {
+	root = tree.levels[len(tree.levels)-1][0]
+	return
+}
+
+// NumLeaves returns the number of balances in the tree
+func (tree *Tree) NumLeaves() (numLeaves uint64) {
+	numLeaves = uint64(len(tree.leaves))
+	return
+}
+
+// Proof returns the inclusion proof for a pubkey's balance
+func (tree *Tree) Proof(pubkey []byte) (proof *InclusionProof, err error) {
+	index, found := tree.indexes[string(pubkey)]
+	if !found {
+		err = fmt.Errorf("Pubkey %x is not in the merkle sum tree", pubkey)
+		return
+	}
+
+	proof = &InclusionProof{
+		Nonce:   tree.leaves[index].nonce,
+		Balance: tree.leaves[index].balance,
+		Index:   uint64(index),
+	}
+
+	pos := index
+	for _, level := range tree.levels[:len(tree.levels)-1] {
+		proof.Siblings = append(proof.Siblings, level[pos^1])
+		pos /= 2
+	}
+
+	return
+}
+
+// Root computes the root of the tree from the proof and the pubkey it is for
+func (proof *InclusionProof) Root(pubkey []byte) (root Node, err error) {
+	if proof == nil {
+		err = fmt.Errorf("Inclusion proof cannot be nil")
+		return
+	}
+	if len(proof.Siblings) < 64 && proof.Index >= uint64(1)<<uint(len(proof.Siblings)) {
+		err = fmt.Errorf("Inclusion proof index is too big for the number of siblings")
+		return
+	}
+
+	root = leafNode(customerID(pubkey, proof.Nonce), proof.Balance)
+	for k, sibling := range proof.Siblings {
+		if (proof.Index>>uint(k))&1 == 1 {
+			root, err = parentNode(sibling, root)
+		} else {
+			root, err = parentNode(root, sibling)
+		}
+		if err != nil {
+			err = fmt.Errorf("Error computing root from inclusion proof: %s", err)
+			return
+		}
+	}
+
+	return
+}
+
+// Verify checks that the proof shows the pubkey's balance is included in the tree with the root
+func (proof *InclusionProof) Verify(pubkey []byte, root Node) (err error) {
+	var computed Node
+	if computed, err = proof.Root(pubkey); err != nil {
+		return
+	}
+
+	if computed != root {
+		err = fmt.Errorf("Inclusion proof does not match root, got %x with sum %d, expected %x with sum %d", computed.Hash, computed.Sum, root.Hash, root.Sum)
+		return
+	}
+
+	return
+}
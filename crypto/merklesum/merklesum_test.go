@@ -0,0 +1,102 @@
+package merklesum
+
+import (
+	"fmt"
+	"testing"
+)
+
+func createTestBalances(n int) (balances []*Balance) {
+	for i := 0; i < n; i++ {
+		balances = append(balances, &Balance{
+			PubKey:  []byte(fmt.Sprintf("user%d", i)),
+			Balance: uint64(i * 100),
+		})
+	}
+	return
+}
+
+func TestInclusionProofs(t *testing.T) {
+	var err error
+	for _, n := range []int{1, 2, 3, 7, 16} {
+		balances := createTestBalances(n)
+
+		var tree *Tree
+		if tree, err = NewTree(balances); err != nil {
+			t.Errorf("Error creating tree with %d balances: %s", n, err)
+			return
+		}
+
+		var total uint64
+		for _, bal := range balances {
+			total += bal.Balance
+		}
+		if tree.Root().Sum != total {
+			t.Errorf("Root sum %d does not equal total %d", tree.Root().Sum, total)
+			return
+		}
+
+		for _, bal := range balances {
+			var proof *InclusionProof
+			if proof, err = tree.Proof(bal.PubKey); err != nil {
+				t.Errorf("Error getting proof for %s: %s", bal.PubKey, err)
+				return
+			}
+
+			if err = proof.Verify(bal.PubKey, tree.Root()); err != nil {
+				t.Errorf("Valid proof for %s in tree of %d did not verify: %s", bal.PubKey, n, err)
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func TestTamperedProofs(t *testing.T) {
+	var err error
+	balances := createTestBalances(5)
+
+	var tree *Tree
+	if tree, err = NewTree(balances); err != nil {
+		t.Errorf("Error creating tree: %s", err)
+		return
+	}
+
+	var proof *InclusionProof
+	if proof, err = tree.Proof(balances[3].PubKey); err != nil {
+		t.Errorf("Error getting proof: %s", err)
+		return
+	}
+
+	if err = proof.Verify(balances[2].PubKey, tree.Root()); err == nil {
+		t.Errorf("Proof should not verify for a different pubkey")
+		return
+	}
+
+	proof.Balance++
+	if err = proof.Verify(balances[3].PubKey, tree.Root()); err == nil {
+		t.Errorf("Proof with changed balance should not verify")
+		return
+	}
+	proof.Balance--
+
+	// Moving balance from a sibling into ours keeps the root sum the same, but not the hash
+	proof.Siblings[0].Sum -= 100
+	proof.Balance += 100
+	if err = proof.Verify(balances[3].PubKey, tree.Root()); err == nil {
+		t.Errorf("Proof with changed sibling sum should not verify")
+		return
+	}
+
+	if _, err = tree.Proof([]byte("nobody")); err == nil {
+		t.Errorf("Should not get a proof for a pubkey that isn't in the tree")
+		return
+	}
+
+	if _, err = NewTree(append(balances, balances[0])); err == nil {
+		t.Errorf("Should not create a tree with duplicate pubkeys")
+		return
+	}
+
+	return
+}
@@ -2,51 +2,103 @@ package provisions
 
 import (
 	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"math/big"
 	"sync"
-
-	"github.com/mit-dci/zksigma"
 )
 
+// AnonSetEntry is a single public key in the anonymity set PK from the paper, along with the
+// balance the blockchain says it holds. If we own the key then PrivKey is set, and s_i = 1.
+type AnonSetEntry struct {
+	PubKey  *ecdsa.PublicKey
+	Balance uint64
+	PrivKey *ecdsa.PrivateKey
+}
+
+// AssetProofEntry is the public part of one iteration of the proof of assets protocol. The
+// verifier knows y_i and bal_i from the blockchain, and gets the commitments p_i = b_i^(s_i) h^(v_i)
+// and l_i = y_i^(s_i) h^(t_i), a proof that s_i is 0 or 1, and the responses to the challenge.
+type AssetProofEntry struct {
+	PubKey  *Point
+	Balance uint64
+	P       *Point
+	L       *Point
+	S       *BitProof
+	C       *big.Int
+	RS      *big.Int
+	RV      *big.Int
+	RT      *big.Int
+	RX      *big.Int
+}
+
+// AssetProof is the proof of assets. The commitment to the total assets is the sum of all p_i.
+type AssetProof struct {
+	Entries []*AssetProofEntry
+}
+
 // BalProofMachine represents a single iteration of the proof of assets protocol, and is
 // used to compute individual balance commitments, as well as calculate things like
 // responses to challenges
 type BalProofMachine struct {
+	params *Params
+	// random values for the first move
 	u1 *big.Int
 	u2 *big.Int
 	u3 *big.Int
 	u4 *big.Int
-	ci *big.Int
+	// secrets for this iteration
+	si *big.Int
+	vi *big.Int
 	ti *big.Int
 	xi *big.Int
+	// challenge
+	ci *big.Int
 }
 
 // NewBalProofMachine creates a new balance proof machine
-func NewBalProofMachine(curve elliptic.Curve) (machine *BalProofMachine, err error) {
-	order := curve.Params().P
-	if machine.u1, err = rand.Int(rand.Reader, order); err != nil {
+func NewBalProofMachine(params *Params) (machine *BalProofMachine, err error) {
+	if params == nil {
+		err = fmt.Errorf("Cannot create balance proof machine with nil params")
+		return
+	}
+
+	machine = &BalProofMachine{
+		params: params,
+		si:     big.NewInt(0),
+		xi:     big.NewInt(0),
+	}
+
+	if machine.u1, err = params.randScalar(); err != nil {
 		err = fmt.Errorf("Error getting random u_1 for balance proof machine: %s", err)
 		return
 	}
 
-	if machine.u2, err = rand.Int(rand.Reader, order); err != nil {
+	if machine.u2, err = params.randScalar(); err != nil {
 		err = fmt.Errorf("Error getting random u_2 for balance proof machine: %s", err)
 		return
 	}
 
-	if machine.u3, err = rand.Int(rand.Reader, order); err != nil {
+	if machine.u3, err = params.randScalar(); err != nil {
 		err = fmt.Errorf("Error getting random u_3 for balance proof machine: %s", err)
 		return
 	}
 
-	if machine.u4, err = rand.Int(rand.Reader, order); err != nil {
+	if machine.u4, err = params.randScalar(); err != nil {
 		err = fmt.Errorf("Error getting random u_4 for balance proof machine: %s", err)
 		return
 	}
 
+	if machine.vi, err = params.randScalar(); err != nil {
+		err = fmt.Errorf("Error getting random v_i for balance proof machine: %s", err)
+		return
+	}
+
+	if machine.ti, err = params.randScalar(); err != nil {
+		err = fmt.Errorf("Error getting random t_i for balance proof machine: %s", err)
+		return
+	}
+
 	return
 }
 
@@ -60,63 +112,296 @@ func (machine *BalProofMachine) SetChallenge(ci *big.Int) (err error) {
 	return
 }
 
-// SetPrivKey sets the private key for this iteration
+// SetPrivKey sets the private key for this iteration, which means s_i = 1
 func (machine *BalProofMachine) SetPrivKey(privkey *ecdsa.PrivateKey) (err error) {
 	if privkey == nil {
 		err = fmt.Errorf("Private key cannot be nil")
 		return
 	}
+	machine.si = big.NewInt(1)
 	machine.xi = privkey.D
 	return
 }
 
-// SResponse generates the response r_(s_i) with the balance proof machine and si (s_i). The challenge must be set.
-func (machine *BalProofMachine) SResponse(si bool) (rs *big.Int, err error) {
+// response computes u + c*secret mod n
+func (machine *BalProofMachine) response(u *big.Int, secret *big.Int) (r *big.Int, err error) {
 	if machine.ci == nil {
 		err = fmt.Errorf("Cannot generate a response to a challenge if the challenge has not been set")
 		return
 	}
 
-	if si {
-		rs = new(big.Int).Add(machine.u1, machine.ci)
-	} else {
-		rs = new(big.Int).Set(machine.u1)
+	r = new(big.Int).Mul(machine.ci, secret)
+	r.Add(r, u)
+	r.Mod(r, machine.params.Curve.Params().N)
+	return
+}
+
+// SResponse generates the response r_(s_i). The challenge must be set.
+func (machine *BalProofMachine) SResponse() (rs *big.Int, err error) {
+	rs, err = machine.response(machine.u1, machine.si)
+	return
+}
+
+// VResponse generates the response r_(v_i). The challenge must be set.
+func (machine *BalProofMachine) VResponse() (rv *big.Int, err error) {
+	rv, err = machine.response(machine.u2, machine.vi)
+	return
+}
+
+// TResponse generates the response r_(t_i). The challenge must be set.
+func (machine *BalProofMachine) TResponse() (rt *big.Int, err error) {
+	rt, err = machine.response(machine.u3, machine.ti)
+	return
+}
+
+// XResponse generates the response r_(x_i) for x^_i = s_i * x_i. The challenge must be set.
+func (machine *BalProofMachine) XResponse() (rx *big.Int, err error) {
+	xHat := new(big.Int).Mul(machine.si, machine.xi)
+	rx, err = machine.response(machine.u4, xHat)
+	return
+}
+
+// balanceBase returns b_i = g^(bal_i)
+func (params *Params) balanceBase(balance uint64) (base *Point) {
+	base = params.mul(params.G, new(big.Int).SetUint64(balance))
+	return
+}
+
+// assetChallenge is the Fiat-Shamir challenge for one iteration of the proof of assets
+func (params *Params) assetChallenge(balance uint64, pubkey, p, l, a1, a2, a3 *Point) (c *big.Int) {
+	balBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(balBytes, balance)
+	c = params.challenge("assets", balBytes, pubkey, p, l, a1, a2, a3)
+	return
+}
+
+// prove runs one iteration of the proof of assets for the public key and balance
+func (machine *BalProofMachine) prove(pubkey *Point, balance uint64) (entry *AssetProofEntry, err error) {
+	params := machine.params
+	bi := params.balanceBase(balance)
+
+	entry = &AssetProofEntry{
+		PubKey:  pubkey,
+		Balance: balance,
+		P:       params.add(params.mul(bi, machine.si), params.mul(params.H, machine.vi)),
+		L:       params.add(params.mul(pubkey, machine.si), params.mul(params.H, machine.ti)),
+	}
+
+	if entry.S, err = params.proveBit(bi, machine.si.Sign() != 0, machine.vi); err != nil {
+		err = fmt.Errorf("Error proving s_i is a bit: %s", err)
+		return
+	}
+
+	a1 := params.add(params.mul(bi, machine.u1), params.mul(params.H, machine.u2))
+	a2 := params.add(params.mul(pubkey, machine.u1), params.mul(params.H, machine.u3))
+	a3 := params.add(params.mul(params.G, machine.u4), params.mul(params.H, machine.u3))
+
+	entry.C = params.assetChallenge(balance, pubkey, entry.P, entry.L, a1, a2, a3)
+	if err = machine.SetChallenge(entry.C); err != nil {
+		return
+	}
+
+	if entry.RS, err = machine.SResponse(); err != nil {
+		return
+	}
+	if entry.RV, err = machine.VResponse(); err != nil {
+		return
+	}
+	if entry.RT, err = machine.TResponse(); err != nil {
+		return
+	}
+	if entry.RX, err = machine.XResponse(); err != nil {
+		return
 	}
 
 	return
 }
 
+// verifyAssetEntry checks one iteration of the proof of assets
+func (params *Params) verifyAssetEntry(entry *AssetProofEntry) (err error) {
+	if entry == nil {
+		err = fmt.Errorf("Asset proof entry cannot be nil")
+		return
+	}
+	if !params.valid(entry.PubKey) || entry.PubKey.isIdentity() || !params.valid(entry.P) || !params.valid(entry.L) {
+		err = fmt.Errorf("Asset proof entry has invalid points")
+		return
+	}
+	if entry.C == nil || entry.RS == nil || entry.RV == nil || entry.RT == nil || entry.RX == nil {
+		err = fmt.Errorf("Asset proof entry is missing challenge or responses")
+		return
+	}
+
+	bi := params.balanceBase(entry.Balance)
+
+	if err = params.verifyBit(bi, entry.S); err != nil {
+		err = fmt.Errorf("Error verifying s_i is a bit: %s", err)
+		return
+	}
+	if !entry.S.Commitment.Equal(entry.P) {
+		err = fmt.Errorf("Bit proof for s_i is not for p_i")
+		return
+	}
+
+	// a1 = b_i^(r_s) h^(r_v) p_i^(-c)
+	a1 := params.sub(params.add(params.mul(bi, entry.RS), params.mul(params.H, entry.RV)), params.mul(entry.P, entry.C))
+	// a2 = y_i^(r_s) h^(r_t) l_i^(-c)
+	a2 := params.sub(params.add(params.mul(entry.PubKey, entry.RS), params.mul(params.H, entry.RT)), params.mul(entry.L, entry.C))
+	// a3 = g^(r_x) h^(r_t) l_i^(-c)
+	a3 := params.sub(params.add(params.mul(params.G, entry.RX), params.mul(params.H, entry.RT)), params.mul(entry.L, entry.C))
+
+	if params.assetChallenge(entry.Balance, entry.PubKey, entry.P, entry.L, a1, a2, a3).Cmp(entry.C) != 0 {
+		err = fmt.Errorf("Asset proof entry challenge does not match")
+		return
+	}
+
+	return
+}
+
+// Verify verifies every iteration of the proof of assets. The anonymity set must not have
+// duplicate keys, otherwise the same balance could be counted twice.
+func (proof *AssetProof) Verify(params *Params) (err error) {
+	if proof == nil || len(proof.Entries) == 0 {
+		err = fmt.Errorf("Asset proof has no entries")
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i, entry := range proof.Entries {
+		if err = params.verifyAssetEntry(entry); err != nil {
+			err = fmt.Errorf("Error verifying asset proof entry %d: %s", i, err)
+			return
+		}
+
+		key := string(params.bytes(entry.PubKey))
+		if seen[key] {
+			err = fmt.Errorf("Asset proof has duplicate public key at entry %d", i)
+			return
+		}
+		seen[key] = true
+	}
+
+	return
+}
+
+// CheckBalances checks every key in the proof against the balance it has on chain, keyed by
+// compressed pubkey. Verify only shows the proof is consistent with the balances in it, so the
+// proof says nothing about the exchange's assets until this passes. Any key in the proof that's
+// missing from chainBalances, or has a different balance, is an error.
+func (proof *AssetProof) CheckBalances(params *Params, chainBalances map[[33]byte]uint64) (err error) {
+	if proof == nil || len(proof.Entries) == 0 {
+		err = fmt.Errorf("Asset proof has no entries")
+		return
+	}
+
+	for i, entry := range proof.Entries {
+		if entry.PubKey == nil {
+			err = fmt.Errorf("Asset proof entry %d has no public key", i)
+			return
+		}
+
+		compressed := params.compressed(entry.PubKey)
+		if len(compressed) != 33 {
+			err = fmt.Errorf("Asset proof entry %d has a %d byte public key, should be 33", i, len(compressed))
+			return
+		}
+
+		var pubkey [33]byte
+		copy(pubkey[:], compressed)
+		chainBalance, found := chainBalances[pubkey]
+		if !found {
+			err = fmt.Errorf("No chain balance given for key %x in the anonymity set", pubkey)
+			return
+		}
+
+		if entry.Balance != chainBalance {
+			err = fmt.Errorf("Key %x has balance %d in the proof, but %d on chain", pubkey, entry.Balance, chainBalance)
+			return
+		}
+	}
+
+	return
+}
+
+// Commitment returns Z_Assets, the commitment to the total assets
+func (proof *AssetProof) Commitment(params *Params) (commitment *Point) {
+	commitment = identity()
+	for _, entry := range proof.Entries {
+		commitment = params.add(commitment, entry.P)
+	}
+	return
+}
+
 // AssetsProofMachine is the state machine that is used to create a privacy preserving proof of assets
 type AssetsProofMachine struct {
-	curve elliptic.Curve
-	// This is the anonymity set PK in the paper, !(privkey == nil) => s_i = 0
-	// We don't use a privkey set because we are going to want to index by pubkey a lot
-	PubKeyAnonSet    map[*ecdsa.PublicKey]*ecdsa.PrivateKey
-	pkAnonSetMutex   *sync.Mutex
-	BalanceRetreiver func(pubkey *ecdsa.PublicKey) (balance uint64, err error)
+	params *Params
+	// This is the anonymity set PK in the paper, !(privkey == nil) => s_i = 1
+	anonSet        []*AnonSetEntry
+	pkAnonSetMutex *sync.Mutex
+	// the total assets and the blinding factor of Z_Assets, set by Prove
+	totalAssets   uint64
+	assetBlinding *big.Int
 }
 
-// NewAssetsProofMachine creates a new state machine for the asset proof. TODO: Use the anonymity set choosing from
-// the paper to initialize
-func NewAssetsProofMachine(curve elliptic.Curve) (machine *AssetsProofMachine, err error) {
+// NewAssetsProofMachine creates a new state machine for the asset proof. The anonymity set starts
+// out empty, add keys we own with AddOwnedKey and keys we don't own with AddAnonKey.
+func NewAssetsProofMachine(params *Params) (machine *AssetsProofMachine, err error) {
+	if params == nil {
+		err = fmt.Errorf("Cannot create assets proof machine with nil params")
+		return
+	}
 
 	machine = &AssetsProofMachine{
-		curve:            curve,
-		PubKeyAnonSet:    make(map[*ecdsa.PublicKey]*ecdsa.PrivateKey),
-		pkAnonSetMutex:   new(sync.Mutex),
-		BalanceRetreiver: bal,
+		params:         params,
+		pkAnonSetMutex: new(sync.Mutex),
 	}
 
 	return
 }
 
-// bal is supposed to find the balance of a pubkey. I'm going to assume that all addresses have 10 bitcoin (1000000000)
-// in them for now. TODO: Make bal() work in a production environment
-func bal(pubkey *ecdsa.PublicKey) (bal uint64, err error) {
+// addEntry adds an entry to the anonymity set, making sure the key is not already in it
+func (machine *AssetsProofMachine) addEntry(entry *AnonSetEntry) (err error) {
+	if entry.PubKey == nil || !machine.params.Curve.IsOnCurve(entry.PubKey.X, entry.PubKey.Y) {
+		err = fmt.Errorf("Public key for anonymity set is not on the curve")
+		return
+	}
 
-	// all pubkeys have 10 btc -- we measure in satoshis
-	bal = 1000000000
+	machine.pkAnonSetMutex.Lock()
+	for _, existing := range machine.anonSet {
+		if existing.PubKey.X.Cmp(entry.PubKey.X) == 0 && existing.PubKey.Y.Cmp(entry.PubKey.Y) == 0 {
+			machine.pkAnonSetMutex.Unlock()
+			err = fmt.Errorf("Public key is already in the anonymity set")
+			return
+		}
+	}
+	machine.anonSet = append(machine.anonSet, entry)
+	machine.pkAnonSetMutex.Unlock()
+
+	return
+}
+
+// AddOwnedKey adds a key that the exchange owns to the anonymity set, with its balance on chain
+func (machine *AssetsProofMachine) AddOwnedKey(privkey *ecdsa.PrivateKey, balance uint64) (err error) {
+	if privkey == nil {
+		err = fmt.Errorf("Private key cannot be nil")
+		return
+	}
 
+	err = machine.addEntry(&AnonSetEntry{
+		PubKey:  &privkey.PublicKey,
+		Balance: balance,
+		PrivKey: privkey,
+	})
+	return
+}
+
+// AddAnonKey adds a key that the exchange does not own to the anonymity set, with its balance on
+// chain. These hide which keys the exchange owns.
+func (machine *AssetsProofMachine) AddAnonKey(pubkey *ecdsa.PublicKey, balance uint64) (err error) {
+	err = machine.addEntry(&AnonSetEntry{
+		PubKey:  pubkey,
+		Balance: balance,
+	})
 	return
 }
 
@@ -124,17 +409,16 @@ func bal(pubkey *ecdsa.PublicKey) (bal uint64, err error) {
 func (machine *AssetsProofMachine) calculateAssets() (totalAssets uint64, err error) {
 
 	machine.pkAnonSetMutex.Lock()
-	for pub, priv := range machine.PubKeyAnonSet {
+	for _, entry := range machine.anonSet {
 		// priv == nil => s_i == 0
 		// so if priv != nil then add to the total assets
-		if priv != nil {
-			var currBal uint64
-			if currBal, err = machine.BalanceRetreiver(pub); err != nil {
+		if entry.PrivKey != nil {
+			if totalAssets+entry.Balance < totalAssets {
 				machine.pkAnonSetMutex.Unlock()
-				err = fmt.Errorf("Error getting balance of pubkey while calulating assets: %s", err)
+				err = fmt.Errorf("Total assets overflow")
 				return
 			}
-			totalAssets += currBal
+			totalAssets += entry.Balance
 		}
 		// otherwise don't add anything
 	}
@@ -144,10 +428,59 @@ func (machine *AssetsProofMachine) calculateAssets() (totalAssets uint64, err er
 	return
 }
 
-// CalculateAssetCommitment calculates the commitment to the Z_Assets to Assets
-// Are we sure on returning an ECPoint?
-func (machine *AssetsProofMachine) CalculateAssetCommitment() (assetCommitment *zksigma.ECPoint, err error) {
+// Prove runs the proof of assets over the anonymity set.
+func (machine *AssetsProofMachine) Prove() (proof *AssetProof, err error) {
+	if machine.totalAssets, err = machine.calculateAssets(); err != nil {
+		return
+	}
+
+	n := machine.params.Curve.Params().N
+	machine.pkAnonSetMutex.Lock()
+	if len(machine.anonSet) == 0 {
+		machine.pkAnonSetMutex.Unlock()
+		err = fmt.Errorf("Cannot prove assets with an empty anonymity set")
+		return
+	}
+
+	proof = &AssetProof{Entries: make([]*AssetProofEntry, len(machine.anonSet))}
+	machine.assetBlinding = big.NewInt(0)
+	for i, setEntry := range machine.anonSet {
+		var balMachine *BalProofMachine
+		if balMachine, err = NewBalProofMachine(machine.params); err != nil {
+			machine.pkAnonSetMutex.Unlock()
+			return
+		}
+
+		if setEntry.PrivKey != nil {
+			if err = balMachine.SetPrivKey(setEntry.PrivKey); err != nil {
+				machine.pkAnonSetMutex.Unlock()
+				return
+			}
+		}
+
+		pubkey := &Point{X: setEntry.PubKey.X, Y: setEntry.PubKey.Y}
+		if proof.Entries[i], err = balMachine.prove(pubkey, setEntry.Balance); err != nil {
+			machine.pkAnonSetMutex.Unlock()
+			err = fmt.Errorf("Error proving asset entry %d: %s", i, err)
+			return
+		}
+
+		machine.assetBlinding.Add(machine.assetBlinding, balMachine.vi)
+		machine.assetBlinding.Mod(machine.assetBlinding, n)
+	}
+	machine.pkAnonSetMutex.Unlock()
+
+	return
+}
+
+// CalculateAssetCommitment calculates the commitment Z_Assets = g^(Assets) h^(sum v_i) to the
+// total assets. Prove must be called first.
+func (machine *AssetsProofMachine) CalculateAssetCommitment() (assetCommitment *Point, err error) {
+	if machine.assetBlinding == nil {
+		err = fmt.Errorf("Cannot calculate asset commitment before proving assets")
+		return
+	}
 
-	// TODO
+	assetCommitment = machine.params.commit(new(big.Int).SetUint64(machine.totalAssets), machine.assetBlinding)
 	return
 }
@@ -0,0 +1,165 @@
+package provisions
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// cidNonceSize is the size of the nonce that the customer ID is derived from
+const cidNonceSize = 32
+
+// CustomerBalance is how much the exchange owes a customer, from the settlement store
+type CustomerBalance struct {
+	PubKey  []byte
+	Balance uint64
+}
+
+// LiabilityEntry is the public commitment to a single customer's balance. The CID is H(pubkey ||
+// nonce), so only the customer can find their own entry.
+type LiabilityEntry struct {
+	CID        []byte
+	Commitment *Point
+	Range      *RangeProof
+}
+
+// LiabilityProof is the proof of liabilities. The commitment to the total liabilities is the sum
+// of all of the entries' commitments.
+type LiabilityProof struct {
+	Entries []*LiabilityEntry
+}
+
+// CustomerOpening is sent privately to each customer, so they can check that their balance was
+// included in the proof of liabilities.
+type CustomerOpening struct {
+	PubKey   []byte
+	Nonce    []byte
+	Balance  uint64
+	Blinding *big.Int
+}
+
+// customerID computes CID = H(pubkey || nonce)
+func customerID(pubkey []byte, nonce []byte) (cid []byte) {
+	hash := sha256.Sum256(append(append([]byte{}, pubkey...), nonce...))
+	cid = hash[:]
+	return
+}
+
+// ProveLiabilities commits to every customer balance and proves that each one is in range, so
+// negative balances can't be used to lower the total. It returns the openings for each customer
+// and the blinding factor of Z_Liabilities.
+func ProveLiabilities(params *Params, customers []*CustomerBalance) (proof *LiabilityProof, openings []*CustomerOpening, totalLiabilities uint64, liabilityBlinding *big.Int, err error) {
+	if params == nil {
+		err = fmt.Errorf("Cannot prove liabilities with nil params")
+		return
+	}
+
+	n := params.Curve.Params().N
+	proof = &LiabilityProof{Entries: make([]*LiabilityEntry, len(customers))}
+	openings = make([]*CustomerOpening, len(customers))
+	liabilityBlinding = big.NewInt(0)
+	for i, customer := range customers {
+		if totalLiabilities+customer.Balance < totalLiabilities {
+			err = fmt.Errorf("Total liabilities overflow")
+			return
+		}
+		totalLiabilities += customer.Balance
+
+		opening := &CustomerOpening{
+			PubKey:  customer.PubKey,
+			Nonce:   make([]byte, cidNonceSize),
+			Balance: customer.Balance,
+		}
+		if _, err = rand.Read(opening.Nonce); err != nil {
+			err = fmt.Errorf("Error getting nonce for customer ID: %s", err)
+			return
+		}
+		if opening.Blinding, err = params.randScalar(); err != nil {
+			return
+		}
+
+		entry := &LiabilityEntry{
+			CID:        customerID(customer.PubKey, opening.Nonce),
+			Commitment: params.commit(new(big.Int).SetUint64(customer.Balance), opening.Blinding),
+		}
+		if entry.Range, err = params.proveRange(customer.Balance, opening.Blinding, RangeBits); err != nil {
+			err = fmt.Errorf("Error proving range of customer balance: %s", err)
+			return
+		}
+
+		proof.Entries[i] = entry
+		openings[i] = opening
+		liabilityBlinding.Add(liabilityBlinding, opening.Blinding)
+		liabilityBlinding.Mod(liabilityBlinding, n)
+	}
+
+	return
+}
+
+// Verify verifies the range proof for every customer balance
+func (proof *LiabilityProof) Verify(params *Params) (err error) {
+	if proof == nil {
+		err = fmt.Errorf("Liability proof cannot be nil")
+		return
+	}
+
+	seen := make(map[string]bool)
+	for i, entry := range proof.Entries {
+		if entry == nil || !params.valid(entry.Commitment) {
+			err = fmt.Errorf("Liability entry %d has invalid commitment", i)
+			return
+		}
+
+		if err = params.verifyRange(entry.Range, RangeBits); err != nil {
+			err = fmt.Errorf("Error verifying range proof of liability entry %d: %s", i, err)
+			return
+		}
+
+		if !entry.Range.Commitment(params).Equal(entry.Commitment) {
+			err = fmt.Errorf("Range proof of liability entry %d is not for its commitment", i)
+			return
+		}
+
+		if seen[string(entry.CID)] {
+			err = fmt.Errorf("Liability proof has duplicate customer ID at entry %d", i)
+			return
+		}
+		seen[string(entry.CID)] = true
+	}
+
+	return
+}
+
+// Commitment returns Z_Liabilities, the commitment to the total liabilities
+func (proof *LiabilityProof) Commitment(params *Params) (commitment *Point) {
+	commitment = identity()
+	for _, entry := range proof.Entries {
+		commitment = params.add(commitment, entry.Commitment)
+	}
+	return
+}
+
+// Verify is run by a customer to check that their balance is in the proof of liabilities
+func (opening *CustomerOpening) Verify(params *Params, proof *LiabilityProof) (err error) {
+	if opening == nil || opening.Blinding == nil {
+		err = fmt.Errorf("Customer opening is missing blinding factor")
+		return
+	}
+
+	cid := customerID(opening.PubKey, opening.Nonce)
+	expected := params.commit(new(big.Int).SetUint64(opening.Balance), opening.Blinding)
+	for _, entry := range proof.Entries {
+		if entry != nil && bytes.Equal(entry.CID, cid) {
+			if !expected.Equal(entry.Commitment) {
+				err = fmt.Errorf("Commitment for customer does not match their balance")
+				return
+			}
+			return
+		}
+	}
+
+	err = fmt.Errorf("Customer is not included in the liability proof")
+	return
+}
@@ -0,0 +1,239 @@
+// Package provisions is an implementation of Provisions, the privacy preserving proof of solvency
+// for Bitcoin exchanges from DBBCB15. The exchange proves that the assets it controls on chain are
+// at least the sum of the balances it owes its customers, without revealing which addresses it
+// owns, how much it owns, or any customer balances.
+package provisions
+
+import (
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// hGeneratorSeed is hashed to find the second generator h, so nobody knows log_g(h)
+const hGeneratorSeed = "opencx-provisions-h"
+
+// Point is a point on the curve. The point at infinity is represented as (0, 0), like the
+// crypto/elliptic package does.
+type Point struct {
+	X *big.Int
+	Y *big.Int
+}
+
+// Params holds the curve and the two generators g and h used for Pedersen commitments. Nobody
+// knows the discrete log of h with respect to g.
+type Params struct {
+	Curve elliptic.Curve
+	G     *Point
+	H     *Point
+}
+
+// NewParams creates the parameters for a curve. The second generator is found by hashing to the
+// curve, so anyone can check that it was chosen fairly.
+func NewParams(curve elliptic.Curve) (params *Params, err error) {
+	if curve == nil {
+		err = fmt.Errorf("Cannot create provisions params with nil curve")
+		return
+	}
+
+	params = &Params{
+		Curve: curve,
+		G: &Point{
+			X: new(big.Int).Set(curve.Params().Gx),
+			Y: new(big.Int).Set(curve.Params().Gy),
+		},
+	}
+
+	if params.H, err = hashToCurve(curve, []byte(hGeneratorSeed)); err != nil {
+		err = fmt.Errorf("Error finding second generator for provisions params: %s", err)
+		return
+	}
+
+	return
+}
+
+// curveA finds the a coefficient of y^2 = x^3 + ax + b for the curve. The elliptic package only
+// knows about curves with a = -3, but secp256k1 has a = 0, so we check which one the base point
+// is on.
+func curveA(curve elliptic.Curve) (a *big.Int, err error) {
+	cp := curve.Params()
+	ySquared := new(big.Int).Exp(cp.Gy, big.NewInt(2), cp.P)
+	for _, candidate := range []int64{0, -3} {
+		a = big.NewInt(candidate)
+		if rhs(cp, a, cp.Gx).Cmp(ySquared) == 0 {
+			return
+		}
+	}
+
+	err = fmt.Errorf("Curve %s has an a coefficient that isn't 0 or -3", cp.Name)
+	return
+}
+
+// rhs computes x^3 + ax + b (mod p)
+func rhs(cp *elliptic.CurveParams, a *big.Int, x *big.Int) (res *big.Int) {
+	res = new(big.Int).Exp(x, big.NewInt(3), cp.P)
+	res.Add(res, new(big.Int).Mul(a, x))
+	res.Add(res, cp.B)
+	res.Mod(res, cp.P)
+	return
+}
+
+// hashToCurve finds a point on the curve by hashing the seed with a counter until the hash is the
+// x coordinate of a point.
+func hashToCurve(curve elliptic.Curve, seed []byte) (point *Point, err error) {
+	var a *big.Int
+	if a, err = curveA(curve); err != nil {
+		return
+	}
+
+	cp := curve.Params()
+	ctrBytes := make([]byte, 4)
+	for ctr := uint32(0); ctr < 1000; ctr++ {
+		binary.BigEndian.PutUint32(ctrBytes, ctr)
+		hash := sha256.Sum256(append(append([]byte{}, seed...), ctrBytes...))
+		x := new(big.Int).Mod(new(big.Int).SetBytes(hash[:]), cp.P)
+
+		y := new(big.Int).ModSqrt(rhs(cp, a, x), cp.P)
+		if y == nil || !curve.IsOnCurve(x, y) {
+			continue
+		}
+
+		point = &Point{X: x, Y: y}
+		return
+	}
+
+	err = fmt.Errorf("Could not hash to curve %s", cp.Name)
+	return
+}
+
+// identity returns the point at infinity
+func identity() (point *Point) {
+	point = &Point{X: new(big.Int), Y: new(big.Int)}
+	return
+}
+
+// isIdentity returns whether or not the point is the point at infinity
+func (p *Point) isIdentity() bool {
+	return p.X.Sign() == 0 && p.Y.Sign() == 0
+}
+
+// Equal returns whether or not two points are the same
+func (p *Point) Equal(q *Point) bool {
+	if p == nil || q == nil || p.X == nil || p.Y == nil || q.X == nil || q.Y == nil {
+		return false
+	}
+	return p.X.Cmp(q.X) == 0 && p.Y.Cmp(q.Y) == 0
+}
+
+// valid returns whether or not the point is on the curve or the point at infinity
+func (params *Params) valid(p *Point) bool {
+	if p == nil || p.X == nil || p.Y == nil {
+		return false
+	}
+	return p.isIdentity() || params.Curve.IsOnCurve(p.X, p.Y)
+}
+
+// add returns p + q
+func (params *Params) add(p *Point, q *Point) (sum *Point) {
+	if p.isIdentity() {
+		sum = &Point{X: new(big.Int).Set(q.X), Y: new(big.Int).Set(q.Y)}
+		return
+	}
+	if q.isIdentity() {
+		sum = &Point{X: new(big.Int).Set(p.X), Y: new(big.Int).Set(p.Y)}
+		return
+	}
+	// p + -p is the identity, which not every curve implementation handles
+	if p.X.Cmp(q.X) == 0 && p.Y.Cmp(q.Y) != 0 {
+		sum = identity()
+		return
+	}
+	if p.Equal(q) {
+		x, y := params.Curve.Double(p.X, p.Y)
+		sum = &Point{X: x, Y: y}
+		return
+	}
+	x, y := params.Curve.Add(p.X, p.Y, q.X, q.Y)
+	sum = &Point{X: x, Y: y}
+	return
+}
+
+// neg returns -p
+func (params *Params) neg(p *Point) (negP *Point) {
+	if p.isIdentity() {
+		negP = identity()
+		return
+	}
+	negP = &Point{
+		X: new(big.Int).Set(p.X),
+		Y: new(big.Int).Sub(params.Curve.Params().P, p.Y),
+	}
+	return
+}
+
+// sub returns p - q
+func (params *Params) sub(p *Point, q *Point) (diff *Point) {
+	diff = params.add(p, params.neg(q))
+	return
+}
+
+// mul returns kp
+func (params *Params) mul(p *Point, k *big.Int) (prod *Point) {
+	kModN := new(big.Int).Mod(k, params.Curve.Params().N)
+	if p.isIdentity() || kModN.Sign() == 0 {
+		prod = identity()
+		return
+	}
+	x, y := params.Curve.ScalarMult(p.X, p.Y, kModN.Bytes())
+	prod = &Point{X: x, Y: y}
+	return
+}
+
+// commit returns the Pedersen commitment g^value h^blinding, or value*G + blinding*H in additive
+// notation.
+func (params *Params) commit(value *big.Int, blinding *big.Int) (commitment *Point) {
+	commitment = params.add(params.mul(params.G, value), params.mul(params.H, blinding))
+	return
+}
+
+// bytes serializes the point with both coordinates padded to the size of the field
+func (params *Params) bytes(p *Point) (buf []byte) {
+	size := (params.Curve.Params().BitSize + 7) / 8
+	buf = make([]byte, 2*size)
+	xBytes := p.X.Bytes()
+	yBytes := p.Y.Bytes()
+	copy(buf[size-len(xBytes):size], xBytes)
+	copy(buf[2*size-len(yBytes):], yBytes)
+	return
+}
+
+// compressed serializes the point like a compressed public key, with a prefix for the sign of y and
+// then x padded to the size of the field
+func (params *Params) compressed(p *Point) (buf []byte) {
+	size := (params.Curve.Params().BitSize + 7) / 8
+	buf = make([]byte, 1+size)
+	buf[0] = 0x02
+	if p.Y.Bit(0) == 1 {
+		buf[0] = 0x03
+	}
+	xBytes := p.X.Bytes()
+	copy(buf[1+size-len(xBytes):], xBytes)
+	return
+}
+
+// challenge hashes a domain tag and points to get a challenge, for the Fiat-Shamir transform of
+// the sigma protocols in the paper.
+func (params *Params) challenge(tag string, extra []byte, points ...*Point) (c *big.Int) {
+	hasher := sha256.New()
+	hasher.Write([]byte(tag))
+	hasher.Write(extra)
+	hasher.Write(params.bytes(params.G))
+	hasher.Write(params.bytes(params.H))
+	for _, p := range points {
+		hasher.Write(params.bytes(p))
+	}
+	c = new(big.Int).Mod(new(big.Int).SetBytes(hasher.Sum(nil)), params.Curve.Params().N)
+	return
+}
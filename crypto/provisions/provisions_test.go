@@ -0,0 +1,214 @@
+package provisions
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func createTestMachine(t *testing.T, owned []uint64, anon []uint64) (params *Params, machine *AssetsProofMachine) {
+	var err error
+	if params, err = NewParams(elliptic.P256()); err != nil {
+		t.Fatalf("Error creating params: %s", err)
+	}
+
+	if machine, err = NewAssetsProofMachine(params); err != nil {
+		t.Fatalf("Error creating assets proof machine: %s", err)
+	}
+
+	for _, balance := range owned {
+		var priv *ecdsa.PrivateKey
+		if priv, err = ecdsa.GenerateKey(params.Curve, rand.Reader); err != nil {
+			t.Fatalf("Error generating key: %s", err)
+		}
+		if err = machine.AddOwnedKey(priv, balance); err != nil {
+			t.Fatalf("Error adding owned key: %s", err)
+		}
+	}
+
+	for _, balance := range anon {
+		var priv *ecdsa.PrivateKey
+		if priv, err = ecdsa.GenerateKey(params.Curve, rand.Reader); err != nil {
+			t.Fatalf("Error generating key: %s", err)
+		}
+		if err = machine.AddAnonKey(&priv.PublicKey, balance); err != nil {
+			t.Fatalf("Error adding anon key: %s", err)
+		}
+	}
+
+	return
+}
+
+func TestRangeProof(t *testing.T) {
+	var err error
+	var params *Params
+	if params, err = NewParams(elliptic.P256()); err != nil {
+		t.Errorf("Error creating params: %s", err)
+		return
+	}
+
+	blinding := big.NewInt(12345)
+	var proof *RangeProof
+	if proof, err = params.proveRange(1000, blinding, RangeBits); err != nil {
+		t.Errorf("Error proving range: %s", err)
+		return
+	}
+
+	if err = params.verifyRange(proof, RangeBits); err != nil {
+		t.Errorf("Valid range proof did not verify: %s", err)
+		return
+	}
+
+	if !proof.Commitment(params).Equal(params.commit(big.NewInt(1000), blinding)) {
+		t.Errorf("Range proof is not for the commitment with the blinding we asked for")
+		return
+	}
+
+	// Swap the commitment of one bit for a commitment to 2
+	proof.Bits[3].Commitment = params.commit(big.NewInt(2), big.NewInt(1))
+	if err = params.verifyRange(proof, RangeBits); err == nil {
+		t.Errorf("Range proof with a bit that isn't 0 or 1 should not verify")
+		return
+	}
+
+	return
+}
+
+func TestProveSolvency(t *testing.T) {
+	var err error
+	params, machine := createTestMachine(t, []uint64{500, 700}, []uint64{1000, 0, 300})
+
+	customers := []*CustomerBalance{
+		{PubKey: []byte("alice"), Balance: 600},
+		{PubKey: []byte("bob"), Balance: 550},
+		{PubKey: []byte("carol"), Balance: 0},
+	}
+
+	var proof *SolvencyProof
+	var openings []*CustomerOpening
+	if proof, openings, err = ProveSolvency(machine, customers); err != nil {
+		t.Errorf("Error proving solvency: %s", err)
+		return
+	}
+
+	if err = proof.Verify(params); err != nil {
+		t.Errorf("Valid solvency proof did not verify: %s", err)
+		return
+	}
+
+	var assetCommitment *Point
+	if assetCommitment, err = machine.CalculateAssetCommitment(); err != nil {
+		t.Errorf("Error calculating asset commitment: %s", err)
+		return
+	}
+	if !assetCommitment.Equal(proof.Assets.Commitment(params)) {
+		t.Errorf("Asset commitment does not match the proof of assets")
+		return
+	}
+
+	for i, opening := range openings {
+		if err = opening.Verify(params, proof.Liabilities); err != nil {
+			t.Errorf("Customer %d could not verify their balance: %s", i, err)
+			return
+		}
+	}
+
+	// A customer who was told the wrong balance should notice
+	openings[0].Balance = 1
+	if err = openings[0].Verify(params, proof.Liabilities); err == nil {
+		t.Errorf("Customer with wrong balance should not verify")
+		return
+	}
+
+	// Claiming a key that we don't own should break the proof of assets
+	proof.Assets.Entries[2].Balance = 2000
+	if err = proof.Verify(params); err == nil {
+		t.Errorf("Proof with a changed balance should not verify")
+		return
+	}
+
+	return
+}
+
+func TestProveInsolvent(t *testing.T) {
+	var err error
+	_, machine := createTestMachine(t, []uint64{100}, []uint64{5000})
+
+	customers := []*CustomerBalance{
+		{PubKey: []byte("alice"), Balance: 200},
+	}
+
+	if _, _, err = ProveSolvency(machine, customers); err == nil {
+		t.Errorf("Should not be able to prove solvency when liabilities are more than assets")
+		return
+	}
+
+	return
+}
+
+func TestDroppedLiabilityDoesNotVerify(t *testing.T) {
+	var err error
+	params, machine := createTestMachine(t, []uint64{1000}, []uint64{1000})
+
+	customers := []*CustomerBalance{
+		{PubKey: []byte("alice"), Balance: 600},
+		{PubKey: []byte("bob"), Balance: 300},
+	}
+
+	var proof *SolvencyProof
+	if proof, _, err = ProveSolvency(machine, customers); err != nil {
+		t.Errorf("Error proving solvency: %s", err)
+		return
+	}
+
+	proof.Liabilities.Entries = proof.Liabilities.Entries[:1]
+	if err = proof.Verify(params); err == nil {
+		t.Errorf("Proof with a dropped liability should not verify")
+		return
+	}
+
+	return
+}
+
+// TestCheckBalances makes sure the proof of assets is only accepted if every key in it has the
+// balance it has on chain
+func TestCheckBalances(t *testing.T) {
+	var err error
+	params, machine := createTestMachine(t, []uint64{500}, []uint64{1000, 300})
+
+	var proof *AssetProof
+	if proof, err = machine.Prove(); err != nil {
+		t.Errorf("Error proving assets: %s", err)
+		return
+	}
+
+	chainBalances := make(map[[33]byte]uint64)
+	var lastKey [33]byte
+	for _, entry := range proof.Entries {
+		copy(lastKey[:], params.compressed(entry.PubKey))
+		chainBalances[lastKey] = entry.Balance
+	}
+
+	if err = proof.CheckBalances(params, chainBalances); err != nil {
+		t.Errorf("Proof with the chain balances should pass: %s", err)
+		return
+	}
+
+	// A key that has less on chain than the exchange claims
+	chainBalances[lastKey]--
+	if err = proof.CheckBalances(params, chainBalances); err == nil {
+		t.Errorf("Proof with a balance that isn't on chain should not pass")
+		return
+	}
+
+	// A key we don't know the chain balance of
+	delete(chainBalances, lastKey)
+	if err = proof.CheckBalances(params, chainBalances); err == nil {
+		t.Errorf("Proof with a key we have no chain balance for should not pass")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,181 @@
+package provisions
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// RangeBits is the number of bits that balances and the surplus are proven to fit in
+const RangeBits = 64
+
+// BitProof proves that Commitment = base^b h^r for b either 0 or 1, without revealing b. It's the
+// Cramer-Damgard-Schoenmakers OR of two Schnorr proofs of knowledge of r. The first move of each
+// branch is recomputed by the verifier from the challenges and responses.
+type BitProof struct {
+	Commitment *Point
+	C0         *big.Int
+	C1         *big.Int
+	Z0         *big.Int
+	Z1         *big.Int
+}
+
+// RangeProof proves that a commitment g^v h^r has 0 <= v < 2^len(Bits), by committing to every bit
+// of v. The commitment is sum 2^k Bits[k].Commitment.
+type RangeProof struct {
+	Bits []*BitProof
+}
+
+// randScalar returns a random number mod the order of the curve
+func (params *Params) randScalar() (scalar *big.Int, err error) {
+	if scalar, err = rand.Int(rand.Reader, params.Curve.Params().N); err != nil {
+		err = fmt.Errorf("Error getting random scalar: %s", err)
+		return
+	}
+	return
+}
+
+// proveBit creates a proof that commitment = base^bit h^blinding with bit either 0 or 1
+func (params *Params) proveBit(base *Point, bit bool, blinding *big.Int) (proof *BitProof, err error) {
+	n := params.Curve.Params().N
+
+	bitInt := big.NewInt(0)
+	if bit {
+		bitInt = big.NewInt(1)
+	}
+	commitment := params.add(params.mul(base, bitInt), params.mul(params.H, blinding))
+
+	// The simulated branch is the one we aren't proving
+	var cSim, zSim, k *big.Int
+	if cSim, err = params.randScalar(); err != nil {
+		return
+	}
+	if zSim, err = params.randScalar(); err != nil {
+		return
+	}
+	if k, err = params.randScalar(); err != nil {
+		return
+	}
+
+	// branch 0 proves commitment = h^r, branch 1 proves commitment - base = h^r
+	targets := []*Point{commitment, params.sub(commitment, base)}
+	realIdx, simIdx := 0, 1
+	if bit {
+		realIdx, simIdx = 1, 0
+	}
+
+	firstMoves := make([]*Point, 2)
+	firstMoves[realIdx] = params.mul(params.H, k)
+	firstMoves[simIdx] = params.sub(params.mul(params.H, zSim), params.mul(targets[simIdx], cSim))
+
+	c := params.challenge("bit", nil, base, commitment, firstMoves[0], firstMoves[1])
+	cReal := new(big.Int).Sub(c, cSim)
+	cReal.Mod(cReal, n)
+	zReal := new(big.Int).Mul(cReal, blinding)
+	zReal.Add(zReal, k)
+	zReal.Mod(zReal, n)
+
+	proof = &BitProof{Commitment: commitment}
+	if bit {
+		proof.C0, proof.Z0 = cSim, zSim
+		proof.C1, proof.Z1 = cReal, zReal
+	} else {
+		proof.C0, proof.Z0 = cReal, zReal
+		proof.C1, proof.Z1 = cSim, zSim
+	}
+
+	return
+}
+
+// verifyBit verifies that the proof commits to either 0 or 1 with the base
+func (params *Params) verifyBit(base *Point, proof *BitProof) (err error) {
+	if proof == nil || !params.valid(proof.Commitment) {
+		err = fmt.Errorf("Bit proof has invalid commitment")
+		return
+	}
+	if proof.C0 == nil || proof.C1 == nil || proof.Z0 == nil || proof.Z1 == nil {
+		err = fmt.Errorf("Bit proof is missing challenges or responses")
+		return
+	}
+
+	first0 := params.sub(params.mul(params.H, proof.Z0), params.mul(proof.Commitment, proof.C0))
+	first1 := params.sub(params.mul(params.H, proof.Z1), params.mul(params.sub(proof.Commitment, base), proof.C1))
+
+	c := params.challenge("bit", nil, base, proof.Commitment, first0, first1)
+	cSum := new(big.Int).Add(proof.C0, proof.C1)
+	cSum.Mod(cSum, params.Curve.Params().N)
+	if cSum.Cmp(c) != 0 {
+		err = fmt.Errorf("Bit proof challenges do not match")
+		return
+	}
+
+	return
+}
+
+// proveRange creates a range proof for g^value h^blinding. The blinding of every bit is random
+// except the last one, which is picked so the bits add up to the blinding we were given. That lets
+// us prove things about commitments whose blinding is already fixed, like the surplus.
+func (params *Params) proveRange(value uint64, blinding *big.Int, numBits int) (proof *RangeProof, err error) {
+	if numBits <= 0 || numBits > 64 {
+		err = fmt.Errorf("Cannot create range proof with %d bits", numBits)
+		return
+	}
+	if numBits < 64 && value >= uint64(1)<<uint(numBits) {
+		err = fmt.Errorf("Value does not fit in %d bits", numBits)
+		return
+	}
+
+	n := params.Curve.Params().N
+	blindings := make([]*big.Int, numBits)
+	remaining := new(big.Int).Set(blinding)
+	for k := 0; k < numBits-1; k++ {
+		if blindings[k], err = params.randScalar(); err != nil {
+			return
+		}
+		weighted := new(big.Int).Lsh(blindings[k], uint(k))
+		remaining.Sub(remaining, weighted)
+	}
+	// r_(m-1) = (r - sum 2^k r_k) / 2^(m-1)
+	lastWeight := new(big.Int).Lsh(big.NewInt(1), uint(numBits-1))
+	lastWeight.ModInverse(lastWeight, n)
+	blindings[numBits-1] = remaining.Mul(remaining, lastWeight)
+	blindings[numBits-1].Mod(blindings[numBits-1], n)
+
+	proof = &RangeProof{Bits: make([]*BitProof, numBits)}
+	for k := 0; k < numBits; k++ {
+		bit := (value>>uint(k))&1 == 1
+		if proof.Bits[k], err = params.proveBit(params.G, bit, blindings[k]); err != nil {
+			err = fmt.Errorf("Error proving bit %d of range proof: %s", k, err)
+			return
+		}
+	}
+
+	return
+}
+
+// Commitment returns the commitment that the range proof is for
+func (proof *RangeProof) Commitment(params *Params) (commitment *Point) {
+	commitment = identity()
+	for k, bitProof := range proof.Bits {
+		weight := new(big.Int).Lsh(big.NewInt(1), uint(k))
+		commitment = params.add(commitment, params.mul(bitProof.Commitment, weight))
+	}
+	return
+}
+
+// verifyRange verifies that the range proof has numBits bits and that every bit is 0 or 1
+func (params *Params) verifyRange(proof *RangeProof, numBits int) (err error) {
+	if proof == nil || len(proof.Bits) != numBits {
+		err = fmt.Errorf("Range proof should have %d bits", numBits)
+		return
+	}
+
+	for k, bitProof := range proof.Bits {
+		if err = params.verifyBit(params.G, bitProof); err != nil {
+			err = fmt.Errorf("Error verifying bit %d of range proof: %s", k, err)
+			return
+		}
+	}
+
+	return
+}
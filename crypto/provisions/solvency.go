@@ -0,0 +1,84 @@
+package provisions
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// SolvencyProof is the full Provisions proof. The surplus range proof is for Z_Assets -
+// Z_Liabilities, which shows that the assets are at least the liabilities.
+type SolvencyProof struct {
+	Assets      *AssetProof
+	Liabilities *LiabilityProof
+	Surplus     *RangeProof
+}
+
+// ProveSolvency runs the proof of assets over the anonymity set in the machine, the proof of
+// liabilities over the customer balances, and proves that the difference is not negative. The
+// openings should be sent privately to each customer.
+func ProveSolvency(machine *AssetsProofMachine, customers []*CustomerBalance) (proof *SolvencyProof, openings []*CustomerOpening, err error) {
+	if machine == nil {
+		err = fmt.Errorf("Cannot prove solvency with nil assets proof machine")
+		return
+	}
+	params := machine.params
+
+	proof = new(SolvencyProof)
+	if proof.Assets, err = machine.Prove(); err != nil {
+		err = fmt.Errorf("Error proving assets for solvency: %s", err)
+		return
+	}
+
+	var totalLiabilities uint64
+	var liabilityBlinding *big.Int
+	if proof.Liabilities, openings, totalLiabilities, liabilityBlinding, err = ProveLiabilities(params, customers); err != nil {
+		err = fmt.Errorf("Error proving liabilities for solvency: %s", err)
+		return
+	}
+
+	if machine.totalAssets < totalLiabilities {
+		err = fmt.Errorf("Exchange is not solvent, assets %d are less than liabilities %d", machine.totalAssets, totalLiabilities)
+		return
+	}
+
+	surplusBlinding := new(big.Int).Sub(machine.assetBlinding, liabilityBlinding)
+	surplusBlinding.Mod(surplusBlinding, params.Curve.Params().N)
+	if proof.Surplus, err = params.proveRange(machine.totalAssets-totalLiabilities, surplusBlinding, RangeBits); err != nil {
+		err = fmt.Errorf("Error proving range of surplus for solvency: %s", err)
+		return
+	}
+
+	return
+}
+
+// Verify checks the proof of assets, the proof of liabilities, and that the surplus is a
+// commitment to a non negative number.
+func (proof *SolvencyProof) Verify(params *Params) (err error) {
+	if proof == nil {
+		err = fmt.Errorf("Solvency proof cannot be nil")
+		return
+	}
+
+	if err = proof.Assets.Verify(params); err != nil {
+		err = fmt.Errorf("Error verifying proof of assets: %s", err)
+		return
+	}
+
+	if err = proof.Liabilities.Verify(params); err != nil {
+		err = fmt.Errorf("Error verifying proof of liabilities: %s", err)
+		return
+	}
+
+	if err = params.verifyRange(proof.Surplus, RangeBits); err != nil {
+		err = fmt.Errorf("Error verifying surplus range proof: %s", err)
+		return
+	}
+
+	surplus := params.sub(proof.Assets.Commitment(params), proof.Liabilities.Commitment(params))
+	if !proof.Surplus.Commitment(params).Equal(surplus) {
+		err = fmt.Errorf("Surplus range proof is not for Z_Assets - Z_Liabilities")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,153 @@
+package rsw
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// BatchResult is the result of solving one of the puzzles passed to SolveBatch
+type BatchResult struct {
+	// Index is the index of the puzzle in the slice passed to SolveBatch
+	Index  int
+	Answer []byte
+	// Y is A^(2^T) mod N, which a proof can be made from with ProveContext
+	Y   *big.Int
+	Err error
+}
+
+// batchDeque is the puzzles one worker has left to solve. The worker takes puzzles from the front,
+// and workers that run out of puzzles steal from the back.
+type batchDeque struct {
+	items []int
+	mtx   sync.Mutex
+}
+
+// popFront takes the next puzzle for the worker that owns the deque
+func (bd *batchDeque) popFront() (item int, ok bool) {
+	bd.mtx.Lock()
+	if len(bd.items) == 0 {
+		bd.mtx.Unlock()
+		return
+	}
+	item = bd.items[0]
+	bd.items = bd.items[1:]
+	ok = true
+	bd.mtx.Unlock()
+	return
+}
+
+// popBack steals the last puzzle for another worker
+func (bd *batchDeque) popBack() (item int, ok bool) {
+	bd.mtx.Lock()
+	if len(bd.items) == 0 {
+		bd.mtx.Unlock()
+		return
+	}
+	item = bd.items[len(bd.items)-1]
+	bd.items = bd.items[:len(bd.items)-1]
+	ok = true
+	bd.mtx.Unlock()
+	return
+}
+
+// puzzleKey identifies the part of a puzzle that determines the squarings, so puzzles that only
+// differ in CK can share a solution
+func (pz *PuzzleRSW) puzzleKey() (key string) {
+	key = pz.N.Text(16) + ":" + pz.A.Text(16) + ":" + pz.T.Text(16)
+	return
+}
+
+// SolveBatch solves many puzzles at once on the given number of workers, and sends every result on
+// the returned channel as soon as it's done. The channel is closed once every puzzle has a result.
+// No proofs are made, since that would double the work, but each result has the Y a proof can be
+// made from later with ProveContext. Puzzles with the same N, A and T have the same squarings, so
+// they are only solved once. Each worker starts with its share of the puzzles, and steals from the
+// others when it runs out, so no core sits idle while there are puzzles left. If the context is
+// cancelled, puzzles that haven't been solved get an error.
+func SolveBatch(ctx context.Context, puzzles []*PuzzleRSW, workers int) (results chan *BatchResult) {
+	results = make(chan *BatchResult, len(puzzles))
+
+	// group the puzzles that share squarings, and fail the ones we can't solve at all
+	var groups [][]int
+	groupIndex := make(map[string]int)
+	for i, pz := range puzzles {
+		if pz == nil || pz.N == nil || pz.A == nil || pz.T == nil || pz.CK == nil {
+			results <- &BatchResult{
+				Index: i,
+				Err:   fmt.Errorf("Puzzle is missing N, A, T, or CK"),
+			}
+			continue
+		}
+
+		key := pz.puzzleKey()
+		if g, ok := groupIndex[key]; ok {
+			groups[g] = append(groups[g], i)
+			continue
+		}
+		groupIndex[key] = len(groups)
+		groups = append(groups, []int{i})
+	}
+
+	if workers > len(groups) {
+		workers = len(groups)
+	}
+	if workers < 1 {
+		close(results)
+		return
+	}
+
+	// deal the groups out to the workers
+	deques := make([]*batchDeque, workers)
+	for w := range deques {
+		deques[w] = new(batchDeque)
+	}
+	for g := range groups {
+		deques[g%workers].items = append(deques[g%workers].items, g)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			solveBatchWorker(ctx, w, deques, groups, puzzles, results)
+			wg.Done()
+		}(w)
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return
+}
+
+// solveBatchWorker solves groups of puzzles from its own deque, then steals from the other
+// workers' deques, until there's nothing left to solve.
+func solveBatchWorker(ctx context.Context, w int, deques []*batchDeque, groups [][]int, puzzles []*PuzzleRSW, results chan *BatchResult) {
+	for {
+		g, ok := deques[w].popFront()
+		for i := 1; !ok && i < len(deques); i++ {
+			g, ok = deques[(w+i)%len(deques)].popBack()
+		}
+		if !ok {
+			return
+		}
+
+		// the squarings are the same for every puzzle in the group, only the answer differs
+		y, err := puzzles[groups[g][0]].SquareContext(ctx)
+		for _, idx := range groups[g] {
+			result := &BatchResult{
+				Index: idx,
+				Err:   err,
+			}
+			if err == nil {
+				result.Answer = puzzles[idx].answerFromY(y)
+				result.Y = y
+			}
+			results <- result
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package rsw
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+// createBatchPuzzles creates n puzzles over the shared RSA-2048 modulus, each with its own key
+func createBatchPuzzles(n int, t uint64) (puzzles []*PuzzleRSW, answers [][]byte, err error) {
+	var params *SharedParams
+	if params, err = NewRSA2048SharedParams(t); err != nil {
+		return
+	}
+
+	for i := 0; i < n; i++ {
+		key := make([]byte, 16)
+		key[0] = byte(i + 1)
+
+		var timelock crypto.Timelock
+		if timelock, err = NewShared(key, params); err != nil {
+			return
+		}
+
+		var puzzle crypto.Puzzle
+		var answer []byte
+		if puzzle, answer, err = timelock.SetupTimelockPuzzle(t); err != nil {
+			return
+		}
+		puzzles = append(puzzles, puzzle.(*PuzzleRSW))
+		answers = append(answers, answer)
+	}
+	return
+}
+
+func TestSolveBatch(t *testing.T) {
+	var err error
+
+	var puzzles []*PuzzleRSW
+	var answers [][]byte
+	if puzzles, answers, err = createBatchPuzzles(5, 1000); err != nil {
+		t.Errorf("Error creating puzzles: %s", err)
+		return
+	}
+
+	// the same puzzle twice shares its squarings, and a nil puzzle fails on its own
+	puzzles = append(puzzles, puzzles[0], nil)
+	answers = append(answers, answers[0], nil)
+
+	seen := make([]bool, len(puzzles))
+	for result := range SolveBatch(context.Background(), puzzles, 3) {
+		if seen[result.Index] {
+			t.Errorf("Got more than one result for puzzle %d", result.Index)
+			return
+		}
+		seen[result.Index] = true
+
+		if puzzles[result.Index] == nil {
+			if result.Err == nil {
+				t.Errorf("Nil puzzle should have an error")
+				return
+			}
+			continue
+		}
+
+		if result.Err != nil {
+			t.Errorf("Error solving puzzle %d in batch: %s", result.Index, result.Err)
+			return
+		}
+
+		if !bytes.Equal(result.Answer, answers[result.Index]) {
+			t.Errorf("Answer for puzzle %d was %x, expected %x", result.Index, result.Answer, answers[result.Index])
+			return
+		}
+
+		var proof *VDFProof
+		if proof, err = puzzles[result.Index].ProveContext(context.Background(), result.Y); err != nil {
+			t.Errorf("Error creating proof for puzzle %d: %s", result.Index, err)
+			return
+		}
+		if _, err = puzzles[result.Index].VerifyProof(proof); err != nil {
+			t.Errorf("Proof for puzzle %d did not verify: %s", result.Index, err)
+			return
+		}
+	}
+
+	for i, ok := range seen {
+		if !ok {
+			t.Errorf("Never got a result for puzzle %d", i)
+			return
+		}
+	}
+
+	return
+}
+
+func TestSolveBatchCancelled(t *testing.T) {
+	var err error
+
+	var puzzles []*PuzzleRSW
+	if puzzles, _, err = createBatchPuzzles(3, 1000); err != nil {
+		t.Errorf("Error creating puzzles: %s", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var numResults int
+	for result := range SolveBatch(ctx, puzzles, 2) {
+		numResults++
+		if result.Err == nil {
+			t.Errorf("Puzzle %d should not be solved after the batch is cancelled", result.Index)
+			return
+		}
+	}
+
+	if numResults != len(puzzles) {
+		t.Errorf("Expected %d results from cancelled batch, got %d", len(puzzles), numResults)
+		return
+	}
+
+	return
+}
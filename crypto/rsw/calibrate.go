@@ -0,0 +1,107 @@
+package rsw
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	gmpbig "github.com/Rjected/gmp"
+)
+
+// calibrationBatch is how many squarings we do between checks of the clock, so reading the clock
+// doesn't slow down what we're measuring
+const calibrationBatch = 1 << 10
+
+// Calibration is how many modular squarings per second this machine can do, with both the
+// big.Int and GMP solvers. The time parameter t is a number of squarings, so this is what turns t
+// into an amount of real time.
+type Calibration struct {
+	ModulusBits           int
+	BigSquaringsPerSecond float64
+	GMPSquaringsPerSecond float64
+}
+
+// CalibrateBig measures how many squarings mod n per second math/big can do, by squaring for at
+// least the duration.
+func CalibrateBig(n *big.Int, duration time.Duration) (squaringsPerSecond float64, err error) {
+	if n == nil || n.Sign() <= 0 {
+		err = fmt.Errorf("Cannot calibrate with a modulus that isn't positive")
+		return
+	}
+
+	if duration <= 0 {
+		err = fmt.Errorf("Calibration duration must be positive")
+		return
+	}
+
+	x := big.NewInt(2)
+	var squarings uint64
+	start := time.Now()
+	for time.Since(start) < duration {
+		for i := 0; i < calibrationBatch; i++ {
+			x.Mul(x, x)
+			x.Mod(x, n)
+		}
+		squarings += calibrationBatch
+	}
+
+	squaringsPerSecond = float64(squarings) / time.Since(start).Seconds()
+	return
+}
+
+// CalibrateGMP measures how many squarings mod n per second GMP can do, by squaring for at least
+// the duration. This is the path Solve uses.
+func CalibrateGMP(n *big.Int, duration time.Duration) (squaringsPerSecond float64, err error) {
+	if n == nil || n.Sign() <= 0 {
+		err = fmt.Errorf("Cannot calibrate with a modulus that isn't positive")
+		return
+	}
+
+	if duration <= 0 {
+		err = fmt.Errorf("Calibration duration must be positive")
+		return
+	}
+
+	gmpn := new(gmpbig.Int).SetBytes(n.Bytes())
+	batch := gmpbig.NewInt(calibrationBatch)
+	x := gmpbig.NewInt(2)
+	var squarings uint64
+	start := time.Now()
+	for time.Since(start) < duration {
+		x.ExpSquare(x, batch, gmpn)
+		squarings += calibrationBatch
+	}
+
+	squaringsPerSecond = float64(squarings) / time.Since(start).Seconds()
+	return
+}
+
+// Calibrate measures both solvers with the RSA-2048 modulus, which is the size clients create
+// puzzles with. Each solver is run for the duration.
+func Calibrate(duration time.Duration) (calibration *Calibration, err error) {
+	calibration = &Calibration{
+		ModulusBits: RSA2048.BitLen(),
+	}
+
+	if calibration.BigSquaringsPerSecond, err = CalibrateBig(RSA2048, duration); err != nil {
+		err = fmt.Errorf("Error calibrating big.Int squarings: %s", err)
+		return
+	}
+
+	if calibration.GMPSquaringsPerSecond, err = CalibrateGMP(RSA2048, duration); err != nil {
+		err = fmt.Errorf("Error calibrating GMP squarings: %s", err)
+		return
+	}
+
+	return
+}
+
+// SquaringsPerSecond returns the rate of the fastest solver, since that's how fast puzzles can
+// actually be solved on this machine
+func (cal *Calibration) SquaringsPerSecond() (squaringsPerSecond float64) {
+	squaringsPerSecond = cal.GMPSquaringsPerSecond
+	if cal.BigSquaringsPerSecond > squaringsPerSecond {
+		squaringsPerSecond = cal.BigSquaringsPerSecond
+	}
+	return
+}
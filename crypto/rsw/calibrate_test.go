@@ -0,0 +1,43 @@
+package rsw
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCalibrate(t *testing.T) {
+	var err error
+	var cal *Calibration
+	if cal, err = Calibrate(50 * time.Millisecond); err != nil {
+		t.Errorf("Error calibrating: %s", err)
+		return
+	}
+
+	if cal.ModulusBits != 2048 {
+		t.Errorf("Calibration should be for a 2048 bit modulus, got %d bits", cal.ModulusBits)
+		return
+	}
+
+	if cal.BigSquaringsPerSecond <= 0 || cal.GMPSquaringsPerSecond <= 0 {
+		t.Errorf("Calibration should measure a positive rate for both solvers, got %f and %f", cal.BigSquaringsPerSecond, cal.GMPSquaringsPerSecond)
+		return
+	}
+
+	if cal.SquaringsPerSecond() < cal.BigSquaringsPerSecond || cal.SquaringsPerSecond() < cal.GMPSquaringsPerSecond {
+		t.Errorf("Calibration rate should be the rate of the fastest solver")
+		return
+	}
+
+	if _, err = CalibrateGMP(big.NewInt(0), time.Millisecond); err == nil {
+		t.Errorf("Should not calibrate with a zero modulus")
+		return
+	}
+
+	if _, err = CalibrateBig(RSA2048, 0); err == nil {
+		t.Errorf("Should not calibrate for zero time")
+		return
+	}
+
+	return
+}
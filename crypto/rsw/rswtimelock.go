@@ -266,30 +266,117 @@ func (pz *PuzzleRSW) SolveGMPCkADD() (answer []byte, err error) {
 	return
 }
 
-// Serialize turns the RSW puzzle into something that can be sent over the wire
-func (pz *PuzzleRSW) Serialize() (raw []byte, err error) {
-	var b bytes.Buffer
+// smallPrimeBound is the bound for the primes we trial divide N by when checking that a puzzle is
+// well formed. A modulus with a small factor can be factored easily, which makes the puzzle trivial.
+const smallPrimeBound = 1 << 12
+
+// smallPrimes returns all of the primes less than smallPrimeBound
+func smallPrimes() (primes []int64) {
+	composite := make([]bool, smallPrimeBound)
+	for i := 2; i < smallPrimeBound; i++ {
+		if composite[i] {
+			continue
+		}
+		primes = append(primes, int64(i))
+		for j := i * i; j < smallPrimeBound; j += i {
+			composite[j] = true
+		}
+	}
+	return
+}
 
-	// register puzzleRSW interface
-	gob.Register(PuzzleRSW{})
+// CheckWellFormed checks that the puzzle can't be solved without doing t squarings, as far as we
+// can tell without knowing the factorization of N. N must be at least minModulusBits long, and
+// have no small factors. A must not be trivial, and must be coprime with N, since otherwise A
+// reveals a factor of N. CK must fit in N, since b is always less than N.
+func (pz *PuzzleRSW) CheckWellFormed(minModulusBits int) (err error) {
+	if pz.N == nil || pz.A == nil || pz.T == nil || pz.CK == nil {
+		err = fmt.Errorf("Puzzle is missing N, A, T, or CK")
+		return
+	}
+
+	if pz.N.BitLen() < minModulusBits {
+		err = fmt.Errorf("Modulus is %d bits, must be at least %d bits", pz.N.BitLen(), minModulusBits)
+		return
+	}
 
-	// create a new encoder writing to the buffer
-	enc := gob.NewEncoder(&b)
+	for _, prime := range smallPrimes() {
+		if new(big.Int).Mod(pz.N, big.NewInt(prime)).Sign() == 0 {
+			err = fmt.Errorf("Modulus has small factor %d", prime)
+			return
+		}
+	}
+
+	// A has to be in [2, N-2], since 0, 1 and N-1 all square to something trivial
+	if pz.A.Cmp(big.NewInt(2)) < 0 || pz.A.Cmp(new(big.Int).Sub(pz.N, big.NewInt(2))) > 0 {
+		err = fmt.Errorf("Base A must be between 2 and N-2")
+		return
+	}
+
+	if new(big.Int).GCD(nil, nil, pz.A, pz.N).Cmp(big.NewInt(1)) != 0 {
+		err = fmt.Errorf("Base A is not coprime with N")
+		return
+	}
+
+	if pz.T.Sign() <= 0 {
+		err = fmt.Errorf("Time parameter T must be positive")
+		return
+	}
 
-	// encode the puzzle in the buffer
-	if err = enc.Encode(pz); err != nil {
-		err = fmt.Errorf("Error encoding puzzle: %s", err)
+	if pz.CK.Sign() < 0 || pz.CK.BitLen() > pz.N.BitLen() {
+		err = fmt.Errorf("CK must be nonnegative and fit in the modulus")
 		return
 	}
 
-	// Get the bytes from the buffer
-	raw = b.Bytes()
+	return
+}
+
+// Serialize turns the RSW puzzle into something that can be sent over the wire, using the
+// canonical encoding
+func (pz *PuzzleRSW) Serialize() (raw []byte, err error) {
+	enc := crypto.NewEncoder(crypto.TypeRSWPuzzle)
+	for _, num := range []*big.Int{pz.N, pz.A, pz.T, pz.CK} {
+		if err = enc.WriteBigInt(num); err != nil {
+			err = fmt.Errorf("Error encoding puzzle: %s", err)
+			return
+		}
+	}
 
+	raw = enc.Bytes()
 	return
 }
 
-// Deserialize turns the RSW puzzle into something that can be sent over the wire
+// Deserialize turns the RSW puzzle into something that can be sent over the wire. Puzzles that
+// were serialized with gob, before the canonical encoding, can still be deserialized.
 func (pz *PuzzleRSW) Deserialize(raw []byte) (err error) {
+	if !crypto.IsCanonical(raw) {
+		err = pz.deserializeGob(raw)
+		return
+	}
+
+	var dec *crypto.Decoder
+	if dec, err = crypto.NewDecoder(raw, crypto.TypeRSWPuzzle); err != nil {
+		err = fmt.Errorf("Error decoding puzzle: %s", err)
+		return
+	}
+
+	for _, num := range []**big.Int{&pz.N, &pz.A, &pz.T, &pz.CK} {
+		if *num, err = dec.ReadBigInt(); err != nil {
+			err = fmt.Errorf("Error decoding puzzle: %s", err)
+			return
+		}
+	}
+
+	if err = dec.Finish(); err != nil {
+		err = fmt.Errorf("Error decoding puzzle: %s", err)
+		return
+	}
+
+	return
+}
+
+// deserializeGob deserializes a puzzle that was serialized with gob
+func (pz *PuzzleRSW) deserializeGob(raw []byte) (err error) {
 	var b *bytes.Buffer
 	b = bytes.NewBuffer(raw)
 
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"math/big"
 	"runtime"
 	"testing"
+
+	"github.com/mit-dci/opencx/crypto"
 )
 
 // This is how you create a solvable RSW timelock puzzle.
@@ -352,3 +355,47 @@ func BenchmarkConcurrentManyMillions2048A2(b *testing.B) {
 
 // 	return
 // }
+
+func TestCheckWellFormed(t *testing.T) {
+	var err error
+
+	key := make([]byte, 16)
+	var timelock crypto.Timelock
+	if timelock, err = New2048A2(key); err != nil {
+		t.Errorf("Error creating timelock: %s", err)
+		return
+	}
+
+	var puzzle crypto.Puzzle
+	if puzzle, _, err = timelock.SetupTimelockPuzzle(1000); err != nil {
+		t.Errorf("Error creating puzzle: %s", err)
+		return
+	}
+
+	rswPuzzle := puzzle.(*PuzzleRSW)
+	if err = rswPuzzle.CheckWellFormed(2048); err != nil {
+		t.Errorf("Puzzle should be well formed: %s", err)
+		return
+	}
+
+	if err = rswPuzzle.CheckWellFormed(4096); err == nil {
+		t.Errorf("2048 bit puzzle should not pass a 4096 bit minimum")
+		return
+	}
+
+	trivialBase := *rswPuzzle
+	trivialBase.A = big.NewInt(1)
+	if err = trivialBase.CheckWellFormed(2048); err == nil {
+		t.Errorf("Puzzle with base 1 should not be well formed")
+		return
+	}
+
+	smallFactor := *rswPuzzle
+	smallFactor.N = new(big.Int).Mul(rswPuzzle.N, big.NewInt(3))
+	if err = smallFactor.CheckWellFormed(2048); err == nil {
+		t.Errorf("Puzzle with a modulus divisible by 3 should not be well formed")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,176 @@
+package rsw
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+// RSA2048 is the RSA-2048 challenge number from the RSA Factoring Challenge. Nobody knows its
+// factorization, so nobody has a trapdoor for puzzles that use it as their modulus.
+var RSA2048, _ = new(big.Int).SetString("25195908475657893494027183240048398571429282126204032027777137836043662020707595556264018525880784406918290641249515082189298559149176184502808489120072844992687392807287776735971418347270261896375014971824691165077613379859095700097330459748808428401797429100642458691817195118746121515172654632282216869987549182422433637259085141865462043576798423387184774447920739934236584823824281198163815010674810451660377306056201619676256133844143603833904414952634432190114657544454178424020924616515723350778707749817125772467962926386356373289912154831438167899885040445364023527381951378636564391212010397122822120720357", 10)
+
+const (
+	// AbsoluteMinSharedModulusBits is the smallest shared modulus we accept
+	AbsoluteMinSharedModulusBits = 1024
+
+	// blindingSlackBits is how many more bits than the modulus the blinding exponent r has, so G^r
+	// is close to uniform even though we don't know the order of G.
+	blindingSlackBits = 128
+)
+
+// SharedParams are public parameters for timelock puzzles over a shared modulus. H = G^(2^T) mod N
+// is computed once, with T squarings, and the proof lets anyone check H without doing them.
+type SharedParams struct {
+	N     *big.Int
+	G     *big.Int
+	T     *big.Int
+	H     *big.Int
+	Proof *VDFProof
+}
+
+// TimelockShared creates RSW puzzles over a shared modulus that nobody has the trapdoor for. Instead
+// of a trapdoor, puzzles are created from the precomputed H in the shared parameters: the puzzle
+// base is A = G^r for a random r, and the answer is A^(2^T) = H^r. Creating a puzzle only takes
+// two exponentiations, and the creator can't make a puzzle that's easier than T.
+type TimelockShared struct {
+	key    []byte
+	params *SharedParams
+}
+
+// NewSharedParams computes the shared parameters for modulus n, base g and time t. This does t
+// squarings, so it takes as long as solving a puzzle, but only has to be done once.
+func NewSharedParams(n *big.Int, g *big.Int, t uint64) (params *SharedParams, err error) {
+	setupPuzzle := &PuzzleRSW{
+		N:  n,
+		A:  g,
+		T:  new(big.Int).SetUint64(t),
+		CK: big.NewInt(0),
+	}
+
+	if err = setupPuzzle.CheckWellFormed(AbsoluteMinSharedModulusBits); err != nil {
+		err = fmt.Errorf("Shared modulus and base are not well formed: %s", err)
+		return
+	}
+
+	var proof *VDFProof
+	if _, proof, err = setupPuzzle.SolveWithProof(); err != nil {
+		err = fmt.Errorf("Error computing H for shared params: %s", err)
+		return
+	}
+
+	params = &SharedParams{
+		N:     setupPuzzle.N,
+		G:     setupPuzzle.A,
+		T:     setupPuzzle.T,
+		H:     proof.Y,
+		Proof: proof,
+	}
+	return
+}
+
+// NewRSA2048SharedParams computes the shared parameters over the RSA-2048 challenge number, with a
+// base of 2.
+func NewRSA2048SharedParams(t uint64) (params *SharedParams, err error) {
+	return NewSharedParams(RSA2048, big.NewInt(2), t)
+}
+
+// Verify checks that H = G^(2^T) mod N using the proof, so puzzles created with the params really
+// take T squarings to solve.
+func (params *SharedParams) Verify() (err error) {
+	if params.N == nil || params.G == nil || params.T == nil || params.H == nil {
+		err = fmt.Errorf("Shared params are missing N, G, T, or H")
+		return
+	}
+
+	setupPuzzle := &PuzzleRSW{
+		N:  params.N,
+		A:  params.G,
+		T:  params.T,
+		CK: big.NewInt(0),
+	}
+
+	if err = setupPuzzle.CheckWellFormed(AbsoluteMinSharedModulusBits); err != nil {
+		err = fmt.Errorf("Shared modulus and base are not well formed: %s", err)
+		return
+	}
+
+	if _, err = setupPuzzle.VerifyProof(params.Proof); err != nil {
+		err = fmt.Errorf("Proof for shared params does not verify: %s", err)
+		return
+	}
+
+	if params.Proof.Y.Cmp(params.H) != 0 {
+		err = fmt.Errorf("Proof for shared params is for a different H")
+		return
+	}
+
+	return
+}
+
+// NewShared creates a new TimelockShared for the key. The params are verified here, so the
+// puzzles it creates are as hard as the params say.
+func NewShared(key []byte, params *SharedParams) (timelock crypto.Timelock, err error) {
+	if params == nil {
+		err = fmt.Errorf("Cannot create shared timelock with nil params")
+		return
+	}
+
+	if err = params.Verify(); err != nil {
+		err = fmt.Errorf("Invalid shared params for timelock: %s", err)
+		return
+	}
+
+	if new(big.Int).SetBytes(key).Cmp(params.N) >= 0 {
+		err = fmt.Errorf("Key is too big for the shared modulus")
+		return
+	}
+
+	timelock = &TimelockShared{
+		key:    key,
+		params: params,
+	}
+	return
+}
+
+// SetupTimelockPuzzle creates an RSW puzzle over the shared modulus. H was computed for a specific
+// time, so t must be the time in the shared params.
+func (tl *TimelockShared) SetupTimelockPuzzle(t uint64) (puzzle crypto.Puzzle, answer []byte, err error) {
+	if !tl.params.T.IsUint64() || tl.params.T.Uint64() != t {
+		err = fmt.Errorf("Shared params are for time %s, cannot create puzzle with time %d", tl.params.T.String(), t)
+		return
+	}
+
+	// r is random, and much bigger than N, so A = G^r doesn't tell anyone anything about r
+	rBound := new(big.Int).Lsh(big.NewInt(1), uint(tl.params.N.BitLen()+blindingSlackBits))
+	var r *big.Int
+	if r, err = rand.Int(rand.Reader, rBound); err != nil {
+		err = fmt.Errorf("Error getting random exponent for shared puzzle: %s", err)
+		return
+	}
+
+	// A = G^r mod N, and b = A^(2^T) = (G^(2^T))^r = H^r mod N
+	a := new(big.Int).Exp(tl.params.G, r, tl.params.N)
+	b := new(big.Int).Exp(tl.params.H, r, tl.params.N)
+
+	// C_k = k ⊕ b, like the RSW puzzle
+	ck := new(big.Int).Xor(b, new(big.Int).SetBytes(tl.key))
+
+	puzzle = &PuzzleRSW{
+		N:  new(big.Int).Set(tl.params.N),
+		A:  a,
+		T:  new(big.Int).Set(tl.params.T),
+		CK: ck,
+	}
+
+	xorBytes := new(big.Int).Xor(ck, b).Bytes()
+	if len(xorBytes) <= 16 {
+		answer = make([]byte, 16)
+	} else {
+		answer = make([]byte, len(xorBytes))
+	}
+	copy(answer, xorBytes)
+	return
+}
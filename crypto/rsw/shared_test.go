@@ -0,0 +1,98 @@
+package rsw
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+func TestRSA2048(t *testing.T) {
+	if RSA2048 == nil {
+		t.Errorf("RSA-2048 challenge number did not parse")
+		return
+	}
+
+	if RSA2048.BitLen() != 2048 {
+		t.Errorf("RSA-2048 challenge number is %d bits, expected 2048", RSA2048.BitLen())
+		return
+	}
+
+	return
+}
+
+func TestSharedTimelock(t *testing.T) {
+	var err error
+
+	var params *SharedParams
+	if params, err = NewRSA2048SharedParams(1000); err != nil {
+		t.Errorf("Error creating shared params: %s", err)
+		return
+	}
+
+	key := make([]byte, 16)
+	copy(key, []byte("opencxshared"))
+	var timelock crypto.Timelock
+	if timelock, err = NewShared(key, params); err != nil {
+		t.Errorf("Error creating shared timelock: %s", err)
+		return
+	}
+
+	var puzzle crypto.Puzzle
+	var expectedAns []byte
+	if puzzle, expectedAns, err = timelock.SetupTimelockPuzzle(1000); err != nil {
+		t.Errorf("Error creating shared puzzle: %s", err)
+		return
+	}
+
+	var answer []byte
+	if answer, err = puzzle.Solve(); err != nil {
+		t.Errorf("Error solving shared puzzle: %s", err)
+		return
+	}
+
+	if !bytes.Equal(answer, expectedAns) {
+		t.Errorf("Answer from solving shared puzzle was %x, expected %x", answer, expectedAns)
+		return
+	}
+
+	if err = puzzle.(*PuzzleRSW).CheckWellFormed(2048); err != nil {
+		t.Errorf("Shared puzzle should be well formed: %s", err)
+		return
+	}
+
+	if _, _, err = timelock.SetupTimelockPuzzle(999); err == nil {
+		t.Errorf("Should not create a shared puzzle with a different time than the params")
+		return
+	}
+
+	return
+}
+
+func TestTamperedSharedParams(t *testing.T) {
+	var err error
+
+	var params *SharedParams
+	if params, err = NewRSA2048SharedParams(1000); err != nil {
+		t.Errorf("Error creating shared params: %s", err)
+		return
+	}
+
+	// A wrong H would make puzzles that can't be solved, or that are easier than they should be
+	wrongH := *params
+	wrongH.H = new(big.Int).Add(params.H, big.NewInt(1))
+	if _, err = NewShared(make([]byte, 16), &wrongH); err == nil {
+		t.Errorf("Should not create a shared timelock with the wrong H")
+		return
+	}
+
+	fewerSquarings := *params
+	fewerSquarings.T = big.NewInt(100)
+	if _, err = NewShared(make([]byte, 16), &fewerSquarings); err == nil {
+		t.Errorf("Should not create a shared timelock with a T that H wasn't computed for")
+		return
+	}
+
+	return
+}
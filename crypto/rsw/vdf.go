@@ -0,0 +1,361 @@
+package rsw
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"math/bits"
+
+	gmpbig "github.com/Rjected/gmp"
+)
+
+const (
+	// challengePrimeBits is the size of the prime l in the Wesolowski proof. We keep it below 128
+	// bits so the long division of 2^T by l fits in two words.
+	challengePrimeBits = 127
+
+	// proofChunkBits is how many bits of the quotient floor(2^T / l) we generate at a time, so we
+	// never have to hold all T bits of it in memory.
+	proofChunkBits = 1 << 16
+
+	// vdfDomain separates the challenge hash from anything else we hash
+	vdfDomain = "opencx-rsw-wesolowski"
+)
+
+// VDFProof is a Wesolowski proof that Y = A^(2^T) mod N. Anyone can check it with two small
+// exponentiations instead of T squarings, so they can check that the puzzle was solved honestly.
+// Wesolowski proofs are only sound in QR⁺_N, where x and N-x are the same element, so negating Y
+// and Pi would give a proof for N-Y. Instead, Pi proves W = A^(2^(T-1)) up to sign, and the
+// verifier squares W to get Y, which is the same for W and N-W. Pi is also the smaller of Pi and
+// N-Pi, so there's only one valid proof.
+type VDFProof struct {
+	Y  *big.Int
+	Pi *big.Int
+}
+
+// hashToPrime derives the challenge prime l = H(N, A, Y, T) for the Fiat-Shamir transform of
+// Wesolowski's protocol, by hashing with a counter until the result is prime.
+func (pz *PuzzleRSW) hashToPrime(y *big.Int) (l *big.Int) {
+	lenBytes := make([]byte, 4)
+	ctrBytes := make([]byte, 4)
+	for ctr := uint32(0); ; ctr++ {
+		hasher := sha256.New()
+		hasher.Write([]byte(vdfDomain))
+		for _, num := range []*big.Int{pz.N, pz.A, y, pz.T} {
+			numBytes := num.Bytes()
+			binary.BigEndian.PutUint32(lenBytes, uint32(len(numBytes)))
+			hasher.Write(lenBytes)
+			hasher.Write(numBytes)
+		}
+		binary.BigEndian.PutUint32(ctrBytes, ctr)
+		hasher.Write(ctrBytes)
+
+		// take the top challengePrimeBits bits and make sure the top one is set
+		l = new(big.Int).SetBytes(hasher.Sum(nil))
+		l.Rsh(l, uint(256-challengePrimeBits))
+		l.SetBit(l, challengePrimeBits-1, 1)
+		if l.ProbablyPrime(20) {
+			return
+		}
+	}
+}
+
+// quotientBits generates the bits of floor(2^T / l) from the most significant bit, by long
+// division. l is less than 2^127, so the remainder always fits in two words.
+type quotientBits struct {
+	lHi, lLo uint64
+	rHi, rLo uint64
+	// remaining is how many bits of the dividend 2^T we have left
+	remaining uint64
+	first     bool
+}
+
+// newQuotientBits creates a generator for the T+1 bits of floor(2^T / l)
+func newQuotientBits(t uint64, l *big.Int) (qb *quotientBits) {
+	lBytes := make([]byte, 16)
+	lBig := l.Bytes()
+	copy(lBytes[16-len(lBig):], lBig)
+	qb = &quotientBits{
+		lHi:       binary.BigEndian.Uint64(lBytes[:8]),
+		lLo:       binary.BigEndian.Uint64(lBytes[8:]),
+		remaining: t + 1,
+		first:     true,
+	}
+	return
+}
+
+// next returns the next bit of the quotient
+func (qb *quotientBits) next() (bit uint) {
+	// r = 2r + d, where d is 1 for the leading bit of 2^T and 0 after
+	var d uint64
+	if qb.first {
+		d = 1
+		qb.first = false
+	}
+	qb.rHi = qb.rHi<<1 | qb.rLo>>63
+	qb.rLo = qb.rLo<<1 | d
+	qb.remaining--
+
+	// if r >= l then the bit is 1, and r = r - l
+	if qb.rHi > qb.lHi || (qb.rHi == qb.lHi && qb.rLo >= qb.lLo) {
+		var borrow uint64
+		qb.rLo, borrow = bits.Sub64(qb.rLo, qb.lLo, 0)
+		qb.rHi, _ = bits.Sub64(qb.rHi, qb.lHi, borrow)
+		bit = 1
+	}
+	return
+}
+
+// SolveWithProof solves the puzzle by repeated squarings, like Solve, and also creates a
+// Wesolowski proof that the solution is correct. Creating the proof takes about as long as solving.
+func (pz *PuzzleRSW) SolveWithProof() (answer []byte, proof *VDFProof, err error) {
+	return pz.SolveWithProofContext(context.Background())
+}
+
+// SolveWithProofContext is SolveWithProof, but stops early with an error if the context is
+// cancelled. The squarings are done in chunks so a cancelled puzzle doesn't keep a core busy.
+func (pz *PuzzleRSW) SolveWithProofContext(ctx context.Context) (answer []byte, proof *VDFProof, err error) {
+	var y *big.Int
+	if y, err = pz.SquareContext(ctx); err != nil {
+		return
+	}
+
+	if proof, err = pz.ProveContext(ctx, y); err != nil {
+		return
+	}
+
+	answer = pz.answerFromY(y)
+	return
+}
+
+// SquareContext computes Y = A^(2^T) mod N, which is all the work of solving the puzzle, without
+// creating a proof. A proof can be created from Y later with ProveContext. It stops early with an
+// error if the context is cancelled.
+func (pz *PuzzleRSW) SquareContext(ctx context.Context) (y *big.Int, err error) {
+	if pz.N == nil || pz.A == nil || pz.T == nil || pz.CK == nil {
+		err = fmt.Errorf("Puzzle is missing N, A, T, or CK")
+		return
+	}
+	if !pz.T.IsUint64() {
+		err = fmt.Errorf("Cannot solve puzzle with T that doesn't fit in 64 bits")
+		return
+	}
+
+	gmpn := new(gmpbig.Int).SetBytes(pz.N.Bytes())
+	gmpa := new(gmpbig.Int).SetBytes(pz.A.Bytes())
+
+	// Y = A^(2^T) mod N, computed a chunk of squarings at a time
+	gmpy := new(gmpbig.Int).Mod(gmpa, gmpn)
+	for remaining := pz.T.Uint64(); remaining > 0; {
+		if err = ctx.Err(); err != nil {
+			err = fmt.Errorf("Stopped solving puzzle: %s", err)
+			return
+		}
+
+		chunkSquarings := uint64(proofChunkBits)
+		if remaining < chunkSquarings {
+			chunkSquarings = remaining
+		}
+		gmpy.ExpSquare(gmpy, new(gmpbig.Int).SetUint64(chunkSquarings), gmpn)
+		remaining -= chunkSquarings
+	}
+	y = new(big.Int).SetBytes(gmpy.Bytes())
+	return
+}
+
+// ProveContext creates a Wesolowski proof that Y = A^(2^T) mod N, for the Y from SquareContext.
+// This takes about as long as the squarings did. Y isn't checked, so a wrong Y gives a proof
+// that doesn't verify. It stops early with an error if the context is cancelled.
+// The proof is for the T-1 squarings to W, see VDFProof, so Pi = A^(floor(2^(T-1) / l)).
+func (pz *PuzzleRSW) ProveContext(ctx context.Context, y *big.Int) (proof *VDFProof, err error) {
+	if pz.N == nil || pz.A == nil || pz.T == nil || pz.CK == nil {
+		err = fmt.Errorf("Puzzle is missing N, A, T, or CK")
+		return
+	}
+	if y == nil {
+		err = fmt.Errorf("Cannot create proof without Y")
+		return
+	}
+	if !pz.T.IsUint64() {
+		err = fmt.Errorf("Cannot create proof for puzzle with T that doesn't fit in 64 bits")
+		return
+	}
+
+	// with no squarings Y is just A, and there's nothing to prove
+	if pz.T.Sign() == 0 {
+		proof = &VDFProof{
+			Y:  new(big.Int).Set(y),
+			Pi: big.NewInt(1),
+		}
+		return
+	}
+
+	gmpn := new(gmpbig.Int).SetBytes(pz.N.Bytes())
+	gmpa := new(gmpbig.Int).SetBytes(pz.A.Bytes())
+
+	// Pi = A^(floor(2^(T-1) / l)) mod N, computed a chunk of quotient bits at a time
+	l := pz.hashToPrime(y)
+	qb := newQuotientBits(pz.T.Uint64()-1, l)
+	gmpPi := gmpbig.NewInt(1)
+	for qb.remaining > 0 {
+		if err = ctx.Err(); err != nil {
+			err = fmt.Errorf("Stopped creating proof for puzzle: %s", err)
+			return
+		}
+
+		chunkBits := uint64(proofChunkBits)
+		if qb.remaining < chunkBits {
+			chunkBits = qb.remaining
+		}
+
+		chunk := new(big.Int)
+		for i := uint64(0); i < chunkBits; i++ {
+			chunk.Lsh(chunk, 1)
+			if qb.next() == 1 {
+				chunk.SetBit(chunk, 0, 1)
+			}
+		}
+
+		// Pi = Pi^(2^chunkBits) * A^chunk mod N
+		gmpPi.ExpSquare(gmpPi, new(gmpbig.Int).SetUint64(chunkBits), gmpn)
+		gmpPi.Mul(gmpPi, new(gmpbig.Int).Exp(gmpa, new(gmpbig.Int).SetBytes(chunk.Bytes()), gmpn))
+		gmpPi.Mod(gmpPi, gmpn)
+	}
+	proof = &VDFProof{
+		Y:  new(big.Int).Set(y),
+		Pi: absModN(new(big.Int).SetBytes(gmpPi.Bytes()), pz.N),
+	}
+	return
+}
+
+// absModN returns the smaller of x and N-x, which is how x is written in QR⁺_N
+func absModN(x *big.Int, n *big.Int) (abs *big.Int) {
+	abs = new(big.Int).Sub(n, x)
+	if abs.Cmp(x) > 0 {
+		abs.Set(x)
+	}
+	return
+}
+
+// answerFromY computes the answer CK ⊕ Y, padded to at least 16 bytes like the other solvers
+func (pz *PuzzleRSW) answerFromY(y *big.Int) (answer []byte) {
+	ansBytes := new(big.Int).Xor(pz.CK, y).Bytes()
+	if len(ansBytes) <= 16 {
+		answer = make([]byte, 16)
+	} else {
+		answer = make([]byte, len(ansBytes))
+	}
+	copy(answer, ansBytes)
+	return
+}
+
+// VerifyProof checks the Wesolowski proof that Y = A^(2^T) mod N, by checking that
+// (Pi^l * A^(2^(T-1) mod l))^2 = Y mod N. If the proof is valid it returns the answer to the
+// puzzle.
+func (pz *PuzzleRSW) VerifyProof(proof *VDFProof) (answer []byte, err error) {
+	if pz.N == nil || pz.A == nil || pz.T == nil || pz.CK == nil {
+		err = fmt.Errorf("Puzzle is missing N, A, T, or CK")
+		return
+	}
+	if proof == nil || proof.Y == nil || proof.Pi == nil {
+		err = fmt.Errorf("Proof is missing Y or Pi")
+		return
+	}
+	if proof.Y.Sign() <= 0 || proof.Y.Cmp(pz.N) >= 0 || proof.Pi.Sign() <= 0 || proof.Pi.Cmp(pz.N) >= 0 {
+		err = fmt.Errorf("Proof values must be between 1 and N-1")
+		return
+	}
+	if pz.T.Sign() < 0 {
+		err = fmt.Errorf("Time parameter T cannot be negative")
+		return
+	}
+	if absModN(proof.Pi, pz.N).Cmp(proof.Pi) != 0 {
+		err = fmt.Errorf("Proof Pi must be the smaller of Pi and N-Pi")
+		return
+	}
+
+	// with no squarings Y is just A
+	if pz.T.Sign() == 0 {
+		if new(big.Int).Mod(pz.A, pz.N).Cmp(proof.Y) != 0 {
+			err = fmt.Errorf("Y should be A for a puzzle with no squarings")
+			return
+		}
+		answer = pz.answerFromY(proof.Y)
+		return
+	}
+
+	// W = Pi^l * A^(2^(T-1) mod l) is only right up to sign, so check its square
+	tMinusOne := new(big.Int).Sub(pz.T, big.NewInt(1))
+	l := pz.hashToPrime(proof.Y)
+	r := new(big.Int).Exp(big.NewInt(2), tMinusOne, l)
+
+	lhs := new(big.Int).Exp(proof.Pi, l, pz.N)
+	lhs.Mul(lhs, new(big.Int).Exp(pz.A, r, pz.N))
+	lhs.Exp(lhs, big.NewInt(2), pz.N)
+
+	if lhs.Cmp(proof.Y) != 0 {
+		err = fmt.Errorf("Wesolowski proof does not verify")
+		return
+	}
+
+	answer = pz.answerFromY(proof.Y)
+	return
+}
+
+// ProvenPuzzle is an RSW puzzle along with a proof of its solution. Solving it checks the proof
+// instead of doing the squarings, so anyone can cheaply decrypt something the exchange already
+// decrypted, and know they got the same answer.
+type ProvenPuzzle struct {
+	Puzzle *PuzzleRSW
+	Proof  *VDFProof
+}
+
+// Solve verifies the proof and returns the answer to the puzzle
+func (pp *ProvenPuzzle) Solve() (answer []byte, err error) {
+	if pp.Puzzle == nil {
+		err = fmt.Errorf("Proven puzzle has no puzzle")
+		return
+	}
+	answer, err = pp.Puzzle.VerifyProof(pp.Proof)
+	return
+}
+
+// Serialize serializes the underlying puzzle
+func (pp *ProvenPuzzle) Serialize() (raw []byte, err error) {
+	if pp.Puzzle == nil {
+		err = fmt.Errorf("Proven puzzle has no puzzle")
+		return
+	}
+	raw, err = pp.Puzzle.Serialize()
+	return
+}
+
+// SolvedPuzzle is an RSW puzzle along with the Y = A^(2^T) mod N that SquareContext computed for
+// it. Solving it just uses Y, so whoever did the squarings can decrypt without making a proof
+// first. Nothing is checked, so this is only for puzzles we solved ourselves.
+type SolvedPuzzle struct {
+	Puzzle *PuzzleRSW
+	Y      *big.Int
+}
+
+// Solve returns the answer to the puzzle from Y
+func (sp *SolvedPuzzle) Solve() (answer []byte, err error) {
+	if sp.Puzzle == nil || sp.Puzzle.CK == nil || sp.Y == nil {
+		err = fmt.Errorf("Solved puzzle is missing the puzzle or Y")
+		return
+	}
+	answer = sp.Puzzle.answerFromY(sp.Y)
+	return
+}
+
+// Serialize serializes the underlying puzzle
+func (sp *SolvedPuzzle) Serialize() (raw []byte, err error) {
+	if sp.Puzzle == nil {
+		err = fmt.Errorf("Solved puzzle has no puzzle")
+		return
+	}
+	raw, err = sp.Puzzle.Serialize()
+	return
+}
@@ -0,0 +1,183 @@
+package rsw
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+func TestSolveWithProof(t *testing.T) {
+	var err error
+
+	key := make([]byte, 16)
+	copy(key, []byte("opencxvdfproof"))
+	var timelock crypto.Timelock
+	if timelock, err = New2048A2(key); err != nil {
+		t.Errorf("Error creating timelock: %s", err)
+		return
+	}
+
+	// more than one chunk of quotient bits, so the chunking gets tested
+	var puzzle crypto.Puzzle
+	var expectedAns []byte
+	if puzzle, expectedAns, err = timelock.SetupTimelockPuzzle(proofChunkBits + 1000); err != nil {
+		t.Errorf("Error creating puzzle: %s", err)
+		return
+	}
+	rswPuzzle := puzzle.(*PuzzleRSW)
+
+	var answer []byte
+	var proof *VDFProof
+	if answer, proof, err = rswPuzzle.SolveWithProof(); err != nil {
+		t.Errorf("Error solving puzzle with proof: %s", err)
+		return
+	}
+
+	if !bytes.Equal(answer, expectedAns) {
+		t.Errorf("Answer from solving with proof was %x, expected %x", answer, expectedAns)
+		return
+	}
+
+	var verifiedAns []byte
+	if verifiedAns, err = rswPuzzle.VerifyProof(proof); err != nil {
+		t.Errorf("Valid proof did not verify: %s", err)
+		return
+	}
+
+	if !bytes.Equal(verifiedAns, expectedAns) {
+		t.Errorf("Answer from verifying proof was %x, expected %x", verifiedAns, expectedAns)
+		return
+	}
+
+	// the proven puzzle should give the same answer as solving
+	var provenAns []byte
+	if provenAns, err = (&ProvenPuzzle{Puzzle: rswPuzzle, Proof: proof}).Solve(); err != nil {
+		t.Errorf("Error solving proven puzzle: %s", err)
+		return
+	}
+
+	if !bytes.Equal(provenAns, expectedAns) {
+		t.Errorf("Answer from proven puzzle was %x, expected %x", provenAns, expectedAns)
+		return
+	}
+
+	return
+}
+
+func TestTamperedVDFProof(t *testing.T) {
+	var err error
+
+	key := make([]byte, 16)
+	var timelock crypto.Timelock
+	if timelock, err = New2048A2(key); err != nil {
+		t.Errorf("Error creating timelock: %s", err)
+		return
+	}
+
+	var puzzle crypto.Puzzle
+	if puzzle, _, err = timelock.SetupTimelockPuzzle(1000); err != nil {
+		t.Errorf("Error creating puzzle: %s", err)
+		return
+	}
+	rswPuzzle := puzzle.(*PuzzleRSW)
+
+	var proof *VDFProof
+	if _, proof, err = rswPuzzle.SolveWithProof(); err != nil {
+		t.Errorf("Error solving puzzle with proof: %s", err)
+		return
+	}
+
+	wrongY := &VDFProof{
+		Y:  new(big.Int).Add(proof.Y, big.NewInt(1)),
+		Pi: proof.Pi,
+	}
+	if _, err = rswPuzzle.VerifyProof(wrongY); err == nil {
+		t.Errorf("Proof with the wrong Y should not verify")
+		return
+	}
+
+	wrongPi := &VDFProof{
+		Y:  proof.Y,
+		Pi: new(big.Int).Add(proof.Pi, big.NewInt(1)),
+	}
+	if _, err = rswPuzzle.VerifyProof(wrongPi); err == nil {
+		t.Errorf("Proof with the wrong Pi should not verify")
+		return
+	}
+
+	// a proof for fewer squarings should not verify
+	fewerSquarings := *rswPuzzle
+	fewerSquarings.T = big.NewInt(999)
+	if _, err = fewerSquarings.VerifyProof(proof); err == nil {
+		t.Errorf("Proof should not verify for a different T")
+		return
+	}
+
+	return
+}
+
+// TestNegatedVDFProof makes sure negating Y and Pi doesn't give a proof that verifies. Without
+// checking the proof in QR⁺_N, Y' = N-Y with Pi' = -A^(floor(2^T / l')) passes, and gives a wrong
+// answer.
+func TestNegatedVDFProof(t *testing.T) {
+	var err error
+
+	key := make([]byte, 16)
+	var timelock crypto.Timelock
+	if timelock, err = New2048A2(key); err != nil {
+		t.Errorf("Error creating timelock: %s", err)
+		return
+	}
+
+	var puzzle crypto.Puzzle
+	if puzzle, _, err = timelock.SetupTimelockPuzzle(1000); err != nil {
+		t.Errorf("Error creating puzzle: %s", err)
+		return
+	}
+	rswPuzzle := puzzle.(*PuzzleRSW)
+
+	var proof *VDFProof
+	if _, proof, err = rswPuzzle.SolveWithProof(); err != nil {
+		t.Errorf("Error solving puzzle with proof: %s", err)
+		return
+	}
+
+	n := rswPuzzle.N
+	forgedY := new(big.Int).Sub(n, proof.Y)
+	l := rswPuzzle.hashToPrime(forgedY)
+
+	// forge for all T squarings, and make sure it passes Pi'^l * A^(2^T mod l) = Y' like it would
+	// without QR⁺_N
+	q := new(big.Int).Lsh(big.NewInt(1), uint(rswPuzzle.T.Uint64()))
+	q.Div(q, l)
+	forgedPi := new(big.Int).Sub(n, new(big.Int).Exp(rswPuzzle.A, q, n))
+	check := new(big.Int).Exp(forgedPi, l, n)
+	check.Mul(check, new(big.Int).Exp(rswPuzzle.A, new(big.Int).Exp(big.NewInt(2), rswPuzzle.T, l), n))
+	check.Mod(check, n)
+	if check.Cmp(forgedY) != 0 {
+		t.Errorf("Forged proof should pass the check outside of QR⁺_N")
+		return
+	}
+
+	// forge for the T-1 squarings that are proven, too
+	qHalf := new(big.Int).Lsh(big.NewInt(1), uint(rswPuzzle.T.Uint64()-1))
+	qHalf.Div(qHalf, l)
+	forgedHalfPi := new(big.Int).Sub(n, new(big.Int).Exp(rswPuzzle.A, qHalf, n))
+
+	for _, forgery := range []*big.Int{forgedPi, absModN(forgedPi, n), forgedHalfPi, absModN(forgedHalfPi, n)} {
+		if _, err = rswPuzzle.VerifyProof(&VDFProof{Y: forgedY, Pi: forgery}); err == nil {
+			t.Errorf("Proof for N-Y should not verify")
+			return
+		}
+	}
+
+	// negating Pi of a valid proof gives the same Y, but is still rejected so there's one proof
+	if _, err = rswPuzzle.VerifyProof(&VDFProof{Y: proof.Y, Pi: new(big.Int).Sub(n, proof.Pi)}); err == nil {
+		t.Errorf("Proof with N-Pi should not verify")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,139 @@
+package threshold
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+// Capsule hides a key for a decryption committee. R = rG, and CK is the key masked with a hash of
+// the label and rX. E and F prove knowledge of r for the label. It satisfies crypto.Puzzle so it
+// can go anywhere a puzzle can, but it can't be solved on its own, only opened with decryption
+// shares.
+type Capsule struct {
+	R     *Point
+	CK    []byte
+	Label []byte
+	E     *big.Int
+	F     *big.Int
+}
+
+// Solve always fails, since a capsule can only be opened by the committee. Use OpenedCapsule to
+// solve with decryption shares.
+func (capsule *Capsule) Solve() (answer []byte, err error) {
+	err = fmt.Errorf("Capsule can only be opened with decryption shares from the committee")
+	return
+}
+
+// Serialize turns the capsule into something that can be sent over the wire, using the canonical
+// encoding
+func (capsule *Capsule) Serialize() (raw []byte, err error) {
+	if capsule.R == nil {
+		err = fmt.Errorf("Capsule has no point, cannot encode")
+		return
+	}
+
+	enc := crypto.NewEncoder(crypto.TypeCommitteeCapsule)
+	if err = enc.WriteBigInt(capsule.R.X); err != nil {
+		err = fmt.Errorf("Error encoding capsule: %s", err)
+		return
+	}
+	if err = enc.WriteBigInt(capsule.R.Y); err != nil {
+		err = fmt.Errorf("Error encoding capsule: %s", err)
+		return
+	}
+	if err = enc.WriteBytes(capsule.CK); err != nil {
+		err = fmt.Errorf("Error encoding capsule: %s", err)
+		return
+	}
+	if err = enc.WriteBytes(capsule.Label); err != nil {
+		err = fmt.Errorf("Error encoding capsule: %s", err)
+		return
+	}
+	if err = enc.WriteBigInt(capsule.E); err != nil {
+		err = fmt.Errorf("Error encoding capsule: %s", err)
+		return
+	}
+	if err = enc.WriteBigInt(capsule.F); err != nil {
+		err = fmt.Errorf("Error encoding capsule: %s", err)
+		return
+	}
+
+	raw = enc.Bytes()
+	return
+}
+
+// Deserialize turns the raw bytes into the capsule receiver. This doesn't check that the point is
+// on a curve or check the proof, since that depends on the committee, so use
+// CommitteeKey.CheckCapsule.
+func (capsule *Capsule) Deserialize(raw []byte) (err error) {
+	var dec *crypto.Decoder
+	if dec, err = crypto.NewDecoder(raw, crypto.TypeCommitteeCapsule); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+
+	decoded := &Capsule{R: new(Point)}
+	if decoded.R.X, err = dec.ReadBigInt(); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+	if decoded.R.Y, err = dec.ReadBigInt(); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+	if decoded.CK, err = dec.ReadBytes(); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+	if decoded.Label, err = dec.ReadBytes(); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+	if decoded.E, err = dec.ReadBigInt(); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+	if decoded.F, err = dec.ReadBigInt(); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+
+	if err = dec.Finish(); err != nil {
+		err = fmt.Errorf("Error decoding capsule: %s", err)
+		return
+	}
+
+	*capsule = *decoded
+	return
+}
+
+// OpenedCapsule is a capsule along with the decryption shares to open it. Solving it checks and
+// combines the shares, so it can be used to decrypt anything that was encrypted with the capsule's
+// key, and anyone with the shares can check the decryption.
+type OpenedCapsule struct {
+	Capsule   *Capsule
+	Committee *CommitteeKey
+	Shares    []*DecryptionShare
+}
+
+// Solve opens the capsule with the decryption shares
+func (oc *OpenedCapsule) Solve() (answer []byte, err error) {
+	if oc.Capsule == nil || oc.Committee == nil {
+		err = fmt.Errorf("Opened capsule has no capsule or committee")
+		return
+	}
+	answer, err = oc.Committee.Open(oc.Capsule, oc.Shares)
+	return
+}
+
+// Serialize serializes the underlying capsule
+func (oc *OpenedCapsule) Serialize() (raw []byte, err error) {
+	if oc.Capsule == nil {
+		err = fmt.Errorf("Opened capsule has no capsule")
+		return
+	}
+	raw, err = oc.Capsule.Serialize()
+	return
+}
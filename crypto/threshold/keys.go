@@ -0,0 +1,187 @@
+package threshold
+
+import (
+	"crypto/elliptic"
+	"fmt"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+// Serialize encodes the committee key along with the share keys, so it can be given to the
+// exchange and to every key holder after dealing. The curve isn't encoded, it has to be known.
+func (committee *CommitteeKey) Serialize() (raw []byte, err error) {
+	if committee.PubKey == nil {
+		err = fmt.Errorf("Committee key has no public key, cannot encode")
+		return
+	}
+
+	enc := crypto.NewEncoder(crypto.TypeCommitteeKey)
+	enc.WriteUint64(uint64(committee.Threshold))
+	if err = writePoint(enc, committee.PubKey); err != nil {
+		err = fmt.Errorf("Error encoding committee key: %s", err)
+		return
+	}
+	enc.WriteUint64(uint64(len(committee.ShareKeys)))
+	for _, shareKey := range committee.ShareKeys {
+		if err = writePoint(enc, shareKey); err != nil {
+			err = fmt.Errorf("Error encoding committee share key: %s", err)
+			return
+		}
+	}
+
+	raw = enc.Bytes()
+	return
+}
+
+// DeserializeCommitteeKey decodes a committee key created with Serialize, and checks that every
+// point is on the curve.
+func DeserializeCommitteeKey(curve elliptic.Curve, raw []byte) (committee *CommitteeKey, err error) {
+	var dec *crypto.Decoder
+	if dec, err = crypto.NewDecoder(raw, crypto.TypeCommitteeKey); err != nil {
+		err = fmt.Errorf("Error decoding committee key: %s", err)
+		return
+	}
+
+	var threshold uint64
+	if threshold, err = dec.ReadUint64(); err != nil {
+		err = fmt.Errorf("Error decoding committee key: %s", err)
+		return
+	}
+
+	decoded := &CommitteeKey{Curve: curve}
+	if decoded.PubKey, err = readPoint(curve, dec); err != nil {
+		err = fmt.Errorf("Error decoding committee public key: %s", err)
+		return
+	}
+
+	var numShareKeys uint64
+	if numShareKeys, err = dec.ReadUint64(); err != nil {
+		err = fmt.Errorf("Error decoding committee key: %s", err)
+		return
+	}
+
+	if threshold < 1 || threshold > numShareKeys {
+		err = fmt.Errorf("Committee threshold must be between 1 and the number of share keys, got %d of %d", threshold, numShareKeys)
+		return
+	}
+	decoded.Threshold = int(threshold)
+
+	for i := uint64(0); i < numShareKeys; i++ {
+		var shareKey *Point
+		if shareKey, err = readPoint(curve, dec); err != nil {
+			err = fmt.Errorf("Error decoding committee share key %d: %s", i+1, err)
+			return
+		}
+		decoded.ShareKeys = append(decoded.ShareKeys, shareKey)
+	}
+
+	if err = dec.Finish(); err != nil {
+		err = fmt.Errorf("Error decoding committee key: %s", err)
+		return
+	}
+
+	committee = decoded
+	return
+}
+
+// Serialize encodes the key share so it can be handed to its key holder. This is the key holder's
+// secret, so it should only ever be written somewhere only they can read.
+func (share *KeyShare) Serialize() (raw []byte, err error) {
+	enc := crypto.NewEncoder(crypto.TypeKeyShare)
+	enc.WriteUint64(uint64(share.Index))
+	if err = enc.WriteBigInt(share.Secret); err != nil {
+		err = fmt.Errorf("Error encoding key share: %s", err)
+		return
+	}
+
+	raw = enc.Bytes()
+	return
+}
+
+// Deserialize turns the raw bytes into the key share receiver
+func (share *KeyShare) Deserialize(raw []byte) (err error) {
+	var dec *crypto.Decoder
+	if dec, err = crypto.NewDecoder(raw, crypto.TypeKeyShare); err != nil {
+		err = fmt.Errorf("Error decoding key share: %s", err)
+		return
+	}
+
+	var index uint64
+	if index, err = dec.ReadUint64(); err != nil {
+		err = fmt.Errorf("Error decoding key share: %s", err)
+		return
+	}
+
+	if index == 0 || index > uint64(^uint32(0)) {
+		err = fmt.Errorf("Key share index %d is out of range", index)
+		return
+	}
+
+	decoded := &KeyShare{Index: uint32(index)}
+	if decoded.Secret, err = dec.ReadBigInt(); err != nil {
+		err = fmt.Errorf("Error decoding key share: %s", err)
+		return
+	}
+
+	if err = dec.Finish(); err != nil {
+		err = fmt.Errorf("Error decoding key share: %s", err)
+		return
+	}
+
+	*share = *decoded
+	return
+}
+
+// CheckShare checks that the key share is the one the committee has the share key for, so a key
+// holder can't start with the wrong share.
+func (committee *CommitteeKey) CheckShare(share *KeyShare) (err error) {
+	if share == nil || share.Secret == nil {
+		err = fmt.Errorf("Key share is nil")
+		return
+	}
+
+	if share.Index == 0 || int(share.Index) > len(committee.ShareKeys) {
+		err = fmt.Errorf("Key share index %d is not in the committee", share.Index)
+		return
+	}
+
+	shareKey := baseMult(committee.Curve, share.Secret)
+	expected := committee.ShareKeys[share.Index-1]
+	if shareKey.X.Cmp(expected.X) != 0 || shareKey.Y.Cmp(expected.Y) != 0 {
+		err = fmt.Errorf("Key share %d does not match the committee's share key", share.Index)
+		return
+	}
+
+	return
+}
+
+// writePoint encodes a point as its two coordinates
+func writePoint(enc *crypto.Encoder, p *Point) (err error) {
+	if p == nil || p.X == nil || p.Y == nil {
+		err = fmt.Errorf("Cannot encode nil point")
+		return
+	}
+	if err = enc.WriteBigInt(p.X); err != nil {
+		return
+	}
+	if err = enc.WriteBigInt(p.Y); err != nil {
+		return
+	}
+	return
+}
+
+// readPoint decodes a point written with writePoint, and checks that it's on the curve
+func readPoint(curve elliptic.Curve, dec *crypto.Decoder) (p *Point, err error) {
+	p = new(Point)
+	if p.X, err = dec.ReadBigInt(); err != nil {
+		return
+	}
+	if p.Y, err = dec.ReadBigInt(); err != nil {
+		return
+	}
+	if !onCurve(curve, p) {
+		err = fmt.Errorf("Point is not on the curve")
+		return
+	}
+	return
+}
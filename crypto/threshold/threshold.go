@@ -0,0 +1,471 @@
+// Package threshold is threshold decryption, as an alternative to timelock puzzles. The committee
+// private key is split between key holders with Shamir secret sharing, so any threshold of them
+// can decrypt together but fewer learn nothing. Keys are hidden in capsules, like ECIES: the
+// capsule has R = rG, and the key is masked with a hash of rX, where X is the committee public
+// key. Each key holder gives out x_i * R along with a proof that it used its real share, and the
+// shares are combined with Lagrange interpolation to get rX back.
+//
+// Every capsule is bound to a label, like the auction and pair an order is for. The label is
+// hashed into the mask and into every proof, and the capsule proves knowledge of r for its label,
+// so a capsule can't be copied under another label without breaking it. This lets key holders
+// decide which capsules to give out shares for by their label.
+package threshold
+
+import (
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+const (
+	// shareProofDomain separates the challenge hash for decryption share proofs from anything
+	// else we hash
+	shareProofDomain = "opencx-threshold-share"
+
+	// padDomain separates the hash that masks the key from anything else we hash
+	padDomain = "opencx-threshold-pad"
+
+	// capsuleProofDomain separates the challenge hash for capsule proofs from anything else we
+	// hash
+	capsuleProofDomain = "opencx-threshold-capsule"
+
+	// MaxKeySize is the longest key a capsule can hide, the size of the hash that masks it
+	MaxKeySize = sha256.Size
+
+	// MaxLabelSize is the longest label a capsule can be bound to
+	MaxLabelSize = 256
+)
+
+// Point is a point on the committee's curve
+type Point struct {
+	X *big.Int
+	Y *big.Int
+}
+
+// CommitteeKey is the public key of a decryption committee. Anyone can encrypt to it with just the
+// curve and PubKey, but checking decryption shares also needs the public key of every share.
+type CommitteeKey struct {
+	Curve elliptic.Curve
+	// Threshold is how many key holders have to give out decryption shares to decrypt
+	Threshold int
+	PubKey    *Point
+	// ShareKeys are the public keys x_i * G of the key shares, where ShareKeys[i-1] is for the
+	// share with index i
+	ShareKeys []*Point
+}
+
+// KeyShare is one key holder's share of the committee private key, x_i = f(i) for the secret
+// polynomial f
+type KeyShare struct {
+	Index  uint32
+	Secret *big.Int
+}
+
+// DecryptionShare is D = x_i * R for a capsule, from the key holder with index i, along with a
+// proof that log_G(x_i * G) = log_R(D), so a key holder can't give out a bad share.
+type DecryptionShare struct {
+	Index uint32
+	D     *Point
+	C     *big.Int
+	Z     *big.Int
+}
+
+// randScalar returns a random scalar between 1 and the order of the curve
+func randScalar(curve elliptic.Curve) (scalar *big.Int, err error) {
+	if scalar, err = rand.Int(rand.Reader, new(big.Int).Sub(curve.Params().N, big.NewInt(1))); err != nil {
+		err = fmt.Errorf("Error getting random scalar: %s", err)
+		return
+	}
+	scalar.Add(scalar, big.NewInt(1))
+	return
+}
+
+// baseMult returns k * G
+func baseMult(curve elliptic.Curve, k *big.Int) (p *Point) {
+	x, y := curve.ScalarBaseMult(k.Bytes())
+	p = &Point{X: x, Y: y}
+	return
+}
+
+// mult returns k * p
+func mult(curve elliptic.Curve, p *Point, k *big.Int) (prod *Point) {
+	x, y := curve.ScalarMult(p.X, p.Y, k.Bytes())
+	prod = &Point{X: x, Y: y}
+	return
+}
+
+// add returns p + q
+func add(curve elliptic.Curve, p *Point, q *Point) (sum *Point) {
+	x, y := curve.Add(p.X, p.Y, q.X, q.Y)
+	sum = &Point{X: x, Y: y}
+	return
+}
+
+// onCurve returns whether or not the point is on the curve
+func onCurve(curve elliptic.Curve, p *Point) bool {
+	return p != nil && p.X != nil && p.Y != nil && curve.IsOnCurve(p.X, p.Y)
+}
+
+// Deal creates a committee key and splits the private key into numHolders shares, any threshold
+// of which can decrypt. Whoever deals sees the private key, so they have to be trusted to forget
+// it once the shares are handed out.
+func Deal(curve elliptic.Curve, threshold int, numHolders int) (committee *CommitteeKey, shares []*KeyShare, err error) {
+	if curve == nil {
+		err = fmt.Errorf("Cannot deal committee key with nil curve")
+		return
+	}
+
+	if threshold < 1 || numHolders < threshold {
+		err = fmt.Errorf("Threshold must be between 1 and the number of key holders, got %d of %d", threshold, numHolders)
+		return
+	}
+
+	// f(z) = a_0 + a_1 z + ... + a_(threshold-1) z^(threshold-1), and the private key is a_0
+	n := curve.Params().N
+	coeffs := make([]*big.Int, threshold)
+	for i := range coeffs {
+		if coeffs[i], err = randScalar(curve); err != nil {
+			err = fmt.Errorf("Error getting coefficient for committee key: %s", err)
+			return
+		}
+	}
+
+	committee = &CommitteeKey{
+		Curve:     curve,
+		Threshold: threshold,
+		PubKey:    baseMult(curve, coeffs[0]),
+	}
+
+	for i := 1; i <= numHolders; i++ {
+		// Horner's method for f(i)
+		z := big.NewInt(int64(i))
+		secret := new(big.Int)
+		for j := threshold - 1; j >= 0; j-- {
+			secret.Mul(secret, z)
+			secret.Add(secret, coeffs[j])
+			secret.Mod(secret, n)
+		}
+
+		shares = append(shares, &KeyShare{
+			Index:  uint32(i),
+			Secret: secret,
+		})
+		committee.ShareKeys = append(committee.ShareKeys, baseMult(curve, secret))
+	}
+
+	return
+}
+
+// MarshalPubKey returns the committee public key as an uncompressed point, which is all anyone
+// needs to encrypt to the committee
+func (committee *CommitteeKey) MarshalPubKey() (raw []byte) {
+	raw = elliptic.Marshal(committee.Curve, committee.PubKey.X, committee.PubKey.Y)
+	return
+}
+
+// ParsePubKey parses a committee public key created with MarshalPubKey. The committee key this
+// returns can encrypt, but can't check decryption shares.
+func ParsePubKey(curve elliptic.Curve, raw []byte) (committee *CommitteeKey, err error) {
+	x, y := elliptic.Unmarshal(curve, raw)
+	if x == nil {
+		err = fmt.Errorf("Committee public key is not a point on the curve")
+		return
+	}
+
+	committee = &CommitteeKey{
+		Curve:  curve,
+		PubKey: &Point{X: x, Y: y},
+	}
+	return
+}
+
+// writeLabel writes the label to the hasher, length prefixed so it can't run into what comes next
+func writeLabel(hasher hash.Hash, label []byte) {
+	lenBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenBytes, uint32(len(label)))
+	hasher.Write(lenBytes)
+	hasher.Write(label)
+}
+
+// challenge computes the Fiat-Shamir challenge for a decryption share proof, for a capsule with
+// the label
+func (committee *CommitteeKey) challenge(index uint32, label []byte, points ...*Point) (c *big.Int) {
+	hasher := sha256.New()
+	hasher.Write([]byte(shareProofDomain))
+	writeLabel(hasher, label)
+	indexBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(indexBytes, index)
+	hasher.Write(indexBytes)
+	for _, p := range points {
+		hasher.Write(elliptic.Marshal(committee.Curve, p.X, p.Y))
+	}
+	c = new(big.Int).SetBytes(hasher.Sum(nil))
+	c.Mod(c, committee.Curve.Params().N)
+	return
+}
+
+// pad is the hash of the label and the shared point rX that masks the key in a capsule
+func (committee *CommitteeKey) pad(label []byte, shared *Point) (pad []byte) {
+	hasher := sha256.New()
+	hasher.Write([]byte(padDomain))
+	writeLabel(hasher, label)
+	hasher.Write(elliptic.Marshal(committee.Curve, shared.X, shared.Y))
+	pad = hasher.Sum(nil)
+	return
+}
+
+// capsuleChallenge computes the Fiat-Shamir challenge for the proof that whoever created the
+// capsule knows r, which binds R to the label and masked key
+func (committee *CommitteeKey) capsuleChallenge(label []byte, r *Point, ck []byte, w *Point) (c *big.Int) {
+	hasher := sha256.New()
+	hasher.Write([]byte(capsuleProofDomain))
+	writeLabel(hasher, label)
+	hasher.Write(elliptic.Marshal(committee.Curve, r.X, r.Y))
+	writeLabel(hasher, ck)
+	hasher.Write(elliptic.Marshal(committee.Curve, w.X, w.Y))
+	c = new(big.Int).SetBytes(hasher.Sum(nil))
+	c.Mod(c, committee.Curve.Params().N)
+	return
+}
+
+// CheckCapsule checks that the capsule could have been created for this committee
+func (committee *CommitteeKey) CheckCapsule(capsule *Capsule) (err error) {
+	if capsule == nil {
+		err = fmt.Errorf("Capsule is nil")
+		return
+	}
+
+	if !onCurve(committee.Curve, capsule.R) {
+		err = fmt.Errorf("Capsule point is not on the committee curve")
+		return
+	}
+
+	if len(capsule.CK) == 0 || len(capsule.CK) > MaxKeySize {
+		err = fmt.Errorf("Capsule key is %d bytes, must be between 1 and %d bytes", len(capsule.CK), MaxKeySize)
+		return
+	}
+
+	if len(capsule.Label) > MaxLabelSize {
+		err = fmt.Errorf("Capsule label is %d bytes, can be at most %d bytes", len(capsule.Label), MaxLabelSize)
+		return
+	}
+
+	// Schnorr: W = F G - E R is what the creator committed to if they know r
+	curve := committee.Curve
+	n := curve.Params().N
+	if capsule.E == nil || capsule.F == nil || capsule.E.Sign() < 0 || capsule.E.Cmp(n) >= 0 || capsule.F.Sign() < 0 || capsule.F.Cmp(n) >= 0 {
+		err = fmt.Errorf("Capsule proof is missing or out of range")
+		return
+	}
+	negE := new(big.Int).Sub(n, capsule.E)
+	w := add(curve, baseMult(curve, capsule.F), mult(curve, capsule.R, negE))
+	if committee.capsuleChallenge(capsule.Label, capsule.R, capsule.CK, w).Cmp(capsule.E) != 0 {
+		err = fmt.Errorf("Capsule proof does not verify for its label")
+		return
+	}
+
+	return
+}
+
+// DecryptionShare creates this key holder's decryption share for a capsule, with a proof that
+// it's correct.
+func (share *KeyShare) DecryptionShare(committee *CommitteeKey, capsule *Capsule) (decShare *DecryptionShare, err error) {
+	if err = committee.CheckCapsule(capsule); err != nil {
+		err = fmt.Errorf("Cannot create decryption share for bad capsule: %s", err)
+		return
+	}
+
+	curve := committee.Curve
+	n := curve.Params().N
+
+	// Chaum-Pedersen: commit to w with A1 = wG and A2 = wR, then z = w + c x_i
+	var w *big.Int
+	if w, err = randScalar(curve); err != nil {
+		err = fmt.Errorf("Error getting nonce for decryption share proof: %s", err)
+		return
+	}
+
+	shareKey := baseMult(curve, share.Secret)
+	decShare = &DecryptionShare{
+		Index: share.Index,
+		D:     mult(curve, capsule.R, share.Secret),
+	}
+	decShare.C = committee.challenge(share.Index, capsule.Label, shareKey, capsule.R, decShare.D, baseMult(curve, w), mult(curve, capsule.R, w))
+	decShare.Z = new(big.Int).Mul(decShare.C, share.Secret)
+	decShare.Z.Add(decShare.Z, w)
+	decShare.Z.Mod(decShare.Z, n)
+	return
+}
+
+// VerifyShare checks the proof on a decryption share for a capsule, against the public key of the
+// share it claims to be from
+func (committee *CommitteeKey) VerifyShare(capsule *Capsule, decShare *DecryptionShare) (err error) {
+	if err = committee.CheckCapsule(capsule); err != nil {
+		err = fmt.Errorf("Cannot verify decryption share for bad capsule: %s", err)
+		return
+	}
+
+	if decShare == nil || decShare.C == nil || decShare.Z == nil {
+		err = fmt.Errorf("Decryption share is missing its proof")
+		return
+	}
+
+	if decShare.Index == 0 || int(decShare.Index) > len(committee.ShareKeys) {
+		err = fmt.Errorf("Decryption share has index %d, committee only has %d key holders", decShare.Index, len(committee.ShareKeys))
+		return
+	}
+
+	if !onCurve(committee.Curve, decShare.D) {
+		err = fmt.Errorf("Decryption share is not a point on the curve")
+		return
+	}
+
+	// A1 = zG - c X_i and A2 = zR - c D, which are what the key holder committed to if the share
+	// is honest
+	curve := committee.Curve
+	n := curve.Params().N
+	negC := new(big.Int).Sub(n, new(big.Int).Mod(decShare.C, n))
+	shareKey := committee.ShareKeys[decShare.Index-1]
+	a1 := add(curve, baseMult(curve, decShare.Z), mult(curve, shareKey, negC))
+	a2 := add(curve, mult(curve, capsule.R, decShare.Z), mult(curve, decShare.D, negC))
+
+	if committee.challenge(decShare.Index, capsule.Label, shareKey, capsule.R, decShare.D, a1, a2).Cmp(decShare.C) != 0 {
+		err = fmt.Errorf("Proof for decryption share %d does not verify", decShare.Index)
+		return
+	}
+
+	return
+}
+
+// Open combines decryption shares to get the key hidden in the capsule. Every share is checked,
+// and there have to be at least Threshold good shares from different key holders.
+func (committee *CommitteeKey) Open(capsule *Capsule, decShares []*DecryptionShare) (key []byte, err error) {
+	var good []*DecryptionShare
+	seen := make(map[uint32]bool)
+	for _, decShare := range decShares {
+		if err = committee.VerifyShare(capsule, decShare); err != nil {
+			err = fmt.Errorf("Bad decryption share: %s", err)
+			return
+		}
+		if seen[decShare.Index] {
+			continue
+		}
+		seen[decShare.Index] = true
+		good = append(good, decShare)
+	}
+
+	if committee.Threshold < 1 || len(good) < committee.Threshold {
+		err = fmt.Errorf("Need %d decryption shares to open capsule, only have %d", committee.Threshold, len(good))
+		return
+	}
+	good = good[:committee.Threshold]
+
+	// rX = sum of lambda_i * D_i, where lambda_i is the Lagrange coefficient for f(0)
+	curve := committee.Curve
+	n := curve.Params().N
+	var shared *Point
+	for _, decShare := range good {
+		lambda := big.NewInt(1)
+		for _, other := range good {
+			if other.Index == decShare.Index {
+				continue
+			}
+			num := big.NewInt(int64(other.Index))
+			den := new(big.Int).Sub(num, big.NewInt(int64(decShare.Index)))
+			den.Mod(den, n)
+			lambda.Mul(lambda, num)
+			lambda.Mul(lambda, new(big.Int).ModInverse(den, n))
+			lambda.Mod(lambda, n)
+		}
+
+		term := mult(curve, decShare.D, lambda)
+		if shared == nil {
+			shared = term
+		} else {
+			shared = add(curve, shared, term)
+		}
+	}
+
+	pad := committee.pad(capsule.Label, shared)
+	key = make([]byte, len(capsule.CK))
+	for i := range key {
+		key[i] = capsule.CK[i] ^ pad[i]
+	}
+	return
+}
+
+// Timelock hides keys in capsules for a committee. It satisfies crypto.Timelock so it can be used
+// anywhere a timelock can, but the time parameter is ignored, since it's the committee that
+// decides when to decrypt.
+type Timelock struct {
+	key       []byte
+	label     []byte
+	committee *CommitteeKey
+}
+
+// NewTimelock creates a Timelock that hides the key for the committee, in capsules bound to the
+// label
+func NewTimelock(key []byte, label []byte, committee *CommitteeKey) (timelock crypto.Timelock, err error) {
+	if committee == nil || committee.Curve == nil || !onCurve(committee.Curve, committee.PubKey) {
+		err = fmt.Errorf("Cannot hide key for committee without a valid public key")
+		return
+	}
+
+	if len(key) == 0 || len(key) > MaxKeySize {
+		err = fmt.Errorf("Key is %d bytes, must be between 1 and %d bytes", len(key), MaxKeySize)
+		return
+	}
+
+	if len(label) > MaxLabelSize {
+		err = fmt.Errorf("Label is %d bytes, can be at most %d bytes", len(label), MaxLabelSize)
+		return
+	}
+
+	timelock = &Timelock{
+		key:       key,
+		label:     append([]byte{}, label...),
+		committee: committee,
+	}
+	return
+}
+
+// SetupTimelockPuzzle hides the key in a capsule for the committee. The time t is ignored.
+func (tl *Timelock) SetupTimelockPuzzle(t uint64) (puzzle crypto.Puzzle, answer []byte, err error) {
+	var r *big.Int
+	if r, err = randScalar(tl.committee.Curve); err != nil {
+		err = fmt.Errorf("Error getting ephemeral key for capsule: %s", err)
+		return
+	}
+
+	curve := tl.committee.Curve
+	pad := tl.committee.pad(tl.label, mult(curve, tl.committee.PubKey, r))
+	capsule := &Capsule{
+		R:     baseMult(curve, r),
+		CK:    make([]byte, len(tl.key)),
+		Label: tl.label,
+	}
+	for i := range capsule.CK {
+		capsule.CK[i] = tl.key[i] ^ pad[i]
+	}
+
+	// Prove we know r for this label with F = w + E r, so the capsule can't be relabeled
+	var w *big.Int
+	if w, err = randScalar(curve); err != nil {
+		err = fmt.Errorf("Error getting nonce for capsule proof: %s", err)
+		return
+	}
+	capsule.E = tl.committee.capsuleChallenge(capsule.Label, capsule.R, capsule.CK, baseMult(curve, w))
+	capsule.F = new(big.Int).Mul(capsule.E, r)
+	capsule.F.Add(capsule.F, w)
+	capsule.F.Mod(capsule.F, curve.Params().N)
+
+	puzzle = capsule
+	answer = append([]byte{}, tl.key...)
+	return
+}
@@ -0,0 +1,273 @@
+package threshold
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"math/big"
+	"testing"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+// testLabel is the label test capsules are bound to
+var testLabel = []byte("auction 1")
+
+// createTestCapsule deals a committee and hides a key for it
+func createTestCapsule(t *testing.T, threshold int, numHolders int) (committee *CommitteeKey, shares []*KeyShare, capsule *Capsule, key []byte) {
+	var err error
+	if committee, shares, err = Deal(elliptic.P256(), threshold, numHolders); err != nil {
+		t.Fatalf("Error dealing committee: %s", err)
+	}
+
+	key = []byte("opencx committee key")
+	var timelock crypto.Timelock
+	if timelock, err = NewTimelock(key, testLabel, committee); err != nil {
+		t.Fatalf("Error creating committee timelock: %s", err)
+	}
+
+	var puzzle crypto.Puzzle
+	var answer []byte
+	if puzzle, answer, err = timelock.SetupTimelockPuzzle(0); err != nil {
+		t.Fatalf("Error creating capsule: %s", err)
+	}
+
+	if !bytes.Equal(answer, key) {
+		t.Fatalf("Capsule answer was %x, expected the key %x", answer, key)
+	}
+
+	capsule = puzzle.(*Capsule)
+	return
+}
+
+// decryptionShares gets a decryption share for the capsule from each of the key shares
+func decryptionShares(t *testing.T, committee *CommitteeKey, capsule *Capsule, shares []*KeyShare) (decShares []*DecryptionShare) {
+	for _, share := range shares {
+		decShare, err := share.DecryptionShare(committee, capsule)
+		if err != nil {
+			t.Fatalf("Error creating decryption share %d: %s", share.Index, err)
+		}
+		decShares = append(decShares, decShare)
+	}
+	return
+}
+
+func TestOpenCapsule(t *testing.T) {
+	var err error
+	committee, shares, capsule, key := createTestCapsule(t, 3, 5)
+
+	// any 3 of the 5 should work
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}} {
+		var subShares []*KeyShare
+		for _, i := range subset {
+			subShares = append(subShares, shares[i])
+		}
+
+		var opened []byte
+		if opened, err = committee.Open(capsule, decryptionShares(t, committee, capsule, subShares)); err != nil {
+			t.Errorf("Error opening capsule with shares %v: %s", subset, err)
+			return
+		}
+
+		if !bytes.Equal(opened, key) {
+			t.Errorf("Opened key with shares %v was %x, expected %x", subset, opened, key)
+			return
+		}
+	}
+
+	// the capsule should survive the canonical encoding
+	var raw []byte
+	if raw, err = capsule.Serialize(); err != nil {
+		t.Errorf("Error serializing capsule: %s", err)
+		return
+	}
+
+	decoded := new(Capsule)
+	if err = decoded.Deserialize(raw); err != nil {
+		t.Errorf("Error deserializing capsule: %s", err)
+		return
+	}
+
+	var answer []byte
+	opened := &OpenedCapsule{
+		Capsule:   decoded,
+		Committee: committee,
+		Shares:    decryptionShares(t, committee, decoded, shares[:3]),
+	}
+	if answer, err = opened.Solve(); err != nil {
+		t.Errorf("Error solving opened capsule: %s", err)
+		return
+	}
+
+	if !bytes.Equal(answer, key) {
+		t.Errorf("Opened capsule answer was %x, expected %x", answer, key)
+		return
+	}
+
+	if _, err = capsule.Solve(); err == nil {
+		t.Errorf("Capsule should not be solvable without shares")
+		return
+	}
+
+	return
+}
+
+func TestBadDecryptionShares(t *testing.T) {
+	var err error
+	committee, shares, capsule, _ := createTestCapsule(t, 3, 5)
+
+	decShares := decryptionShares(t, committee, capsule, shares[:3])
+	if _, err = committee.Open(capsule, decShares[:2]); err == nil {
+		t.Errorf("Should not open capsule with fewer shares than the threshold")
+		return
+	}
+
+	// the same share twice only counts once
+	if _, err = committee.Open(capsule, []*DecryptionShare{decShares[0], decShares[1], decShares[0]}); err == nil {
+		t.Errorf("Should not open capsule with a repeated share")
+		return
+	}
+
+	// a key holder can't give out a share from someone else's key
+	stolen := *decShares[0]
+	stolen.Index = 4
+	if err = committee.VerifyShare(capsule, &stolen); err == nil {
+		t.Errorf("Share with the wrong index should not verify")
+		return
+	}
+
+	// or a different point with the same proof
+	tampered := *decShares[1]
+	tampered.D = baseMult(committee.Curve, big.NewInt(7))
+	if _, err = committee.Open(capsule, []*DecryptionShare{decShares[0], &tampered, decShares[2]}); err == nil {
+		t.Errorf("Should not open capsule with a tampered share")
+		return
+	}
+
+	// a committee made from just the public key can't check shares
+	var pubOnly *CommitteeKey
+	if pubOnly, err = ParsePubKey(committee.Curve, committee.MarshalPubKey()); err != nil {
+		t.Errorf("Error parsing committee public key: %s", err)
+		return
+	}
+
+	if _, err = pubOnly.Open(capsule, decShares); err == nil {
+		t.Errorf("Should not open capsule without share public keys")
+		return
+	}
+
+	if _, _, err = Deal(elliptic.P256(), 4, 3); err == nil {
+		t.Errorf("Should not deal committee with a threshold larger than the number of key holders")
+		return
+	}
+
+	return
+}
+
+func TestSerializeCommitteeKeys(t *testing.T) {
+	var err error
+
+	committee, shares, capsule, key := createTestCapsule(t, 2, 3)
+
+	var raw []byte
+	if raw, err = committee.Serialize(); err != nil {
+		t.Errorf("Error serializing committee key: %s", err)
+		return
+	}
+
+	var decoded *CommitteeKey
+	if decoded, err = DeserializeCommitteeKey(elliptic.P256(), raw); err != nil {
+		t.Errorf("Error deserializing committee key: %s", err)
+		return
+	}
+
+	if !bytes.Equal(decoded.MarshalPubKey(), committee.MarshalPubKey()) || decoded.Threshold != committee.Threshold {
+		t.Errorf("Deserialized committee key does not match the original")
+		return
+	}
+
+	// shares that went through serialization should still open capsules for the decoded committee
+	var decodedShares []*KeyShare
+	for _, share := range shares[:2] {
+		if raw, err = share.Serialize(); err != nil {
+			t.Errorf("Error serializing key share %d: %s", share.Index, err)
+			return
+		}
+
+		decodedShare := new(KeyShare)
+		if err = decodedShare.Deserialize(raw); err != nil {
+			t.Errorf("Error deserializing key share %d: %s", share.Index, err)
+			return
+		}
+
+		if err = decoded.CheckShare(decodedShare); err != nil {
+			t.Errorf("Deserialized key share should match the committee: %s", err)
+			return
+		}
+		decodedShares = append(decodedShares, decodedShare)
+	}
+
+	var opened []byte
+	if opened, err = decoded.Open(capsule, decryptionShares(t, decoded, capsule, decodedShares)); err != nil {
+		t.Errorf("Error opening capsule with deserialized keys: %s", err)
+		return
+	}
+
+	if !bytes.Equal(opened, key) {
+		t.Errorf("Opened key %x, expected %x", opened, key)
+		return
+	}
+
+	wrongShare := &KeyShare{
+		Index:  shares[2].Index,
+		Secret: shares[0].Secret,
+	}
+	if err = decoded.CheckShare(wrongShare); err == nil {
+		t.Errorf("Key share with the wrong secret should not match the committee")
+		return
+	}
+
+	return
+}
+
+func TestRelabeledCapsule(t *testing.T) {
+	var err error
+	committee, shares, capsule, key := createTestCapsule(t, 2, 3)
+
+	if err = committee.CheckCapsule(capsule); err != nil {
+		t.Errorf("Capsule should check for its own label: %s", err)
+		return
+	}
+
+	// copying R under another label breaks the proof, so key holders can trust the label
+	relabeled := *capsule
+	relabeled.Label = []byte("auction 2")
+	if err = committee.CheckCapsule(&relabeled); err == nil {
+		t.Errorf("Relabeled capsule should not check")
+		return
+	}
+
+	if _, err = shares[0].DecryptionShare(committee, &relabeled); err == nil {
+		t.Errorf("Key holder should not give out a share for a relabeled capsule")
+		return
+	}
+
+	// shares for the real label don't verify or open under another label either
+	decShares := decryptionShares(t, committee, capsule, shares[:2])
+	if err = committee.VerifyShare(&relabeled, decShares[0]); err == nil {
+		t.Errorf("Share for one label should not verify for another")
+		return
+	}
+
+	var opened []byte
+	if opened, err = committee.Open(capsule, decShares); err != nil {
+		t.Errorf("Error opening capsule: %s", err)
+		return
+	}
+
+	if !bytes.Equal(opened, key) {
+		t.Errorf("Opened key %x, expected %x", opened, key)
+		return
+	}
+
+	return
+}
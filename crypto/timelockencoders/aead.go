@@ -0,0 +1,163 @@
+package timelockencoders
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/mit-dci/opencx/crypto"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// aeadKeyDomain separates the AEAD key from anything else we hash the puzzle answer for
+const aeadKeyDomain = "opencx-timelock-aead"
+
+// aeadKey derives a 256 bit AEAD key from the answer to a puzzle. The answer isn't always a
+// valid key size, since it depends on the puzzle, so we hash it.
+func aeadKey(answer []byte) (key []byte) {
+	hasher := sha256.New()
+	hasher.Write([]byte(aeadKeyDomain))
+	hasher.Write(answer)
+	key = hasher.Sum(nil)
+	return
+}
+
+// newAESGCM creates an AES-256-GCM AEAD from the key
+func newAESGCM(key []byte) (aead cipher.AEAD, err error) {
+	var block cipher.Block
+	if block, err = aes.NewCipher(key); err != nil {
+		err = fmt.Errorf("Error creating aes cipher for gcm: %s", err)
+		return
+	}
+
+	if aead, err = cipher.NewGCM(block); err != nil {
+		err = fmt.Errorf("Error creating gcm from aes cipher: %s", err)
+		return
+	}
+	return
+}
+
+// createPuzzleAEAD creates a timelock puzzle with time t and encrypts the message with an AEAD,
+// authenticating the additional data. The nonce is prepended to the ciphertext.
+func createPuzzleAEAD(t uint64, message []byte, additionalData []byte, puzzleCreator func(uint64, []byte) (crypto.Puzzle, []byte, error), newAEAD func([]byte) (cipher.AEAD, error)) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
+	var key []byte
+	if key, err = Generate16ByteKey(rand.Reader); err != nil {
+		err = fmt.Errorf("Could not generate key for aead puzzle: %s", err)
+		return
+	}
+
+	if puzzle, key, err = puzzleCreator(t, key); err != nil {
+		err = fmt.Errorf("Error while creating timelock puzzle for aead: %s", err)
+		return
+	}
+
+	var aead cipher.AEAD
+	if aead, err = newAEAD(aeadKey(key)); err != nil {
+		err = fmt.Errorf("Error creating aead for encryption in puzzle: %s", err)
+		return
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		err = fmt.Errorf("Error reading random reader for nonce: %s", err)
+		return
+	}
+
+	ciphertext = aead.Seal(nonce, nonce, message, additionalData)
+	return
+}
+
+// solvePuzzleAEAD solves the timelock puzzle and decrypts the ciphertext with an AEAD. This fails
+// if the ciphertext or additional data were changed.
+func solvePuzzleAEAD(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle, newAEAD func([]byte) (cipher.AEAD, error)) (message []byte, err error) {
+	if puzzle == nil {
+		err = fmt.Errorf("Puzzle cannot be nil, what are you solving")
+		return
+	}
+
+	var key []byte
+	if key, err = puzzle.Solve(); err != nil {
+		err = fmt.Errorf("Error solving auction puzzle: %s", err)
+		return
+	}
+
+	var aead cipher.AEAD
+	if aead, err = newAEAD(aeadKey(key)); err != nil {
+		err = fmt.Errorf("Error creating aead for decryption in puzzle: %s", err)
+		return
+	}
+
+	if len(ciphertext) < aead.NonceSize()+aead.Overhead() {
+		err = fmt.Errorf("Ciphertext is too short to have a nonce and tag")
+		return
+	}
+
+	if message, err = aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], additionalData); err != nil {
+		err = fmt.Errorf("Error authenticating ciphertext, it may have been tampered with: %s", err)
+		return
+	}
+
+	return
+}
+
+// checkAEADCiphertext checks that the ciphertext is long enough to have a nonce, a tag, and at
+// least one byte of message
+func checkAEADCiphertext(ciphertext []byte, nonceSize int, overhead int) (err error) {
+	if len(ciphertext) <= nonceSize+overhead {
+		err = fmt.Errorf("Ciphertext of length %d is too short, must be longer than the %d byte nonce and %d byte tag", len(ciphertext), nonceSize, overhead)
+		return
+	}
+	return
+}
+
+// CreateRSW2048A2PuzzleAESGCM creates a RSW timelock puzzle with time t and encrypts the message
+// using AES-GCM, authenticating the additional data.
+func CreateRSW2048A2PuzzleAESGCM(t uint64, message []byte, additionalData []byte) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
+	return CreatePuzzleAESGCM(t, message, additionalData, createRSWPuzzle)
+}
+
+// CreatePuzzleAESGCM creates a timelock puzzle with time t and encrypts the message using AES-GCM,
+// authenticating the additional data.
+func CreatePuzzleAESGCM(t uint64, message []byte, additionalData []byte, puzzleCreator func(uint64, []byte) (crypto.Puzzle, []byte, error)) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
+	return createPuzzleAEAD(t, message, additionalData, puzzleCreator, newAESGCM)
+}
+
+// CheckCiphertextAESGCM checks that the ciphertext looks like it was created by CreatePuzzleAESGCM
+func CheckCiphertextAESGCM(ciphertext []byte) (err error) {
+	// GCM uses a 12 byte nonce and a 16 byte tag
+	return checkAEADCiphertext(ciphertext, 12, 16)
+}
+
+// SolvePuzzleAESGCM solves the timelock puzzle and decrypts the ciphertext using AES-GCM. This
+// fails if the ciphertext or additional data were changed.
+func SolvePuzzleAESGCM(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle) (message []byte, err error) {
+	return solvePuzzleAEAD(ciphertext, additionalData, puzzle, newAESGCM)
+}
+
+// CreateRSW2048A2PuzzleChaCha20Poly1305 creates a RSW timelock puzzle with time t and encrypts the
+// message using ChaCha20-Poly1305, authenticating the additional data.
+func CreateRSW2048A2PuzzleChaCha20Poly1305(t uint64, message []byte, additionalData []byte) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
+	return CreatePuzzleChaCha20Poly1305(t, message, additionalData, createRSWPuzzle)
+}
+
+// CreatePuzzleChaCha20Poly1305 creates a timelock puzzle with time t and encrypts the message using
+// ChaCha20-Poly1305, authenticating the additional data.
+func CreatePuzzleChaCha20Poly1305(t uint64, message []byte, additionalData []byte, puzzleCreator func(uint64, []byte) (crypto.Puzzle, []byte, error)) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
+	return createPuzzleAEAD(t, message, additionalData, puzzleCreator, chacha20poly1305.New)
+}
+
+// CheckCiphertextChaCha20Poly1305 checks that the ciphertext looks like it was created by
+// CreatePuzzleChaCha20Poly1305
+func CheckCiphertextChaCha20Poly1305(ciphertext []byte) (err error) {
+	// ChaCha20-Poly1305 uses a 16 byte tag
+	return checkAEADCiphertext(ciphertext, chacha20poly1305.NonceSize, 16)
+}
+
+// SolvePuzzleChaCha20Poly1305 solves the timelock puzzle and decrypts the ciphertext using
+// ChaCha20-Poly1305. This fails if the ciphertext or additional data were changed.
+func SolvePuzzleChaCha20Poly1305(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle) (message []byte, err error) {
+	return solvePuzzleAEAD(ciphertext, additionalData, puzzle, chacha20poly1305.New)
+}
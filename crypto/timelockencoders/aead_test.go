@@ -0,0 +1,106 @@
+package timelockencoders
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/mit-dci/opencx/crypto"
+)
+
+// aeadEncoder is an AEAD create, check and solve function, so every AEAD can be tested the same way
+type aeadEncoder struct {
+	name   string
+	create func(uint64, []byte, []byte) ([]byte, crypto.Puzzle, error)
+	check  func([]byte) error
+	solve  func([]byte, []byte, crypto.Puzzle) ([]byte, error)
+}
+
+var aeadEncoders = []aeadEncoder{
+	{
+		name:   "aes-gcm",
+		create: CreateRSW2048A2PuzzleAESGCM,
+		check:  CheckCiphertextAESGCM,
+		solve:  SolvePuzzleAESGCM,
+	},
+	{
+		name:   "chacha20-poly1305",
+		create: CreateRSW2048A2PuzzleChaCha20Poly1305,
+		check:  CheckCiphertextChaCha20Poly1305,
+		solve:  SolvePuzzleChaCha20Poly1305,
+	},
+}
+
+func TestRSWAEAD(t *testing.T) {
+	var err error
+	message := []byte("RSW96 Full Scheme but authenticated!")
+	additionalData := []byte("auction id and pair")
+
+	for _, encoder := range aeadEncoders {
+		var ciphertext []byte
+		var puzzle crypto.Puzzle
+		if ciphertext, puzzle, err = encoder.create(10000, message, additionalData); err != nil {
+			t.Errorf("Error creating %s puzzle: %s", encoder.name, err)
+			return
+		}
+
+		if err = encoder.check(ciphertext); err != nil {
+			t.Errorf("Ciphertext for %s should pass the check: %s", encoder.name, err)
+			return
+		}
+
+		var newMessage []byte
+		if newMessage, err = encoder.solve(ciphertext, additionalData, puzzle); err != nil {
+			t.Errorf("Error solving %s puzzle: %s", encoder.name, err)
+			return
+		}
+
+		if !bytes.Equal(newMessage, message) {
+			t.Errorf("Messages not equal for %s", encoder.name)
+			return
+		}
+	}
+
+	return
+}
+
+func TestTamperedAEAD(t *testing.T) {
+	var err error
+	message := []byte("RSW96 Full Scheme but authenticated!")
+	additionalData := []byte("auction id and pair")
+
+	for _, encoder := range aeadEncoders {
+		var ciphertext []byte
+		var puzzle crypto.Puzzle
+		if ciphertext, puzzle, err = encoder.create(10000, message, additionalData); err != nil {
+			t.Errorf("Error creating %s puzzle: %s", encoder.name, err)
+			return
+		}
+
+		// flip a bit in every byte of the ciphertext, including the nonce and tag
+		for i := range ciphertext {
+			tampered := append([]byte{}, ciphertext...)
+			tampered[i] ^= 0x01
+			if _, err = encoder.solve(tampered, additionalData, puzzle); err == nil {
+				t.Errorf("Ciphertext for %s tampered at byte %d should not decrypt", encoder.name, i)
+				return
+			}
+		}
+
+		if _, err = encoder.solve(ciphertext, []byte("different auction and pair"), puzzle); err == nil {
+			t.Errorf("Ciphertext for %s should not decrypt with different additional data", encoder.name)
+			return
+		}
+
+		if _, err = encoder.solve(ciphertext[:len(ciphertext)-1], additionalData, puzzle); err == nil {
+			t.Errorf("Truncated ciphertext for %s should not decrypt", encoder.name)
+			return
+		}
+
+		if err = encoder.check(ciphertext[:16]); err == nil {
+			t.Errorf("Ciphertext for %s that is too short should not pass the check", encoder.name)
+			return
+		}
+	}
+
+	return
+}
@@ -46,6 +46,16 @@ func CreateRSW2048A2PuzzleRSA(t uint64, message []byte) (ciphertext []byte, puzz
 	return
 }
 
+// CheckCiphertextRSA checks that the ciphertext looks like it was created by CreateRSW2048A2PuzzleRSA,
+// so it's exactly as long as the 2048 bit modulus.
+func CheckCiphertextRSA(ciphertext []byte) (err error) {
+	if len(ciphertext) != 2048/8 {
+		err = fmt.Errorf("Ciphertext is %d bytes, must be %d bytes for a 2048 bit RSA key", len(ciphertext), 2048/8)
+		return
+	}
+	return
+}
+
 // SolvePuzzleRSA solves the timelock puzzle and decrypts the ciphertext using RSA. We assume the key is in PKCS1 format
 func SolvePuzzleRSA(ciphertext []byte, puzzle crypto.Puzzle) (message []byte, err error) {
 	if puzzle == nil {
@@ -118,6 +128,20 @@ func CreateRSW2048A2PuzzleECIES(t uint64, message []byte) (ciphertext []byte, pu
 	return
 }
 
+// eciesOverhead is how much longer an ECIES ciphertext is than the message. This is the uncompressed
+// ephemeral public key, the IV, and the HMAC-SHA256 tag.
+const eciesOverhead = 65 + 16 + 32
+
+// CheckCiphertextECIES checks that the ciphertext looks like it was created by CreateRSW2048A2PuzzleECIES,
+// so it has room for the ephemeral key, IV, tag, and at least one byte of message.
+func CheckCiphertextECIES(ciphertext []byte) (err error) {
+	if len(ciphertext) <= eciesOverhead {
+		err = fmt.Errorf("Ciphertext is %d bytes, must be more than %d bytes for ecies", len(ciphertext), eciesOverhead)
+		return
+	}
+	return
+}
+
 // SolvePuzzleECIES solves the timelock puzzle and decrypts the ciphertext using ECIES. We assume the key is an ASN.1 ECPKS
 func SolvePuzzleECIES(ciphertext []byte, puzzle crypto.Puzzle) (message []byte, err error) {
 	if puzzle == nil {
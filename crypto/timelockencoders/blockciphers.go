@@ -13,6 +13,7 @@ import (
 	"github.com/mit-dci/opencx/crypto"
 	"github.com/mit-dci/opencx/crypto/hashtimelock"
 	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/crypto/threshold"
 )
 
 func createSHAPuzzle(t uint64, key []byte) (puzzle crypto.Puzzle, anskey []byte, err error) {
@@ -46,6 +47,49 @@ func createRSWPuzzle(t uint64, key []byte) (puzzle crypto.Puzzle, anskey []byte,
 	return
 }
 
+// SharedPuzzleCreator returns a puzzle creator for RSW puzzles over the shared modulus in params,
+// which can be used with CreatePuzzleRC5, CreatePuzzleRC6, and CreatePuzzleAES. The time passed to
+// those must be the time in the params.
+func SharedPuzzleCreator(params *rsw.SharedParams) (puzzleCreator func(uint64, []byte) (crypto.Puzzle, []byte, error)) {
+	puzzleCreator = func(t uint64, key []byte) (puzzle crypto.Puzzle, anskey []byte, err error) {
+		var timelock crypto.Timelock
+		if timelock, err = rsw.NewShared(key, params); err != nil {
+			err = fmt.Errorf("Error creating new shared rsw timelock for puzzle: %s", err)
+			return
+		}
+
+		if puzzle, anskey, err = timelock.SetupTimelockPuzzle(t); err != nil {
+			err = fmt.Errorf("Error setting up timelock while creating shared rsw puzzle: %s", err)
+			return
+		}
+
+		return
+	}
+	return
+}
+
+// CommitteePuzzleCreator returns a puzzle creator that hides keys in capsules for a decryption
+// committee instead of timelock puzzles, bound to the label. The time passed to the Create
+// functions is ignored, and the capsules can only be opened with decryption shares from the
+// committee.
+func CommitteePuzzleCreator(committee *threshold.CommitteeKey, label []byte) (puzzleCreator func(uint64, []byte) (crypto.Puzzle, []byte, error)) {
+	puzzleCreator = func(t uint64, key []byte) (puzzle crypto.Puzzle, anskey []byte, err error) {
+		var timelock crypto.Timelock
+		if timelock, err = threshold.NewTimelock(key, label, committee); err != nil {
+			err = fmt.Errorf("Error creating new committee timelock for puzzle: %s", err)
+			return
+		}
+
+		if puzzle, anskey, err = timelock.SetupTimelockPuzzle(t); err != nil {
+			err = fmt.Errorf("Error setting up committee capsule for puzzle: %s", err)
+			return
+		}
+
+		return
+	}
+	return
+}
+
 // CreateRSW2048A2PuzzleRC5 creates a RSW timelock puzzle with time t and encrypts the message using RC5. This is consistent with the scheme described in RSW96.
 func CreateRSW2048A2PuzzleRC5(t uint64, message []byte) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
 	return CreatePuzzleRC5(t, message, createRSWPuzzle)
@@ -106,6 +150,56 @@ func CreatePuzzleRC5(t uint64, message []byte, puzzleCreator func(uint64, []byte
 	return
 }
 
+// checkBlockCiphertext checks that the ciphertext has an IV and at least one block of message
+// after it, for a block cipher in CFB mode.
+func checkBlockCiphertext(blockCipher cipher.Block, ciphertext []byte) (err error) {
+	if len(ciphertext) < 2*blockCipher.BlockSize() {
+		err = fmt.Errorf("Ciphertext is %d bytes, must have an IV and at least one block, so at least %d bytes", len(ciphertext), 2*blockCipher.BlockSize())
+		return
+	}
+	return
+}
+
+// CheckCiphertextRC5 checks that the ciphertext looks like it was created by CreatePuzzleRC5, so
+// it has an IV and at least one block of message after it.
+func CheckCiphertextRC5(ciphertext []byte) (err error) {
+	// The key doesn't matter, we just want the block size
+	var RC5Cipher cipher.Block
+	if RC5Cipher, err = rc5.New(make([]byte, 16)); err != nil {
+		err = fmt.Errorf("Could not create rc5 cipher to check ciphertext: %s", err)
+		return
+	}
+
+	err = checkBlockCiphertext(RC5Cipher, ciphertext)
+	return
+}
+
+// CheckCiphertextRC6 checks that the ciphertext looks like it was created by CreatePuzzleRC6, so
+// it has an IV and at least one block of message after it.
+func CheckCiphertextRC6(ciphertext []byte) (err error) {
+	var RC6Cipher cipher.Block
+	if RC6Cipher, err = rc6.New(make([]byte, 16)); err != nil {
+		err = fmt.Errorf("Could not create rc6 cipher to check ciphertext: %s", err)
+		return
+	}
+
+	err = checkBlockCiphertext(RC6Cipher, ciphertext)
+	return
+}
+
+// CheckCiphertextAES checks that the ciphertext looks like it was created by CreatePuzzleAES, so
+// it has an IV and at least one block of message after it.
+func CheckCiphertextAES(ciphertext []byte) (err error) {
+	var AESCipher cipher.Block
+	if AESCipher, err = aes.NewCipher(make([]byte, 16)); err != nil {
+		err = fmt.Errorf("Could not create aes cipher to check ciphertext: %s", err)
+		return
+	}
+
+	err = checkBlockCiphertext(AESCipher, ciphertext)
+	return
+}
+
 // SolvePuzzleRC5 solves the timelock puzzle and decrypts the ciphertext using RC5
 func SolvePuzzleRC5(ciphertext []byte, puzzle crypto.Puzzle) (message []byte, err error) {
 	if puzzle == nil {
@@ -3,6 +3,8 @@ package timelockencoders
 import (
 	"bytes"
 	"testing"
+
+	"github.com/mit-dci/opencx/crypto/rsw"
 )
 
 func TestRSWAES(t *testing.T) {
@@ -200,3 +202,35 @@ func TestRSWRC6(t *testing.T) {
 
 	return
 }
+
+func TestSharedAES(t *testing.T) {
+	message := make([]byte, 32)
+	copy(message, []byte("Shared modulus RSW with AES!"))
+
+	// Computing the params takes as long as solving a puzzle, but only happens once
+	params, err := rsw.NewRSA2048SharedParams(100000)
+	if err != nil {
+		t.Fatalf("Error creating shared params: %s", err)
+	}
+
+	ciphertext, puzzle, err := CreatePuzzleAES(100000, message, SharedPuzzleCreator(params))
+	if err != nil {
+		t.Fatalf("Error creating puzzle: %s", err)
+	}
+
+	newMessage, err := SolvePuzzleAES(ciphertext, puzzle)
+	if err != nil {
+		t.Fatalf("Error solving puzzle: %s", err)
+	}
+
+	if !bytes.Equal(newMessage, message) {
+		t.Fatalf("Messages not equal")
+	}
+
+	// The params are only good for the time they were computed for
+	if _, _, err = CreatePuzzleAES(1000, message, SharedPuzzleCreator(params)); err == nil {
+		t.Fatalf("Should not create shared puzzle with a different time than the params")
+	}
+
+	return
+}
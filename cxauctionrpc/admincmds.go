@@ -0,0 +1,219 @@
+package cxauctionrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// GetNonceArgs holds the args for the getnonce command
+type GetNonceArgs struct {
+	Pubkey [33]byte
+}
+
+// GetNonceReply holds the reply for the getnonce command
+type GetNonceReply struct {
+	Nonce  [32]byte
+	Expiry time.Time
+	match.ErrorReply
+}
+
+// GetNonce issues a nonce for a pubkey to sign one request with. Every signed command needs a new
+// one, so a signed request can't be replayed.
+func (cl *OpencxAuctionRPC) GetNonce(args GetNonceArgs, reply *GetNonceReply) (err error) {
+	defer reply.SetError(&err)
+
+	if reply.Nonce, reply.Expiry, err = cl.Server.IssueNonce(args.Pubkey); err != nil {
+		err = fmt.Errorf("Error issuing nonce for GetNonce RPC command: %s", err)
+		return
+	}
+	return
+}
+
+// PauseAuctionsArgs holds the args for the pauseauctions command
+type PauseAuctionsArgs struct {
+	Pair match.Pair
+	// Request is signed by the admin key
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a pauseauctions request commits to
+func (args *PauseAuctionsArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs(args.Pair.Serialize())
+	return
+}
+
+// PauseAuctionsReply holds the reply for the pauseauctions command
+type PauseAuctionsReply struct {
+	match.ErrorReply
+}
+
+// PauseAuctions pauses the auction clock for a pair. Only the admin key can do this.
+func (cl *OpencxAuctionRPC) PauseAuctions(args PauseAuctionsArgs, reply *PauseAuctionsReply) (err error) {
+	defer reply.SetError(&err)
+
+	if err = cl.Server.AdminCommandVerify("OpencxAuctionRPC.PauseAuctions", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying admin signature for PauseAuctions RPC command: %s", err)
+		return
+	}
+
+	if err = cl.Server.PauseAuctions(&args.Pair); err != nil {
+		err = fmt.Errorf("Error pausing auctions for PauseAuctions RPC command: %s", err)
+		return
+	}
+
+	logging.Infof("Paused auctions for pair %s", args.Pair.String())
+	return
+}
+
+// ResumeAuctionsArgs holds the args for the resumeauctions command
+type ResumeAuctionsArgs struct {
+	Pair match.Pair
+	// Request is signed by the admin key
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a resumeauctions request commits to
+func (args *ResumeAuctionsArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs(args.Pair.Serialize())
+	return
+}
+
+// ResumeAuctionsReply holds the reply for the resumeauctions command
+type ResumeAuctionsReply struct {
+	match.ErrorReply
+}
+
+// ResumeAuctions resumes the auction clock for a pair. Only the admin key can do this.
+func (cl *OpencxAuctionRPC) ResumeAuctions(args ResumeAuctionsArgs, reply *ResumeAuctionsReply) (err error) {
+	defer reply.SetError(&err)
+
+	if err = cl.Server.AdminCommandVerify("OpencxAuctionRPC.ResumeAuctions", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying admin signature for ResumeAuctions RPC command: %s", err)
+		return
+	}
+
+	if err = cl.Server.ResumeAuctions(&args.Pair); err != nil {
+		err = fmt.Errorf("Error resuming auctions for ResumeAuctions RPC command: %s", err)
+		return
+	}
+
+	logging.Infof("Resumed auctions for pair %s", args.Pair.String())
+	return
+}
+
+// SetAuctionScheduleArgs holds the args for the setauctionschedule command
+type SetAuctionScheduleArgs struct {
+	Pair       match.Pair
+	Duration   time.Duration
+	Aligned    bool
+	Recovery   string
+	MaxRetries uint64
+	RetryDelay time.Duration
+	// Request is signed by the admin key
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a setauctionschedule request commits to, including the whole
+// schedule
+func (args *SetAuctionScheduleArgs) ArgsHash() (argsHash [32]byte) {
+	var durationBytes, maxRetriesBytes, retryDelayBytes [8]byte
+	binary.BigEndian.PutUint64(durationBytes[:], uint64(args.Duration))
+	binary.BigEndian.PutUint64(maxRetriesBytes[:], args.MaxRetries)
+	binary.BigEndian.PutUint64(retryDelayBytes[:], uint64(args.RetryDelay))
+	alignedBytes := []byte{0x00}
+	if args.Aligned {
+		alignedBytes[0] = 0x01
+	}
+	argsHash = cxauth.HashArgs(args.Pair.Serialize(), durationBytes[:], alignedBytes, []byte(args.Recovery), maxRetriesBytes[:], retryDelayBytes[:])
+	return
+}
+
+// SetAuctionScheduleReply holds the reply for the setauctionschedule command
+type SetAuctionScheduleReply struct {
+	match.ErrorReply
+}
+
+// SetAuctionSchedule sets the auction schedule for a pair. Only the admin key can do this. Whether
+// or not the pair is paused stays the same.
+func (cl *OpencxAuctionRPC) SetAuctionSchedule(args SetAuctionScheduleArgs, reply *SetAuctionScheduleReply) (err error) {
+	defer reply.SetError(&err)
+
+	if err = cl.Server.AdminCommandVerify("OpencxAuctionRPC.SetAuctionSchedule", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying admin signature for SetAuctionSchedule RPC command: %s", err)
+		return
+	}
+
+	var oldSchedule *cxauctionserver.AuctionSchedule
+	if oldSchedule, err = cl.Server.GetAuctionSchedule(&args.Pair); err != nil {
+		err = fmt.Errorf("Error getting old schedule for SetAuctionSchedule RPC command: %s", err)
+		return
+	}
+
+	newSchedule := &cxauctionserver.AuctionSchedule{
+		Duration:   args.Duration,
+		Aligned:    args.Aligned,
+		Paused:     oldSchedule.Paused,
+		MaxRetries: args.MaxRetries,
+		RetryDelay: args.RetryDelay,
+	}
+	if newSchedule.Recovery, err = cxauctionserver.RecoveryPolicyFromString(args.Recovery); err != nil {
+		err = fmt.Errorf("Error parsing recovery policy for SetAuctionSchedule RPC command: %s", err)
+		return
+	}
+
+	if err = cl.Server.SetAuctionSchedule(&args.Pair, newSchedule); err != nil {
+		err = fmt.Errorf("Error setting schedule for SetAuctionSchedule RPC command: %s", err)
+		return
+	}
+
+	logging.Infof("Set auction schedule for pair %s to every %s", args.Pair.String(), args.Duration)
+	return
+}
+
+// GetAuctionScheduleArgs holds the args for the getauctionschedule command
+type GetAuctionScheduleArgs struct {
+	Pair match.Pair
+}
+
+// GetAuctionScheduleReply holds the reply for the getauctionschedule command
+type GetAuctionScheduleReply struct {
+	Duration   time.Duration
+	Aligned    bool
+	Paused     bool
+	Recovery   string
+	MaxRetries uint64
+	RetryDelay time.Duration
+	// NextTick is when the current auction for the pair is expected to end, if it's aligned
+	NextTick time.Time
+	match.ErrorReply
+}
+
+// GetAuctionSchedule gets the auction schedule for a pair. Anyone can do this, since the
+// schedule is public.
+func (cl *OpencxAuctionRPC) GetAuctionSchedule(args GetAuctionScheduleArgs, reply *GetAuctionScheduleReply) (err error) {
+	defer reply.SetError(&err)
+
+	var schedule *cxauctionserver.AuctionSchedule
+	if schedule, err = cl.Server.GetAuctionSchedule(&args.Pair); err != nil {
+		err = fmt.Errorf("Error getting schedule for GetAuctionSchedule RPC command: %s", err)
+		return
+	}
+
+	reply.Duration = schedule.Duration
+	reply.Aligned = schedule.Aligned
+	reply.Paused = schedule.Paused
+	reply.Recovery = schedule.Recovery.String()
+	reply.MaxRetries = schedule.MaxRetries
+	reply.RetryDelay = schedule.RetryDelay
+	if schedule.Aligned {
+		reply.NextTick = schedule.NextTick(time.Now())
+	}
+
+	return
+}
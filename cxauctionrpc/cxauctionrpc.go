@@ -4,6 +4,8 @@ import (
 	"net"
 
 	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxjsonrpc"
+	"github.com/mit-dci/opencx/cxlimit"
 )
 
 // AuctionRPCCaller is a listener for RPC commands
@@ -11,6 +13,12 @@ type AuctionRPCCaller struct {
 	caller   *OpencxAuctionRPC
 	listener net.Listener
 	killers  []chan bool
+
+	// jsonGateway serves JSON-RPC, if JSONRPCListen was called
+	jsonGateway *cxjsonrpc.Gateway
+
+	// limits throttles requests by address, if set
+	limits *cxlimit.Limits
 }
 
 // OpencxAuctionRPC is what is registered and called
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"net"
 	"net/rpc"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxjsonrpc"
+	"github.com/mit-dci/opencx/cxlimit"
 	"github.com/mit-dci/opencx/cxnoise"
 	"github.com/mit-dci/opencx/logging"
 )
@@ -20,6 +23,24 @@ func CreateRPCForServer(server *cxauctionserver.OpencxAuctionServer) (rpc1 *Auct
 	return
 }
 
+// ExpensiveMethods are the methods that use the expensive budget of the rate limits, since every
+// order has to be solved or checked against a bond
+var ExpensiveMethods = []string{
+	"OpencxAuctionRPC.SubmitPuzzledOrder",
+	"OpencxAuctionRPC.SubmitOrderCommitment",
+	"OpencxAuctionRPC.RevealOrder",
+}
+
+// SetRateLimits throttles requests by address, giving ExpensiveMethods their own budget. Orders
+// aren't signed, so there's no pubkey to throttle by. It should be called before listening.
+func (rpc1 *AuctionRPCCaller) SetRateLimits(config cxlimit.Config) (err error) {
+	if rpc1.limits, err = cxlimit.NewLimits(config, ExpensiveMethods...); err != nil {
+		err = fmt.Errorf("Error creating rate limits for RPC: %s", err)
+		return
+	}
+	return
+}
+
 // NoiseListen is a synchronous version of RPCListenAsync
 func (rpc1 *AuctionRPCCaller) NoiseListen(privkey *koblitz.PrivateKey, host string, port uint16) (err error) {
 
@@ -62,10 +83,11 @@ func (rpc1 *AuctionRPCCaller) NoiseListenAsync(doneChan chan bool, errChan chan
 		return
 	}
 	logging.Infof("Running RPC-Noise server on %s\n", rpc1.listener.Addr().String())
+	logging.Infof("RPC-Noise server pubkey, for clients to pin: %x", privkey.PubKey().SerializeCompressed())
 
 	// We don't need to do anything fancy here either because the noise protocol
 	// is built in to the listener as well.
-	go noiseRPCServer.Accept(rpc1.listener)
+	go rpc1.limits.Accept(noiseRPCServer, rpc1.listener)
 	doneChan <- true
 	close(doneChan)
 	return
@@ -138,13 +160,58 @@ func (rpc1 *AuctionRPCCaller) RPCListenAsync(doneChan chan bool, errChan chan er
 	}
 	logging.Infof("Running RPC server on %s\n", rpc1.listener.Addr().String())
 
-	go rpc.Accept(rpc1.listener)
+	go rpc1.limits.Accept(rpc.DefaultServer, rpc1.listener)
+	doneChan <- true
+	close(doneChan)
+	return
+}
+
+// JSONRPCListen is a synchronous version of JSONRPCListenAsync
+func (rpc1 *AuctionRPCCaller) JSONRPCListen(host string, port uint16) (err error) {
+
+	doneChan := make(chan bool, 1)
+	errChan := make(chan error, 1)
+	go rpc1.JSONRPCListenAsync(doneChan, errChan, host, port)
+	select {
+	case err = <-errChan:
+	case <-doneChan:
+	}
+
+	return
+}
+
+// JSONRPCListenAsync serves the RPC API as JSON-RPC 2.0 over HTTP and WebSocket on host and port.
+// There's no noise session, so commands need signed requests like with RPCListen.
+func (rpc1 *AuctionRPCCaller) JSONRPCListenAsync(doneChan chan bool, errChan chan error, host string, port uint16) {
+	var err error
+	if rpc1.caller == nil {
+		errChan <- fmt.Errorf("Error, rpc caller cannot be nil, please create caller correctly")
+		close(errChan)
+		return
+	}
+
+	logging.Infof("Registering JSON-RPC API...")
+	if rpc1.jsonGateway, err = cxjsonrpc.NewGateway(rpc1.caller); err != nil {
+		errChan <- fmt.Errorf("Error registering JSON-RPC Interface:\n%s", err)
+		close(errChan)
+		return
+	}
+	rpc1.jsonGateway.SetLimits(rpc1.limits)
+
+	logging.Infof("Starting JSON-RPC Server")
+	if err = rpc1.jsonGateway.Listen(host, port); err != nil {
+		errChan <- fmt.Errorf("Error listening for JSONRPCListenAsync: %s", err)
+		close(errChan)
+		return
+	}
+	logging.Infof("Running JSON-RPC server on %s\n", rpc1.jsonGateway.Addr().String())
+
 	doneChan <- true
 	close(doneChan)
 	return
 }
 
-// WaitUntilDead waits until the Stop() method is called
+// WaitUntilDead waits until the Stop() or Shutdown() method is called
 func (rpc1 *AuctionRPCCaller) WaitUntilDead() {
 	dedchan := make(chan bool, 1)
 	rpc1.killers = append(rpc1.killers, dedchan)
@@ -154,16 +221,54 @@ func (rpc1 *AuctionRPCCaller) WaitUntilDead() {
 
 // Stop closes the RPC listener and notifies those from WaitUntilDead
 func (rpc1 *AuctionRPCCaller) Stop() (err error) {
-	if rpc1.listener == nil {
+	if rpc1.listener == nil && rpc1.jsonGateway == nil {
 		err = fmt.Errorf("Error, cannot stop a listener that doesn't exist")
 		return
 	}
 	logging.Infof("Stopping RPC!!")
-	if err = rpc1.listener.Close(); err != nil {
-		err = fmt.Errorf("Error closing listener: \n%s", err)
-		return
+	if rpc1.listener != nil {
+		if err = rpc1.listener.Close(); err != nil {
+			err = fmt.Errorf("Error closing listener: \n%s", err)
+			return
+		}
 	}
-	// kill the guy waiting
+	if rpc1.jsonGateway != nil {
+		if err = rpc1.jsonGateway.Close(); err != nil {
+			err = fmt.Errorf("Error closing JSON-RPC gateway: \n%s", err)
+			return
+		}
+	}
+	rpc1.notifyKillers()
+	return
+}
+
+// Shutdown closes the RPC listeners so no new connections come in, gives the JSON-RPC requests
+// being served half of the time left before deadline to finish, and shuts down the server,
+// finishing or aborting the active auctions. Those from WaitUntilDead are notified even if
+// something fails, and the first error is returned.
+func (rpc1 *AuctionRPCCaller) Shutdown(deadline time.Time, finishAuctions bool) (err error) {
+	logging.Infof("Shutting down RPC...")
+	if rpc1.listener != nil {
+		if closeErr := rpc1.listener.Close(); closeErr != nil {
+			err = fmt.Errorf("Error closing listener: %s", closeErr)
+		}
+	}
+	if rpc1.jsonGateway != nil {
+		// the rest of the time is left for the server to drain
+		if closeErr := rpc1.jsonGateway.Shutdown(time.Until(deadline) / 2); closeErr != nil && err == nil {
+			err = fmt.Errorf("Error shutting down JSON-RPC gateway: %s", closeErr)
+		}
+	}
+	if serverErr := rpc1.caller.Server.Shutdown(finishAuctions); serverErr != nil && err == nil {
+		err = fmt.Errorf("Error shutting down server: %s", serverErr)
+	}
+
+	rpc1.notifyKillers()
+	return
+}
+
+// notifyKillers notifies those from WaitUntilDead
+func (rpc1 *AuctionRPCCaller) notifyKillers() {
 	for _, killer := range rpc1.killers {
 		// send the signals, but even if they don't send, close the channel
 		select {
@@ -15,11 +15,12 @@ type SubmitPuzzledOrderArgs struct {
 
 // SubmitPuzzledOrderReply holds the reply for the submitpuzzledorder command
 type SubmitPuzzledOrderReply struct {
-	// empty
+	match.ErrorReply
 }
 
 // SubmitPuzzledOrder submits an order to the order book or throws an error
 func (cl *OpencxAuctionRPC) SubmitPuzzledOrder(args SubmitPuzzledOrderArgs, reply *SubmitPuzzledOrderReply) (err error) {
+	defer reply.SetError(&err)
 
 	logging.Infof("Received timelocked order!")
 
@@ -30,7 +31,52 @@ func (cl *OpencxAuctionRPC) SubmitPuzzledOrder(args SubmitPuzzledOrderArgs, repl
 	}
 
 	if err = cl.Server.PlacePuzzledOrder(order); err != nil {
-		err = fmt.Errorf("Error placing order while submitting order: \n%s", err)
+		err = match.Wrapf(err, "Error placing order while submitting order: \n%s", err)
+		return
+	}
+
+	return
+}
+
+// SubmitOrderCommitmentArgs holds the args for the submitordercommitment command
+type SubmitOrderCommitmentArgs struct {
+	Commitment match.OrderCommitment
+}
+
+// SubmitOrderCommitmentReply holds the reply for the submitordercommitment command
+type SubmitOrderCommitmentReply struct {
+	match.ErrorReply
+}
+
+// SubmitOrderCommitment submits a commitment to an order for a commit-reveal auction, putting up
+// the bond for it
+func (cl *OpencxAuctionRPC) SubmitOrderCommitment(args SubmitOrderCommitmentArgs, reply *SubmitOrderCommitmentReply) (err error) {
+	defer reply.SetError(&err)
+
+	if err = cl.Server.PlaceOrderCommitment(&args.Commitment); err != nil {
+		err = match.Wrapf(err, "Error placing order commitment: %s", err)
+		return
+	}
+
+	return
+}
+
+// RevealOrderArgs holds the args for the revealorder command
+type RevealOrderArgs struct {
+	Reveal match.OrderReveal
+}
+
+// RevealOrderReply holds the reply for the revealorder command
+type RevealOrderReply struct {
+	match.ErrorReply
+}
+
+// RevealOrder reveals an order committed to in a commit-reveal auction, getting the bond back
+func (cl *OpencxAuctionRPC) RevealOrder(args RevealOrderArgs, reply *RevealOrderReply) (err error) {
+	defer reply.SetError(&err)
+
+	if err = cl.Server.RevealOrder(&args.Reveal); err != nil {
+		err = match.Wrapf(err, "Error revealing order: %s", err)
 		return
 	}
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/mit-dci/opencx/cxauctionserver"
 	"github.com/mit-dci/opencx/match"
 )
 
@@ -20,10 +21,31 @@ type GetPublicParametersReply struct {
 	// for extra time.
 	AuctionTime uint64
 	StartTime   time.Time
+	// MinModulusBits is the smallest RSA modulus the exchange accepts in puzzles, any puzzle with
+	// a smaller modulus will be rejected.
+	MinModulusBits int
+	// AllowedSchemes are the timelock and cipher combinations the exchange accepts orders with
+	AllowedSchemes []match.EncryptionScheme
+	// SquaringsPerSecond, TargetAuctionDuration and SafetyFactor are the calibration that
+	// AuctionTime was derived from, so clients can check that t is long enough. They are all zero
+	// if the exchange set AuctionTime directly.
+	SquaringsPerSecond    float64
+	TargetAuctionDuration time.Duration
+	SafetyFactor          float64
+	// CommitteePubKey is the public key of the decryption committee that orders are encrypted to.
+	// It is nil unless the exchange is in committee mode.
+	CommitteePubKey []byte
+	// IntakeMode is how the pair takes orders. If it's commit-reveal, CommitReveal has the bond
+	// and reveal window.
+	IntakeMode   cxauctionserver.IntakeMode
+	CommitReveal *cxauctionserver.CommitRevealParams
+	match.ErrorReply
 }
 
 // GetPublicParameters gets public parameters from the exchange, like time and auctionID
 func (cl *OpencxAuctionRPC) GetPublicParameters(args GetPublicParametersArgs, reply *GetPublicParametersReply) (err error) {
+	defer reply.SetError(&err)
+
 	if reply.AuctionID, reply.StartTime, err = cl.Server.GetIDTimeFromPair(&args.Pair); err != nil {
 		err = fmt.Errorf("Error getting public param auction id: %s", err)
 		return
@@ -34,5 +56,30 @@ func (cl *OpencxAuctionRPC) GetPublicParameters(args GetPublicParametersArgs, re
 		return
 	}
 
+	if reply.MinModulusBits, err = cl.Server.MinModulusBits(); err != nil {
+		err = fmt.Errorf("Error getting public param min modulus bits: %s", err)
+		return
+	}
+
+	if reply.AllowedSchemes, err = cl.Server.AllowedSchemes(); err != nil {
+		err = fmt.Errorf("Error getting public param allowed schemes: %s", err)
+		return
+	}
+
+	if reply.SquaringsPerSecond, reply.TargetAuctionDuration, reply.SafetyFactor, err = cl.Server.AuctionTimeCalibration(); err != nil {
+		err = fmt.Errorf("Error getting public param auction time calibration: %s", err)
+		return
+	}
+
+	if reply.CommitteePubKey, err = cl.Server.CommitteePubKey(); err != nil {
+		err = fmt.Errorf("Error getting public param committee public key: %s", err)
+		return
+	}
+
+	if reply.IntakeMode, reply.CommitReveal, err = cl.Server.IntakeMode(&args.Pair); err != nil {
+		err = fmt.Errorf("Error getting public param intake mode: %s", err)
+		return
+	}
+
 	return
 }
@@ -0,0 +1,51 @@
+package cxauctionrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BatchProofEntry is an encrypted order and the proof that its puzzle was solved correctly
+type BatchProofEntry struct {
+	// Use the serialize method on match.EncryptedAuctionOrder
+	EncryptedOrderBytes []byte
+	Proof               *rsw.VDFProof
+}
+
+// GetBatchProofsArgs holds the args for the getbatchproofs command
+type GetBatchProofsArgs struct {
+	AuctionID [32]byte
+}
+
+// GetBatchProofsReply holds the reply for the getbatchproofs command
+type GetBatchProofsReply struct {
+	Proofs []BatchProofEntry
+	match.ErrorReply
+}
+
+// GetBatchProofs gets the proofs that every order in a batch was decrypted correctly, so users can
+// audit the decryption without solving any puzzles.
+func (cl *OpencxAuctionRPC) GetBatchProofs(args GetBatchProofsArgs, reply *GetBatchProofsReply) (err error) {
+	defer reply.SetError(&err)
+
+	var proofs []*cxauctionserver.BatchProof
+	if proofs, err = cl.Server.GetBatchProofs(args.AuctionID); err != nil {
+		err = fmt.Errorf("Error getting batch proofs: %s", err)
+		return
+	}
+
+	for _, proof := range proofs {
+		var entry BatchProofEntry
+		if entry.EncryptedOrderBytes, err = proof.Encrypted.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing encrypted order for batch proofs: %s", err)
+			return
+		}
+		entry.Proof = proof.Proof
+		reply.Proofs = append(reply.Proofs, entry)
+	}
+
+	return
+}
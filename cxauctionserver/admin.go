@@ -0,0 +1,46 @@
+package cxauctionserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SetAdminPubkey sets the public key that is allowed to run admin commands, like pausing auctions.
+func (s *OpencxAuctionServer) SetAdminPubkey(pubkey *koblitz.PublicKey) (err error) {
+	if pubkey == nil {
+		err = fmt.Errorf("Cannot set nil admin pubkey")
+		return
+	}
+	s.dbLock.Lock()
+	s.adminPubkey = pubkey
+	s.dbLock.Unlock()
+	return
+}
+
+// AdminCommandVerify verifies that a signed request for an admin command was made by the admin key.
+func (s *OpencxAuctionServer) AdminCommandVerify(method string, argsHash [32]byte, req *cxauth.SignedRequest) (err error) {
+	s.dbLock.Lock()
+	adminPubkey := s.adminPubkey
+	s.dbLock.Unlock()
+
+	if adminPubkey == nil {
+		err = fmt.Errorf("No admin key set, admin commands are disabled")
+		return
+	}
+
+	var sigPubkey *koblitz.PublicKey
+	if sigPubkey, err = s.VerifyRequest(method, argsHash, req); err != nil {
+		err = match.Wrapf(err, "Error verifying admin command: %s", err)
+		return
+	}
+
+	if !sigPubkey.IsEqual(adminPubkey) {
+		err = fmt.Errorf("Admin command signed by %x, which is not the admin key", sigPubkey.SerializeCompressed())
+		return
+	}
+
+	return
+}
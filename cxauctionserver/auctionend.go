@@ -0,0 +1,187 @@
+package cxauctionserver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/btcsuite/golangcrypto/sha3"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/match"
+)
+
+// AuctionEndDomain is written first in the digest of an auction end, so the signature on it can't
+// be mistaken for any other signature made with the exchange's key.
+const AuctionEndDomain = "opencx-auction-end-v1"
+
+// AuctionEnd is the exchange's signed statement that an auction is over, along with every order
+// in it. Key holders check it for themselves before giving out decryption shares.
+type AuctionEnd struct {
+	AuctionID [32]byte
+	Pair      match.Pair
+	Orders    []*match.EncryptedAuctionOrder
+	Signature []byte
+}
+
+// Digest returns the digest that the exchange signs for the auction end. It commits to the auction,
+// the pair, and every order in order.
+func (end *AuctionEnd) Digest() (digest []byte, err error) {
+	hasher := sha3.New256()
+	hasher.Write([]byte(AuctionEndDomain))
+	hasher.Write(end.AuctionID[:])
+	hasher.Write(end.Pair.Serialize())
+
+	var lenBytes [4]byte
+	binary.BigEndian.PutUint32(lenBytes[:], uint32(len(end.Orders)))
+	hasher.Write(lenBytes[:])
+	for i, order := range end.Orders {
+		var orderBytes []byte
+		if orderBytes, err = order.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing order %d for auction end digest: %s", i, err)
+			return
+		}
+		binary.BigEndian.PutUint32(lenBytes[:], uint32(len(orderBytes)))
+		hasher.Write(lenBytes[:])
+		hasher.Write(orderBytes)
+	}
+
+	digest = hasher.Sum(nil)
+	return
+}
+
+// SignAuctionEnd creates an auction end for the orders and signs it with the exchange's key
+func SignAuctionEnd(privkey *koblitz.PrivateKey, auctionID [32]byte, pair match.Pair, orders []*match.EncryptedAuctionOrder) (end *AuctionEnd, err error) {
+	if privkey == nil {
+		err = fmt.Errorf("Cannot sign auction end with nil key")
+		return
+	}
+
+	end = &AuctionEnd{
+		AuctionID: auctionID,
+		Pair:      pair,
+		Orders:    orders,
+	}
+
+	var digest []byte
+	if digest, err = end.Digest(); err != nil {
+		err = fmt.Errorf("Error getting digest to sign auction end: %s", err)
+		return
+	}
+
+	if end.Signature, err = koblitz.SignCompact(koblitz.S256(), privkey, digest, false); err != nil {
+		err = fmt.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	return
+}
+
+// Signer recovers the pubkey that signed the auction end
+func (end *AuctionEnd) Signer() (pubkey *koblitz.PublicKey, err error) {
+	var digest []byte
+	if digest, err = end.Digest(); err != nil {
+		err = fmt.Errorf("Error getting digest to verify auction end: %s", err)
+		return
+	}
+
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), end.Signature, digest); err != nil {
+		err = fmt.Errorf("Error verifying auction end, invalid signature: %s", err)
+		return
+	}
+	return
+}
+
+// AuctionEndChecker is what a key holder uses to decide whether to give out decryption shares. An
+// auction end has to be signed by the exchange, every order has to be for that auction and pair,
+// and every capsule has to be bound to them. The first auction end a key holder accepts for an
+// auction is the only one it will ever accept, so the exchange can only decrypt orders once it has
+// committed to every order in the auction, and can't decrypt orders added to it afterwards.
+type AuctionEndChecker struct {
+	committee    *threshold.CommitteeKey
+	exchangeKeys []*koblitz.PublicKey
+
+	// ended is the digest of the auction end we accepted for each auction
+	ended    map[[32]byte][32]byte
+	endedMtx *sync.Mutex
+}
+
+// NewAuctionEndChecker creates a checker for auction ends signed by any of the exchange keys
+func NewAuctionEndChecker(committee *threshold.CommitteeKey, exchangeKeys []*koblitz.PublicKey) (checker *AuctionEndChecker, err error) {
+	if committee == nil {
+		err = fmt.Errorf("Cannot check auction ends without a committee")
+		return
+	}
+
+	if len(exchangeKeys) == 0 {
+		err = fmt.Errorf("Need at least one exchange key to check auction ends")
+		return
+	}
+
+	checker = &AuctionEndChecker{
+		committee:    committee,
+		exchangeKeys: exchangeKeys,
+		ended:        make(map[[32]byte][32]byte),
+		endedMtx:     new(sync.Mutex),
+	}
+	return
+}
+
+// Check checks the auction end, and returns the capsules of its orders in the same order. Once
+// this succeeds for an auction, it fails for any other set of orders for the same auction.
+func (checker *AuctionEndChecker) Check(end *AuctionEnd) (capsules []*threshold.Capsule, err error) {
+	if end == nil {
+		err = fmt.Errorf("Auction end is nil")
+		return
+	}
+
+	var signer *koblitz.PublicKey
+	if signer, err = end.Signer(); err != nil {
+		return
+	}
+
+	var fromExchange bool
+	for _, exchangeKey := range checker.exchangeKeys {
+		if exchangeKey.IsEqual(signer) {
+			fromExchange = true
+			break
+		}
+	}
+	if !fromExchange {
+		err = fmt.Errorf("Auction end for %x is not signed by the exchange", end.AuctionID)
+		return
+	}
+
+	for i, order := range end.Orders {
+		if order.IntendedAuction != end.AuctionID || order.IntendedPair != end.Pair {
+			err = fmt.Errorf("Order %d is not for auction %x on pair %s", i, end.AuctionID, end.Pair.String())
+			return
+		}
+
+		var capsule *threshold.Capsule
+		if capsule, err = order.CommitteeCapsule(checker.committee); err != nil {
+			err = fmt.Errorf("Bad capsule for order %d in auction end: %s", i, err)
+			return
+		}
+		capsules = append(capsules, capsule)
+	}
+
+	// Signer already worked, so this won't fail
+	var digestBytes []byte
+	if digestBytes, err = end.Digest(); err != nil {
+		return
+	}
+	var digest [32]byte
+	copy(digest[:], digestBytes)
+
+	checker.endedMtx.Lock()
+	if accepted, ok := checker.ended[end.AuctionID]; ok && accepted != digest {
+		err = fmt.Errorf("Auction %x already ended with different orders, not giving out shares", end.AuctionID)
+		checker.endedMtx.Unlock()
+		return
+	}
+	checker.ended[end.AuctionID] = digest
+	checker.endedMtx.Unlock()
+
+	return
+}
@@ -7,6 +7,9 @@ import (
 	"time"
 
 	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/cxauth"
 	"github.com/mit-dci/opencx/cxdb"
 	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
 	"github.com/mit-dci/opencx/cxdb/cxdbsql"
@@ -15,9 +18,19 @@ import (
 	"golang.org/x/text/number"
 )
 
+const (
+	// DefaultMinModulusBits is the default minimum RSA modulus size for puzzles, the same size that
+	// clients use when they create orders.
+	DefaultMinModulusBits = 2048
+	// AbsoluteMinModulusBits is the smallest the minimum modulus size can be set to. A modulus any
+	// smaller than this can be factored, which would make the puzzle trivial.
+	AbsoluteMinModulusBits = 1024
+)
+
 // OpencxAuctionServer is what will hopefully help handle and manage the auction logic, rpc, and db
 type OpencxAuctionServer struct {
 	SettlementEngines map[*coinparam.Params]match.SettlementEngine
+	SettlementStores  map[*coinparam.Params]cxdb.SettlementStore
 	MatchingEngines   map[match.Pair]match.AuctionEngine
 	Orderbooks        map[match.Pair]match.AuctionOrderbook
 	PuzzleEngines     map[match.Pair]cxdb.PuzzleStore
@@ -28,9 +41,52 @@ type OpencxAuctionServer struct {
 
 	// auction params -- we'll store them in here for now
 	t uint64
-
-	// clock off button
-	clockOffButton chan bool
+	// minModulusBits is the minimum size of the RSA modulus for puzzles we accept
+	minModulusBits int
+	// allowedSchemes are the timelock and cipher combinations we accept orders with
+	allowedSchemes []match.EncryptionScheme
+	// committee orders are encrypted to, nil unless the server is in committee mode
+	committee *threshold.CommitteeKey
+
+	// commitments for pairs that take orders with commit-reveal instead of timelocks
+	commitRevealBooks map[match.Pair]*commitRevealBook
+	commitRevealMtx   *sync.Mutex
+	// calibration t was derived from, all zero if t was set directly
+	squaringsPerSecond    float64
+	targetAuctionDuration time.Duration
+	safetyFactor          float64
+
+	// schedules for each pair's auction clock, and channels to wake the clocks up when they change
+	schedules           map[match.Pair]*AuctionSchedule
+	scheduleUpdateChans map[match.Pair]chan bool
+	scheduleMtx         *sync.Mutex
+
+	// admin key, used to authorize admin commands like pausing auctions
+	adminPubkey *koblitz.PublicKey
+
+	// nonces for signed requests, so they can't be replayed
+	requestNonces *cxauth.NonceStore
+
+	// solutions for every recent batch, by auction ID, so users can get proofs to audit decryption
+	batchProofs   map[[32]byte]*batchSolutions
+	batchProofMtx *sync.Mutex
+	// held while making proofs, so only one batch is proven at a time
+	proverMtx *sync.Mutex
+
+	// closed to stop the clock for every pair, clockWG waits for the clocks to return
+	clockStop     chan struct{}
+	clockStopOnce *sync.Once
+	clockWG       *sync.WaitGroup
+	// waits for batch placers, so the DB handlers aren't closed under them
+	placerWG *sync.WaitGroup
+	// closed on shutdown so reveal windows stop early, revealWG waits for them to return
+	revealStop     chan struct{}
+	revealStopOnce *sync.Once
+	revealWG       *sync.WaitGroup
+
+	// set once the server is shutting down, so it stops taking orders
+	stopping    bool
+	stoppingMtx *sync.Mutex
 }
 
 // InitServerMemoryDefault initializes an auction server with in memory auction engines, settlement engines,
@@ -55,6 +111,12 @@ func InitServerMemoryDefault(coinList []*coinparam.Params, orderChanSize uint64,
 		return
 	}
 
+	var setStores map[*coinparam.Params]cxdb.SettlementStore
+	if setStores, err = cxdbmemory.CreateSettlementStoreMap(coinList); err != nil {
+		err = fmt.Errorf("Error creating settlement store map for InitServerMemoryDefault: %s", err)
+		return
+	}
+
 	var aucBooks map[match.Pair]match.AuctionOrderbook
 	if aucBooks, err = cxdbmemory.CreateAuctionOrderbookMap(pairList); err != nil {
 		err = fmt.Errorf("Error creating auction orderbook map for InitServerMemoryDefault: %s", err)
@@ -73,7 +135,7 @@ func InitServerMemoryDefault(coinList []*coinparam.Params, orderChanSize uint64,
 		return
 	}
 
-	if server, err = InitServer(setEngines, mengines, aucBooks, pzEngines, batchers, orderChanSize, standardAuctionTime); err != nil {
+	if server, err = InitServer(setEngines, mengines, aucBooks, pzEngines, setStores, batchers, orderChanSize, standardAuctionTime); err != nil {
 		err = fmt.Errorf("Error initializing server for InitServerMemoryDefault: %s", err)
 		return
 	}
@@ -102,6 +164,12 @@ func InitServerSQLDefault(coinList []*coinparam.Params, orderChanSize uint64, st
 		return
 	}
 
+	var setStores map[*coinparam.Params]cxdb.SettlementStore
+	if setStores, err = cxdbsql.CreateSettlementStoreMap(coinList); err != nil {
+		err = fmt.Errorf("Error creating settlement store map for InitServerSQLDefault: %s", err)
+		return
+	}
+
 	var aucBooks map[match.Pair]match.AuctionOrderbook
 	if aucBooks, err = cxdbsql.CreateAuctionOrderbookMap(pairList); err != nil {
 		err = fmt.Errorf("Error creating auction orderbook map for InitServerSQLDefault: %s", err)
@@ -120,17 +188,19 @@ func InitServerSQLDefault(coinList []*coinparam.Params, orderChanSize uint64, st
 		return
 	}
 
-	if server, err = InitServer(setEngines, mengines, aucBooks, pzEngines, batchers, orderChanSize, standardAuctionTime); err != nil {
+	if server, err = InitServer(setEngines, mengines, aucBooks, pzEngines, setStores, batchers, orderChanSize, standardAuctionTime); err != nil {
 		err = fmt.Errorf("Error initializing server for createFullServer: %s", err)
 		return
 	}
 	return
 }
 
-// InitServer creates a new server
-func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEngines map[match.Pair]match.AuctionEngine, books map[match.Pair]match.AuctionOrderbook, pzengines map[match.Pair]cxdb.PuzzleStore, batchers map[match.Pair]match.AuctionBatcher, orderChanSize uint64, standardAuctionTime uint64) (server *OpencxAuctionServer, err error) {
+// InitServer creates a new server. Any bonds the settlement stores still have as held are given
+// back, since the commitments they were for didn't outlive the last server.
+func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEngines map[match.Pair]match.AuctionEngine, books map[match.Pair]match.AuctionOrderbook, pzengines map[match.Pair]cxdb.PuzzleStore, settleStores map[*coinparam.Params]cxdb.SettlementStore, batchers map[match.Pair]match.AuctionBatcher, orderChanSize uint64, standardAuctionTime uint64) (server *OpencxAuctionServer, err error) {
 	server = &OpencxAuctionServer{
 		SettlementEngines: setEngines,
+		SettlementStores:  settleStores,
 		MatchingEngines:   matchEngines,
 		Orderbooks:        books,
 		PuzzleEngines:     pzengines,
@@ -139,7 +209,37 @@ func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEn
 		orderChannel:      make(chan *match.OrderPuzzleResult, orderChanSize),
 		orderChanMap:      make(map[[32]byte]chan *match.OrderPuzzleResult),
 		t:                 standardAuctionTime,
-		clockOffButton:    make(chan bool, 1),
+		minModulusBits:    DefaultMinModulusBits,
+		allowedSchemes:    []match.EncryptionScheme{match.DefaultEncryptionScheme},
+		clockStop:         make(chan struct{}),
+		clockStopOnce:     new(sync.Once),
+		clockWG:           new(sync.WaitGroup),
+		placerWG:          new(sync.WaitGroup),
+		revealStop:        make(chan struct{}),
+		revealStopOnce:    new(sync.Once),
+		revealWG:          new(sync.WaitGroup),
+		stoppingMtx:       new(sync.Mutex),
+
+		schedules:           createScheduleMap(batchers, standardAuctionTime),
+		scheduleUpdateChans: make(map[match.Pair]chan bool),
+		scheduleMtx:         new(sync.Mutex),
+
+		batchProofs:   make(map[[32]byte]*batchSolutions),
+		batchProofMtx: new(sync.Mutex),
+		proverMtx:     new(sync.Mutex),
+
+		commitRevealBooks: make(map[match.Pair]*commitRevealBook),
+		commitRevealMtx:   new(sync.Mutex),
+	}
+
+	if server.requestNonces, err = cxauth.NewNonceStore(cxauth.DefaultNonceLifetime); err != nil {
+		err = fmt.Errorf("Error creating nonce store for server: %s", err)
+		return
+	}
+
+	if err = server.RefundOpenBonds(); err != nil {
+		err = fmt.Errorf("Error refunding bonds left from the last server: %s", err)
+		return
 	}
 
 	return
@@ -189,6 +289,10 @@ func (s *OpencxAuctionServer) EndAuctionWithID(pair *match.Pair, auctionID [32]b
 	}
 
 	result = <-batchResultChan
+	if result.Err != nil {
+		err = fmt.Errorf("Error solving batch for EndAuctionWithID: %s", result.Err)
+		return
+	}
 	logging.Infof("Results for auction %x retrieved", auctionID)
 	// get the batcher
 
@@ -215,6 +319,7 @@ func (s *OpencxAuctionServer) StartClockRandomAuction() (err error) {
 		batcher.RegisterAuction(randID)
 
 		// Start the auction clock (also TODO: is this the right place to put this?)
+		s.clockWG.Add(1)
 		go s.AuctionClock(pair, randID)
 	}
 	s.dbLock.Unlock()
@@ -265,48 +370,80 @@ func (s *OpencxAuctionServer) GetIDTimeFromPair(pair *match.Pair) (id [32]byte,
 	return
 }
 
-// StopClock stops the server clock
+// StopClock stops the server clock for every pair. A tick that's already started still finishes,
+// WaitForClock waits for that.
 func (s *OpencxAuctionServer) StopClock() (err error) {
-	s.clockOffButton <- true
+	s.clockStopOnce.Do(func() {
+		logging.Infof("Stopping clock at %s", time.Now())
+		close(s.clockStop)
+	})
+	return
+}
+
+// WaitForClock waits for the clock of every pair to stop, after StopClock
+func (s *OpencxAuctionServer) WaitForClock() {
+	s.clockWG.Wait()
 	return
 }
 
 // StopClockAndWait stops the clock and ends, waits for all active auctions to finish
 func (s *OpencxAuctionServer) StopClockAndWait() (err error) {
-	// Since we're acquiring this lock, we know other things won't end the auctions
-	// in the time that we're trying to
-
 	logging.Infof("Trying to stop clock...")
 	if err = s.StopClock(); err != nil {
 		err = fmt.Errorf("Error stopping clock for StopClockAndWait: %s", err)
 		return
 	}
+	s.WaitForClock()
+
+	if err = s.endActiveAuctions(); err != nil {
+		err = fmt.Errorf("Error ending auctions for StopClockAndWait: %s", err)
+		return
+	}
+
+	return
+}
 
+// endActiveAuctions ends every active auction, places the results like the clock would, and
+// waits for them to be placed. The clock should be stopped first, or it could start new ones.
+func (s *OpencxAuctionServer) endActiveAuctions() (err error) {
 	logging.Infof("waiting for results to roll in...")
-	howManyBatchers := float64(len(s.OrderBatchers))
+	s.dbLock.Lock()
+	var batchers []match.AuctionBatcher
+	for _, batcher := range s.OrderBatchers {
+		batchers = append(batchers, batcher)
+	}
+	s.dbLock.Unlock()
+
 	var activeAuctions map[[32]byte]time.Time
 	num := 0
-	doneChan := make(chan bool)
-	for _, batcher := range s.OrderBatchers {
+	errChan := make(chan error)
+	for _, batcher := range batchers {
 		activeAuctions = batcher.ActiveAuctions()
-		for id, _ := range activeAuctions {
-			go func() {
+		for id := range activeAuctions {
+			go func(batcher match.AuctionBatcher, id [32]byte) {
 				var batchChan chan *match.AuctionBatch
-				if batchChan, err = batcher.EndAuction(id); err != nil {
-					err = fmt.Errorf("Error ending auction for StopClockAndWait: %s", err)
+				var endErr error
+				if batchChan, endErr = batcher.EndAuction(id); endErr != nil {
+					errChan <- fmt.Errorf("Error ending auction %x: %s", id, endErr)
+					return
+				}
+				s.placerWG.Add(1)
+				s.asyncBatchPlacer(batchChan)
+				if batch := <-batchChan; batch.Err != nil {
+					errChan <- fmt.Errorf("Error getting batch for auction %x: %s", id, batch.Err)
 					return
 				}
-				<-batchChan
-				close(batchChan)
-				doneChan <- true
-			}()
+				errChan <- nil
+			}(batcher, id)
 			num++
 		}
-		logging.Infof("Ending auction results progress: %v ", number.Percent(float64(num)/howManyBatchers))
 	}
 
-	for i := num; i > 0; i-- {
-		<-doneChan
+	for i := 0; i < num; i++ {
+		if endErr := <-errChan; endErr != nil && err == nil {
+			err = endErr
+		}
+		logging.Infof("Ending auction results progress: %v ", number.Percent(float64(i+1)/float64(num)))
 	}
 
 	return
@@ -317,3 +454,69 @@ func (s *OpencxAuctionServer) CurrentAuctionTime() (currentAuctionTime uint64, e
 	currentAuctionTime = s.t
 	return
 }
+
+// MinModulusBits gets the minimum size of the RSA modulus in bits for puzzles the server accepts
+func (s *OpencxAuctionServer) MinModulusBits() (minModulusBits int, err error) {
+	minModulusBits = s.minModulusBits
+	return
+}
+
+// SetMinModulusBits sets the minimum size of the RSA modulus in bits for puzzles the server accepts.
+// This can't be set lower than AbsoluteMinModulusBits.
+func (s *OpencxAuctionServer) SetMinModulusBits(minModulusBits int) (err error) {
+	if minModulusBits < AbsoluteMinModulusBits {
+		err = fmt.Errorf("Minimum modulus size of %d bits is too small, must be at least %d bits", minModulusBits, AbsoluteMinModulusBits)
+		return
+	}
+	s.minModulusBits = minModulusBits
+	return
+}
+
+// AllowedSchemes gets the timelock and cipher combinations the server accepts orders with
+func (s *OpencxAuctionServer) AllowedSchemes() (allowedSchemes []match.EncryptionScheme, err error) {
+	allowedSchemes = make([]match.EncryptionScheme, len(s.allowedSchemes))
+	copy(allowedSchemes, s.allowedSchemes)
+	return
+}
+
+// SetAllowedSchemes sets the timelock and cipher combinations the server accepts orders with. Every
+// scheme has to be supported, and committee schemes are allowed only in committee mode, since
+// that's the only time there are batchers that can decrypt them.
+func (s *OpencxAuctionServer) SetAllowedSchemes(allowedSchemes []match.EncryptionScheme) (err error) {
+	if len(allowedSchemes) == 0 {
+		err = fmt.Errorf("Must allow at least one scheme, otherwise no orders can be placed")
+		return
+	}
+
+	for _, scheme := range allowedSchemes {
+		if !scheme.Supported() {
+			err = fmt.Errorf("Cannot allow unsupported scheme %s", scheme.String())
+			return
+		}
+
+		if s.committee != nil && scheme.Timelock != match.TimelockCommittee {
+			err = fmt.Errorf("Cannot allow scheme %s, server is in committee mode so only committee schemes can be used", scheme.String())
+			return
+		}
+
+		if s.committee == nil && scheme.Timelock == match.TimelockCommittee {
+			err = fmt.Errorf("Cannot allow scheme %s, server is not in committee mode", scheme.String())
+			return
+		}
+	}
+
+	s.allowedSchemes = make([]match.EncryptionScheme, len(allowedSchemes))
+	copy(s.allowedSchemes, allowedSchemes)
+	return
+}
+
+// schemeAllowed returns whether or not orders encrypted with the scheme are accepted
+func (s *OpencxAuctionServer) schemeAllowed(scheme match.EncryptionScheme) (allowed bool) {
+	for _, allowedScheme := range s.allowedSchemes {
+		if scheme == allowedScheme {
+			allowed = true
+			return
+		}
+	}
+	return
+}
@@ -76,6 +76,12 @@ func createUltraLightAuctionServer(coinList []*coinparam.Params, orderChanSize u
 		return
 	}
 
+	var setStores map[*coinparam.Params]cxdb.SettlementStore
+	if setStores, err = cxdbmemory.CreateSettlementStoreMap(coinList); err != nil {
+		err = fmt.Errorf("Error creating settlement store map for createUltraLightAuctionServer: %s", err)
+		return
+	}
+
 	var aucBooks map[match.Pair]match.AuctionOrderbook
 	if aucBooks, err = cxdbmemory.CreateAuctionOrderbookMap(pairList); err != nil {
 		err = fmt.Errorf("Error creating auction orderbook map for createUltraLightAuctionServer: %s", err)
@@ -95,7 +101,7 @@ func createUltraLightAuctionServer(coinList []*coinparam.Params, orderChanSize u
 	}
 
 	// orderChanSize = 100 because uh why not?
-	if server, err = InitServer(setEngines, mengines, aucBooks, pzEngines, batchers, orderChanSize, auctionTime); err != nil {
+	if server, err = InitServer(setEngines, mengines, aucBooks, pzEngines, setStores, batchers, orderChanSize, auctionTime); err != nil {
 		err = fmt.Errorf("Error initializing server for createUltraLightAuctionServer: %s", err)
 		return
 	}
@@ -2,10 +2,10 @@ package cxauctionserver
 
 import (
 	"fmt"
+	"math/big"
 	"sync"
 	"time"
 
-	"github.com/mit-dci/opencx/crypto/timelockencoders"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
@@ -31,18 +31,84 @@ type ABatcher struct {
 	batchMap     map[[32]byte]*intermediateBatch
 	batchMapMtx  sync.Mutex
 	maxBatchSize uint64
+	// solverPool solves the puzzles for every auction in this batcher
+	solverPool *SolverPool
+	stopped    bool
 }
 
-// NewABatcher creates a new AuctionBatcher.
+// StoppableBatcher is a batcher with something running in the background, like a solver pool,
+// that has to be stopped once the batcher isn't used anymore.
+type StoppableBatcher interface {
+	match.AuctionBatcher
+	Stop()
+}
+
+// stopBatchers stops every batcher that has to be stopped
+func stopBatchers(batchers map[match.Pair]match.AuctionBatcher) {
+	for _, batcher := range batchers {
+		if stoppable, ok := batcher.(StoppableBatcher); ok {
+			stoppable.Stop()
+		}
+	}
+	return
+}
+
+// NewABatcher creates a new AuctionBatcher, with its own solver pool that has a worker for each
+// CPU and can queue up to maxBatchSize puzzles.
 func NewABatcher(maxBatchSize uint64) (batcher *ABatcher, err error) {
+	var pool *SolverPool
+	if pool, err = NewDefaultSolverPool(maxBatchSize); err != nil {
+		err = fmt.Errorf("Error creating solver pool for new batcher: %s", err)
+		return
+	}
+
+	batcher, err = NewABatcherWithPool(maxBatchSize, pool)
+	return
+}
+
+// NewABatcherWithPool creates a new AuctionBatcher that solves puzzles using the given solver
+// pool. The pool can be shared with other batchers, and is stopped once they're all stopped.
+func NewABatcherWithPool(maxBatchSize uint64, pool *SolverPool) (batcher *ABatcher, err error) {
+	if pool == nil {
+		err = fmt.Errorf("Cannot create batcher with a nil solver pool")
+		return
+	}
+
+	if err = pool.addUser(); err != nil {
+		err = fmt.Errorf("Error creating batcher: %s", err)
+		return
+	}
+
 	batcher = &ABatcher{
 		batchMap:     make(map[[32]byte]*intermediateBatch),
 		batchMapMtx:  sync.Mutex{},
 		maxBatchSize: maxBatchSize,
+		solverPool:   pool,
 	}
 	return
 }
 
+// Stop stops the batcher from taking orders. Its solver pool is stopped once every batcher using
+// it is stopped, and any puzzles it hadn't solved are sent to their auctions with an error.
+func (ab *ABatcher) Stop() {
+	ab.batchMapMtx.Lock()
+	if ab.stopped {
+		ab.batchMapMtx.Unlock()
+		return
+	}
+	ab.stopped = true
+	ab.batchMapMtx.Unlock()
+
+	ab.solverPool.release()
+	return
+}
+
+// SolverStats returns metrics for the solver pool used by this batcher
+func (ab *ABatcher) SolverStats() (stats SolverStats) {
+	stats = ab.solverPool.Stats()
+	return
+}
+
 // RegisterAuction registers a new auction with a specified Auction ID, which will be an array of
 // 32 bytes.
 func (ab *ABatcher) RegisterAuction(auctionID [32]byte) (err error) {
@@ -115,54 +181,67 @@ func (ib *intermediateBatch) OrderSolver() {
 			// case with everything already but just noting it down here.
 			ib.numOrders--
 			ib.solvedOrders = append(ib.solvedOrders, currResult)
-			if !ib.active && ib.numOrders == 0 {
-				// put the batch into the channel
-				ib.solvedChan <- &match.AuctionBatch{
-					Batch:     ib.solvedOrders,
-					AuctionID: ib.id,
-				}
-				// turn this off, clean up
-				ib.offChan <- true
-			}
+			ib.finishIfDone()
 			ib.orderUpdateMtx.Unlock()
 		}
 	}
 	return
 }
 
-// solveSingleOrder solves a single order and deposits the result into the sendResChan. This should
-// be done in a goroutine.
-func (ib *intermediateBatch) solveSingleOrder(eOrder *match.EncryptedAuctionOrder) {
-	var err error
-	result := new(match.OrderPuzzleResult)
-	result.Encrypted = eOrder
+// finishIfDone sends the batch and turns the solver off once the auction is ended and every order
+// is solved. orderUpdateMtx must be held.
+func (ib *intermediateBatch) finishIfDone() {
+	if ib.active || ib.numOrders != 0 {
+		return
+	}
+	// put the batch into the channel
+	ib.solvedChan <- &match.AuctionBatch{
+		Batch:     ib.solvedOrders,
+		AuctionID: ib.id,
+	}
+	// turn this off, clean up
+	ib.offChan <- true
+	return
+}
 
-	// send to channel at end of method
-	defer func() {
-		// Make sure we can actually send to this channel
-		// logging.Infof("Try send, num orders left: %d", ib.numOrders)
+// sendResult deposits the result of solving an order into the orderChan.
+func (ib *intermediateBatch) sendResult(result *match.OrderPuzzleResult) (err error) {
+	// Make sure we can actually send to this channel
+	select {
+	case ib.orderChan <- result:
+		logging.Infof("Sent order to channel")
+		return
+	default:
+		err = fmt.Errorf("Couldn't send result to auction %x, its channel is full", ib.id)
+		return
+	}
+}
 
-		// interBatch.orderUpdateMtx.Lock()
-		// logging.Infof("Start of solve, num orders left: %d", ib.numOrders)
-		// interBatch.orderUpdateMtx.Unlock()
-		select {
-		case ib.orderChan <- result:
-			logging.Infof("Sent order to channel")
-			return
-		default:
-			panic("Couldn't send result to channel! panicking!")
-		}
-	}()
+// solveSingleOrder solves a single order. The solver pool uses this for orders it can't solve in
+// a batch, and then sends the result with sendResult.
+func (ib *intermediateBatch) solveSingleOrder(eOrder *match.EncryptedAuctionOrder) (result *match.OrderPuzzleResult) {
+	var err error
+	result = new(match.OrderPuzzleResult)
+	result.Encrypted = eOrder
 
-	var orderBytes []byte
-	if orderBytes, err = timelockencoders.SolvePuzzleRC5(eOrder.OrderCiphertext, eOrder.OrderPuzzle); err != nil {
-		result.Err = fmt.Errorf("Error solving RC5 puzzle for solve single order: %s", err)
+	if result.Auction, err = eOrder.Decrypt(); err != nil {
+		result.Err = fmt.Errorf("Error decrypting order for solve single order: %s", err)
 		return
 	}
 
-	result.Auction = new(match.AuctionOrder)
-	if err = result.Auction.Deserialize(orderBytes); err != nil {
-		result.Err = fmt.Errorf("Error deserializing order from puzzle for solve single order: %s", err)
+	return
+}
+
+// decryptSolvedOrder decrypts an order whose puzzle the solver pool already solved, using the
+// solution. The proof is made later, only if someone asks for it.
+func (ib *intermediateBatch) decryptSolvedOrder(eOrder *match.EncryptedAuctionOrder, solution *big.Int) (result *match.OrderPuzzleResult) {
+	var err error
+	result = new(match.OrderPuzzleResult)
+	result.Encrypted = eOrder
+	result.Solution = solution
+
+	if result.Auction, err = eOrder.DecryptSolved(solution); err != nil {
+		result.Err = fmt.Errorf("Error decrypting solved order: %s", err)
 		return
 	}
 
@@ -170,16 +249,23 @@ func (ib *intermediateBatch) solveSingleOrder(eOrder *match.EncryptedAuctionOrde
 }
 
 // AddEncrypted adds an encrypted order to an auction. This should error if either the auction doesn't
-// exist, or the auction is ended.
+// exist, the auction is ended, the auction is full, or the solver queue is full. If the solver
+// queue is full the client should try again later.
 func (ab *ABatcher) AddEncrypted(order *match.EncryptedAuctionOrder) (err error) {
 	// First retreive the interBatch, this shouldn't change but we use a mutex to access the map.
 	ab.batchMapMtx.Lock()
 
+	if ab.stopped {
+		err = match.Errorf(match.ErrServerBusy, "Cannot add encrypted order, batcher is stopped")
+		ab.batchMapMtx.Unlock()
+		return
+	}
+
 	// Check that we can do things to the batch
 	var interBatch *intermediateBatch
 	var ok bool
 	if interBatch, ok = ab.batchMap[order.IntendedAuction]; !ok {
-		err = fmt.Errorf("Cannot add encrypted order to unregistered auction %x", order.IntendedAuction)
+		err = match.Errorf(match.ErrAuctionClosed, "Cannot add encrypted order to unregistered auction %x", order.IntendedAuction)
 		ab.batchMapMtx.Unlock()
 		return
 	}
@@ -189,15 +275,30 @@ func (ab *ABatcher) AddEncrypted(order *match.EncryptedAuctionOrder) (err error)
 	// now we have this other mutex because we are going to be checking and modifying the contents
 	interBatch.orderUpdateMtx.Lock()
 	if !interBatch.active {
-		err = fmt.Errorf("Cannot add encrypted order to inactive auction")
+		err = match.Errorf(match.ErrAuctionClosed, "Cannot add encrypted order to inactive auction")
 		interBatch.orderUpdateMtx.Unlock()
 		return
 	}
 
+	if interBatch.numOrders+uint64(len(interBatch.solvedOrders)) >= interBatch.maxOrders {
+		err = match.Errorf(match.ErrAuctionFull, "Cannot add encrypted order to auction, auction is full with %d orders", interBatch.maxOrders)
+		interBatch.orderUpdateMtx.Unlock()
+		return
+	}
+
+	// Count the order before it's submitted, so the auction can't end without it. If the pool
+	// doesn't take it, it's taken back out, and the batch is sent if the auction ended meanwhile.
 	interBatch.numOrders++
 	interBatch.orderUpdateMtx.Unlock()
 
-	go interBatch.solveSingleOrder(order)
+	if err = ab.solverPool.submit(order, interBatch); err != nil {
+		err = match.Wrapf(err, "Cannot add encrypted order to auction: %s", err)
+		interBatch.orderUpdateMtx.Lock()
+		interBatch.numOrders--
+		interBatch.finishIfDone()
+		interBatch.orderUpdateMtx.Unlock()
+		return
+	}
 
 	return
 }
@@ -227,22 +328,44 @@ func (ab *ABatcher) EndAuction(auctionID [32]byte) (batchChan chan *match.Auctio
 		interBatch.orderUpdateMtx.Unlock()
 		return
 	}
-	interBatch.orderUpdateMtx.Unlock()
-	interBatch.orderUpdateMtx.Lock()
 	interBatch.active = false
+	// If there are no orders left to solve the solver won't receive anything else, so the batch
+	// is done now.
+	interBatch.finishIfDone()
 	interBatch.orderUpdateMtx.Unlock()
 	batchChan = interBatch.solvedChan
+
+	// The solver pool keeps its own reference to the batch for the orders it's still solving, so
+	// the batcher doesn't need to keep an ended auction around
+	ab.batchMapMtx.Lock()
+	delete(ab.batchMap, auctionID)
+	ab.batchMapMtx.Unlock()
 	return
 }
 
+// CreateAuctionBatcherMap creates a batcher for every pair, all sharing one solver pool with a
+// worker for each CPU.
 func CreateAuctionBatcherMap(pairList []*match.Pair, maxBatchSize uint64) (batchers map[match.Pair]match.AuctionBatcher, err error) {
+	var pool *SolverPool
+	if pool, err = NewDefaultSolverPool(maxBatchSize * uint64(len(pairList))); err != nil {
+		err = fmt.Errorf("Error creating solver pool for batcher map: %s", err)
+		return
+	}
+
+	batchers, err = CreateAuctionBatcherMapWithPool(pairList, maxBatchSize, pool)
+	return
+}
+
+// CreateAuctionBatcherMapWithPool creates a batcher for every pair, all sharing the given solver
+// pool. Since the pool is shared, orders for older auctions are solved first no matter the pair.
+func CreateAuctionBatcherMapWithPool(pairList []*match.Pair, maxBatchSize uint64, pool *SolverPool) (batchers map[match.Pair]match.AuctionBatcher, err error) {
 	batchers = make(map[match.Pair]match.AuctionBatcher)
 
 	// We just create a new struct because that's all we really need, we satisfy the interface
 	var currBatcher *ABatcher
 	for _, pair := range pairList {
 		// TODO: make sure that this one pointer being reused doesn't cause any issues
-		if currBatcher, err = NewABatcher(maxBatchSize); err != nil {
+		if currBatcher, err = NewABatcherWithPool(maxBatchSize, pool); err != nil {
 			err = fmt.Errorf("Error creating new batcher for %s pair: %s", pair.String(), err)
 			return
 		}
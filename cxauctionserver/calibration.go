@@ -0,0 +1,69 @@
+package cxauctionserver
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// DefaultSafetyFactor is how many times faster than the exchange we assume the fastest solver
+// could be, by default. Calibration only measures our own hardware, and someone with faster
+// hardware solves the same puzzle in less time.
+const DefaultSafetyFactor = 2.0
+
+// AuctionTimeForDuration derives the time parameter t for puzzles so that nobody can solve them
+// before the target auction duration is up. squaringsPerSecond is the measured rate of this
+// machine, and safetyFactor is how many times faster than us we assume anyone else could be, so
+// t = squaringsPerSecond * target * safetyFactor, rounded up.
+func AuctionTimeForDuration(squaringsPerSecond float64, target time.Duration, safetyFactor float64) (t uint64, err error) {
+	if squaringsPerSecond <= 0 || math.IsInf(squaringsPerSecond, 0) || math.IsNaN(squaringsPerSecond) {
+		err = fmt.Errorf("Squarings per second must be positive and finite, got %f", squaringsPerSecond)
+		return
+	}
+
+	if target <= 0 {
+		err = fmt.Errorf("Target auction duration must be positive, got %s", target)
+		return
+	}
+
+	if safetyFactor < 1 || math.IsInf(safetyFactor, 0) || math.IsNaN(safetyFactor) {
+		err = fmt.Errorf("Safety factor must be at least 1 and finite, got %f", safetyFactor)
+		return
+	}
+
+	squarings := math.Ceil(squaringsPerSecond * target.Seconds() * safetyFactor)
+	if squarings >= math.MaxUint64 {
+		err = fmt.Errorf("Auction time for a %s auction is too large to fit in 64 bits", target)
+		return
+	}
+
+	t = uint64(squarings)
+	return
+}
+
+// SetCalibratedAuctionTime derives the auction time from the calibrated squarings per second, the
+// target auction duration and the safety factor, and sets it. The calibration is kept so clients
+// can see where t came from. Like the other auction params, this should be set before the server
+// starts taking orders.
+func (s *OpencxAuctionServer) SetCalibratedAuctionTime(squaringsPerSecond float64, target time.Duration, safetyFactor float64) (err error) {
+	var t uint64
+	if t, err = AuctionTimeForDuration(squaringsPerSecond, target, safetyFactor); err != nil {
+		err = fmt.Errorf("Error deriving auction time from calibration: %s", err)
+		return
+	}
+
+	s.t = t
+	s.squaringsPerSecond = squaringsPerSecond
+	s.targetAuctionDuration = target
+	s.safetyFactor = safetyFactor
+	return
+}
+
+// AuctionTimeCalibration gets the calibration the auction time was derived from. Everything is
+// zero if the auction time was set directly.
+func (s *OpencxAuctionServer) AuctionTimeCalibration() (squaringsPerSecond float64, target time.Duration, safetyFactor float64, err error) {
+	squaringsPerSecond = s.squaringsPerSecond
+	target = s.targetAuctionDuration
+	safetyFactor = s.safetyFactor
+	return
+}
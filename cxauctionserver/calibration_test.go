@@ -0,0 +1,54 @@
+package cxauctionserver
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAuctionTimeForDuration(t *testing.T) {
+	var err error
+	var auctionTime uint64
+	if auctionTime, err = AuctionTimeForDuration(1000, 30*time.Second, DefaultSafetyFactor); err != nil {
+		t.Errorf("Error deriving auction time: %s", err)
+		return
+	}
+
+	if auctionTime != 60000 {
+		t.Errorf("Auction time for 1000 squarings per second over 30s with a safety factor of 2 should be 60000, got %d", auctionTime)
+		return
+	}
+
+	// partial squarings round up, so the puzzle is never easier than the target
+	if auctionTime, err = AuctionTimeForDuration(1.5, time.Second, 1); err != nil {
+		t.Errorf("Error deriving auction time: %s", err)
+		return
+	}
+
+	if auctionTime != 2 {
+		t.Errorf("Auction time should round up to 2, got %d", auctionTime)
+		return
+	}
+
+	if _, err = AuctionTimeForDuration(1000, time.Second, 0.5); err == nil {
+		t.Errorf("Should not derive an auction time with a safety factor less than 1")
+		return
+	}
+
+	if _, err = AuctionTimeForDuration(0, time.Second, 1); err == nil {
+		t.Errorf("Should not derive an auction time with no squarings per second")
+		return
+	}
+
+	if _, err = AuctionTimeForDuration(1000, 0, 1); err == nil {
+		t.Errorf("Should not derive an auction time for a zero duration")
+		return
+	}
+
+	if _, err = AuctionTimeForDuration(math.MaxFloat64, time.Hour, 1); err == nil {
+		t.Errorf("Should not derive an auction time that overflows")
+		return
+	}
+
+	return
+}
@@ -1,107 +1,171 @@
 package cxauctionserver
 
 import (
-	"runtime"
+	"fmt"
 	"time"
 
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
 
-type timeID struct {
-	time time.Time
-	id   [32]byte
-}
-
-// AuctionClock should be run in a goroutine and just commit to puzzles after some time
-// What this does is first makes a channel called doneChan.
-// This channel keeps track of the time that each auction is committed to.
+// AuctionClock should be run in a goroutine and just commit to puzzles after some time.
+// Each tick follows the schedule for the pair, which can be changed, paused, and resumed
+// while the clock is running. If a tick fails, the schedule's recovery policy decides whether
+// to retry it or skip it, and the clock keeps running. If the tick failed after the auction was
+// ended, the next tick starts the auction chained off its commitment.
+// StartClockRandomAuction starts the clocks, so StopClock and WaitForClock know about them.
 func (s *OpencxAuctionServer) AuctionClock(pair match.Pair, startID [32]byte) {
-	logging.Infof("Starting Auction Clock!")
-
-	// We make the variables here because we don't want to fill up our memory with stuff in the loop
-	doneChan := make(chan timeID, 1)
-	var tickResult timeID
+	defer s.clockWG.Done()
+	logging.Infof("Starting Auction Clock for pair %s!", pair.String())
 
-	// FOR STATS / DEBUG
-	var m *runtime.MemStats
-	m = new(runtime.MemStats)
+	updateChan := s.scheduleUpdates(&pair)
 
-	// This takes currAuctionID, plugs it in to auctionTick, gets back a new auction id from the channel so it
-	// can continue the loop
+	var err error
+	var schedule *AuctionSchedule
+	var tickTimer *time.Timer
 	var currAuctionID [32]byte = startID
 	for {
-
-		// Before we commit to anything, let's check that we should turn the clock off
-		select {
-		case <-s.clockOffButton:
-			logging.Infof("Stopping clock at %s", time.Now())
-			// this is probably the jankiest code I've written but I don't care because
-			// this will all probably get rewritten and is the least important part
-			// of the system
-			s.clockOffButton <- true
+		if schedule, err = s.GetAuctionSchedule(&pair); err != nil {
+			logging.Errorf("Error getting schedule for auction clock, stopping clock for %s: %s", pair.String(), err)
 			return
-		default:
 		}
 
-		// Read mem stats FOR STATISTICS
-		runtime.ReadMemStats(m)
-
-		// TODO: configurable time, work out schedule, base it on the AuctionTime option
-		time.AfterFunc(time.Duration(s.t)*time.Microsecond, func() {
-			// Before we commit to anything, let's check that we should turn the clock off
+		if schedule.Paused {
+			logging.Infof("Auctions for %s paused, waiting to resume", pair.String())
 			select {
-			case <-s.clockOffButton:
-				// this is probably the jankiest code I've written but I don't care because
-				// this will all probably get rewritten and is the least important part
-				// of the system
-				s.clockOffButton <- true
+			case <-s.clockStop:
 				return
-			default:
+			case <-updateChan:
+				// The schedule changed, maybe we're resumed
+				continue
 			}
-			s.auctionTick(pair, currAuctionID, doneChan)
-		})
+		}
 
-		// retrieve the tick from the channel
-		tickResult = <-doneChan
+		tickTimer = time.NewTimer(time.Until(schedule.NextTick(time.Now())))
+		select {
+		case <-s.clockStop:
+			tickTimer.Stop()
+			return
+		case <-updateChan:
+			// The schedule changed, so schedule the tick again
+			tickTimer.Stop()
+			continue
+		case <-tickTimer.C:
+		}
 
-		// Now set the new id to the result
-		currAuctionID = tickResult.id
+		// The tick says which auction the clock is on now, even if it failed after ending the last one
+		var tickRes timeID
+		tickRes, err = s.auctionTickWithRecovery(pair, currAuctionID, schedule)
+		currAuctionID = tickRes.id
+		if err != nil {
+			logging.Errorf("Skipping tick for %s, auction %x is next: %s", pair.String(), currAuctionID, err)
+			continue
+		}
 
-		logging.Infof("Tick done at %s", tickResult.time.String())
+		logging.Infof("Tick done at %s", tickRes.time.String())
 	}
 }
 
-// auctionTick commits to orders and creates a new auction, while making sure to send a "done" time to a channel afterwards
-func (s *OpencxAuctionServer) auctionTick(pair match.Pair, oldID [32]byte, doneChan chan timeID) {
-	var err error
+type timeID struct {
+	time time.Time
+	id   [32]byte
+}
+
+// auctionTickWithRecovery runs a tick, retrying according to the recovery policy of the schedule.
+func (s *OpencxAuctionServer) auctionTickWithRecovery(pair match.Pair, oldID [32]byte, schedule *AuctionSchedule) (tickRes timeID, err error) {
+	if tickRes, err = s.auctionTick(pair, oldID); err == nil || schedule.Recovery != RecoveryRetry {
+		return
+	}
+
+	for i := uint64(0); i < schedule.MaxRetries; i++ {
+		logging.Warnf("Tick for %s failed, retrying (%d/%d): %s", pair.String(), i+1, schedule.MaxRetries, err)
+		select {
+		case <-s.clockStop:
+			err = fmt.Errorf("Clock stopped while retrying tick")
+			return
+		case <-time.After(schedule.RetryDelay):
+		}
+		if tickRes, err = s.auctionTick(pair, tickRes.id); err == nil {
+			return
+		}
+	}
 
-	var tickRes timeID
+	err = fmt.Errorf("Tick failed after %d retries: %s", schedule.MaxRetries, err)
+	return
+}
+
+// auctionTick commits to orders and creates a new auction, returning the new auction ID and
+// the time the tick finished. If the auction isn't active, an earlier tick ended it but couldn't
+// start the next one, and the ID is the one chained off that commitment, so this tick starts it
+// instead. The returned ID is the auction the clock is on, even if there's an error.
+func (s *OpencxAuctionServer) auctionTick(pair match.Pair, oldID [32]byte) (tickRes timeID, err error) {
+	tickRes.id = oldID
 
-	select {
-	case <-s.clockOffButton:
-		logging.Infof("trying to not do commitments")
-		s.clockOffButton <- true
-		doneChan <- tickRes
-		logging.Infof("not doing commitments")
+	var active bool
+	if active, err = s.auctionActive(&pair, oldID); err != nil {
 		return
-	default:
 	}
 
-	// this basically makes sure we send something to doneChan
-	// when we're done
-	defer func() {
-		doneChan <- tickRes
-	}()
+	if !active {
+		if err = s.startAuction(&pair, oldID); err != nil {
+			err = fmt.Errorf("Could not start auction %x chained off the last commitment: %s", oldID, err)
+			return
+		}
+		tickRes.time = time.Now()
+		return
+	}
 
 	// batcher solves puzzles, puzzle engine stores puzzles.
-	if tickRes.id, err = s.CommitOrdersNewAuction(&pair, oldID); err != nil {
-		// TODO: What should happen in this case? How can we prevent this case?
-		logging.Fatalf("Exchange commitment for %x failed!!! Fatal error: %s", oldID, err)
+	var newID [32]byte
+	if newID, err = s.CommitOrdersNewAuction(&pair, oldID); err != nil {
+		// the auction was ended, so the next tick should start the new one
+		if newID != ([32]byte{}) {
+			tickRes.id = newID
+		}
+		err = fmt.Errorf("Exchange commitment for %x failed: %s", oldID, err)
+		return
 	}
+	tickRes.id = newID
 
 	// Now set the time
 	tickRes.time = time.Now()
 
 	return
 }
+
+// auctionActive returns whether or not an auction is still taking orders
+func (s *OpencxAuctionServer) auctionActive(pair *match.Pair, id [32]byte) (active bool, err error) {
+	s.dbLock.Lock()
+	var currBatcher match.AuctionBatcher
+	var ok bool
+	if currBatcher, ok = s.OrderBatchers[*pair]; !ok {
+		err = fmt.Errorf("Error getting correct batcher for pair %s", pair.String())
+		s.dbLock.Unlock()
+		return
+	}
+	s.dbLock.Unlock()
+
+	_, active = currBatcher.ActiveAuctions()[id]
+	return
+}
+
+// startAuction registers an auction with the batcher for the pair, so it starts taking orders
+func (s *OpencxAuctionServer) startAuction(pair *match.Pair, id [32]byte) (err error) {
+	s.dbLock.Lock()
+	var currBatcher match.AuctionBatcher
+	var ok bool
+	if currBatcher, ok = s.OrderBatchers[*pair]; !ok {
+		err = fmt.Errorf("Error getting correct batcher for pair %s", pair.String())
+		s.dbLock.Unlock()
+		return
+	}
+
+	if err = currBatcher.RegisterAuction(id); err != nil {
+		err = fmt.Errorf("Error registering auction %x for pair %s: %s", id, pair.String(), err)
+		s.dbLock.Unlock()
+		return
+	}
+	s.dbLock.Unlock()
+
+	return
+}
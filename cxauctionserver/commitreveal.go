@@ -0,0 +1,663 @@
+package cxauctionserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/golangcrypto/sha3"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// IntakeMode is how a pair takes in orders during an auction
+type IntakeMode uint8
+
+const (
+	// IntakeTimelock takes orders encrypted with timelock puzzles, or to a committee, which are
+	// decrypted by the pair's batcher after the auction ends.
+	IntakeTimelock IntakeMode = iota
+	// IntakeCommitReveal takes hash commitments to orders, backed by a bond. Orders are revealed
+	// after the auction ends, and anyone who doesn't reveal loses their bond.
+	IntakeCommitReveal
+)
+
+// String returns the string representation of the intake mode
+func (im IntakeMode) String() string {
+	switch im {
+	case IntakeTimelock:
+		return "timelock"
+	case IntakeCommitReveal:
+		return "commitreveal"
+	default:
+		return "unknown"
+	}
+}
+
+// CommitRevealRetention is how long the result of a commit-reveal auction is kept after its reveal
+// window closes. After this the auction is dropped and its result can't be looked up.
+const CommitRevealRetention = 24 * time.Hour
+
+// CommitRevealParams are the parameters for a pair that takes orders with commit-reveal
+type CommitRevealParams struct {
+	// BondAsset and BondAmount are what gets held in the settlement engine for every commitment,
+	// until the order is revealed. Every bond is recorded in the settlement store for the asset.
+	BondAsset  match.Asset
+	BondAmount uint64
+	// RevealWindow is how long after the auction ends orders can be revealed
+	RevealWindow time.Duration
+}
+
+// CommitRevealResult is what happened to the commitments in a commit-reveal auction once the
+// reveal window closed
+type CommitRevealResult struct {
+	AuctionID [32]byte
+	// Revealed are the orders that were revealed, which are placed and matched
+	Revealed []*match.AuctionOrder
+	// Forfeited are the commitments that were never revealed, whose bonds are kept
+	Forfeited []*match.OrderCommitment
+}
+
+// commitRevealAuction is the commitments for a single auction
+type commitRevealAuction struct {
+	commitments []*match.OrderCommitment
+	// revealed orders by commitment
+	revealed map[[32]byte]*match.AuctionOrder
+	// revealing is true once the auction is over and no more commitments are taken
+	revealing bool
+	result    *CommitRevealResult
+	// closed is when the reveal window closed and the result was set
+	closed time.Time
+}
+
+// commitRevealBook keeps track of the commitments for every auction of a pair
+type commitRevealBook struct {
+	params   CommitRevealParams
+	auctions map[[32]byte]*commitRevealAuction
+	mtx      sync.Mutex
+}
+
+// auction gets the commitments for an auction, creating them if there aren't any yet. The book
+// should be locked.
+func (book *commitRevealBook) auction(auctionID [32]byte) (crAuction *commitRevealAuction) {
+	var ok bool
+	if crAuction, ok = book.auctions[auctionID]; !ok {
+		crAuction = &commitRevealAuction{
+			revealed: make(map[[32]byte]*match.AuctionOrder),
+		}
+		book.auctions[auctionID] = crAuction
+	}
+	return
+}
+
+// bondFor gets the bond the book holds for a commitment
+func (book *commitRevealBook) bondFor(commitment *match.OrderCommitment) (bond *match.Bond) {
+	bond = &match.Bond{
+		Commitment: commitment.Commitment,
+		AuctionID:  commitment.IntendedAuction,
+		Pubkey:     commitment.Pubkey,
+		Asset:      book.params.BondAsset,
+		Amount:     book.params.BondAmount,
+		Status:     match.BondHeld,
+	}
+	return
+}
+
+// SetCommitReveal makes the pair take orders with commit-reveal instead of timelock puzzles. The
+// bond asset needs a settlement engine and a settlement store. Like the other auction params, this should be set before
+// the server starts taking orders.
+func (s *OpencxAuctionServer) SetCommitReveal(pair *match.Pair, params CommitRevealParams) (err error) {
+	if params.BondAmount == 0 {
+		err = fmt.Errorf("Commit-reveal bond must be more than 0, otherwise nothing stops people from not revealing")
+		return
+	}
+
+	if params.RevealWindow <= 0 {
+		err = fmt.Errorf("Reveal window must be positive, got %s", params.RevealWindow)
+		return
+	}
+
+	s.dbLock.Lock()
+	if _, _, err = s.settlementForAsset(params.BondAsset); err != nil {
+		err = fmt.Errorf("Cannot hold bonds in %s: %s", params.BondAsset.String(), err)
+		s.dbLock.Unlock()
+		return
+	}
+
+	if _, ok := s.OrderBatchers[*pair]; !ok {
+		err = fmt.Errorf("Cannot set commit-reveal for pair %s, no batcher for the pair", pair.String())
+		s.dbLock.Unlock()
+		return
+	}
+	s.dbLock.Unlock()
+
+	s.commitRevealMtx.Lock()
+	s.commitRevealBooks[*pair] = &commitRevealBook{
+		params:   params,
+		auctions: make(map[[32]byte]*commitRevealAuction),
+	}
+	s.commitRevealMtx.Unlock()
+	return
+}
+
+// IntakeMode gets how the pair takes in orders, and the commit-reveal params if it uses
+// commit-reveal
+func (s *OpencxAuctionServer) IntakeMode(pair *match.Pair) (mode IntakeMode, params *CommitRevealParams, err error) {
+	mode = IntakeTimelock
+	var book *commitRevealBook
+	if book = s.commitRevealBook(pair); book != nil {
+		mode = IntakeCommitReveal
+		paramsCopy := book.params
+		params = &paramsCopy
+	}
+	return
+}
+
+// commitRevealBook gets the commit-reveal book for the pair, or nil if the pair uses timelocks
+func (s *OpencxAuctionServer) commitRevealBook(pair *match.Pair) (book *commitRevealBook) {
+	s.commitRevealMtx.Lock()
+	book = s.commitRevealBooks[*pair]
+	s.commitRevealMtx.Unlock()
+	return
+}
+
+// settlementForAsset gets the settlement engine and settlement store for an asset. The db lock
+// should be held.
+func (s *OpencxAuctionServer) settlementForAsset(asset match.Asset) (setEngine match.SettlementEngine, setStore cxdb.SettlementStore, err error) {
+	var coin *coinparam.Params
+	if coin, err = asset.CoinParamFromAsset(); err != nil {
+		err = fmt.Errorf("Error getting coin param for asset: %s", err)
+		return
+	}
+
+	var ok bool
+	if setEngine, ok = s.SettlementEngines[coin]; !ok {
+		err = fmt.Errorf("No settlement engine for %s", asset.String())
+		return
+	}
+
+	if setStore, ok = s.SettlementStores[coin]; !ok {
+		err = fmt.Errorf("No settlement store for %s", asset.String())
+		return
+	}
+	return
+}
+
+// takeBond takes the bond out of its pubkey's balance and records it as held. If the balance
+// isn't enough, nothing is taken. The db lock should be held from before this is called until
+// the commitment is stored.
+func (s *OpencxAuctionServer) takeBond(bond *match.Bond) (err error) {
+	var setEngine match.SettlementEngine
+	var setStore cxdb.SettlementStore
+	if setEngine, setStore, err = s.settlementForAsset(bond.Asset); err != nil {
+		err = fmt.Errorf("Error getting settlement for bond: %s", err)
+		return
+	}
+
+	bondExec := &match.SettlementExecution{
+		Pubkey: bond.Pubkey,
+		Amount: bond.Amount,
+		Asset:  bond.Asset,
+		Type:   match.Credit,
+	}
+
+	var valid bool
+	if valid, err = setEngine.CheckValid(bondExec); err != nil {
+		err = fmt.Errorf("Error checking bond for commitment: %s", err)
+		return
+	}
+
+	if !valid {
+		err = match.Errorf(match.ErrInsufficientFunds, "Not enough %s to put up a bond of %d", bond.Asset.String(), bond.Amount)
+		return
+	}
+
+	var setRes *match.SettlementResult
+	if setRes, err = setEngine.ApplySettlementExecution(bondExec); err != nil {
+		err = fmt.Errorf("Error taking bond for commitment: %s", err)
+		return
+	}
+
+	held := *bond
+	held.Status = match.BondHeld
+	if err = setStore.RecordBond(&held); err != nil {
+		// A bond with no record wouldn't be given back after a restart, so don't keep it
+		undoExec := *bondExec
+		undoExec.Type = match.Debit
+		if _, undoErr := setEngine.ApplySettlementExecution(&undoExec); undoErr != nil {
+			logging.Errorf("Error giving back unrecorded bond for commitment %x: %s", bond.Commitment, undoErr)
+		}
+		err = fmt.Errorf("Error recording bond for commitment: %s", err)
+		return
+	}
+
+	if err = setStore.UpdateBalances([]*match.SettlementResult{setRes}); err != nil {
+		err = fmt.Errorf("Error updating balances for bond: %s", err)
+		return
+	}
+	return
+}
+
+// refundBond gives a held bond back to its pubkey and records it as refunded. The db lock should
+// be held.
+func (s *OpencxAuctionServer) refundBond(bond *match.Bond) (err error) {
+	var setEngine match.SettlementEngine
+	var setStore cxdb.SettlementStore
+	if setEngine, setStore, err = s.settlementForAsset(bond.Asset); err != nil {
+		err = fmt.Errorf("Error getting settlement for bond: %s", err)
+		return
+	}
+
+	refundExec := &match.SettlementExecution{
+		Pubkey: bond.Pubkey,
+		Amount: bond.Amount,
+		Asset:  bond.Asset,
+		Type:   match.Debit,
+	}
+
+	var valid bool
+	if valid, err = setEngine.CheckValid(refundExec); err != nil {
+		err = fmt.Errorf("Error checking bond refund: %s", err)
+		return
+	}
+
+	if !valid {
+		err = fmt.Errorf("Error, invalid settlement exec for bond refund")
+		return
+	}
+
+	var setRes *match.SettlementResult
+	if setRes, err = setEngine.ApplySettlementExecution(refundExec); err != nil {
+		err = fmt.Errorf("Error giving back bond: %s", err)
+		return
+	}
+
+	refunded := *bond
+	refunded.Status = match.BondRefunded
+	if err = setStore.RecordBond(&refunded); err != nil {
+		err = fmt.Errorf("Error recording refund for bond: %s", err)
+		return
+	}
+
+	if err = setStore.UpdateBalances([]*match.SettlementResult{setRes}); err != nil {
+		err = fmt.Errorf("Error updating balances for bond refund: %s", err)
+		return
+	}
+	return
+}
+
+// forfeitBond records a held bond as forfeited. The funds were already taken when the bond was, so
+// nothing is applied. The db lock should be held.
+func (s *OpencxAuctionServer) forfeitBond(bond *match.Bond) (err error) {
+	var setStore cxdb.SettlementStore
+	if _, setStore, err = s.settlementForAsset(bond.Asset); err != nil {
+		err = fmt.Errorf("Error getting settlement for bond: %s", err)
+		return
+	}
+
+	forfeited := *bond
+	forfeited.Status = match.BondForfeited
+	if err = setStore.RecordBond(&forfeited); err != nil {
+		err = fmt.Errorf("Error recording forfeit for bond: %s", err)
+		return
+	}
+	return
+}
+
+// RefundOpenBonds gives back every bond that's still recorded as held. Commitments only live in
+// memory, so a bond that's held when the server starts belongs to a commitment that can never be
+// revealed. This is called when the server starts and when it shuts down.
+func (s *OpencxAuctionServer) RefundOpenBonds() (err error) {
+	s.dbLock.Lock()
+	err = s.refundOpenBonds()
+	s.dbLock.Unlock()
+	return
+}
+
+// refundOpenBonds is RefundOpenBonds with the db lock already held. Every bond is tried, even if
+// some fail.
+func (s *OpencxAuctionServer) refundOpenBonds() (err error) {
+	for coin, setStore := range s.SettlementStores {
+		var bonds []*match.Bond
+		var bondErr error
+		if bonds, bondErr = setStore.GetBonds(match.BondHeld); bondErr != nil {
+			logging.Errorf("Error getting held bonds for %s: %s", coin.Name, bondErr)
+			if err == nil {
+				err = fmt.Errorf("Error getting held bonds for %s: %s", coin.Name, bondErr)
+			}
+			continue
+		}
+
+		for _, bond := range bonds {
+			if bondErr = s.refundBond(bond); bondErr != nil {
+				logging.Errorf("Error refunding bond for commitment %x: %s", bond.Commitment, bondErr)
+				if err == nil {
+					err = fmt.Errorf("Error refunding bond for commitment %x: %s", bond.Commitment, bondErr)
+				}
+				continue
+			}
+			logging.Infof("Refunded open bond of %d %s for commitment %x", bond.Amount, bond.Asset.String(), bond.Commitment)
+		}
+	}
+	return
+}
+
+// PlaceOrderCommitment takes a commitment to an order for the current auction of a commit-reveal
+// pair, and holds the bond for it in the settlement engine. The commitment has to be signed by its
+// pubkey, since that's who the bond is taken from.
+func (s *OpencxAuctionServer) PlaceOrderCommitment(commitment *match.OrderCommitment) (err error) {
+	if commitment == nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Cannot place nil commitment, invalid")
+		return
+	}
+
+	if err = s.checkStopping(); err != nil {
+		return
+	}
+
+	var book *commitRevealBook
+	if book = s.commitRevealBook(&commitment.IntendedPair); book == nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Pair %s does not take order commitments", commitment.IntendedPair.String())
+		return
+	}
+
+	if err = verifyCommitmentSig(commitment); err != nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Invalid order commitment: %s", err)
+		return
+	}
+
+	bond := book.bondFor(commitment)
+
+	// The db lock is held from checking the balance to taking the bond, like every other check
+	// and apply on the settlement engines, so nothing can spend the balance in between. The book
+	// is locked until the commitment is stored, so the auction can't end after we've checked it
+	// and taken the bond.
+	s.dbLock.Lock()
+	var currBatcher match.AuctionBatcher
+	var ok bool
+	if currBatcher, ok = s.OrderBatchers[commitment.IntendedPair]; !ok {
+		err = fmt.Errorf("Could not find batcher for pair %s", commitment.IntendedPair.String())
+		s.dbLock.Unlock()
+		return
+	}
+
+	book.mtx.Lock()
+	if _, ok = currBatcher.ActiveAuctions()[commitment.IntendedAuction]; !ok {
+		err = match.Errorf(match.ErrAuctionClosed, "Auction %x is not taking commitments", commitment.IntendedAuction)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	crAuction := book.auction(commitment.IntendedAuction)
+	if crAuction.revealing {
+		err = match.Errorf(match.ErrAuctionClosed, "Auction %x is over, not taking commitments", commitment.IntendedAuction)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	for _, existing := range crAuction.commitments {
+		if existing.Commitment == commitment.Commitment {
+			err = fmt.Errorf("Commitment %x was already placed", commitment.Commitment)
+			book.mtx.Unlock()
+			s.dbLock.Unlock()
+			return
+		}
+	}
+
+	if err = s.takeBond(bond); err != nil {
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	crAuction.commitments = append(crAuction.commitments, commitment)
+	book.mtx.Unlock()
+	s.dbLock.Unlock()
+
+	logging.Infof("Got commitment %x for auction %x", commitment.Commitment, commitment.IntendedAuction)
+	return
+}
+
+// RevealOrder opens a commitment for an auction that's over, during its reveal window. The order
+// has to be valid, and once it's revealed the bond is given back. Reveals are still taken while
+// the server shuts down, since the bonds are already held.
+func (s *OpencxAuctionServer) RevealOrder(reveal *match.OrderReveal) (err error) {
+	if reveal == nil || reveal.Order == nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Cannot reveal nil order, invalid")
+		return
+	}
+
+	var book *commitRevealBook
+	if book = s.commitRevealBook(&reveal.Order.TradingPair); book == nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Pair %s does not take order commitments", reveal.Order.TradingPair.String())
+		return
+	}
+
+	if err = s.validateAuctionOrder(reveal.Order.AuctionID, reveal.Order); err != nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Revealed order is invalid: %s", err)
+		return
+	}
+
+	// Like taking the bond, giving it back holds the db lock and then the book, so the bond is
+	// refunded and recorded at most once
+	s.dbLock.Lock()
+	book.mtx.Lock()
+	var crAuction *commitRevealAuction
+	var ok bool
+	if crAuction, ok = book.auctions[reveal.Order.AuctionID]; !ok || !crAuction.revealing {
+		err = match.Errorf(match.ErrAuctionClosed, "Auction %x is not taking reveals", reveal.Order.AuctionID)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	if crAuction.result != nil {
+		err = match.Errorf(match.ErrAuctionClosed, "Reveal window for auction %x is closed", reveal.Order.AuctionID)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	commitment := match.CommitToOrder(reveal.Order, reveal.Salt)
+	var committed *match.OrderCommitment
+	for _, existing := range crAuction.commitments {
+		if existing.Commitment == commitment {
+			committed = existing
+			break
+		}
+	}
+
+	if committed == nil {
+		err = match.Errorf(match.ErrUnknownOrder, "No commitment for revealed order in auction %x", reveal.Order.AuctionID)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	if err = reveal.Opens(committed); err != nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Reveal does not open commitment: %s", err)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	if _, ok = crAuction.revealed[commitment]; ok {
+		err = fmt.Errorf("Commitment %x was already revealed", commitment)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	if err = s.refundBond(book.bondFor(committed)); err != nil {
+		err = fmt.Errorf("Error giving back bond for revealed order: %s", err)
+		book.mtx.Unlock()
+		s.dbLock.Unlock()
+		return
+	}
+
+	crAuction.revealed[commitment] = reveal.Order
+	book.mtx.Unlock()
+	s.dbLock.Unlock()
+
+	logging.Infof("Order for commitment %x revealed", commitment)
+	return
+}
+
+// CommitRevealResult gets what happened to the commitments in a commit-reveal auction, once the
+// reveal window has closed, for up to CommitRevealRetention after that
+func (s *OpencxAuctionServer) CommitRevealResult(pair *match.Pair, auctionID [32]byte) (result *CommitRevealResult, err error) {
+	var book *commitRevealBook
+	if book = s.commitRevealBook(pair); book == nil {
+		err = fmt.Errorf("Pair %s does not take order commitments", pair.String())
+		return
+	}
+
+	book.mtx.Lock()
+	var crAuction *commitRevealAuction
+	var ok bool
+	if crAuction, ok = book.auctions[auctionID]; !ok || crAuction.result == nil {
+		err = fmt.Errorf("No result for auction %x, its reveal window may not be closed yet, or it closed more than %s ago", auctionID, CommitRevealRetention)
+		book.mtx.Unlock()
+		return
+	}
+	result = crAuction.result
+	book.mtx.Unlock()
+	return
+}
+
+// endCommitments stops taking commitments for an auction, returning the commitments in the order
+// they were placed, so the exchange can commit to them.
+func (book *commitRevealBook) endCommitments(auctionID [32]byte) (commitments []*match.OrderCommitment) {
+	book.mtx.Lock()
+	crAuction := book.auction(auctionID)
+	crAuction.revealing = true
+	commitments = crAuction.commitments
+	book.mtx.Unlock()
+	return
+}
+
+// closeRevealWindow waits out the reveal window for an auction, then forfeits the bonds of
+// everyone who didn't reveal, and places and matches the revealed orders. Auctions whose results
+// are older than CommitRevealRetention are dropped. If the server shuts down first, nothing is
+// forfeited, and the bonds are given back by Shutdown. This should be done in a goroutine, after
+// adding it to revealWG.
+func (s *OpencxAuctionServer) closeRevealWindow(pair match.Pair, auctionID [32]byte, book *commitRevealBook) {
+	defer s.revealWG.Done()
+
+	select {
+	case <-time.After(book.params.RevealWindow):
+	case <-s.revealStop:
+		logging.Warnf("Shutting down before the reveal window for auction %x closed, giving back its bonds instead", auctionID)
+		return
+	}
+
+	// The db lock is held until the bonds are forfeited, so nothing can refund them after the
+	// result says they're kept
+	s.dbLock.Lock()
+	book.mtx.Lock()
+	now := time.Now()
+	for id, oldAuction := range book.auctions {
+		if oldAuction.result != nil && now.Sub(oldAuction.closed) > CommitRevealRetention {
+			delete(book.auctions, id)
+		}
+	}
+
+	crAuction := book.auction(auctionID)
+	result := &CommitRevealResult{
+		AuctionID: auctionID,
+		Revealed:  []*match.AuctionOrder{},
+		Forfeited: []*match.OrderCommitment{},
+	}
+	for _, commitment := range crAuction.commitments {
+		if order, ok := crAuction.revealed[commitment.Commitment]; ok {
+			result.Revealed = append(result.Revealed, order)
+		} else {
+			result.Forfeited = append(result.Forfeited, commitment)
+		}
+	}
+	crAuction.result = result
+	crAuction.closed = now
+	book.mtx.Unlock()
+
+	for _, forfeited := range result.Forfeited {
+		logging.Warnf("Commitment %x by %x was not revealed, keeping bond of %d %s", forfeited.Commitment, forfeited.Pubkey, book.params.BondAmount, book.params.BondAsset.String())
+		if err := s.forfeitBond(book.bondFor(forfeited)); err != nil {
+			logging.Errorf("Error forfeiting bond for commitment %x: %s", forfeited.Commitment, err)
+		}
+	}
+	s.dbLock.Unlock()
+
+	if err := s.placeRevealedOrders(&pair, auctionID, result.Revealed); err != nil {
+		logging.Errorf("Error placing revealed orders for auction %x: %s", auctionID, err)
+	}
+	return
+}
+
+// placeRevealedOrders places the revealed orders for an auction and matches them
+func (s *OpencxAuctionServer) placeRevealedOrders(pair *match.Pair, auctionID [32]byte, orders []*match.AuctionOrder) (err error) {
+	if len(orders) == 0 {
+		return
+	}
+
+	idStruct := new(match.AuctionID)
+	if err = idStruct.UnmarshalBinary(auctionID[:]); err != nil {
+		err = fmt.Errorf("Error unmarshalling auction ID: %s", err)
+		return
+	}
+
+	s.dbLock.Lock()
+	var auctionEngine match.AuctionEngine
+	var ok bool
+	if auctionEngine, ok = s.MatchingEngines[*pair]; !ok {
+		err = fmt.Errorf("Could not find matching engine for pair %s", pair.String())
+		s.dbLock.Unlock()
+		return
+	}
+
+	for _, order := range orders {
+		var placeRes *match.AuctionOrderIDPair
+		if placeRes, err = auctionEngine.PlaceAuctionOrder(order, idStruct); err != nil {
+			err = fmt.Errorf("Error placing revealed order: %s", err)
+			s.dbLock.Unlock()
+			return
+		}
+		logging.Infof("Placed revealed order %x for auction %x", placeRes.OrderID[:], auctionID)
+	}
+	s.dbLock.Unlock()
+
+	if err = s.runMatching(idStruct, pair); err != nil {
+		err = fmt.Errorf("Error matching revealed orders: %s", err)
+		return
+	}
+	return
+}
+
+// verifyCommitmentSig checks that the commitment is signed by its pubkey
+func verifyCommitmentSig(commitment *match.OrderCommitment) (err error) {
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = koblitz.ParsePubKey(commitment.Pubkey[:], koblitz.S256()); err != nil {
+		err = fmt.Errorf("Commitment pubkey cannot be parsed: %s", err)
+		return
+	}
+
+	sha3 := sha3.New256()
+	sha3.Write(commitment.SerializeSignable())
+	e := sha3.Sum(nil)
+
+	var recoveredPubkey *koblitz.PublicKey
+	if recoveredPubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), commitment.Signature, e); err != nil {
+		err = fmt.Errorf("Commitment signature cannot be verified with pubkey recovery: %s", err)
+		return
+	}
+
+	if !recoveredPubkey.IsEqual(pubkey) {
+		err = fmt.Errorf("Commitment is signed by %x, not its pubkey %x", recoveredPubkey.SerializeCompressed(), pubkey.SerializeCompressed())
+		return
+	}
+	return
+}
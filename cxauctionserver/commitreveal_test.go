@@ -0,0 +1,492 @@
+package cxauctionserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/golangcrypto/sha3"
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb/cxdbmemory"
+	"github.com/mit-dci/opencx/match"
+)
+
+const (
+	testBondAmount   = uint64(1000)
+	testRevealWindow = 100 * time.Millisecond
+)
+
+var testCommitAuction = [32]byte{0xc0, 0xff, 0xee}
+
+// initCommitRevealServer creates a test server whose test pair takes commitments, with a memory
+// settlement engine so bonds can be held, and starts an auction
+func initCommitRevealServer(t *testing.T) (s *OpencxAuctionServer) {
+	var err error
+	if s, err = initTestServer(); err != nil {
+		t.Fatalf("Error initializing test server: %s", err)
+	}
+
+	if s.SettlementEngines, err = cxdbmemory.CreateSettlementEngineMap(testCoins); err != nil {
+		t.Fatalf("Error creating settlement engines: %s", err)
+	}
+
+	params := CommitRevealParams{
+		BondAsset:    match.BTCReg,
+		BondAmount:   testBondAmount,
+		RevealWindow: testRevealWindow,
+	}
+	if err = s.SetCommitReveal(&testAuctionOrder.TradingPair, params); err != nil {
+		t.Fatalf("Error setting commit-reveal for test pair: %s", err)
+	}
+
+	if err = s.StartAuctionWithID(&testAuctionOrder.TradingPair, testCommitAuction); err != nil {
+		t.Fatalf("Error starting auction: %s", err)
+	}
+	return
+}
+
+// bondBalance gets the balance of the bond asset for the pubkey, by applying an empty debit
+func bondBalance(t *testing.T, s *OpencxAuctionServer, pubkey [33]byte) (balance uint64) {
+	setRes, err := s.SettlementEngines[&coinparam.RegressionNetParams].ApplySettlementExecution(&match.SettlementExecution{
+		Pubkey: pubkey,
+		Asset:  match.BTCReg,
+		Type:   match.Debit,
+	})
+	if err != nil {
+		t.Fatalf("Error getting bond balance: %s", err)
+	}
+	balance = setRes.NewBal
+	return
+}
+
+// bondsWithStatus gets the bonds the settlement store has with the status
+func bondsWithStatus(t *testing.T, s *OpencxAuctionServer, status match.BondStatus) (bonds []*match.Bond) {
+	var err error
+	if bonds, err = s.SettlementStores[&coinparam.RegressionNetParams].GetBonds(status); err != nil {
+		t.Fatalf("Error getting %s bonds: %s", status.String(), err)
+	}
+	return
+}
+
+// signedCommitment creates a new key with enough for a bond, and a signed order for the test
+// auction and a signed commitment to it
+func signedCommitment(t *testing.T, s *OpencxAuctionServer) (commitment *match.OrderCommitment, reveal *match.OrderReveal) {
+	privkey, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Fatalf("Error creating private key: %s", err)
+	}
+
+	commitment, reveal = signedCommitmentFrom(t, privkey)
+
+	if _, err = s.SettlementEngines[&coinparam.RegressionNetParams].ApplySettlementExecution(&match.SettlementExecution{
+		Pubkey: commitment.Pubkey,
+		Amount: testBondAmount,
+		Asset:  match.BTCReg,
+		Type:   match.Debit,
+	}); err != nil {
+		t.Fatalf("Error funding bond: %s", err)
+	}
+	return
+}
+
+// signedCommitmentFrom creates a signed order for the test auction from the key, and a signed
+// commitment to it with a new salt, without funding a bond
+func signedCommitmentFrom(t *testing.T, privkey *koblitz.PrivateKey) (commitment *match.OrderCommitment, reveal *match.OrderReveal) {
+	var err error
+	order := *testAuctionOrder
+	order.AuctionID = testCommitAuction
+	copy(order.Pubkey[:], privkey.PubKey().SerializeCompressed())
+
+	orderHash := sha3.New256()
+	orderHash.Write(order.SerializeSignable())
+	if order.Signature, err = koblitz.SignCompact(koblitz.S256(), privkey, orderHash.Sum(nil), false); err != nil {
+		t.Fatalf("Error signing order: %s", err)
+	}
+
+	if commitment, reveal, err = order.TurnIntoCommitment(); err != nil {
+		t.Fatalf("Error committing to order: %s", err)
+	}
+
+	commitHash := sha3.New256()
+	commitHash.Write(commitment.SerializeSignable())
+	if commitment.Signature, err = koblitz.SignCompact(koblitz.S256(), privkey, commitHash.Sum(nil), false); err != nil {
+		t.Fatalf("Error signing commitment: %s", err)
+	}
+	return
+}
+
+// waitForCommitRevealResult waits for the reveal window of the test auction to close
+func waitForCommitRevealResult(t *testing.T, s *OpencxAuctionServer) (result *CommitRevealResult) {
+	var err error
+	deadline := time.Now().Add(10 * testRevealWindow)
+	for time.Now().Before(deadline) {
+		if result, err = s.CommitRevealResult(&testAuctionOrder.TradingPair, testCommitAuction); err == nil {
+			return
+		}
+		time.Sleep(testRevealWindow / 10)
+	}
+	t.Fatalf("Timed out waiting for reveal window to close: %s", err)
+	return
+}
+
+func TestCommitReveal(t *testing.T) {
+	var err error
+
+	s := initCommitRevealServer(t)
+	revealer, reveal := signedCommitment(t, s)
+	noShow, _ := signedCommitment(t, s)
+
+	for _, commitment := range []*match.OrderCommitment{revealer, noShow} {
+		if err = s.PlaceOrderCommitment(commitment); err != nil {
+			t.Errorf("Error placing commitment: %s", err)
+			return
+		}
+
+		if balance := bondBalance(t, s, commitment.Pubkey); balance != 0 {
+			t.Errorf("Bond should be held after committing, balance is %d", balance)
+			return
+		}
+	}
+
+	if err = s.PlaceOrderCommitment(revealer); err == nil {
+		t.Errorf("Should not place the same commitment twice")
+		return
+	}
+
+	// orders can't be revealed while the auction is running
+	if err = s.RevealOrder(reveal); err == nil {
+		t.Errorf("Should not reveal order before the auction ends")
+		return
+	}
+
+	if _, err = s.CommitOrdersNewAuction(&testAuctionOrder.TradingPair, testCommitAuction); err != nil {
+		t.Errorf("Error ending commit stage: %s", err)
+		return
+	}
+
+	late, _ := signedCommitment(t, s)
+	if err = s.PlaceOrderCommitment(late); err == nil {
+		t.Errorf("Should not place commitment after the auction ends")
+		return
+	}
+
+	// the salt is what hides the order, the wrong one doesn't open the commitment
+	wrongSalt := *reveal
+	wrongSalt.Salt[0] ^= 0x01
+	if err = s.RevealOrder(&wrongSalt); err == nil {
+		t.Errorf("Should not reveal order with the wrong salt")
+		return
+	}
+
+	if err = s.RevealOrder(reveal); err != nil {
+		t.Errorf("Error revealing order: %s", err)
+		return
+	}
+
+	if balance := bondBalance(t, s, revealer.Pubkey); balance != testBondAmount {
+		t.Errorf("Bond should be given back after revealing, balance is %d", balance)
+		return
+	}
+
+	if err = s.RevealOrder(reveal); err == nil {
+		t.Errorf("Should not reveal the same order twice")
+		return
+	}
+
+	result := waitForCommitRevealResult(t, s)
+	if len(result.Revealed) != 1 || len(result.Forfeited) != 1 {
+		t.Errorf("Expected 1 revealed and 1 forfeited, got %d revealed and %d forfeited", len(result.Revealed), len(result.Forfeited))
+		return
+	}
+
+	if result.Forfeited[0].Pubkey != noShow.Pubkey {
+		t.Errorf("Forfeited commitment should be the one that wasn't revealed")
+		return
+	}
+
+	if balance := bondBalance(t, s, noShow.Pubkey); balance != 0 {
+		t.Errorf("Bond should be kept when the order isn't revealed, balance is %d", balance)
+		return
+	}
+
+	if err = s.RevealOrder(reveal); err == nil {
+		t.Errorf("Should not reveal after the reveal window closes")
+		return
+	}
+
+	return
+}
+
+// TestBondsRecorded makes sure every bond is recorded in the settlement store when it's taken,
+// and again when it's given back or forfeited
+func TestBondsRecorded(t *testing.T) {
+	var err error
+
+	s := initCommitRevealServer(t)
+	revealer, reveal := signedCommitment(t, s)
+	noShow, _ := signedCommitment(t, s)
+
+	for _, commitment := range []*match.OrderCommitment{revealer, noShow} {
+		if err = s.PlaceOrderCommitment(commitment); err != nil {
+			t.Errorf("Error placing commitment: %s", err)
+			return
+		}
+	}
+
+	if held := bondsWithStatus(t, s, match.BondHeld); len(held) != 2 {
+		t.Errorf("Expected 2 held bonds after committing, got %d", len(held))
+		return
+	}
+
+	if _, err = s.CommitOrdersNewAuction(&testAuctionOrder.TradingPair, testCommitAuction); err != nil {
+		t.Errorf("Error ending commit stage: %s", err)
+		return
+	}
+
+	if err = s.RevealOrder(reveal); err != nil {
+		t.Errorf("Error revealing order: %s", err)
+		return
+	}
+
+	waitForCommitRevealResult(t, s)
+
+	if held := bondsWithStatus(t, s, match.BondHeld); len(held) != 0 {
+		t.Errorf("Expected no held bonds after the reveal window, got %d", len(held))
+		return
+	}
+
+	refunded := bondsWithStatus(t, s, match.BondRefunded)
+	if len(refunded) != 1 || refunded[0].Pubkey != revealer.Pubkey || refunded[0].Commitment != revealer.Commitment {
+		t.Errorf("Expected the revealed commitment's bond to be recorded as refunded, got %d refunded", len(refunded))
+		return
+	}
+
+	forfeited := bondsWithStatus(t, s, match.BondForfeited)
+	if len(forfeited) != 1 || forfeited[0].Pubkey != noShow.Pubkey || forfeited[0].Amount != testBondAmount {
+		t.Errorf("Expected the unrevealed commitment's bond to be recorded as forfeited, got %d forfeited", len(forfeited))
+		return
+	}
+
+	return
+}
+
+// TestOpenBondsRefunded makes sure bonds that are still held, like the ones left when a server
+// stopped, are given back
+func TestOpenBondsRefunded(t *testing.T) {
+	var err error
+
+	s := initCommitRevealServer(t)
+	commitment, _ := signedCommitment(t, s)
+
+	if err = s.PlaceOrderCommitment(commitment); err != nil {
+		t.Errorf("Error placing commitment: %s", err)
+		return
+	}
+
+	if err = s.RefundOpenBonds(); err != nil {
+		t.Errorf("Error refunding open bonds: %s", err)
+		return
+	}
+
+	if balance := bondBalance(t, s, commitment.Pubkey); balance != testBondAmount {
+		t.Errorf("Open bond should be given back, balance is %d", balance)
+		return
+	}
+
+	if held := bondsWithStatus(t, s, match.BondHeld); len(held) != 0 {
+		t.Errorf("Expected no held bonds after refunding, got %d", len(held))
+		return
+	}
+
+	// refunding again shouldn't give anything more back
+	if err = s.RefundOpenBonds(); err != nil {
+		t.Errorf("Error refunding open bonds a second time: %s", err)
+		return
+	}
+
+	if balance := bondBalance(t, s, commitment.Pubkey); balance != testBondAmount {
+		t.Errorf("Bond should only be given back once, balance is %d", balance)
+		return
+	}
+
+	return
+}
+
+// TestShutdownDuringRevealWindow makes sure shutting down doesn't wait out a reveal window, and
+// gives back the bonds instead of keeping them, since nobody can reveal once the server is gone
+func TestShutdownDuringRevealWindow(t *testing.T) {
+	var err error
+
+	s := initCommitRevealServer(t)
+	params := CommitRevealParams{
+		BondAsset:    match.BTCReg,
+		BondAmount:   testBondAmount,
+		RevealWindow: time.Hour,
+	}
+	if err = s.SetCommitReveal(&testAuctionOrder.TradingPair, params); err != nil {
+		t.Errorf("Error setting long reveal window: %s", err)
+		return
+	}
+
+	commitment, _ := signedCommitment(t, s)
+	if err = s.PlaceOrderCommitment(commitment); err != nil {
+		t.Errorf("Error placing commitment: %s", err)
+		return
+	}
+
+	if _, err = s.CommitOrdersNewAuction(&testAuctionOrder.TradingPair, testCommitAuction); err != nil {
+		t.Errorf("Error ending commit stage: %s", err)
+		return
+	}
+
+	// the engines are gone after shutdown, so keep them to check the balance
+	setEngines := s.SettlementEngines
+	setStores := s.SettlementStores
+
+	shutdownChan := make(chan error, 1)
+	go func() {
+		shutdownChan <- s.Shutdown(false)
+	}()
+
+	select {
+	case err = <-shutdownChan:
+		if err != nil {
+			t.Errorf("Error shutting down: %s", err)
+			return
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("Shutdown waited for the reveal window to close")
+		return
+	}
+
+	s.SettlementEngines = setEngines
+	s.SettlementStores = setStores
+	if balance := bondBalance(t, s, commitment.Pubkey); balance != testBondAmount {
+		t.Errorf("Bond should be given back on shutdown, balance is %d", balance)
+		return
+	}
+
+	if forfeited := bondsWithStatus(t, s, match.BondForfeited); len(forfeited) != 0 {
+		t.Errorf("No bonds should be forfeited on shutdown, got %d", len(forfeited))
+		return
+	}
+
+	return
+}
+
+func TestCommitRevealRejectsPuzzles(t *testing.T) {
+	var err error
+
+	s := initCommitRevealServer(t)
+
+	encOrder := *testEncryptedOrder
+	encOrder.IntendedAuction = testCommitAuction
+	if err = s.PlacePuzzledOrder(&encOrder); err == nil {
+		t.Errorf("Commit-reveal pair should not take encrypted orders")
+		return
+	}
+
+	// a commitment has to be signed by the key that puts up the bond
+	commitment, _ := signedCommitment(t, s)
+	commitment.Pubkey = testAuctionOrder.Pubkey
+	if err = s.PlaceOrderCommitment(commitment); err == nil {
+		t.Errorf("Should not place commitment signed by someone else")
+		return
+	}
+
+	if err = s.SetCommitReveal(&testAuctionOrder.TradingPair, CommitRevealParams{BondAsset: match.BTCReg, RevealWindow: testRevealWindow}); err == nil {
+		t.Errorf("Should not set commit-reveal without a bond")
+		return
+	}
+
+	return
+}
+
+// TestCommitmentBondOnce makes sure commitments placed at the same time can't both take a bond
+// out of a balance that only covers one
+func TestCommitmentBondOnce(t *testing.T) {
+	s := initCommitRevealServer(t)
+
+	privkey, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Errorf("Error creating private key: %s", err)
+		return
+	}
+
+	// every commitment is from the same key, with its own salt, but there's only one bond
+	numCommitments := 8
+	var commitments []*match.OrderCommitment
+	for i := 0; i < numCommitments; i++ {
+		commitment, _ := signedCommitmentFrom(t, privkey)
+		commitments = append(commitments, commitment)
+	}
+
+	if _, err = s.SettlementEngines[&coinparam.RegressionNetParams].ApplySettlementExecution(&match.SettlementExecution{
+		Pubkey: commitments[0].Pubkey,
+		Amount: testBondAmount,
+		Asset:  match.BTCReg,
+		Type:   match.Debit,
+	}); err != nil {
+		t.Errorf("Error funding bond: %s", err)
+		return
+	}
+
+	errChan := make(chan error, numCommitments)
+	for _, commitment := range commitments {
+		go func(commitment *match.OrderCommitment) {
+			errChan <- s.PlaceOrderCommitment(commitment)
+		}(commitment)
+	}
+
+	placed := 0
+	for i := 0; i < numCommitments; i++ {
+		if <-errChan == nil {
+			placed++
+		}
+	}
+
+	if placed != 1 {
+		t.Errorf("Only one commitment should take the bond, %d did", placed)
+		return
+	}
+
+	if balance := bondBalance(t, s, commitments[0].Pubkey); balance != 0 {
+		t.Errorf("Balance should be 0 after one bond, got %d", balance)
+		return
+	}
+
+	return
+}
+
+// TestCommitRevealResultsPruned makes sure old commit-reveal auctions are dropped once their
+// results are older than the retention
+func TestCommitRevealResultsPruned(t *testing.T) {
+	var err error
+
+	s := initCommitRevealServer(t)
+	book := s.commitRevealBook(&testAuctionOrder.TradingPair)
+
+	oldAuction := [32]byte{0x01, 0xd0}
+	book.mtx.Lock()
+	book.auctions[oldAuction] = &commitRevealAuction{
+		revealed:  make(map[[32]byte]*match.AuctionOrder),
+		revealing: true,
+		result:    &CommitRevealResult{AuctionID: oldAuction},
+		closed:    time.Now().Add(-2 * CommitRevealRetention),
+	}
+	book.mtx.Unlock()
+
+	if _, err = s.CommitOrdersNewAuction(&testAuctionOrder.TradingPair, testCommitAuction); err != nil {
+		t.Errorf("Error ending commit stage: %s", err)
+		return
+	}
+
+	waitForCommitRevealResult(t, s)
+
+	if _, err = s.CommitRevealResult(&testAuctionOrder.TradingPair, oldAuction); err == nil {
+		t.Errorf("Result older than the retention should be dropped")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,419 @@
+package cxauctionserver
+
+import (
+	"crypto/elliptic"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// CommitteeCurve is the curve that the exchange's decryption committee keys are on, the same curve
+// as every other key on the exchange.
+var CommitteeCurve elliptic.Curve = koblitz.S256()
+
+// KeyHolder is a member of a decryption committee. Once an auction is over, it gives out its
+// decryption shares for the capsules of the orders in the auction.
+type KeyHolder interface {
+	// DecryptionShares checks the exchange's signed auction end, and returns a decryption share
+	// for the capsule of each order in it, in the same order
+	DecryptionShares(end *AuctionEnd) (shares []*threshold.DecryptionShare, err error)
+}
+
+// LocalKeyHolder is a key holder that runs in the same process as the batcher. This is useful for
+// testing, but a real committee should have each key holder run keyholderd on their own machine,
+// and use cxkeyholderrpc.RemoteKeyHolder.
+type LocalKeyHolder struct {
+	share     *threshold.KeyShare
+	committee *threshold.CommitteeKey
+	checker   *AuctionEndChecker
+}
+
+// NewLocalKeyHolder creates a local key holder for a share of the committee key, which only gives
+// out shares for auction ends signed by the exchange key
+func NewLocalKeyHolder(share *threshold.KeyShare, committee *threshold.CommitteeKey, exchangeKey *koblitz.PublicKey) (holder *LocalKeyHolder, err error) {
+	if share == nil || committee == nil || exchangeKey == nil {
+		err = fmt.Errorf("Cannot create key holder with nil share, committee, or exchange key")
+		return
+	}
+
+	holder = &LocalKeyHolder{
+		share:     share,
+		committee: committee,
+	}
+	if holder.checker, err = NewAuctionEndChecker(committee, []*koblitz.PublicKey{exchangeKey}); err != nil {
+		err = fmt.Errorf("Error creating auction end checker for local key holder: %s", err)
+		return
+	}
+	return
+}
+
+// DecryptionShares checks the auction end and returns this key holder's decryption share for each
+// order in it
+func (lkh *LocalKeyHolder) DecryptionShares(end *AuctionEnd) (shares []*threshold.DecryptionShare, err error) {
+	var capsules []*threshold.Capsule
+	if capsules, err = lkh.checker.Check(end); err != nil {
+		err = fmt.Errorf("Not giving out decryption shares: %s", err)
+		return
+	}
+
+	for _, capsule := range capsules {
+		var share *threshold.DecryptionShare
+		if share, err = lkh.share.DecryptionShare(lkh.committee, capsule); err != nil {
+			err = fmt.Errorf("Error creating decryption share for auction %x: %s", end.AuctionID, err)
+			return
+		}
+		shares = append(shares, share)
+	}
+	return
+}
+
+// committeeBatch is an auction whose orders are waiting for the committee to decrypt them
+type committeeBatch struct {
+	orders     []*match.EncryptedAuctionOrder
+	active     bool
+	started    time.Time
+	solvedChan chan *match.AuctionBatch
+}
+
+// CommitteeBatcher is an AuctionBatcher for orders encrypted to a decryption committee instead of
+// with timelock puzzles. Nothing is solved while the auction runs, orders are just collected. Once
+// the auction ends, the batcher signs an auction end with every order in it, every key holder is
+// asked for decryption shares, and each order is decrypted with the good shares, so auctions don't
+// depend on how fast we can square.
+type CommitteeBatcher struct {
+	committee    *threshold.CommitteeKey
+	holders      []KeyHolder
+	privkey      *koblitz.PrivateKey
+	maxBatchSize uint64
+	batchMap     map[[32]byte]*committeeBatch
+	batchMapMtx  sync.Mutex
+}
+
+// NewCommitteeBatcher creates a batcher that has the key holders decrypt orders encrypted to the
+// committee. There have to be at least as many key holders as the committee threshold, and the
+// key holders have to accept auction ends signed with privkey.
+func NewCommitteeBatcher(maxBatchSize uint64, committee *threshold.CommitteeKey, holders []KeyHolder, privkey *koblitz.PrivateKey) (batcher *CommitteeBatcher, err error) {
+	if privkey == nil {
+		err = fmt.Errorf("Committee batcher needs a key to sign auction ends")
+		return
+	}
+
+	if maxBatchSize == 0 {
+		err = fmt.Errorf("Cannot have a max batch size of 0")
+		return
+	}
+
+	if committee == nil || len(committee.ShareKeys) == 0 {
+		err = fmt.Errorf("Committee batcher needs a committee key with share keys to check decryption shares")
+		return
+	}
+
+	if len(holders) < committee.Threshold {
+		err = fmt.Errorf("Committee needs %d key holders to decrypt, only have %d", committee.Threshold, len(holders))
+		return
+	}
+
+	batcher = &CommitteeBatcher{
+		committee:    committee,
+		holders:      holders,
+		privkey:      privkey,
+		maxBatchSize: maxBatchSize,
+		batchMap:     make(map[[32]byte]*committeeBatch),
+	}
+	return
+}
+
+// RegisterAuction registers a new auction with a specified Auction ID
+func (cb *CommitteeBatcher) RegisterAuction(auctionID [32]byte) (err error) {
+	cb.batchMapMtx.Lock()
+	cb.batchMap[auctionID] = &committeeBatch{
+		active:     true,
+		started:    time.Now(),
+		solvedChan: make(chan *match.AuctionBatch, 1),
+	}
+	cb.batchMapMtx.Unlock()
+	return
+}
+
+// AddEncrypted adds an order encrypted to the committee to an auction. This errors if the auction
+// doesn't exist, is ended, or is full, or if the order isn't encrypted to a committee.
+func (cb *CommitteeBatcher) AddEncrypted(order *match.EncryptedAuctionOrder) (err error) {
+	var scheme match.EncryptionScheme
+	if scheme, err = order.GetScheme(); err != nil {
+		err = match.Wrapf(err, "Cannot add encrypted order to committee auction: %s", err)
+		return
+	}
+
+	if scheme.Timelock != match.TimelockCommittee {
+		err = fmt.Errorf("Cannot add order with scheme %s to committee auction", scheme.String())
+		return
+	}
+
+	// Key holders won't give out shares for capsules that aren't bound to the order's auction
+	if _, err = order.CommitteeCapsule(cb.committee); err != nil {
+		err = fmt.Errorf("Cannot add order with bad capsule to committee auction: %s", err)
+		return
+	}
+
+	cb.batchMapMtx.Lock()
+	var batch *committeeBatch
+	var ok bool
+	if batch, ok = cb.batchMap[order.IntendedAuction]; !ok {
+		err = fmt.Errorf("Cannot add encrypted order to unregistered auction %x", order.IntendedAuction)
+		cb.batchMapMtx.Unlock()
+		return
+	}
+
+	if !batch.active {
+		err = fmt.Errorf("Cannot add encrypted order to inactive auction")
+		cb.batchMapMtx.Unlock()
+		return
+	}
+
+	if uint64(len(batch.orders)) >= cb.maxBatchSize {
+		err = fmt.Errorf("Cannot add encrypted order to auction, auction is full with %d orders", cb.maxBatchSize)
+		cb.batchMapMtx.Unlock()
+		return
+	}
+
+	// The auction end is for a single pair
+	if len(batch.orders) > 0 && batch.orders[0].IntendedPair != order.IntendedPair {
+		err = fmt.Errorf("Cannot add order for pair %s to auction for pair %s", order.IntendedPair.String(), batch.orders[0].IntendedPair.String())
+		cb.batchMapMtx.Unlock()
+		return
+	}
+
+	batch.orders = append(batch.orders, order)
+	cb.batchMapMtx.Unlock()
+	return
+}
+
+// EndAuction ends the auction with the specified auction ID, and returns the channel which will
+// receive the batch once the committee has decrypted it.
+func (cb *CommitteeBatcher) EndAuction(auctionID [32]byte) (batchChan chan *match.AuctionBatch, err error) {
+	cb.batchMapMtx.Lock()
+	var batch *committeeBatch
+	var ok bool
+	if batch, ok = cb.batchMap[auctionID]; !ok {
+		err = fmt.Errorf("Cannot end unregistered auction %x", auctionID)
+		cb.batchMapMtx.Unlock()
+		return
+	}
+
+	if !batch.active {
+		err = fmt.Errorf("Cannot end inactive auction")
+		cb.batchMapMtx.Unlock()
+		return
+	}
+	batch.active = false
+	orders := batch.orders
+	batchChan = batch.solvedChan
+	// Nothing else can be done with an ended auction, so it doesn't need to be kept around
+	delete(cb.batchMap, auctionID)
+	cb.batchMapMtx.Unlock()
+
+	// No more orders can be added, so it's safe to give out shares now
+	go func() {
+		batchChan <- cb.decryptBatch(auctionID, orders)
+	}()
+	return
+}
+
+// ActiveAuctions returns a map of auction id to time
+func (cb *CommitteeBatcher) ActiveAuctions() (activeBatches map[[32]byte]time.Time) {
+	activeBatches = make(map[[32]byte]time.Time)
+
+	cb.batchMapMtx.Lock()
+	for id, batch := range cb.batchMap {
+		if batch.active {
+			activeBatches[id] = batch.started
+		}
+	}
+	cb.batchMapMtx.Unlock()
+
+	return
+}
+
+// decryptBatch asks every key holder for decryption shares for the orders, and decrypts each order
+// with the good shares. Key holders that fail or give out bad shares are skipped, so the batch
+// decrypts as long as enough of them are honest.
+func (cb *CommitteeBatcher) decryptBatch(auctionID [32]byte, orders []*match.EncryptedAuctionOrder) (auctionBatch *match.AuctionBatch) {
+	auctionBatch = &match.AuctionBatch{
+		AuctionID: auctionID,
+		Batch:     []*match.OrderPuzzleResult{},
+	}
+
+	// Nothing to decrypt, so there's no reason to bother the key holders
+	if len(orders) == 0 {
+		return
+	}
+
+	// AddEncrypted makes sure these are all capsules
+	capsules := make([]*threshold.Capsule, len(orders))
+	for i, order := range orders {
+		capsules[i] = order.OrderPuzzle.(*threshold.Capsule)
+	}
+
+	// Key holders only give out shares once we've committed to every order in the auction. Every
+	// order in an auction is for the same pair, so the pair comes from the first one.
+	var end *AuctionEnd
+	var err error
+	if end, err = SignAuctionEnd(cb.privkey, auctionID, orders[0].IntendedPair, orders); err != nil {
+		err = fmt.Errorf("Error signing auction end for committee: %s", err)
+		for _, order := range orders {
+			auctionBatch.Batch = append(auctionBatch.Batch, &match.OrderPuzzleResult{
+				Encrypted: order,
+				Err:       err,
+			})
+		}
+		return
+	}
+
+	// Ask the key holders at the same time, since they're independent
+	holderShares := make([][]*threshold.DecryptionShare, len(cb.holders))
+	var wg sync.WaitGroup
+	wg.Add(len(cb.holders))
+	for h, holder := range cb.holders {
+		go func(h int, holder KeyHolder) {
+			shares, err := holder.DecryptionShares(end)
+			if err != nil {
+				logging.Errorf("Key holder %d failed to give decryption shares for auction %x: %s", h, auctionID, err)
+			} else if len(shares) != len(capsules) {
+				logging.Errorf("Key holder %d gave %d decryption shares for %d orders in auction %x", h, len(shares), len(capsules), auctionID)
+			} else {
+				holderShares[h] = shares
+			}
+			wg.Done()
+		}(h, holder)
+	}
+	wg.Wait()
+
+	for i, order := range orders {
+		var goodShares []*threshold.DecryptionShare
+		for h, shares := range holderShares {
+			if shares == nil {
+				continue
+			}
+			if err := cb.committee.VerifyShare(capsules[i], shares[i]); err != nil {
+				logging.Errorf("Key holder %d gave a bad decryption share for auction %x: %s", h, auctionID, err)
+				continue
+			}
+			goodShares = append(goodShares, shares[i])
+		}
+
+		result := &match.OrderPuzzleResult{
+			Encrypted: order,
+		}
+		if result.Auction, err = order.DecryptWithShares(cb.committee, goodShares); err != nil {
+			result.Err = fmt.Errorf("Error decrypting order with committee shares: %s", err)
+		}
+		auctionBatch.Batch = append(auctionBatch.Batch, result)
+	}
+
+	return
+}
+
+// CreateCommitteeBatcherMap creates a committee batcher for every pair, all using the same
+// committee, key holders, and key to sign auction ends.
+func CreateCommitteeBatcherMap(pairList []*match.Pair, maxBatchSize uint64, committee *threshold.CommitteeKey, holders []KeyHolder, privkey *koblitz.PrivateKey) (batchers map[match.Pair]match.AuctionBatcher, err error) {
+	batchers = make(map[match.Pair]match.AuctionBatcher)
+
+	var currBatcher *CommitteeBatcher
+	for _, pair := range pairList {
+		if currBatcher, err = NewCommitteeBatcher(maxBatchSize, committee, holders, privkey); err != nil {
+			err = fmt.Errorf("Error creating new committee batcher for %s pair: %s", pair.String(), err)
+			return
+		}
+		batchers[*pair] = currBatcher
+	}
+
+	return
+}
+
+// UseCommittee puts the server in committee mode. Every pair gets a committee batcher, so orders are
+// encrypted to the committee and decrypted by the key holders after each auction ends, and only
+// committee schemes are allowed. Auction ends are signed with privkey, which the key holders have
+// to accept. The batchers it replaces are stopped. Like the other auction params, this should be
+// set before the server starts taking orders.
+func (s *OpencxAuctionServer) UseCommittee(committee *threshold.CommitteeKey, holders []KeyHolder, maxBatchSize uint64, privkey *koblitz.PrivateKey) (err error) {
+	var committeeSchemes []match.EncryptionScheme
+	for _, scheme := range match.SupportedEncryptionSchemes {
+		if scheme.Timelock == match.TimelockCommittee {
+			committeeSchemes = append(committeeSchemes, scheme)
+		}
+	}
+
+	s.dbLock.Lock()
+	if s.committee != nil {
+		err = fmt.Errorf("Server is already in committee mode")
+		s.dbLock.Unlock()
+		return
+	}
+
+	var pairList []*match.Pair
+	for pair := range s.OrderBatchers {
+		pairCopy := pair
+		pairList = append(pairList, &pairCopy)
+	}
+
+	var batchers map[match.Pair]match.AuctionBatcher
+	if batchers, err = CreateCommitteeBatcherMap(pairList, maxBatchSize, committee, holders, privkey); err != nil {
+		err = fmt.Errorf("Error creating committee batchers: %s", err)
+		s.dbLock.Unlock()
+		return
+	}
+
+	oldBatchers := s.OrderBatchers
+	s.OrderBatchers = batchers
+	s.committee = committee
+
+	// Timelocked orders can't go to the committee batchers, so only committee schemes are allowed
+	if err = s.SetAllowedSchemes(committeeSchemes); err != nil {
+		err = fmt.Errorf("Error allowing committee schemes: %s", err)
+		s.OrderBatchers = oldBatchers
+		s.committee = nil
+		s.dbLock.Unlock()
+		return
+	}
+	s.dbLock.Unlock()
+
+	stopBatchers(oldBatchers)
+	return
+}
+
+// DealLocalCommittee deals a committee key and creates a local key holder for every share, which
+// accept auction ends signed by the exchange key. Since one process ends up with every share, this
+// is only for testing, a real committee should be dealt so that each key holder only gets their
+// own share.
+func DealLocalCommittee(committeeThreshold int, numHolders int, exchangeKey *koblitz.PublicKey) (committee *threshold.CommitteeKey, holders []KeyHolder, err error) {
+	var shares []*threshold.KeyShare
+	if committee, shares, err = threshold.Deal(CommitteeCurve, committeeThreshold, numHolders); err != nil {
+		err = fmt.Errorf("Error dealing local committee: %s", err)
+		return
+	}
+
+	for _, share := range shares {
+		var holder *LocalKeyHolder
+		if holder, err = NewLocalKeyHolder(share, committee, exchangeKey); err != nil {
+			err = fmt.Errorf("Error creating local key holder: %s", err)
+			return
+		}
+		holders = append(holders, holder)
+	}
+	return
+}
+
+// CommitteePubKey gets the serialized public key of the committee orders are encrypted to, or nil
+// if the server isn't in committee mode.
+func (s *OpencxAuctionServer) CommitteePubKey() (pubKey []byte, err error) {
+	if s.committee == nil {
+		return
+	}
+	pubKey = s.committee.MarshalPubKey()
+	return
+}
@@ -0,0 +1,329 @@
+package cxauctionserver
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/match"
+)
+
+var testCommitteeScheme = match.EncryptionScheme{
+	Timelock: match.TimelockCommittee,
+	Cipher:   match.CipherAESGCM,
+}
+
+// createTestHolders deals a 2 of 3 committee and creates a local key holder for each share, with
+// the last key holder giving out bad shares. The key holders accept auction ends signed with the
+// exchange key.
+func createTestHolders(t *testing.T) (committee *threshold.CommitteeKey, holders []KeyHolder, exchangeKey *koblitz.PrivateKey) {
+	var err error
+	if exchangeKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Fatalf("Error creating exchange key: %s", err)
+	}
+
+	var shares []*threshold.KeyShare
+	if committee, shares, err = threshold.Deal(elliptic.P256(), 2, 3); err != nil {
+		t.Fatalf("Error dealing test committee: %s", err)
+	}
+
+	// same index as the last share, but someone else's secret
+	wrongShare := &threshold.KeyShare{
+		Index:  shares[2].Index,
+		Secret: shares[0].Secret,
+	}
+
+	for _, share := range []*threshold.KeyShare{shares[0], shares[1], wrongShare} {
+		var holder *LocalKeyHolder
+		if holder, err = NewLocalKeyHolder(share, committee, exchangeKey.PubKey()); err != nil {
+			t.Fatalf("Error creating local key holder: %s", err)
+		}
+		holders = append(holders, holder)
+	}
+	return
+}
+
+func TestCommitteeBatcher(t *testing.T) {
+	var err error
+
+	committee, holders, exchangeKey := createTestHolders(t)
+
+	var batcher *CommitteeBatcher
+	if batcher, err = NewCommitteeBatcher(testMaxBatchSize, committee, holders, exchangeKey); err != nil {
+		t.Errorf("Error creating committee batcher: %s", err)
+		return
+	}
+
+	var encOrder *match.EncryptedAuctionOrder
+	if encOrder, err = testAuctionOrder.TurnIntoCommitteeEncryptedOrder(testCommitteeScheme, committee); err != nil {
+		t.Errorf("Error encrypting order to committee: %s", err)
+		return
+	}
+
+	if err = batcher.AddEncrypted(encOrder); err == nil {
+		t.Errorf("Should not add order to an auction that isn't registered")
+		return
+	}
+
+	if err = batcher.RegisterAuction(encOrder.IntendedAuction); err != nil {
+		t.Errorf("Error registering auction: %s", err)
+		return
+	}
+
+	for i := 0; i < testNumOrders; i++ {
+		if err = batcher.AddEncrypted(encOrder); err != nil {
+			t.Errorf("Error adding committee order %d: %s", i, err)
+			return
+		}
+	}
+
+	// timelocked orders can't go to the committee
+	if err = batcher.AddEncrypted(testEncryptedOrder); err == nil {
+		t.Errorf("Should not add timelocked order to committee auction")
+		return
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(encOrder.IntendedAuction); err != nil {
+		t.Errorf("Error ending auction: %s", err)
+		return
+	}
+
+	if err = batcher.AddEncrypted(encOrder); err == nil {
+		t.Errorf("Should not add order to an auction that has ended")
+		return
+	}
+
+	if len(batcher.batchMap) != 0 {
+		t.Errorf("Ended auction should be removed from the batcher, have %d auctions", len(batcher.batchMap))
+		return
+	}
+
+	// the bad key holder is skipped, the other two are enough
+	select {
+	case batch := <-batchChan:
+		if len(batch.Batch) != testNumOrders {
+			t.Errorf("Expected %d orders in batch, got %d", testNumOrders, len(batch.Batch))
+			return
+		}
+		for i, result := range batch.Batch {
+			if result.Err != nil {
+				t.Errorf("Error decrypting committee order %d: %s", i, result.Err)
+				return
+			}
+
+			if !bytes.Equal(result.Auction.Serialize(), testAuctionOrder.Serialize()) {
+				t.Errorf("Decrypted order %d does not match the original order", i)
+				return
+			}
+		}
+	case <-time.After(time.Minute):
+		t.Errorf("Timed out waiting for committee to decrypt batch")
+		return
+	}
+
+	return
+}
+
+func TestCommitteeNotEnoughHolders(t *testing.T) {
+	var err error
+
+	committee, holders, exchangeKey := createTestHolders(t)
+	if _, err = NewCommitteeBatcher(testMaxBatchSize, committee, holders[:1], exchangeKey); err == nil {
+		t.Errorf("Should not create committee batcher with fewer key holders than the threshold")
+		return
+	}
+
+	// only one good holder left, so nothing can be decrypted
+	var batcher *CommitteeBatcher
+	if batcher, err = NewCommitteeBatcher(testMaxBatchSize, committee, holders[1:], exchangeKey); err != nil {
+		t.Errorf("Error creating committee batcher: %s", err)
+		return
+	}
+
+	var encOrder *match.EncryptedAuctionOrder
+	if encOrder, err = testAuctionOrder.TurnIntoCommitteeEncryptedOrder(testCommitteeScheme, committee); err != nil {
+		t.Errorf("Error encrypting order to committee: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(encOrder.IntendedAuction); err != nil {
+		t.Errorf("Error registering auction: %s", err)
+		return
+	}
+
+	if err = batcher.AddEncrypted(encOrder); err != nil {
+		t.Errorf("Error adding committee order: %s", err)
+		return
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(encOrder.IntendedAuction); err != nil {
+		t.Errorf("Error ending auction: %s", err)
+		return
+	}
+
+	batch := <-batchChan
+	if len(batch.Batch) != 1 || batch.Batch[0].Err == nil {
+		t.Errorf("Order should not decrypt with only one good key holder")
+		return
+	}
+
+	return
+}
+
+func TestValidateCommitteeOrder(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server for TestValidateCommitteeOrder: %s", err)
+		return
+	}
+
+	committee, holders, exchangeKey := createTestHolders(t)
+
+	var encOrder *match.EncryptedAuctionOrder
+	if encOrder, err = testAuctionOrder.TurnIntoCommitteeEncryptedOrder(testCommitteeScheme, committee); err != nil {
+		t.Errorf("Error encrypting order to committee: %s", err)
+		return
+	}
+
+	if err = s.validateEncryptedOrder(encOrder); err == nil {
+		t.Errorf("Committee order should be invalid when the server isn't in committee mode")
+		return
+	}
+
+	if err = s.SetAllowedSchemes([]match.EncryptionScheme{testCommitteeScheme}); err == nil {
+		t.Errorf("Should not allow committee schemes when the server isn't in committee mode")
+		return
+	}
+
+	oldBatchers := s.OrderBatchers
+	if err = s.UseCommittee(committee, holders, testMaxBatchSize, exchangeKey); err != nil {
+		t.Errorf("Error putting server in committee mode: %s", err)
+		return
+	}
+
+	for pair, oldBatcher := range oldBatchers {
+		if abatcher, ok := oldBatcher.(*ABatcher); ok && !abatcher.stopped {
+			t.Errorf("Batcher for %s should be stopped once it's replaced by a committee batcher", pair.String())
+			return
+		}
+	}
+
+	if err = s.SetAllowedSchemes([]match.EncryptionScheme{match.DefaultEncryptionScheme}); err == nil {
+		t.Errorf("Should not allow timelocked schemes in committee mode")
+		return
+	}
+
+	if err = s.SetAllowedSchemes([]match.EncryptionScheme{testCommitteeScheme}); err != nil {
+		t.Errorf("Error allowing committee scheme in committee mode: %s", err)
+		return
+	}
+
+	if err = s.validateEncryptedOrder(encOrder); err != nil {
+		t.Errorf("Committee order should be valid: %s", err)
+		return
+	}
+
+	if err = s.validateEncryptedOrder(testEncryptedOrder); err == nil {
+		t.Errorf("Timelocked order should be invalid in committee mode")
+		return
+	}
+
+	return
+}
+
+func TestKeyHolderChecksAuctionEnd(t *testing.T) {
+	var err error
+
+	committee, holders, exchangeKey := createTestHolders(t)
+	holder := holders[0]
+
+	var encOrder *match.EncryptedAuctionOrder
+	if encOrder, err = testAuctionOrder.TurnIntoCommitteeEncryptedOrder(testCommitteeScheme, committee); err != nil {
+		t.Errorf("Error encrypting order to committee: %s", err)
+		return
+	}
+
+	// only the exchange can end an auction
+	var otherKey *koblitz.PrivateKey
+	if otherKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating other key: %s", err)
+		return
+	}
+
+	var end *AuctionEnd
+	if end, err = SignAuctionEnd(otherKey, encOrder.IntendedAuction, encOrder.IntendedPair, []*match.EncryptedAuctionOrder{encOrder}); err != nil {
+		t.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	if _, err = holder.DecryptionShares(end); err == nil {
+		t.Errorf("Key holder should not give out shares for an auction end someone else signed")
+		return
+	}
+
+	// moving an order to another auction breaks its capsule, so it can't be decrypted early
+	moved := *encOrder
+	moved.IntendedAuction[0] ^= 0xff
+	if end, err = SignAuctionEnd(exchangeKey, moved.IntendedAuction, moved.IntendedPair, []*match.EncryptedAuctionOrder{&moved}); err != nil {
+		t.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	if _, err = holder.DecryptionShares(end); err == nil {
+		t.Errorf("Key holder should not give out shares for a capsule bound to another auction")
+		return
+	}
+
+	// and orders have to be for the auction that ended
+	if end, err = SignAuctionEnd(exchangeKey, moved.IntendedAuction, encOrder.IntendedPair, []*match.EncryptedAuctionOrder{encOrder}); err != nil {
+		t.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	if _, err = holder.DecryptionShares(end); err == nil {
+		t.Errorf("Key holder should not give out shares for an order from another auction")
+		return
+	}
+
+	if end, err = SignAuctionEnd(exchangeKey, encOrder.IntendedAuction, encOrder.IntendedPair, []*match.EncryptedAuctionOrder{encOrder}); err != nil {
+		t.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	var shares []*threshold.DecryptionShare
+	if shares, err = holder.DecryptionShares(end); err != nil {
+		t.Errorf("Key holder should give out shares for a good auction end: %s", err)
+		return
+	}
+
+	if len(shares) != 1 {
+		t.Errorf("Expected 1 decryption share, got %d", len(shares))
+		return
+	}
+
+	// asking again for the same auction end is fine
+	if _, err = holder.DecryptionShares(end); err != nil {
+		t.Errorf("Key holder should give out shares for the same auction end again: %s", err)
+		return
+	}
+
+	// but once the auction has ended, it can't end again with more orders
+	if end, err = SignAuctionEnd(exchangeKey, encOrder.IntendedAuction, encOrder.IntendedPair, []*match.EncryptedAuctionOrder{encOrder, encOrder}); err != nil {
+		t.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	if _, err = holder.DecryptionShares(end); err == nil {
+		t.Errorf("Key holder should not accept a second auction end with different orders")
+		return
+	}
+
+	return
+}
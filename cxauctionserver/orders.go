@@ -8,7 +8,6 @@ import (
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/crypto/rsw"
-	"github.com/mit-dci/opencx/crypto/timelockencoders"
 	"github.com/mit-dci/opencx/cxdb"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
@@ -21,14 +20,23 @@ func (s *OpencxAuctionServer) PlacePuzzledOrderAsync(order *match.EncryptedAucti
 		errChan <- err
 	}()
 	if order == nil {
-		err = fmt.Errorf("Cannot place nil order, invalid")
+		err = match.Errorf(match.ErrInvalidOrder, "Cannot place nil order, invalid")
+		return
+	}
+
+	if err = s.checkStopping(); err != nil {
 		return
 	}
 
 	logging.Infof("Got a new puzzle for auction %x", order.IntendedAuction)
 
+	if s.commitRevealBook(&order.IntendedPair) != nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Pair %s takes order commitments, not encrypted orders", order.IntendedPair.String())
+		return
+	}
+
 	if err = s.validateEncryptedOrder(order); err != nil {
-		err = fmt.Errorf("Error validating order: %s", err)
+		err = match.Errorf(match.ErrInvalidOrder, "Error validating order: %s", err)
 		return
 	}
 
@@ -39,7 +47,7 @@ func (s *OpencxAuctionServer) PlacePuzzledOrderAsync(order *match.EncryptedAucti
 	var pzEngine cxdb.PuzzleStore
 	var ok bool
 	if pzEngine, ok = s.PuzzleEngines[order.IntendedPair]; !ok {
-		err = fmt.Errorf("Could not find puzzle engine for pair %s", order.IntendedPair.String())
+		err = match.Errorf(match.ErrUnknownPair, "Could not find puzzle engine for pair %s", order.IntendedPair.String())
 		s.dbLock.Unlock()
 		return
 	}
@@ -59,7 +67,7 @@ func (s *OpencxAuctionServer) PlacePuzzledOrderAsync(order *match.EncryptedAucti
 
 	// This will add to the batcher
 	if err = correctBatcher.AddEncrypted(order); err != nil {
-		err = fmt.Errorf("Error adding encrypted order to batcher: %s", err)
+		err = match.Wrapf(err, "Error adding encrypted order to batcher: %s", err)
 		s.dbLock.Unlock()
 		return
 	}
@@ -83,16 +91,8 @@ func (s *OpencxAuctionServer) solveOrderIntoResChan(eOrder *match.EncryptedAucti
 	result := new(match.OrderPuzzleResult)
 	result.Encrypted = eOrder
 
-	var orderBytes []byte
-	if orderBytes, err = timelockencoders.SolvePuzzleRC5(eOrder.OrderCiphertext, eOrder.OrderPuzzle); err != nil {
-		result.Err = fmt.Errorf("Error solving RC5 puzzle for auction order server solve: %s", err)
-		s.orderChannel <- result
-		return
-	}
-
-	result.Auction = new(match.AuctionOrder)
-	if err = result.Auction.Deserialize(orderBytes); err != nil {
-		result.Err = fmt.Errorf("Error deserializing order from puzzle for server: %s", err)
+	if result.Auction, err = eOrder.Decrypt(); err != nil {
+		result.Err = fmt.Errorf("Error decrypting auction order for server solve: %s", err)
 		s.orderChannel <- result
 		return
 	}
@@ -103,6 +103,8 @@ func (s *OpencxAuctionServer) solveOrderIntoResChan(eOrder *match.EncryptedAucti
 }
 
 // CommitOrdersNewAuction commits to a set of encrypted orders and changes the auction ID.
+// If the auction was ended but the new one couldn't be registered, newID is still set along with
+// the error, so the new auction can be started later and stay chained to the commitment.
 // TODO: figure out how to broadcast these, and where to store them, if we need to store them
 // also TODO: REWRITE because batcher is a better way of doing things
 func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID [32]byte) (newID [32]byte, err error) {
@@ -134,18 +136,8 @@ func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID
 		return
 	}
 
-	// First, get the commitorderschannel
-	var commitOrderChannel chan *match.AuctionBatch
-	if commitOrderChannel, err = correctBatcher.EndAuction(auctionID); err != nil {
-		err = fmt.Errorf("Error ending auction while committing orders for new auction: %s", err)
-		s.dbLock.Unlock()
-		return
-	}
-
-	// Make this boi wait for the batch to come in
-	go s.asyncBatchPlacer(commitOrderChannel)
-
-	// Then get the puzzles
+	// Get the puzzles and commit to them before ending the auction, so if anything here fails
+	// the auction is left untouched and the tick can be retried.
 	var puzzles []*match.EncryptedAuctionOrder
 	if puzzles, err = pzEngine.ViewAuctionPuzzleBook(matchAuctionID); err != nil {
 		s.dbLock.Unlock()
@@ -156,11 +148,11 @@ func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID
 	sha3 := sha3.New256()
 	// Add the current auction ID to be hashed
 	sha3.Write(auctionID[:])
-	// Then find the hash of the orders + the previous hash
+	// Then find the hash of the orders + the previous hash. Orders serialize to the canonical
+	// encoding, which is self delimiting, so anyone can recompute this.
 	for _, pz := range puzzles {
 		var pzRaw []byte
 		if pzRaw, err = pz.Serialize(); err != nil {
-			s.dbLock.Unlock()
 			err = fmt.Errorf("Error serializing puzzle for commitment: %s", err)
 			s.dbLock.Unlock()
 			return
@@ -168,6 +160,15 @@ func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID
 		sha3.Write(pzRaw)
 	}
 
+	// Pairs with commit-reveal commit to the order commitments instead, and stop taking them
+	var book *commitRevealBook
+	if book = s.commitRevealBook(pair); book != nil {
+		for _, commitment := range book.endCommitments(auctionID) {
+			sha3.Write(commitment.Pubkey[:])
+			sha3.Write(commitment.Commitment[:])
+		}
+	}
+
 	// Set the new auction ID to the hash of the orders. TODO: figure out if
 	// dependence on the previous commitment is a good idea.
 	var newAuctionID [32]byte
@@ -175,10 +176,28 @@ func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID
 	// TODO: sign
 	// TODO: how to broadcast and timestamp these?
 
+	// Now get the commitorderschannel
+	var commitOrderChannel chan *match.AuctionBatch
+	if commitOrderChannel, err = correctBatcher.EndAuction(auctionID); err != nil {
+		err = fmt.Errorf("Error ending auction while committing orders for new auction: %s", err)
+		s.dbLock.Unlock()
+		return
+	}
+
+	// Make this boi wait for the batch to come in
+	s.placerWG.Add(1)
+	go s.asyncBatchPlacer(commitOrderChannel)
+
+	if book != nil {
+		s.revealWG.Add(1)
+		go s.closeRevealWindow(*pair, auctionID, book)
+	}
+
 	// Start the new auction by registering
 	if err = correctBatcher.RegisterAuction(newAuctionID); err != nil {
 		err = fmt.Errorf("Error registering auction while committing / creating new auction: %s", err)
 		s.dbLock.Unlock()
+		newID = newAuctionID
 		return
 	}
 
@@ -196,10 +215,12 @@ func (s *OpencxAuctionServer) CommitOrdersNewAuction(pair *match.Pair, auctionID
 	return
 }
 
-// asyncBatchPlacer waits for a batch and places it. This should be done in a goroutine
+// asyncBatchPlacer waits for a batch and places it. This should be done in a goroutine, after
+// adding it to placerWG.
 func (s *OpencxAuctionServer) asyncBatchPlacer(batchChan chan *match.AuctionBatch) {
 	var err error
 
+	defer s.placerWG.Done()
 	defer func() {
 		if err != nil {
 			logging.Errorf("Error placing order asynchronously: %s", err)
@@ -210,11 +231,20 @@ func (s *OpencxAuctionServer) asyncBatchPlacer(batchChan chan *match.AuctionBatc
 	batch := <-batchChan
 	batchChan <- batch
 
+	// The orders stay in the puzzle store, but without a batch we don't know what they are
+	if batch.Err != nil {
+		err = fmt.Errorf("Error getting batch for auction %x, its orders were not placed: %s", batch.AuctionID, batch.Err)
+		return
+	}
+
 	s.dbLock.Lock()
 
 	var batchRes *match.BatchResult
 	batchRes = s.validateBatch(batch)
 
+	// Only orders that were accepted can be audited
+	s.publishBatchSolutions(batch.AuctionID, batchRes.AcceptedResults)
+
 	logging.Infof("Got a batch result for %x! \n\tValid orders: %d\n\tInvalid orders: %d", batchRes.OriginalBatch, len(batchRes.AcceptedResults), len(batchRes.RejectedResults))
 
 	for _, acceptedOrder := range batchRes.AcceptedResults {
@@ -236,6 +266,10 @@ func (s *OpencxAuctionServer) asyncBatchPlacer(batchChan chan *match.AuctionBatc
 }
 
 func (s *OpencxAuctionServer) PlaceBatch(batch *match.AuctionBatch) (err error) {
+	if batch.Err != nil {
+		err = fmt.Errorf("Error getting batch for auction %x, not placing it: %s", batch.AuctionID, batch.Err)
+		return
+	}
 
 	s.dbLock.Lock()
 
@@ -244,6 +278,9 @@ func (s *OpencxAuctionServer) PlaceBatch(batch *match.AuctionBatch) (err error)
 
 	var batchRes *match.BatchResult = s.validateBatch(batch)
 
+	// Only orders that were accepted can be audited
+	s.publishBatchSolutions(batch.AuctionID, batchRes.AcceptedResults)
+
 	logging.Infof("Got a batch result for %x! \n\tValid orders: %d\n\tInvalid orders: %d", batchRes.OriginalBatch, len(batchRes.AcceptedResults), len(batchRes.RejectedResults))
 
 	var auctionIDList map[match.AuctionID]bool = make(map[match.AuctionID]bool)
@@ -296,18 +333,57 @@ func (s *OpencxAuctionServer) PlaceBatch(batch *match.AuctionBatch) (err error)
 	return
 }
 
-// validateOrder is how the server checks that an order is valid, and checks out with its corresponding encrypted order
+// validateEncryptedOrder checks that an encrypted order can't be decrypted before the auction ends,
+// before we store it. The cipher and puzzle have to be ones we support, and the puzzle has to use
+// the parameters we publish.
 func (s *OpencxAuctionServer) validateEncryptedOrder(order *match.EncryptedAuctionOrder) (err error) {
 
-	var rswPuzzle *rsw.PuzzleRSW
-	var ok bool
-	if rswPuzzle, ok = order.OrderPuzzle.(*rsw.PuzzleRSW); !ok {
-		err = fmt.Errorf("Puzzle could not be converted to RSW puzzle, invalid encrypted order")
+	var scheme match.EncryptionScheme
+	if scheme, err = order.GetScheme(); err != nil {
+		err = fmt.Errorf("Error getting scheme, invalid encrypted order: %s", err)
+		return
+	}
+
+	if !s.schemeAllowed(scheme) {
+		err = fmt.Errorf("Scheme %s is not allowed, invalid encrypted order", scheme.String())
+		return
+	}
+
+	if err = scheme.CheckCiphertext(order.OrderCiphertext); err != nil {
+		err = fmt.Errorf("Ciphertext is not valid for scheme %s, invalid encrypted order: %s", scheme.String(), err)
 		return
 	}
 
-	if uint64(rswPuzzle.T.Int64()) != s.t {
-		err = fmt.Errorf("The time to solve the puzzle is not correct, invalid encrypted order")
+	if err = scheme.CheckPuzzleType(order.OrderPuzzle); err != nil {
+		err = fmt.Errorf("Puzzle is not valid for scheme %s, invalid encrypted order: %s", scheme.String(), err)
+		return
+	}
+
+	if scheme.Timelock == match.TimelockCommittee {
+		if s.committee == nil {
+			err = fmt.Errorf("Server has no committee, invalid encrypted order")
+			return
+		}
+
+		if _, err = order.CommitteeCapsule(s.committee); err != nil {
+			err = fmt.Errorf("Bad committee capsule, invalid encrypted order: %s", err)
+			return
+		}
+		return
+	}
+
+	// Every other timelock is RSW, so CheckPuzzleType makes sure this is fine
+	rswPuzzle := order.OrderPuzzle.(*rsw.PuzzleRSW)
+
+	if err = rswPuzzle.CheckWellFormed(s.minModulusBits); err != nil {
+		err = fmt.Errorf("Puzzle is not well formed, invalid encrypted order: %s", err)
+		return
+	}
+
+	// T has to be exactly the time parameter we publish, any less and the order could be
+	// decrypted before the auction ends
+	if !rswPuzzle.T.IsUint64() || rswPuzzle.T.Uint64() != s.t {
+		err = fmt.Errorf("The time to solve the puzzle is %s, must be exactly %d, invalid encrypted order", rswPuzzle.T.String(), s.t)
 		return
 	}
 
@@ -332,10 +408,10 @@ func (s *OpencxAuctionServer) validateBatch(auctionBatch *match.AuctionBatch) (b
 		}
 		// TODO: this is to protect the database, this is why switching to a better price system would be a good idea
 		if pr > float64(10000000000000000000000) {
-			orderPzRes.Err = fmt.Errorf("Price too high, complain online if you want the maximum price increased, or lower your price")
+			orderPzRes.Err = match.Errorf(match.ErrPriceOutOfBounds, "Price too high, complain online if you want the maximum price increased, or lower your price")
 		}
 		if pr < float64(1)/float64(1000000) {
-			orderPzRes.Err = fmt.Errorf("Price too low, complain online if you want the minimum price decreased, or increase your price")
+			orderPzRes.Err = match.Errorf(match.ErrPriceOutOfBounds, "Price too low, complain online if you want the minimum price decreased, or increase your price")
 		}
 		if err = s.validateOrderResult(auctionBatch.AuctionID, orderPzRes); err != nil {
 			orderPzRes.Err = fmt.Errorf("Order invalid: %s", err)
@@ -372,30 +448,42 @@ func (s *OpencxAuctionServer) validateOrderResult(claimedAuction [32]byte, resul
 		return
 	}
 
-	if _, err = result.Auction.Price(); err != nil {
+	if !bytes.Equal(result.Encrypted.IntendedAuction[:], result.Auction.AuctionID[:]) {
+		err = fmt.Errorf("Auction ID for decrypted and encrypted order must be equal")
+		return
+	}
+
+	err = s.validateAuctionOrder(claimedAuction, result.Auction)
+	return
+}
+
+// validateAuctionOrder checks that a decrypted or revealed order is signed, has a price and a side,
+// and is for the claimed auction.
+func (s *OpencxAuctionServer) validateAuctionOrder(claimedAuction [32]byte, order *match.AuctionOrder) (err error) {
+	if _, err = order.Price(); err != nil {
 		err = fmt.Errorf("Orders with an indeterminable price are invalid: %s", err)
 		return
 	}
 
-	if !result.Auction.IsBuySide() && !result.Auction.IsSellSide() {
+	if !order.IsBuySide() && !order.IsSellSide() {
 		err = fmt.Errorf("Orders that aren't buy or sell side are invalid: %s", err)
 		return
 	}
 
 	// We could use pub key hashes here but there might not be any reason for it
 	var orderPublicKey *koblitz.PublicKey
-	if orderPublicKey, err = koblitz.ParsePubKey(result.Auction.Pubkey[:], koblitz.S256()); err != nil {
+	if orderPublicKey, err = koblitz.ParsePubKey(order.Pubkey[:], koblitz.S256()); err != nil {
 		err = fmt.Errorf("Orders with a public key that cannot be parsed are invalid: %s", err)
 		return
 	}
 
 	// e = h(asset)
 	sha3 := sha3.New256()
-	sha3.Write(result.Auction.SerializeSignable())
+	sha3.Write(order.SerializeSignable())
 	e := sha3.Sum(nil)
 
 	var recoveredPublickey *koblitz.PublicKey
-	if recoveredPublickey, _, err = koblitz.RecoverCompact(koblitz.S256(), result.Auction.Signature, e); err != nil {
+	if recoveredPublickey, _, err = koblitz.RecoverCompact(koblitz.S256(), order.Signature, e); err != nil {
 		err = fmt.Errorf("Orders whose signature cannot be verified with pubkey recovery are invalid: %s", err)
 		return
 	}
@@ -405,12 +493,7 @@ func (s *OpencxAuctionServer) validateOrderResult(claimedAuction [32]byte, resul
 		return
 	}
 
-	if !bytes.Equal(result.Encrypted.IntendedAuction[:], result.Auction.AuctionID[:]) {
-		err = fmt.Errorf("Auction ID for decrypted and encrypted order must be equal")
-		return
-	}
-
-	if !bytes.Equal(claimedAuction[:], result.Auction.AuctionID[:]) {
+	if !bytes.Equal(claimedAuction[:], order.AuctionID[:]) {
 		err = fmt.Errorf("Auction ID must equal current auction")
 		return
 	}
@@ -7,6 +7,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mit-dci/opencx/crypto"
+	"github.com/mit-dci/opencx/crypto/rsw"
 	"github.com/mit-dci/opencx/match"
 )
 
@@ -146,6 +148,104 @@ func TestPlaceNilOrderUltraLight(t *testing.T) {
 	return
 }
 
+func TestValidateEncryptedOrder(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server for TestValidateEncryptedOrder: %s", err)
+		return
+	}
+
+	if err = s.validateEncryptedOrder(testEncryptedOrder); err != nil {
+		t.Errorf("Test encrypted order should be valid: %s", err)
+		return
+	}
+
+	// wrong time parameter
+	var wrongTime *match.EncryptedAuctionOrder
+	if wrongTime, err = testAuctionOrder.TurnIntoEncryptedOrder(testStandardAuctionTime - 1); err != nil {
+		t.Errorf("Error creating encrypted order with wrong time: %s", err)
+		return
+	}
+	if err = s.validateEncryptedOrder(wrongTime); err == nil {
+		t.Errorf("Encrypted order with the wrong time parameter should be invalid")
+		return
+	}
+
+	// tiny modulus
+	var tinyTimelock crypto.Timelock
+	if tinyTimelock, err = rsw.New(make([]byte, 16), 2, 512); err != nil {
+		t.Errorf("Error creating timelock with tiny modulus: %s", err)
+		return
+	}
+	tinyModulus := *testEncryptedOrder
+	if tinyModulus.OrderPuzzle, _, err = tinyTimelock.SetupTimelockPuzzle(testStandardAuctionTime); err != nil {
+		t.Errorf("Error creating puzzle with tiny modulus: %s", err)
+		return
+	}
+	if err = s.validateEncryptedOrder(&tinyModulus); err == nil {
+		t.Errorf("Encrypted order with a 512 bit modulus should be invalid")
+		return
+	}
+
+	// ciphertext too short to be RC5
+	shortCiphertext := *testEncryptedOrder
+	shortCiphertext.OrderCiphertext = []byte{0x00}
+	if err = s.validateEncryptedOrder(&shortCiphertext); err == nil {
+		t.Errorf("Encrypted order with a 1 byte ciphertext should be invalid")
+		return
+	}
+
+	return
+}
+
+func TestValidateAllowedSchemes(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server for TestValidateAllowedSchemes: %s", err)
+		return
+	}
+
+	aesScheme := match.EncryptionScheme{
+		Timelock: match.TimelockRSW2048A2,
+		Cipher:   match.CipherAES,
+	}
+
+	var aesOrder *match.EncryptedAuctionOrder
+	if aesOrder, err = testAuctionOrder.TurnIntoEncryptedOrderWithScheme(testStandardAuctionTime, aesScheme); err != nil {
+		t.Errorf("Error creating aes encrypted order: %s", err)
+		return
+	}
+
+	if err = s.validateEncryptedOrder(aesOrder); err == nil {
+		t.Errorf("Order with a scheme that isn't allowed should be invalid")
+		return
+	}
+
+	if err = s.SetAllowedSchemes([]match.EncryptionScheme{match.DefaultEncryptionScheme, aesScheme}); err != nil {
+		t.Errorf("Error setting allowed schemes: %s", err)
+		return
+	}
+
+	if err = s.validateEncryptedOrder(aesOrder); err != nil {
+		t.Errorf("Order with an allowed scheme should be valid: %s", err)
+		return
+	}
+
+	// rc6 still isn't allowed, even though the ciphertext would look fine for it
+	rc6Order := *aesOrder
+	rc6Order.Scheme = match.EncryptionScheme{Timelock: match.TimelockRSW2048A2, Cipher: match.CipherRC6}
+	if err = s.validateEncryptedOrder(&rc6Order); err == nil {
+		t.Errorf("Order with a scheme that isn't allowed should be invalid")
+		return
+	}
+
+	return
+}
+
 func BenchmarkAllThingsAutomated(b *testing.B) {
 
 	for _, n := range []uint64{10, 100} {
@@ -0,0 +1,133 @@
+package cxauctionserver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BatchProofRetention is how long the solutions for a batch are kept after it's placed, so users
+// can ask for proofs that it was decrypted honestly. After this the proofs can't be made.
+const BatchProofRetention = 24 * time.Hour
+
+// BatchProof is an encrypted order from a batch along with the proof that its puzzle was solved
+// correctly, so anyone can check that the order was decrypted honestly without solving the puzzle.
+type BatchProof struct {
+	Encrypted *match.EncryptedAuctionOrder
+	Proof     *rsw.VDFProof
+}
+
+// batchSolutions are the solved puzzles for the accepted orders in a batch. Making a proof takes
+// about as long as solving the puzzle, so proofs are only made once someone asks for them.
+type batchSolutions struct {
+	results   []*match.OrderPuzzleResult
+	published time.Time
+	// proving is closed once proofs and err are set, and is nil until someone asks for proofs
+	proving chan struct{}
+	proofs  []*BatchProof
+	err     error
+}
+
+// publishBatchSolutions stores the solutions for the accepted orders of a batch, so proofs can
+// be made for users who want to audit the batch. Solutions older than BatchProofRetention are
+// dropped.
+func (s *OpencxAuctionServer) publishBatchSolutions(auctionID [32]byte, accepted []*match.OrderPuzzleResult) {
+	var results []*match.OrderPuzzleResult
+	for _, result := range accepted {
+		if result.Encrypted == nil || result.Solution == nil {
+			continue
+		}
+		results = append(results, result)
+	}
+
+	now := time.Now()
+	s.batchProofMtx.Lock()
+	for id, solutions := range s.batchProofs {
+		if now.Sub(solutions.published) > BatchProofRetention {
+			delete(s.batchProofs, id)
+		}
+	}
+	s.batchProofs[auctionID] = &batchSolutions{
+		results:   results,
+		published: now,
+	}
+	s.batchProofMtx.Unlock()
+
+	logging.Infof("Published %d solutions to prove for auction %x", len(results), auctionID)
+	return
+}
+
+// GetBatchProofs returns the decryption proofs for the accepted orders in the batch with the
+// auction ID. The first call for a batch makes the proofs, which takes about as long as solving
+// its puzzles did, and later calls get the same proofs.
+func (s *OpencxAuctionServer) GetBatchProofs(auctionID [32]byte) (proofs []*BatchProof, err error) {
+	s.batchProofMtx.Lock()
+	var solutions *batchSolutions
+	var ok bool
+	if solutions, ok = s.batchProofs[auctionID]; !ok {
+		err = fmt.Errorf("No proofs for auction %x, it may not have ended yet, or it ended more than %s ago", auctionID, BatchProofRetention)
+		s.batchProofMtx.Unlock()
+		return
+	}
+	if solutions.proving == nil {
+		solutions.proving = make(chan struct{})
+		go s.proveBatch(auctionID, solutions)
+	}
+	proving := solutions.proving
+	s.batchProofMtx.Unlock()
+
+	<-proving
+
+	s.batchProofMtx.Lock()
+	proofs = solutions.proofs
+	err = solutions.err
+	s.batchProofMtx.Unlock()
+	return
+}
+
+// proveBatch makes the proofs for a batch's solutions. Only one batch is proven at a time, so
+// audits can't take more than a core away from solving puzzles. This should be run in a goroutine.
+func (s *OpencxAuctionServer) proveBatch(auctionID [32]byte, solutions *batchSolutions) {
+	var proofs []*BatchProof
+	var err error
+
+	s.proverMtx.Lock()
+	// orders with the same N, A and T have the same solution, so they share a proof
+	sharedProofs := make(map[string]*rsw.VDFProof)
+	for _, result := range solutions.results {
+		// only RSW puzzles have solutions
+		puzzle := result.Encrypted.OrderPuzzle.(*rsw.PuzzleRSW)
+		key := puzzle.N.Text(16) + ":" + puzzle.A.Text(16) + ":" + puzzle.T.Text(16)
+
+		proof, ok := sharedProofs[key]
+		if !ok {
+			if proof, err = puzzle.ProveContext(context.Background(), result.Solution); err != nil {
+				err = fmt.Errorf("Error making proof for auction %x: %s", auctionID, err)
+				break
+			}
+			sharedProofs[key] = proof
+		}
+
+		proofs = append(proofs, &BatchProof{
+			Encrypted: result.Encrypted,
+			Proof:     proof,
+		})
+	}
+	s.proverMtx.Unlock()
+
+	if err != nil {
+		logging.Errorf("%s", err)
+		proofs = nil
+	}
+
+	s.batchProofMtx.Lock()
+	solutions.proofs = proofs
+	solutions.err = err
+	close(solutions.proving)
+	s.batchProofMtx.Unlock()
+	return
+}
@@ -0,0 +1,86 @@
+package cxauctionserver
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestGetBatchProofs(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server: %s", err)
+		return
+	}
+
+	var y *big.Int
+	if y, err = testEncryptedOrder.OrderPuzzle.(*rsw.PuzzleRSW).SquareContext(context.Background()); err != nil {
+		t.Errorf("Error solving test puzzle: %s", err)
+		return
+	}
+
+	auctionID := testEncryptedOrder.IntendedAuction
+	if _, err = s.GetBatchProofs(auctionID); err == nil {
+		t.Errorf("Should not get proofs for a batch that wasn't published")
+		return
+	}
+
+	// a result without a solution can't be proven, so it's left out
+	accepted := []*match.OrderPuzzleResult{
+		{Encrypted: testEncryptedOrder, Solution: y},
+		{Encrypted: testEncryptedOrder, Solution: y},
+		{Encrypted: testEncryptedOrder},
+	}
+	s.publishBatchSolutions(auctionID, accepted)
+
+	var proofs []*BatchProof
+	if proofs, err = s.GetBatchProofs(auctionID); err != nil {
+		t.Errorf("Error getting batch proofs: %s", err)
+		return
+	}
+	if len(proofs) != 2 {
+		t.Errorf("Expected 2 proofs, got %d", len(proofs))
+		return
+	}
+	for i, proof := range proofs {
+		if _, err = proof.Encrypted.DecryptFromProof(proof.Proof); err != nil {
+			t.Errorf("Proof %d does not decrypt the order: %s", i, err)
+			return
+		}
+	}
+
+	return
+}
+
+func TestBatchSolutionsPruned(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server: %s", err)
+		return
+	}
+
+	oldID := [32]byte{0x01}
+	newID := [32]byte{0x02}
+	s.publishBatchSolutions(oldID, nil)
+	s.batchProofs[oldID].published = time.Now().Add(-BatchProofRetention - time.Minute)
+
+	s.publishBatchSolutions(newID, nil)
+	if _, ok := s.batchProofs[oldID]; ok {
+		t.Errorf("Solutions older than the retention window should be dropped")
+		return
+	}
+	if _, ok := s.batchProofs[newID]; !ok {
+		t.Errorf("New solutions should be kept")
+		return
+	}
+
+	return
+}
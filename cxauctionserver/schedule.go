@@ -0,0 +1,225 @@
+package cxauctionserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// RecoveryPolicy determines what the auction clock does when committing to an auction fails.
+type RecoveryPolicy uint8
+
+const (
+	// RecoverySkip keeps the current auction open and tries to commit again at the next scheduled tick.
+	RecoverySkip RecoveryPolicy = iota
+	// RecoveryRetry retries the tick up to MaxRetries times, waiting RetryDelay between attempts,
+	// and then falls back to skipping the tick.
+	RecoveryRetry
+)
+
+// String returns the name of the recovery policy
+func (rp RecoveryPolicy) String() string {
+	switch rp {
+	case RecoverySkip:
+		return "skip"
+	case RecoveryRetry:
+		return "retry"
+	}
+	return "unknown"
+}
+
+// RecoveryPolicyFromString parses a recovery policy from its name, "skip" or "retry"
+func RecoveryPolicyFromString(str string) (rp RecoveryPolicy, err error) {
+	switch str {
+	case "skip":
+		rp = RecoverySkip
+	case "retry":
+		rp = RecoveryRetry
+	default:
+		err = fmt.Errorf("Unknown recovery policy %s, must be skip or retry", str)
+	}
+	return
+}
+
+const (
+	// DefaultMaxRetries is how many times a failed tick is retried with RecoveryRetry if not set
+	DefaultMaxRetries = uint64(3)
+	// DefaultRetryDelay is how long we wait between retries with RecoveryRetry if not set
+	DefaultRetryDelay = time.Second
+)
+
+// AuctionSchedule is the schedule that the auction clock follows for a single pair.
+type AuctionSchedule struct {
+	// Duration is how long each auction is open for before the exchange commits to it.
+	Duration time.Duration
+	// Aligned makes auctions start at multiples of Duration since the unix epoch, so
+	// anyone can tell when the next auction will start just by looking at a clock.
+	Aligned bool
+	// Paused stops the clock from committing to auctions. The current auction stays
+	// open until the schedule is resumed.
+	Paused bool
+	// Recovery is what the clock does when a tick fails.
+	Recovery RecoveryPolicy
+	// MaxRetries is the number of times a failed tick is retried with RecoveryRetry.
+	MaxRetries uint64
+	// RetryDelay is how long we wait between retries with RecoveryRetry.
+	RetryDelay time.Duration
+}
+
+// DefaultAuctionSchedule returns the schedule the clock used before schedules were configurable:
+// an unaligned auction every t microseconds, skipping failed ticks.
+func DefaultAuctionSchedule(t uint64) (schedule *AuctionSchedule) {
+	schedule = &AuctionSchedule{
+		Duration:   time.Duration(t) * time.Microsecond,
+		Aligned:    false,
+		Paused:     false,
+		Recovery:   RecoverySkip,
+		MaxRetries: DefaultMaxRetries,
+		RetryDelay: DefaultRetryDelay,
+	}
+	return
+}
+
+// NextTick returns the time that the auction clock should next commit to an auction, given the
+// current time.
+func (as *AuctionSchedule) NextTick(now time.Time) (next time.Time) {
+	if !as.Aligned {
+		next = now.Add(as.Duration)
+		return
+	}
+	// Round down to the start of the current slot, then the next slot is the tick
+	next = now.Truncate(as.Duration).Add(as.Duration)
+	return
+}
+
+// validate makes sure a schedule can actually be run by the clock
+func (as *AuctionSchedule) validate() (err error) {
+	if as.Duration <= 0 {
+		err = fmt.Errorf("Auction duration must be positive, got %s", as.Duration)
+		return
+	}
+	if as.Recovery != RecoverySkip && as.Recovery != RecoveryRetry {
+		err = fmt.Errorf("Unknown recovery policy %d", as.Recovery)
+		return
+	}
+	if as.Recovery == RecoveryRetry && as.RetryDelay < 0 {
+		err = fmt.Errorf("Retry delay cannot be negative, got %s", as.RetryDelay)
+		return
+	}
+	return
+}
+
+// SetAuctionSchedule sets the schedule for a pair. The clock for that pair picks up the new
+// schedule right away, so the next tick will follow the new schedule.
+func (s *OpencxAuctionServer) SetAuctionSchedule(pair *match.Pair, schedule *AuctionSchedule) (err error) {
+	if schedule == nil {
+		err = fmt.Errorf("Cannot set nil schedule for pair %s", pair.String())
+		return
+	}
+	if err = schedule.validate(); err != nil {
+		err = fmt.Errorf("Invalid schedule for SetAuctionSchedule: %s", err)
+		return
+	}
+
+	s.dbLock.Lock()
+	if _, ok := s.OrderBatchers[*pair]; !ok {
+		err = fmt.Errorf("Cannot set schedule for pair %s, no batcher for pair", pair.String())
+		s.dbLock.Unlock()
+		return
+	}
+	s.dbLock.Unlock()
+
+	s.scheduleMtx.Lock()
+	newSchedule := new(AuctionSchedule)
+	*newSchedule = *schedule
+	s.schedules[*pair] = newSchedule
+	s.scheduleMtx.Unlock()
+
+	s.notifyScheduleUpdate(pair)
+	return
+}
+
+// GetAuctionSchedule returns a copy of the schedule for a pair.
+func (s *OpencxAuctionServer) GetAuctionSchedule(pair *match.Pair) (schedule *AuctionSchedule, err error) {
+	s.scheduleMtx.Lock()
+	var currSchedule *AuctionSchedule
+	var ok bool
+	if currSchedule, ok = s.schedules[*pair]; !ok {
+		err = fmt.Errorf("No schedule for pair %s", pair.String())
+		s.scheduleMtx.Unlock()
+		return
+	}
+	schedule = new(AuctionSchedule)
+	*schedule = *currSchedule
+	s.scheduleMtx.Unlock()
+	return
+}
+
+// PauseAuctions pauses the auction clock for a pair. Orders can still be placed into the current
+// auction, it just won't be committed to until ResumeAuctions is called.
+func (s *OpencxAuctionServer) PauseAuctions(pair *match.Pair) (err error) {
+	if err = s.setPaused(pair, true); err != nil {
+		err = fmt.Errorf("Error pausing auctions for pair: %s", err)
+		return
+	}
+	return
+}
+
+// ResumeAuctions resumes the auction clock for a pair. The next tick is scheduled starting from
+// the time the clock is resumed.
+func (s *OpencxAuctionServer) ResumeAuctions(pair *match.Pair) (err error) {
+	if err = s.setPaused(pair, false); err != nil {
+		err = fmt.Errorf("Error resuming auctions for pair: %s", err)
+		return
+	}
+	return
+}
+
+// setPaused sets the paused field of a schedule and lets the clock know
+func (s *OpencxAuctionServer) setPaused(pair *match.Pair, paused bool) (err error) {
+	s.scheduleMtx.Lock()
+	var currSchedule *AuctionSchedule
+	var ok bool
+	if currSchedule, ok = s.schedules[*pair]; !ok {
+		err = fmt.Errorf("No schedule for pair %s", pair.String())
+		s.scheduleMtx.Unlock()
+		return
+	}
+	currSchedule.Paused = paused
+	s.scheduleMtx.Unlock()
+
+	s.notifyScheduleUpdate(pair)
+	return
+}
+
+// scheduleUpdates returns the channel that is notified whenever the schedule for a pair changes
+func (s *OpencxAuctionServer) scheduleUpdates(pair *match.Pair) (updateChan chan bool) {
+	s.scheduleMtx.Lock()
+	var ok bool
+	if updateChan, ok = s.scheduleUpdateChans[*pair]; !ok {
+		updateChan = make(chan bool, 1)
+		s.scheduleUpdateChans[*pair] = updateChan
+	}
+	s.scheduleMtx.Unlock()
+	return
+}
+
+// notifyScheduleUpdate wakes up the clock for a pair without blocking. If there's already an
+// update waiting then the clock will see the newest schedule anyways.
+func (s *OpencxAuctionServer) notifyScheduleUpdate(pair *match.Pair) {
+	select {
+	case s.scheduleUpdates(pair) <- true:
+	default:
+	}
+	return
+}
+
+// createScheduleMap creates a default schedule for every pair with a batcher
+func createScheduleMap(batchers map[match.Pair]match.AuctionBatcher, t uint64) (schedules map[match.Pair]*AuctionSchedule) {
+	schedules = make(map[match.Pair]*AuctionSchedule)
+	for pair := range batchers {
+		schedules[pair] = DefaultAuctionSchedule(t)
+	}
+	return
+}
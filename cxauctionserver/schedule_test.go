@@ -0,0 +1,209 @@
+package cxauctionserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
+)
+
+// signAdminRequest gets a nonce from the server and signs a request for an admin command with it
+func signAdminRequest(t *testing.T, s *OpencxAuctionServer, privkey *koblitz.PrivateKey, method string, argsHash [32]byte) (req *cxauth.SignedRequest) {
+	var owner [33]byte
+	copy(owner[:], privkey.PubKey().SerializeCompressed())
+	nonce, expiry, err := s.IssueNonce(owner)
+	if err != nil {
+		t.Fatalf("Error issuing nonce: %s", err)
+	}
+	if req, err = cxauth.SignRequest(privkey, method, argsHash, nonce, expiry); err != nil {
+		t.Fatalf("Error signing admin request: %s", err)
+	}
+	return
+}
+
+func TestNextTickUnaligned(t *testing.T) {
+	schedule := &AuctionSchedule{
+		Duration: 30 * time.Second,
+		Aligned:  false,
+	}
+
+	now := time.Unix(1000000007, 0)
+	if next := schedule.NextTick(now); !next.Equal(now.Add(30 * time.Second)) {
+		t.Errorf("Unaligned next tick should be now + duration, got %s", next)
+	}
+
+	return
+}
+
+func TestNextTickAligned(t *testing.T) {
+	schedule := &AuctionSchedule{
+		Duration: time.Minute,
+		Aligned:  true,
+	}
+
+	now := time.Unix(1000000007, 0)
+	next := schedule.NextTick(now)
+	if next.Unix()%60 != 0 {
+		t.Errorf("Aligned next tick should be at a multiple of the duration, got %s", next)
+	}
+	if !next.After(now) || next.Sub(now) > time.Minute {
+		t.Errorf("Aligned next tick should be within one duration after now, got %s", next)
+	}
+
+	return
+}
+
+func TestSetInvalidSchedule(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server for TestSetInvalidSchedule: %s", err)
+		return
+	}
+
+	pair := testEncryptedOrder.IntendedPair
+	if err = s.SetAuctionSchedule(&pair, &AuctionSchedule{Duration: 0}); err == nil {
+		t.Errorf("Setting a schedule with a zero duration succeeded, it should fail")
+	}
+
+	return
+}
+
+func TestPauseResumeAuctions(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server for TestPauseResumeAuctions: %s", err)
+		return
+	}
+
+	pair := testEncryptedOrder.IntendedPair
+	if err = s.PauseAuctions(&pair); err != nil {
+		t.Errorf("Error pausing auctions: %s", err)
+		return
+	}
+
+	var schedule *AuctionSchedule
+	if schedule, err = s.GetAuctionSchedule(&pair); err != nil {
+		t.Errorf("Error getting schedule after pausing: %s", err)
+		return
+	}
+	if !schedule.Paused {
+		t.Errorf("Schedule should be paused after pausing auctions")
+		return
+	}
+
+	if err = s.ResumeAuctions(&pair); err != nil {
+		t.Errorf("Error resuming auctions: %s", err)
+		return
+	}
+
+	if schedule, err = s.GetAuctionSchedule(&pair); err != nil {
+		t.Errorf("Error getting schedule after resuming: %s", err)
+		return
+	}
+	if schedule.Paused {
+		t.Errorf("Schedule should not be paused after resuming auctions")
+		return
+	}
+
+	return
+}
+
+func TestAdminCommandVerify(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server for TestAdminCommandVerify: %s", err)
+		return
+	}
+
+	var adminKey *koblitz.PrivateKey
+	if adminKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating admin key: %s", err)
+		return
+	}
+	var otherKey *koblitz.PrivateKey
+	if otherKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating other key: %s", err)
+		return
+	}
+
+	if err = s.SetAdminPubkey(adminKey.PubKey()); err != nil {
+		t.Errorf("Error setting admin pubkey: %s", err)
+		return
+	}
+
+	pair := testEncryptedOrder.IntendedPair
+	argsHash := cxauth.HashArgs(pair.Serialize())
+
+	adminReq := signAdminRequest(t, s, adminKey, "OpencxAuctionRPC.PauseAuctions", argsHash)
+	otherReq := signAdminRequest(t, s, otherKey, "OpencxAuctionRPC.PauseAuctions", argsHash)
+	secondPauseReq := signAdminRequest(t, s, adminKey, "OpencxAuctionRPC.PauseAuctions", argsHash)
+
+	if err = s.AdminCommandVerify("OpencxAuctionRPC.ResumeAuctions", argsHash, secondPauseReq); err == nil {
+		t.Errorf("Admin request for a different command should not verify")
+	}
+	if err = s.AdminCommandVerify("OpencxAuctionRPC.PauseAuctions", argsHash, otherReq); err == nil {
+		t.Errorf("Request by a key that isn't the admin key should not verify")
+	}
+	if err = s.AdminCommandVerify("OpencxAuctionRPC.PauseAuctions", argsHash, adminReq); err != nil {
+		t.Errorf("Admin request should verify: %s", err)
+	}
+	if err = s.AdminCommandVerify("OpencxAuctionRPC.PauseAuctions", argsHash, adminReq); err == nil {
+		t.Errorf("Admin request should not verify twice")
+	}
+
+	return
+}
+
+func TestAuctionTickStartsChainedAuction(t *testing.T) {
+	var err error
+
+	var s *OpencxAuctionServer
+	if s, err = initTestServer(); err != nil {
+		t.Errorf("Error initializing test server for TestAuctionTickStartsChainedAuction: %s", err)
+		return
+	}
+
+	pair := testEncryptedOrder.IntendedPair
+	var endedID [32]byte
+	endedID[0] = 0x02
+
+	// An earlier tick ended the last auction but couldn't start this one, so the tick starts it
+	var tickRes timeID
+	if tickRes, err = s.auctionTick(pair, endedID); err != nil {
+		t.Errorf("Tick should start the auction that isn't active yet, got error: %s", err)
+		return
+	}
+	if tickRes.id != endedID {
+		t.Errorf("Tick should stay on auction %x after starting it, is on %x", endedID, tickRes.id)
+		return
+	}
+
+	var active bool
+	if active, err = s.auctionActive(&pair, endedID); err != nil || !active {
+		t.Errorf("Auction should be active after the tick started it, err: %v", err)
+		return
+	}
+
+	// The next tick commits to it like normal
+	if tickRes, err = s.auctionTick(pair, endedID); err != nil {
+		t.Errorf("Tick should commit to the active auction, got error: %s", err)
+		return
+	}
+	if tickRes.id == endedID {
+		t.Errorf("Tick should move on to a new auction after committing")
+		return
+	}
+	if active, err = s.auctionActive(&pair, tickRes.id); err != nil || !active {
+		t.Errorf("New auction should be active after the tick, err: %v", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,110 @@
+package cxauctionserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// Shutdown stops the server from taking orders, stops the clock, and closes the DB handlers. A
+// tick that's already started finishes first, and so do the batches it's placing. If
+// finishAuctions is true, the auctions that are still active are ended and their batches placed,
+// otherwise they're aborted and their
+// orders stay in the puzzle stores. Reveal windows that are still open are cut short, since
+// nobody can reveal once the server is gone, and every bond that's still held is given back.
+// Reveals are taken until then. After this the server has no engines or stores, so anything
+// that comes in later gets an error.
+func (s *OpencxAuctionServer) Shutdown(finishAuctions bool) (err error) {
+	s.stoppingMtx.Lock()
+	s.stopping = true
+	s.stoppingMtx.Unlock()
+
+	// No bonds are forfeited once we're shutting down, since the reveals might not make it in
+	s.revealStopOnce.Do(func() {
+		close(s.revealStop)
+	})
+
+	if err = s.StopClock(); err != nil {
+		err = fmt.Errorf("Error stopping clock for Shutdown: %s", err)
+		return
+	}
+	logging.Infof("Waiting for auction clocks to stop...")
+	s.WaitForClock()
+
+	if finishAuctions {
+		if err = s.endActiveAuctions(); err != nil {
+			err = fmt.Errorf("Error ending auctions for Shutdown: %s", err)
+			return
+		}
+	} else {
+		s.dbLock.Lock()
+		for pair, batcher := range s.OrderBatchers {
+			if active := len(batcher.ActiveAuctions()); active != 0 {
+				logging.Warnf("Aborting %d active auctions for %s", active, pair.String())
+			}
+		}
+		s.dbLock.Unlock()
+	}
+
+	// Batches from the last ticks are placed before the handlers are gone
+	logging.Infof("Waiting for batches to be placed...")
+	s.placerWG.Wait()
+
+	logging.Infof("Waiting for reveal windows to stop...")
+	s.revealWG.Wait()
+
+	// Anything still holding the lock finishes before the handlers are gone
+	s.dbLock.Lock()
+	// Commitments don't outlive the server, so the bonds for them go back now
+	if bondErr := s.refundOpenBonds(); bondErr != nil {
+		logging.Errorf("Error refunding open bonds for Shutdown: %s", bondErr)
+	}
+	// Nothing else gets solved, so the solver pools can stop
+	stopBatchers(s.OrderBatchers)
+	var handlers []interface{}
+	for _, engine := range s.MatchingEngines {
+		handlers = append(handlers, engine)
+	}
+	for _, book := range s.Orderbooks {
+		handlers = append(handlers, book)
+	}
+	for _, pzEngine := range s.PuzzleEngines {
+		handlers = append(handlers, pzEngine)
+	}
+	for _, setEngine := range s.SettlementEngines {
+		handlers = append(handlers, setEngine)
+	}
+	for _, setStore := range s.SettlementStores {
+		handlers = append(handlers, setStore)
+	}
+	err = cxdb.DestroyHandlers(handlers)
+
+	// The handlers are closed even if some failed, so nothing should use them
+	s.SettlementEngines = make(map[*coinparam.Params]match.SettlementEngine)
+	s.SettlementStores = make(map[*coinparam.Params]cxdb.SettlementStore)
+	s.MatchingEngines = make(map[match.Pair]match.AuctionEngine)
+	s.Orderbooks = make(map[match.Pair]match.AuctionOrderbook)
+	s.PuzzleEngines = make(map[match.Pair]cxdb.PuzzleStore)
+	s.dbLock.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("Error closing DB handlers for Shutdown: %s", err)
+		return
+	}
+	return
+}
+
+// checkStopping returns an error if the server is shutting down, so it doesn't take new orders
+func (s *OpencxAuctionServer) checkStopping() (err error) {
+	s.stoppingMtx.Lock()
+	if s.stopping {
+		err = match.Errorf(match.ErrServerBusy, "Server is shutting down, not taking orders")
+		s.stoppingMtx.Unlock()
+		return
+	}
+	s.stoppingMtx.Unlock()
+	return
+}
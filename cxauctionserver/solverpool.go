@@ -0,0 +1,370 @@
+package cxauctionserver
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// solveJob is a single encrypted order waiting to be solved for a batch
+type solveJob struct {
+	order *match.EncryptedAuctionOrder
+	batch *intermediateBatch
+	// seq is used to break ties between orders in the same auction, so they're solved in the
+	// order they came in
+	seq    uint64
+	queued time.Time
+}
+
+// solveQueue is a priority queue of solve jobs, where orders for older auctions come first.
+// This implements heap.Interface.
+type solveQueue []*solveJob
+
+func (sq solveQueue) Len() int { return len(sq) }
+
+func (sq solveQueue) Less(i, j int) bool {
+	if !sq[i].batch.started.Equal(sq[j].batch.started) {
+		return sq[i].batch.started.Before(sq[j].batch.started)
+	}
+	return sq[i].seq < sq[j].seq
+}
+
+func (sq solveQueue) Swap(i, j int) { sq[i], sq[j] = sq[j], sq[i] }
+
+func (sq *solveQueue) Push(x interface{}) { *sq = append(*sq, x.(*solveJob)) }
+
+func (sq *solveQueue) Pop() interface{} {
+	old := *sq
+	n := len(old)
+	job := old[n-1]
+	old[n-1] = nil
+	*sq = old[:n-1]
+	return job
+}
+
+// SolverStats holds metrics on how long it takes the solver pool to solve puzzles.
+type SolverStats struct {
+	// Solved is the number of puzzles that have been solved, including ones that failed
+	Solved uint64
+	// Failed is the number of puzzles that couldn't be solved or decrypted
+	Failed uint64
+	// Rejected is the number of puzzles rejected because the queue was full
+	Rejected uint64
+	// Queued is the number of puzzles waiting for a worker right now
+	Queued uint64
+	// TotalSolveTime is the total time workers spent solving puzzles
+	TotalSolveTime time.Duration
+	// MaxSolveTime is the longest it took to solve a single puzzle
+	MaxSolveTime time.Duration
+	// TotalWaitTime is the total time puzzles waited in the queue before a worker picked them up
+	TotalWaitTime time.Duration
+}
+
+// AverageSolveTime returns the average time it took to solve a puzzle
+func (ss SolverStats) AverageSolveTime() (avg time.Duration) {
+	if ss.Solved == 0 {
+		return
+	}
+	avg = ss.TotalSolveTime / time.Duration(ss.Solved)
+	return
+}
+
+// AverageWaitTime returns the average time a puzzle waited in the queue
+func (ss SolverStats) AverageWaitTime() (avg time.Duration) {
+	if ss.Solved == 0 {
+		return
+	}
+	avg = ss.TotalWaitTime / time.Duration(ss.Solved)
+	return
+}
+
+// SolverPool is a bounded pool of workers that solve order puzzles. Solving a puzzle is CPU
+// bound, so we don't want to start a goroutine for every puzzle we get. Puzzles for older
+// auctions are solved first, and once maxQueued puzzles are waiting new puzzles are rejected.
+// Each worker takes the highest priority puzzle as soon as it's free, along with every queued
+// puzzle that needs the same squarings, and solves them together with rsw.SolveBatch. One pool can be shared between many batchers, and it's stopped once all of them are stopped.
+type SolverPool struct {
+	numWorkers uint64
+	maxQueued  uint64
+
+	// ctx is cancelled when the pool is stopped, which stops the puzzles being solved
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	queue   solveQueue
+	nextSeq uint64
+	stopped bool
+	// users is how many batchers use the pool that haven't been stopped
+	users    uint64
+	queueMtx sync.Mutex
+	// queueCond is signalled whenever there's a new job or the pool is stopped
+	queueCond *sync.Cond
+	// workerWG waits for the workers to return once the pool is stopped
+	workerWG sync.WaitGroup
+
+	stats    SolverStats
+	statsMtx sync.Mutex
+}
+
+// NewSolverPool creates a solver pool with numWorkers workers and a queue that holds at most
+// maxQueued puzzles, and starts the workers.
+func NewSolverPool(numWorkers uint64, maxQueued uint64) (pool *SolverPool, err error) {
+	if numWorkers == 0 {
+		err = fmt.Errorf("Cannot have a solver pool with 0 workers")
+		return
+	}
+	if maxQueued == 0 {
+		err = fmt.Errorf("Cannot have a solver pool with a max queue size of 0")
+		return
+	}
+
+	pool = &SolverPool{
+		numWorkers: numWorkers,
+		maxQueued:  maxQueued,
+		queue:      solveQueue{},
+	}
+	pool.queueCond = sync.NewCond(&pool.queueMtx)
+	pool.ctx, pool.cancel = context.WithCancel(context.Background())
+
+	pool.workerWG.Add(int(numWorkers))
+	for i := uint64(0); i < numWorkers; i++ {
+		go pool.worker()
+	}
+
+	return
+}
+
+// NewDefaultSolverPool creates a solver pool with a worker for every CPU.
+func NewDefaultSolverPool(maxQueued uint64) (pool *SolverPool, err error) {
+	return NewSolverPool(uint64(runtime.NumCPU()), maxQueued)
+}
+
+// addUser adds a batcher to the pool, so the pool isn't stopped until that batcher is stopped too
+func (sp *SolverPool) addUser() (err error) {
+	sp.queueMtx.Lock()
+	if sp.stopped {
+		err = fmt.Errorf("Cannot use a solver pool that is stopped")
+		sp.queueMtx.Unlock()
+		return
+	}
+	sp.users++
+	sp.queueMtx.Unlock()
+	return
+}
+
+// release removes a batcher from the pool, and stops the pool if no other batchers use it
+func (sp *SolverPool) release() {
+	sp.queueMtx.Lock()
+	if sp.users > 0 {
+		sp.users--
+	}
+	last := sp.users == 0
+	sp.queueMtx.Unlock()
+
+	if last {
+		sp.Stop()
+	}
+	return
+}
+
+// submit adds an order to the queue, or fails if the queue is full or the pool is stopped.
+func (sp *SolverPool) submit(order *match.EncryptedAuctionOrder, batch *intermediateBatch) (err error) {
+	sp.queueMtx.Lock()
+	if sp.stopped {
+		err = match.Errorf(match.ErrServerBusy, "Cannot solve puzzle, solver pool is stopped")
+		sp.queueMtx.Unlock()
+		return
+	}
+
+	if uint64(len(sp.queue)) >= sp.maxQueued {
+		err = match.Errorf(match.ErrServerBusy, "Solver queue full with %d puzzles, try again later", sp.maxQueued)
+		sp.queueMtx.Unlock()
+		sp.statsMtx.Lock()
+		sp.stats.Rejected++
+		sp.statsMtx.Unlock()
+		return
+	}
+
+	heap.Push(&sp.queue, &solveJob{
+		order:  order,
+		batch:  batch,
+		seq:    sp.nextSeq,
+		queued: time.Now(),
+	})
+	sp.nextSeq++
+	sp.queueCond.Signal()
+	sp.queueMtx.Unlock()
+	return
+}
+
+// worker solves the highest priority jobs in the queue, one group at a time, until the pool is
+// stopped. This should be run in a goroutine.
+func (sp *SolverPool) worker() {
+	defer sp.workerWG.Done()
+	for {
+		jobs, ok := sp.nextJobs()
+		if !ok {
+			return
+		}
+		sp.solveJobs(jobs)
+	}
+}
+
+// nextJobs waits for a job and takes the highest priority one off the queue, along with every
+// queued job whose puzzle needs the same squarings, since solving one solves them all. It
+// returns false once the pool is stopped.
+func (sp *SolverPool) nextJobs() (jobs []*solveJob, ok bool) {
+	sp.queueMtx.Lock()
+	for len(sp.queue) == 0 && !sp.stopped {
+		sp.queueCond.Wait()
+	}
+	if sp.stopped {
+		sp.queueMtx.Unlock()
+		return
+	}
+
+	first := heap.Pop(&sp.queue).(*solveJob)
+	jobs = append(jobs, first)
+	if firstPuzzle, isRSW := first.order.OrderPuzzle.(*rsw.PuzzleRSW); isRSW {
+		remaining := solveQueue{}
+		for _, job := range sp.queue {
+			if puzzle, jobIsRSW := job.order.OrderPuzzle.(*rsw.PuzzleRSW); jobIsRSW && sameSquarings(firstPuzzle, puzzle) {
+				jobs = append(jobs, job)
+				continue
+			}
+			remaining = append(remaining, job)
+		}
+		if len(remaining) != len(sp.queue) {
+			sp.queue = remaining
+			heap.Init(&sp.queue)
+		}
+	}
+	sp.queueMtx.Unlock()
+
+	ok = true
+	return
+}
+
+// sameSquarings returns true if two puzzles have the same N, A and T, so solving one solves both
+func sameSquarings(a *rsw.PuzzleRSW, b *rsw.PuzzleRSW) (same bool) {
+	if a.N == nil || a.A == nil || a.T == nil || b.N == nil || b.A == nil || b.T == nil {
+		return
+	}
+	same = a.N.Cmp(b.N) == 0 && a.A.Cmp(b.A) == 0 && a.T.Cmp(b.T) == 0
+	return
+}
+
+// solveJobs solves the RSW puzzles for jobs that need the same squarings, and sends each result
+// to its auction as soon as it's done.
+func (sp *SolverPool) solveJobs(jobs []*solveJob) {
+	startSolve := time.Now()
+
+	var puzzles []*rsw.PuzzleRSW
+	var rswJobs []*solveJob
+	for _, job := range jobs {
+		rswPuzzle, ok := job.order.OrderPuzzle.(*rsw.PuzzleRSW)
+		if !ok {
+			// This isn't an RSW puzzle, so it won't be solved, but the batch should find out why
+			sp.finishJob(job, job.batch.solveSingleOrder(job.order), startSolve)
+			continue
+		}
+		puzzles = append(puzzles, rswPuzzle)
+		rswJobs = append(rswJobs, job)
+	}
+
+	// Puzzles that are stopped get an error, which is sent to their auction like any other
+	for batchResult := range rsw.SolveBatch(sp.ctx, puzzles, 1) {
+		job := rswJobs[batchResult.Index]
+		if batchResult.Err != nil {
+			sp.finishJob(job, &match.OrderPuzzleResult{
+				Encrypted: job.order,
+				Err:       fmt.Errorf("Error solving puzzle in batch: %s", batchResult.Err),
+			}, startSolve)
+			continue
+		}
+		sp.finishJob(job, job.batch.decryptSolvedOrder(job.order, batchResult.Y), startSolve)
+	}
+
+	return
+}
+
+// finishJob records the stats for a job and sends its result to its auction
+func (sp *SolverPool) finishJob(job *solveJob, result *match.OrderPuzzleResult, startSolve time.Time) {
+	solveTime := time.Since(startSolve)
+
+	// record before sending so the stats include every order in a batch once it's done
+	sp.recordSolve(result, startSolve.Sub(job.queued), solveTime)
+	logging.Debugf("Solved puzzle for auction %x in %s after waiting %s", job.order.IntendedAuction, solveTime, startSolve.Sub(job.queued))
+	if err := job.batch.sendResult(result); err != nil {
+		logging.Errorf("Error sending solved puzzle to auction %x: %s", job.order.IntendedAuction, err)
+	}
+	return
+}
+
+// recordSolve updates the stats after a puzzle is solved
+func (sp *SolverPool) recordSolve(result *match.OrderPuzzleResult, waitTime time.Duration, solveTime time.Duration) {
+	sp.statsMtx.Lock()
+	sp.stats.Solved++
+	if result.Err != nil {
+		sp.stats.Failed++
+	}
+	sp.stats.TotalSolveTime += solveTime
+	sp.stats.TotalWaitTime += waitTime
+	if solveTime > sp.stats.MaxSolveTime {
+		sp.stats.MaxSolveTime = solveTime
+	}
+	sp.statsMtx.Unlock()
+	return
+}
+
+// Stats returns a snapshot of the solver pool metrics
+func (sp *SolverPool) Stats() (stats SolverStats) {
+	sp.queueMtx.Lock()
+	queued := uint64(len(sp.queue))
+	sp.queueMtx.Unlock()
+
+	sp.statsMtx.Lock()
+	stats = sp.stats
+	sp.statsMtx.Unlock()
+
+	stats.Queued = queued
+	return
+}
+
+// Stop stops the workers, including the ones in the middle of solving a puzzle, and waits for
+// them to return. Puzzles that weren't solved yet, including the ones still in the queue, are
+// sent to their auctions with an error, so the auctions can still end.
+func (sp *SolverPool) Stop() {
+	sp.queueMtx.Lock()
+	if sp.stopped {
+		sp.queueMtx.Unlock()
+		sp.workerWG.Wait()
+		return
+	}
+	sp.stopped = true
+	queued := sp.queue
+	sp.queue = solveQueue{}
+	sp.queueCond.Broadcast()
+	sp.queueMtx.Unlock()
+	if sp.cancel != nil {
+		sp.cancel()
+	}
+
+	stopTime := time.Now()
+	for _, job := range queued {
+		sp.finishJob(job, &match.OrderPuzzleResult{
+			Encrypted: job.order,
+			Err:       match.Errorf(match.ErrServerBusy, "Solver pool stopped before the puzzle was solved"),
+		}, stopTime)
+	}
+
+	sp.workerWG.Wait()
+	return
+}
@@ -0,0 +1,303 @@
+package cxauctionserver
+
+import (
+	"container/heap"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+func TestSolveQueueOlderAuctionsFirst(t *testing.T) {
+	now := time.Now()
+	older := &intermediateBatch{started: now.Add(-time.Minute)}
+	newer := &intermediateBatch{started: now}
+
+	queue := &solveQueue{}
+	heap.Push(queue, &solveJob{batch: newer, seq: 0})
+	heap.Push(queue, &solveJob{batch: older, seq: 2})
+	heap.Push(queue, &solveJob{batch: older, seq: 1})
+
+	expected := []*solveJob{
+		{batch: older, seq: 1},
+		{batch: older, seq: 2},
+		{batch: newer, seq: 0},
+	}
+	for i, exp := range expected {
+		job := heap.Pop(queue).(*solveJob)
+		if job.batch != exp.batch || job.seq != exp.seq {
+			t.Errorf("Job %d popped out of order, got seq %d", i, job.seq)
+			return
+		}
+	}
+
+	return
+}
+
+func TestSolverPoolQueueFull(t *testing.T) {
+	var err error
+
+	// No workers are started, so nothing leaves the queue
+	pool := &SolverPool{
+		numWorkers: 0,
+		maxQueued:  1,
+		queue:      solveQueue{},
+	}
+	pool.queueCond = sync.NewCond(&pool.queueMtx)
+
+	batch := &intermediateBatch{started: time.Now()}
+	if err = pool.submit(testEncryptedOrder, batch); err != nil {
+		t.Errorf("First submit should succeed: %s", err)
+		return
+	}
+
+	if err = pool.submit(testEncryptedOrder, batch); err == nil {
+		t.Errorf("Submit to a full queue should fail")
+		return
+	}
+
+	stats := pool.Stats()
+	if stats.Queued != 1 || stats.Rejected != 1 {
+		t.Errorf("Expected 1 queued and 1 rejected, got %d queued and %d rejected", stats.Queued, stats.Rejected)
+		return
+	}
+
+	return
+}
+
+func TestSolverPoolSolvesBatch(t *testing.T) {
+	var err error
+
+	var pool *SolverPool
+	if pool, err = NewSolverPool(2, testMaxBatchSize); err != nil {
+		t.Errorf("Error creating solver pool: %s", err)
+		return
+	}
+	defer pool.Stop()
+
+	var batcher *ABatcher
+	if batcher, err = NewABatcherWithPool(testMaxBatchSize, pool); err != nil {
+		t.Errorf("Error creating batcher: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(testEncryptedOrder.IntendedAuction); err != nil {
+		t.Errorf("Error registering auction: %s", err)
+		return
+	}
+
+	for i := 0; i < testNumOrders; i++ {
+		if err = batcher.AddEncrypted(testEncryptedOrder); err != nil {
+			t.Errorf("Error adding encrypted order %d: %s", i, err)
+			return
+		}
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(testEncryptedOrder.IntendedAuction); err != nil {
+		t.Errorf("Error ending auction: %s", err)
+		return
+	}
+
+	select {
+	case batch := <-batchChan:
+		if len(batch.Batch) != testNumOrders {
+			t.Errorf("Expected %d orders in batch, got %d", testNumOrders, len(batch.Batch))
+			return
+		}
+	case <-time.After(time.Minute):
+		t.Errorf("Timed out waiting for batch")
+		return
+	}
+
+	if stats := batcher.SolverStats(); stats.Solved != uint64(testNumOrders) {
+		t.Errorf("Expected %d solved puzzles in stats, got %d", testNumOrders, stats.Solved)
+		return
+	}
+
+	return
+}
+
+func TestEndEmptyAuction(t *testing.T) {
+	var err error
+
+	var batcher *ABatcher
+	if batcher, err = NewABatcher(testMaxBatchSize); err != nil {
+		t.Errorf("Error creating batcher: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(testEncryptedOrder.IntendedAuction); err != nil {
+		t.Errorf("Error registering auction: %s", err)
+		return
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(testEncryptedOrder.IntendedAuction); err != nil {
+		t.Errorf("Error ending auction: %s", err)
+		return
+	}
+
+	select {
+	case batch := <-batchChan:
+		if len(batch.Batch) != 0 {
+			t.Errorf("Expected empty batch, got %d orders", len(batch.Batch))
+			return
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for batch of empty auction")
+		return
+	}
+
+	return
+}
+
+func TestStopFailsQueuedPuzzles(t *testing.T) {
+	var err error
+
+	// No workers are started, so the puzzles are still queued when the pool stops
+	pool := &SolverPool{
+		numWorkers: 0,
+		maxQueued:  testMaxBatchSize,
+		queue:      solveQueue{},
+	}
+	pool.queueCond = sync.NewCond(&pool.queueMtx)
+
+	var batcher *ABatcher
+	if batcher, err = NewABatcherWithPool(testMaxBatchSize, pool); err != nil {
+		t.Errorf("Error creating batcher: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(testEncryptedOrder.IntendedAuction); err != nil {
+		t.Errorf("Error registering auction: %s", err)
+		return
+	}
+
+	for i := 0; i < testNumOrders; i++ {
+		if err = batcher.AddEncrypted(testEncryptedOrder); err != nil {
+			t.Errorf("Error adding encrypted order %d: %s", i, err)
+			return
+		}
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(testEncryptedOrder.IntendedAuction); err != nil {
+		t.Errorf("Error ending auction: %s", err)
+		return
+	}
+
+	// the batcher is the only one using the pool, so this stops the pool
+	batcher.Stop()
+
+	select {
+	case batch := <-batchChan:
+		if len(batch.Batch) != testNumOrders {
+			t.Errorf("Expected %d orders in batch, got %d", testNumOrders, len(batch.Batch))
+			return
+		}
+		for i, result := range batch.Batch {
+			if result.Err == nil {
+				t.Errorf("Order %d should have failed when the pool stopped", i)
+				return
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for batch after stopping pool")
+		return
+	}
+
+	if err = batcher.AddEncrypted(testEncryptedOrder); err == nil {
+		t.Errorf("Stopped batcher should not take orders")
+		return
+	}
+
+	return
+}
+
+func TestSendResultFull(t *testing.T) {
+	batch := &intermediateBatch{
+		orderChan: make(chan *match.OrderPuzzleResult, 1),
+	}
+
+	if err := batch.sendResult(&match.OrderPuzzleResult{}); err != nil {
+		t.Errorf("First result should send: %s", err)
+		return
+	}
+
+	if err := batch.sendResult(&match.OrderPuzzleResult{}); err == nil {
+		t.Errorf("Sending to a full channel should fail")
+		return
+	}
+
+	return
+}
+
+func TestNextJobsSameSquarings(t *testing.T) {
+	var err error
+
+	// a second order has its own puzzle, so it needs different squarings
+	var otherOrder *match.EncryptedAuctionOrder
+	if otherOrder, err = testAuctionOrder.TurnIntoEncryptedOrder(testStandardAuctionTime); err != nil {
+		t.Errorf("Error creating other encrypted order: %s", err)
+		return
+	}
+
+	// No workers are started, so we take the jobs ourselves
+	pool := &SolverPool{
+		numWorkers: 0,
+		maxQueued:  testMaxBatchSize,
+		queue:      solveQueue{},
+	}
+	pool.queueCond = sync.NewCond(&pool.queueMtx)
+
+	now := time.Now()
+	older := &intermediateBatch{started: now.Add(-time.Minute)}
+	newer := &intermediateBatch{started: now}
+	submits := []struct {
+		order *match.EncryptedAuctionOrder
+		batch *intermediateBatch
+	}{
+		{testEncryptedOrder, newer},
+		{testEncryptedOrder, newer},
+		{otherOrder, older},
+		{testEncryptedOrder, older},
+	}
+	for i, sub := range submits {
+		if err = pool.submit(sub.order, sub.batch); err != nil {
+			t.Errorf("Error submitting job %d: %s", i, err)
+			return
+		}
+	}
+
+	// the oldest auction's first order comes first, and nothing else needs its squarings
+	jobs, ok := pool.nextJobs()
+	if !ok || len(jobs) != 1 || jobs[0].order != otherOrder {
+		t.Errorf("Expected only the other order first, got %d jobs", len(jobs))
+		return
+	}
+
+	// then the next order in the oldest auction, along with the newer orders with the same puzzle
+	if jobs, ok = pool.nextJobs(); !ok || len(jobs) != 3 {
+		t.Errorf("Expected 3 jobs with the same squarings, got %d", len(jobs))
+		return
+	}
+	if jobs[0].batch != older {
+		t.Errorf("Highest priority job should be first")
+		return
+	}
+
+	if stats := pool.Stats(); stats.Queued != 0 {
+		t.Errorf("Expected empty queue, got %d queued", stats.Queued)
+		return
+	}
+
+	pool.Stop()
+	if _, ok = pool.nextJobs(); ok {
+		t.Errorf("Stopped pool should not give out jobs")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,139 @@
+package cxauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+const (
+	// DefaultNonceLifetime is how long an issued nonce can be used for
+	DefaultNonceLifetime = 5 * time.Minute
+	// nonceSaltSize is how many bytes of a nonce are random. The rest is the MAC over the salt, the
+	// owner and the expiry.
+	nonceSaltSize = 16
+)
+
+// NonceStore issues single use nonces and verifies signed requests against them. Every signed
+// RPC goes through a NonceStore, so this is the one place replays are checked.
+// Nonces are a random salt and a MAC over the salt, the pubkey they're issued to, and their
+// expiry, so issuing them doesn't keep anything. No one can stop someone else's nonces from
+// working by asking for lots of nonces, for them or for anyone else. Only nonces that were used
+// are kept, until they expire, so they can't be used again.
+type NonceStore struct {
+	lifetime time.Duration
+	// key is what nonces are MACed with. It's random for each store, so nonces from another store,
+	// or from before a restart, don't verify.
+	key [32]byte
+	// used has the used nonces by the expiry they were issued with
+	used map[int64]map[[32]byte]struct{}
+	mtx  *sync.Mutex
+}
+
+// NewNonceStore creates a nonce store whose nonces can be used for lifetime after they are issued
+func NewNonceStore(lifetime time.Duration) (store *NonceStore, err error) {
+	if lifetime <= 0 {
+		err = fmt.Errorf("Nonce lifetime must be positive, got %s", lifetime)
+		return
+	}
+	store = &NonceStore{
+		lifetime: lifetime,
+		used:     make(map[int64]map[[32]byte]struct{}),
+		mtx:      new(sync.Mutex),
+	}
+	if _, err = rand.Read(store.key[:]); err != nil {
+		err = fmt.Errorf("Error getting random key for nonce store: %s", err)
+		return
+	}
+	return
+}
+
+// IssueNonce issues a new nonce for a pubkey to sign requests with, and returns when it expires.
+// Only requests signed by that pubkey, with that expiry, can use the nonce.
+func (ns *NonceStore) IssueNonce(owner [33]byte) (nonce [32]byte, expiry time.Time, err error) {
+	if _, err = rand.Read(nonce[:nonceSaltSize]); err != nil {
+		err = fmt.Errorf("Error getting random nonce: %s", err)
+		return
+	}
+
+	// requests sign the expiry in unix seconds, so that's what the nonce is bound to
+	expiry = time.Unix(time.Now().Add(ns.lifetime).Unix(), 0)
+	copy(nonce[nonceSaltSize:], ns.nonceMAC(owner, expiry.Unix(), nonce[:nonceSaltSize]))
+	return
+}
+
+// VerifyRequest checks that a request for a method and args hash is signed, unexpired, and uses a
+// nonce this store issued to the signer that hasn't been used yet. The nonce is used up if the
+// request is valid. It returns the pubkey that signed the request.
+func (ns *NonceStore) VerifyRequest(method string, argsHash [32]byte, req *SignedRequest) (pubkey *koblitz.PublicKey, err error) {
+	if req == nil {
+		err = fmt.Errorf("Request for %s is not signed", method)
+		return
+	}
+
+	now := time.Now()
+	if now.Unix() > req.Expiry {
+		err = fmt.Errorf("Request for %s expired at %s", method, time.Unix(req.Expiry, 0))
+		return
+	}
+
+	if pubkey, err = req.Signer(method, argsHash); err != nil {
+		return
+	}
+
+	// a signature over anything else recovers to some other pubkey, so the MAC only matches if the
+	// nonce was issued to whoever signed, with the expiry they signed
+	var signer [33]byte
+	copy(signer[:], pubkey.SerializeCompressed())
+	if !hmac.Equal(req.Nonce[nonceSaltSize:], ns.nonceMAC(signer, req.Expiry, req.Nonce[:nonceSaltSize])) {
+		err = fmt.Errorf("Nonce %x for %s was not issued to %x with expiry %s", req.Nonce, method, signer, time.Unix(req.Expiry, 0))
+		return
+	}
+
+	ns.mtx.Lock()
+	ns.pruneUsed(now)
+	if _, used := ns.used[req.Expiry][req.Nonce]; used {
+		ns.mtx.Unlock()
+		err = fmt.Errorf("Nonce %x for %s was already used", req.Nonce, method)
+		return
+	}
+	if _, ok := ns.used[req.Expiry]; !ok {
+		ns.used[req.Expiry] = make(map[[32]byte]struct{})
+	}
+	ns.used[req.Expiry][req.Nonce] = struct{}{}
+	ns.mtx.Unlock()
+
+	return
+}
+
+// nonceMAC returns the MAC part of a nonce with the salt, issued to owner with the expiry in unix
+// seconds
+func (ns *NonceStore) nonceMAC(owner [33]byte, expiry int64, salt []byte) (mac []byte) {
+	hasher := hmac.New(sha256.New, ns.key[:])
+	hasher.Write(salt)
+	hasher.Write(owner[:])
+
+	var expiryBytes [8]byte
+	binary.BigEndian.PutUint64(expiryBytes[:], uint64(expiry))
+	hasher.Write(expiryBytes[:])
+
+	mac = hasher.Sum(nil)[:32-nonceSaltSize]
+	return
+}
+
+// pruneUsed forgets used nonces that have expired, since requests with them are rejected for
+// being expired anyway. The mutex must be held.
+func (ns *NonceStore) pruneUsed(now time.Time) {
+	for expiry := range ns.used {
+		if now.Unix() > expiry {
+			delete(ns.used, expiry)
+		}
+	}
+	return
+}
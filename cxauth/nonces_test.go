@@ -0,0 +1,199 @@
+package cxauth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+const testMethod = "OpencxRPC.CancelOrder"
+
+var testArgsHash = HashArgs([]byte("testorderid"))
+
+// ownerOf returns the serialized pubkey of a private key, for issuing nonces
+func ownerOf(privkey *koblitz.PrivateKey) (owner [33]byte) {
+	copy(owner[:], privkey.PubKey().SerializeCompressed())
+	return
+}
+
+// signTestRequest issues a nonce from the store and signs a request with it
+func signTestRequest(t *testing.T, store *NonceStore, privkey *koblitz.PrivateKey, method string, argsHash [32]byte) (req *SignedRequest) {
+	nonce, expiry, err := store.IssueNonce(ownerOf(privkey))
+	if err != nil {
+		t.Fatalf("Error issuing nonce: %s", err)
+	}
+	if req, err = SignRequest(privkey, method, argsHash, nonce, expiry); err != nil {
+		t.Fatalf("Error signing request: %s", err)
+	}
+	return
+}
+
+func TestVerifyRequest(t *testing.T) {
+	var err error
+
+	var privkey *koblitz.PrivateKey
+	if privkey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating private key: %s", err)
+		return
+	}
+
+	var store *NonceStore
+	if store, err = NewNonceStore(DefaultNonceLifetime); err != nil {
+		t.Errorf("Error creating nonce store: %s", err)
+		return
+	}
+
+	req := signTestRequest(t, store, privkey, testMethod, testArgsHash)
+
+	// the same signature for a different method or different args doesn't verify, and doesn't
+	// use up the nonce either
+	if _, err = store.VerifyRequest("OpencxRPC.GetOrder", testArgsHash, req); err == nil {
+		t.Errorf("Request for one method should not verify for another")
+		return
+	}
+	if _, err = store.VerifyRequest(testMethod, HashArgs([]byte("otherorderid")), req); err == nil {
+		t.Errorf("Request should not verify with different args")
+		return
+	}
+
+	// nor can someone else sign with the nonce
+	var otherKey *koblitz.PrivateKey
+	if otherKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating other private key: %s", err)
+		return
+	}
+	var stolenReq *SignedRequest
+	if stolenReq, err = SignRequest(otherKey, testMethod, testArgsHash, req.Nonce, time.Unix(req.Expiry, 0)); err != nil {
+		t.Errorf("Error signing request with other key: %s", err)
+		return
+	}
+	if _, err = store.VerifyRequest(testMethod, testArgsHash, stolenReq); err == nil {
+		t.Errorf("Request should not verify with a nonce issued to someone else")
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = store.VerifyRequest(testMethod, testArgsHash, req); err != nil {
+		t.Errorf("Request should verify: %s", err)
+		return
+	}
+	if !pubkey.IsEqual(privkey.PubKey()) {
+		t.Errorf("Request verified for the wrong pubkey")
+		return
+	}
+
+	if _, err = store.VerifyRequest(testMethod, testArgsHash, req); err == nil {
+		t.Errorf("Request should not verify twice")
+		return
+	}
+
+	// nonces from another store were never issued here
+	var otherStore *NonceStore
+	if otherStore, err = NewNonceStore(DefaultNonceLifetime); err != nil {
+		t.Errorf("Error creating other nonce store: %s", err)
+		return
+	}
+	otherReq := signTestRequest(t, otherStore, privkey, testMethod, testArgsHash)
+	if _, err = store.VerifyRequest(testMethod, testArgsHash, otherReq); err == nil {
+		t.Errorf("Request with a nonce from another store should not verify")
+		return
+	}
+
+	return
+}
+
+func TestRequestExpiry(t *testing.T) {
+	var err error
+
+	var privkey *koblitz.PrivateKey
+	if privkey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating private key: %s", err)
+		return
+	}
+
+	var store *NonceStore
+	if store, err = NewNonceStore(DefaultNonceLifetime); err != nil {
+		t.Errorf("Error creating nonce store: %s", err)
+		return
+	}
+
+	var nonce [32]byte
+	if nonce, _, err = store.IssueNonce(ownerOf(privkey)); err != nil {
+		t.Errorf("Error issuing nonce: %s", err)
+		return
+	}
+
+	var req *SignedRequest
+	if req, err = SignRequest(privkey, testMethod, testArgsHash, nonce, time.Now().Add(-time.Minute)); err != nil {
+		t.Errorf("Error signing request: %s", err)
+		return
+	}
+
+	if _, err = store.VerifyRequest(testMethod, testArgsHash, req); err == nil {
+		t.Errorf("Expired request should not verify")
+		return
+	}
+
+	// pushing the expiry forward changes the digest, so the old signature is no good
+	req.Expiry = time.Now().Add(time.Minute).Unix()
+	if _, err = store.VerifyRequest(testMethod, testArgsHash, req); err == nil {
+		t.Errorf("Request with a changed expiry should not verify")
+		return
+	}
+
+	return
+}
+
+func TestNoncesNotEvicted(t *testing.T) {
+	var err error
+
+	var privkey *koblitz.PrivateKey
+	if privkey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating private key: %s", err)
+		return
+	}
+
+	var store *NonceStore
+	if store, err = NewNonceStore(DefaultNonceLifetime); err != nil {
+		t.Errorf("Error creating nonce store: %s", err)
+		return
+	}
+
+	// anyone can ask for nonces for our pubkey, or lots of other pubkeys, without dropping ours
+	req := signTestRequest(t, store, privkey, testMethod, testArgsHash)
+	var otherOwner [33]byte
+	otherOwner[0] = 0x02
+	for i := 0; i < 1000; i++ {
+		otherOwner[1+i%32]++
+		if _, _, err = store.IssueNonce(otherOwner); err != nil {
+			t.Errorf("Error issuing nonce to other owner: %s", err)
+			return
+		}
+		if _, _, err = store.IssueNonce(ownerOf(privkey)); err != nil {
+			t.Errorf("Error issuing nonce: %s", err)
+			return
+		}
+	}
+
+	if _, err = store.VerifyRequest(testMethod, testArgsHash, req); err != nil {
+		t.Errorf("Request should verify after others ask for nonces: %s", err)
+		return
+	}
+	if _, err = store.VerifyRequest(testMethod, testArgsHash, req); err == nil {
+		t.Errorf("Request should not verify twice")
+		return
+	}
+
+	// used nonces are only kept until they expire
+	store.mtx.Lock()
+	store.pruneUsed(time.Unix(req.Expiry+1, 0))
+	numUsed := len(store.used)
+	store.mtx.Unlock()
+	if numUsed != 0 {
+		t.Errorf("Used nonces should be forgotten once they expire, %d expiries left", numUsed)
+		return
+	}
+
+	return
+}
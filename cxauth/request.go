@@ -0,0 +1,89 @@
+package cxauth
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"golang.org/x/crypto/sha3"
+)
+
+// RequestDomain is written first in every request digest, so a request signature can't be
+// mistaken for any other signature made with the same key, like an order signature.
+const RequestDomain = "opencx-request-v1"
+
+// SignedRequest authorizes a single RPC call. The signature commits to the method, a hash of the
+// args, a nonce the server issued, and an expiry. So it can't be used for a different method or
+// different args, and can't be replayed once the nonce is used or the expiry passes.
+type SignedRequest struct {
+	Nonce [32]byte
+	// Expiry is in unix seconds
+	Expiry    int64
+	Signature []byte
+}
+
+// HashArgs hashes the parts of an RPC's args that a request commits to. Each part is length
+// prefixed so parts can't run into each other.
+func HashArgs(parts ...[]byte) (argsHash [32]byte) {
+	hasher := sha3.New256()
+	var lenBytes [4]byte
+	for _, part := range parts {
+		binary.BigEndian.PutUint32(lenBytes[:], uint32(len(part)))
+		hasher.Write(lenBytes[:])
+		hasher.Write(part)
+	}
+	copy(argsHash[:], hasher.Sum(nil))
+	return
+}
+
+// RequestDigest returns the digest that gets signed for a request
+func RequestDigest(method string, argsHash [32]byte, nonce [32]byte, expiry int64) (digest []byte) {
+	hasher := sha3.New256()
+	hasher.Write([]byte(RequestDomain))
+
+	var lenBytes [4]byte
+	binary.BigEndian.PutUint32(lenBytes[:], uint32(len(method)))
+	hasher.Write(lenBytes[:])
+	hasher.Write([]byte(method))
+
+	hasher.Write(argsHash[:])
+	hasher.Write(nonce[:])
+
+	var expiryBytes [8]byte
+	binary.BigEndian.PutUint64(expiryBytes[:], uint64(expiry))
+	hasher.Write(expiryBytes[:])
+
+	digest = hasher.Sum(nil)
+	return
+}
+
+// SignRequest signs a request for a method and args hash, with a nonce from the server and an
+// expiry.
+func SignRequest(privkey *koblitz.PrivateKey, method string, argsHash [32]byte, nonce [32]byte, expiry time.Time) (req *SignedRequest, err error) {
+	if privkey == nil {
+		err = fmt.Errorf("Cannot sign request with nil key")
+		return
+	}
+
+	req = &SignedRequest{
+		Nonce:  nonce,
+		Expiry: expiry.Unix(),
+	}
+	if req.Signature, err = koblitz.SignCompact(koblitz.S256(), privkey, RequestDigest(method, argsHash, req.Nonce, req.Expiry), false); err != nil {
+		err = fmt.Errorf("Error signing request for %s: %s", method, err)
+		return
+	}
+
+	return
+}
+
+// Signer recovers the pubkey that signed the request for a method and args hash. This does not
+// check the nonce or expiry, a NonceStore does that.
+func (req *SignedRequest) Signer(method string, argsHash [32]byte) (pubkey *koblitz.PublicKey, err error) {
+	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), req.Signature, RequestDigest(method, argsHash, req.Nonce, req.Expiry)); err != nil {
+		err = fmt.Errorf("Error verifying request for %s, invalid signature: %s", method, err)
+		return
+	}
+	return
+}
@@ -0,0 +1,232 @@
+package cxbatcherrpc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// getBatcher gets the batcher for a pair, creating it if it doesn't exist yet
+func (cl *OpencxBatcherRPC) getBatcher(pair *match.Pair) (batcher match.AuctionBatcher, err error) {
+	cl.batcherMtx.Lock()
+	var ok bool
+	if batcher, ok = cl.batchers[*pair]; !ok {
+		if batcher, err = cxauctionserver.NewABatcherWithPool(cl.maxBatchSize, cl.solverPool); err != nil {
+			err = fmt.Errorf("Error creating batcher for pair %s: %s", pair.String(), err)
+			cl.batcherMtx.Unlock()
+			return
+		}
+		cl.batchers[*pair] = batcher
+	}
+	cl.batcherMtx.Unlock()
+	return
+}
+
+// RegisterAuctionArgs holds the args for the registerauction command
+type RegisterAuctionArgs struct {
+	Pair      match.Pair
+	AuctionID [32]byte
+}
+
+// RegisterAuctionReply holds the reply for the registerauction command
+type RegisterAuctionReply struct {
+	match.ErrorReply
+}
+
+// RegisterAuction registers a new auction for a pair
+func (cl *OpencxBatcherRPC) RegisterAuction(args RegisterAuctionArgs, reply *RegisterAuctionReply) (err error) {
+	defer reply.SetError(&err)
+
+	var batcher match.AuctionBatcher
+	if batcher, err = cl.getBatcher(&args.Pair); err != nil {
+		err = fmt.Errorf("Error getting batcher for RegisterAuction RPC command: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(args.AuctionID); err != nil {
+		err = fmt.Errorf("Error registering auction for RegisterAuction RPC command: %s", err)
+		return
+	}
+
+	logging.Infof("Registered auction %x for pair %s", args.AuctionID, args.Pair.String())
+	return
+}
+
+// AddEncryptedArgs holds the args for the addencrypted command
+type AddEncryptedArgs struct {
+	Pair match.Pair
+	// Use the serialize method on match.EncryptedAuctionOrder
+	EncryptedOrderBytes []byte
+}
+
+// AddEncryptedReply holds the reply for the addencrypted command
+type AddEncryptedReply struct {
+	match.ErrorReply
+}
+
+// AddEncrypted adds an encrypted order to an auction, which the batcher will start solving
+func (cl *OpencxBatcherRPC) AddEncrypted(args AddEncryptedArgs, reply *AddEncryptedReply) (err error) {
+	defer reply.SetError(&err)
+
+	order := new(match.EncryptedAuctionOrder)
+	if err = order.Deserialize(args.EncryptedOrderBytes); err != nil {
+		err = fmt.Errorf("Error deserializing encrypted order for AddEncrypted RPC command: %s", err)
+		return
+	}
+
+	var batcher match.AuctionBatcher
+	if batcher, err = cl.getBatcher(&args.Pair); err != nil {
+		err = fmt.Errorf("Error getting batcher for AddEncrypted RPC command: %s", err)
+		return
+	}
+
+	if err = batcher.AddEncrypted(order); err != nil {
+		err = match.Wrapf(err, "Error adding encrypted order for AddEncrypted RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// EndAuctionArgs holds the args for the endauction command
+type EndAuctionArgs struct {
+	Pair      match.Pair
+	AuctionID [32]byte
+}
+
+// EndAuctionReply holds the reply for the endauction command
+type EndAuctionReply struct {
+	match.ErrorReply
+}
+
+// EndAuction ends an auction. This returns right away, the batch can be retrieved once it's
+// solved with WaitForBatch.
+func (cl *OpencxBatcherRPC) EndAuction(args EndAuctionArgs, reply *EndAuctionReply) (err error) {
+	defer reply.SetError(&err)
+
+	var batcher match.AuctionBatcher
+	if batcher, err = cl.getBatcher(&args.Pair); err != nil {
+		err = fmt.Errorf("Error getting batcher for EndAuction RPC command: %s", err)
+		return
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(args.AuctionID); err != nil {
+		err = fmt.Errorf("Error ending auction for EndAuction RPC command: %s", err)
+		return
+	}
+
+	cl.pendingMtx.Lock()
+	cl.pendingBatches[args.AuctionID] = &pendingBatch{batchChan: batchChan}
+	cl.pendingMtx.Unlock()
+
+	logging.Infof("Ended auction %x for pair %s", args.AuctionID, args.Pair.String())
+	return
+}
+
+// WaitForBatchArgs holds the args for the waitforbatch command
+type WaitForBatchArgs struct {
+	AuctionID [32]byte
+}
+
+// WaitForBatchReply holds the reply for the waitforbatch command
+type WaitForBatchReply struct {
+	AuctionID [32]byte
+	Results   []*RemoteOrderPuzzleResult
+	match.ErrorReply
+}
+
+// WaitForBatch waits until every puzzle in an ended auction is solved, and returns the batch.
+// This blocks for as long as it takes to solve the puzzles. The batch is kept until AckBatch is
+// called, so it can be waited for again if the reply doesn't make it.
+func (cl *OpencxBatcherRPC) WaitForBatch(args WaitForBatchArgs, reply *WaitForBatchReply) (err error) {
+	defer reply.SetError(&err)
+
+	cl.pendingMtx.Lock()
+	var pending *pendingBatch
+	var ok bool
+	if pending, ok = cl.pendingBatches[args.AuctionID]; !ok {
+		err = fmt.Errorf("No ended auction %x to wait for, end the auction first", args.AuctionID)
+		cl.pendingMtx.Unlock()
+		return
+	}
+	cl.pendingMtx.Unlock()
+
+	pending.mtx.Lock()
+	if pending.batch == nil {
+		pending.batch = <-pending.batchChan
+	}
+	batch := pending.batch
+	pending.mtx.Unlock()
+
+	if batch.Err != nil {
+		err = fmt.Errorf("Error solving batch for WaitForBatch RPC command: %s", batch.Err)
+		return
+	}
+
+	reply.AuctionID = batch.AuctionID
+	var remoteRes *RemoteOrderPuzzleResult
+	for _, res := range batch.Batch {
+		if remoteRes, err = resultToRemote(res); err != nil {
+			err = fmt.Errorf("Error converting result for WaitForBatch RPC command: %s", err)
+			return
+		}
+		reply.Results = append(reply.Results, remoteRes)
+	}
+
+	logging.Infof("Sending batch of %d orders for auction %x", len(reply.Results), args.AuctionID)
+	return
+}
+
+// AckBatchArgs holds the args for the ackbatch command
+type AckBatchArgs struct {
+	AuctionID [32]byte
+}
+
+// AckBatchReply holds the reply for the ackbatch command
+type AckBatchReply struct {
+	match.ErrorReply
+}
+
+// AckBatch says the batch for an auction was received, so it doesn't need to be kept any more
+func (cl *OpencxBatcherRPC) AckBatch(args AckBatchArgs, reply *AckBatchReply) (err error) {
+	defer reply.SetError(&err)
+
+	cl.pendingMtx.Lock()
+	if _, ok := cl.pendingBatches[args.AuctionID]; !ok {
+		err = fmt.Errorf("No batch for auction %x to acknowledge", args.AuctionID)
+		cl.pendingMtx.Unlock()
+		return
+	}
+	delete(cl.pendingBatches, args.AuctionID)
+	cl.pendingMtx.Unlock()
+	return
+}
+
+// ActiveAuctionsArgs holds the args for the activeauctions command
+type ActiveAuctionsArgs struct {
+	Pair match.Pair
+}
+
+// ActiveAuctionsReply holds the reply for the activeauctions command
+type ActiveAuctionsReply struct {
+	ActiveAuctions map[[32]byte]time.Time
+	match.ErrorReply
+}
+
+// ActiveAuctions returns the active auctions for a pair and when they started
+func (cl *OpencxBatcherRPC) ActiveAuctions(args ActiveAuctionsArgs, reply *ActiveAuctionsReply) (err error) {
+	defer reply.SetError(&err)
+
+	var batcher match.AuctionBatcher
+	if batcher, err = cl.getBatcher(&args.Pair); err != nil {
+		err = fmt.Errorf("Error getting batcher for ActiveAuctions RPC command: %s", err)
+		return
+	}
+
+	reply.ActiveAuctions = batcher.ActiveAuctions()
+	return
+}
@@ -0,0 +1,47 @@
+package cxbatcherrpc
+
+import (
+	"net"
+	"sync"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BatcherRPCCaller is a listener for batcher RPC commands
+type BatcherRPCCaller struct {
+	caller   *OpencxBatcherRPC
+	listener net.Listener
+	killers  []chan bool
+
+	// authorizedKeys are the only keys allowed to use the batcher, since
+	// anyone who can use it can make it solve puzzles.
+	authorizedKeys []*koblitz.PublicKey
+
+	// isStopped is set once Stop is called, so the accept loop knows to exit
+	isStopped bool
+	stopMtx   sync.Mutex
+}
+
+// OpencxBatcherRPC is what is registered and called. It keeps one batcher per pair,
+// so a single batcher daemon can serve every pair on an exchange.
+type OpencxBatcherRPC struct {
+	batchers     map[match.Pair]match.AuctionBatcher
+	batcherMtx   sync.Mutex
+	maxBatchSize uint64
+	// solverPool is shared by the batchers for every pair
+	solverPool *cxauctionserver.SolverPool
+
+	// pendingBatches holds the batches for auctions that have been ended but haven't been
+	// acknowledged yet, so a batch can be picked up again if the connection drops.
+	pendingBatches map[[32]byte]*pendingBatch
+	pendingMtx     sync.Mutex
+}
+
+// pendingBatch is the result channel for an ended auction, and the batch once it's been received
+type pendingBatch struct {
+	batchChan chan *match.AuctionBatch
+	batch     *match.AuctionBatch
+	mtx       sync.Mutex
+}
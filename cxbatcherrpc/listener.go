@@ -0,0 +1,182 @@
+package cxbatcherrpc
+
+import (
+	"fmt"
+	"net/rpc"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxnoise"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// CreateRPCForBatcher creates a batcher RPC caller which creates batchers with a max batch size of
+// maxBatchSize that solve puzzles with the solver pool, and only accepts connections from the
+// authorized keys.
+func CreateRPCForBatcher(maxBatchSize uint64, solverPool *cxauctionserver.SolverPool, authorizedKeys []*koblitz.PublicKey) (rpc1 *BatcherRPCCaller, err error) {
+	if maxBatchSize == 0 {
+		err = fmt.Errorf("Cannot have a max batch size of 0")
+		return
+	}
+
+	if solverPool == nil {
+		err = fmt.Errorf("Cannot create batcher rpc with a nil solver pool")
+		return
+	}
+
+	if len(authorizedKeys) == 0 {
+		err = fmt.Errorf("Need at least one authorized key, otherwise nobody can use the batcher")
+		return
+	}
+
+	rpc1 = &BatcherRPCCaller{
+		caller: &OpencxBatcherRPC{
+			batchers:       make(map[match.Pair]match.AuctionBatcher),
+			maxBatchSize:   maxBatchSize,
+			solverPool:     solverPool,
+			pendingBatches: make(map[[32]byte]*pendingBatch),
+		},
+		authorizedKeys: authorizedKeys,
+	}
+	return
+}
+
+// NoiseListen is a synchronous version of NoiseListenAsync
+func (rpc1 *BatcherRPCCaller) NoiseListen(privkey *koblitz.PrivateKey, host string, port uint16) (err error) {
+
+	doneChan := make(chan bool, 1)
+	errChan := make(chan error, 1)
+	go rpc1.NoiseListenAsync(doneChan, errChan, privkey, host, port)
+	select {
+	case err = <-errChan:
+	case <-doneChan:
+	}
+
+	return
+}
+
+// NoiseListenAsync listens on socket host and port. The batcher only listens over noise, since
+// it needs to know who is connecting.
+func (rpc1 *BatcherRPCCaller) NoiseListenAsync(doneChan chan bool, errChan chan error, privkey *koblitz.PrivateKey, host string, port uint16) {
+	var err error
+	if rpc1.caller == nil {
+		errChan <- fmt.Errorf("Error, rpc caller cannot be nil, please create caller correctly")
+		close(errChan)
+		return
+	}
+
+	// Start noise rpc server (need to do this since the client is a rpc newclient)
+	noiseRPCServer := rpc.NewServer()
+
+	logging.Infof("Registering batcher RPC API over Noise protocol ...")
+	// Register rpc
+	if err = noiseRPCServer.Register(rpc1.caller); err != nil {
+		errChan <- fmt.Errorf("Error registering RPC Interface: %s", err)
+		close(errChan)
+		return
+	}
+
+	logging.Infof("Starting batcher RPC Server over noise protocol")
+	// Start RPC Server
+	if rpc1.listener, err = cxnoise.NewListener(privkey, int(port)); err != nil {
+		errChan <- fmt.Errorf("Error creating noise listener for NoiseListenAsync: %s", err)
+		close(errChan)
+		return
+	}
+	logging.Infof("Running batcher RPC-Noise server on %s\n", rpc1.listener.Addr().String())
+
+	go rpc1.acceptAuthorized(noiseRPCServer)
+	doneChan <- true
+	close(doneChan)
+	return
+}
+
+// acceptAuthorized accepts noise connections and serves them, but only if the remote key is one
+// of the authorized keys. Unlike rpc.Accept this keeps going if a handshake fails. This should be
+// run in a goroutine.
+func (rpc1 *BatcherRPCCaller) acceptAuthorized(server *rpc.Server) {
+	for {
+		conn, err := rpc1.listener.Accept()
+		if err != nil {
+			if rpc1.stopped() {
+				return
+			}
+			logging.Warnf("Error accepting connection to batcher, continuing: %s", err)
+			continue
+		}
+
+		noiseConn, ok := conn.(*cxnoise.Conn)
+		if !ok {
+			logging.Warnf("Batcher connection is not a noise connection, closing")
+			conn.Close()
+			continue
+		}
+
+		if !rpc1.isAuthorized(noiseConn.RemotePub()) {
+			logging.Warnf("Unauthorized key %x tried to connect to batcher, closing", noiseConn.RemotePub().SerializeCompressed())
+			conn.Close()
+			continue
+		}
+
+		logging.Infof("Authorized key %x connected to batcher", noiseConn.RemotePub().SerializeCompressed())
+		go server.ServeConn(conn)
+	}
+}
+
+// isAuthorized returns true if the pubkey is one of the authorized keys
+func (rpc1 *BatcherRPCCaller) isAuthorized(pubkey *koblitz.PublicKey) (authorized bool) {
+	if pubkey == nil {
+		return
+	}
+	for _, authKey := range rpc1.authorizedKeys {
+		if authKey.IsEqual(pubkey) {
+			authorized = true
+			return
+		}
+	}
+	return
+}
+
+// stopped returns true if Stop has been called
+func (rpc1 *BatcherRPCCaller) stopped() (isStopped bool) {
+	rpc1.stopMtx.Lock()
+	isStopped = rpc1.isStopped
+	rpc1.stopMtx.Unlock()
+	return
+}
+
+// WaitUntilDead waits until the Stop() method is called
+func (rpc1 *BatcherRPCCaller) WaitUntilDead() {
+	dedchan := make(chan bool, 1)
+	rpc1.killers = append(rpc1.killers, dedchan)
+	<-dedchan
+	return
+}
+
+// Stop closes the RPC listener and notifies those from WaitUntilDead
+func (rpc1 *BatcherRPCCaller) Stop() (err error) {
+	if rpc1.listener == nil {
+		err = fmt.Errorf("Error, cannot stop a listener that doesn't exist")
+		return
+	}
+	logging.Infof("Stopping batcher RPC!!")
+	rpc1.stopMtx.Lock()
+	rpc1.isStopped = true
+	rpc1.stopMtx.Unlock()
+	if err = rpc1.listener.Close(); err != nil {
+		err = fmt.Errorf("Error closing listener: %s", err)
+		return
+	}
+	// kill the guy waiting
+	for _, killer := range rpc1.killers {
+		// send the signals, but even if they don't send, close the channel
+		select {
+		case killer <- true:
+			close(killer)
+		default:
+			close(killer)
+		}
+	}
+	return
+}
@@ -0,0 +1,283 @@
+package cxbatcherrpc
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxnoise"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+const (
+	// WaitForBatchRetries is how many more times to wait for a batch if waiting fails because the
+	// connection to the batcher daemon dropped
+	WaitForBatchRetries = 5
+	// WaitForBatchRetryDelay is how long to wait before waiting for a batch again
+	WaitForBatchRetryDelay = 5 * time.Second
+)
+
+// RemoteBatcher is a match.AuctionBatcher that sends everything to a batcher daemon over noise
+// RPC, so puzzle solving can happen on a different machine than the exchange.
+type RemoteBatcher struct {
+	pair match.Pair
+	Conn *BatcherConn
+}
+
+// A compile-time assertion to make sure RemoteBatcher is an AuctionBatcher
+var _ match.AuctionBatcher = (*RemoteBatcher)(nil)
+
+// BatcherConn is a noise RPC connection to a batcher daemon, which is dialed again on the next
+// call if it drops. It can be shared between many remote batchers.
+type BatcherConn struct {
+	privkey *koblitz.PrivateKey
+	addr    string
+	client  *rpc.Client
+	mtx     sync.Mutex
+}
+
+// DialBatcher creates a noise RPC connection to a batcher daemon
+func DialBatcher(privkey *koblitz.PrivateKey, host string, port uint16) (conn *BatcherConn, err error) {
+	if privkey == nil {
+		err = fmt.Errorf("Please set the key for the noise client to dial the batcher")
+		return
+	}
+
+	conn = &BatcherConn{
+		privkey: privkey,
+		addr:    net.JoinHostPort(host, fmt.Sprintf("%d", port)),
+	}
+	if _, err = conn.getClient(); err != nil {
+		err = fmt.Errorf("Error dialing batcher for DialBatcher: %s", err)
+		return
+	}
+	return
+}
+
+// getClient returns the RPC client, dialing the batcher if there isn't a connection
+func (bc *BatcherConn) getClient() (client *rpc.Client, err error) {
+	bc.mtx.Lock()
+	if bc.client == nil {
+		var clientConn *cxnoise.Conn
+		if clientConn, err = cxnoise.Dial(bc.privkey, bc.addr, []byte("opencx"), net.Dial); err != nil {
+			err = fmt.Errorf("Error dialing batcher at %s: %s", bc.addr, err)
+			bc.mtx.Unlock()
+			return
+		}
+		bc.client = rpc.NewClient(clientConn)
+	}
+	client = bc.client
+	bc.mtx.Unlock()
+	return
+}
+
+// Call calls a method on the batcher daemon. If the call fails because of the connection rather
+// than the daemon, the connection is closed and dialed again on the next call. Calls aren't
+// retried here, since most of them aren't safe to do twice. Errors with a code come back in the
+// reply, and are returned with their code.
+func (bc *BatcherConn) Call(serviceMethod string, args interface{}, reply interface{}) (err error) {
+	var client *rpc.Client
+	if client, err = bc.getClient(); err != nil {
+		return
+	}
+
+	if err = client.Call(serviceMethod, args, reply); err != nil {
+		if _, ok := err.(rpc.ServerError); !ok {
+			bc.mtx.Lock()
+			if bc.client == client {
+				bc.client.Close()
+				bc.client = nil
+			}
+			bc.mtx.Unlock()
+		}
+		return
+	}
+	err = match.ReplyError(reply)
+	return
+}
+
+// Close closes the connection to the batcher daemon
+func (bc *BatcherConn) Close() (err error) {
+	bc.mtx.Lock()
+	if bc.client != nil {
+		err = bc.client.Close()
+		bc.client = nil
+	}
+	bc.mtx.Unlock()
+	return
+}
+
+// NewRemoteBatcher creates a remote batcher for a pair, using an existing connection to the
+// batcher daemon.
+func NewRemoteBatcher(pair *match.Pair, conn *BatcherConn) (batcher *RemoteBatcher, err error) {
+	if conn == nil {
+		err = fmt.Errorf("Cannot create remote batcher with nil connection")
+		return
+	}
+	batcher = &RemoteBatcher{
+		pair: *pair,
+		Conn: conn,
+	}
+	return
+}
+
+// CreateRemoteBatcherMap creates a remote batcher for every pair, all sharing one connection to
+// the batcher daemon.
+func CreateRemoteBatcherMap(pairList []*match.Pair, privkey *koblitz.PrivateKey, host string, port uint16) (batchers map[match.Pair]match.AuctionBatcher, err error) {
+	batchers = make(map[match.Pair]match.AuctionBatcher)
+
+	var conn *BatcherConn
+	if conn, err = DialBatcher(privkey, host, port); err != nil {
+		err = fmt.Errorf("Error dialing batcher for CreateRemoteBatcherMap: %s", err)
+		return
+	}
+
+	var currBatcher *RemoteBatcher
+	for _, pair := range pairList {
+		if currBatcher, err = NewRemoteBatcher(pair, conn); err != nil {
+			err = fmt.Errorf("Error creating remote batcher for %s pair: %s", pair.String(), err)
+			return
+		}
+		batchers[*pair] = currBatcher
+	}
+
+	return
+}
+
+// RegisterAuction registers a new auction with a specified Auction ID on the batcher daemon
+func (rb *RemoteBatcher) RegisterAuction(auctionID [32]byte) (err error) {
+	registerArgs := &RegisterAuctionArgs{
+		Pair:      rb.pair,
+		AuctionID: auctionID,
+	}
+	registerReply := new(RegisterAuctionReply)
+
+	if err = rb.Conn.Call("OpencxBatcherRPC.RegisterAuction", registerArgs, registerReply); err != nil {
+		err = fmt.Errorf("Error registering auction with remote batcher: %s", err)
+		return
+	}
+
+	return
+}
+
+// AddEncrypted sends an encrypted order to the batcher daemon to be solved. This should error if
+// either the auction doesn't exist, or the auction is ended.
+func (rb *RemoteBatcher) AddEncrypted(order *match.EncryptedAuctionOrder) (err error) {
+	addArgs := &AddEncryptedArgs{
+		Pair: rb.pair,
+	}
+	addReply := new(AddEncryptedReply)
+
+	if addArgs.EncryptedOrderBytes, err = order.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing encrypted order for remote batcher: %s", err)
+		return
+	}
+
+	if err = rb.Conn.Call("OpencxBatcherRPC.AddEncrypted", addArgs, addReply); err != nil {
+		err = match.Wrapf(err, "Error adding encrypted order to remote batcher: %s", err)
+		return
+	}
+
+	return
+}
+
+// EndAuction ends the auction on the batcher daemon, and returns a channel which will receive the
+// batch once the daemon has solved every puzzle. The channel has size 1.
+func (rb *RemoteBatcher) EndAuction(auctionID [32]byte) (batchChan chan *match.AuctionBatch, err error) {
+	endArgs := &EndAuctionArgs{
+		Pair:      rb.pair,
+		AuctionID: auctionID,
+	}
+	endReply := new(EndAuctionReply)
+
+	if err = rb.Conn.Call("OpencxBatcherRPC.EndAuction", endArgs, endReply); err != nil {
+		err = fmt.Errorf("Error ending auction on remote batcher: %s", err)
+		return
+	}
+
+	batchChan = make(chan *match.AuctionBatch, 1)
+	go rb.waitForBatch(auctionID, batchChan)
+
+	return
+}
+
+// waitForBatch waits for the batch for an auction and sends it to the batch channel. If the
+// connection drops it waits again, and if it still can't get the batch, the batch is sent with
+// its error set, so nobody waits forever and nobody mistakes it for an empty auction.
+// This should be run in a goroutine.
+func (rb *RemoteBatcher) waitForBatch(auctionID [32]byte, batchChan chan *match.AuctionBatch) {
+	var err error
+	batch := &match.AuctionBatch{
+		AuctionID: auctionID,
+	}
+
+	defer func() {
+		if err != nil {
+			logging.Errorf("Error waiting for batch from remote batcher: %s", err)
+			batch.Batch = nil
+			batch.Err = err
+		}
+		batchChan <- batch
+	}()
+
+	waitArgs := &WaitForBatchArgs{
+		AuctionID: auctionID,
+	}
+	var waitReply *WaitForBatchReply
+	for attempt := 0; ; attempt++ {
+		waitReply = new(WaitForBatchReply)
+		if err = rb.Conn.Call("OpencxBatcherRPC.WaitForBatch", waitArgs, waitReply); err == nil {
+			break
+		}
+		if _, ok := err.(rpc.ServerError); ok || attempt >= WaitForBatchRetries {
+			err = fmt.Errorf("Error waiting for batch %x: %s", auctionID, err)
+			return
+		}
+		logging.Warnf("Lost connection waiting for batch %x, waiting again (%d/%d): %s", auctionID, attempt+1, WaitForBatchRetries, err)
+		time.Sleep(WaitForBatchRetryDelay)
+	}
+
+	var res *match.OrderPuzzleResult
+	for _, remoteRes := range waitReply.Results {
+		if res, err = remoteRes.toResult(); err != nil {
+			err = fmt.Errorf("Error converting remote result for batch %x: %s", auctionID, err)
+			return
+		}
+		batch.Batch = append(batch.Batch, res)
+	}
+
+	// The daemon keeps the batch until we say we have it
+	ackArgs := &AckBatchArgs{
+		AuctionID: auctionID,
+	}
+	if ackErr := rb.Conn.Call("OpencxBatcherRPC.AckBatch", ackArgs, new(AckBatchReply)); ackErr != nil {
+		logging.Warnf("Error acknowledging batch %x, the batcher will keep it: %s", auctionID, ackErr)
+	}
+
+	return
+}
+
+// ActiveAuctions returns a map of auction id to time for this pair on the batcher daemon. If
+// the daemon can't be reached this returns no auctions.
+func (rb *RemoteBatcher) ActiveAuctions() (activeBatches map[[32]byte]time.Time) {
+	activeArgs := &ActiveAuctionsArgs{
+		Pair: rb.pair,
+	}
+	activeReply := new(ActiveAuctionsReply)
+
+	if err := rb.Conn.Call("OpencxBatcherRPC.ActiveAuctions", activeArgs, activeReply); err != nil {
+		logging.Errorf("Error getting active auctions from remote batcher: %s", err)
+		activeBatches = make(map[[32]byte]time.Time)
+		return
+	}
+
+	activeBatches = activeReply.ActiveAuctions
+	if activeBatches == nil {
+		activeBatches = make(map[[32]byte]time.Time)
+	}
+	return
+}
@@ -0,0 +1,275 @@
+package cxbatcherrpc
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	testPair = match.Pair{
+		AssetWant: match.Asset(6),
+		AssetHave: match.Asset(8),
+	}
+	testAuctionID  = [32]byte{0xde, 0xad, 0xbe, 0xef}
+	testBatchSize  = uint64(100)
+	testOrderBytes = (&match.AuctionOrder{
+		Side:        "buy",
+		TradingPair: testPair,
+		AmountWant:  100000,
+		AmountHave:  10000,
+		AuctionID:   testAuctionID,
+	}).Serialize()
+)
+
+// startTestBatcher starts a batcher that only authorizes the client key, returning the port it's listening on
+func startTestBatcher(clientKey *koblitz.PrivateKey) (rpc1 *BatcherRPCCaller, port uint16, err error) {
+	var serverKey *koblitz.PrivateKey
+	if serverKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error creating batcher key: %s", err)
+		return
+	}
+
+	var pool *cxauctionserver.SolverPool
+	if pool, err = cxauctionserver.NewSolverPool(1, testBatchSize); err != nil {
+		err = fmt.Errorf("Error creating solver pool: %s", err)
+		return
+	}
+
+	if rpc1, err = CreateRPCForBatcher(testBatchSize, pool, []*koblitz.PublicKey{clientKey.PubKey()}); err != nil {
+		err = fmt.Errorf("Error creating batcher rpc: %s", err)
+		return
+	}
+
+	if err = rpc1.NoiseListen(serverKey, "localhost", 0); err != nil {
+		err = fmt.Errorf("Error listening for batcher rpc: %s", err)
+		return
+	}
+
+	var portStr string
+	if _, portStr, err = net.SplitHostPort(rpc1.listener.Addr().String()); err != nil {
+		err = fmt.Errorf("Error getting batcher port: %s", err)
+		return
+	}
+
+	var port64 uint64
+	if port64, err = strconv.ParseUint(portStr, 10, 16); err != nil {
+		err = fmt.Errorf("Error parsing batcher port: %s", err)
+		return
+	}
+	port = uint16(port64)
+
+	return
+}
+
+func TestRemoteResultRoundTrip(t *testing.T) {
+	var err error
+
+	res := &match.OrderPuzzleResult{
+		Auction: new(match.AuctionOrder),
+		Err:     fmt.Errorf("test error"),
+	}
+	if err = res.Auction.Deserialize(testOrderBytes); err != nil {
+		t.Errorf("Error deserializing test order: %s", err)
+		return
+	}
+
+	var remoteRes *RemoteOrderPuzzleResult
+	if remoteRes, err = resultToRemote(res); err != nil {
+		t.Errorf("Error converting result to remote: %s", err)
+		return
+	}
+
+	var roundTrip *match.OrderPuzzleResult
+	if roundTrip, err = remoteRes.toResult(); err != nil {
+		t.Errorf("Error converting remote to result: %s", err)
+		return
+	}
+
+	if roundTrip.Err == nil || roundTrip.Err.Error() != res.Err.Error() {
+		t.Errorf("Error did not survive round trip, got %v", roundTrip.Err)
+	}
+	if roundTrip.Auction == nil || roundTrip.Auction.AmountHave != res.Auction.AmountHave {
+		t.Errorf("Auction order did not survive round trip")
+	}
+	if roundTrip.Encrypted != nil {
+		t.Errorf("Encrypted order should be nil after round trip since it was nil before")
+	}
+
+	return
+}
+
+func TestRemoteBatcherEmptyAuction(t *testing.T) {
+	var err error
+
+	var clientKey *koblitz.PrivateKey
+	if clientKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating client key: %s", err)
+		return
+	}
+
+	var rpc1 *BatcherRPCCaller
+	var port uint16
+	if rpc1, port, err = startTestBatcher(clientKey); err != nil {
+		t.Errorf("Error starting test batcher: %s", err)
+		return
+	}
+	defer rpc1.Stop()
+
+	var conn *BatcherConn
+	if conn, err = DialBatcher(clientKey, "localhost", port); err != nil {
+		t.Errorf("Error dialing test batcher: %s", err)
+		return
+	}
+
+	var batcher *RemoteBatcher
+	if batcher, err = NewRemoteBatcher(&testPair, conn); err != nil {
+		t.Errorf("Error creating remote batcher: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(testAuctionID); err != nil {
+		t.Errorf("Error registering auction on remote batcher: %s", err)
+		return
+	}
+
+	if _, ok := batcher.ActiveAuctions()[testAuctionID]; !ok {
+		t.Errorf("Registered auction should be active")
+		return
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(testAuctionID); err != nil {
+		t.Errorf("Error ending auction on remote batcher: %s", err)
+		return
+	}
+
+	if _, ok := batcher.ActiveAuctions()[testAuctionID]; ok {
+		t.Errorf("Ended auction should not be active")
+		return
+	}
+
+	// make sure we can't end it twice
+	if _, err = batcher.EndAuction(testAuctionID); err == nil {
+		t.Errorf("Ending an auction twice should fail")
+		return
+	}
+
+	// An auction with no orders should still produce an empty batch
+	select {
+	case batch := <-batchChan:
+		if batch.AuctionID != testAuctionID {
+			t.Errorf("Batch has auction ID %x, expected %x", batch.AuctionID, testAuctionID)
+		}
+		if len(batch.Batch) != 0 {
+			t.Errorf("Batch for empty auction should be empty, has %d orders", len(batch.Batch))
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for batch of empty auction")
+	}
+
+	return
+}
+
+func TestRemoteBatcherUnauthorized(t *testing.T) {
+	var err error
+
+	var clientKey *koblitz.PrivateKey
+	if clientKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating client key: %s", err)
+		return
+	}
+	var otherKey *koblitz.PrivateKey
+	if otherKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating other key: %s", err)
+		return
+	}
+
+	var rpc1 *BatcherRPCCaller
+	var port uint16
+	if rpc1, port, err = startTestBatcher(clientKey); err != nil {
+		t.Errorf("Error starting test batcher: %s", err)
+		return
+	}
+	defer rpc1.Stop()
+
+	var conn *BatcherConn
+	if conn, err = DialBatcher(otherKey, "localhost", port); err != nil {
+		// the handshake might fail since the connection is closed, that's fine
+		return
+	}
+
+	var batcher *RemoteBatcher
+	if batcher, err = NewRemoteBatcher(&testPair, conn); err != nil {
+		t.Errorf("Error creating remote batcher: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(testAuctionID); err == nil {
+		t.Errorf("Unauthorized key should not be able to register auctions")
+	}
+
+	return
+}
+
+func TestWaitForBatchUntilAck(t *testing.T) {
+	var err error
+
+	var clientKey *koblitz.PrivateKey
+	if clientKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating client key: %s", err)
+		return
+	}
+
+	var pool *cxauctionserver.SolverPool
+	if pool, err = cxauctionserver.NewSolverPool(1, testBatchSize); err != nil {
+		t.Errorf("Error creating solver pool: %s", err)
+		return
+	}
+
+	var rpc1 *BatcherRPCCaller
+	if rpc1, err = CreateRPCForBatcher(testBatchSize, pool, []*koblitz.PublicKey{clientKey.PubKey()}); err != nil {
+		t.Errorf("Error creating batcher rpc: %s", err)
+		return
+	}
+	cl := rpc1.caller
+
+	if err = cl.RegisterAuction(RegisterAuctionArgs{Pair: testPair, AuctionID: testAuctionID}, new(RegisterAuctionReply)); err != nil {
+		t.Errorf("Error registering auction: %s", err)
+		return
+	}
+	if err = cl.EndAuction(EndAuctionArgs{Pair: testPair, AuctionID: testAuctionID}, new(EndAuctionReply)); err != nil {
+		t.Errorf("Error ending auction: %s", err)
+		return
+	}
+
+	// if a reply gets lost the batch can be waited for again
+	for i := 0; i < 2; i++ {
+		reply := new(WaitForBatchReply)
+		if err = cl.WaitForBatch(WaitForBatchArgs{AuctionID: testAuctionID}, reply); err != nil {
+			t.Errorf("Error waiting for batch the %d time: %s", i+1, err)
+			return
+		}
+		if reply.AuctionID != testAuctionID {
+			t.Errorf("Batch has auction ID %x, expected %x", reply.AuctionID, testAuctionID)
+			return
+		}
+	}
+
+	if err = cl.AckBatch(AckBatchArgs{AuctionID: testAuctionID}, new(AckBatchReply)); err != nil {
+		t.Errorf("Error acknowledging batch: %s", err)
+		return
+	}
+	if err = cl.WaitForBatch(WaitForBatchArgs{AuctionID: testAuctionID}, new(WaitForBatchReply)); err == nil {
+		t.Errorf("Acknowledged batch should not be kept")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,76 @@
+package cxbatcherrpc
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// RemoteOrderPuzzleResult is a match.OrderPuzzleResult that can be sent over the wire. Errors
+// can't be encoded with gob, so we send the error message instead.
+type RemoteOrderPuzzleResult struct {
+	// Use the serialize method on match.EncryptedAuctionOrder
+	EncryptedOrderBytes []byte
+	// Use the serialize method on match.AuctionOrder, this is empty if the puzzle couldn't be solved
+	AuctionOrderBytes []byte
+	// Solution is the Y a proof can be made from, if the puzzle was an RSW puzzle that was solved
+	Solution *big.Int
+	Err      string
+}
+
+// resultToRemote converts a puzzle result into something that can be sent over the wire
+func resultToRemote(res *match.OrderPuzzleResult) (remoteRes *RemoteOrderPuzzleResult, err error) {
+	if res == nil {
+		err = fmt.Errorf("Cannot convert nil result")
+		return
+	}
+
+	remoteRes = new(RemoteOrderPuzzleResult)
+	if res.Encrypted != nil {
+		if remoteRes.EncryptedOrderBytes, err = res.Encrypted.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing encrypted order for result: %s", err)
+			return
+		}
+	}
+
+	if res.Auction != nil {
+		remoteRes.AuctionOrderBytes = res.Auction.Serialize()
+	}
+
+	remoteRes.Solution = res.Solution
+
+	if res.Err != nil {
+		remoteRes.Err = res.Err.Error()
+	}
+
+	return
+}
+
+// toResult converts the remote result back into a puzzle result
+func (remoteRes *RemoteOrderPuzzleResult) toResult() (res *match.OrderPuzzleResult, err error) {
+	res = new(match.OrderPuzzleResult)
+	if len(remoteRes.EncryptedOrderBytes) != 0 {
+		res.Encrypted = new(match.EncryptedAuctionOrder)
+		if err = res.Encrypted.Deserialize(remoteRes.EncryptedOrderBytes); err != nil {
+			err = fmt.Errorf("Error deserializing encrypted order from remote result: %s", err)
+			return
+		}
+	}
+
+	if len(remoteRes.AuctionOrderBytes) != 0 {
+		res.Auction = new(match.AuctionOrder)
+		if err = res.Auction.Deserialize(remoteRes.AuctionOrderBytes); err != nil {
+			err = fmt.Errorf("Error deserializing auction order from remote result: %s", err)
+			return
+		}
+	}
+
+	res.Solution = remoteRes.Solution
+
+	if remoteRes.Err != "" {
+		res.Err = fmt.Errorf("%s", remoteRes.Err)
+	}
+
+	return
+}
@@ -176,6 +176,12 @@ func createLightAuctionServer(coinList []*coinparam.Params, whitelist []*koblitz
 	}
 
 	logging.Infof("Creating stores...")
+	var setStores map[*coinparam.Params]cxdb.SettlementStore
+	if setStores, err = cxdbmemory.CreateSettlementStoreMap(coinList); err != nil {
+		err = fmt.Errorf("Error creating settlement store map for createLightAuctionServer: %s", err)
+		return
+	}
+
 	var aucBooks map[match.Pair]match.AuctionOrderbook
 	if aucBooks, err = cxdbsql.CreateAuctionOrderbookMap(pairList); err != nil {
 		err = fmt.Errorf("Error creating auction orderbook map for createLightAuctionServer: %s", err)
@@ -196,7 +202,7 @@ func createLightAuctionServer(coinList []*coinparam.Params, whitelist []*koblitz
 
 	// orderChanSize = 100 because uh why not?
 	var ocxServer *cxauctionserver.OpencxAuctionServer
-	if ocxServer, err = cxauctionserver.InitServer(setEngines, mengines, aucBooks, pzEngines, batchers, 100, auctionTime); err != nil {
+	if ocxServer, err = cxauctionserver.InitServer(setEngines, mengines, aucBooks, pzEngines, setStores, batchers, 100, auctionTime); err != nil {
 		err = fmt.Errorf("Error initializing server for createLightAuctionServer: %s", err)
 		return
 	}
@@ -3,7 +3,6 @@ package cxbenchmark
 import (
 	"fmt"
 
-	"github.com/btcsuite/golangcrypto/sha3"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/benchclient"
 	"github.com/mit-dci/opencx/cxauctionrpc"
@@ -52,37 +51,9 @@ func SetupBenchmarkClientWithoutKey(authrpc bool) (client *benchclient.BenchClie
 	return
 }
 
-// signBytes is used in the register method because that's an interactive process.
-// BenchClient shouldn't be responsible for interactive stuff, just providing a good
-// Go API for the RPC methods the exchange offers.
-func signBytes(client *benchclient.BenchClient, bytes []byte) (signature []byte, err error) {
-
-	sha := sha3.New256()
-	sha.Write(bytes)
-	e := sha.Sum(nil)
-
-	if signature, err = koblitz.SignCompact(koblitz.S256(), client.PrivKey, e, false); err != nil {
-		err = fmt.Errorf("Failed to sign bytes : \n%s", err)
-		return
-	}
-
-	return
-}
-
 func registerClient(client *benchclient.BenchClient) (err error) {
-	// Register the clients
-	var regStringReply *cxrpc.GetRegistrationStringReply
-	if regStringReply, err = client.GetRegistrationString(); err != nil {
-		return
-	}
-
-	var sig []byte
-	if sig, err = signBytes(client, []byte(regStringReply.RegistrationString)); err != nil {
-		return
-	}
-
-	// we don't really care about the reply
-	if _, err = client.Register(sig); err != nil {
+	// Register the clients, we don't really care about the reply
+	if _, err = client.Register(); err != nil {
 		return
 	}
 
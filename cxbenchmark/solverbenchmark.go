@@ -0,0 +1,82 @@
+package cxbenchmark
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+// createSolverBenchOrders creates howMany encrypted orders for the same auction, with puzzles that
+// take t squarings to solve.
+func createSolverBenchOrders(howMany int, t uint64, auctionID [32]byte) (orders []*match.EncryptedAuctionOrder, err error) {
+	baseOrder := &match.AuctionOrder{
+		AuctionID:  auctionID,
+		AmountWant: 100000,
+		AmountHave: 10000,
+		Side:       "buy",
+		TradingPair: match.Pair{
+			AssetWant: match.Asset(6),
+			AssetHave: match.Asset(8),
+		},
+	}
+
+	var encrypted *match.EncryptedAuctionOrder
+	for i := 0; i < howMany; i++ {
+		if encrypted, err = baseOrder.TurnIntoEncryptedOrder(t); err != nil {
+			err = fmt.Errorf("Error creating encrypted order for solver benchmark: %s", err)
+			return
+		}
+		encrypted.IntendedAuction = auctionID
+		encrypted.IntendedPair = baseOrder.TradingPair
+		orders = append(orders, encrypted)
+	}
+
+	return
+}
+
+// SolveBatchWithPool adds every order to an auction on a batcher that uses the solver pool, ends
+// the auction, and waits for the batch.
+func SolveBatchWithPool(pool *cxauctionserver.SolverPool, orders []*match.EncryptedAuctionOrder, auctionID [32]byte) (err error) {
+	var batcher *cxauctionserver.ABatcher
+	if batcher, err = cxauctionserver.NewABatcherWithPool(uint64(len(orders)), pool); err != nil {
+		err = fmt.Errorf("Error creating batcher for SolveBatchWithPool: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(auctionID); err != nil {
+		err = fmt.Errorf("Error registering auction for SolveBatchWithPool: %s", err)
+		return
+	}
+
+	for _, order := range orders {
+		if err = batcher.AddEncrypted(order); err != nil {
+			err = fmt.Errorf("Error adding order for SolveBatchWithPool: %s", err)
+			return
+		}
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(auctionID); err != nil {
+		err = fmt.Errorf("Error ending auction for SolveBatchWithPool: %s", err)
+		return
+	}
+
+	select {
+	case batch := <-batchChan:
+		if batch.Err != nil {
+			err = fmt.Errorf("Error solving batch for SolveBatchWithPool: %s", batch.Err)
+			return
+		}
+		if len(batch.Batch) != len(orders) {
+			err = fmt.Errorf("Expected %d orders in batch, got %d", len(orders), len(batch.Batch))
+			return
+		}
+	case <-time.After(10 * time.Minute):
+		err = fmt.Errorf("Timed out waiting for batch in SolveBatchWithPool")
+		return
+	}
+
+	return
+}
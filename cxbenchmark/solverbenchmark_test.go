@@ -0,0 +1,51 @@
+package cxbenchmark
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+// BenchmarkSolverPool solves batches of orders with different numbers of solver workers, and
+// reports the average solve latency and queue wait for each.
+func BenchmarkSolverPool(b *testing.B) {
+	var err error
+
+	auctionID := [32]byte{0xde, 0xad, 0xbe, 0xef}
+	numOrders := 32
+
+	var orders []*match.EncryptedAuctionOrder
+	if orders, err = createSolverBenchOrders(numOrders, 100000, auctionID); err != nil {
+		b.Errorf("Error creating orders for BenchmarkSolverPool: %s", err)
+		return
+	}
+
+	workerCounts := []uint64{1, 2, uint64(runtime.NumCPU())}
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("Workers%d", workers), func(b *testing.B) {
+			var pool *cxauctionserver.SolverPool
+			if pool, err = cxauctionserver.NewSolverPool(workers, uint64(numOrders)); err != nil {
+				b.Errorf("Error creating solver pool: %s", err)
+				return
+			}
+			defer pool.Stop()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err = SolveBatchWithPool(pool, orders, auctionID); err != nil {
+					b.Errorf("Error solving batch: %s", err)
+					return
+				}
+			}
+			b.StopTimer()
+
+			stats := pool.Stats()
+			b.Logf("%d workers: %d puzzles, average solve %s, max solve %s, average wait %s", workers, stats.Solved, stats.AverageSolveTime(), stats.MaxSolveTime, stats.AverageWaitTime())
+		})
+	}
+
+	return
+}
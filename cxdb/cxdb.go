@@ -1,6 +1,8 @@
 package cxdb
 
 import (
+	"fmt"
+
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/match"
 )
@@ -11,6 +13,13 @@ type SettlementStore interface {
 	UpdateBalances(settlementExecs []*match.SettlementResult) (err error)
 	// GetBalance gets the balance for a pubkey and an asset.
 	GetBalance(pubkey *koblitz.PublicKey) (balance uint64, err error)
+	// GetAllBalances gets the balance of every pubkey for the asset, keyed by compressed pubkey.
+	GetAllBalances() (balances map[[33]byte]uint64, err error)
+	// RecordBond records a bond taken for an order commitment, or updates its status once it's
+	// given back or forfeited.
+	RecordBond(bond *match.Bond) (err error)
+	// GetBonds gets every bond for the asset with the status.
+	GetBonds(status match.BondStatus) (bonds []*match.Bond, err error)
 }
 
 type DepositStore interface {
@@ -33,3 +42,27 @@ type PuzzleStore interface {
 	// PlaceAuctionPuzzle puts an encrypted auction order in the datastore.
 	PlaceAuctionPuzzle(puzzledOrder *match.EncryptedAuctionOrder) (err error)
 }
+
+// HandlerDestroyer is a store, engine, or orderbook with a DB handler that needs to be closed when
+// the server shuts down
+type HandlerDestroyer interface {
+	// DestroyHandler closes the DB handler
+	DestroyHandler() (err error)
+}
+
+// DestroyHandlers destroys the handler of everything that has one. Things that share a handler
+// only get destroyed once. It keeps going if one fails, and returns the first error.
+func DestroyHandlers(handlers []interface{}) (err error) {
+	destroyed := make(map[HandlerDestroyer]bool)
+	for _, handler := range handlers {
+		destroyer, ok := handler.(HandlerDestroyer)
+		if !ok || destroyed[destroyer] {
+			continue
+		}
+		destroyed[destroyer] = true
+		if destroyErr := destroyer.DestroyHandler(); destroyErr != nil && err == nil {
+			err = fmt.Errorf("Error destroying handler for DestroyHandlers: %s", destroyErr)
+		}
+	}
+	return
+}
@@ -63,7 +63,8 @@ func (me *MemorySettlementEngine) ApplySettlementExecution(setExec *match.Settle
 	return
 }
 
-// CheckValid returns true if the settlement execution would be valid
+// CheckValid returns true if the settlement execution would be valid. A credit is only valid if
+// the user has at least the amount, so applying it can't take their balance below zero.
 func (me *MemorySettlementEngine) CheckValid(setExec *match.SettlementExecution) (valid bool, err error) {
 	if setExec.Type == match.Debit {
 		// No settlement will be an invalid debit
@@ -73,7 +74,7 @@ func (me *MemorySettlementEngine) CheckValid(setExec *match.SettlementExecution)
 	me.balancesMtx.Lock()
 	curBal := me.balances[setExec.Pubkey]
 	me.balancesMtx.Unlock()
-	valid = setExec.Amount > curBal
+	valid = setExec.Amount <= curBal
 	return
 }
 
@@ -0,0 +1,70 @@
+package cxdbmemory
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestMemorySettlementCheckValid makes sure a credit is only valid if the user has enough to pay
+// for it, and a debit is always valid
+func TestMemorySettlementCheckValid(t *testing.T) {
+	var err error
+
+	var engine match.SettlementEngine
+	if engine, err = CreateSettlementEngine(&coinparam.BitcoinParams); err != nil {
+		t.Errorf("Error creating memory settlement engine for TestMemorySettlementCheckValid: %s", err)
+		return
+	}
+
+	fund := &match.SettlementExecution{
+		Pubkey: [33]byte{},
+		Amount: uint64(1000),
+		Asset:  btc,
+		Type:   match.Debit,
+	}
+
+	var valid bool
+	if valid, err = engine.CheckValid(fund); err != nil {
+		t.Errorf("Error checking valid debit for TestMemorySettlementCheckValid: %s", err)
+		return
+	}
+	if !valid {
+		t.Errorf("Debit should always be valid")
+		return
+	}
+
+	if _, err = engine.ApplySettlementExecution(fund); err != nil {
+		t.Errorf("Error applying debit for TestMemorySettlementCheckValid: %s", err)
+		return
+	}
+
+	// Spending exactly the balance is fine, one more is not
+	checks := []struct {
+		amount   uint64
+		expected bool
+	}{
+		{amount: 1, expected: true},
+		{amount: 1000, expected: true},
+		{amount: 1001, expected: false},
+	}
+	for _, check := range checks {
+		spend := &match.SettlementExecution{
+			Pubkey: [33]byte{},
+			Amount: check.amount,
+			Asset:  btc,
+			Type:   match.Credit,
+		}
+		if valid, err = engine.CheckValid(spend); err != nil {
+			t.Errorf("Error checking valid credit for TestMemorySettlementCheckValid: %s", err)
+			return
+		}
+		if valid != check.expected {
+			t.Errorf("Credit of %d with a balance of 1000 should have been %t but was %t", check.amount, check.expected, valid)
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,127 @@
+package cxdbmemory
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MemorySettlementStore keeps the readable balances and bonds for a coin in memory
+type MemorySettlementStore struct {
+	// Balances
+	balances map[[33]byte]uint64
+	// Bonds by commitment and auction
+	bonds map[bondKey]*match.Bond
+	mtx   *sync.Mutex
+
+	// this coin
+	coin *coinparam.Params
+}
+
+// bondKey is what bonds are stored by, since the same commitment can be placed in more than one
+// auction
+type bondKey struct {
+	commitment [32]byte
+	auctionID  [32]byte
+}
+
+// CreateSettlementStore creates a settlement store for a specific coin
+func CreateSettlementStore(coin *coinparam.Params) (store cxdb.SettlementStore, err error) {
+
+	// Set values
+	ms := &MemorySettlementStore{
+		balances: make(map[[33]byte]uint64),
+		bonds:    make(map[bondKey]*match.Bond),
+		mtx:      new(sync.Mutex),
+		coin:     coin,
+	}
+
+	// Now we actually set what we want
+	store = ms
+	return
+}
+
+// UpdateBalances updates the balances from the settlement executions
+func (ms *MemorySettlementStore) UpdateBalances(settlementResults []*match.SettlementResult) (err error) {
+	ms.mtx.Lock()
+	for _, setResult := range settlementResults {
+		ms.balances[setResult.SuccessfulExec.Pubkey] = setResult.NewBal
+	}
+	ms.mtx.Unlock()
+	return
+}
+
+// GetBalance gets the balance for a pubkey and an asset.
+func (ms *MemorySettlementStore) GetBalance(pubkey *koblitz.PublicKey) (balance uint64, err error) {
+	var pubkeyBytes [33]byte
+	copy(pubkeyBytes[:], pubkey.SerializeCompressed())
+
+	ms.mtx.Lock()
+	var ok bool
+	if balance, ok = ms.balances[pubkeyBytes]; !ok {
+		err = fmt.Errorf("No balance for pubkey %x", pubkeyBytes)
+		ms.mtx.Unlock()
+		return
+	}
+	ms.mtx.Unlock()
+	return
+}
+
+// GetAllBalances gets the balance of every pubkey for the asset, keyed by compressed pubkey.
+func (ms *MemorySettlementStore) GetAllBalances() (balances map[[33]byte]uint64, err error) {
+	balances = make(map[[33]byte]uint64)
+	ms.mtx.Lock()
+	for pubkey, balance := range ms.balances {
+		balances[pubkey] = balance
+	}
+	ms.mtx.Unlock()
+	return
+}
+
+// RecordBond records a bond taken for an order commitment, or updates its status once it's
+// given back or forfeited.
+func (ms *MemorySettlementStore) RecordBond(bond *match.Bond) (err error) {
+	if bond == nil {
+		err = fmt.Errorf("Cannot record nil bond")
+		return
+	}
+
+	bondCopy := *bond
+	ms.mtx.Lock()
+	ms.bonds[bondKey{commitment: bond.Commitment, auctionID: bond.AuctionID}] = &bondCopy
+	ms.mtx.Unlock()
+	return
+}
+
+// GetBonds gets every bond for the asset with the status.
+func (ms *MemorySettlementStore) GetBonds(status match.BondStatus) (bonds []*match.Bond, err error) {
+	ms.mtx.Lock()
+	for _, bond := range ms.bonds {
+		if bond.Status == status {
+			bondCopy := *bond
+			bonds = append(bonds, &bondCopy)
+		}
+	}
+	ms.mtx.Unlock()
+	return
+}
+
+// CreateSettlementStoreMap creates a map of coin to settlement store, given a list of coins.
+func CreateSettlementStoreMap(coins []*coinparam.Params) (setMap map[*coinparam.Params]cxdb.SettlementStore, err error) {
+
+	setMap = make(map[*coinparam.Params]cxdb.SettlementStore)
+	var curSetStore cxdb.SettlementStore
+	for _, coin := range coins {
+		if curSetStore, err = CreateSettlementStore(coin); err != nil {
+			err = fmt.Errorf("Error creating single settlement store while creating settlement store map: %s", err)
+			return
+		}
+		setMap[coin] = curSetStore
+	}
+
+	return
+}
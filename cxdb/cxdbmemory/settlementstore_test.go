@@ -0,0 +1,66 @@
+package cxdbmemory
+
+import (
+	"testing"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestMemorySettlementStoreBonds makes sure recording a bond again updates its status, and that
+// the same commitment in two auctions is two bonds
+func TestMemorySettlementStoreBonds(t *testing.T) {
+	var err error
+
+	var store cxdb.SettlementStore
+	if store, err = CreateSettlementStore(&coinparam.BitcoinParams); err != nil {
+		t.Errorf("Error creating memory settlement store for TestMemorySettlementStoreBonds: %s", err)
+		return
+	}
+
+	first := &match.Bond{
+		Commitment: [32]byte{0x01},
+		AuctionID:  [32]byte{0xaa},
+		Asset:      btc,
+		Amount:     uint64(1000),
+		Status:     match.BondHeld,
+	}
+	second := *first
+	second.AuctionID = [32]byte{0xbb}
+
+	for _, bond := range []*match.Bond{first, &second} {
+		if err = store.RecordBond(bond); err != nil {
+			t.Errorf("Error recording bond for TestMemorySettlementStoreBonds: %s", err)
+			return
+		}
+	}
+
+	var held []*match.Bond
+	if held, err = store.GetBonds(match.BondHeld); err != nil {
+		t.Errorf("Error getting held bonds for TestMemorySettlementStoreBonds: %s", err)
+		return
+	}
+	if len(held) != 2 {
+		t.Errorf("Same commitment in two auctions should be two bonds, got %d", len(held))
+		return
+	}
+
+	refunded := *first
+	refunded.Status = match.BondRefunded
+	if err = store.RecordBond(&refunded); err != nil {
+		t.Errorf("Error recording refund for TestMemorySettlementStoreBonds: %s", err)
+		return
+	}
+
+	if held, err = store.GetBonds(match.BondHeld); err != nil {
+		t.Errorf("Error getting held bonds for TestMemorySettlementStoreBonds: %s", err)
+		return
+	}
+	if len(held) != 1 || held[0].AuctionID != second.AuctionID {
+		t.Errorf("Only the bond in the second auction should still be held, got %d held", len(held))
+		return
+	}
+
+	return
+}
@@ -82,6 +82,20 @@ func CreateAuctionOrderbook(pair *match.Pair) (book match.AuctionOrderbook, err
 	return
 }
 
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ao *SQLAuctionOrderbook) DestroyHandler() (err error) {
+	if ao.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new orderbook")
+		return
+	}
+	if err = ao.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing orderbook handler for DestroyHandler: %s", err)
+		return
+	}
+	ao.DBHandler = nil
+	return
+}
+
 // setupAuctionOrderbookTables sets up the tables needed for the auction orderbook.
 // This assumes everything else is set
 func (ao *SQLAuctionOrderbook) setupAuctionOrderbookTables() (err error) {
@@ -136,6 +136,7 @@ func testConfig() (conf *dbsqlConfig) {
 		BalanceSchemaName:        testString + defaultBalanceSchema,
 		DepositSchemaName:        testString + defaultDepositSchema,
 		PendingDepositSchemaName: testString + defaultPendingDepositSchema,
+		BondSchemaName:           testString + defaultBondSchema,
 		PuzzleSchemaName:         testString + defaultPuzzleSchema,
 		AuctionSchemaName:        testString + defaultAuctionSchema,
 		AuctionOrderSchemaName:   testString + defaultAuctionOrderSchema,
@@ -170,6 +171,7 @@ func getSchemasFromConfig(conf *dbsqlConfig) (schemas []string) {
 		conf.ReadOnlyBalanceSchemaName,
 		conf.ReadOnlyAuctionSchemaName,
 		conf.PendingDepositSchemaName,
+		conf.BondSchemaName,
 		conf.ReadOnlyOrderSchemaName,
 		conf.DepositSchemaName,
 		conf.BalanceSchemaName,
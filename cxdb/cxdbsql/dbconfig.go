@@ -29,6 +29,7 @@ type dbsqlConfig struct {
 	BalanceSchemaName         string `long:"balanceschema" description:"Name of balance schema"`
 	DepositSchemaName         string `long:"depositschema" description:"Name of deposit schema"`
 	PendingDepositSchemaName  string `long:"penddepschema" description:"Name of pending deposit schema"`
+	BondSchemaName            string `long:"bondschema" description:"Name of schema for commitment bonds"`
 	PuzzleSchemaName          string `long:"puzzleschema" description:"Name of schema for puzzle orderbooks"`
 	AuctionSchemaName         string `long:"auctionschema" description:"Name of schema for auction ID"`
 	AuctionOrderSchemaName    string `long:"auctionorderschema" description:"Name of schema for auction orderbook"`
@@ -58,6 +59,7 @@ var (
 	defaultBalanceSchema         = "balances"
 	defaultDepositSchema         = "deposit"
 	defaultPendingDepositSchema  = "pending_deposits"
+	defaultBondSchema            = "bonds"
 	defaultPuzzleSchema          = "puzzle"
 	defaultAuctionSchema         = "auctions"
 	defaultAuctionOrderSchema    = "auctionorder"
@@ -87,6 +89,7 @@ var (
 		BalanceSchemaName:         defaultBalanceSchema,
 		DepositSchemaName:         defaultDepositSchema,
 		PendingDepositSchemaName:  defaultPendingDepositSchema,
+		BondSchemaName:            defaultBondSchema,
 		PuzzleSchemaName:          defaultPuzzleSchema,
 		AuctionSchemaName:         defaultAuctionSchema,
 		AuctionOrderSchemaName:    defaultAuctionOrderSchema,
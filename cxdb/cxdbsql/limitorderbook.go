@@ -82,6 +82,20 @@ func CreateLimitOrderbook(pair *match.Pair) (book match.LimitOrderbook, err erro
 	return
 }
 
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (lo *SQLLimitOrderbook) DestroyHandler() (err error) {
+	if lo.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new orderbook")
+		return
+	}
+	if err = lo.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing orderbook handler for DestroyHandler: %s", err)
+		return
+	}
+	lo.DBHandler = nil
+	return
+}
+
 // setupLimitOrderbookTables sets up the tables needed for the limit orderbook.
 // This assumes everything else is set
 func (lo *SQLLimitOrderbook) setupLimitOrderbookTables() (err error) {
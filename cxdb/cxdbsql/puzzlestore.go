@@ -84,6 +84,20 @@ func CreatePuzzleStore(pair *match.Pair) (store cxdb.PuzzleStore, err error) {
 	return
 }
 
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (sp *SQLPuzzleStore) DestroyHandler() (err error) {
+	if sp.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new store")
+		return
+	}
+	if err = sp.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing store handler for DestroyHandler: %s", err)
+		return
+	}
+	sp.DBHandler = nil
+	return
+}
+
 // ViewAuctionPuzzleBook takes in an auction ID, and returns encrypted auction orders, and puzzles.
 // You don't know what auction IDs should be in the orders encrypted in the puzzle book, but this is
 // what was submitted.
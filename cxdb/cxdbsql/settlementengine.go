@@ -81,6 +81,20 @@ func CreateSettlementEngine(coin *coinparam.Params) (engine match.SettlementEngi
 	return
 }
 
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (se *SQLSettlementEngine) DestroyHandler() (err error) {
+	if se.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new engine")
+		return
+	}
+	if err = se.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing engine handler for DestroyHandler: %s", err)
+		return
+	}
+	se.DBHandler = nil
+	return
+}
+
 // ApplySettlementExecution applies the settlementExecution, this assumes that the settlement execution is
 // valid
 func (se *SQLSettlementEngine) ApplySettlementExecution(setExec *match.SettlementExecution) (setRes *match.SettlementResult, err error) {
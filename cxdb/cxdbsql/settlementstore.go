@@ -2,6 +2,7 @@ package cxdbsql
 
 import (
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"net"
 
@@ -30,12 +31,16 @@ type SQLSettlementStore struct {
 	// balance schema name
 	balanceReadOnlySchema string
 
+	// bond schema name
+	bondSchema string
+
 	// this coin
 	coin *coinparam.Params
 }
 
 const (
 	settlementStoreSchema = "pubkey VARBINARY(66), balance BIGINT(64), PRIMARY KEY (pubkey)"
+	bondStoreSchema       = "commitment VARBINARY(64), auctionID VARBINARY(64), pubkey VARBINARY(66), amount BIGINT(64), status TINYINT UNSIGNED, PRIMARY KEY (commitment, auctionID)"
 )
 
 // CreateSettlementStore creates a settlement store for a specific coin.
@@ -59,6 +64,7 @@ func CreateSettlementStore(coin *coinparam.Params) (store cxdb.SettlementStore,
 		dbUsername:            conf.DBUsername,
 		dbPassword:            conf.DBPassword,
 		balanceReadOnlySchema: conf.ReadOnlyBalanceSchemaName,
+		bondSchema:            conf.BondSchemaName,
 		dbAddr:                addr,
 		coin:                  coin,
 	}
@@ -86,6 +92,20 @@ func CreateSettlementStore(coin *coinparam.Params) (store cxdb.SettlementStore,
 	return
 }
 
+// DestroyHandler closes the DB handler that we created, and makes it nil
+func (ss *SQLSettlementStore) DestroyHandler() (err error) {
+	if ss.DBHandler == nil {
+		err = fmt.Errorf("Error, cannot destroy nil handler, please create new store")
+		return
+	}
+	if err = ss.DBHandler.Close(); err != nil {
+		err = fmt.Errorf("Error closing store handler for DestroyHandler: %s", err)
+		return
+	}
+	ss.DBHandler = nil
+	return
+}
+
 // setupSettlementStoreTables sets up the tables needed for the auction orderbook.
 // This assumes the schema name is set
 func (ss *SQLSettlementStore) setupSettlementStoreTables() (err error) {
@@ -138,6 +158,23 @@ func (ss *SQLSettlementStore) setupSettlementStoreTables() (err error) {
 		err = fmt.Errorf("Error creating settlement store table: %s", err)
 		return
 	}
+
+	// Now the bond schema
+	if _, err = tx.Exec("CREATE SCHEMA IF NOT EXISTS " + ss.bondSchema + ";"); err != nil {
+		err = fmt.Errorf("Error creating bond schema for setup settlement store tables: %s", err)
+		return
+	}
+
+	if _, err = tx.Exec("USE " + ss.bondSchema + ";"); err != nil {
+		err = fmt.Errorf("Could not use %s schema: %s", ss.bondSchema, err)
+		return
+	}
+
+	createTableQuery = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", ss.coin.Name, bondStoreSchema)
+	if _, err = tx.Exec(createTableQuery); err != nil {
+		err = fmt.Errorf("Error creating bond table: %s", err)
+		return
+	}
 	return
 }
 
@@ -226,6 +263,199 @@ func (ss *SQLSettlementStore) GetBalance(pubkey *koblitz.PublicKey) (balance uin
 	return
 }
 
+// GetAllBalances gets the balance of every pubkey for the asset, keyed by compressed pubkey.
+func (ss *SQLSettlementStore) GetAllBalances() (balances map[[33]byte]uint64, err error) {
+	// Get asset from coin
+	var assetForBal match.Asset
+	if assetForBal, err = match.AssetFromCoinParam(ss.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param: %s", err)
+		return
+	}
+
+	// Then create transaction
+	var tx *sql.Tx
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while getting all balances: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while getting all balances: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// use balance schema
+	if _, err = tx.Exec("USE " + ss.balanceReadOnlySchema + ";"); err != nil {
+		err = fmt.Errorf("Error using balance schema for GetAllBalances: %s", err)
+		return
+	}
+
+	var rows *sql.Rows
+	allBalQuery := fmt.Sprintf("SELECT pubkey, balance FROM %s;", assetForBal)
+	if rows, err = tx.Query(allBalQuery); err != nil {
+		err = fmt.Errorf("Error querying balances for GetAllBalances: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	balances = make(map[[33]byte]uint64)
+	var pubkeyBytes []byte
+	var balance uint64
+	for rows.Next() {
+		if err = rows.Scan(&pubkeyBytes, &balance); err != nil {
+			err = fmt.Errorf("Error scanning balance for GetAllBalances: %s", err)
+			return
+		}
+
+		// because we really only know that sql will give us a hex string, not actual bytes
+		if pubkeyBytes, err = hex.DecodeString(string(pubkeyBytes)); err != nil {
+			err = fmt.Errorf("Error decoding pubkey bytes string for GetAllBalances: %s", err)
+			return
+		}
+		if len(pubkeyBytes) != 33 {
+			err = fmt.Errorf("Pubkey %x in GetAllBalances is %d bytes, should be 33", pubkeyBytes, len(pubkeyBytes))
+			return
+		}
+
+		var pubkey [33]byte
+		copy(pubkey[:], pubkeyBytes)
+		balances[pubkey] = balance
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error iterating over balances for GetAllBalances: %s", err)
+		return
+	}
+
+	return
+}
+
+// RecordBond records a bond taken for an order commitment, or updates its status once it's
+// given back or forfeited.
+func (ss *SQLSettlementStore) RecordBond(bond *match.Bond) (err error) {
+	if bond == nil {
+		err = fmt.Errorf("Cannot record nil bond")
+		return
+	}
+
+	// Then create transaction
+	var tx *sql.Tx
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while recording bond: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while recording bond: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// use bond schema
+	if _, err = tx.Exec("USE " + ss.bondSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using bond schema for RecordBond: %s", err)
+		return
+	}
+
+	recordBondQuery := fmt.Sprintf("INSERT INTO %s (commitment, auctionID, pubkey, amount, status) VALUES ('%x', '%x', '%x', %d, %d) ON DUPLICATE KEY UPDATE status=%[5]d;", ss.coin.Name, bond.Commitment, bond.AuctionID, bond.Pubkey, bond.Amount, bond.Status)
+	if _, err = tx.Exec(recordBondQuery); err != nil {
+		err = fmt.Errorf("Error inserting bond for RecordBond: %s", err)
+		return
+	}
+	return
+}
+
+// GetBonds gets every bond for the asset with the status.
+func (ss *SQLSettlementStore) GetBonds(status match.BondStatus) (bonds []*match.Bond, err error) {
+	// Get asset from coin
+	var assetForBond match.Asset
+	if assetForBond, err = match.AssetFromCoinParam(ss.coin); err != nil {
+		err = fmt.Errorf("Error getting asset from coin param: %s", err)
+		return
+	}
+
+	// Then create transaction
+	var tx *sql.Tx
+	if tx, err = ss.DBHandler.Begin(); err != nil {
+		err = fmt.Errorf("Error beginning transaction while getting bonds: \n%s", err)
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("Error while getting bonds: \n%s", err)
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	// use bond schema
+	if _, err = tx.Exec("USE " + ss.bondSchema + ";"); err != nil {
+		err = fmt.Errorf("Error using bond schema for GetBonds: %s", err)
+		return
+	}
+
+	var rows *sql.Rows
+	getBondsQuery := fmt.Sprintf("SELECT commitment, auctionID, pubkey, amount FROM %s WHERE status=%d;", ss.coin.Name, status)
+	if rows, err = tx.Query(getBondsQuery); err != nil {
+		err = fmt.Errorf("Error querying bonds for GetBonds: %s", err)
+		return
+	}
+	defer rows.Close()
+
+	var commitmentBytes []byte
+	var auctionIDBytes []byte
+	var pubkeyBytes []byte
+	var amount uint64
+	for rows.Next() {
+		if err = rows.Scan(&commitmentBytes, &auctionIDBytes, &pubkeyBytes, &amount); err != nil {
+			err = fmt.Errorf("Error scanning bond for GetBonds: %s", err)
+			return
+		}
+
+		// sql gives us hex strings, not the actual bytes
+		if commitmentBytes, err = hex.DecodeString(string(commitmentBytes)); err != nil {
+			err = fmt.Errorf("Error decoding commitment for GetBonds: %s", err)
+			return
+		}
+		if auctionIDBytes, err = hex.DecodeString(string(auctionIDBytes)); err != nil {
+			err = fmt.Errorf("Error decoding auction ID for GetBonds: %s", err)
+			return
+		}
+		if pubkeyBytes, err = hex.DecodeString(string(pubkeyBytes)); err != nil {
+			err = fmt.Errorf("Error decoding pubkey for GetBonds: %s", err)
+			return
+		}
+		if len(commitmentBytes) != 32 || len(auctionIDBytes) != 32 || len(pubkeyBytes) != 33 {
+			err = fmt.Errorf("Bond with commitment %x in GetBonds has the wrong size fields", commitmentBytes)
+			return
+		}
+
+		bond := &match.Bond{
+			Asset:  assetForBond,
+			Amount: amount,
+			Status: status,
+		}
+		copy(bond.Commitment[:], commitmentBytes)
+		copy(bond.AuctionID[:], auctionIDBytes)
+		copy(bond.Pubkey[:], pubkeyBytes)
+		bonds = append(bonds, bond)
+	}
+	if err = rows.Err(); err != nil {
+		err = fmt.Errorf("Error iterating over bonds for GetBonds: %s", err)
+		return
+	}
+
+	return
+}
+
 // CreateSettlementStoreMap creates a map of coin to settlement engine, given a list of coins.
 func CreateSettlementStoreMap(coins []*coinparam.Params) (setMap map[*coinparam.Params]cxdb.SettlementStore, err error) {
 
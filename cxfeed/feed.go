@@ -0,0 +1,163 @@
+package cxfeed
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Event is something published to a feed, along with its sequence number
+type Event struct {
+	Seq  uint64
+	Data interface{}
+}
+
+// Feed is an append only log of events with sequence numbers starting at 1. It keeps the most
+// recent events, so readers can ask for everything after the last seq they saw and wait for more.
+// If a reader falls so far behind that the events it needs are gone, it's told there's a gap.
+// Every feed has a random epoch, and a reader's cursor is the epoch along with the seq. Seqs start
+// at 1 again in a new feed, like after a restart, so a cursor from another epoch is a gap too.
+type Feed struct {
+	backlog int
+	epoch   uint64
+	events  []*Event
+	lastSeq uint64
+
+	// notify is closed and replaced every time there's a new event, to wake up waiting readers
+	notify chan struct{}
+	closed bool
+	mtx    sync.Mutex
+}
+
+// NewFeed creates a feed that keeps the last backlog events
+func NewFeed(backlog int) (feed *Feed, err error) {
+	if backlog <= 0 {
+		err = fmt.Errorf("Feed backlog must be positive, got %d", backlog)
+		return
+	}
+	feed = &Feed{
+		backlog: backlog,
+		notify:  make(chan struct{}),
+	}
+
+	// 0 is for readers that haven't seen an epoch yet, so a feed never gets it
+	var epochBytes [8]byte
+	for feed.epoch == 0 {
+		if _, err = rand.Read(epochBytes[:]); err != nil {
+			err = fmt.Errorf("Error getting random feed epoch: %s", err)
+			return
+		}
+		feed.epoch = binary.BigEndian.Uint64(epochBytes[:])
+	}
+	return
+}
+
+// Epoch returns the feed's epoch, which readers pass back along with the last seq they saw
+func (f *Feed) Epoch() (epoch uint64) {
+	epoch = f.epoch
+	return
+}
+
+// Publish adds data to the feed as the next event, wakes up waiting readers, and returns the
+// event's seq. Publishing to a closed feed does nothing and returns 0.
+func (f *Feed) Publish(data interface{}) (seq uint64) {
+	f.mtx.Lock()
+	if f.closed {
+		f.mtx.Unlock()
+		return
+	}
+	f.lastSeq++
+	seq = f.lastSeq
+	f.events = append(f.events, &Event{Seq: seq, Data: data})
+	if len(f.events) > f.backlog {
+		f.events = f.events[len(f.events)-f.backlog:]
+	}
+	close(f.notify)
+	f.notify = make(chan struct{})
+	f.mtx.Unlock()
+	return
+}
+
+// LastSeq returns the seq of the last event published, or 0 if there hasn't been one
+func (f *Feed) LastSeq() (seq uint64) {
+	f.mtx.Lock()
+	seq = f.lastSeq
+	f.mtx.Unlock()
+	return
+}
+
+// Since returns up to max of the events after afterSeq in epoch. If gap is true, the events right
+// after afterSeq aren't kept anymore, afterSeq is from the future, or the epoch isn't this feed's,
+// and no events are returned. A reader that hasn't seen anything yet passes epoch 0 and seq 0.
+func (f *Feed) Since(epoch uint64, afterSeq uint64, max int) (events []*Event, gap bool) {
+	f.mtx.Lock()
+	events, gap = f.since(epoch, afterSeq, max)
+	f.mtx.Unlock()
+	return
+}
+
+// Wait is like Since, but if there are no events after afterSeq yet it waits up to timeout for
+// one. It returns early with nothing if the feed is closed.
+func (f *Feed) Wait(epoch uint64, afterSeq uint64, max int, timeout time.Duration) (events []*Event, gap bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		f.mtx.Lock()
+		if events, gap = f.since(epoch, afterSeq, max); len(events) != 0 || gap || f.closed {
+			f.mtx.Unlock()
+			return
+		}
+		notify := f.notify
+		f.mtx.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return
+		}
+	}
+}
+
+// Close stops the feed from taking new events and wakes up any waiting readers
+func (f *Feed) Close() {
+	f.mtx.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.notify)
+	}
+	f.mtx.Unlock()
+	return
+}
+
+// since is Since without locking
+func (f *Feed) since(epoch uint64, afterSeq uint64, max int) (events []*Event, gap bool) {
+	if epoch != f.epoch && !(epoch == 0 && afterSeq == 0) {
+		gap = true
+		return
+	}
+	if afterSeq > f.lastSeq {
+		gap = true
+		return
+	}
+	if afterSeq == f.lastSeq || max <= 0 {
+		return
+	}
+
+	firstSeq := f.events[0].Seq
+	if afterSeq+1 < firstSeq {
+		gap = true
+		return
+	}
+
+	start := int(afterSeq + 1 - firstSeq)
+	end := len(f.events)
+	if end-start > max {
+		end = start + max
+	}
+	events = make([]*Event, end-start)
+	copy(events, f.events[start:end])
+	return
+}
@@ -0,0 +1,166 @@
+package cxfeed
+
+import (
+	"testing"
+	"time"
+)
+
+// publishN publishes the numbers 1 through n to a feed
+func publishN(feed *Feed, n int) {
+	for i := 1; i <= n; i++ {
+		feed.Publish(i)
+	}
+	return
+}
+
+func TestSince(t *testing.T) {
+	var err error
+	var feed *Feed
+	if feed, err = NewFeed(5); err != nil {
+		t.Errorf("Error creating feed: %s", err)
+		return
+	}
+
+	if events, gap := feed.Since(0, 0, 10); len(events) != 0 || gap {
+		t.Errorf("Empty feed should have no events and no gap, got %d events, gap %t", len(events), gap)
+		return
+	}
+
+	publishN(feed, 8)
+	if feed.LastSeq() != 8 {
+		t.Errorf("Expected last seq 8, got %d", feed.LastSeq())
+		return
+	}
+
+	// only 4 through 8 are kept
+	events, gap := feed.Since(feed.Epoch(), 3, 10)
+	if gap || len(events) != 5 {
+		t.Errorf("Expected the 5 kept events after seq 3, got %d events, gap %t", len(events), gap)
+		return
+	}
+	for i, event := range events {
+		if event.Seq != uint64(i+4) || event.Data.(int) != i+4 {
+			t.Errorf("Expected event %d at index %d, got seq %d data %v", i+4, i, event.Seq, event.Data)
+			return
+		}
+	}
+
+	if events, gap = feed.Since(feed.Epoch(), 5, 2); gap || len(events) != 2 || events[0].Seq != 6 || events[1].Seq != 7 {
+		t.Errorf("Expected events 6 and 7 with max 2, got %d events, gap %t", len(events), gap)
+		return
+	}
+
+	if events, gap = feed.Since(feed.Epoch(), 8, 10); gap || len(events) != 0 {
+		t.Errorf("Reader that's caught up should get nothing and no gap, got %d events, gap %t", len(events), gap)
+		return
+	}
+
+	// event 3 is gone, and seq 9 hasn't happened, so both are gaps
+	if events, gap = feed.Since(feed.Epoch(), 2, 10); !gap || len(events) != 0 {
+		t.Errorf("Reader that missed dropped events should get a gap, got %d events, gap %t", len(events), gap)
+		return
+	}
+	if events, gap = feed.Since(feed.Epoch(), 9, 10); !gap || len(events) != 0 {
+		t.Errorf("Reader ahead of the feed should get a gap, got %d events, gap %t", len(events), gap)
+		return
+	}
+
+	if _, err = NewFeed(0); err == nil {
+		t.Errorf("Feed with no backlog should not be created")
+		return
+	}
+
+	return
+}
+
+func TestEpoch(t *testing.T) {
+	var err error
+	var before, after *Feed
+	if before, err = NewFeed(10); err != nil {
+		t.Errorf("Error creating feed: %s", err)
+		return
+	}
+	if after, err = NewFeed(10); err != nil {
+		t.Errorf("Error creating feed: %s", err)
+		return
+	}
+	if before.Epoch() == after.Epoch() || before.Epoch() == 0 {
+		t.Errorf("Feeds should have different nonzero epochs, got %d and %d", before.Epoch(), after.Epoch())
+		return
+	}
+
+	// like a restart, the new feed has the same seqs as the old one
+	publishN(before, 5)
+	publishN(after, 8)
+
+	if events, gap := after.Since(before.Epoch(), 3, 10); !gap || len(events) != 0 {
+		t.Errorf("Cursor from another epoch should get a gap, got %d events, gap %t", len(events), gap)
+		return
+	}
+	if events, gap := after.Since(0, 3, 10); !gap || len(events) != 0 {
+		t.Errorf("Cursor with a seq but no epoch should get a gap, got %d events, gap %t", len(events), gap)
+		return
+	}
+	if events, gap := after.Since(0, 0, 10); gap || len(events) != 8 {
+		t.Errorf("New reader should get every event, got %d events, gap %t", len(events), gap)
+		return
+	}
+
+	return
+}
+
+func TestWait(t *testing.T) {
+	var err error
+	var feed *Feed
+	if feed, err = NewFeed(10); err != nil {
+		t.Errorf("Error creating feed: %s", err)
+		return
+	}
+
+	start := time.Now()
+	if events, gap := feed.Wait(feed.Epoch(), 0, 10, 20*time.Millisecond); len(events) != 0 || gap {
+		t.Errorf("Wait with nothing published should time out empty, got %d events, gap %t", len(events), gap)
+		return
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Wait returned before the timeout")
+		return
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		feed.Publish("first")
+	}()
+	events, gap := feed.Wait(feed.Epoch(), 0, 10, 5*time.Second)
+	if gap || len(events) != 1 || events[0].Data.(string) != "first" {
+		t.Errorf("Wait should return the event published while waiting, got %d events, gap %t", len(events), gap)
+		return
+	}
+
+	// already published events come back right away
+	if events, gap = feed.Wait(feed.Epoch(), 0, 10, 5*time.Second); len(events) != 1 {
+		t.Errorf("Wait should not block when there are events, got %d events, gap %t", len(events), gap)
+		return
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		feed.Close()
+	}()
+	start = time.Now()
+	if events, gap = feed.Wait(feed.Epoch(), 1, 10, 5*time.Second); len(events) != 0 || gap {
+		t.Errorf("Wait on a closed feed should return empty, got %d events, gap %t", len(events), gap)
+		return
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Closing the feed should wake up waiting readers")
+		return
+	}
+
+	if seq := feed.Publish("after close"); seq != 0 || feed.LastSeq() != 1 {
+		t.Errorf("Publishing to a closed feed should do nothing, got seq %d", seq)
+		return
+	}
+
+	return
+}
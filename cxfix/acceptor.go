@@ -0,0 +1,350 @@
+package cxfix
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/cxserver"
+	"github.com/mit-dci/opencx/logging"
+)
+
+const (
+	// LogonMethod is the method a logon signature is made for, see SignLogon
+	LogonMethod = "FIX.Logon"
+	// MaxLogonSkew is how far a logon's SendingTime can be from the server's clock
+	MaxLogonSkew = 2 * time.Minute
+	// LogonTimeout is how long a new connection has to send a logon
+	LogonTimeout = 10 * time.Second
+	// MaxHeartBtInt is the longest heartbeat interval, in seconds, the gateway will accept
+	MaxHeartBtInt = 300
+	// MinTLSVersion is the oldest TLS version sessions can connect with
+	MinTLSVersion = tls.VersionTLS12
+)
+
+// Acceptor is a FIX 4.4 acceptor for order entry. Counterparties log on with the hex compressed
+// pubkey of a registered user as their SenderCompID, and sign the logon with that key.
+type Acceptor struct {
+	server   *cxserver.OpencxServer
+	compID   string
+	stateDir string
+	listener net.Listener
+
+	// sessions are the logged on sessions by SenderCompID, one per pubkey
+	sessions map[string]*session
+	// usedLogons are the digests of logons that were used, until they expire, so they can't be
+	// replayed. These are keyed by what was signed rather than the signature, since a signature
+	// can be changed into another valid one for the same digest.
+	usedLogons map[[32]byte]time.Time
+	mtx        sync.Mutex
+}
+
+// NewAcceptor creates an acceptor for a server. compID is our CompID, which counterparties use as
+// their TargetCompID, and session state is kept in stateDir.
+func NewAcceptor(server *cxserver.OpencxServer, compID string, stateDir string) (acceptor *Acceptor, err error) {
+	if server == nil {
+		err = fmt.Errorf("Server cannot be nil for FIX acceptor")
+		return
+	}
+	if compID == "" {
+		err = fmt.Errorf("CompID cannot be empty for FIX acceptor")
+		return
+	}
+	if err = os.MkdirAll(stateDir, 0700); err != nil {
+		err = fmt.Errorf("Error creating FIX session state directory: %s", err)
+		return
+	}
+
+	acceptor = &Acceptor{
+		server:     server,
+		compID:     compID,
+		stateDir:   stateDir,
+		sessions:   make(map[string]*session),
+		usedLogons: make(map[[32]byte]time.Time),
+	}
+	return
+}
+
+// Listen starts accepting FIX connections on host and port over TLS. Only the logon is signed,
+// everything after it is trusted because it's on the same connection, so the connection has to be
+// encrypted and the config has to have a certificate.
+func (acceptor *Acceptor) Listen(host string, port uint16, tlsConfig *tls.Config) (err error) {
+	if tlsConfig == nil || (len(tlsConfig.Certificates) == 0 && tlsConfig.GetCertificate == nil) {
+		err = fmt.Errorf("FIX acceptor needs a TLS certificate to listen")
+		return
+	}
+
+	tlsConfig = tlsConfig.Clone()
+	if tlsConfig.MinVersion < MinTLSVersion {
+		tlsConfig.MinVersion = MinTLSVersion
+	}
+
+	serverAddr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	if acceptor.listener, err = tls.Listen("tcp", serverAddr, tlsConfig); err != nil {
+		err = fmt.Errorf("Error listening for FIX acceptor: %s", err)
+		return
+	}
+
+	go acceptor.acceptLoop()
+	return
+}
+
+// LoadTLSConfig loads the certificate and key the acceptor uses for TLS from PEM files
+func LoadTLSConfig(certFile string, keyFile string) (tlsConfig *tls.Config, err error) {
+	var cert tls.Certificate
+	if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		err = fmt.Errorf("Error loading FIX TLS certificate: %s", err)
+		return
+	}
+
+	tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   MinTLSVersion,
+	}
+	return
+}
+
+// Addr returns the address the acceptor is listening on, or nil if it isn't
+func (acceptor *Acceptor) Addr() (addr net.Addr) {
+	if acceptor.listener != nil {
+		addr = acceptor.listener.Addr()
+	}
+	return
+}
+
+// Close stops accepting connections and logs out every session
+func (acceptor *Acceptor) Close() (err error) {
+	if acceptor.listener != nil {
+		if err = acceptor.listener.Close(); err != nil {
+			err = fmt.Errorf("Error closing FIX listener: %s", err)
+			return
+		}
+	}
+
+	acceptor.mtx.Lock()
+	var sessions []*session
+	for _, sess := range acceptor.sessions {
+		sessions = append(sessions, sess)
+	}
+	acceptor.mtx.Unlock()
+
+	for _, sess := range sessions {
+		sess.logout("Server shutting down")
+	}
+	return
+}
+
+// acceptLoop accepts connections until the listener is closed
+func (acceptor *Acceptor) acceptLoop() {
+	for {
+		conn, err := acceptor.listener.Accept()
+		if err != nil {
+			logging.Infof("FIX acceptor stopped accepting connections: %s", err)
+			return
+		}
+		go acceptor.handleConn(conn)
+	}
+}
+
+// handleConn waits for a logon on a new connection, and runs the session if it's valid
+func (acceptor *Acceptor) handleConn(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+
+	conn.SetReadDeadline(time.Now().Add(LogonTimeout))
+	logon, err := ReadMessage(reader)
+	if err != nil {
+		logging.Warnf("Error reading FIX logon from %s: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	var sess *session
+	if sess, err = acceptor.logon(conn, reader, logon); err != nil {
+		logging.Warnf("Rejected FIX logon from %s: %s", conn.RemoteAddr(), err)
+		// the first message has to be a logon, so there's no session to log out of
+		logout := NewMessage(MsgTypeLogout)
+		logout.Set(TagSenderCompID, acceptor.compID)
+		if sender, ok := logon.Get(TagSenderCompID); ok {
+			logout.Set(TagTargetCompID, sender)
+		}
+		logout.SetUint(TagMsgSeqNum, 1)
+		logout.SetTime(TagSendingTime, time.Now())
+		logout.Set(TagText, err.Error())
+		conn.Write(logout.Bytes())
+		conn.Close()
+		return
+	}
+
+	sess.run()
+
+	acceptor.mtx.Lock()
+	delete(acceptor.sessions, sess.senderCompID)
+	acceptor.mtx.Unlock()
+	return
+}
+
+// logon authenticates a logon message and creates its session
+func (acceptor *Acceptor) logon(conn net.Conn, reader *bufio.Reader, logon *Message) (sess *session, err error) {
+	if logon.MsgType() != MsgTypeLogon {
+		err = fmt.Errorf("First message must be a logon, got MsgType %s", logon.MsgType())
+		return
+	}
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = acceptor.authenticate(logon); err != nil {
+		return
+	}
+	if !acceptor.server.IsRegistered(pubkey) {
+		err = fmt.Errorf("Pubkey %x is not registered", pubkey.SerializeCompressed())
+		return
+	}
+
+	if encryptMethod, _ := logon.Get(TagEncryptMethod); encryptMethod != "0" {
+		err = fmt.Errorf("EncryptMethod must be 0")
+		return
+	}
+	var heartBtInt uint64
+	if heartBtInt, err = logon.GetUint(TagHeartBtInt); err != nil {
+		return
+	}
+	if heartBtInt == 0 || heartBtInt > MaxHeartBtInt {
+		err = fmt.Errorf("HeartBtInt must be between 1 and %d", MaxHeartBtInt)
+		return
+	}
+
+	sess = &session{
+		acceptor:  acceptor,
+		conn:      conn,
+		reader:    reader,
+		pubkey:    pubkey,
+		heartbeat: time.Duration(heartBtInt) * time.Second,
+		done:      make(chan struct{}),
+	}
+	sess.senderCompID, _ = logon.Get(TagSenderCompID)
+	sess.statePath = statePath(acceptor.stateDir, sess.senderCompID)
+
+	// only one session per pubkey at a time, since they share sequence numbers
+	acceptor.mtx.Lock()
+	if _, ok := acceptor.sessions[sess.senderCompID]; ok {
+		acceptor.mtx.Unlock()
+		err = fmt.Errorf("Session for %s is already logged on", sess.senderCompID)
+		return
+	}
+	acceptor.sessions[sess.senderCompID] = sess
+	acceptor.mtx.Unlock()
+
+	if err = sess.start(logon); err != nil {
+		acceptor.mtx.Lock()
+		delete(acceptor.sessions, sess.senderCompID)
+		acceptor.mtx.Unlock()
+		return
+	}
+	return
+}
+
+// authenticate checks that a logon is for us, recent, not replayed, and signed by the pubkey in
+// its SenderCompID. It returns that pubkey.
+func (acceptor *Acceptor) authenticate(logon *Message) (pubkey *koblitz.PublicKey, err error) {
+	sender, _ := logon.Get(TagSenderCompID)
+	target, _ := logon.Get(TagTargetCompID)
+	if target != acceptor.compID {
+		err = fmt.Errorf("TargetCompID must be %s", acceptor.compID)
+		return
+	}
+
+	var senderBytes []byte
+	if senderBytes, err = hex.DecodeString(sender); err != nil || len(senderBytes) != 33 {
+		err = fmt.Errorf("SenderCompID must be a hex compressed pubkey")
+		return
+	}
+	var senderPubkey *koblitz.PublicKey
+	if senderPubkey, err = koblitz.ParsePubKey(senderBytes, koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error parsing SenderCompID as a pubkey: %s", err)
+		return
+	}
+
+	sendingTimeString, _ := logon.Get(TagSendingTime)
+	var sendingTime time.Time
+	if sendingTime, err = logon.GetTime(TagSendingTime); err != nil {
+		return
+	}
+	now := time.Now()
+	if sendingTime.Before(now.Add(-MaxLogonSkew)) || sendingTime.After(now.Add(MaxLogonSkew)) {
+		err = fmt.Errorf("SendingTime of logon must be within %s of %s", MaxLogonSkew, now.UTC().Format(SendingTimeFormat))
+		return
+	}
+
+	rawData, _ := logon.Get(TagRawData)
+	var signature []byte
+	if signature, err = base64.StdEncoding.DecodeString(rawData); err != nil {
+		err = fmt.Errorf("RawData must be a base64 logon signature: %s", err)
+		return
+	}
+
+	argsHash := logonArgsHash(sender, target, sendingTimeString)
+	req := &cxauth.SignedRequest{
+		Expiry:    sendingTime.Add(MaxLogonSkew).Unix(),
+		Signature: signature,
+	}
+	if pubkey, err = req.Signer(LogonMethod, argsHash); err != nil {
+		return
+	}
+	if !pubkey.IsEqual(senderPubkey) {
+		err = fmt.Errorf("Logon is not signed by the SenderCompID pubkey")
+		return
+	}
+
+	var digest [32]byte
+	copy(digest[:], cxauth.RequestDigest(LogonMethod, argsHash, req.Nonce, req.Expiry))
+
+	acceptor.mtx.Lock()
+	for usedDigest, expiry := range acceptor.usedLogons {
+		if now.After(expiry) {
+			delete(acceptor.usedLogons, usedDigest)
+		}
+	}
+	if _, ok := acceptor.usedLogons[digest]; ok {
+		acceptor.mtx.Unlock()
+		err = fmt.Errorf("Logon was already used")
+		return
+	}
+	acceptor.usedLogons[digest] = sendingTime.Add(2 * MaxLogonSkew)
+	acceptor.mtx.Unlock()
+
+	return
+}
+
+// logonArgsHash is what a logon signature commits to
+func logonArgsHash(senderCompID string, targetCompID string, sendingTime string) (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs([]byte(senderCompID), []byte(targetCompID), []byte(sendingTime))
+	return
+}
+
+// SignLogon signs a logon for a counterparty, returning what goes in RawData. senderCompID is
+// the hex compressed pubkey of privkey, and sendingTime is exactly what's in the SendingTime field.
+func SignLogon(privkey *koblitz.PrivateKey, senderCompID string, targetCompID string, sendingTime string) (rawData string, err error) {
+	var parsedTime time.Time
+	if parsedTime, err = time.Parse(SendingTimeFormat, sendingTime); err != nil {
+		if parsedTime, err = time.Parse("20060102-15:04:05", sendingTime); err != nil {
+			err = fmt.Errorf("Error parsing SendingTime for logon signature: %s", err)
+			return
+		}
+	}
+
+	var req *cxauth.SignedRequest
+	if req, err = cxauth.SignRequest(privkey, LogonMethod, logonArgsHash(senderCompID, targetCompID, sendingTime), [32]byte{}, parsedTime.Add(MaxLogonSkew)); err != nil {
+		err = fmt.Errorf("Error signing logon: %s", err)
+		return
+	}
+
+	rawData = base64.StdEncoding.EncodeToString(req.Signature)
+	return
+}
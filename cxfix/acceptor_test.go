@@ -0,0 +1,106 @@
+package cxfix
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+)
+
+// createTestLogon creates a logon from a new key to the acceptor, signed with the given SendingTime
+func createTestLogon(t *testing.T, acceptor *Acceptor, sendingTime time.Time) (logon *Message) {
+	privkey, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Fatalf("Error creating test key: %s", err)
+	}
+
+	sender := hex.EncodeToString(privkey.PubKey().SerializeCompressed())
+	sendingTimeString := sendingTime.UTC().Format(SendingTimeFormat)
+	rawData, err := SignLogon(privkey, sender, acceptor.compID, sendingTimeString)
+	if err != nil {
+		t.Fatalf("Error signing test logon: %s", err)
+	}
+
+	logon = NewMessage(MsgTypeLogon)
+	logon.Set(TagSenderCompID, sender)
+	logon.Set(TagTargetCompID, acceptor.compID)
+	logon.Set(TagSendingTime, sendingTimeString)
+	logon.Set(TagRawData, rawData)
+	return
+}
+
+// TestLogonReplay makes sure a logon can't be used twice, even with the other valid signature for
+// the same digest
+func TestLogonReplay(t *testing.T) {
+	acceptor := &Acceptor{
+		compID:     "OPENCX",
+		sessions:   make(map[string]*session),
+		usedLogons: make(map[[32]byte]time.Time),
+	}
+
+	logon := createTestLogon(t, acceptor, time.Now())
+	if _, err := acceptor.authenticate(logon); err != nil {
+		t.Errorf("Error authenticating logon: %s", err)
+		return
+	}
+
+	if _, err := acceptor.authenticate(logon); err == nil {
+		t.Errorf("Logon should not authenticate twice")
+		return
+	}
+
+	// (r, N-s) with the other recovery id recovers the same key for the same digest
+	rawData, _ := logon.Get(TagRawData)
+	signature, err := base64.StdEncoding.DecodeString(rawData)
+	if err != nil || len(signature) != 65 {
+		t.Errorf("Logon signature should be a 65 byte compact signature")
+		return
+	}
+	sigS := new(big.Int).SetBytes(signature[33:])
+	sigS.Sub(koblitz.S256().N, sigS)
+	malleated := make([]byte, 65)
+	malleated[0] = 27 + ((signature[0] - 27) ^ 1)
+	copy(malleated[1:33], signature[1:33])
+	sBytes := sigS.Bytes()
+	copy(malleated[65-len(sBytes):], sBytes)
+	logon.Set(TagRawData, base64.StdEncoding.EncodeToString(malleated))
+
+	if _, err = acceptor.authenticate(logon); err == nil {
+		t.Errorf("Logon should not authenticate again with a malleated signature")
+		return
+	}
+
+	if _, err = acceptor.authenticate(createTestLogon(t, acceptor, time.Now().Add(-2*MaxLogonSkew))); err == nil {
+		t.Errorf("Logon outside of the skew should not authenticate")
+		return
+	}
+
+	return
+}
+
+// TestListenRequiresTLS makes sure the acceptor won't listen without a certificate
+func TestListenRequiresTLS(t *testing.T) {
+	acceptor := &Acceptor{
+		compID:     "OPENCX",
+		sessions:   make(map[string]*session),
+		usedLogons: make(map[[32]byte]time.Time),
+	}
+
+	if err := acceptor.Listen("localhost", 0, nil); err == nil {
+		t.Errorf("Should not listen without a TLS config")
+		acceptor.Close()
+		return
+	}
+
+	if err := acceptor.Listen("localhost", 0, &tls.Config{}); err == nil {
+		t.Errorf("Should not listen without a TLS certificate")
+		acceptor.Close()
+		return
+	}
+
+	return
+}
@@ -0,0 +1,373 @@
+package cxfix
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// BeginString is the FIX version the gateway speaks
+	BeginString = "FIX.4.4"
+	// MaxBodyLength is the largest message body the gateway will read
+	MaxBodyLength = 1 << 16
+	// SendingTimeFormat is the format of UTCTimestamp fields like SendingTime
+	SendingTimeFormat = "20060102-15:04:05.000"
+	// soh separates fields
+	soh = '\x01'
+)
+
+// FIX tags used by the gateway
+const (
+	TagAvgPx            = 6
+	TagBeginSeqNo       = 7
+	TagBeginString      = 8
+	TagBodyLength       = 9
+	TagCheckSum         = 10
+	TagClOrdID          = 11
+	TagCumQty           = 14
+	TagEndSeqNo         = 16
+	TagExecID           = 17
+	TagLastPx           = 31
+	TagLastQty          = 32
+	TagMsgSeqNum        = 34
+	TagMsgType          = 35
+	TagNewSeqNo         = 36
+	TagOrderID          = 37
+	TagOrderQty         = 38
+	TagOrdStatus        = 39
+	TagOrdType          = 40
+	TagOrigClOrdID      = 41
+	TagPossDupFlag      = 43
+	TagPrice            = 44
+	TagRefSeqNum        = 45
+	TagSenderCompID     = 49
+	TagSendingTime      = 52
+	TagSide             = 54
+	TagSymbol           = 55
+	TagTargetCompID     = 56
+	TagText             = 58
+	TagTransactTime     = 60
+	TagRawDataLength    = 95
+	TagRawData          = 96
+	TagEncryptMethod    = 98
+	TagOrdRejReason     = 103
+	TagHeartBtInt       = 108
+	TagTestReqID        = 112
+	TagOrigSendingTime  = 122
+	TagGapFillFlag      = 123
+	TagResetSeqNumFlag  = 141
+	TagExecType         = 150
+	TagLeavesQty        = 151
+	TagSessionRejReason = 373
+	TagCxlRejResponseTo = 434
+)
+
+// FIX message types used by the gateway
+const (
+	MsgTypeHeartbeat                 = "0"
+	MsgTypeTestRequest               = "1"
+	MsgTypeResendRequest             = "2"
+	MsgTypeReject                    = "3"
+	MsgTypeSequenceReset             = "4"
+	MsgTypeLogout                    = "5"
+	MsgTypeExecutionReport           = "8"
+	MsgTypeOrderCancelReject         = "9"
+	MsgTypeLogon                     = "A"
+	MsgTypeNewOrderSingle            = "D"
+	MsgTypeOrderCancelRequest        = "F"
+	MsgTypeOrderCancelReplaceRequest = "G"
+)
+
+// Field is one tag=value pair of a message
+type Field struct {
+	Tag   int
+	Value string
+}
+
+// Message is a FIX message. Fields holds everything but BeginString, BodyLength and CheckSum, which
+// are worked out when the message is written.
+type Message struct {
+	Fields []Field
+}
+
+// NewMessage creates a message of a type
+func NewMessage(msgType string) (msg *Message) {
+	msg = &Message{}
+	msg.Set(TagMsgType, msgType)
+	return
+}
+
+// MsgType returns the type of the message
+func (msg *Message) MsgType() (msgType string) {
+	msgType, _ = msg.Get(TagMsgType)
+	return
+}
+
+// Get returns the value of the first field with a tag, and whether there is one
+func (msg *Message) Get(tag int) (value string, ok bool) {
+	for _, field := range msg.Fields {
+		if field.Tag == tag {
+			value, ok = field.Value, true
+			return
+		}
+	}
+	return
+}
+
+// GetUint returns the value of a field as an unsigned integer
+func (msg *Message) GetUint(tag int) (value uint64, err error) {
+	str, ok := msg.Get(tag)
+	if !ok {
+		err = fmt.Errorf("Message is missing tag %d", tag)
+		return
+	}
+	if value, err = strconv.ParseUint(str, 10, 64); err != nil {
+		err = fmt.Errorf("Tag %d is not an unsigned integer: %s", tag, err)
+		return
+	}
+	return
+}
+
+// GetFloat returns the value of a field as a float
+func (msg *Message) GetFloat(tag int) (value float64, err error) {
+	str, ok := msg.Get(tag)
+	if !ok {
+		err = fmt.Errorf("Message is missing tag %d", tag)
+		return
+	}
+	if value, err = strconv.ParseFloat(str, 64); err != nil {
+		err = fmt.Errorf("Tag %d is not a number: %s", tag, err)
+		return
+	}
+	return
+}
+
+// GetTime returns the value of a UTCTimestamp field, with or without milliseconds
+func (msg *Message) GetTime(tag int) (value time.Time, err error) {
+	str, ok := msg.Get(tag)
+	if !ok {
+		err = fmt.Errorf("Message is missing tag %d", tag)
+		return
+	}
+	if value, err = time.Parse(SendingTimeFormat, str); err == nil {
+		return
+	}
+	if value, err = time.Parse("20060102-15:04:05", str); err != nil {
+		err = fmt.Errorf("Tag %d is not a UTC timestamp: %s", tag, err)
+		return
+	}
+	return
+}
+
+// Set sets the first field with a tag to value, or adds the field if there isn't one
+func (msg *Message) Set(tag int, value string) {
+	for i := range msg.Fields {
+		if msg.Fields[i].Tag == tag {
+			msg.Fields[i].Value = value
+			return
+		}
+	}
+	msg.Fields = append(msg.Fields, Field{Tag: tag, Value: value})
+	return
+}
+
+// SetUint sets a field to an unsigned integer
+func (msg *Message) SetUint(tag int, value uint64) {
+	msg.Set(tag, strconv.FormatUint(value, 10))
+	return
+}
+
+// SetFloat sets a field to a float
+func (msg *Message) SetFloat(tag int, value float64) {
+	msg.Set(tag, strconv.FormatFloat(value, 'f', -1, 64))
+	return
+}
+
+// SetTime sets a field to a UTC timestamp
+func (msg *Message) SetTime(tag int, value time.Time) {
+	msg.Set(tag, value.UTC().Format(SendingTimeFormat))
+	return
+}
+
+// headerTags are the standard header fields the gateway sets, in the order they're written
+var headerTags = []int{TagMsgType, TagSenderCompID, TagTargetCompID, TagMsgSeqNum, TagPossDupFlag, TagSendingTime, TagOrigSendingTime}
+
+// isHeaderTag returns true if a tag is written as part of the header
+func isHeaderTag(tag int) (header bool) {
+	switch tag {
+	case TagBeginString, TagBodyLength, TagCheckSum:
+		header = true
+		return
+	}
+	for _, headerTag := range headerTags {
+		if tag == headerTag {
+			header = true
+			return
+		}
+	}
+	return
+}
+
+// Bytes encodes the message with BeginString, BodyLength and CheckSum. The header fields are
+// written first, starting with MsgType, and then the rest in order.
+func (msg *Message) Bytes() (encoded []byte) {
+	var body bytes.Buffer
+	for _, tag := range headerTags {
+		if value, ok := msg.Get(tag); ok {
+			writeField(&body, tag, value)
+		}
+	}
+	for _, field := range msg.Fields {
+		if isHeaderTag(field.Tag) {
+			continue
+		}
+		writeField(&body, field.Tag, field.Value)
+	}
+
+	var buf bytes.Buffer
+	writeField(&buf, TagBeginString, BeginString)
+	writeField(&buf, TagBodyLength, strconv.Itoa(body.Len()))
+	buf.Write(body.Bytes())
+	writeField(&buf, TagCheckSum, fmt.Sprintf("%03d", checksum(buf.Bytes())))
+
+	encoded = buf.Bytes()
+	return
+}
+
+// String returns the message with | instead of SOH, for logging
+func (msg *Message) String() string {
+	return string(bytes.Replace(msg.Bytes(), []byte{soh}, []byte{'|'}, -1))
+}
+
+// ReadMessage reads a message, checking the BeginString, BodyLength and CheckSum
+func ReadMessage(r *bufio.Reader) (msg *Message, err error) {
+	var header bytes.Buffer
+
+	var tag int
+	var value string
+	if tag, value, err = readField(r, &header); err != nil {
+		return
+	}
+	if tag != TagBeginString || value != BeginString {
+		err = fmt.Errorf("Message must start with %d=%s, got %d=%s", TagBeginString, BeginString, tag, value)
+		return
+	}
+
+	if tag, value, err = readField(r, &header); err != nil {
+		return
+	}
+	var bodyLength int
+	if tag != TagBodyLength {
+		err = fmt.Errorf("Second field of message must be BodyLength, got tag %d", tag)
+		return
+	}
+	if bodyLength, err = strconv.Atoi(value); err != nil || bodyLength <= 0 || bodyLength > MaxBodyLength {
+		err = fmt.Errorf("Invalid BodyLength %s, must be between 1 and %d", value, MaxBodyLength)
+		return
+	}
+
+	body := make([]byte, bodyLength)
+	if _, err = io.ReadFull(r, body); err != nil {
+		err = fmt.Errorf("Error reading message body: %s", err)
+		return
+	}
+	header.Write(body)
+
+	if tag, value, err = readField(r, nil); err != nil {
+		return
+	}
+	if tag != TagCheckSum {
+		err = fmt.Errorf("Body should be followed by CheckSum, got tag %d, BodyLength may be wrong", tag)
+		return
+	}
+	if expected := fmt.Sprintf("%03d", checksum(header.Bytes())); value != expected {
+		err = fmt.Errorf("Invalid CheckSum %s, expected %s", value, expected)
+		return
+	}
+
+	if msg, err = parseBody(body); err != nil {
+		return
+	}
+	return
+}
+
+// parseBody parses the tag=value fields of a message body
+func parseBody(body []byte) (msg *Message, err error) {
+	if len(body) == 0 || body[len(body)-1] != soh {
+		err = fmt.Errorf("Message body must end with SOH")
+		return
+	}
+
+	msg = &Message{}
+	for _, rawField := range bytes.Split(body[:len(body)-1], []byte{soh}) {
+		var field Field
+		if field, err = parseField(rawField); err != nil {
+			return
+		}
+		msg.Fields = append(msg.Fields, field)
+	}
+
+	if len(msg.Fields) == 0 || msg.Fields[0].Tag != TagMsgType {
+		err = fmt.Errorf("MsgType must be the first field of the body")
+		return
+	}
+	return
+}
+
+// parseField parses one tag=value field, without its SOH
+func parseField(rawField []byte) (field Field, err error) {
+	eq := bytes.IndexByte(rawField, '=')
+	if eq <= 0 {
+		err = fmt.Errorf("Invalid field %q, must be tag=value", rawField)
+		return
+	}
+	if field.Tag, err = strconv.Atoi(string(rawField[:eq])); err != nil || field.Tag <= 0 {
+		err = fmt.Errorf("Invalid tag in field %q", rawField)
+		return
+	}
+	field.Value = string(rawField[eq+1:])
+	return
+}
+
+// readField reads one field ending in SOH, and writes it to raw if raw isn't nil
+func readField(r *bufio.Reader, raw *bytes.Buffer) (tag int, value string, err error) {
+	var line []byte
+	if line, err = r.ReadSlice(soh); err != nil {
+		if err == bufio.ErrBufferFull {
+			err = fmt.Errorf("Field too long")
+		}
+		return
+	}
+	if raw != nil {
+		raw.Write(line)
+	}
+
+	var field Field
+	if field, err = parseField(line[:len(line)-1]); err != nil {
+		return
+	}
+	tag, value = field.Tag, field.Value
+	return
+}
+
+// writeField writes a tag=value field and its SOH
+func writeField(buf *bytes.Buffer, tag int, value string) {
+	buf.WriteString(strconv.Itoa(tag))
+	buf.WriteByte('=')
+	buf.WriteString(value)
+	buf.WriteByte(soh)
+	return
+}
+
+// checksum is the sum of the bytes mod 256
+func checksum(data []byte) (sum int) {
+	for _, b := range data {
+		sum += int(b)
+	}
+	sum %= 256
+	return
+}
@@ -0,0 +1,79 @@
+package cxfix
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// TestMessageRoundTrip writes a message and reads it back
+func TestMessageRoundTrip(t *testing.T) {
+	msg := NewMessage(MsgTypeNewOrderSingle)
+	msg.Set(TagClOrdID, "order1")
+	msg.Set(TagSymbol, "btc/ltc")
+	msg.SetFloat(TagPrice, 1.5)
+	msg.SetUint(TagOrderQty, 1000)
+	// header fields should be written before the body even if they're set after
+	msg.Set(TagSenderCompID, "sender")
+	msg.SetUint(TagMsgSeqNum, 7)
+
+	encoded := msg.Bytes()
+	if !bytes.HasPrefix(encoded, []byte("8=FIX.4.4\x019=")) {
+		t.Errorf("Message should start with BeginString and BodyLength, got %q", encoded)
+		return
+	}
+
+	read, err := ReadMessage(bufio.NewReader(bytes.NewReader(encoded)))
+	if err != nil {
+		t.Errorf("Error reading message back: %s", err)
+		return
+	}
+	if read.MsgType() != MsgTypeNewOrderSingle {
+		t.Errorf("MsgType should be %s, got %s", MsgTypeNewOrderSingle, read.MsgType())
+		return
+	}
+	if read.Fields[1].Tag != TagSenderCompID || read.Fields[2].Tag != TagMsgSeqNum {
+		t.Errorf("Header fields should follow MsgType, got %v", read.Fields)
+		return
+	}
+
+	var seq uint64
+	if seq, err = read.GetUint(TagMsgSeqNum); err != nil || seq != 7 {
+		t.Errorf("MsgSeqNum should be 7, got %d, err %v", seq, err)
+		return
+	}
+	var price float64
+	if price, err = read.GetFloat(TagPrice); err != nil || price != 1.5 {
+		t.Errorf("Price should be 1.5, got %f, err %v", price, err)
+		return
+	}
+	if symbol, _ := read.Get(TagSymbol); symbol != "btc/ltc" {
+		t.Errorf("Symbol should be btc/ltc, got %s", symbol)
+		return
+	}
+}
+
+// TestReadMessageInvalid makes sure malformed messages are rejected
+func TestReadMessageInvalid(t *testing.T) {
+	valid := NewMessage(MsgTypeHeartbeat).Bytes()
+
+	badChecksum := append([]byte{}, valid...)
+	badChecksum[len(badChecksum)-2]++
+
+	badLength := bytes.Replace(valid, []byte("\x019="), []byte("\x019=1"), 1)
+
+	tests := map[string][]byte{
+		"wrong version":  bytes.Replace(valid, []byte("FIX.4.4"), []byte("FIX.4.2"), 1),
+		"bad checksum":   badChecksum,
+		"bad length":     badLength,
+		"truncated":      valid[:len(valid)-4],
+		"no msgtype":     (&Message{Fields: []Field{{Tag: TagMsgSeqNum, Value: "1"}}}).Bytes(),
+		"missing equals": []byte("8FIX.4.4\x01"),
+	}
+	for name, encoded := range tests {
+		if _, err := ReadMessage(bufio.NewReader(bytes.NewReader(encoded))); err == nil {
+			t.Errorf("Reading message with %s should fail", name)
+			return
+		}
+	}
+}
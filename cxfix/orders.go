@@ -0,0 +1,407 @@
+package cxfix
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mit-dci/opencx/cxserver"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// FIX field values used in order messages
+const (
+	sideBuy  = "1"
+	sideSell = "2"
+
+	ordTypeLimit = "2"
+
+	execTypeNew      = "0"
+	execTypeCanceled = "4"
+	execTypeReplaced = "5"
+	execTypeRejected = "8"
+	execTypeTrade    = "F"
+
+	ordStatusNew             = "0"
+	ordStatusPartiallyFilled = "1"
+	ordStatusFilled          = "2"
+	ordStatusCanceled        = "4"
+	ordStatusRejected        = "8"
+
+	ordRejReasonDuplicate = 6
+	ordRejReasonOther     = 99
+
+	cxlRejResponseToCancel  = "1"
+	cxlRejResponseToReplace = "2"
+)
+
+// newOrderSingle places a limit order and reports it as new, or rejects it. mtx must be held.
+func (sess *session) newOrderSingle(msg *Message) (err error) {
+	clOrdID, _ := msg.Get(TagClOrdID)
+
+	var order *sessionOrder
+	var limitOrder *match.LimitOrder
+	if order, limitOrder, err = sess.parseOrder(msg); err != nil {
+		err = sess.rejectOrder(msg, ordRejReasonOther, err.Error())
+		return
+	}
+	if _, _, found := sess.findByClOrdID(clOrdID); found {
+		err = sess.rejectOrder(msg, ordRejReasonDuplicate, fmt.Sprintf("ClOrdID %s is already used by an open order", clOrdID))
+		return
+	}
+
+	var orderID *match.OrderID
+	if orderID, err = sess.acceptor.server.PlaceOrder(limitOrder); err != nil {
+		err = sess.rejectOrder(msg, ordRejReasonOther, err.Error())
+		return
+	}
+
+	id := hex.EncodeToString(orderID[:])
+	sess.state.Orders[id] = order
+	err = sess.send(sess.executionReport(id, order, execTypeNew, ordStatusNew))
+	return
+}
+
+// orderCancelRequest cancels an open order placed over the session. mtx must be held.
+func (sess *session) orderCancelRequest(msg *Message) (err error) {
+	var id string
+	var order *sessionOrder
+	if id, order, err = sess.findOrder(msg); err != nil {
+		err = sess.rejectCancel(msg, "", nil, cxlRejResponseToCancel, err.Error())
+		return
+	}
+
+	if err = sess.cancelOrder(id); err != nil {
+		err = sess.rejectCancel(msg, id, order, cxlRejResponseToCancel, err.Error())
+		return
+	}
+	delete(sess.state.Orders, id)
+
+	report := sess.executionReport(id, order, execTypeCanceled, ordStatusCanceled)
+	clOrdID, _ := msg.Get(TagClOrdID)
+	report.Set(TagClOrdID, clOrdID)
+	report.Set(TagOrigClOrdID, order.ClOrdID)
+	err = sess.send(report)
+	return
+}
+
+// orderCancelReplaceRequest cancels an open order and places its replacement. The replacement is a
+// new order on the book, with a new OrderID. If the replacement can't be placed, the original
+// stays cancelled. mtx must be held.
+func (sess *session) orderCancelReplaceRequest(msg *Message) (err error) {
+	var id string
+	var order *sessionOrder
+	if id, order, err = sess.findOrder(msg); err != nil {
+		err = sess.rejectCancel(msg, "", nil, cxlRejResponseToReplace, err.Error())
+		return
+	}
+
+	var replacement *sessionOrder
+	var limitOrder *match.LimitOrder
+	if replacement, limitOrder, err = sess.parseOrder(msg); err != nil {
+		err = sess.rejectCancel(msg, id, order, cxlRejResponseToReplace, err.Error())
+		return
+	}
+	if replacement.Symbol != order.Symbol || replacement.Side != order.Side {
+		err = sess.rejectCancel(msg, id, order, cxlRejResponseToReplace, "Symbol and Side can't be replaced")
+		return
+	}
+	if _, _, found := sess.findByClOrdID(replacement.ClOrdID); found {
+		err = sess.rejectCancel(msg, id, order, cxlRejResponseToReplace, fmt.Sprintf("ClOrdID %s is already used by an open order", replacement.ClOrdID))
+		return
+	}
+
+	if err = sess.cancelOrder(id); err != nil {
+		err = sess.rejectCancel(msg, id, order, cxlRejResponseToReplace, err.Error())
+		return
+	}
+	delete(sess.state.Orders, id)
+
+	var orderID *match.OrderID
+	var placeErr error
+	if orderID, placeErr = sess.acceptor.server.PlaceOrder(limitOrder); placeErr != nil {
+		report := sess.executionReport(id, order, execTypeCanceled, ordStatusCanceled)
+		report.Set(TagClOrdID, replacement.ClOrdID)
+		report.Set(TagOrigClOrdID, order.ClOrdID)
+		report.Set(TagText, fmt.Sprintf("Order was cancelled but the replacement was rejected: %s", placeErr))
+		err = sess.send(report)
+		return
+	}
+
+	newID := hex.EncodeToString(orderID[:])
+	sess.state.Orders[newID] = replacement
+	report := sess.executionReport(newID, replacement, execTypeReplaced, ordStatusNew)
+	report.Set(TagOrigClOrdID, order.ClOrdID)
+	err = sess.send(report)
+	return
+}
+
+// parseOrder turns the order fields of a NewOrderSingle or OrderCancelReplaceRequest into an order
+// for the session and a limit order for the server. OrderQty is the amount of the asset you have,
+// like AmountHave, and Price is how much you want for each unit of it.
+func (sess *session) parseOrder(msg *Message) (order *sessionOrder, limitOrder *match.LimitOrder, err error) {
+	order = &sessionOrder{}
+	limitOrder = &match.LimitOrder{}
+	copy(limitOrder.Pubkey[:], sess.pubkey.SerializeCompressed())
+
+	var ok bool
+	if order.ClOrdID, ok = msg.Get(TagClOrdID); !ok || order.ClOrdID == "" {
+		err = fmt.Errorf("ClOrdID is required")
+		return
+	}
+
+	// pairs are written like btc/ltc
+	if order.Symbol, ok = msg.Get(TagSymbol); !ok || !strings.Contains(order.Symbol, "/") {
+		err = fmt.Errorf("Symbol must be a pair like btc/ltc")
+		return
+	}
+	if err = limitOrder.TradingPair.FromString(order.Symbol); err != nil {
+		err = fmt.Errorf("Error parsing Symbol as a pair: %s", err)
+		return
+	}
+
+	order.Side, _ = msg.Get(TagSide)
+	switch order.Side {
+	case sideBuy:
+		limitOrder.Side = match.Buy
+	case sideSell:
+		limitOrder.Side = match.Sell
+	default:
+		err = fmt.Errorf("Side must be %s for buy or %s for sell", sideBuy, sideSell)
+		return
+	}
+
+	if ordType, _ := msg.Get(TagOrdType); ordType != ordTypeLimit {
+		err = fmt.Errorf("Only limit orders, OrdType %s, are supported", ordTypeLimit)
+		return
+	}
+
+	if order.OrderQty, err = msg.GetUint(TagOrderQty); err != nil {
+		return
+	}
+	if order.Price, err = msg.GetFloat(TagPrice); err != nil {
+		return
+	}
+	if order.OrderQty == 0 || order.Price <= 0 {
+		err = fmt.Errorf("OrderQty and Price must be positive")
+		return
+	}
+
+	limitOrder.AmountHave = order.OrderQty
+	limitOrder.AmountWant = uint64(order.Price * float64(order.OrderQty))
+	return
+}
+
+// findOrder finds the open order a cancel or replace is for, by OrderID if it's given and otherwise
+// by OrigClOrdID
+func (sess *session) findOrder(msg *Message) (id string, order *sessionOrder, err error) {
+	var ok bool
+	if id, ok = msg.Get(TagOrderID); ok {
+		if order, ok = sess.state.Orders[id]; !ok {
+			err = fmt.Errorf("No open order with OrderID %s on this session", id)
+		}
+		return
+	}
+
+	origClOrdID, _ := msg.Get(TagOrigClOrdID)
+	if id, order, ok = sess.findByClOrdID(origClOrdID); !ok {
+		err = fmt.Errorf("No open order with ClOrdID %s on this session", origClOrdID)
+		return
+	}
+	return
+}
+
+// findByClOrdID finds an open order by its ClOrdID
+func (sess *session) findByClOrdID(clOrdID string) (id string, order *sessionOrder, found bool) {
+	for id, order = range sess.state.Orders {
+		if order.ClOrdID == clOrdID {
+			found = true
+			return
+		}
+	}
+	id, order = "", nil
+	return
+}
+
+// cancelOrder cancels an order on the server, making sure it belongs to the session's pubkey
+func (sess *session) cancelOrder(id string) (err error) {
+	orderID := new(match.OrderID)
+	if err = orderID.UnmarshalText([]byte(id)); err != nil {
+		return
+	}
+
+	var orderPair *match.LimitOrderIDPair
+	if orderPair, err = sess.acceptor.server.GetOrder(orderID); err != nil {
+		err = fmt.Errorf("Error getting order to cancel: %s", err)
+		return
+	}
+	if !bytes.Equal(orderPair.Order.Pubkey[:], sess.pubkey.SerializeCompressed()) {
+		err = fmt.Errorf("Order does not belong to this session")
+		return
+	}
+
+	if err = sess.acceptor.server.CancelOrder(orderPair); err != nil {
+		err = fmt.Errorf("Error cancelling order: %s", err)
+		return
+	}
+	return
+}
+
+// executionReport creates an execution report for an order
+func (sess *session) executionReport(id string, order *sessionOrder, execType string, ordStatus string) (report *Message) {
+	report = NewMessage(MsgTypeExecutionReport)
+	report.Set(TagOrderID, id)
+	report.Set(TagClOrdID, order.ClOrdID)
+	report.Set(TagExecID, newExecID())
+	report.Set(TagExecType, execType)
+	report.Set(TagOrdStatus, ordStatus)
+	report.Set(TagSymbol, order.Symbol)
+	report.Set(TagSide, order.Side)
+	report.Set(TagOrdType, ordTypeLimit)
+	report.SetUint(TagOrderQty, order.OrderQty)
+	report.SetFloat(TagPrice, order.Price)
+
+	var leavesQty uint64
+	switch ordStatus {
+	case ordStatusNew, ordStatusPartiallyFilled:
+		leavesQty = order.OrderQty - order.CumQty
+	}
+	report.SetUint(TagLeavesQty, leavesQty)
+	report.SetUint(TagCumQty, order.CumQty)
+	if order.CumQty != 0 {
+		report.SetFloat(TagAvgPx, order.Price)
+	} else {
+		report.SetFloat(TagAvgPx, 0)
+	}
+	report.SetTime(TagTransactTime, time.Now())
+	return
+}
+
+// rejectOrder rejects a NewOrderSingle with an execution report
+func (sess *session) rejectOrder(msg *Message, reason int, text string) (err error) {
+	order := &sessionOrder{}
+	order.ClOrdID, _ = msg.Get(TagClOrdID)
+	order.Symbol, _ = msg.Get(TagSymbol)
+	order.Side, _ = msg.Get(TagSide)
+	order.OrderQty, _ = msg.GetUint(TagOrderQty)
+	order.Price, _ = msg.GetFloat(TagPrice)
+
+	report := sess.executionReport("NONE", order, execTypeRejected, ordStatusRejected)
+	report.SetUint(TagOrdRejReason, uint64(reason))
+	report.Set(TagText, text)
+	err = sess.send(report)
+	return
+}
+
+// rejectCancel rejects a cancel or replace request. order is nil if there's no such open order.
+func (sess *session) rejectCancel(msg *Message, id string, order *sessionOrder, responseTo string, text string) (err error) {
+	reject := NewMessage(MsgTypeOrderCancelReject)
+	clOrdID, _ := msg.Get(TagClOrdID)
+	origClOrdID, _ := msg.Get(TagOrigClOrdID)
+	ordStatus := ordStatusRejected
+	if order == nil {
+		id = "NONE"
+	} else if order.CumQty != 0 {
+		ordStatus = ordStatusPartiallyFilled
+	} else {
+		ordStatus = ordStatusNew
+	}
+
+	reject.Set(TagOrderID, id)
+	reject.Set(TagClOrdID, clOrdID)
+	reject.Set(TagOrigClOrdID, origClOrdID)
+	reject.Set(TagOrdStatus, ordStatus)
+	reject.Set(TagCxlRejResponseTo, responseTo)
+	reject.Set(TagText, text)
+	err = sess.send(reject)
+	return
+}
+
+// pumpEvents turns the user events for the session's pubkey into execution reports, until the
+// session is done. The cursor is saved with the session, so reports pick up where they left off
+// when the counterparty logs back on.
+func (sess *session) pumpEvents() {
+	for {
+		select {
+		case <-sess.done:
+			return
+		default:
+		}
+
+		sess.mtx.Lock()
+		epoch := sess.state.EventEpoch
+		cursor := sess.state.EventCursor
+		sess.mtx.Unlock()
+
+		events, gap, feedEpoch, lastSeq, err := sess.acceptor.server.UserEvents(sess.pubkey, epoch, cursor, EventPollWait)
+		if err != nil {
+			logging.Errorf("Error getting user events for FIX session %s: %s", sess.senderCompID, err)
+			sess.logout("Internal error")
+			return
+		}
+
+		sess.mtx.Lock()
+		if gap {
+			logging.Warnf("FIX session %s missed user events after %d:%d, continuing from %d:%d", sess.senderCompID, epoch, cursor, feedEpoch, lastSeq)
+			sess.state.EventCursor = lastSeq
+		}
+		sess.state.EventEpoch = feedEpoch
+		for _, event := range events {
+			sess.reportEvent(event)
+			sess.state.EventCursor = event.Seq
+		}
+		if gap || len(events) != 0 || epoch != feedEpoch {
+			if err = sess.state.save(sess.statePath); err != nil {
+				logging.Errorf("Error saving FIX session state for %s: %s", sess.senderCompID, err)
+			}
+		}
+		sess.mtx.Unlock()
+	}
+}
+
+// reportEvent sends an execution report for a fill or cancel of an open order on the session.
+// Acks and cancels requested over the session were already reported when they were handled, and
+// deposits and withdrawals aren't reported over FIX. mtx must be held.
+func (sess *session) reportEvent(event *cxserver.UserEvent) {
+	id := hex.EncodeToString(event.OrderID[:])
+	order, ok := sess.state.Orders[id]
+	if !ok {
+		return
+	}
+
+	var report *Message
+	switch event.Type {
+	case cxserver.OrderFill:
+		order.CumQty += event.AmountHave
+		ordStatus := ordStatusPartiallyFilled
+		if event.Filled {
+			ordStatus = ordStatusFilled
+			delete(sess.state.Orders, id)
+		}
+		report = sess.executionReport(id, order, execTypeTrade, ordStatus)
+		report.SetUint(TagLastQty, event.AmountHave)
+		report.SetFloat(TagLastPx, event.Price)
+	case cxserver.OrderCancel:
+		delete(sess.state.Orders, id)
+		report = sess.executionReport(id, order, execTypeCanceled, ordStatusCanceled)
+	default:
+		return
+	}
+
+	if err := sess.send(report); err != nil {
+		logging.Warnf("Error sending execution report to %s: %s", sess.senderCompID, err)
+	}
+	return
+}
+
+// newExecID returns a random ExecID
+func newExecID() (execID string) {
+	var execIDBytes [8]byte
+	rand.Read(execIDBytes[:])
+	execID = hex.EncodeToString(execIDBytes[:])
+	return
+}
@@ -0,0 +1,409 @@
+package cxfix
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/logging"
+)
+
+const (
+	// WriteTimeout is how long writing a message to a counterparty can take
+	WriteTimeout = 10 * time.Second
+	// EventPollWait is how long a session waits for user events before checking if it's done
+	EventPollWait = time.Second
+)
+
+// Session level reject reasons
+const (
+	rejectRequiredTagMissing = 1
+	rejectValueIncorrect     = 5
+	rejectInvalidMsgType     = 11
+)
+
+// session is a logged on FIX session. Handling messages, sending, and turning user events into
+// reports all happen with mtx held, so reports go out in the same order things happened.
+type session struct {
+	acceptor     *Acceptor
+	conn         net.Conn
+	reader       *bufio.Reader
+	pubkey       *koblitz.PublicKey
+	senderCompID string
+	heartbeat    time.Duration
+
+	state     *sessionState
+	statePath string
+	// lastSent is when we last sent something, for heartbeats
+	lastSent time.Time
+	// resendPending is set when we asked the counterparty to resend, so we only ask once per gap
+	resendPending bool
+	closed        bool
+	mtx           sync.Mutex
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// start loads the session state, checks the sequence number of the logon, and responds to it
+func (sess *session) start(logon *Message) (err error) {
+	if sess.state, err = loadState(sess.statePath); err != nil {
+		return
+	}
+
+	reset, _ := logon.Get(TagResetSeqNumFlag)
+	if reset == "Y" {
+		sess.state.NextOutgoingSeq = 1
+		sess.state.NextIncomingSeq = 1
+	}
+
+	var seq uint64
+	if seq, err = logon.GetUint(TagMsgSeqNum); err != nil {
+		return
+	}
+	if seq < sess.state.NextIncomingSeq {
+		err = fmt.Errorf("MsgSeqNum too low, expecting %d but received %d", sess.state.NextIncomingSeq, seq)
+		return
+	}
+	gap := seq > sess.state.NextIncomingSeq
+	if !gap {
+		sess.state.NextIncomingSeq = seq + 1
+	}
+
+	sess.mtx.Lock()
+	response := NewMessage(MsgTypeLogon)
+	response.Set(TagEncryptMethod, "0")
+	response.SetUint(TagHeartBtInt, uint64(sess.heartbeat/time.Second))
+	if reset == "Y" {
+		response.Set(TagResetSeqNumFlag, "Y")
+	}
+	if err = sess.send(response); err != nil {
+		sess.mtx.Unlock()
+		return
+	}
+	if gap {
+		if err = sess.requestResend(); err != nil {
+			sess.mtx.Unlock()
+			return
+		}
+	}
+	sess.mtx.Unlock()
+
+	logging.Infof("FIX session for %s logged on, next incoming seq %d, next outgoing seq %d", sess.senderCompID, sess.state.NextIncomingSeq, sess.state.NextOutgoingSeq)
+	return
+}
+
+// run serves the session until it logs out or the connection drops
+func (sess *session) run() {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		sess.heartbeatLoop()
+		wg.Done()
+	}()
+	go func() {
+		sess.pumpEvents()
+		wg.Done()
+	}()
+
+	sess.readLoop()
+	sess.close()
+
+	// wait so a new session for the same pubkey can't load the state before we're done with it
+	wg.Wait()
+	logging.Infof("FIX session for %s ended", sess.senderCompID)
+	return
+}
+
+// readLoop reads and handles messages, sending a test request if the counterparty goes quiet for
+// a heartbeat interval, and giving up if it stays quiet after that
+func (sess *session) readLoop() {
+	timeout := sess.heartbeat + sess.heartbeat/5
+	testRequestSent := false
+	for {
+		sess.conn.SetReadDeadline(time.Now().Add(timeout))
+		msg, err := ReadMessage(sess.reader)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			if testRequestSent {
+				sess.logout("Heartbeat timed out")
+				return
+			}
+			testRequestSent = true
+			testRequest := NewMessage(MsgTypeTestRequest)
+			testRequest.Set(TagTestReqID, fmt.Sprintf("%d", time.Now().Unix()))
+			sess.mtx.Lock()
+			err = sess.send(testRequest)
+			sess.mtx.Unlock()
+			if err != nil {
+				logging.Warnf("Error sending test request to %s: %s", sess.senderCompID, err)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			sess.mtx.Lock()
+			closed := sess.closed
+			sess.mtx.Unlock()
+			if !closed {
+				logging.Warnf("Error reading from FIX session %s: %s", sess.senderCompID, err)
+			}
+			return
+		}
+		testRequestSent = false
+
+		sess.mtx.Lock()
+		keepGoing := sess.handle(msg)
+		sess.mtx.Unlock()
+		if !keepGoing {
+			return
+		}
+	}
+}
+
+// heartbeatLoop sends a heartbeat whenever nothing else has been sent for a heartbeat interval
+func (sess *session) heartbeatLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-sess.done:
+			return
+		case <-ticker.C:
+		}
+
+		sess.mtx.Lock()
+		if time.Since(sess.lastSent) >= sess.heartbeat {
+			if err := sess.send(NewMessage(MsgTypeHeartbeat)); err != nil {
+				logging.Warnf("Error sending heartbeat to %s: %s", sess.senderCompID, err)
+			}
+		}
+		sess.mtx.Unlock()
+	}
+}
+
+// handle handles one message from the counterparty, and returns false if the session should end.
+// mtx must be held.
+func (sess *session) handle(msg *Message) (keepGoing bool) {
+	sender, _ := msg.Get(TagSenderCompID)
+	target, _ := msg.Get(TagTargetCompID)
+	if sender != sess.senderCompID || target != sess.acceptor.compID {
+		sess.sendLogout("SenderCompID and TargetCompID must match the logon")
+		return
+	}
+
+	seq, err := msg.GetUint(TagMsgSeqNum)
+	if err != nil {
+		sess.sendLogout(err.Error())
+		return
+	}
+
+	// a reset, rather than a gap fill, sets the sequence number no matter what its own is
+	if gapFill, _ := msg.Get(TagGapFillFlag); msg.MsgType() == MsgTypeSequenceReset && gapFill != "Y" {
+		keepGoing = true
+		sess.sequenceReset(msg, seq)
+		return
+	}
+
+	if seq < sess.state.NextIncomingSeq {
+		if possDup, _ := msg.Get(TagPossDupFlag); possDup == "Y" {
+			keepGoing = true
+			return
+		}
+		sess.sendLogout(fmt.Sprintf("MsgSeqNum too low, expecting %d but received %d", sess.state.NextIncomingSeq, seq))
+		return
+	}
+	if seq > sess.state.NextIncomingSeq {
+		// we drop everything after a gap, and the resend will send it again
+		if !sess.resendPending {
+			if err = sess.requestResend(); err != nil {
+				logging.Warnf("Error requesting resend from %s: %s", sess.senderCompID, err)
+				return
+			}
+		}
+		keepGoing = true
+		return
+	}
+
+	sess.state.NextIncomingSeq++
+	sess.resendPending = false
+	if err = sess.state.save(sess.statePath); err != nil {
+		logging.Errorf("Error saving FIX session state for %s: %s", sess.senderCompID, err)
+		return
+	}
+
+	keepGoing = true
+	switch msg.MsgType() {
+	case MsgTypeHeartbeat, MsgTypeReject:
+	case MsgTypeTestRequest:
+		heartbeat := NewMessage(MsgTypeHeartbeat)
+		if testReqID, ok := msg.Get(TagTestReqID); ok {
+			heartbeat.Set(TagTestReqID, testReqID)
+		}
+		err = sess.send(heartbeat)
+	case MsgTypeResendRequest:
+		// we don't keep sent messages, so we gap fill over them. Open orders can be checked with
+		// the other APIs.
+		var beginSeqNo uint64
+		if beginSeqNo, err = msg.GetUint(TagBeginSeqNo); err != nil {
+			err = sess.sendReject(seq, rejectRequiredTagMissing, err.Error())
+			break
+		}
+		err = sess.sendGapFill(beginSeqNo)
+	case MsgTypeSequenceReset:
+		sess.sequenceReset(msg, seq)
+	case MsgTypeLogout:
+		sess.sendLogout("Logout acknowledged")
+		keepGoing = false
+	case MsgTypeLogon:
+		err = sess.sendReject(seq, rejectValueIncorrect, "Already logged on")
+	case MsgTypeNewOrderSingle:
+		err = sess.newOrderSingle(msg)
+	case MsgTypeOrderCancelRequest:
+		err = sess.orderCancelRequest(msg)
+	case MsgTypeOrderCancelReplaceRequest:
+		err = sess.orderCancelReplaceRequest(msg)
+	default:
+		err = sess.sendReject(seq, rejectInvalidMsgType, fmt.Sprintf("MsgType %s is not supported", msg.MsgType()))
+	}
+	if err != nil {
+		logging.Warnf("Error handling FIX message from %s: %s", sess.senderCompID, err)
+		keepGoing = false
+	}
+	return
+}
+
+// sequenceReset moves the next incoming sequence number forward. mtx must be held.
+func (sess *session) sequenceReset(msg *Message, seq uint64) {
+	newSeqNo, err := msg.GetUint(TagNewSeqNo)
+	if err != nil || newSeqNo < sess.state.NextIncomingSeq {
+		sess.sendReject(seq, rejectValueIncorrect, "NewSeqNo must be at least the next expected sequence number")
+		return
+	}
+	sess.state.NextIncomingSeq = newSeqNo
+	sess.resendPending = false
+	if err = sess.state.save(sess.statePath); err != nil {
+		logging.Errorf("Error saving FIX session state for %s: %s", sess.senderCompID, err)
+	}
+	return
+}
+
+// send sends a message with the next outgoing sequence number. The sequence number is saved before
+// the message is written, so it's never used twice. mtx must be held.
+func (sess *session) send(msg *Message) (err error) {
+	if sess.closed {
+		err = fmt.Errorf("Session is closed")
+		return
+	}
+
+	msg.Set(TagSenderCompID, sess.acceptor.compID)
+	msg.Set(TagTargetCompID, sess.senderCompID)
+	msg.SetUint(TagMsgSeqNum, sess.state.NextOutgoingSeq)
+	msg.SetTime(TagSendingTime, time.Now())
+
+	sess.state.NextOutgoingSeq++
+	if err = sess.state.save(sess.statePath); err != nil {
+		return
+	}
+
+	err = sess.write(msg)
+	return
+}
+
+// write writes a message to the connection. mtx must be held.
+func (sess *session) write(msg *Message) (err error) {
+	sess.conn.SetWriteDeadline(time.Now().Add(WriteTimeout))
+	if _, err = sess.conn.Write(msg.Bytes()); err != nil {
+		err = fmt.Errorf("Error writing FIX message: %s", err)
+		return
+	}
+	sess.lastSent = time.Now()
+	return
+}
+
+// sendGapFill responds to a resend request with a sequence reset that fills the gap from
+// beginSeqNo up to our next sequence number. mtx must be held.
+func (sess *session) sendGapFill(beginSeqNo uint64) (err error) {
+	if beginSeqNo == 0 || beginSeqNo >= sess.state.NextOutgoingSeq {
+		return
+	}
+
+	now := time.Now()
+	gapFill := NewMessage(MsgTypeSequenceReset)
+	gapFill.Set(TagSenderCompID, sess.acceptor.compID)
+	gapFill.Set(TagTargetCompID, sess.senderCompID)
+	gapFill.SetUint(TagMsgSeqNum, beginSeqNo)
+	gapFill.Set(TagPossDupFlag, "Y")
+	gapFill.SetTime(TagSendingTime, now)
+	gapFill.SetTime(TagOrigSendingTime, now)
+	gapFill.Set(TagGapFillFlag, "Y")
+	gapFill.SetUint(TagNewSeqNo, sess.state.NextOutgoingSeq)
+
+	err = sess.write(gapFill)
+	return
+}
+
+// requestResend asks the counterparty to resend everything from the next sequence number we
+// expect. mtx must be held.
+func (sess *session) requestResend() (err error) {
+	resendRequest := NewMessage(MsgTypeResendRequest)
+	resendRequest.SetUint(TagBeginSeqNo, sess.state.NextIncomingSeq)
+	resendRequest.SetUint(TagEndSeqNo, 0)
+	if err = sess.send(resendRequest); err != nil {
+		return
+	}
+	sess.resendPending = true
+	return
+}
+
+// sendReject rejects a message at the session level. mtx must be held.
+func (sess *session) sendReject(refSeqNum uint64, reason int, text string) (err error) {
+	reject := NewMessage(MsgTypeReject)
+	reject.SetUint(TagRefSeqNum, refSeqNum)
+	reject.SetUint(TagSessionRejReason, uint64(reason))
+	reject.Set(TagText, text)
+	err = sess.send(reject)
+	return
+}
+
+// sendLogout sends a logout and closes the session. mtx must be held.
+func (sess *session) sendLogout(text string) {
+	if sess.closed {
+		return
+	}
+	logout := NewMessage(MsgTypeLogout)
+	logout.Set(TagText, text)
+	if err := sess.send(logout); err != nil {
+		logging.Warnf("Error sending logout to %s: %s", sess.senderCompID, err)
+	}
+	sess.closeLocked()
+	return
+}
+
+// logout sends a logout and closes the session
+func (sess *session) logout(text string) {
+	sess.mtx.Lock()
+	sess.sendLogout(text)
+	sess.mtx.Unlock()
+	return
+}
+
+// close closes the session
+func (sess *session) close() {
+	sess.mtx.Lock()
+	sess.closeLocked()
+	sess.mtx.Unlock()
+	return
+}
+
+// closeLocked closes the connection and stops the session's loops. mtx must be held.
+func (sess *session) closeLocked() {
+	sess.closed = true
+	sess.closeOnce.Do(func() {
+		close(sess.done)
+		sess.conn.Close()
+	})
+	return
+}
@@ -0,0 +1,90 @@
+package cxfix
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// sessionOrder is an order placed over a FIX session, which gets execution reports until it's
+// filled or cancelled
+type sessionOrder struct {
+	ClOrdID  string
+	Symbol   string
+	Side     string
+	Price    float64
+	OrderQty uint64
+	CumQty   uint64
+}
+
+// sessionState is what's kept about a session across reconnects and restarts, so sequence numbers
+// continue where they left off and open orders still get reports
+type sessionState struct {
+	// NextOutgoingSeq is the MsgSeqNum of the next message we send
+	NextOutgoingSeq uint64
+	// NextIncomingSeq is the MsgSeqNum we expect next from the counterparty
+	NextIncomingSeq uint64
+	// EventEpoch and EventCursor are the epoch and seq of the last user event from the server that
+	// was turned into reports
+	EventEpoch  uint64
+	EventCursor uint64
+	// Orders are the open orders placed over the session, by order ID
+	Orders map[string]*sessionOrder
+}
+
+// loadState reads the session state at path, or returns a fresh state if there isn't one yet
+func loadState(path string) (state *sessionState, err error) {
+	state = &sessionState{
+		NextOutgoingSeq: 1,
+		NextIncomingSeq: 1,
+		Orders:          make(map[string]*sessionOrder),
+	}
+
+	var stateBytes []byte
+	if stateBytes, err = ioutil.ReadFile(path); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		} else {
+			err = fmt.Errorf("Error reading session state: %s", err)
+		}
+		return
+	}
+
+	if err = json.Unmarshal(stateBytes, state); err != nil {
+		err = fmt.Errorf("Error decoding session state from %s: %s", path, err)
+		return
+	}
+	if state.Orders == nil {
+		state.Orders = make(map[string]*sessionOrder)
+	}
+	return
+}
+
+// save writes the session state to path. It writes to a temporary file first so a crash can't
+// leave a half written state.
+func (state *sessionState) save(path string) (err error) {
+	var stateBytes []byte
+	if stateBytes, err = json.Marshal(state); err != nil {
+		err = fmt.Errorf("Error encoding session state: %s", err)
+		return
+	}
+
+	tmpPath := path + ".tmp"
+	if err = ioutil.WriteFile(tmpPath, stateBytes, 0600); err != nil {
+		err = fmt.Errorf("Error writing session state: %s", err)
+		return
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		err = fmt.Errorf("Error replacing session state: %s", err)
+		return
+	}
+	return
+}
+
+// statePath is where the state for a counterparty's session is kept
+func statePath(dir string, senderCompID string) (path string) {
+	path = filepath.Join(dir, senderCompID+".json")
+	return
+}
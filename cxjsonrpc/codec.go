@@ -0,0 +1,263 @@
+package cxjsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/rpc"
+	"sync"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// Version is the JSON-RPC version the gateway speaks
+const Version = "2.0"
+
+// JSON-RPC 2.0 error codes
+const (
+	// ParseError means the request was not valid JSON
+	ParseError = -32700
+	// InvalidRequest means the request was JSON but not a JSON-RPC 2.0 request
+	InvalidRequest = -32600
+	// MethodNotFound means there is no such service method
+	MethodNotFound = -32601
+	// InvalidParams means the params didn't decode into the method's args
+	InvalidParams = -32602
+	// ServerError means the method itself returned an error
+	ServerError = -32000
+	// RateLimited means the client made too many requests and should retry later
+	RateLimited = -32029
+)
+
+// Request is a JSON-RPC 2.0 request. Method is the same service method as for net/rpc, like
+// OpencxRPC.GetBalance, and Params are the method's args as an object, or an array with the args
+// as its only element.
+type Request struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// Response is a JSON-RPC 2.0 response. Result is the method's reply, and is only set if there's
+// no Error.
+type Response struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// Error is a JSON-RPC 2.0 error object. If the method failed with an error code from the match
+// package, it's in Data.
+type Error struct {
+	Code    int        `json:"code"`
+	Message string     `json:"message"`
+	Data    *ErrorData `json:"data,omitempty"`
+}
+
+// ErrorData is the data of an error from a method
+type ErrorData struct {
+	// ErrorCode is the stable code of the error, like INSUFFICIENT_FUNDS
+	ErrorCode match.ErrorCode `json:"errorCode"`
+}
+
+// Error returns the message and code of the error
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// pendingRequest is what the codec remembers about a request until net/rpc writes its response
+type pendingRequest struct {
+	id           json.RawMessage
+	notification bool
+	code         int
+}
+
+// serverCodec is a net/rpc ServerCodec for JSON-RPC 2.0. Requests are read one JSON value at a
+// time, so it works on a stream like a websocket as well as on a single HTTP body.
+type serverCodec struct {
+	dec    *json.Decoder
+	enc    *json.Encoder
+	closer io.Closer
+
+	// params of the request being read, and its seq
+	params json.RawMessage
+	seq    uint64
+
+	// pending holds the requests net/rpc hasn't responded to yet, by seq
+	pending map[uint64]*pendingRequest
+	mtx     sync.Mutex
+
+	// writeMtx makes sure parse errors and responses don't get written at the same time
+	writeMtx sync.Mutex
+}
+
+// newServerCodec creates a JSON-RPC 2.0 server codec that reads requests from and writes
+// responses to conn
+func newServerCodec(conn io.ReadWriteCloser) (codec *serverCodec) {
+	codec = &serverCodec{
+		dec:     json.NewDecoder(conn),
+		enc:     json.NewEncoder(conn),
+		closer:  conn,
+		pending: make(map[uint64]*pendingRequest),
+	}
+	return
+}
+
+// ReadRequestHeader reads the next request. A request that isn't valid JSON-RPC 2.0 is still
+// given to net/rpc, with no service method, so that it responds with an error.
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	var raw json.RawMessage
+	if err = c.dec.Decode(&raw); err != nil {
+		// we can't find the next request after bad JSON, so tell the client and stop
+		if _, ok := err.(*json.SyntaxError); ok {
+			c.write(&Response{
+				Version: Version,
+				Error:   &Error{Code: ParseError, Message: fmt.Sprintf("Error parsing request: %s", err)},
+			})
+		}
+		return
+	}
+
+	c.mtx.Lock()
+	c.seq++
+	c.params = nil
+	pending := &pendingRequest{code: ServerError}
+	c.pending[c.seq] = pending
+	r.Seq = c.seq
+	c.mtx.Unlock()
+
+	var req Request
+	if err = json.Unmarshal(raw, &req); err != nil || !validID(req.ID) {
+		err = nil
+		pending.code = InvalidRequest
+		r.ServiceMethod = ""
+		return
+	}
+	pending.id = req.ID
+	if req.Version != Version || req.Method == "" {
+		pending.code = InvalidRequest
+		r.ServiceMethod = ""
+		return
+	}
+
+	pending.notification = len(req.ID) == 0
+	r.ServiceMethod = req.Method
+	c.params = req.Params
+	return
+}
+
+// ReadRequestBody decodes the params of the request into the args. net/rpc passes nil when it
+// couldn't find the method.
+func (c *serverCodec) ReadRequestBody(x interface{}) (err error) {
+	c.mtx.Lock()
+	pending := c.pending[c.seq]
+	c.mtx.Unlock()
+
+	if x == nil {
+		if pending.code != InvalidRequest {
+			pending.code = MethodNotFound
+		}
+		return
+	}
+
+	params := bytes.TrimSpace(c.params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return
+	}
+
+	// params can also be an array with the args as the only element
+	if params[0] == '[' {
+		var paramList []json.RawMessage
+		if err = json.Unmarshal(params, &paramList); err != nil || len(paramList) != 1 {
+			pending.code = InvalidParams
+			err = fmt.Errorf("Params array must have exactly one element, the args")
+			return
+		}
+		params = paramList[0]
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(x); err != nil {
+		pending.code = InvalidParams
+		err = fmt.Errorf("Error decoding params: %s", err)
+		return
+	}
+
+	return
+}
+
+// WriteResponse writes the response to a request, unless the request was a notification
+func (c *serverCodec) WriteResponse(r *rpc.Response, x interface{}) (err error) {
+	c.mtx.Lock()
+	pending, ok := c.pending[r.Seq]
+	if !ok {
+		c.mtx.Unlock()
+		err = fmt.Errorf("Invalid sequence number %d in response", r.Seq)
+		return
+	}
+	delete(c.pending, r.Seq)
+	c.mtx.Unlock()
+
+	// notifications don't get responses, even if they fail
+	if pending.notification {
+		return
+	}
+
+	resp := &Response{
+		Version: Version,
+		ID:      pending.id,
+	}
+	// coded errors come in the reply, so they're errors too
+	if r.Error != "" {
+		resp.Error = &Error{Code: pending.code, Message: r.Error}
+	} else if replyErr := match.ReplyError(x); replyErr != nil {
+		errorCode := match.CodeOf(replyErr)
+		resp.Error = &Error{
+			Code:    ServerError,
+			Message: replyErr.Error(),
+			Data:    &ErrorData{ErrorCode: errorCode},
+		}
+		if errorCode == match.ErrRateLimited {
+			resp.Error.Code = RateLimited
+		}
+	} else {
+		resp.Result = x
+	}
+
+	err = c.write(resp)
+	return
+}
+
+// write encodes a response to the connection
+func (c *serverCodec) write(resp *Response) (err error) {
+	c.writeMtx.Lock()
+	err = c.enc.Encode(resp)
+	c.writeMtx.Unlock()
+	return
+}
+
+// Close closes the connection
+func (c *serverCodec) Close() error {
+	return c.closer.Close()
+}
+
+// validID returns true if a request id is missing, or a string, number, or null
+func validID(id json.RawMessage) (valid bool) {
+	if len(id) == 0 {
+		valid = true
+		return
+	}
+	var value interface{}
+	if err := json.Unmarshal(id, &value); err != nil {
+		return
+	}
+	switch value.(type) {
+	case nil, string, float64:
+		valid = true
+	}
+	return
+}
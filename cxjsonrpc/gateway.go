@@ -0,0 +1,247 @@
+package cxjsonrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/rpc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mit-dci/opencx/cxlimit"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// MaxBodySize is the largest HTTP request body the gateway will read
+	MaxBodySize = 1 << 20
+	// MaxBatchSize is the most requests the gateway will serve in one HTTP batch
+	MaxBatchSize = 100
+)
+
+// Gateway serves the methods of RPC receivers as JSON-RPC 2.0, over HTTP POST and over
+// WebSocket. The methods, args and replies are the same as for net/rpc, so calls that need a
+// signed request need one here too.
+type Gateway struct {
+	rpcServer  *rpc.Server
+	httpServer *http.Server
+	wsServer   websocket.Server
+	listener   net.Listener
+
+	// limits throttles requests by the address they come from, if set
+	limits *cxlimit.Limits
+
+	// wsConns are the open websockets, so Close can close them
+	wsConns map[*websocket.Conn]bool
+	connMtx sync.Mutex
+}
+
+// NewGateway creates a gateway for the RPC receivers. Each is registered like with rpc.Register,
+// so its methods are called by the name of its type, like OpencxRPC.GetBalance.
+func NewGateway(rcvrs ...interface{}) (gw *Gateway, err error) {
+	gw = &Gateway{
+		rpcServer: rpc.NewServer(),
+		wsConns:   make(map[*websocket.Conn]bool),
+	}
+	for _, rcvr := range rcvrs {
+		if err = gw.rpcServer.Register(rcvr); err != nil {
+			err = fmt.Errorf("Error registering receiver for JSON-RPC gateway: %s", err)
+			return
+		}
+	}
+
+	// Requests are signed, so we don't need to check where a websocket comes from
+	gw.wsServer = websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) (err error) { return },
+		Handler:   gw.serveWebSocket,
+	}
+	gw.httpServer = &http.Server{Handler: gw}
+	return
+}
+
+// SetLimits throttles requests by the address they come from. It should be called before Listen.
+func (gw *Gateway) SetLimits(limits *cxlimit.Limits) {
+	gw.limits = limits
+	return
+}
+
+// Listen starts serving the gateway on host and port
+func (gw *Gateway) Listen(host string, port uint16) (err error) {
+	serverAddr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
+	if gw.listener, err = net.Listen("tcp", serverAddr); err != nil {
+		err = fmt.Errorf("Error listening for JSON-RPC gateway: %s", err)
+		return
+	}
+
+	go gw.httpServer.Serve(gw.listener)
+	return
+}
+
+// Addr returns the address the gateway is listening on, or nil if it isn't
+func (gw *Gateway) Addr() (addr net.Addr) {
+	if gw.listener != nil {
+		addr = gw.listener.Addr()
+	}
+	return
+}
+
+// Close stops the gateway and closes any open websockets
+func (gw *Gateway) Close() (err error) {
+	if err = gw.httpServer.Close(); err != nil {
+		err = fmt.Errorf("Error closing JSON-RPC HTTP server: %s", err)
+		return
+	}
+
+	gw.closeWebSockets()
+	return
+}
+
+// Shutdown stops the gateway from taking new requests and waits up to timeout for the HTTP
+// requests being served to finish, then closes any open websockets. Websockets don't say when
+// they're done, so they're closed without waiting.
+func (gw *Gateway) Shutdown(timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err = gw.httpServer.Shutdown(ctx); err != nil {
+		err = fmt.Errorf("Error waiting for JSON-RPC requests to finish: %s", err)
+		gw.httpServer.Close()
+		gw.closeWebSockets()
+		return
+	}
+
+	gw.closeWebSockets()
+	return
+}
+
+// closeWebSockets closes every open websocket
+func (gw *Gateway) closeWebSockets() {
+	gw.connMtx.Lock()
+	for conn := range gw.wsConns {
+		conn.Close()
+	}
+	gw.connMtx.Unlock()
+	return
+}
+
+// ServeHTTP serves a websocket if the request asks to upgrade, and otherwise serves a POST with
+// a single JSON-RPC request or a batch.
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		gw.wsServer.ServeHTTP(w, req)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "JSON-RPC requests must be POSTed, or use a websocket", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, MaxBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error reading request body: %s", err), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var resp []byte
+	if resp, err = gw.serveBody(body, req.RemoteAddr); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// only notifications, so nothing to respond with
+	if len(resp) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(resp)
+	return
+}
+
+// serveBody serves the single request or batch in an HTTP body, and returns what to respond with.
+// The response is empty if every request was a notification.
+func (gw *Gateway) serveBody(body []byte, remoteAddr string) (resp []byte, err error) {
+	var raw json.RawMessage
+	if err = json.Unmarshal(body, &raw); err != nil {
+		resp, err = json.Marshal(&Response{
+			Version: Version,
+			Error:   &Error{Code: ParseError, Message: fmt.Sprintf("Error parsing request: %s", err)},
+		})
+		return
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if raw[0] != '[' {
+		resp = gw.serveRequest(raw, remoteAddr)
+		return
+	}
+
+	var batch []json.RawMessage
+	if err = json.Unmarshal(raw, &batch); err != nil {
+		err = fmt.Errorf("Error parsing batch: %s", err)
+		return
+	}
+	if len(batch) == 0 || len(batch) > MaxBatchSize {
+		resp, err = json.Marshal(&Response{
+			Version: Version,
+			Error:   &Error{Code: InvalidRequest, Message: fmt.Sprintf("Batch must have between 1 and %d requests", MaxBatchSize)},
+		})
+		return
+	}
+
+	var responses []json.RawMessage
+	for _, req := range batch {
+		if reqResp := gw.serveRequest(req, remoteAddr); len(reqResp) != 0 {
+			responses = append(responses, reqResp)
+		}
+	}
+
+	if len(responses) == 0 {
+		return
+	}
+	resp, err = json.Marshal(responses)
+	return
+}
+
+// serveRequest serves one request and returns the response, which is empty for a notification.
+// ServeRequest also returns the errors it responds with, so the response is all that matters.
+func (gw *Gateway) serveRequest(req []byte, remoteAddr string) (resp []byte) {
+	conn := &bufferConn{reader: bytes.NewReader(req)}
+	gw.rpcServer.ServeRequest(gw.limits.ServerCodec(newServerCodec(conn), remoteAddr))
+	resp = bytes.TrimSpace(conn.writer.Bytes())
+	return
+}
+
+// serveWebSocket serves JSON-RPC requests on a websocket until it closes. Responses can come
+// back in a different order than the requests, so clients should use ids.
+func (gw *Gateway) serveWebSocket(conn *websocket.Conn) {
+	gw.connMtx.Lock()
+	gw.wsConns[conn] = true
+	gw.connMtx.Unlock()
+
+	gw.rpcServer.ServeCodec(gw.limits.ServerCodec(newServerCodec(conn), conn.Request().RemoteAddr))
+
+	gw.connMtx.Lock()
+	delete(gw.wsConns, conn)
+	gw.connMtx.Unlock()
+	return
+}
+
+// bufferConn is a connection for a single HTTP request, which reads the request and buffers the
+// response
+type bufferConn struct {
+	reader io.Reader
+	writer bytes.Buffer
+}
+
+func (c *bufferConn) Read(p []byte) (int, error)  { return c.reader.Read(p) }
+func (c *bufferConn) Write(p []byte) (int, error) { return c.writer.Write(p) }
+func (c *bufferConn) Close() error                { return nil }
@@ -0,0 +1,373 @@
+package cxjsonrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+	"golang.org/x/net/websocket"
+)
+
+// TestRPC is a receiver with a method that works and one that fails
+type TestRPC struct{}
+
+type EchoArgs struct {
+	Message string
+	Nonce   [4]byte
+}
+
+type EchoReply struct {
+	Message string
+	Nonce   [4]byte
+	match.ErrorReply
+}
+
+// Echo replies with its args
+func (t *TestRPC) Echo(args EchoArgs, reply *EchoReply) (err error) {
+	reply.Message = args.Message
+	reply.Nonce = args.Nonce
+	return
+}
+
+// Fail always returns an error
+func (t *TestRPC) Fail(args EchoArgs, reply *EchoReply) (err error) {
+	err = fmt.Errorf("Error failing for Fail RPC command")
+	return
+}
+
+// FailCoded always returns an error with a code
+func (t *TestRPC) FailCoded(args EchoArgs, reply *EchoReply) (err error) {
+	defer reply.SetError(&err)
+	err = match.Errorf(match.ErrInsufficientFunds, "Error failing for FailCoded RPC command")
+	return
+}
+
+// Slow replies with its args after a while, so there's a request in flight
+func (t *TestRPC) Slow(args EchoArgs, reply *EchoReply) (err error) {
+	time.Sleep(200 * time.Millisecond)
+	reply.Message = args.Message
+	return
+}
+
+// newTestGateway creates a gateway for TestRPC
+func newTestGateway(t *testing.T) (gw *Gateway) {
+	var err error
+	if gw, err = NewGateway(new(TestRPC)); err != nil {
+		t.Fatalf("Error creating gateway: %s", err)
+	}
+	return
+}
+
+// post sends a body to the gateway and returns the status and response body
+func post(t *testing.T, gw *Gateway, body string) (status int, resp []byte) {
+	recorder := httptest.NewRecorder()
+	gw.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	status = recorder.Code
+	resp = recorder.Body.Bytes()
+	return
+}
+
+// testResponse is a response with the result left raw so it can be compared
+type testResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *Error          `json:"error"`
+	ID      json.RawMessage `json:"id"`
+}
+
+func TestHTTPRequests(t *testing.T) {
+	gw := newTestGateway(t)
+
+	cases := []struct {
+		name   string
+		body   string
+		code   int
+		result string
+		id     string
+	}{
+		{"object params", `{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Message":"hi","Nonce":[1,2,3,4]},"id":1}`, 0, `{"Message":"hi","Nonce":[1,2,3,4]}`, `1`},
+		{"array params", `{"jsonrpc":"2.0","method":"TestRPC.Echo","params":[{"Message":"hi"}],"id":"a"}`, 0, `{"Message":"hi","Nonce":[0,0,0,0]}`, `"a"`},
+		{"no params", `{"jsonrpc":"2.0","method":"TestRPC.Echo","id":2}`, 0, `{"Message":"","Nonce":[0,0,0,0]}`, `2`},
+		{"method error", `{"jsonrpc":"2.0","method":"TestRPC.Fail","id":3}`, ServerError, "", `3`},
+		{"unknown method", `{"jsonrpc":"2.0","method":"TestRPC.Nope","id":4}`, MethodNotFound, "", `4`},
+		{"unknown service", `{"jsonrpc":"2.0","method":"Nope.Echo","id":5}`, MethodNotFound, "", `5`},
+		{"unknown field", `{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Mesage":"hi"},"id":6}`, InvalidParams, "", `6`},
+		{"too many params", `{"jsonrpc":"2.0","method":"TestRPC.Echo","params":[{},{}],"id":7}`, InvalidParams, "", `7`},
+		{"wrong version", `{"jsonrpc":"1.0","method":"TestRPC.Echo","id":8}`, InvalidRequest, "", `8`},
+		{"no method", `{"jsonrpc":"2.0","id":9}`, InvalidRequest, "", `9`},
+		{"bad id", `{"jsonrpc":"2.0","method":"TestRPC.Echo","id":{}}`, InvalidRequest, "", `null`},
+		{"not an object", `"TestRPC.Echo"`, InvalidRequest, "", `null`},
+		{"bad json", `{"jsonrpc":"2.0",`, ParseError, "", `null`},
+	}
+
+	for _, c := range cases {
+		status, body := post(t, gw, c.body)
+		if status != http.StatusOK {
+			t.Errorf("%s: expected status %d, got %d", c.name, http.StatusOK, status)
+			return
+		}
+
+		var resp testResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Errorf("%s: error decoding response %s: %s", c.name, body, err)
+			return
+		}
+		if resp.Version != Version {
+			t.Errorf("%s: expected version %s, got %s", c.name, Version, resp.Version)
+			return
+		}
+		if string(resp.ID) != c.id {
+			t.Errorf("%s: expected id %s, got %s", c.name, c.id, resp.ID)
+			return
+		}
+
+		if c.code == 0 {
+			if resp.Error != nil {
+				t.Errorf("%s: unexpected error %s", c.name, resp.Error)
+				return
+			}
+			if string(resp.Result) != c.result {
+				t.Errorf("%s: expected result %s, got %s", c.name, c.result, resp.Result)
+				return
+			}
+			continue
+		}
+
+		if resp.Error == nil || resp.Error.Code != c.code {
+			t.Errorf("%s: expected error code %d, got response %s", c.name, c.code, body)
+			return
+		}
+		if resp.Result != nil {
+			t.Errorf("%s: error response should not have a result, got %s", c.name, body)
+			return
+		}
+	}
+
+	// coded errors come in the reply, but are sent as errors with the code in the data
+	_, body := post(t, gw, `{"jsonrpc":"2.0","method":"TestRPC.FailCoded","id":10}`)
+	var resp testResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Errorf("Error decoding coded error response %s: %s", body, err)
+		return
+	}
+	if resp.Error == nil || resp.Error.Code != ServerError || resp.Error.Data == nil || resp.Error.Data.ErrorCode != match.ErrInsufficientFunds {
+		t.Errorf("Expected error with code %s in the data, got response %s", match.ErrInsufficientFunds, body)
+		return
+	}
+	if resp.Result != nil {
+		t.Errorf("Coded error response should not have a result, got %s", body)
+		return
+	}
+
+	return
+}
+
+func TestHTTPBatchAndNotifications(t *testing.T) {
+	gw := newTestGateway(t)
+
+	// notifications are served but not responded to, even if they fail
+	status, body := post(t, gw, `{"jsonrpc":"2.0","method":"TestRPC.Fail"}`)
+	if status != http.StatusNoContent || len(body) != 0 {
+		t.Errorf("Notification should get no content, got %d %s", status, body)
+		return
+	}
+
+	status, body = post(t, gw, `[
+		{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Message":"one"},"id":1},
+		{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Message":"note"}},
+		{"jsonrpc":"2.0","method":"TestRPC.Nope","id":2}
+	]`)
+	if status != http.StatusOK {
+		t.Errorf("Expected status %d for batch, got %d", http.StatusOK, status)
+		return
+	}
+	var responses []testResponse
+	if err := json.Unmarshal(body, &responses); err != nil {
+		t.Errorf("Error decoding batch response %s: %s", body, err)
+		return
+	}
+	if len(responses) != 2 {
+		t.Errorf("Expected 2 responses, one per request that isn't a notification, got %s", body)
+		return
+	}
+	if string(responses[0].ID) != "1" || responses[0].Error != nil {
+		t.Errorf("First batch response should be the echo, got %s", body)
+		return
+	}
+	if string(responses[1].ID) != "2" || responses[1].Error == nil || responses[1].Error.Code != MethodNotFound {
+		t.Errorf("Second batch response should be method not found, got %s", body)
+		return
+	}
+
+	status, body = post(t, gw, `[]`)
+	var resp testResponse
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == nil || resp.Error.Code != InvalidRequest {
+		t.Errorf("Empty batch should be an invalid request, got %d %s", status, body)
+		return
+	}
+
+	recorder := httptest.NewRecorder()
+	gw.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET without a websocket upgrade should not be allowed, got %d", recorder.Code)
+		return
+	}
+
+	return
+}
+
+// TestStream checks the codec on a stream, which is how websockets are served
+func TestStream(t *testing.T) {
+	gw := newTestGateway(t)
+
+	serverConn, clientConn := net.Pipe()
+	go gw.rpcServer.ServeCodec(newServerCodec(serverConn))
+	defer clientConn.Close()
+
+	dec := json.NewDecoder(clientConn)
+	go clientConn.Write([]byte(`{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Message":"stream"},"id":1}
+		{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Message":"note"}}
+		{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Message":"again"},"id":2}`))
+
+	// the notification gets no response, and responses can come in any order
+	ids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		var resp testResponse
+		if err := dec.Decode(&resp); err != nil {
+			t.Errorf("Error reading response from stream: %s", err)
+			return
+		}
+		if resp.Error != nil {
+			t.Errorf("Unexpected error for id %s: %s", resp.ID, resp.Error)
+			return
+		}
+		ids[string(resp.ID)] = true
+	}
+	if !ids["1"] || !ids["2"] {
+		t.Errorf("Expected responses for ids 1 and 2, got %v", ids)
+		return
+	}
+
+	// after bad JSON the server responds with a parse error and closes the stream
+	go clientConn.Write([]byte(`{"jsonrpc":}`))
+	var resp testResponse
+	if err := dec.Decode(&resp); err != nil {
+		t.Errorf("Error reading parse error from stream: %s", err)
+		return
+	}
+	if resp.Error == nil || resp.Error.Code != ParseError {
+		t.Errorf("Expected parse error, got %v", resp.Error)
+		return
+	}
+	if err := dec.Decode(&resp); err == nil {
+		t.Errorf("Stream should be closed after a parse error")
+		return
+	}
+
+	return
+}
+
+func TestWebSocket(t *testing.T) {
+	gw := newTestGateway(t)
+	server := httptest.NewServer(gw)
+	defer server.Close()
+
+	var err error
+	var ws *websocket.Conn
+	if ws, err = websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http"), "", "http://localhost/"); err != nil {
+		t.Errorf("Error dialing websocket: %s", err)
+		return
+	}
+	defer ws.Close()
+
+	if err = websocket.Message.Send(ws, `{"jsonrpc":"2.0","method":"TestRPC.Echo","params":{"Message":"ws"},"id":7}`); err != nil {
+		t.Errorf("Error sending over websocket: %s", err)
+		return
+	}
+
+	var resp testResponse
+	if err = websocket.JSON.Receive(ws, &resp); err != nil {
+		t.Errorf("Error receiving over websocket: %s", err)
+		return
+	}
+	if string(resp.ID) != "7" || resp.Error != nil || !bytes.Equal(resp.Result, []byte(`{"Message":"ws","Nonce":[0,0,0,0]}`)) {
+		t.Errorf("Unexpected websocket response id %s result %s error %v", resp.ID, resp.Result, resp.Error)
+		return
+	}
+
+	// closing the gateway closes open websockets
+	if err = gw.Close(); err != nil {
+		t.Errorf("Error closing gateway: %s", err)
+		return
+	}
+	var leftover []byte
+	if leftover, err = ioutil.ReadAll(ws); err == nil && len(leftover) != 0 {
+		t.Errorf("Expected websocket to be closed, read %s", leftover)
+		return
+	}
+
+	return
+}
+
+// TestShutdown makes sure requests being served finish, and new ones aren't taken
+func TestShutdown(t *testing.T) {
+	gw := newTestGateway(t)
+	var err error
+	if err = gw.Listen("127.0.0.1", 0); err != nil {
+		t.Errorf("Error listening: %s", err)
+		return
+	}
+	url := "http://" + gw.Addr().String()
+
+	respChan := make(chan *http.Response, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		resp, postErr := http.Post(url, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"TestRPC.Slow","params":{"Message":"slow"},"id":1}`))
+		if postErr != nil {
+			errChan <- postErr
+			return
+		}
+		respChan <- resp
+	}()
+
+	// give the request time to get to the gateway
+	time.Sleep(50 * time.Millisecond)
+	if err = gw.Shutdown(5 * time.Second); err != nil {
+		t.Errorf("Error shutting down gateway: %s", err)
+		return
+	}
+
+	var resp *http.Response
+	select {
+	case resp = <-respChan:
+	case err = <-errChan:
+		t.Errorf("Request in flight should finish, got error %s", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var slowResp testResponse
+	if err = json.NewDecoder(resp.Body).Decode(&slowResp); err != nil {
+		t.Errorf("Error decoding response: %s", err)
+		return
+	}
+	if slowResp.Error != nil || string(slowResp.Result) != `{"Message":"slow","Nonce":[0,0,0,0]}` {
+		t.Errorf("Unexpected response to request in flight, result %s error %v", slowResp.Result, slowResp.Error)
+		return
+	}
+
+	if _, err = http.Post(url, "application/json", strings.NewReader(`{"jsonrpc":"2.0","method":"TestRPC.Echo","id":2}`)); err == nil {
+		t.Errorf("Requests after shutdown should fail")
+		return
+	}
+
+	return
+}
@@ -0,0 +1,35 @@
+package cxkeyholderrpc
+
+import (
+	"net"
+	"sync"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/cxauctionserver"
+)
+
+// KeyHolderRPCCaller is a listener for key holder RPC commands
+type KeyHolderRPCCaller struct {
+	caller   *OpencxKeyHolderRPC
+	listener net.Listener
+	killers  []chan bool
+
+	// authorizedKeys are the only keys allowed to ask for decryption shares, since anyone who
+	// can ask for them can decrypt orders with the rest of the committee. Auction ends have to be
+	// signed by one of them too.
+	authorizedKeys []*koblitz.PublicKey
+
+	// isStopped is set once Stop is called, so the accept loop knows to exit
+	isStopped bool
+	stopMtx   sync.Mutex
+}
+
+// OpencxKeyHolderRPC is what is registered and called. It holds one key holder's share of the
+// committee key, and the auction ends it has accepted, so each key holder can run on their own
+// machine and decide for itself when to give out shares.
+type OpencxKeyHolderRPC struct {
+	share     *threshold.KeyShare
+	committee *threshold.CommitteeKey
+	checker   *cxauctionserver.AuctionEndChecker
+}
@@ -0,0 +1,185 @@
+package cxkeyholderrpc
+
+import (
+	"fmt"
+	"net/rpc"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxnoise"
+	"github.com/mit-dci/opencx/logging"
+)
+
+// CreateRPCForKeyHolder creates a key holder RPC caller which gives out decryption shares with
+// the key share, and only accepts connections and auction ends from the authorized keys. The share
+// has to be one of the committee's shares.
+func CreateRPCForKeyHolder(share *threshold.KeyShare, committee *threshold.CommitteeKey, authorizedKeys []*koblitz.PublicKey) (rpc1 *KeyHolderRPCCaller, err error) {
+	if committee == nil {
+		err = fmt.Errorf("Cannot create key holder rpc with a nil committee")
+		return
+	}
+
+	if err = committee.CheckShare(share); err != nil {
+		err = fmt.Errorf("Cannot create key holder rpc with a share that isn't in the committee: %s", err)
+		return
+	}
+
+	if len(authorizedKeys) == 0 {
+		err = fmt.Errorf("Need at least one authorized key, otherwise nobody can use the key holder")
+		return
+	}
+
+	var checker *cxauctionserver.AuctionEndChecker
+	if checker, err = cxauctionserver.NewAuctionEndChecker(committee, authorizedKeys); err != nil {
+		err = fmt.Errorf("Error creating auction end checker for key holder rpc: %s", err)
+		return
+	}
+
+	rpc1 = &KeyHolderRPCCaller{
+		caller: &OpencxKeyHolderRPC{
+			share:     share,
+			committee: committee,
+			checker:   checker,
+		},
+		authorizedKeys: authorizedKeys,
+	}
+	return
+}
+
+// NoiseListen is a synchronous version of NoiseListenAsync
+func (rpc1 *KeyHolderRPCCaller) NoiseListen(privkey *koblitz.PrivateKey, host string, port uint16) (err error) {
+
+	doneChan := make(chan bool, 1)
+	errChan := make(chan error, 1)
+	go rpc1.NoiseListenAsync(doneChan, errChan, privkey, host, port)
+	select {
+	case err = <-errChan:
+	case <-doneChan:
+	}
+
+	return
+}
+
+// NoiseListenAsync listens on socket host and port. The key holder only listens over noise, since
+// it needs to know who is connecting.
+func (rpc1 *KeyHolderRPCCaller) NoiseListenAsync(doneChan chan bool, errChan chan error, privkey *koblitz.PrivateKey, host string, port uint16) {
+	var err error
+	if rpc1.caller == nil {
+		errChan <- fmt.Errorf("Error, rpc caller cannot be nil, please create caller correctly")
+		close(errChan)
+		return
+	}
+
+	noiseRPCServer := rpc.NewServer()
+
+	logging.Infof("Registering key holder RPC API over Noise protocol ...")
+	if err = noiseRPCServer.Register(rpc1.caller); err != nil {
+		errChan <- fmt.Errorf("Error registering RPC Interface: %s", err)
+		close(errChan)
+		return
+	}
+
+	logging.Infof("Starting key holder RPC Server over noise protocol")
+	if rpc1.listener, err = cxnoise.NewListener(privkey, int(port)); err != nil {
+		errChan <- fmt.Errorf("Error creating noise listener for NoiseListenAsync: %s", err)
+		close(errChan)
+		return
+	}
+	logging.Infof("Running key holder RPC-Noise server on %s\n", rpc1.listener.Addr().String())
+
+	go rpc1.acceptAuthorized(noiseRPCServer)
+	doneChan <- true
+	close(doneChan)
+	return
+}
+
+// acceptAuthorized accepts noise connections and serves them, but only if the remote key is one
+// of the authorized keys. This should be run in a goroutine.
+func (rpc1 *KeyHolderRPCCaller) acceptAuthorized(server *rpc.Server) {
+	for {
+		conn, err := rpc1.listener.Accept()
+		if err != nil {
+			if rpc1.stopped() {
+				return
+			}
+			logging.Warnf("Error accepting connection to key holder, continuing: %s", err)
+			continue
+		}
+
+		noiseConn, ok := conn.(*cxnoise.Conn)
+		if !ok {
+			logging.Warnf("Key holder connection is not a noise connection, closing")
+			conn.Close()
+			continue
+		}
+
+		if !rpc1.isAuthorized(noiseConn.RemotePub()) {
+			logging.Warnf("Unauthorized key %x tried to connect to key holder, closing", noiseConn.RemotePub().SerializeCompressed())
+			conn.Close()
+			continue
+		}
+
+		logging.Infof("Authorized key %x connected to key holder", noiseConn.RemotePub().SerializeCompressed())
+		go server.ServeConn(conn)
+	}
+}
+
+// isAuthorized returns true if the pubkey is one of the authorized keys
+func (rpc1 *KeyHolderRPCCaller) isAuthorized(pubkey *koblitz.PublicKey) (authorized bool) {
+	if pubkey == nil {
+		return
+	}
+	for _, authKey := range rpc1.authorizedKeys {
+		if authKey.IsEqual(pubkey) {
+			authorized = true
+			return
+		}
+	}
+	return
+}
+
+// stopped returns true if Stop has been called
+func (rpc1 *KeyHolderRPCCaller) stopped() (isStopped bool) {
+	rpc1.stopMtx.Lock()
+	isStopped = rpc1.isStopped
+	rpc1.stopMtx.Unlock()
+	return
+}
+
+// WaitUntilDead waits until the Stop() method is called
+func (rpc1 *KeyHolderRPCCaller) WaitUntilDead() {
+	dedchan := make(chan bool, 1)
+	rpc1.stopMtx.Lock()
+	rpc1.killers = append(rpc1.killers, dedchan)
+	rpc1.stopMtx.Unlock()
+	<-dedchan
+	return
+}
+
+// Stop closes the RPC listener and notifies those from WaitUntilDead
+func (rpc1 *KeyHolderRPCCaller) Stop() (err error) {
+	if rpc1.listener == nil {
+		err = fmt.Errorf("Error, cannot stop a listener that doesn't exist")
+		return
+	}
+	logging.Infof("Stopping key holder RPC!!")
+	rpc1.stopMtx.Lock()
+	rpc1.isStopped = true
+	killers := rpc1.killers
+	rpc1.stopMtx.Unlock()
+	if err = rpc1.listener.Close(); err != nil {
+		err = fmt.Errorf("Error closing listener: %s", err)
+		return
+	}
+	for _, killer := range killers {
+		// send the signals, but even if they don't send, close the channel
+		select {
+		case killer <- true:
+			close(killer)
+		default:
+			close(killer)
+		}
+	}
+	return
+}
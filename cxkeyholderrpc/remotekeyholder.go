@@ -0,0 +1,158 @@
+package cxkeyholderrpc
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/cxnoise"
+	"github.com/mit-dci/opencx/logging"
+)
+
+// RemoteKeyHolder is a cxauctionserver.KeyHolder that asks a key holder daemon for decryption
+// shares over noise RPC, so each member of the committee can keep their share on their own
+// machine. The shares it gets back are checked by the committee batcher like any other.
+type RemoteKeyHolder struct {
+	privkey *koblitz.PrivateKey
+	addr    string
+	client  *rpc.Client
+	mtx     sync.Mutex
+}
+
+// A compile-time assertion to make sure RemoteKeyHolder is a KeyHolder
+var _ cxauctionserver.KeyHolder = (*RemoteKeyHolder)(nil)
+
+// DialKeyHolder creates a noise RPC connection to a key holder daemon
+func DialKeyHolder(privkey *koblitz.PrivateKey, host string, port uint16) (holder *RemoteKeyHolder, err error) {
+	if privkey == nil {
+		err = fmt.Errorf("Please set the key for the noise client to dial the key holder")
+		return
+	}
+
+	holder = &RemoteKeyHolder{
+		privkey: privkey,
+		addr:    net.JoinHostPort(host, fmt.Sprintf("%d", port)),
+	}
+	if _, err = holder.getClient(); err != nil {
+		err = fmt.Errorf("Error dialing key holder for DialKeyHolder: %s", err)
+		return
+	}
+	return
+}
+
+// getClient returns the RPC client, dialing the key holder if there isn't a connection
+func (rkh *RemoteKeyHolder) getClient() (client *rpc.Client, err error) {
+	rkh.mtx.Lock()
+	if rkh.client == nil {
+		var clientConn *cxnoise.Conn
+		if clientConn, err = cxnoise.Dial(rkh.privkey, rkh.addr, []byte("opencx"), net.Dial); err != nil {
+			err = fmt.Errorf("Error dialing key holder at %s: %s", rkh.addr, err)
+			rkh.mtx.Unlock()
+			return
+		}
+		rkh.client = rpc.NewClient(clientConn)
+	}
+	client = rkh.client
+	rkh.mtx.Unlock()
+	return
+}
+
+// call calls a method on the key holder daemon. If the call fails because of the connection
+// rather than the daemon, the connection is closed and dialed again on the next call.
+func (rkh *RemoteKeyHolder) call(serviceMethod string, args interface{}, reply interface{}) (err error) {
+	var client *rpc.Client
+	if client, err = rkh.getClient(); err != nil {
+		return
+	}
+
+	if err = client.Call(serviceMethod, args, reply); err != nil {
+		if _, ok := err.(rpc.ServerError); !ok {
+			rkh.mtx.Lock()
+			if rkh.client == client {
+				rkh.client.Close()
+				rkh.client = nil
+			}
+			rkh.mtx.Unlock()
+		}
+		return
+	}
+	return
+}
+
+// Close closes the connection to the key holder daemon
+func (rkh *RemoteKeyHolder) Close() (err error) {
+	rkh.mtx.Lock()
+	if rkh.client != nil {
+		err = rkh.client.Close()
+		rkh.client = nil
+	}
+	rkh.mtx.Unlock()
+	return
+}
+
+// DecryptionShares sends the auction end to the key holder daemon, and gets back a decryption
+// share for each order in it, in the same order. The daemon accepts the same auction end more
+// than once, so if the connection dropped this dials again and asks one more time.
+func (rkh *RemoteKeyHolder) DecryptionShares(end *cxauctionserver.AuctionEnd) (shares []*threshold.DecryptionShare, err error) {
+	sharesArgs := &DecryptionSharesArgs{
+		AuctionID: end.AuctionID,
+		Pair:      end.Pair,
+		Signature: end.Signature,
+	}
+	for _, order := range end.Orders {
+		var orderBytes []byte
+		if orderBytes, err = order.Serialize(); err != nil {
+			err = fmt.Errorf("Error serializing order for remote key holder: %s", err)
+			return
+		}
+		sharesArgs.Orders = append(sharesArgs.Orders, orderBytes)
+	}
+
+	sharesReply := new(DecryptionSharesReply)
+	if err = rkh.call("OpencxKeyHolderRPC.DecryptionShares", sharesArgs, sharesReply); err != nil {
+		if _, ok := err.(rpc.ServerError); ok {
+			err = fmt.Errorf("Error getting decryption shares from remote key holder: %s", err)
+			return
+		}
+
+		sharesReply = new(DecryptionSharesReply)
+		if err = rkh.call("OpencxKeyHolderRPC.DecryptionShares", sharesArgs, sharesReply); err != nil {
+			err = fmt.Errorf("Error getting decryption shares from remote key holder: %s", err)
+			return
+		}
+	}
+
+	shares = sharesReply.Shares
+	return
+}
+
+// DialKeyHolders creates a remote key holder for each key holder daemon at the given addresses,
+// like host:port, with the same key. A committee only needs a threshold of its key holders, so a
+// key holder that can't be dialed yet is only logged, and is dialed again when it's needed.
+func DialKeyHolders(privkey *koblitz.PrivateKey, addrs []string) (holders []cxauctionserver.KeyHolder, err error) {
+	if privkey == nil {
+		err = fmt.Errorf("Please set the key for the noise client to dial the key holders")
+		return
+	}
+
+	for _, addr := range addrs {
+		if _, _, err = net.SplitHostPort(addr); err != nil {
+			err = fmt.Errorf("Error parsing key holder address %s: %s", addr, err)
+			return
+		}
+
+		holder := &RemoteKeyHolder{
+			privkey: privkey,
+			addr:    addr,
+		}
+		if _, dialErr := holder.getClient(); dialErr != nil {
+			logging.Warnf("Could not dial key holder %s, will try again when decrypting: %s", addr, dialErr)
+		}
+		holders = append(holders, holder)
+	}
+	return
+}
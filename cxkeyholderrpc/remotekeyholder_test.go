@@ -0,0 +1,237 @@
+package cxkeyholderrpc
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+var (
+	testPair = match.Pair{
+		AssetWant: match.Asset(6),
+		AssetHave: match.Asset(8),
+	}
+	testAuctionID    = [32]byte{0xde, 0xad, 0xbe, 0xef}
+	testMaxBatchSize = uint64(100)
+	testAuctionOrder = &match.AuctionOrder{
+		Side:        "buy",
+		TradingPair: testPair,
+		AmountWant:  100000,
+		AmountHave:  10000,
+		AuctionID:   testAuctionID,
+	}
+	testCommitteeScheme = match.EncryptionScheme{
+		Timelock: match.TimelockCommittee,
+		Cipher:   match.CipherAESGCM,
+	}
+)
+
+// startTestKeyHolder starts a key holder for the share that only authorizes the client key,
+// returning the address it's listening on
+func startTestKeyHolder(share *threshold.KeyShare, committee *threshold.CommitteeKey, clientKey *koblitz.PrivateKey) (rpc1 *KeyHolderRPCCaller, addr string, err error) {
+	var serverKey *koblitz.PrivateKey
+	if serverKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error creating key holder key: %s", err)
+		return
+	}
+
+	if rpc1, err = CreateRPCForKeyHolder(share, committee, []*koblitz.PublicKey{clientKey.PubKey()}); err != nil {
+		err = fmt.Errorf("Error creating key holder rpc: %s", err)
+		return
+	}
+
+	if err = rpc1.NoiseListen(serverKey, "localhost", 0); err != nil {
+		err = fmt.Errorf("Error listening for key holder rpc: %s", err)
+		return
+	}
+
+	var port string
+	if _, port, err = net.SplitHostPort(rpc1.listener.Addr().String()); err != nil {
+		err = fmt.Errorf("Error getting key holder port: %s", err)
+		return
+	}
+	addr = net.JoinHostPort("localhost", port)
+
+	return
+}
+
+func TestRemoteKeyHolders(t *testing.T) {
+	var err error
+
+	var committee *threshold.CommitteeKey
+	var shares []*threshold.KeyShare
+	if committee, shares, err = threshold.Deal(cxauctionserver.CommitteeCurve, 2, 3); err != nil {
+		t.Errorf("Error dealing test committee: %s", err)
+		return
+	}
+
+	var clientKey *koblitz.PrivateKey
+	if clientKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating client key: %s", err)
+		return
+	}
+
+	// every key holder runs its own listener, with only its own share
+	var addrs []string
+	var listeners []*KeyHolderRPCCaller
+	for _, share := range shares {
+		var rpc1 *KeyHolderRPCCaller
+		var addr string
+		if rpc1, addr, err = startTestKeyHolder(share, committee, clientKey); err != nil {
+			t.Errorf("Error starting test key holder %d: %s", share.Index, err)
+			return
+		}
+		defer rpc1.Stop()
+		listeners = append(listeners, rpc1)
+		addrs = append(addrs, addr)
+	}
+
+	var holders []cxauctionserver.KeyHolder
+	if holders, err = DialKeyHolders(clientKey, addrs); err != nil {
+		t.Errorf("Error dialing key holders: %s", err)
+		return
+	}
+
+	// the other two are enough without the last one
+	if err = listeners[2].Stop(); err != nil {
+		t.Errorf("Error stopping key holder: %s", err)
+		return
+	}
+	holders[2].(*RemoteKeyHolder).Close()
+
+	var batcher *cxauctionserver.CommitteeBatcher
+	if batcher, err = cxauctionserver.NewCommitteeBatcher(testMaxBatchSize, committee, holders, clientKey); err != nil {
+		t.Errorf("Error creating committee batcher: %s", err)
+		return
+	}
+
+	var encOrder *match.EncryptedAuctionOrder
+	if encOrder, err = testAuctionOrder.TurnIntoCommitteeEncryptedOrder(testCommitteeScheme, committee); err != nil {
+		t.Errorf("Error encrypting order to committee: %s", err)
+		return
+	}
+
+	if err = batcher.RegisterAuction(encOrder.IntendedAuction); err != nil {
+		t.Errorf("Error registering auction: %s", err)
+		return
+	}
+
+	if err = batcher.AddEncrypted(encOrder); err != nil {
+		t.Errorf("Error adding committee order: %s", err)
+		return
+	}
+
+	var batchChan chan *match.AuctionBatch
+	if batchChan, err = batcher.EndAuction(encOrder.IntendedAuction); err != nil {
+		t.Errorf("Error ending auction: %s", err)
+		return
+	}
+
+	select {
+	case batch := <-batchChan:
+		if len(batch.Batch) != 1 {
+			t.Errorf("Expected 1 order in batch, got %d", len(batch.Batch))
+			return
+		}
+		if batch.Batch[0].Err != nil {
+			t.Errorf("Error decrypting order with remote key holders: %s", batch.Batch[0].Err)
+			return
+		}
+		if !bytes.Equal(batch.Batch[0].Auction.Serialize(), testAuctionOrder.Serialize()) {
+			t.Errorf("Decrypted order does not match the original order")
+			return
+		}
+	case <-time.After(time.Minute):
+		t.Errorf("Timed out waiting for remote key holders to decrypt batch")
+		return
+	}
+
+	return
+}
+
+func TestKeyHolderAuthorization(t *testing.T) {
+	var err error
+
+	var committee *threshold.CommitteeKey
+	var shares []*threshold.KeyShare
+	if committee, shares, err = threshold.Deal(cxauctionserver.CommitteeCurve, 1, 2); err != nil {
+		t.Errorf("Error dealing test committee: %s", err)
+		return
+	}
+
+	var clientKey *koblitz.PrivateKey
+	if clientKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating client key: %s", err)
+		return
+	}
+
+	wrongShare := &threshold.KeyShare{
+		Index:  shares[1].Index,
+		Secret: shares[0].Secret,
+	}
+	if _, err = CreateRPCForKeyHolder(wrongShare, committee, []*koblitz.PublicKey{clientKey.PubKey()}); err == nil {
+		t.Errorf("Should not create key holder with a share that isn't in the committee")
+		return
+	}
+
+	var rpc1 *KeyHolderRPCCaller
+	var addr string
+	if rpc1, addr, err = startTestKeyHolder(shares[0], committee, clientKey); err != nil {
+		t.Errorf("Error starting test key holder: %s", err)
+		return
+	}
+	defer rpc1.Stop()
+
+	var otherKey *koblitz.PrivateKey
+	if otherKey, err = koblitz.NewPrivateKey(koblitz.S256()); err != nil {
+		t.Errorf("Error creating other key: %s", err)
+		return
+	}
+
+	var holders []cxauctionserver.KeyHolder
+	if holders, err = DialKeyHolders(otherKey, []string{addr}); err != nil {
+		t.Errorf("Error creating remote key holder: %s", err)
+		return
+	}
+
+	var end *cxauctionserver.AuctionEnd
+	if end, err = cxauctionserver.SignAuctionEnd(otherKey, testAuctionID, testPair, nil); err != nil {
+		t.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	if _, err = holders[0].DecryptionShares(end); err == nil {
+		t.Errorf("Unauthorized key should not get decryption shares")
+		return
+	}
+
+	// an authorized connection still needs an auction end signed by an authorized key
+	if holders, err = DialKeyHolders(clientKey, []string{addr}); err != nil {
+		t.Errorf("Error creating remote key holder: %s", err)
+		return
+	}
+
+	if _, err = holders[0].DecryptionShares(end); err == nil {
+		t.Errorf("Key holder should not give out shares for an auction end from an unauthorized key")
+		return
+	}
+
+	if end, err = cxauctionserver.SignAuctionEnd(clientKey, testAuctionID, testPair, nil); err != nil {
+		t.Errorf("Error signing auction end: %s", err)
+		return
+	}
+
+	if _, err = holders[0].DecryptionShares(end); err != nil {
+		t.Errorf("Key holder should accept an auction end from an authorized key: %s", err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,71 @@
+package cxkeyholderrpc
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/cxauctionserver"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// MaxOrdersPerRequest is the most orders decryption shares can be asked for at once, so one
+// request can't keep the key holder busy forever
+const MaxOrdersPerRequest = 100000
+
+// DecryptionSharesArgs holds the args for the decryptionshares command, which is the exchange's
+// signed auction end
+type DecryptionSharesArgs struct {
+	AuctionID [32]byte
+	Pair      match.Pair
+	// Use the serialize method on match.EncryptedAuctionOrder
+	Orders    [][]byte
+	Signature []byte
+}
+
+// DecryptionSharesReply holds the reply for the decryptionshares command
+type DecryptionSharesReply struct {
+	Shares []*threshold.DecryptionShare
+}
+
+// DecryptionShares gives out this key holder's decryption share for every order in an auction, in
+// the same order. The key holder checks the signed auction end for itself first, so it only gives
+// out shares once the exchange has committed to every order in the auction.
+func (cl *OpencxKeyHolderRPC) DecryptionShares(args DecryptionSharesArgs, reply *DecryptionSharesReply) (err error) {
+	if len(args.Orders) > MaxOrdersPerRequest {
+		err = fmt.Errorf("Cannot give out shares for %d orders at once, at most %d", len(args.Orders), MaxOrdersPerRequest)
+		return
+	}
+
+	end := &cxauctionserver.AuctionEnd{
+		AuctionID: args.AuctionID,
+		Pair:      args.Pair,
+		Signature: args.Signature,
+	}
+	for i, orderBytes := range args.Orders {
+		order := new(match.EncryptedAuctionOrder)
+		if err = order.Deserialize(orderBytes); err != nil {
+			err = fmt.Errorf("Error deserializing order %d for DecryptionShares RPC command: %s", i, err)
+			return
+		}
+		end.Orders = append(end.Orders, order)
+	}
+
+	var capsules []*threshold.Capsule
+	if capsules, err = cl.checker.Check(end); err != nil {
+		err = fmt.Errorf("Not giving out decryption shares for DecryptionShares RPC command: %s", err)
+		return
+	}
+
+	for i, capsule := range capsules {
+		var share *threshold.DecryptionShare
+		if share, err = cl.share.DecryptionShare(cl.committee, capsule); err != nil {
+			err = fmt.Errorf("Error creating decryption share %d for DecryptionShares RPC command: %s", i, err)
+			return
+		}
+		reply.Shares = append(reply.Shares, share)
+	}
+
+	logging.Infof("Gave out %d decryption shares for auction %x", len(reply.Shares), args.AuctionID)
+	return
+}
@@ -0,0 +1,158 @@
+package cxlimit
+
+import (
+	"bufio"
+	"encoding/gob"
+	"io"
+	"net"
+	"net/rpc"
+	"sync"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// limitedCodec wraps an RPC server codec to throttle requests by the address they come from.
+// A throttled request is handed to net/rpc with no method, so it's answered with an error without
+// calling anything, and the response is replaced with a throttledReply when it's written.
+type limitedCodec struct {
+	rpc.ServerCodec
+	limits     *Limits
+	remoteAddr string
+
+	// throttled are the errors for throttled requests by seq, until they're responded to
+	throttled map[uint64]error
+	mtx       sync.Mutex
+}
+
+// ServerCodec wraps an RPC server codec for a connection from remoteAddr so requests are
+// throttled by address. With nil limits it returns the codec as is.
+func (limits *Limits) ServerCodec(codec rpc.ServerCodec, remoteAddr string) (limited rpc.ServerCodec) {
+	if limits == nil {
+		limited = codec
+		return
+	}
+	limited = &limitedCodec{
+		ServerCodec: codec,
+		limits:      limits,
+		remoteAddr:  remoteAddr,
+		throttled:   make(map[uint64]error),
+	}
+	return
+}
+
+// ServeConn serves an RPC server on a connection like server.ServeConn, throttling requests by the
+// connection's remote address
+func (limits *Limits) ServeConn(server *rpc.Server, conn net.Conn) {
+	server.ServeCodec(limits.ServerCodec(newGobServerCodec(conn), conn.RemoteAddr().String()))
+	return
+}
+
+// Accept accepts connections and serves an RPC server on each like server.Accept, throttling
+// requests by address. It returns when the listener is closed.
+func (limits *Limits) Accept(server *rpc.Server, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go limits.ServeConn(server, conn)
+	}
+}
+
+// ReadRequestHeader reads the next request and takes a token for it from the address's budget
+func (c *limitedCodec) ReadRequestHeader(r *rpc.Request) (err error) {
+	if err = c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return
+	}
+	// requests with no method are already going to be refused
+	if r.ServiceMethod == "" {
+		return
+	}
+
+	if throttleErr := c.limits.AllowAddr(r.ServiceMethod, c.remoteAddr); throttleErr != nil {
+		c.mtx.Lock()
+		c.throttled[r.Seq] = throttleErr
+		c.mtx.Unlock()
+		r.ServiceMethod = ""
+	}
+	return
+}
+
+// throttledReply is the reply to a throttled request. RPC replies embed match.ErrorReply, so the
+// client decodes the ThrottledError into its reply like any other coded error.
+type throttledReply struct {
+	match.ErrorReply
+}
+
+// WriteResponse writes a response, or a throttledReply if the request was throttled
+func (c *limitedCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	c.mtx.Lock()
+	if throttleErr, ok := c.throttled[r.Seq]; ok {
+		delete(c.throttled, r.Seq)
+		reply := new(throttledReply)
+		reply.SetError(&throttleErr)
+		r.Error = ""
+		body = reply
+	}
+	c.mtx.Unlock()
+
+	err = c.ServerCodec.WriteResponse(r, body)
+	return
+}
+
+// gobServerCodec is the gob codec net/rpc uses for ServeConn, which it doesn't export
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+	closed bool
+}
+
+// newGobServerCodec creates a gob server codec for a connection
+func newGobServerCodec(conn io.ReadWriteCloser) (codec *gobServerCodec) {
+	encBuf := bufio.NewWriter(conn)
+	codec = &gobServerCodec{
+		rwc:    conn,
+		dec:    gob.NewDecoder(conn),
+		enc:    gob.NewEncoder(encBuf),
+		encBuf: encBuf,
+	}
+	return
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *rpc.Response, body interface{}) (err error) {
+	if err = c.enc.Encode(r); err != nil {
+		if c.encBuf.Flush() == nil {
+			// gob couldn't encode the header, which shouldn't happen, so close the connection
+			c.Close()
+		}
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		if c.encBuf.Flush() == nil {
+			// the body couldn't be encoded, so close the connection
+			c.Close()
+		}
+		return
+	}
+	err = c.encBuf.Flush()
+	return
+}
+
+func (c *gobServerCodec) Close() error {
+	if c.closed {
+		// only close the connection once
+		return nil
+	}
+	c.closed = true
+	return c.rwc.Close()
+}
@@ -0,0 +1,105 @@
+package cxlimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// SweepInterval is how often a limiter forgets buckets that have filled back up
+const SweepInterval = time.Minute
+
+// bucket is a token bucket. It holds up to burst tokens, and gains rate tokens a second.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a token bucket for each key, like a pubkey or an address. Every request takes a token
+// from the bucket of its key, and is refused if the bucket is empty.
+type Limiter struct {
+	rate  float64
+	burst float64
+
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	mtx       sync.Mutex
+
+	// now is the clock, so tests can move time along
+	now func() time.Time
+}
+
+// NewLimiter creates a limiter that lets each key make rate requests a second, and up to burst at
+// once
+func NewLimiter(rate float64, burst float64) (limiter *Limiter, err error) {
+	if rate <= 0 {
+		err = fmt.Errorf("Rate for limiter must be positive, got %f", rate)
+		return
+	}
+	if burst < 1 {
+		err = fmt.Errorf("Burst for limiter must be at least 1, got %f", burst)
+		return
+	}
+
+	limiter = &Limiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+	limiter.lastSweep = limiter.now()
+	return
+}
+
+// Allow takes a token for key. If there isn't one it returns false, and how long until there is.
+func (limiter *Limiter) Allow(key string) (allowed bool, retryAfter time.Duration) {
+	limiter.mtx.Lock()
+	now := limiter.now()
+
+	if now.Sub(limiter.lastSweep) >= SweepInterval {
+		limiter.sweep(now)
+	}
+
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = b
+	}
+	limiter.refill(b, now)
+
+	if b.tokens < 1 {
+		retryAfter = time.Duration((1 - b.tokens) / limiter.rate * float64(time.Second))
+		limiter.mtx.Unlock()
+		return
+	}
+
+	b.tokens--
+	allowed = true
+	limiter.mtx.Unlock()
+	return
+}
+
+// refill adds the tokens a bucket gained since it was last used
+func (limiter *Limiter) refill(b *bucket, now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * limiter.rate
+		if b.tokens > limiter.burst {
+			b.tokens = limiter.burst
+		}
+		b.last = now
+	}
+	return
+}
+
+// sweep forgets the buckets that are full, since a new bucket would be the same. This keeps
+// the map from growing with every key that's ever made a request.
+func (limiter *Limiter) sweep(now time.Time) {
+	for key, b := range limiter.buckets {
+		limiter.refill(b, now)
+		if b.tokens >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.lastSweep = now
+	return
+}
@@ -0,0 +1,128 @@
+package cxlimit
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// ThrottledError is returned when a pubkey or address has made too many requests. Its code is
+// match.ErrRateLimited.
+type ThrottledError struct {
+	Key        string
+	RetryAfter time.Duration
+}
+
+// Error returns the key that was throttled and when to retry
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("Rate limit exceeded for %s, retry in %s", e.Key, e.RetryAfter)
+}
+
+// ErrorCode returns match.ErrRateLimited, so throttled requests get to clients with that code
+func (e *ThrottledError) ErrorCode() match.ErrorCode {
+	return match.ErrRateLimited
+}
+
+// Config is how many requests each pubkey and each address can make. Expensive methods have
+// their own budget, so cheap queries don't use it up. A rate of 0 doesn't limit those requests.
+type Config struct {
+	// Rate is the requests a second for other methods, and Burst how many can be made at once
+	Rate  float64
+	Burst float64
+
+	// ExpensiveRate is the requests a second for expensive methods, and ExpensiveBurst how many
+	// can be made at once
+	ExpensiveRate  float64
+	ExpensiveBurst float64
+}
+
+// Limits enforces a Config for an RPC server. A nil Limits allows everything, so servers
+// without limits don't need to check.
+type Limits struct {
+	pubkeys          *Limiter
+	pubkeysExpensive *Limiter
+	addrs            *Limiter
+	addrsExpensive   *Limiter
+
+	// expensive are the methods that use the expensive budget, like OpencxRPC.SubmitOrder
+	expensive map[string]bool
+}
+
+// NewLimits creates limits from a config. expensiveMethods are the full names of the methods that
+// use the expensive budget, like OpencxRPC.SubmitOrder.
+func NewLimits(config Config, expensiveMethods ...string) (limits *Limits, err error) {
+	limits = &Limits{
+		expensive: make(map[string]bool),
+	}
+	for _, method := range expensiveMethods {
+		limits.expensive[method] = true
+	}
+
+	if config.Rate != 0 {
+		if limits.pubkeys, err = NewLimiter(config.Rate, config.Burst); err != nil {
+			err = fmt.Errorf("Error creating limiter for requests: %s", err)
+			return
+		}
+		if limits.addrs, err = NewLimiter(config.Rate, config.Burst); err != nil {
+			err = fmt.Errorf("Error creating limiter for requests: %s", err)
+			return
+		}
+	}
+	if config.ExpensiveRate != 0 {
+		if limits.pubkeysExpensive, err = NewLimiter(config.ExpensiveRate, config.ExpensiveBurst); err != nil {
+			err = fmt.Errorf("Error creating limiter for expensive requests: %s", err)
+			return
+		}
+		if limits.addrsExpensive, err = NewLimiter(config.ExpensiveRate, config.ExpensiveBurst); err != nil {
+			err = fmt.Errorf("Error creating limiter for expensive requests: %s", err)
+			return
+		}
+	}
+	return
+}
+
+// AllowPubkey returns a ThrottledError if the pubkey has run out of requests for the method
+func (limits *Limits) AllowPubkey(method string, pubkey []byte) (err error) {
+	if limits == nil {
+		return
+	}
+	limiter := limits.pubkeys
+	if limits.expensive[method] {
+		limiter = limits.pubkeysExpensive
+	}
+	err = allow(limiter, "pubkey "+hex.EncodeToString(pubkey))
+	return
+}
+
+// AllowAddr returns a ThrottledError if the remote address has run out of requests for the
+// method. Only the host counts, so new connections from the same host share a budget.
+func (limits *Limits) AllowAddr(method string, remoteAddr string) (err error) {
+	if limits == nil {
+		return
+	}
+	limiter := limits.addrs
+	if limits.expensive[method] {
+		limiter = limits.addrsExpensive
+	}
+	host, _, splitErr := net.SplitHostPort(remoteAddr)
+	if splitErr != nil {
+		host = remoteAddr
+	}
+	err = allow(limiter, "address "+host)
+	return
+}
+
+// allow takes a token for key from a limiter, if there is a limiter
+func allow(limiter *Limiter, key string) (err error) {
+	if limiter == nil {
+		return
+	}
+	if allowed, retryAfter := limiter.Allow(key); !allowed {
+		err = &ThrottledError{Key: key, RetryAfter: retryAfter}
+		return
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package cxlimit
+
+import (
+	"net"
+	"net/rpc"
+	"testing"
+	"time"
+
+	"github.com/mit-dci/opencx/match"
+)
+
+// TestLimiter makes sure buckets empty, refill, and are forgotten once full
+func TestLimiter(t *testing.T) {
+	limiter, err := NewLimiter(2, 3)
+	if err != nil {
+		t.Errorf("Error creating limiter: %s", err)
+		return
+	}
+	now := time.Unix(1000, 0)
+	limiter.now = func() time.Time { return now }
+	limiter.lastSweep = now
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("a"); !allowed {
+			t.Errorf("Request %d should be allowed within the burst", i)
+			return
+		}
+	}
+	allowed, retryAfter := limiter.Allow("a")
+	if allowed {
+		t.Errorf("Request after the burst should not be allowed")
+		return
+	}
+	if retryAfter != 500*time.Millisecond {
+		t.Errorf("Should retry after 500ms at 2 a second, got %s", retryAfter)
+		return
+	}
+
+	// other keys have their own bucket
+	if allowed, _ = limiter.Allow("b"); !allowed {
+		t.Errorf("Request for another key should be allowed")
+		return
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if allowed, _ = limiter.Allow("a"); !allowed {
+		t.Errorf("Request should be allowed once a token is back")
+		return
+	}
+
+	now = now.Add(SweepInterval)
+	limiter.Allow("c")
+	if _, ok := limiter.buckets["a"]; ok {
+		t.Errorf("Full bucket should be forgotten after a sweep")
+		return
+	}
+}
+
+// TestRPC is a receiver for testing limits over net/rpc
+type TestRPC struct{}
+
+// TestReply is the reply for TestRPC methods
+type TestReply struct {
+	Value int
+	match.ErrorReply
+}
+
+// Cheap does nothing
+func (t *TestRPC) Cheap(args int, reply *TestReply) (err error) {
+	reply.Value = args
+	return
+}
+
+// Expensive does nothing, but uses the expensive budget
+func (t *TestRPC) Expensive(args int, reply *TestReply) (err error) {
+	reply.Value = args
+	return
+}
+
+// TestServeConn makes sure requests over net/rpc are throttled by address, with separate budgets
+func TestServeConn(t *testing.T) {
+	limits, err := NewLimits(Config{Rate: 0.001, Burst: 2, ExpensiveRate: 0.001, ExpensiveBurst: 1}, "TestRPC.Expensive")
+	if err != nil {
+		t.Errorf("Error creating limits: %s", err)
+		return
+	}
+
+	server := rpc.NewServer()
+	if err = server.Register(new(TestRPC)); err != nil {
+		t.Errorf("Error registering test receiver: %s", err)
+		return
+	}
+
+	serverConn, clientConn := net.Pipe()
+	go limits.ServeConn(server, serverConn)
+	client := rpc.NewClient(clientConn)
+	defer client.Close()
+
+	var reply TestReply
+	for i := 0; i < 2; i++ {
+		if err = client.Call("TestRPC.Cheap", i, &reply); err != nil {
+			t.Errorf("Cheap request %d should be allowed: %s", i, err)
+			return
+		}
+	}
+	if err = client.Call("TestRPC.Expensive", 7, &reply); err != nil || reply.Value != 7 {
+		t.Errorf("Expensive request should have its own budget, got %d, err %v", reply.Value, err)
+		return
+	}
+
+	reply = TestReply{}
+	if err = client.Call("TestRPC.Cheap", 3, &reply); err != nil || match.CodeOf(reply.Err()) != match.ErrRateLimited {
+		t.Errorf("Cheap request after the burst should be throttled, got err %v and reply error %v", err, reply.Err())
+		return
+	}
+	reply = TestReply{}
+	if err = client.Call("TestRPC.Expensive", 8, &reply); err != nil || match.CodeOf(reply.Err()) != match.ErrRateLimited {
+		t.Errorf("Expensive request after the burst should be throttled, got err %v and reply error %v", err, reply.Err())
+		return
+	}
+
+	if err = limits.AllowPubkey("TestRPC.Cheap", []byte{2, 3}); err != nil {
+		t.Errorf("Pubkeys should have their own budget: %s", err)
+		return
+	}
+	var nilLimits *Limits
+	if err = nilLimits.AllowAddr("TestRPC.Cheap", "pipe"); err != nil {
+		t.Errorf("Nil limits should allow everything: %s", err)
+		return
+	}
+}
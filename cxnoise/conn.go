@@ -2,6 +2,7 @@ package cxnoise
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"math"
 	"net"
@@ -12,7 +13,8 @@ import (
 )
 
 // Conn is an implementation of net.Conn which enforces an authenticated key
-// exchange and message encryption protocol based off the noise_XX protocol
+// exchange and message encryption protocol based off the noise_XX protocol,
+// or noise_XK if the dialer knows the listener's static key.
 // In the case of a successful handshake, all
 // messages sent via the .Write() method are encrypted with an AEAD cipher
 // along with an encrypted length-prefix. See the Machine struct for
@@ -29,11 +31,31 @@ type Conn struct {
 var _ net.Conn = (*Conn)(nil)
 
 // Dial attempts to establish an encrypted+authenticated connection with the
-// remote peer located at address which has remotePub as its long-term static
-// public key. In the case of a handshake failure, the connection is closed and
-// a non-nil error is returned.
+// remote peer located at address using the Noise_XX handshake, where the
+// remote peer sends its long-term static public key during the handshake. In
+// the case of a handshake failure, the connection is closed and a non-nil
+// error is returned.
 func Dial(localPriv *koblitz.PrivateKey, ipAddr string,
 	prologue []byte, dialer func(string, string) (net.Conn, error)) (*Conn, error) {
+	return dial(NewNoiseMachine(true, prologue, localPriv), ipAddr, dialer)
+}
+
+// DialXK attempts to establish an encrypted+authenticated connection with the
+// remote peer located at address which has remotePub as its long-term static
+// public key, using the Noise_XK handshake. The handshake fails unless the
+// remote peer holds the private key for remotePub, so this is how clients pin
+// a known server key.
+func DialXK(localPriv *koblitz.PrivateKey, remotePub *koblitz.PublicKey, ipAddr string,
+	prologue []byte, dialer func(string, string) (net.Conn, error)) (*Conn, error) {
+	if remotePub == nil {
+		return nil, fmt.Errorf("Remote pubkey must be known for an XK handshake")
+	}
+	return dial(NewNoiseMachine(true, prologue, localPriv, KnownRemoteStatic(remotePub)), ipAddr, dialer)
+}
+
+// dial connects to the remote peer and runs the initiator's side of the
+// handshake the noise machine was created for.
+func dial(noise *Machine, ipAddr string, dialer func(string, string) (net.Conn, error)) (*Conn, error) {
 	var conn net.Conn
 	var err error
 	conn, err = dialer("tcp", ipAddr)
@@ -44,7 +66,7 @@ func Dial(localPriv *koblitz.PrivateKey, ipAddr string,
 
 	b := &Conn{
 		conn:  conn,
-		noise: NewNoiseMachine(true, prologue, localPriv),
+		noise: noise,
 	}
 
 	// Initiate the handshake by sending the first act to the receiver.
@@ -63,29 +85,37 @@ func Dial(localPriv *koblitz.PrivateKey, ipAddr string,
 	// connection.
 	conn.SetReadDeadline(time.Now().Add(handshakeReadTimeout))
 
-	// If the first act was successful (we know that address is actually
-	// remotePub), then read the second act after which we'll be able to
-	// send our static public key to the remote peer with strong forward
-	// secrecy.
-	var actTwo [ActTwoSize]byte
-	if _, err := io.ReadFull(conn, actTwo[:]); err != nil {
-		b.conn.Close()
-		return nil, err
-	}
-	s, err := b.noise.RecvActTwo(actTwo)
-	if err != nil {
-		b.conn.Close()
-		return nil, err
-	}
-
-	// This is commented out because with OpenCX we don't match the hash of the static pubkey we get with the pubkey
-	// we just want plain old Noise XX Handshake
+	// If the first act was successful, then read the second act after
+	// which we'll be able to send our static public key to the remote peer
+	// with strong forward secrecy. In XK act two authenticates the remote
+	// peer as the static key we already know, in XX the remote peer sends
+	// its static key.
+	if b.noise.Version() == HandshakeVersionXK {
+		var actTwo [ActTwoSizeXK]byte
+		if _, err := io.ReadFull(conn, actTwo[:]); err != nil {
+			b.conn.Close()
+			return nil, err
+		}
+		if err := b.noise.RecvActTwoXK(actTwo); err != nil {
+			b.conn.Close()
+			return nil, err
+		}
+	} else {
+		var actTwo [ActTwoSize]byte
+		if _, err := io.ReadFull(conn, actTwo[:]); err != nil {
+			b.conn.Close()
+			return nil, err
+		}
+		s, err := b.noise.RecvActTwo(actTwo)
+		if err != nil {
+			b.conn.Close()
+			return nil, err
+		}
 
-	logging.Infof("Received pubkey %x", s)
-	// if lnutil.LitAdrFromPubkey(s) != remotePKH {
-	// 	return nil, fmt.Errorf("Remote PKH doesn't match. Quitting")
-	// }
-	// logging.Infof("Received PKH %s matches", lnutil.LitAdrFromPubkey(s))
+		// With XX we don't match the static pubkey we get against
+		// anything, clients that want to pin the server key use DialXK.
+		logging.Infof("Received pubkey %x", s)
+	}
 
 	// Finally, complete the handshake by sending over our encrypted static
 	// key and execute the final ECDH operation.
@@ -127,14 +127,28 @@ func (l *Listener) doHandshake(prologue []byte, conn net.Conn) {
 		return
 	}
 	// Next, progress the handshake processes by sending over our ephemeral
-	// key for the session along with an authenticating tag.
-	actTwo, err := cxnoiseConn.noise.GenActTwo()
-	if err != nil {
-		cxnoiseConn.conn.Close()
-		l.rejectConn(err)
-		return
+	// key for the session along with an authenticating tag. Act one told
+	// us whether the initiator already knows our static key (XK), or
+	// whether we send it (XX).
+	var actTwo []byte
+	if cxnoiseConn.noise.Version() == HandshakeVersionXK {
+		actTwoXK, err := cxnoiseConn.noise.GenActTwoXK()
+		if err != nil {
+			cxnoiseConn.conn.Close()
+			l.rejectConn(err)
+			return
+		}
+		actTwo = actTwoXK[:]
+	} else {
+		actTwoXX, err := cxnoiseConn.noise.GenActTwo()
+		if err != nil {
+			cxnoiseConn.conn.Close()
+			l.rejectConn(err)
+			return
+		}
+		actTwo = actTwoXX[:]
 	}
-	if _, err := conn.Write(actTwo[:]); err != nil {
+	if _, err := conn.Write(actTwo); err != nil {
 		cxnoiseConn.conn.Close()
 		l.rejectConn(err)
 		return
@@ -17,12 +17,16 @@ import (
 )
 
 const (
-	// protocolName is the precise instantiation of the Noise protocol
+	// protocolNameXX is the precise instantiation of the Noise protocol
 	// This value will be used as part of the prologue. If the initiator
 	// and responder aren't using the exact same string for this value,
 	// along with prologue of the Bitcoin network, then the initial
 	// handshake will fail.
-	protocolName = "Noise_XX_secp256k1_ChaChaPoly_SHA256"
+	protocolNameXX = "Noise_XX_secp256k1_ChaChaPoly_SHA256"
+
+	// protocolNameXK is the protocol name for the Noise_XK handshake, which
+	// is the same as brontide's, so XK sessions are brontide compatible.
+	protocolNameXK = "Noise_XK_secp256k1_ChaChaPoly_SHA256"
 
 	// macSize is the length in bytes of the tags generated by poly1305.
 	macSize = 16
@@ -255,6 +259,13 @@ type handshakeState struct {
 
 	initiator bool
 
+	// version is the handshake version, which decides the handshake
+	// pattern. The initiator picks it, the responder learns it from act
+	// one.
+	version byte
+
+	prologue []byte
+
 	localStatic    *koblitz.PrivateKey
 	localEphemeral *koblitz.PrivateKey
 
@@ -262,24 +273,53 @@ type handshakeState struct {
 	remoteEphemeral *koblitz.PublicKey
 }
 
-// newHandshakeState returns a new instance of the handshake state initialized
-// with the prologue and protocol name. If this is the responder's handshake
-// state, then the remotePub can be nil.
+// newHandshakeState returns a new instance of the handshake state with the
+// prologue. The symmetric state is initialized once the handshake version is
+// known, by initialize.
 func newHandshakeState(initiator bool, prologue []byte,
 	localStatic *koblitz.PrivateKey) handshakeState {
 
 	h := handshakeState{
 		initiator:   initiator,
+		version:     HandshakeVersionXX,
+		prologue:    prologue,
 		localStatic: localStatic,
 	}
 
+	return h
+}
+
+// initialize sets the handshake version and initializes the symmetric state
+// for it. The version must be HandshakeVersionXX or HandshakeVersionXK.
+func (h *handshakeState) initialize(version byte) {
+	h.version = version
+
 	// Set the current chaining key and handshake digest to the hash of the
 	// protocol name, and additionally mix in the prologue. If either sides
 	// disagree about the prologue or protocol name, then the handshake
 	// will fail.
-	h.InitializeSymmetric([]byte(protocolName))
-	h.mixHash(prologue)
-	return h
+	if version == HandshakeVersionXK {
+		h.InitializeSymmetric([]byte(protocolNameXK))
+	} else {
+		h.InitializeSymmetric([]byte(protocolNameXX))
+	}
+	h.mixHash(h.prologue)
+
+	// In XK the initiator already knows the responder's static key, so
+	// both sides mix it in as a pre-message.
+	if version == HandshakeVersionXK {
+		if h.initiator {
+			h.mixHash(h.remoteStatic.SerializeCompressed())
+		} else {
+			h.mixHash(h.localStatic.PubKey().SerializeCompressed())
+		}
+	}
+}
+
+// Version returns the handshake version. For the responder this is only
+// known once act one has been received.
+func (h *handshakeState) Version() byte {
+	return h.version
 }
 
 // EphemeralGenerator is a functional option that allows callers to substitute
@@ -292,9 +332,21 @@ func EphemeralGenerator(gen func() (*koblitz.PrivateKey, error)) func(*Machine)
 	}
 }
 
+// KnownRemoteStatic is a functional option that makes the initiator use the
+// Noise_XK handshake with a responder static key it already knows, like a
+// pinned exchange pubkey. The responder is authenticated in act two, and
+// never has to reveal its static key.
+func KnownRemoteStatic(remoteStatic *koblitz.PublicKey) func(*Machine) {
+	return func(m *Machine) {
+		m.remoteStatic = remoteStatic
+		m.version = HandshakeVersionXK
+	}
+}
+
 // Machine is a state-machine which implements cxnoise: an
 // Authenticated-key Exchange in Three Acts. cxnoise is derived from the Noise
-// framework, specifically implementing the Noise_XX handshake. Once the
+// framework, implementing the Noise_XX handshake, and the Noise_XK handshake
+// when the initiator knows the responder's static key. Once the
 // initial 3-act handshake has completed all messages are encrypted with a
 // chacha20 AEAD cipher. On the wire, all messages are prefixed with an
 // authenticated+encrypted length field. Additionally, the encrypted+auth'd
@@ -316,6 +368,17 @@ func EphemeralGenerator(gen func() (*koblitz.PrivateKey, error)) func(*Machine)
 //  INITIATOR -> e            RESPONDER
 //  INITIATOR <- e, ee, s, es RESPONDER
 //  INITIATOR -> s, se        RESPONDER
+//
+// If the initiator already knows the responder's static key, it can use the
+// Noise_XK handshake instead, which is the same as brontide's. The responder
+// learns which one from the version byte of act one, and act two is sent with
+// GenActTwoXK and RecvActTwoXK:
+// XK(s, rs):
+//  INITIATOR <- s
+//  ...
+//  INITIATOR -> e, es        RESPONDER
+//  INITIATOR <- e, ee        RESPONDER
+//  INITIATOR -> s, se        RESPONDER
 // s refers to the static key (or public key) belonging to an entity
 // e refers to the ephemeral key
 // e, ee, es refer to a DH exchange between the initiator's key pair and the
@@ -367,14 +430,24 @@ func NewNoiseMachine(initiator bool, prologue []byte, localStatic *koblitz.Priva
 		option(m)
 	}
 
+	// The initiator knows which handshake it's using now, the responder
+	// finds out in act one.
+	if initiator {
+		m.initialize(m.version)
+	}
+
 	return m
 }
 
 const (
-	// HandshakeVersion is the expected version of the cxnoise handshake.
-	// Any messages that carry a different version will cause the handshake
-	// to abort immediately.
-	HandshakeVersion = byte(1) // TODO: add support for noise_XK (brontide) as well
+	// HandshakeVersionXX is the version of the Noise_XX cxnoise handshake.
+	// Any messages that carry a version other than the one the handshake
+	// started with will cause the handshake to abort immediately.
+	HandshakeVersionXX = byte(1)
+
+	// HandshakeVersionXK is the version of the Noise_XK cxnoise handshake,
+	// which is the same as brontide's.
+	HandshakeVersionXK = byte(0)
 
 	// ActOneSize is the size of the packet sent from initiator to
 	// responder in ActOne. The packet consists of a handshake version, an
@@ -391,6 +464,12 @@ const (
 	// 1 + 33 + 33 + 16
 	ActTwoSize = 83
 
+	// ActTwoSizeXK is the size of act two in the XK handshake, where the
+	// responder doesn't send its static key.
+	// <- e, ee
+	// 1 + 33 + 16
+	ActTwoSizeXK = 50
+
 	// ActThreeSize is the size of the packet sent from initiator to
 	// responder in ActThree. The packet consists of a handshake version,
 	// the initiators static key encrypted with strong forward secrecy and
@@ -403,8 +482,9 @@ const (
 // GenActOne generates the initial packet (act one) to be sent from initiator
 // to responder. During act one the initiator generates an ephemeral key and
 // hashes it into the handshake digest. Future payloads are encrypted with a key
-// derived from this result.
-// -> e
+// derived from this result. In XK the initiator also does an ECDH with the
+// responder's static key.
+// -> e (, es)
 func (b *Machine) GenActOne() ([ActOneSize]byte, error) {
 	var (
 		err    error
@@ -422,8 +502,14 @@ func (b *Machine) GenActOne() ([ActOneSize]byte, error) {
 	// Hash it into the handshake digest
 	b.mixHash(e)
 
+	// es
+	if b.version == HandshakeVersionXK {
+		es := ecdh(b.remoteStatic, b.localEphemeral)
+		b.mixKey(es)
+	}
+
 	authPayload := b.EncryptAndHash([]byte{})
-	actOne[0] = HandshakeVersion
+	actOne[0] = b.version
 	copy(actOne[1:34], e)
 	copy(actOne[34:], authPayload)
 	return actOne, nil
@@ -441,12 +527,13 @@ func (b *Machine) RecvActOne(actOne [ActOneSize]byte) error {
 	)
 
 	// If the handshake version is unknown, then the handshake fails
-	// immediately.
-	if actOne[0] != HandshakeVersion {
+	// immediately. Otherwise it decides the handshake pattern.
+	if actOne[0] != HandshakeVersionXX && actOne[0] != HandshakeVersionXK {
 		return fmt.Errorf("Act One: invalid handshake version: %v, "+
-			"only %v is valid, msg=%x", actOne[0], HandshakeVersion,
-			actOne[:])
+			"only %v and %v are valid, msg=%x", actOne[0],
+			HandshakeVersionXX, HandshakeVersionXK, actOne[:])
 	}
+	b.initialize(actOne[0])
 
 	copy(e[:], actOne[1:34])
 	copy(p[:], actOne[34:])
@@ -458,6 +545,12 @@ func (b *Machine) RecvActOne(actOne [ActOneSize]byte) error {
 	}
 	b.mixHash(b.remoteEphemeral.SerializeCompressed())
 
+	// es
+	if b.version == HandshakeVersionXK {
+		es := ecdh(b.remoteEphemeral, b.localStatic)
+		b.mixKey(es)
+	}
+
 	_, err = b.DecryptAndHash(p[:])
 	return err // nil means Act one completed successfully
 }
@@ -471,6 +564,11 @@ func (b *Machine) GenActTwo() ([ActTwoSize]byte, error) {
 		actTwo [ActTwoSize]byte
 	)
 
+	if b.version != HandshakeVersionXX {
+		return actTwo, fmt.Errorf("Act Two: handshake version is %v, "+
+			"use GenActTwoXK", b.version)
+	}
+
 	// e
 	b.localEphemeral, err = b.ephemeralGen()
 	if err != nil {
@@ -493,7 +591,7 @@ func (b *Machine) GenActTwo() ([ActTwoSize]byte, error) {
 	b.mixKey(es)
 
 	authPayload := b.EncryptAndHash([]byte{})
-	actTwo[0] = HandshakeVersion
+	actTwo[0] = HandshakeVersionXX
 	copy(actTwo[1:34], e)
 	copy(actTwo[34:67], s)
 	copy(actTwo[67:], authPayload)
@@ -514,9 +612,9 @@ func (b *Machine) RecvActTwo(actTwo [ActTwoSize]byte) ([33]byte, error) {
 	var empty [33]byte
 	// If the handshake version is unknown, then the handshake fails
 	// immediately.
-	if actTwo[0] != HandshakeVersion {
+	if b.version != HandshakeVersionXX || actTwo[0] != HandshakeVersionXX {
 		return empty, fmt.Errorf("Act Two: invalid handshake version: %v, "+
-			"only %v is valid, msg=%x", actTwo[0], HandshakeVersion,
+			"only %v is valid, msg=%x", actTwo[0], b.version,
 			actTwo[:])
 	}
 
@@ -550,6 +648,77 @@ func (b *Machine) RecvActTwo(actTwo [ActTwoSize]byte) ([33]byte, error) {
 	return s, err
 }
 
+// GenActTwoXK generates act two of the XK handshake, to be sent from the
+// responder to the initiator. The initiator already knows the responder's
+// static key, so only the ephemeral key is sent.
+// <- e, ee
+func (b *Machine) GenActTwoXK() ([ActTwoSizeXK]byte, error) {
+	var (
+		err    error
+		actTwo [ActTwoSizeXK]byte
+	)
+
+	if b.version != HandshakeVersionXK {
+		return actTwo, fmt.Errorf("Act Two: handshake version is %v, "+
+			"use GenActTwo", b.version)
+	}
+
+	// e
+	b.localEphemeral, err = b.ephemeralGen()
+	if err != nil {
+		return actTwo, err
+	}
+
+	e := b.localEphemeral.PubKey().SerializeCompressed()
+	b.mixHash(e)
+
+	// ee
+	ee := ecdh(b.remoteEphemeral, b.localEphemeral)
+	b.mixKey(ee)
+
+	authPayload := b.EncryptAndHash([]byte{})
+	actTwo[0] = HandshakeVersionXK
+	copy(actTwo[1:34], e)
+	copy(actTwo[34:], authPayload)
+	return actTwo, nil
+}
+
+// RecvActTwoXK processes act two of the XK handshake. Since es was mixed in
+// during act one, decrypting the tag authenticates the responder as the
+// holder of the static key the initiator expected.
+func (b *Machine) RecvActTwoXK(actTwo [ActTwoSizeXK]byte) error {
+	var (
+		err error
+		e   [33]byte
+		p   [16]byte
+	)
+
+	// If the handshake version is unknown, then the handshake fails
+	// immediately.
+	if b.version != HandshakeVersionXK || actTwo[0] != HandshakeVersionXK {
+		return fmt.Errorf("Act Two: invalid handshake version: %v, "+
+			"only %v is valid, msg=%x", actTwo[0], b.version,
+			actTwo[:])
+	}
+
+	copy(e[:], actTwo[1:34])
+	copy(p[:], actTwo[34:])
+
+	// e
+	b.remoteEphemeral, err = koblitz.ParsePubKey(e[:], koblitz.S256())
+	if err != nil {
+		return err
+	}
+	b.mixHash(b.remoteEphemeral.SerializeCompressed())
+
+	// ee
+	ee := ecdh(b.remoteEphemeral, b.localEphemeral)
+	b.mixKey(ee)
+
+	_, err = b.DecryptAndHash(p[:])
+	return err
+}
+
 // GenActThree creates the final (act three) packet of the handshake. Act three
 // is to be sent from the initiator to the responder. The purpose of act three
 // is to transmit the initiator's public key under strong forward secrecy to
@@ -569,7 +738,7 @@ func (b *Machine) GenActThree() ([ActThreeSize]byte, error) {
 
 	authPayload := b.EncryptAndHash([]byte{})
 
-	actThree[0] = HandshakeVersion
+	actThree[0] = b.version
 	copy(actThree[1:50], encryptedS)
 	copy(actThree[50:], authPayload)
 
@@ -592,9 +761,9 @@ func (b *Machine) RecvActThree(actThree [ActThreeSize]byte) error {
 
 	// If the handshake version is unknown, then the handshake fails
 	// immediately.
-	if actThree[0] != HandshakeVersion {
+	if actThree[0] != b.version {
 		return fmt.Errorf("Act Three: invalid handshake version: %v, "+
-			"only %v is valid, msg=%x", actThree[0], HandshakeVersion,
+			"only %v is valid, msg=%x", actThree[0], b.version,
 			actThree[:])
 	}
 
@@ -507,3 +507,231 @@ func TestBolt0008TestVectors(t *testing.T) {
 		buf.Reset()
 	}
 }
+
+// TestBolt0008XKTestVectors checks the XK handshake against the test vectors
+// in the appendix of BOLT-0008, which makes sure XK sessions are compatible
+// with brontide.
+func TestBolt0008XKTestVectors(t *testing.T) {
+	t.Parallel()
+
+	decode := func(s string) []byte {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			t.Fatalf("unable to decode hex: %v", err)
+		}
+		return b
+	}
+	ephemeral := func(e string) func(*Machine) {
+		return EphemeralGenerator(func() (*koblitz.PrivateKey, error) {
+			priv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(), decode(e))
+			return priv, nil
+		})
+	}
+
+	initiatorPriv, _ := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		decode("1111111111111111111111111111111111111111111111111111111111111111"))
+	responderPriv, responderPub := koblitz.PrivKeyFromBytes(koblitz.S256(),
+		decode("2121212121212121212121212121212121212121212121212121212121212121"))
+
+	initiator := NewNoiseMachine(true, []byte("lightning"), initiatorPriv,
+		KnownRemoteStatic(responderPub),
+		ephemeral("1212121212121212121212121212121212121212121212121212121212121212"))
+	responder := NewNoiseMachine(false, []byte("lightning"), responderPriv,
+		ephemeral("2222222222222222222222222222222222222222222222222222222222222222"))
+
+	actOne, err := initiator.GenActOne()
+	if err != nil {
+		t.Fatalf("unable to generate act one: %v", err)
+	}
+	expectedActOne := decode("00036360e856310ce5d294e8be33fc807077dc56ac80d95d" +
+		"9cd4ddbd21325eff73f70df6086551151f58b8afe6c195782c6a")
+	if !bytes.Equal(expectedActOne, actOne[:]) {
+		t.Fatalf("act one mismatch: expected %x, got %x",
+			expectedActOne, actOne)
+	}
+	if err := responder.RecvActOne(actOne); err != nil {
+		t.Fatalf("responder unable to process act one: %v", err)
+	}
+	if responder.Version() != HandshakeVersionXK {
+		t.Fatalf("responder should be using XK, got version %v",
+			responder.Version())
+	}
+
+	actTwo, err := responder.GenActTwoXK()
+	if err != nil {
+		t.Fatalf("unable to generate act two: %v", err)
+	}
+	expectedActTwo := decode("0002466d7fcae563e5cb09a0d1870bb580344804617879a1" +
+		"4949cf22285f1bae3f276e2470b93aac583c9ef6eafca3f730ae")
+	if !bytes.Equal(expectedActTwo, actTwo[:]) {
+		t.Fatalf("act two mismatch: expected %x, got %x",
+			expectedActTwo, actTwo)
+	}
+	if err := initiator.RecvActTwoXK(actTwo); err != nil {
+		t.Fatalf("initiator unable to process act two: %v", err)
+	}
+
+	actThree, err := initiator.GenActThree()
+	if err != nil {
+		t.Fatalf("unable to generate act three: %v", err)
+	}
+	expectedActThree := decode("00b9e3a702e93e3a9948c2ed6e5fd7590a6e1c3a0344cfc9" +
+		"d5b57357049aa22355361aa02e55a8fc28fef5bd6d71ad0c38228dc68b1c4662" +
+		"63b47fdf31e560e139ba")
+	if !bytes.Equal(expectedActThree, actThree[:]) {
+		t.Fatalf("act three mismatch: expected %x, got %x",
+			expectedActThree, actThree)
+	}
+	if err := responder.RecvActThree(actThree); err != nil {
+		t.Fatalf("responder unable to process act three: %v", err)
+	}
+	if !responder.remoteStatic.IsEqual(initiatorPriv.PubKey()) {
+		t.Fatalf("responder learned the wrong initiator static key")
+	}
+
+	sendingKey := decode("969ab31b4d288cedf6218839b27a3e2140827047f2c0f01bf5c04435d43511a9")
+	recvKey := decode("bb9020b8965f4df047e07f955f3c4b88418984aadc5cdb35096b9ea8fa5c3442")
+	chainKey := decode("919219dbb2920afa8db80f9a51787a840bcf111ed8d588caf9ab4be716e42b01")
+	if !bytes.Equal(initiator.sendCipher.secretKey[:], sendingKey) ||
+		!bytes.Equal(responder.recvCipher.secretKey[:], sendingKey) {
+		t.Fatalf("sending key mismatch: expected %x", sendingKey)
+	}
+	if !bytes.Equal(initiator.recvCipher.secretKey[:], recvKey) ||
+		!bytes.Equal(responder.sendCipher.secretKey[:], recvKey) {
+		t.Fatalf("receiving key mismatch: expected %x", recvKey)
+	}
+	if !bytes.Equal(initiator.chainingKey[:], chainKey) ||
+		!bytes.Equal(responder.chainingKey[:], chainKey) {
+		t.Fatalf("chaining key mismatch: expected %x", chainKey)
+	}
+
+	// The transport messages are the same as brontide's too, including
+	// across key rotations.
+	transportMessageVectors := map[int]string{
+		0:    "cf2b30ddf0cf3f80e7c35a6e6730b59fe802473180f396d88a8fb0db8cbcf25d2f214cf9ea1d95",
+		1:    "72887022101f0b6753e0c7de21657d35a4cb2a1f5cde2650528bbc8f837d0f0d7ad833b1a256a1",
+		500:  "178cb9d7387190fa34db9c2d50027d21793c9bc2d40b1e14dcf30ebeeeb220f48364f7a4c68bf8",
+		501:  "1b186c57d44eb6de4c057c49940d79bb838a145cb528d6e8fd26dbe50a60ca2c104b56b60e45bd",
+		1000: "4a2f3cc3b5e78ddb83dcb426d9863d9d9a723b0337c89dd0b005d89f8d3c05c52b76b29b740f09",
+		1001: "2ecd8c8a5629d0d02ab457a0fdd0f7b90a192cd46be5ecb6ca570bfc5e268338b1a16cf4ef2d36",
+	}
+
+	payload := []byte("hello")
+	var buf bytes.Buffer
+	for i := 0; i < 1002; i++ {
+		if err := initiator.WriteMessage(&buf, payload); err != nil {
+			t.Fatalf("could not write message %s", payload)
+		}
+		if val, ok := transportMessageVectors[i]; ok {
+			if !bytes.Equal(buf.Bytes(), decode(val)) {
+				t.Fatalf("Ciphertext %x was not equal to expected %s",
+					buf.Bytes(), val)
+			}
+		}
+
+		plaintext, err := responder.ReadMessage(&buf)
+		if err != nil {
+			t.Fatalf("failed to read message in responder: %v", err)
+		}
+		if !bytes.Equal(plaintext, payload) {
+			t.Fatalf("Decryption failed to receive plaintext: %s, got %s",
+				payload, plaintext)
+		}
+		buf.Reset()
+	}
+}
+
+// TestMixedVersionPeers checks that one listener accepts both an XX dialer and
+// an XK dialer that pinned the listener's key, and that either kind of session
+// works.
+func TestMixedVersionPeers(t *testing.T) {
+	listener, netAddr, err := makeListener()
+	if err != nil {
+		t.Fatalf("unable to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	dialers := map[string]func(*koblitz.PrivateKey) (*Conn, error){
+		"XX": func(priv *koblitz.PrivateKey) (*Conn, error) {
+			return Dial(priv, netAddr, []byte("opencx"), net.Dial)
+		},
+		"XK": func(priv *koblitz.PrivateKey) (*Conn, error) {
+			return DialXK(priv, listener.localStatic.PubKey(), netAddr, []byte("opencx"), net.Dial)
+		},
+	}
+
+	for name, dialer := range dialers {
+		remotePriv, err := koblitz.NewPrivateKey(koblitz.S256())
+		if err != nil {
+			t.Fatalf("unable to generate key: %v", err)
+		}
+
+		remoteConnChan := make(chan maybeNetConn, 1)
+		go func() {
+			remoteConn, err := dialer(remotePriv)
+			remoteConnChan <- maybeNetConn{remoteConn, err}
+		}()
+
+		localConn, err := listener.Accept()
+		if err != nil {
+			t.Fatalf("%s: listener unable to accept: %v", name, err)
+		}
+		remote := <-remoteConnChan
+		if remote.err != nil {
+			t.Fatalf("%s: unable to dial: %v", name, remote.err)
+		}
+
+		// Both sides should know who they're talking to
+		if !localConn.(*Conn).RemotePub().IsEqual(remotePriv.PubKey()) {
+			t.Fatalf("%s: listener got the wrong remote pubkey", name)
+		}
+		if !remote.conn.(*Conn).RemotePub().IsEqual(listener.localStatic.PubKey()) {
+			t.Fatalf("%s: dialer got the wrong remote pubkey", name)
+		}
+
+		msg := []byte("hello " + name)
+		if _, err := remote.conn.Write(msg); err != nil {
+			t.Fatalf("%s: dialer failed to write: %v", name, err)
+		}
+		readBuf := make([]byte, len(msg))
+		if _, err := io.ReadFull(localConn, readBuf); err != nil {
+			t.Fatalf("%s: listener failed to read: %v", name, err)
+		}
+		if !bytes.Equal(readBuf, msg) {
+			t.Fatalf("%s: messages don't match, %v vs %v", name,
+				string(readBuf), string(msg))
+		}
+
+		localConn.Close()
+		remote.conn.Close()
+	}
+}
+
+// TestXKWrongPinnedKey checks that an XK dialer can't connect to a listener
+// that doesn't hold the key it pinned.
+func TestXKWrongPinnedKey(t *testing.T) {
+	listener, netAddr, err := makeListener()
+	if err != nil {
+		t.Fatalf("unable to create listener: %v", err)
+	}
+	defer listener.Close()
+
+	localPriv, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+	wrongPriv, err := koblitz.NewPrivateKey(koblitz.S256())
+	if err != nil {
+		t.Fatalf("unable to generate key: %v", err)
+	}
+
+	// The listener can't decrypt act one, so it drops the connection and
+	// the dialer fails reading act two.
+	if _, err := DialXK(localPriv, wrongPriv.PubKey(), netAddr, []byte("opencx"), net.Dial); err == nil {
+		t.Fatalf("dial with the wrong pinned key should fail")
+	}
+
+	if _, err := listener.Accept(); err == nil {
+		t.Fatalf("listener should reject the handshake")
+	}
+}
@@ -2,27 +2,38 @@ package cxrpc
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
 	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
 )
 
 // RegisterArgs holds the args for register
 type RegisterArgs struct {
-	Signature []byte
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a register request commits to
+func (args *RegisterArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs()
+	return
 }
 
 // RegisterReply holds the data for the register reply
 type RegisterReply struct {
-	// empty
+	match.ErrorReply
 }
 
-// Register registers a pubkey into the db, verifies that the action was signed by that pubkey. A valid signature for the string "register" is considered a valid registration.
+// Register registers a pubkey into the db, verifies that the action was signed by that pubkey.
+// Over noise the request can be left out, and the session pubkey is registered.
 func (cl *OpencxRPC) Register(args RegisterArgs, reply *RegisterReply) (err error) {
+	defer reply.SetError(&err)
 
 	var pubkey *koblitz.PublicKey
-	if pubkey, err = cl.Server.RegistrationStringVerify(args.Signature); err != nil {
-		err = fmt.Errorf("Error verifying registration string for register RPC command: %s", err)
+	if pubkey, err = cl.authorize("OpencxRPC.Register", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for register RPC command: %s", err)
 		return
 	}
 
@@ -37,18 +48,26 @@ func (cl *OpencxRPC) Register(args RegisterArgs, reply *RegisterReply) (err erro
 	return
 }
 
-// GetRegistrationStringArgs holds the args for register
-type GetRegistrationStringArgs struct {
-	// empty
+// GetNonceArgs holds the args for the getnonce command
+type GetNonceArgs struct {
+	Pubkey [33]byte
 }
 
-// GetRegistrationStringReply holds the data for the register reply
-type GetRegistrationStringReply struct {
-	RegistrationString string
+// GetNonceReply holds the reply for the getnonce command
+type GetNonceReply struct {
+	Nonce  [32]byte
+	Expiry time.Time
+	match.ErrorReply
 }
 
-// GetRegistrationString returns a string to the client which is a valid string to sign to indicate they want their pubkey to be registered. This is like kinda weird but whatever
-func (cl *OpencxRPC) GetRegistrationString(args GetRegistrationStringArgs, reply *GetRegistrationStringReply) (err error) {
-	reply.RegistrationString = cl.Server.GetRegistrationString()
+// GetNonce issues a nonce for a pubkey to sign one request with. Every signed command needs a new
+// one, so a signed request can't be replayed.
+func (cl *OpencxRPC) GetNonce(args GetNonceArgs, reply *GetNonceReply) (err error) {
+	defer reply.SetError(&err)
+
+	if reply.Nonce, reply.Expiry, err = cl.Server.IssueNonce(args.Pubkey); err != nil {
+		err = fmt.Errorf("Error issuing nonce for GetNonce RPC command: %s", err)
+		return
+	}
 	return
 }
@@ -0,0 +1,32 @@
+package cxrpc
+
+import (
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/match"
+)
+
+// authorize returns the pubkey a call is authorized for. A call over noise with no signed request
+// is authorized for the session pubkey, since the handshake already proved the client has the key.
+// Otherwise the server verifies the signed request, and over noise the signer has to be the
+// session pubkey. Either way the call takes a request from the pubkey's budget.
+func (cl *OpencxRPC) authorize(method string, argsHash [32]byte, req *cxauth.SignedRequest) (pubkey *koblitz.PublicKey, err error) {
+	if req == nil && cl.sessionPubkey != nil {
+		pubkey = cl.sessionPubkey
+	} else {
+		if pubkey, err = cl.Server.VerifyRequest(method, argsHash, req); err != nil {
+			return
+		}
+
+		if cl.sessionPubkey != nil && !cl.sessionPubkey.IsEqual(pubkey) {
+			err = match.Errorf(match.ErrUnauthorized, "Request is signed by %x but the session is authenticated as %x", pubkey.SerializeCompressed(), cl.sessionPubkey.SerializeCompressed())
+			return
+		}
+	}
+
+	if err = cl.limits.AllowPubkey(method, pubkey.SerializeCompressed()); err != nil {
+		return
+	}
+
+	return
+}
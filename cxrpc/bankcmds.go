@@ -6,43 +6,46 @@ import (
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/cxauth"
 	"github.com/mit-dci/opencx/match"
-	"golang.org/x/crypto/sha3"
 )
 
 // GetBalanceArgs hold the arguments for GetBalance
 type GetBalanceArgs struct {
-	Asset     string
-	Signature []byte
+	Asset   string
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a getbalance request commits to
+func (args *GetBalanceArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs([]byte(args.Asset))
+	return
 }
 
 // GetBalanceReply holds the reply for GetBalance
 type GetBalanceReply struct {
 	Amount uint64
+	match.ErrorReply
 }
 
 // GetBalance is the RPC Interface for GetBalance
 func (cl *OpencxRPC) GetBalance(args GetBalanceArgs, reply *GetBalanceReply) (err error) {
-
-	// e = h(asset)
-	sha3 := sha3.New256()
-	sha3.Write([]byte(args.Asset))
-	e := sha3.Sum(nil)
+	defer reply.SetError(&err)
 
 	var pubkey *koblitz.PublicKey
-	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
-		err = fmt.Errorf("Error verifying order, invalid signature: \n%s", err)
+	if pubkey, err = cl.authorize("OpencxRPC.GetBalance", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for GetBalance RPC command: %s", err)
 		return
 	}
 
 	var param *coinparam.Params
 	if param, err = util.GetParamFromName(args.Asset); err != nil {
-		err = fmt.Errorf("Error getting coin type from name, pass in a different asset")
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting coin type from name, pass in a different asset")
 		return
 	}
 
 	if reply.Amount, err = cl.Server.GetBalance(pubkey, param); err != nil {
-		err = fmt.Errorf("Error getting balance for pubkey in GetBalance RPC command: %s", err)
+		err = match.Wrapf(err, "Error getting balance for pubkey in GetBalance RPC command: %s", err)
 		return
 	}
 
@@ -51,37 +54,40 @@ func (cl *OpencxRPC) GetBalance(args GetBalanceArgs, reply *GetBalanceReply) (er
 
 // GetDepositAddressArgs hold the arguments for GetDepositAddress
 type GetDepositAddressArgs struct {
-	Asset     string
-	Signature []byte
+	Asset   string
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a getdepositaddress request commits to
+func (args *GetDepositAddressArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs([]byte(args.Asset))
+	return
 }
 
 // GetDepositAddressReply holds the reply for GetDepositAddress
 type GetDepositAddressReply struct {
 	Address string
+	match.ErrorReply
 }
 
 // GetDepositAddress is the RPC Interface for GetDepositAddress
 func (cl *OpencxRPC) GetDepositAddress(args GetDepositAddressArgs, reply *GetDepositAddressReply) (err error) {
-
-	// e = h(asset)
-	sha3 := sha3.New256()
-	sha3.Write([]byte(args.Asset))
-	e := sha3.Sum(nil)
+	defer reply.SetError(&err)
 
 	var pubkey *koblitz.PublicKey
-	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
-		err = fmt.Errorf("Error, invalid signature with GetDepositAddress RPC command: %s", err)
+	if pubkey, err = cl.authorize("OpencxRPC.GetDepositAddress", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for GetDepositAddress RPC command: %s", err)
 		return
 	}
 
 	var param *coinparam.Params
 	if param, err = util.GetParamFromName(args.Asset); err != nil {
-		err = fmt.Errorf("Error getting param from name for asset: %s", err)
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting param from name for asset: %s", err)
 		return
 	}
 
 	if reply.Address, err = cl.Server.GetDepositAddress(pubkey, param); err != nil {
-		err = fmt.Errorf("Error getting deposit address from server for GetDepositAddress RPC: %s", err)
+		err = match.Wrapf(err, "Error getting deposit address from server for GetDepositAddress RPC: %s", err)
 		return
 	}
 
@@ -91,30 +97,39 @@ func (cl *OpencxRPC) GetDepositAddress(args GetDepositAddressArgs, reply *GetDep
 // WithdrawArgs holds the args for Withdraw
 type WithdrawArgs struct {
 	Withdrawal *match.Withdrawal
-	Signature  []byte
+	Request    *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a withdraw request commits to
+func (args *WithdrawArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs(args.Withdrawal.Serialize())
+	return
 }
 
 // WithdrawReply holds the reply for Withdraw
 type WithdrawReply struct {
 	Txid string
+	match.ErrorReply
 }
 
 // Withdraw is the RPC Interface for Withdraw
 func (cl *OpencxRPC) Withdraw(args WithdrawArgs, reply *WithdrawReply) (err error) {
+	defer reply.SetError(&err)
 
-	// e = h(asset)
-	sha3 := sha3.New256()
-	sha3.Write(args.Withdrawal.Serialize())
-	e := sha3.Sum(nil)
+	if args.Withdrawal == nil {
+		err = fmt.Errorf("Withdrawal cannot be nil for Withdraw RPC command")
+		return
+	}
 
-	pubkey, _, err := koblitz.RecoverCompact(koblitz.S256(), args.Signature, e)
-	if err != nil {
-		err = fmt.Errorf("Error verifying order, invalid signature: \n%s", err)
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.authorize("OpencxRPC.Withdraw", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for Withdraw RPC command: %s", err)
 		return
 	}
 
 	var coinType *coinparam.Params
 	if coinType, err = util.GetParamFromName(args.Withdrawal.Asset.String()); err != nil {
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting param from name for asset: %s", err)
 		return
 	}
 
@@ -123,14 +138,14 @@ func (cl *OpencxRPC) Withdraw(args WithdrawArgs, reply *WithdrawReply) (err erro
 	if args.Withdrawal.Lightning {
 
 		if reply.Txid, err = cl.Server.WithdrawLightning(pubkey, args.Withdrawal.Amount, coinType); err != nil {
-			err = fmt.Errorf("Error with withdraw command (withdraw from lightning): \n%s", err)
+			err = match.Wrapf(err, "Error with withdraw command (withdraw from lightning): \n%s", err)
 			return
 		}
 
 	} else {
 
 		if reply.Txid, err = cl.Server.WithdrawCoins(args.Withdrawal.Address, pubkey, args.Withdrawal.Amount, coinType); err != nil {
-			err = fmt.Errorf("Error with withdraw command (withdraw from chain): \n%s", err)
+			err = match.Wrapf(err, "Error with withdraw command (withdraw from chain): \n%s", err)
 			return
 		}
 
@@ -5,6 +5,7 @@ import (
 	"net"
 	"strconv"
 
+	"github.com/mit-dci/opencx/cxauth"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
@@ -18,10 +19,13 @@ type GetLitConnectionArgs struct {
 type GetLitConnectionReply struct {
 	PubKeyHash string
 	Ports      []uint16
+	match.ErrorReply
 }
 
 // GetLitConnection gets a pubkeyhash and port for connecting with lit, the hostname is assumed to be the same.
 func (cl *OpencxRPC) GetLitConnection(args GetLitConnectionArgs, reply *GetLitConnectionReply) (err error) {
+	defer reply.SetError(&err)
+
 	var hosts []string
 	reply.PubKeyHash, hosts = cl.Server.ExchangeNode.GetLisAddressAndPorts()
 
@@ -53,16 +57,18 @@ func (cl *OpencxRPC) GetLitConnection(args GetLitConnectionArgs, reply *GetLitCo
 // WithdrawToLightningNodeArgs holds the args for the withdrawtolightning RPC command
 type WithdrawToLightningNodeArgs struct {
 	Withdrawal *match.Withdrawal
-	Signature  []byte
+	Request    *cxauth.SignedRequest
 }
 
 // WithdrawToLightningNodeReply holds the reply for the withdrawtolightning RPC command
 type WithdrawToLightningNodeReply struct {
 	Txid string
+	match.ErrorReply
 }
 
 // WithdrawToLightningNode creates a channel that pushes a certain amount to a lightning node through a lightning channel.
 func (cl *OpencxRPC) WithdrawToLightningNode(args WithdrawToLightningNodeArgs, reply *WithdrawToLightningNodeReply) (err error) {
+	defer reply.SetError(&err)
 
 	return
 }
@@ -2,13 +2,24 @@ package cxrpc
 
 import (
 	"net"
+	"sync"
 
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxjsonrpc"
+	"github.com/mit-dci/opencx/cxlimit"
 	"github.com/mit-dci/opencx/cxserver"
 )
 
 // OpencxRPC is what is registered and called
 type OpencxRPC struct {
 	Server *cxserver.OpencxServer
+
+	// sessionPubkey is the pubkey the noise handshake authenticated for the
+	// connection this was registered for. It's nil when not using noise.
+	sessionPubkey *koblitz.PublicKey
+
+	// limits throttles requests by pubkey and address, if set
+	limits *cxlimit.Limits
 }
 
 // OpencxRPCCaller is a listener for RPC commands
@@ -16,4 +27,11 @@ type OpencxRPCCaller struct {
 	caller   *OpencxRPC
 	listener net.Listener
 	killers  []chan bool
+
+	// jsonGateway serves JSON-RPC, if JSONRPCListen was called
+	jsonGateway *cxjsonrpc.Gateway
+
+	// isStopped is set once Stop is called, so the accept loop knows to exit
+	isStopped bool
+	stopMtx   sync.Mutex
 }
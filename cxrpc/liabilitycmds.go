@@ -0,0 +1,90 @@
+package cxrpc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/crypto/merklesum"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/match"
+)
+
+// GetLiabilityRootArgs holds the args for the getliabilityroot command
+type GetLiabilityRootArgs struct {
+	Asset string
+}
+
+// GetLiabilityRootReply holds the reply for the getliabilityroot command
+type GetLiabilityRootReply struct {
+	Root      merklesum.Node
+	NumUsers  uint64
+	Published time.Time
+	match.ErrorReply
+}
+
+// GetLiabilityRoot gets the latest published liability root for an asset. It isn't authenticated,
+// so the exchange can't tell who is asking and give them their own root.
+func (cl *OpencxRPC) GetLiabilityRoot(args GetLiabilityRootArgs, reply *GetLiabilityRootReply) (err error) {
+	defer reply.SetError(&err)
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Root, reply.NumUsers, reply.Published, err = cl.Server.GetLiabilityRoot(param); err != nil {
+		err = fmt.Errorf("Error getting liability root for GetLiabilityRoot RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetLiabilityProofArgs holds the args for the getliabilityproof command
+type GetLiabilityProofArgs struct {
+	Asset   string
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a getliabilityproof request commits to
+func (args *GetLiabilityProofArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs([]byte(args.Asset))
+	return
+}
+
+// GetLiabilityProofReply holds the reply for the getliabilityproof command
+type GetLiabilityProofReply struct {
+	Proof     *merklesum.InclusionProof
+	Published time.Time
+	match.ErrorReply
+}
+
+// GetLiabilityProof gets the proof that the signer's balance is included in the latest published
+// liability root for an asset. The root is not part of the reply, clients check the proof against
+// the root from GetLiabilityRoot or one published somewhere else.
+func (cl *OpencxRPC) GetLiabilityProof(args GetLiabilityProofArgs, reply *GetLiabilityProofReply) (err error) {
+	defer reply.SetError(&err)
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.authorize("OpencxRPC.GetLiabilityProof", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for GetLiabilityProof RPC command: %s", err)
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Proof, reply.Published, err = cl.Server.GetLiabilityProof(pubkey, param); err != nil {
+		err = match.Wrapf(err, "Error getting liability proof for GetLiabilityProof RPC command: %s", err)
+		return
+	}
+
+	return
+}
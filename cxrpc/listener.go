@@ -4,8 +4,11 @@ import (
 	"fmt"
 	"net"
 	"net/rpc"
+	"time"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxjsonrpc"
+	"github.com/mit-dci/opencx/cxlimit"
 	"github.com/mit-dci/opencx/cxnoise"
 	"github.com/mit-dci/opencx/cxserver"
 	"github.com/mit-dci/opencx/logging"
@@ -20,6 +23,31 @@ func CreateRPCForServer(server *cxserver.OpencxServer) (rpc1 *OpencxRPCCaller, e
 	return
 }
 
+// ExpensiveMethods are the methods that use the expensive budget of the rate limits, since they
+// change the books or balances
+var ExpensiveMethods = []string{
+	"OpencxRPC.SubmitOrder",
+	"OpencxRPC.CancelOrder",
+	"OpencxRPC.Withdraw",
+	"OpencxRPC.WithdrawToLightningNode",
+	"OpencxRPC.ProveSolvency",
+}
+
+// SetRateLimits throttles requests by pubkey and by address, giving ExpensiveMethods their own
+// budget. It should be called before listening.
+func (rpc1 *OpencxRPCCaller) SetRateLimits(config cxlimit.Config) (err error) {
+	if rpc1.caller == nil {
+		err = fmt.Errorf("Error, rpc caller cannot be nil, please create caller correctly")
+		return
+	}
+
+	if rpc1.caller.limits, err = cxlimit.NewLimits(config, ExpensiveMethods...); err != nil {
+		err = fmt.Errorf("Error creating rate limits for RPC: %s", err)
+		return
+	}
+	return
+}
+
 // NoiseListen is a synchronous version of RPCListenAsync
 func (rpc1 *OpencxRPCCaller) NoiseListen(privkey *koblitz.PrivateKey, host string, port uint16) (err error) {
 
@@ -34,7 +62,8 @@ func (rpc1 *OpencxRPCCaller) NoiseListen(privkey *koblitz.PrivateKey, host strin
 	return
 }
 
-// NoiseListenAsync listens on socket host and port
+// NoiseListenAsync listens on socket host and port. Every noise connection gets its own RPC
+// server, so handlers know which pubkey the handshake authenticated.
 func (rpc1 *OpencxRPCCaller) NoiseListenAsync(doneChan chan bool, errChan chan error, privkey *koblitz.PrivateKey, host string, port uint16) {
 	var err error
 	if rpc1.caller == nil {
@@ -43,12 +72,9 @@ func (rpc1 *OpencxRPCCaller) NoiseListenAsync(doneChan chan bool, errChan chan e
 		return
 	}
 
-	// Start noise rpc server (need to do this since the client is a rpc newclient)
-	noiseRPCServer := rpc.NewServer()
-
+	// Make sure the API registers before listening, each connection registers it again
 	logging.Infof("Registering RPC API over Noise protocol ...")
-	// Register rpc
-	if err = noiseRPCServer.Register(rpc1.caller); err != nil {
+	if err = rpc.NewServer().Register(rpc1.caller); err != nil {
 		errChan <- fmt.Errorf("Error registering RPC Interface: %s", err)
 		close(errChan)
 		return
@@ -62,15 +88,51 @@ func (rpc1 *OpencxRPCCaller) NoiseListenAsync(doneChan chan bool, errChan chan e
 		return
 	}
 	logging.Infof("Running RPC-Noise server on %s\n", rpc1.listener.Addr().String())
+	logging.Infof("RPC-Noise server pubkey, for clients to pin: %x", privkey.PubKey().SerializeCompressed())
 
-	// We don't need to do anything fancy here either because the noise protocol
-	// is built in to the listener as well.
-	go noiseRPCServer.Accept(rpc1.listener)
+	go rpc1.acceptSessions()
 	doneChan <- true
 	close(doneChan)
 	return
 }
 
+// acceptSessions accepts noise connections and serves each one with an RPC server whose
+// handlers are bound to the remote pubkey of the connection. Unlike rpc.Accept this keeps going
+// if a handshake fails. This should be run in a goroutine.
+func (rpc1 *OpencxRPCCaller) acceptSessions() {
+	for {
+		conn, err := rpc1.listener.Accept()
+		if err != nil {
+			if rpc1.stopped() {
+				return
+			}
+			logging.Warnf("Error accepting noise connection, continuing: %s", err)
+			continue
+		}
+
+		noiseConn, ok := conn.(*cxnoise.Conn)
+		if !ok {
+			logging.Warnf("Connection is not a noise connection, closing")
+			conn.Close()
+			continue
+		}
+
+		sessionServer := rpc.NewServer()
+		sessionCaller := &OpencxRPC{
+			Server:        rpc1.caller.Server,
+			sessionPubkey: noiseConn.RemotePub(),
+			limits:        rpc1.caller.limits,
+		}
+		if err = sessionServer.Register(sessionCaller); err != nil {
+			logging.Errorf("Error registering RPC Interface for session, closing: %s", err)
+			conn.Close()
+			continue
+		}
+
+		go rpc1.caller.limits.ServeConn(sessionServer, conn)
+	}
+}
+
 // RPCListen is a synchronous version of RPCListenAsync
 func (rpc1 *OpencxRPCCaller) RPCListen(host string, port uint16) (err error) {
 
@@ -112,13 +174,66 @@ func (rpc1 *OpencxRPCCaller) RPCListenAsync(doneChan chan bool, errChan chan err
 	}
 	logging.Infof("Running RPC server on %s\n", rpc1.listener.Addr().String())
 
-	go rpc.Accept(rpc1.listener)
+	go rpc1.caller.limits.Accept(rpc.DefaultServer, rpc1.listener)
 	doneChan <- true
 	close(doneChan)
 	return
 }
 
-// WaitUntilDead waits until the Stop() method is called
+// stopped returns true if Stop has been called
+func (rpc1 *OpencxRPCCaller) stopped() (isStopped bool) {
+	rpc1.stopMtx.Lock()
+	isStopped = rpc1.isStopped
+	rpc1.stopMtx.Unlock()
+	return
+}
+
+// JSONRPCListen is a synchronous version of JSONRPCListenAsync
+func (rpc1 *OpencxRPCCaller) JSONRPCListen(host string, port uint16) (err error) {
+
+	doneChan := make(chan bool, 1)
+	errChan := make(chan error, 1)
+	go rpc1.JSONRPCListenAsync(doneChan, errChan, host, port)
+	select {
+	case err = <-errChan:
+	case <-doneChan:
+	}
+
+	return
+}
+
+// JSONRPCListenAsync serves the RPC API as JSON-RPC 2.0 over HTTP and WebSocket on host and port.
+// There's no noise session, so commands need signed requests like with RPCListen.
+func (rpc1 *OpencxRPCCaller) JSONRPCListenAsync(doneChan chan bool, errChan chan error, host string, port uint16) {
+	var err error
+	if rpc1.caller == nil {
+		errChan <- fmt.Errorf("Error, rpc caller cannot be nil, please create caller correctly")
+		close(errChan)
+		return
+	}
+
+	logging.Infof("Registering JSON-RPC API...")
+	if rpc1.jsonGateway, err = cxjsonrpc.NewGateway(rpc1.caller); err != nil {
+		errChan <- fmt.Errorf("Error registering JSON-RPC Interface: %s", err)
+		close(errChan)
+		return
+	}
+	rpc1.jsonGateway.SetLimits(rpc1.caller.limits)
+
+	logging.Infof("Starting JSON-RPC Server")
+	if err = rpc1.jsonGateway.Listen(host, port); err != nil {
+		errChan <- fmt.Errorf("Error listening for JSONRPCListenAsync: %s", err)
+		close(errChan)
+		return
+	}
+	logging.Infof("Running JSON-RPC server on %s\n", rpc1.jsonGateway.Addr().String())
+
+	doneChan <- true
+	close(doneChan)
+	return
+}
+
+// WaitUntilDead waits until the Stop() or Shutdown() method is called
 func (rpc1 *OpencxRPCCaller) WaitUntilDead() {
 	dedchan := make(chan bool, 1)
 	rpc1.killers = append(rpc1.killers, dedchan)
@@ -128,16 +243,60 @@ func (rpc1 *OpencxRPCCaller) WaitUntilDead() {
 
 // Stop closes the RPC listener and notifies those from WaitUntilDead
 func (rpc1 *OpencxRPCCaller) Stop() (err error) {
-	if rpc1.listener == nil {
+	if rpc1.listener == nil && rpc1.jsonGateway == nil {
 		err = fmt.Errorf("Error, cannot stop a listener that doesn't exist")
 		return
 	}
 	logging.Infof("Stopping RPC!!")
-	if err = rpc1.listener.Close(); err != nil {
-		err = fmt.Errorf("Error closing listener: %s", err)
-		return
+	rpc1.stopMtx.Lock()
+	rpc1.isStopped = true
+	rpc1.stopMtx.Unlock()
+	if rpc1.listener != nil {
+		if err = rpc1.listener.Close(); err != nil {
+			err = fmt.Errorf("Error closing listener: %s", err)
+			return
+		}
+	}
+	if rpc1.jsonGateway != nil {
+		if err = rpc1.jsonGateway.Close(); err != nil {
+			err = fmt.Errorf("Error closing JSON-RPC gateway: %s", err)
+			return
+		}
+	}
+	rpc1.notifyKillers()
+	return
+}
+
+// Shutdown closes the RPC listeners so no new connections come in, gives the JSON-RPC requests
+// being served half of the time left before deadline to finish, and shuts down the server so
+// in-flight matching and settlement finish and the DB handlers are closed. Those from
+// WaitUntilDead are notified even if something fails, and the first error is returned.
+func (rpc1 *OpencxRPCCaller) Shutdown(deadline time.Time) (err error) {
+	logging.Infof("Shutting down RPC...")
+	rpc1.stopMtx.Lock()
+	rpc1.isStopped = true
+	rpc1.stopMtx.Unlock()
+	if rpc1.listener != nil {
+		if closeErr := rpc1.listener.Close(); closeErr != nil {
+			err = fmt.Errorf("Error closing listener: %s", closeErr)
+		}
+	}
+	if rpc1.jsonGateway != nil {
+		// the rest of the time is left for the server to drain
+		if closeErr := rpc1.jsonGateway.Shutdown(time.Until(deadline) / 2); closeErr != nil && err == nil {
+			err = fmt.Errorf("Error shutting down JSON-RPC gateway: %s", closeErr)
+		}
+	}
+	if serverErr := rpc1.caller.Server.Shutdown(); serverErr != nil && err == nil {
+		err = fmt.Errorf("Error shutting down server: %s", serverErr)
 	}
-	// kill the guy waiting
+
+	rpc1.notifyKillers()
+	return
+}
+
+// notifyKillers notifies those from WaitUntilDead
+func (rpc1 *OpencxRPCCaller) notifyKillers() {
 	for _, killer := range rpc1.killers {
 		// send the signals, but even if they don't send, close the channel
 		select {
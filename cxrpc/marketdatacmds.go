@@ -0,0 +1,76 @@
+package cxrpc
+
+import (
+	"time"
+
+	"github.com/mit-dci/opencx/cxserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SubscribeMarketDataArgs holds the args for the SubscribeMarketData command
+type SubscribeMarketDataArgs struct {
+	TradingPair *match.Pair
+}
+
+// SubscribeMarketDataReply holds the reply for the SubscribeMarketData command. Seq is the last
+// market event included in the snapshot, so the subscriber polls for events after it, in Epoch.
+type SubscribeMarketDataReply struct {
+	Orders []*match.LimitOrderIDPair
+	Epoch  uint64
+	Seq    uint64
+	match.ErrorReply
+}
+
+// SubscribeMarketData returns a snapshot of the orderbook to start a market data subscription from
+func (cl *OpencxRPC) SubscribeMarketData(args SubscribeMarketDataArgs, reply *SubscribeMarketDataReply) (err error) {
+	defer reply.SetError(&err)
+
+	if args.TradingPair == nil {
+		err = match.Errorf(match.ErrUnknownPair, "Trading pair cannot be nil for SubscribeMarketData RPC command")
+		return
+	}
+
+	if reply.Orders, reply.Epoch, reply.Seq, err = cl.Server.MarketSnapshot(args.TradingPair); err != nil {
+		err = match.Wrapf(err, "Error getting market snapshot for SubscribeMarketData RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// PollMarketDataArgs holds the args for the PollMarketData command. Epoch is the one from the
+// snapshot, and Wait is how long to wait for new events if there aren't any after AfterSeq yet.
+type PollMarketDataArgs struct {
+	TradingPair *match.Pair
+	Epoch       uint64
+	AfterSeq    uint64
+	Wait        time.Duration
+}
+
+// PollMarketDataReply holds the reply for the PollMarketData command. If Gap is true, the events
+// after AfterSeq were missed, or Epoch isn't the one from the snapshot because the server
+// restarted, and the subscriber should subscribe again for a new snapshot.
+type PollMarketDataReply struct {
+	Events []*cxserver.MarketEvent
+	Gap    bool
+	Epoch  uint64
+	match.ErrorReply
+}
+
+// PollMarketData returns the book deltas and trades for a pair after a seq, waiting for them if
+// there aren't any yet
+func (cl *OpencxRPC) PollMarketData(args PollMarketDataArgs, reply *PollMarketDataReply) (err error) {
+	defer reply.SetError(&err)
+
+	if args.TradingPair == nil {
+		err = match.Errorf(match.ErrUnknownPair, "Trading pair cannot be nil for PollMarketData RPC command")
+		return
+	}
+
+	if reply.Events, reply.Gap, reply.Epoch, err = cl.Server.MarketEvents(args.TradingPair, args.Epoch, args.AfterSeq, args.Wait); err != nil {
+		err = match.Wrapf(err, "Error getting market events for PollMarketData RPC command: %s", err)
+		return
+	}
+
+	return
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxnoise"
+	"github.com/mit-dci/opencx/match"
 )
 
 // OpencxClient is an interface defining the methods a client should implement.
@@ -26,13 +27,20 @@ type OpencxRPCClient struct {
 
 // OpencxNoiseClient is an authenticated RPC Client for the opencx Server
 type OpencxNoiseClient struct {
-	Conn *rpc.Client
-	key  *koblitz.PrivateKey
+	Conn      *rpc.Client
+	key       *koblitz.PrivateKey
+	serverPub *koblitz.PublicKey
 }
 
-// Call calls the servicemethod with name string, args args, and reply reply
-func (cl *OpencxRPCClient) Call(serviceMethod string, args interface{}, reply interface{}) error {
-	return cl.Conn.Call(serviceMethod, args, reply)
+// Call calls the servicemethod with name string, args args, and reply reply. Errors with a code
+// come back in the reply, and are returned with their code.
+func (cl *OpencxRPCClient) Call(serviceMethod string, args interface{}, reply interface{}) (err error) {
+	if err = cl.Conn.Call(serviceMethod, args, reply); err != nil {
+		return
+	}
+
+	err = match.ReplyError(reply)
+	return
 }
 
 // SetupConnection creates a new RPC client
@@ -47,7 +55,8 @@ func (cl *OpencxRPCClient) SetupConnection(server string, port uint16) (err erro
 	return
 }
 
-// Call calls the servicemethod with name string, args args, and reply reply
+// Call calls the servicemethod with name string, args args, and reply reply. Errors with a code
+// come back in the reply, and are returned with their code.
 func (cl *OpencxNoiseClient) Call(serviceMethod string, args interface{}, reply interface{}) (err error) {
 
 	// Create new client because the server is a rpc newserver
@@ -57,6 +66,7 @@ func (cl *OpencxNoiseClient) Call(serviceMethod string, args interface{}, reply
 		return
 	}
 
+	err = match.ReplyError(reply)
 	return
 }
 
@@ -70,6 +80,18 @@ func (cl *OpencxNoiseClient) SetKey(privkey *koblitz.PrivateKey) (err error) {
 	return
 }
 
+// SetServerPubkey pins the server's pubkey, so the noise client connects with a noise_XK handshake
+// that fails unless the server has the key. Without it the server sends its pubkey in a noise_XX
+// handshake.
+func (cl *OpencxNoiseClient) SetServerPubkey(pubkey *koblitz.PublicKey) (err error) {
+	if pubkey == nil {
+		err = fmt.Errorf("Cannot set nil server pubkey")
+		return
+	}
+	cl.serverPub = pubkey
+	return
+}
+
 // SetupConnection creates a new RPC Noise client
 func (cl *OpencxNoiseClient) SetupConnection(server string, port uint16) (err error) {
 
@@ -82,8 +104,14 @@ func (cl *OpencxNoiseClient) SetupConnection(server string, port uint16) (err er
 
 	// Dial a connection to the server
 	var clientConn *cxnoise.Conn
-	if clientConn, err = cxnoise.Dial(cl.key, serverAddr, []byte("opencx"), net.Dial); err != nil {
-		return
+	if cl.serverPub != nil {
+		if clientConn, err = cxnoise.DialXK(cl.key, cl.serverPub, serverAddr, []byte("opencx"), net.Dial); err != nil {
+			return
+		}
+	} else {
+		if clientConn, err = cxnoise.Dial(cl.key, serverAddr, []byte("opencx"), net.Dial); err != nil {
+			return
+		}
 	}
 
 	cl.Conn = rpc.NewClient(clientConn)
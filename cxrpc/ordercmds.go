@@ -4,40 +4,53 @@ import (
 	"fmt"
 
 	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
-	"golang.org/x/crypto/sha3"
 )
 
 // SubmitOrderArgs holds the args for the submitorder command
 type SubmitOrderArgs struct {
 	Order *match.LimitOrder
-	// Signature is a compact signature so we can do pubkey recovery
-	Signature []byte
+	// Request is signed by the order's pubkey
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a submitorder request commits to
+func (args *SubmitOrderArgs) ArgsHash() (argsHash [32]byte, err error) {
+	var orderBytes []byte
+	if orderBytes, err = args.Order.Serialize(); err != nil {
+		err = fmt.Errorf("Error serializing order for args hash: %s", err)
+		return
+	}
+	argsHash = cxauth.HashArgs(orderBytes)
+	return
 }
 
 // SubmitOrderReply holds the reply for the submitorder command
 type SubmitOrderReply struct {
 	OrderID *match.OrderID
+	match.ErrorReply
 }
 
 // SubmitOrder submits an order to the order book or throws an error
 func (cl *OpencxRPC) SubmitOrder(args SubmitOrderArgs, reply *SubmitOrderReply) (err error) {
+	defer reply.SetError(&err)
 
-	var orderBytes []byte
-	if orderBytes, err = args.Order.Serialize(); err != nil {
-		err = fmt.Errorf("Error serializing order for SubmitOrder RPC command: %s", err)
+	if args.Order == nil {
+		err = match.Errorf(match.ErrInvalidOrder, "Order cannot be nil for SubmitOrder RPC command")
 		return
 	}
 
-	// hash order.
-	sha3 := sha3.New256()
-	sha3.Write(orderBytes)
-	e := sha3.Sum(nil)
+	var argsHash [32]byte
+	if argsHash, err = args.ArgsHash(); err != nil {
+		err = fmt.Errorf("Error hashing args for SubmitOrder RPC command: %s", err)
+		return
+	}
 
 	var sigPubKey *koblitz.PublicKey
-	if sigPubKey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
-		err = fmt.Errorf("Error verifying order, invalid signature: \n%s", err)
+	if sigPubKey, err = cl.authorize("OpencxRPC.SubmitOrder", argsHash, args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for SubmitOrder RPC command: %s", err)
 		return
 	}
 
@@ -49,16 +62,12 @@ func (cl *OpencxRPC) SubmitOrder(args SubmitOrderArgs, reply *SubmitOrderReply)
 	}
 
 	if !sigPubKey.IsEqual(orderPubkey) {
-		err = fmt.Errorf("Pubkey used with signature not equal to the one passed")
+		err = match.Errorf(match.ErrUnauthorized, "Pubkey used with signature not equal to the one passed")
 		return
 	}
 
-	// possible replay attack: if we're using the same pubkey for two exchanges and this is like a feature on the exchange, then an exchange could have you
-	// place an order on their exchange, even with a nonce, and then send it over to the other exchange. When you submit an order on one exchange,
-	// you essentially submit an order to all of them. But like once we have channels for orders then this isn't a thing anymore because the channel
-	// tx's are signed and funding stuff is published on chain
 	if reply.OrderID, err = cl.Server.PlaceOrder(args.Order); err != nil {
-		err = fmt.Errorf("Error placing order for PlaceOrder RPC command: %s", err)
+		err = match.Wrapf(err, "Error placing order for PlaceOrder RPC command: %s", err)
 		return
 	}
 
@@ -81,13 +90,15 @@ type ViewOrderBookArgs struct {
 // ViewOrderBookReply holds the reply for the vieworderbook command
 type ViewOrderBookReply struct {
 	Orderbook map[float64][]*match.LimitOrderIDPair
+	match.ErrorReply
 }
 
 // ViewOrderBook handles the vieworderbook command
 func (cl *OpencxRPC) ViewOrderBook(args ViewOrderBookArgs, reply *ViewOrderBookReply) (err error) {
+	defer reply.SetError(&err)
 
 	if reply.Orderbook, err = cl.Server.ViewOrderbook(args.TradingPair); err != nil {
-		err = fmt.Errorf("Error with server ViewOrderbook for ViewOrderbook RPC command: %s", err)
+		err = match.Wrapf(err, "Error with server ViewOrderbook for ViewOrderbook RPC command: %s", err)
 		return
 	}
 
@@ -102,56 +113,57 @@ type GetPriceArgs struct {
 // GetPriceReply holds the reply for the GetPrice command
 type GetPriceReply struct {
 	Price float64
+	match.ErrorReply
 }
 
 // GetPrice returns the price for the specified asset
 func (cl *OpencxRPC) GetPrice(args GetPriceArgs, reply *GetPriceReply) (err error) {
+	defer reply.SetError(&err)
 
 	if reply.Price, err = cl.Server.GetPrice(args.TradingPair); err != nil {
-		err = fmt.Errorf("Error calculating price for GetPrice RPC command: %s", err)
+		err = match.Wrapf(err, "Error calculating price for GetPrice RPC command: %s", err)
 		return
 	}
 
 	return
 }
 
-// TODO: any man in the middle of a GetOrder communication can replay the same thing as a Cancel communication.
-
 // CancelOrderArgs holds the args for the CancelOrder command
 type CancelOrderArgs struct {
-	OrderID   string
-	Signature []byte
+	OrderID string
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a cancelorder request commits to
+func (args *CancelOrderArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs([]byte(args.OrderID))
+	return
 }
 
 // CancelOrderReply holds the args for the CancelOrder command
 type CancelOrderReply struct {
-	// empty
+	match.ErrorReply
 }
 
 // CancelOrder cancels the order
 func (cl *OpencxRPC) CancelOrder(args CancelOrderArgs, reply *CancelOrderReply) (err error) {
+	defer reply.SetError(&err)
 
-	// hash order.
-	sha3 := sha3.New256()
-	sha3.Write([]byte(args.OrderID))
-	e := sha3.Sum(nil)
-
-	logging.Infof("Checking cancel signature")
 	var sigPubKey *koblitz.PublicKey
-	if sigPubKey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
-		err = fmt.Errorf("Error verifying cancel, invalid signature: \n%s", err)
+	if sigPubKey, err = cl.authorize("OpencxRPC.CancelOrder", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for CancelOrder RPC command: %s", err)
 		return
 	}
 
 	var unmarshalledOrderID *match.OrderID = new(match.OrderID)
 	if err = unmarshalledOrderID.UnmarshalText([]byte(args.OrderID)); err != nil {
-		err = fmt.Errorf("Error unmarshalling text for Order ID in CancelOrder RPC: %s", err)
+		err = match.Errorf(match.ErrUnknownOrder, "Error unmarshalling text for Order ID in CancelOrder RPC: %s", err)
 		return
 	}
 
 	var orderPair *match.LimitOrderIDPair
 	if orderPair, err = cl.Server.GetOrder(unmarshalledOrderID); err != nil {
-		err = fmt.Errorf("Error calling GetOrder in CancelOrder RPC: %s", err)
+		err = match.Wrapf(err, "Error calling GetOrder in CancelOrder RPC: %s", err)
 		return
 	}
 
@@ -163,7 +175,7 @@ func (cl *OpencxRPC) CancelOrder(args CancelOrderArgs, reply *CancelOrderReply)
 	}
 
 	if !sigPubKey.IsEqual(orderPubKey) {
-		err = fmt.Errorf("Pubkey used with signature not equal to the one passed")
+		err = match.Errorf(match.ErrNotOwner, "Pubkey used with signature does not own the order")
 		return
 	}
 
@@ -174,7 +186,7 @@ func (cl *OpencxRPC) CancelOrder(args CancelOrderArgs, reply *CancelOrderReply)
 	}
 
 	if err = cl.Server.CancelOrder(orderPair); err != nil {
-		err = fmt.Errorf("Error cancelling order for CancelOrder RPC command: %s", err)
+		err = match.Wrapf(err, "Error cancelling order for CancelOrder RPC command: %s", err)
 		return
 	}
 
@@ -189,10 +201,13 @@ type GetPairsArgs struct {
 // GetPairsReply holds the reply for the GetPairs command
 type GetPairsReply struct {
 	PairList []string
+	match.ErrorReply
 }
 
 // GetPairs gets all the pairs as nice strings
 func (cl *OpencxRPC) GetPairs(args GetPairsArgs, reply *GetPairsReply) (err error) {
+	defer reply.SetError(&err)
+
 	// just go through all the pairs and prettily print them
 	for _, pair := range cl.Server.GetPairs() {
 		reply.PairList = append(reply.PairList, pair.PrettyString())
@@ -203,37 +218,40 @@ func (cl *OpencxRPC) GetPairs(args GetPairsArgs, reply *GetPairsReply) (err erro
 
 // GetOrderArgs holds the args for the GetOrder command
 type GetOrderArgs struct {
-	OrderID   string
-	Signature []byte
+	OrderID string
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a getorder request commits to
+func (args *GetOrderArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs([]byte(args.OrderID))
+	return
 }
 
 // GetOrderReply holds the reply for the GetOrder command
 type GetOrderReply struct {
 	Order *match.LimitOrderIDPair
+	match.ErrorReply
 }
 
 // GetOrder gets an order based on orderID
 func (cl *OpencxRPC) GetOrder(args GetOrderArgs, reply *GetOrderReply) (err error) {
-	// hash order id.
-	sha3 := sha3.New256()
-	sha3.Write([]byte(args.OrderID))
-	e := sha3.Sum(nil)
+	defer reply.SetError(&err)
 
-	logging.Infof("Checking getorder signature")
 	var sigPubKey *koblitz.PublicKey
-	if sigPubKey, _, err = koblitz.RecoverCompact(koblitz.S256(), args.Signature, e); err != nil {
-		err = fmt.Errorf("Error verifying getorder, invalid signature: \n%s", err)
+	if sigPubKey, err = cl.authorize("OpencxRPC.GetOrder", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for GetOrder RPC command: %s", err)
 		return
 	}
 
 	var unmarshalledOrderID *match.OrderID = new(match.OrderID)
 	if err = unmarshalledOrderID.UnmarshalText([]byte(args.OrderID)); err != nil {
-		err = fmt.Errorf("Could not unmarshal order ID for GetOrder command: %s", err)
+		err = match.Errorf(match.ErrUnknownOrder, "Could not unmarshal order ID for GetOrder command: %s", err)
 		return
 	}
 
 	if reply.Order, err = cl.Server.GetOrder(unmarshalledOrderID); err != nil {
-		err = fmt.Errorf("Error getting order from server for GetOrder RPC command: %s", err)
+		err = match.Wrapf(err, "Error getting order from server for GetOrder RPC command: %s", err)
 		return
 	}
 
@@ -245,7 +263,7 @@ func (cl *OpencxRPC) GetOrder(args GetOrderArgs, reply *GetOrderReply) (err erro
 	}
 
 	if !sigPubKey.IsEqual(orderPubKey) {
-		err = fmt.Errorf("Pubkey used with signature not equal to the one passed")
+		err = match.Errorf(match.ErrNotOwner, "Pubkey used with signature does not own the order")
 		return
 	}
 
@@ -254,18 +272,28 @@ func (cl *OpencxRPC) GetOrder(args GetOrderArgs, reply *GetOrderReply) (err erro
 
 // GetOrdersForPubkeyArgs holds the args for the GetOrdersForPubkey command
 type GetOrdersForPubkeyArgs struct {
-	Signature []byte
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a getordersforpubkey request commits to
+func (args *GetOrdersForPubkeyArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs()
+	return
 }
 
 // GetOrdersForPubkeyReply holds the reply for the GetOrdersForPubkey command
 type GetOrdersForPubkeyReply struct {
 	Orders []*match.LimitOrderIDPair
+	match.ErrorReply
 }
 
-// GetOrdersForPubkey gets the orders for the pubkey which has signed the getOrdersString
+// GetOrdersForPubkey gets the orders for the pubkey which has signed the request
 func (cl *OpencxRPC) GetOrdersForPubkey(args GetOrdersForPubkeyArgs, reply *GetOrdersForPubkeyReply) (err error) {
+	defer reply.SetError(&err)
+
 	var pubkey *koblitz.PublicKey
-	if pubkey, err = cl.Server.GetOrdersStringVerify(args.Signature); err != nil {
+	if pubkey, err = cl.authorize("OpencxRPC.GetOrdersForPubkey", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for GetOrdersForPubkey RPC command: %s", err)
 		return
 	}
 
@@ -0,0 +1,146 @@
+package cxrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	util "github.com/mit-dci/opencx/chainutils"
+	"github.com/mit-dci/opencx/crypto/provisions"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/match"
+)
+
+// AnonKey is a key that the exchange doesn't own, with its balance on chain, used to hide which
+// keys the exchange does own in a solvency proof.
+type AnonKey struct {
+	PubKey  [33]byte
+	Balance uint64
+}
+
+// ProveSolvencyArgs holds the args for the provesolvency command
+type ProveSolvencyArgs struct {
+	Asset    string
+	AnonKeys []AnonKey
+	// Request is signed by the admin key
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a provesolvency request commits to, including every anon key
+func (args *ProveSolvencyArgs) ArgsHash() (argsHash [32]byte) {
+	parts := [][]byte{[]byte(args.Asset)}
+	for _, anonKey := range args.AnonKeys {
+		var balanceBytes [8]byte
+		binary.BigEndian.PutUint64(balanceBytes[:], anonKey.Balance)
+		parts = append(parts, anonKey.PubKey[:], balanceBytes[:])
+	}
+	argsHash = cxauth.HashArgs(parts...)
+	return
+}
+
+// ProveSolvencyReply holds the reply for the provesolvency command
+type ProveSolvencyReply struct {
+	Proof *provisions.SolvencyProof
+	match.ErrorReply
+}
+
+// ProveSolvency creates a proof of solvency for an asset. The signature must be from the admin key.
+func (cl *OpencxRPC) ProveSolvency(args ProveSolvencyArgs, reply *ProveSolvencyReply) (err error) {
+	defer reply.SetError(&err)
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if err = cl.Server.AdminCommandVerify("OpencxRPC.ProveSolvency", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying admin command for ProveSolvency RPC command: %s", err)
+		return
+	}
+
+	anonKeys := make(map[[33]byte]uint64)
+	for _, anonKey := range args.AnonKeys {
+		anonKeys[anonKey.PubKey] = anonKey.Balance
+	}
+
+	if reply.Proof, err = cl.Server.ProveSolvency(param, anonKeys); err != nil {
+		err = match.Wrapf(err, "Error proving solvency for ProveSolvency RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetSolvencyProofArgs holds the args for the getsolvencyproof command
+type GetSolvencyProofArgs struct {
+	Asset string
+}
+
+// GetSolvencyProofReply holds the reply for the getsolvencyproof command
+type GetSolvencyProofReply struct {
+	Proof *provisions.SolvencyProof
+	match.ErrorReply
+}
+
+// GetSolvencyProof gets the latest proof of solvency for an asset
+func (cl *OpencxRPC) GetSolvencyProof(args GetSolvencyProofArgs, reply *GetSolvencyProofReply) (err error) {
+	defer reply.SetError(&err)
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Proof, err = cl.Server.GetSolvencyProof(param); err != nil {
+		err = fmt.Errorf("Error getting solvency proof for GetSolvencyProof RPC command: %s", err)
+		return
+	}
+
+	return
+}
+
+// GetSolvencyOpeningArgs holds the args for the getsolvencyopening command
+type GetSolvencyOpeningArgs struct {
+	Asset   string
+	Request *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a getsolvencyopening request commits to
+func (args *GetSolvencyOpeningArgs) ArgsHash() (argsHash [32]byte) {
+	argsHash = cxauth.HashArgs([]byte(args.Asset))
+	return
+}
+
+// GetSolvencyOpeningReply holds the reply for the getsolvencyopening command
+type GetSolvencyOpeningReply struct {
+	Opening *provisions.CustomerOpening
+	match.ErrorReply
+}
+
+// GetSolvencyOpening gets the opening of the signer's balance commitment in the latest proof of
+// solvency for an asset.
+func (cl *OpencxRPC) GetSolvencyOpening(args GetSolvencyOpeningArgs, reply *GetSolvencyOpeningReply) (err error) {
+	defer reply.SetError(&err)
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.authorize("OpencxRPC.GetSolvencyOpening", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for GetSolvencyOpening RPC command: %s", err)
+		return
+	}
+
+	var param *coinparam.Params
+	if param, err = util.GetParamFromName(args.Asset); err != nil {
+		err = match.Errorf(match.ErrUnknownAsset, "Error getting param from name for asset: %s", err)
+		return
+	}
+
+	if reply.Opening, err = cl.Server.GetSolvencyOpening(pubkey, param); err != nil {
+		err = match.Wrapf(err, "Error getting solvency opening for GetSolvencyOpening RPC command: %s", err)
+		return
+	}
+
+	return
+}
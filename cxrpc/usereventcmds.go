@@ -0,0 +1,62 @@
+package cxrpc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/cxserver"
+	"github.com/mit-dci/opencx/match"
+)
+
+// PollUserEventsArgs holds the args for the PollUserEvents command. AfterEpoch and AfterSeq are
+// the epoch and seq of the last event the client has seen, or 0 if it hasn't seen any, and Wait is
+// how long to wait for new events if there aren't any yet.
+type PollUserEventsArgs struct {
+	AfterEpoch uint64
+	AfterSeq   uint64
+	Wait       time.Duration
+	Request    *cxauth.SignedRequest
+}
+
+// ArgsHash hashes the args that a polluserevents request commits to
+func (args *PollUserEventsArgs) ArgsHash() (argsHash [32]byte) {
+	var afterEpochBytes, afterSeqBytes, waitBytes [8]byte
+	binary.BigEndian.PutUint64(afterEpochBytes[:], args.AfterEpoch)
+	binary.BigEndian.PutUint64(afterSeqBytes[:], args.AfterSeq)
+	binary.BigEndian.PutUint64(waitBytes[:], uint64(args.Wait))
+	argsHash = cxauth.HashArgs(afterEpochBytes[:], afterSeqBytes[:], waitBytes[:])
+	return
+}
+
+// PollUserEventsReply holds the reply for the PollUserEvents command. If Gap is true, events after
+// AfterSeq were missed, or the server's feed has a new epoch, so the client should check its
+// orders and balances and continue from Epoch and LastSeq.
+type PollUserEventsReply struct {
+	Events  []*cxserver.UserEvent
+	Gap     bool
+	Epoch   uint64
+	LastSeq uint64
+	match.ErrorReply
+}
+
+// PollUserEvents returns the fills, cancels, deposits and withdrawals for the caller's pubkey after
+// a seq, waiting for them if there aren't any yet
+func (cl *OpencxRPC) PollUserEvents(args PollUserEventsArgs, reply *PollUserEventsReply) (err error) {
+	defer reply.SetError(&err)
+
+	var pubkey *koblitz.PublicKey
+	if pubkey, err = cl.authorize("OpencxRPC.PollUserEvents", args.ArgsHash(), args.Request); err != nil {
+		err = match.Wrapf(err, "Error verifying request for PollUserEvents RPC command: %s", err)
+		return
+	}
+
+	if reply.Events, reply.Gap, reply.Epoch, reply.LastSeq, err = cl.Server.UserEvents(pubkey, args.AfterEpoch, args.AfterSeq, args.Wait); err != nil {
+		err = fmt.Errorf("Error getting user events for PollUserEvents RPC command: %s", err)
+		return
+	}
+
+	return
+}
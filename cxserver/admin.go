@@ -0,0 +1,46 @@
+package cxserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/match"
+)
+
+// SetAdminPubkey sets the public key that is allowed to run admin commands, like proving solvency.
+func (server *OpencxServer) SetAdminPubkey(pubkey *koblitz.PublicKey) (err error) {
+	if pubkey == nil {
+		err = fmt.Errorf("Cannot set nil admin pubkey")
+		return
+	}
+	server.dbLock.Lock()
+	server.adminPubkey = pubkey
+	server.dbLock.Unlock()
+	return
+}
+
+// AdminCommandVerify verifies that a signed request for an admin command was made by the admin key.
+func (server *OpencxServer) AdminCommandVerify(method string, argsHash [32]byte, req *cxauth.SignedRequest) (err error) {
+	server.dbLock.Lock()
+	adminPubkey := server.adminPubkey
+	server.dbLock.Unlock()
+
+	if adminPubkey == nil {
+		err = fmt.Errorf("No admin key set, admin commands are disabled")
+		return
+	}
+
+	var sigPubkey *koblitz.PublicKey
+	if sigPubkey, err = server.VerifyRequest(method, argsHash, req); err != nil {
+		err = match.Wrapf(err, "Error verifying admin command: %s", err)
+		return
+	}
+
+	if !sigPubkey.IsEqual(adminPubkey) {
+		err = fmt.Errorf("Admin command signed by %x, which is not the admin key", sigPubkey.SerializeCompressed())
+		return
+	}
+
+	return
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/mit-dci/lit/coinparam"
 	"github.com/mit-dci/lit/crypto/koblitz"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/match"
 )
 
 // GetBalance gets the balance for a specific public key and coin.
@@ -18,7 +19,7 @@ func (server *OpencxServer) GetBalance(pubkey *koblitz.PublicKey, coin *coinpara
 	var currSettlementStore cxdb.SettlementStore
 	var ok bool
 	if currSettlementStore, ok = server.SettlementStores[coin]; !ok {
-		err = fmt.Errorf("Cannot find the settlement store for GetBalance")
+		err = match.Errorf(match.ErrUnknownAsset, "Cannot find the settlement store for %s for GetBalance", coin.Name)
 		server.dbLock.Unlock()
 		return
 	}
@@ -132,7 +132,12 @@ func (server *OpencxServer) CreditUser(pubkey *koblitz.PublicKey, amount uint64,
 			return
 		}
 	} else {
-		err = fmt.Errorf("Error, invalid settlement exec for CreditUser")
+		// not having enough is only one way the exec can be invalid, so only say so if it's why
+		if balance, balanceErr := currSettleStore.GetBalance(pubkey); balanceErr == nil && balance < amount {
+			err = match.Errorf(match.ErrInsufficientFunds, "Not enough %s to take %d for CreditUser, balance is %d", param.Name, amount, balance)
+		} else {
+			err = fmt.Errorf("Error, invalid settlement exec for CreditUser")
+		}
 		server.dbLock.Unlock()
 		return
 	}
@@ -74,3 +74,20 @@ func (server *OpencxServer) GetDepositAddress(pubkey *koblitz.PublicKey, coin *c
 
 	return
 }
+
+// IsRegistered returns true if the pubkey has registered, which gives it a deposit address for
+// every coin
+func (server *OpencxServer) IsRegistered(pubkey *koblitz.PublicKey) (registered bool) {
+
+	server.dbLock.Lock()
+	for _, depositStore := range server.DepositStores {
+		if _, err := depositStore.GetDepositAddress(pubkey); err != nil {
+			server.dbLock.Unlock()
+			return
+		}
+	}
+	registered = len(server.DepositStores) != 0
+	server.dbLock.Unlock()
+
+	return
+}
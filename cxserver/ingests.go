@@ -190,6 +190,10 @@ func (server *OpencxServer) updateDepositsAtHeight(deposits []match.Deposit, hei
 		server.dbLock.Unlock()
 		return
 	}
+
+	for _, setExec := range depositExecs {
+		server.publishDeposit(setExec)
+	}
 	server.dbLock.Unlock()
 	return
 }
@@ -0,0 +1,124 @@
+package cxserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/crypto/merklesum"
+	"github.com/mit-dci/opencx/logging"
+)
+
+// publishedLiabilities is a merkle sum tree over what the exchange owes each user for a coin, and
+// when it was made
+type publishedLiabilities struct {
+	tree      *merklesum.Tree
+	published time.Time
+}
+
+// PublishLiabilityRoots creates a merkle sum tree over what the exchange owes each user for each
+// coin, so users can get proofs that they are included in the published total. Each leaf counts
+// funds held in open orders, bonds and pending withdrawals, not just the readable balance.
+func (server *OpencxServer) PublishLiabilityRoots() (err error) {
+	server.dbLock.Lock()
+	allBalances := make(map[*coinparam.Params]map[[33]byte]uint64)
+	for coin := range server.SettlementStores {
+		var balances map[[33]byte]uint64
+		if balances, err = server.customerLiabilities(coin); err != nil {
+			err = fmt.Errorf("Could not get %s liabilities for PublishLiabilityRoots: %s", coin.Name, err)
+			server.dbLock.Unlock()
+			return
+		}
+		allBalances[coin] = balances
+	}
+	server.dbLock.Unlock()
+
+	for coin, balances := range allBalances {
+		var treeBalances []*merklesum.Balance
+		for pubkey, balance := range balances {
+			userPubkey := pubkey
+			treeBalances = append(treeBalances, &merklesum.Balance{
+				PubKey:  userPubkey[:],
+				Balance: balance,
+			})
+		}
+
+		var tree *merklesum.Tree
+		if tree, err = merklesum.NewTree(treeBalances); err != nil {
+			err = fmt.Errorf("Error creating %s liability tree: %s", coin.Name, err)
+			return
+		}
+
+		server.liabilityMtx.Lock()
+		server.liabilities[coin] = &publishedLiabilities{
+			tree:      tree,
+			published: time.Now(),
+		}
+		server.liabilityMtx.Unlock()
+
+		root := tree.Root()
+		logging.Infof("Published %s liability root %x with total %d over %d users", coin.Name, root.Hash, root.Sum, tree.NumLeaves())
+	}
+
+	return
+}
+
+// PublishLiabilityRootsEvery publishes liability roots now and then on every interval, until quit
+// is closed. This should be run in a goroutine.
+func (server *OpencxServer) PublishLiabilityRootsEvery(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := server.PublishLiabilityRoots(); err != nil {
+			logging.Errorf("Error publishing liability roots: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-quit:
+			logging.Infof("Stopped publishing liability roots")
+			return
+		}
+	}
+}
+
+// GetLiabilityRoot returns the latest published liability root for a coin, the number of users in
+// the tree, and when it was published.
+func (server *OpencxServer) GetLiabilityRoot(coin *coinparam.Params) (root merklesum.Node, numUsers uint64, published time.Time, err error) {
+	server.liabilityMtx.Lock()
+	currLiabilities, found := server.liabilities[coin]
+	if !found {
+		err = fmt.Errorf("No liability root has been published for %s", coin.Name)
+		server.liabilityMtx.Unlock()
+		return
+	}
+	server.liabilityMtx.Unlock()
+
+	root = currLiabilities.tree.Root()
+	numUsers = currLiabilities.tree.NumLeaves()
+	published = currLiabilities.published
+	return
+}
+
+// GetLiabilityProof returns the proof that a user's balance is included in the latest published
+// liability root for a coin, and when that root was published. The root itself isn't returned,
+// users should check the proof against the root everyone else sees.
+func (server *OpencxServer) GetLiabilityProof(pubkey *koblitz.PublicKey, coin *coinparam.Params) (proof *merklesum.InclusionProof, published time.Time, err error) {
+	server.liabilityMtx.Lock()
+	currLiabilities, found := server.liabilities[coin]
+	if !found {
+		err = fmt.Errorf("No liability root has been published for %s", coin.Name)
+		server.liabilityMtx.Unlock()
+		return
+	}
+	server.liabilityMtx.Unlock()
+
+	if proof, err = currLiabilities.tree.Proof(pubkey.SerializeCompressed()); err != nil {
+		err = fmt.Errorf("Error getting liability proof: %s", err)
+		return
+	}
+
+	published = currLiabilities.published
+	return
+}
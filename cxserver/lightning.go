@@ -404,7 +404,7 @@ func (server *OpencxServer) CreateChannel(pubkey *koblitz.PublicKey, initSend in
 
 	if initSend != 0 {
 		if err = server.CreditUser(pubkey, uint64(initSend), params); err != nil {
-			err = fmt.Errorf("Error while crediting user for CreateChannel: %s\n", err)
+			err = match.Wrapf(err, "Error while crediting user for CreateChannel: %s\n", err)
 			return
 		}
 	}
@@ -0,0 +1,206 @@
+package cxserver
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mit-dci/opencx/cxfeed"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+const (
+	// MarketFeedBacklog is how many market events are kept for each pair, for subscribers to
+	// catch up on
+	MarketFeedBacklog = 10000
+	// MaxMarketEvents is the most market events returned by one call to MarketEvents
+	MaxMarketEvents = 1000
+	// MaxMarketEventWait is the longest MarketEvents will wait for new events
+	MaxMarketEventWait = 30 * time.Second
+)
+
+// MarketEventType is what kind of change a market event is
+type MarketEventType string
+
+const (
+	// BookPlace is an order being added to the book
+	BookPlace = MarketEventType("place")
+	// BookExec is an order on the book being filled, partly or completely
+	BookExec = MarketEventType("exec")
+	// BookCancel is an order being removed from the book because it was cancelled
+	BookCancel = MarketEventType("cancel")
+	// Trade is a trade print, one for each resting order that gets matched
+	Trade = MarketEventType("trade")
+)
+
+// MarketEvent is one change to the market for a pair. Book events are deltas to the orderbook, in
+// the same order as the updates to the book. For place and exec the amounts are what's left of the
+// order, and for a trade they're what the resting order traded.
+type MarketEvent struct {
+	Seq        uint64
+	Type       MarketEventType
+	Time       time.Time
+	OrderID    match.OrderID
+	Side       string
+	Price      float64
+	AmountHave uint64
+	AmountWant uint64
+	// Filled is set for an exec that takes the order off the book
+	Filled bool
+}
+
+// initMarketFeeds creates a market event feed for every pair with an orderbook
+func (server *OpencxServer) initMarketFeeds() (err error) {
+	server.marketFeeds = make(map[match.Pair]*cxfeed.Feed)
+	for pair := range server.Orderbooks {
+		if server.marketFeeds[pair], err = cxfeed.NewFeed(MarketFeedBacklog); err != nil {
+			err = fmt.Errorf("Error creating market feed for %s: %s", pair.String(), err)
+			return
+		}
+	}
+	return
+}
+
+// publishMarketEvent adds an event to the feed for a pair. The db lock should be held so events
+// are in the same order as the book updates.
+func (server *OpencxServer) publishMarketEvent(pair match.Pair, event *MarketEvent) {
+	feed, ok := server.marketFeeds[pair]
+	if !ok {
+		logging.Warnf("No market feed for %s, not publishing %s event", pair.String(), event.Type)
+		return
+	}
+	event.Time = time.Now()
+	feed.Publish(event)
+	return
+}
+
+// publishBookPlace publishes an order being added to the book
+func (server *OpencxServer) publishBookPlace(order *match.LimitOrderIDPair) {
+	server.publishMarketEvent(order.Order.TradingPair, &MarketEvent{
+		Type:       BookPlace,
+		OrderID:    *order.OrderID,
+		Side:       order.Order.Side.String(),
+		Price:      order.Price,
+		AmountHave: order.Order.AmountHave,
+		AmountWant: order.Order.AmountWant,
+	})
+	return
+}
+
+// publishBookExec publishes an execution of an order that was on the book before it, and a trade
+// print if the order was resting rather than the one just placed
+func (server *OpencxServer) publishBookExec(before *match.LimitOrderIDPair, orderExec *match.OrderExecution, resting bool) {
+	event := &MarketEvent{
+		Type:    BookExec,
+		OrderID: orderExec.OrderID,
+		Side:    before.Order.Side.String(),
+		Price:   before.Price,
+		Filled:  orderExec.Filled,
+	}
+	if !orderExec.Filled {
+		event.AmountHave = orderExec.NewAmountHave
+		event.AmountWant = orderExec.NewAmountWant
+	}
+	server.publishMarketEvent(before.Order.TradingPair, event)
+
+	if !resting {
+		return
+	}
+	server.publishMarketEvent(before.Order.TradingPair, &MarketEvent{
+		Type:       Trade,
+		OrderID:    orderExec.OrderID,
+		Side:       event.Side,
+		Price:      before.Price,
+		AmountHave: before.Order.AmountHave - event.AmountHave,
+		AmountWant: before.Order.AmountWant - event.AmountWant,
+	})
+	return
+}
+
+// publishBookCancel publishes an order being cancelled off the book
+func (server *OpencxServer) publishBookCancel(order *match.LimitOrderIDPair) {
+	server.publishMarketEvent(order.Order.TradingPair, &MarketEvent{
+		Type:    BookCancel,
+		OrderID: *order.OrderID,
+		Side:    order.Order.Side.String(),
+		Price:   order.Price,
+	})
+	return
+}
+
+// MarketSnapshot returns every order on the book for a pair, sorted by price then time, along with
+// the epoch of the pair's feed and the seq of the last market event the snapshot includes.
+// Subscribers apply the events after seq in that epoch.
+func (server *OpencxServer) MarketSnapshot(pair *match.Pair) (orders []*match.LimitOrderIDPair, epoch uint64, seq uint64, err error) {
+
+	server.dbLock.Lock()
+	var feed *cxfeed.Feed
+	var ok bool
+	if feed, ok = server.marketFeeds[*pair]; !ok {
+		err = match.Errorf(match.ErrUnknownPair, "Could not find market feed for trading pair for MarketSnapshot")
+		server.dbLock.Unlock()
+		return
+	}
+
+	var currOrderbook match.LimitOrderbook
+	if currOrderbook, ok = server.Orderbooks[*pair]; !ok {
+		err = fmt.Errorf("Could not find orderbooks for trading pair for MarketSnapshot")
+		server.dbLock.Unlock()
+		return
+	}
+
+	var book map[float64][]*match.LimitOrderIDPair
+	if book, err = currOrderbook.ViewLimitOrderBook(); err != nil {
+		err = fmt.Errorf("Error viewing limit orderbook for MarketSnapshot: %s", err)
+		server.dbLock.Unlock()
+		return
+	}
+	// events are only published with the db lock held, so this is the seq of the book we have
+	epoch = feed.Epoch()
+	seq = feed.LastSeq()
+	server.dbLock.Unlock()
+
+	for _, priceOrders := range book {
+		orders = append(orders, priceOrders...)
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].Price != orders[j].Price {
+			return orders[i].Price < orders[j].Price
+		}
+		return orders[i].Timestamp.Before(orders[j].Timestamp)
+	})
+
+	return
+}
+
+// MarketEvents returns the market events for a pair after afterSeq in epoch, waiting up to wait
+// for one if there aren't any yet. The wait is capped at MaxMarketEventWait. If gap is true the
+// events after afterSeq are gone, or the epoch isn't the feed's anymore because the server
+// restarted, so the subscriber missed some and should get a new snapshot. feedEpoch is the epoch
+// of the pair's feed, which is the same as epoch unless there's a gap.
+func (server *OpencxServer) MarketEvents(pair *match.Pair, epoch uint64, afterSeq uint64, wait time.Duration) (events []*MarketEvent, gap bool, feedEpoch uint64, err error) {
+	var feed *cxfeed.Feed
+	var ok bool
+	if feed, ok = server.marketFeeds[*pair]; !ok {
+		err = match.Errorf(match.ErrUnknownPair, "Could not find market feed for trading pair for MarketEvents")
+		return
+	}
+
+	if wait > MaxMarketEventWait {
+		wait = MaxMarketEventWait
+	}
+
+	feedEpoch = feed.Epoch()
+	var feedEvents []*cxfeed.Event
+	if feedEvents, gap = feed.Wait(epoch, afterSeq, MaxMarketEvents, wait); gap {
+		return
+	}
+	for _, feedEvent := range feedEvents {
+		event := *feedEvent.Data.(*MarketEvent)
+		event.Seq = feedEvent.Seq
+		events = append(events, &event)
+	}
+
+	return
+}
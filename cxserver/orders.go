@@ -25,23 +25,31 @@ func (server *OpencxServer) GetOrder(orderID *match.OrderID) (order *match.Limit
 		}
 	}
 
-	err = fmt.Errorf("Could not find order with that order ID")
+	err = match.Errorf(match.ErrUnknownOrder, "Could not find order with that order ID")
 	server.dbLock.Unlock()
 	return
 }
 
-// PlaceOrder places an order by first checking if we can credit the user, then calling the appropriate
-// database calls
-func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.OrderID, err error) {
-
-	var assetToCredit match.Asset
+// heldAsset is the asset that's taken out of the balance while an order is open
+func heldAsset(order *match.LimitOrder) (asset match.Asset) {
 	// If we are buy then we want to credit assethave
 	// If we are sell then we want to credit assetwant
 	if order.Side == match.Buy {
-		assetToCredit = order.TradingPair.AssetHave
+		asset = order.TradingPair.AssetHave
 	} else {
-		assetToCredit = order.TradingPair.AssetWant
+		asset = order.TradingPair.AssetWant
 	}
+	return
+}
+
+// PlaceOrder places an order by first checking if we can credit the user, then calling the appropriate
+// database calls
+func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.OrderID, err error) {
+	if err = server.checkStopping(); err != nil {
+		return
+	}
+
+	assetToCredit := heldAsset(order)
 
 	// just defensive programming here
 
@@ -61,11 +69,11 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 
 	// TODO: this is to protect the database, this is why switching to a better price system would be a good idea
 	if pr > float64(10000000000000000000000) {
-		err = fmt.Errorf("Price too high, complain online if you want the maximum price increased, or lower your price")
+		err = match.Errorf(match.ErrPriceOutOfBounds, "Price too high, complain online if you want the maximum price increased, or lower your price")
 		return
 	}
 	if pr < float64(1)/float64(1000000) {
-		err = fmt.Errorf("Price too low, complain online if you want the minimum price decreased, or increase your price")
+		err = match.Errorf(match.ErrPriceOutOfBounds, "Price too low, complain online if you want the minimum price decreased, or increase your price")
 		return
 	}
 
@@ -82,7 +90,7 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 
 	var currMatchEng match.LimitEngine
 	if currMatchEng, ok = server.MatchingEngines[order.TradingPair]; !ok {
-		err = fmt.Errorf("Could not find matching engine for trading pair for PlaceOrder")
+		err = match.Errorf(match.ErrUnknownPair, "Could not find matching engine for trading pair for PlaceOrder")
 		server.dbLock.Unlock()
 		return
 	}
@@ -119,7 +127,7 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 	}
 
 	if !valid {
-		err = fmt.Errorf("Error placing order, not enough balance or you are not allowed to place orders")
+		err = match.Errorf(match.ErrInsufficientFunds, "Error placing order, not enough balance or you are not allowed to place orders")
 		server.dbLock.Unlock()
 		return
 	}
@@ -209,13 +217,25 @@ func (server *OpencxServer) PlaceOrder(order *match.LimitOrder) (orderID *match.
 		server.dbLock.Unlock()
 		return
 	}
+	server.publishBookPlace(idRes)
+	server.publishOrderAck(idRes)
 
 	for _, orderExec := range orderExecs {
+		// we need the order as it was before the exec for the market data
+		var beforeExec *match.LimitOrderIDPair
+		if beforeExec, err = currOrderbook.GetOrder(&orderExec.OrderID); err != nil {
+			err = fmt.Errorf("Error getting executed order from orderbook for PlaceOrder: %s", err)
+			server.dbLock.Unlock()
+			return
+		}
+
 		if err = currOrderbook.UpdateBookExec(orderExec); err != nil {
 			err = fmt.Errorf("Error updating orderbook execution for PlaceOrder: %s", err)
 			server.dbLock.Unlock()
 			return
 		}
+		server.publishBookExec(beforeExec, orderExec, orderExec.OrderID != *idRes.OrderID)
+		server.publishOrderFill(beforeExec, orderExec)
 	}
 
 	// update what the client sees
@@ -239,7 +259,7 @@ func (server *OpencxServer) ViewOrderbook(pair *match.Pair) (book map[float64][]
 	var currOrderbook match.LimitOrderbook
 	var ok bool
 	if currOrderbook, ok = server.Orderbooks[*pair]; !ok {
-		err = fmt.Errorf("Could not find orderbooks for trading pair for ViewOrderbook")
+		err = match.Errorf(match.ErrUnknownPair, "Could not find orderbooks for trading pair for ViewOrderbook")
 		server.dbLock.Unlock()
 		return
 	}
@@ -282,14 +302,7 @@ func (server *OpencxServer) GetOrdersForPubkey(pubkey *koblitz.PublicKey) (order
 // database calls
 func (server *OpencxServer) CancelOrder(order *match.LimitOrderIDPair) (err error) {
 
-	var assetToDebit match.Asset
-	// If we are buy then we want to credit assethave
-	// If we are sell then we want to credit assetwant
-	if order.Order.Side == match.Buy {
-		assetToDebit = order.Order.TradingPair.AssetHave
-	} else {
-		assetToDebit = order.Order.TradingPair.AssetWant
-	}
+	assetToDebit := heldAsset(order.Order)
 
 	// if we can't turn the asset into coinparams then lol rip
 	var param *coinparam.Params
@@ -384,6 +397,8 @@ func (server *OpencxServer) CancelOrder(order *match.LimitOrderIDPair) (err erro
 		server.dbLock.Unlock()
 		return
 	}
+	server.publishBookCancel(order)
+	server.publishOrderCancel(order)
 
 	// update what the client sees
 	if err = currSetStore.UpdateBalances(settlementResults); err != nil {
@@ -0,0 +1,25 @@
+package cxserver
+
+import (
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxauth"
+	"github.com/mit-dci/opencx/match"
+)
+
+// IssueNonce issues a nonce that a pubkey can sign one request with, and returns when it expires
+func (server *OpencxServer) IssueNonce(owner [33]byte) (nonce [32]byte, expiry time.Time, err error) {
+	nonce, expiry, err = server.requestNonces.IssueNonce(owner)
+	return
+}
+
+// VerifyRequest verifies a signed request for a method and args hash, using up its nonce, and
+// returns the pubkey that signed it.
+func (server *OpencxServer) VerifyRequest(method string, argsHash [32]byte, req *cxauth.SignedRequest) (pubkey *koblitz.PublicKey, err error) {
+	if pubkey, err = server.requestNonces.VerifyRequest(method, argsHash, req); err != nil {
+		err = match.Errorf(match.ErrUnauthorized, "%s", err)
+		return
+	}
+	return
+}
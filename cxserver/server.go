@@ -6,8 +6,6 @@ import (
 
 	"github.com/mit-dci/lit/crypto/koblitz"
 
-	"golang.org/x/crypto/sha3"
-
 	"github.com/mit-dci/lit/uspv"
 
 	"github.com/mit-dci/lit/btcutil/hdkeychain"
@@ -17,7 +15,10 @@ import (
 	"github.com/mit-dci/lit/wallit"
 	"github.com/mit-dci/lit/wire"
 
+	"github.com/mit-dci/opencx/crypto/provisions"
+	"github.com/mit-dci/opencx/cxauth"
 	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/cxfeed"
 	"github.com/mit-dci/opencx/logging"
 	"github.com/mit-dci/opencx/match"
 )
@@ -32,9 +33,6 @@ type OpencxServer struct {
 	SettlementStores  map[*coinparam.Params]cxdb.SettlementStore
 	dbLock            *sync.Mutex
 
-	registrationString string
-	getOrdersString    string
-
 	ExchangeNode *qln.LitNode
 
 	BlockChanMap       map[int]chan *wire.MsgBlock
@@ -56,6 +54,36 @@ type OpencxServer struct {
 	// remove this when we have some sense of how much money the exchange has and/or some fancy
 	// algorithms to determine this number based on reputation or something
 	defaultCapacity int64
+
+	// adminPubkey is the key that is allowed to run admin commands, like proving solvency
+	adminPubkey *koblitz.PublicKey
+
+	// nonces for signed requests, so they can't be replayed
+	requestNonces *cxauth.NonceStore
+
+	// the latest solvency proof for each coin, and the openings we send to customers
+	solvencyProofs   map[*coinparam.Params]*provisions.SolvencyProof
+	solvencyOpenings map[*coinparam.Params]map[[33]byte]*provisions.CustomerOpening
+	solvencyMtx      *sync.Mutex
+
+	// withdrawals that have been taken out of balances but haven't left the wallet yet
+	pendingWithdrawals map[*coinparam.Params]map[[33]byte]uint64
+	withdrawalMtx      *sync.Mutex
+
+	// the latest published merkle sum tree of balances for each coin
+	liabilities  map[*coinparam.Params]*publishedLiabilities
+	liabilityMtx *sync.Mutex
+
+	// market data events for each pair, published as the orderbooks are updated
+	marketFeeds map[match.Pair]*cxfeed.Feed
+
+	// private events for each pubkey, like fills and deposits
+	userFeeds   map[[33]byte]*userEventFeed
+	userFeedMtx *sync.Mutex
+
+	// set once the server is shutting down, so it stops taking orders and withdrawals
+	stopping    bool
+	stoppingMtx *sync.Mutex
 }
 
 // InitServer creates a new server
@@ -69,8 +97,6 @@ func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEn
 		dbLock:            new(sync.Mutex),
 		OpencxRoot:        rootDir,
 
-		registrationString: "opencx-register",
-		getOrdersString:    "opencx-getorders",
 		ingestMutex:        *new(sync.Mutex),
 		BlockChanMap:       make(map[int]chan *wire.MsgBlock),
 		HeightEventChanMap: make(map[int]chan lnutil.HeightEvent),
@@ -84,6 +110,31 @@ func InitServer(setEngines map[*coinparam.Params]match.SettlementEngine, matchEn
 		privKeyMtx: new(sync.Mutex),
 
 		defaultCapacity: 1000000,
+
+		solvencyProofs:   make(map[*coinparam.Params]*provisions.SolvencyProof),
+		solvencyOpenings: make(map[*coinparam.Params]map[[33]byte]*provisions.CustomerOpening),
+		solvencyMtx:      new(sync.Mutex),
+
+		pendingWithdrawals: make(map[*coinparam.Params]map[[33]byte]uint64),
+		withdrawalMtx:      new(sync.Mutex),
+
+		liabilities:  make(map[*coinparam.Params]*publishedLiabilities),
+		liabilityMtx: new(sync.Mutex),
+
+		userFeeds:   make(map[[33]byte]*userEventFeed),
+		userFeedMtx: new(sync.Mutex),
+
+		stoppingMtx: new(sync.Mutex),
+	}
+
+	if server.requestNonces, err = cxauth.NewNonceStore(cxauth.DefaultNonceLifetime); err != nil {
+		err = fmt.Errorf("Error creating nonce store for server: %s", err)
+		return
+	}
+
+	if err = server.initMarketFeeds(); err != nil {
+		err = fmt.Errorf("Error creating market feeds for server: %s", err)
+		return
 	}
 
 	return
@@ -105,48 +156,6 @@ func (server *OpencxServer) StartChainhookHandlers(wallet *wallit.Wallit) {
 
 }
 
-// GetRegistrationString gets a string that should be signed in order for a client to be registered
-func (server *OpencxServer) GetRegistrationString() (regStr string) {
-	regStr = server.registrationString
-	return
-}
-
-// RegistrationStringVerify verifies a signature for a registration string and returns a pubkey
-func (server *OpencxServer) RegistrationStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
-	// e = h(registrationstring)
-	sha3 := sha3.New256()
-	sha3.Write([]byte(server.GetRegistrationString()))
-	e := sha3.Sum(nil)
-
-	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
-		err = fmt.Errorf("Error verifying registration string, invalid signature: \n%s", err)
-		return
-	}
-
-	return
-}
-
-// GetOrdersString gets a string that should be signed in order for a client to be registered
-func (server *OpencxServer) GetOrdersString() (getOrderStr string) {
-	getOrderStr = server.getOrdersString
-	return
-}
-
-// GetOrdersStringVerify verifies a signature for the getOrdersString
-func (server *OpencxServer) GetOrdersStringVerify(sig []byte) (pubkey *koblitz.PublicKey, err error) {
-	// e = h(getOrders)
-	sha3 := sha3.New256()
-	sha3.Write([]byte(server.GetOrdersString()))
-	e := sha3.Sum(nil)
-
-	if pubkey, _, err = koblitz.RecoverCompact(koblitz.S256(), sig, e); err != nil {
-		err = fmt.Errorf("Error verifying getOrders string, invalid signature: \n%s", err)
-		return
-	}
-
-	return
-}
-
 // GetAddressMap gets an address map for a pubkey. This is so we can register multiple ways.
 func (server *OpencxServer) GetAddressMap(pubkey *koblitz.PublicKey) (addrMap map[*coinparam.Params]string, err error) {
 	// go through each enabled wallet in the server and create a new address for them.
@@ -0,0 +1,78 @@
+package cxserver
+
+import (
+	"fmt"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// Shutdown stops the server from taking new orders and withdrawals, waits for the matching and
+// settlement that's already happening to finish, wakes up anyone waiting on event feeds, and
+// closes the DB handlers. Cancels are still taken until the handlers are closed. After this
+// the server has no engines or stores, so anything that comes in later gets an error.
+func (server *OpencxServer) Shutdown() (err error) {
+	server.stoppingMtx.Lock()
+	server.stopping = true
+	server.stoppingMtx.Unlock()
+
+	// Everything that matches or settles holds the lock, so once we have it nothing is in flight
+	logging.Infof("Waiting for matching and settlement to finish...")
+	server.dbLock.Lock()
+
+	for _, feed := range server.marketFeeds {
+		feed.Close()
+	}
+	server.userFeedMtx.Lock()
+	for _, userFeed := range server.userFeeds {
+		userFeed.feed.Close()
+	}
+	server.userFeedMtx.Unlock()
+
+	var handlers []interface{}
+	for _, engine := range server.MatchingEngines {
+		handlers = append(handlers, engine)
+	}
+	for _, book := range server.Orderbooks {
+		handlers = append(handlers, book)
+	}
+	for _, setEngine := range server.SettlementEngines {
+		handlers = append(handlers, setEngine)
+	}
+	for _, depositStore := range server.DepositStores {
+		handlers = append(handlers, depositStore)
+	}
+	for _, settleStore := range server.SettlementStores {
+		handlers = append(handlers, settleStore)
+	}
+	err = cxdb.DestroyHandlers(handlers)
+
+	// The handlers are closed even if some failed, so nothing should use them
+	server.SettlementEngines = make(map[*coinparam.Params]match.SettlementEngine)
+	server.MatchingEngines = make(map[match.Pair]match.LimitEngine)
+	server.Orderbooks = make(map[match.Pair]match.LimitOrderbook)
+	server.DepositStores = make(map[*coinparam.Params]cxdb.DepositStore)
+	server.SettlementStores = make(map[*coinparam.Params]cxdb.SettlementStore)
+	server.dbLock.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("Error closing DB handlers for Shutdown: %s", err)
+		return
+	}
+	return
+}
+
+// checkStopping returns an error if the server is shutting down, so it doesn't take new orders
+// or withdrawals
+func (server *OpencxServer) checkStopping() (err error) {
+	server.stoppingMtx.Lock()
+	if server.stopping {
+		err = match.Errorf(match.ErrServerBusy, "Server is shutting down")
+		server.stoppingMtx.Unlock()
+		return
+	}
+	server.stoppingMtx.Unlock()
+	return
+}
@@ -0,0 +1,234 @@
+package cxserver
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+
+	"github.com/mit-dci/lit/coinparam"
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/lit/portxo"
+	"github.com/mit-dci/lit/wallit"
+	"github.com/mit-dci/opencx/crypto/provisions"
+	"github.com/mit-dci/opencx/cxdb"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+// NewSolvencyParams returns the provisions params that solvency proofs use, on secp256k1
+func NewSolvencyParams() (params *provisions.Params, err error) {
+	if params, err = provisions.NewParams(koblitz.S256()); err != nil {
+		err = fmt.Errorf("Error creating solvency params: %s", err)
+		return
+	}
+	return
+}
+
+// ownedKeyBalances adds up the value of every utxo in the wallet for each key that owns them
+func ownedKeyBalances(wallet *wallit.Wallit) (balances map[portxo.KeyGen]uint64, err error) {
+	var utxos []*portxo.PorTxo
+	if utxos, err = wallet.UtxoDump(); err != nil {
+		err = fmt.Errorf("Error dumping utxos for solvency proof: %s", err)
+		return
+	}
+
+	balances = make(map[portxo.KeyGen]uint64)
+	for _, utxo := range utxos {
+		if utxo.Value < 0 {
+			err = fmt.Errorf("Utxo %s has negative value", utxo.Op.String())
+			return
+		}
+		balances[utxo.KeyGen] += uint64(utxo.Value)
+	}
+
+	return
+}
+
+// customerLiabilities gets what the exchange owes each user in a coin. That's their balance in the
+// settlement store, plus the funds held for them that aren't in it: what's left of their open
+// orders, the bonds for their order commitments, and withdrawals that haven't left the wallet yet.
+// The db lock should be held.
+func (server *OpencxServer) customerLiabilities(coin *coinparam.Params) (liabilities map[[33]byte]uint64, err error) {
+	var asset match.Asset
+	if asset, err = match.AssetFromCoinParam(coin); err != nil {
+		err = fmt.Errorf("Error getting asset for %s liabilities: %s", coin.Name, err)
+		return
+	}
+
+	var currSettlementStore cxdb.SettlementStore
+	var ok bool
+	if currSettlementStore, ok = server.SettlementStores[coin]; !ok {
+		err = fmt.Errorf("Cannot find the settlement store for %s liabilities", coin.Name)
+		return
+	}
+
+	if liabilities, err = currSettlementStore.GetAllBalances(); err != nil {
+		err = fmt.Errorf("Could not get balances for %s liabilities: %s", coin.Name, err)
+		return
+	}
+
+	// Placing an order takes the amount out of the balance until it's filled or cancelled
+	for pair, book := range server.Orderbooks {
+		if pair.AssetHave != asset && pair.AssetWant != asset {
+			continue
+		}
+
+		var bookOrders map[float64][]*match.LimitOrderIDPair
+		if bookOrders, err = book.ViewLimitOrderBook(); err != nil {
+			err = fmt.Errorf("Could not get %s orders for %s liabilities: %s", pair.String(), coin.Name, err)
+			return
+		}
+
+		for _, orders := range bookOrders {
+			for _, order := range orders {
+				if heldAsset(order.Order) == asset {
+					liabilities[order.Order.Pubkey] += order.Order.AmountHave
+				}
+			}
+		}
+	}
+
+	var bonds []*match.Bond
+	if bonds, err = currSettlementStore.GetBonds(match.BondHeld); err != nil {
+		err = fmt.Errorf("Could not get held bonds for %s liabilities: %s", coin.Name, err)
+		return
+	}
+
+	for _, bond := range bonds {
+		liabilities[bond.Pubkey] += bond.Amount
+	}
+
+	server.withdrawalMtx.Lock()
+	for pubkey, amount := range server.pendingWithdrawals[coin] {
+		liabilities[pubkey] += amount
+	}
+	server.withdrawalMtx.Unlock()
+
+	return
+}
+
+// ProveSolvency creates a proof of solvency for a coin. The anonymity set is every key in the
+// exchange's wallet that holds utxos, along with the anonKeys, which are keys the exchange doesn't
+// own and their balances on chain. The liabilities are what the exchange owes every user, including
+// funds held for open orders, bonds and pending withdrawals. The proof and customer openings are
+// kept so customers can get them later.
+func (server *OpencxServer) ProveSolvency(coin *coinparam.Params, anonKeys map[[33]byte]uint64) (proof *provisions.SolvencyProof, err error) {
+	server.walletMtx.Lock()
+	wallet, found := server.WalletMap[coin]
+	server.walletMtx.Unlock()
+	if !found {
+		err = fmt.Errorf("Could not find wallet for %s to prove solvency", coin.Name)
+		return
+	}
+
+	var params *provisions.Params
+	if params, err = NewSolvencyParams(); err != nil {
+		return
+	}
+
+	var machine *provisions.AssetsProofMachine
+	if machine, err = provisions.NewAssetsProofMachine(params); err != nil {
+		err = fmt.Errorf("Error creating assets proof machine for ProveSolvency: %s", err)
+		return
+	}
+
+	var ownedBalances map[portxo.KeyGen]uint64
+	if ownedBalances, err = ownedKeyBalances(wallet); err != nil {
+		return
+	}
+
+	for kg, balance := range ownedBalances {
+		privkey := wallet.PathPrivkey(kg)
+		if privkey == nil {
+			err = fmt.Errorf("Could not derive private key for utxo in %s wallet", coin.Name)
+			return
+		}
+		if err = machine.AddOwnedKey((*ecdsa.PrivateKey)(privkey), balance); err != nil {
+			err = fmt.Errorf("Error adding owned key to anonymity set: %s", err)
+			return
+		}
+	}
+
+	for pubkeyBytes, balance := range anonKeys {
+		var pubkey *koblitz.PublicKey
+		if pubkey, err = koblitz.ParsePubKey(pubkeyBytes[:], koblitz.S256()); err != nil {
+			err = fmt.Errorf("Error parsing anonymity set pubkey %x: %s", pubkeyBytes, err)
+			return
+		}
+		if err = machine.AddAnonKey((*ecdsa.PublicKey)(pubkey), balance); err != nil {
+			err = fmt.Errorf("Error adding key %x to anonymity set: %s", pubkeyBytes, err)
+			return
+		}
+	}
+
+	server.dbLock.Lock()
+	var balances map[[33]byte]uint64
+	if balances, err = server.customerLiabilities(coin); err != nil {
+		err = fmt.Errorf("Could not get liabilities for ProveSolvency: %s", err)
+		server.dbLock.Unlock()
+		return
+	}
+	server.dbLock.Unlock()
+
+	var customers []*provisions.CustomerBalance
+	for pubkey, balance := range balances {
+		customerPubkey := pubkey
+		customers = append(customers, &provisions.CustomerBalance{
+			PubKey:  customerPubkey[:],
+			Balance: balance,
+		})
+	}
+
+	var openings []*provisions.CustomerOpening
+	if proof, openings, err = provisions.ProveSolvency(machine, customers); err != nil {
+		err = fmt.Errorf("Error proving solvency for %s: %s", coin.Name, err)
+		return
+	}
+
+	openingMap := make(map[[33]byte]*provisions.CustomerOpening)
+	for _, opening := range openings {
+		var pubkey [33]byte
+		copy(pubkey[:], opening.PubKey)
+		openingMap[pubkey] = opening
+	}
+
+	server.solvencyMtx.Lock()
+	server.solvencyProofs[coin] = proof
+	server.solvencyOpenings[coin] = openingMap
+	server.solvencyMtx.Unlock()
+
+	logging.Infof("Proved solvency for %s with %d keys in the anonymity set and %d customers", coin.Name, len(proof.Assets.Entries), len(customers))
+
+	return
+}
+
+// GetSolvencyProof returns the latest proof of solvency for a coin
+func (server *OpencxServer) GetSolvencyProof(coin *coinparam.Params) (proof *provisions.SolvencyProof, err error) {
+	server.solvencyMtx.Lock()
+	var ok bool
+	if proof, ok = server.solvencyProofs[coin]; !ok {
+		err = fmt.Errorf("Solvency has not been proven for %s", coin.Name)
+		server.solvencyMtx.Unlock()
+		return
+	}
+	server.solvencyMtx.Unlock()
+
+	return
+}
+
+// GetSolvencyOpening returns the opening of a customer's commitment in the latest proof of
+// solvency for a coin, so they can check that their balance was included.
+func (server *OpencxServer) GetSolvencyOpening(pubkey *koblitz.PublicKey, coin *coinparam.Params) (opening *provisions.CustomerOpening, err error) {
+	var pubkeyBytes [33]byte
+	copy(pubkeyBytes[:], pubkey.SerializeCompressed())
+
+	server.solvencyMtx.Lock()
+	var ok bool
+	if opening, ok = server.solvencyOpenings[coin][pubkeyBytes]; !ok {
+		err = fmt.Errorf("No balance for pubkey in the latest %s solvency proof", coin.Name)
+		server.solvencyMtx.Unlock()
+		return
+	}
+	server.solvencyMtx.Unlock()
+
+	return
+}
@@ -0,0 +1,239 @@
+package cxserver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mit-dci/lit/crypto/koblitz"
+	"github.com/mit-dci/opencx/cxfeed"
+	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
+)
+
+const (
+	// UserFeedBacklog is how many events are kept for each pubkey, for clients to catch up on
+	// after they reconnect
+	UserFeedBacklog = 1000
+	// MaxUserEvents is the most user events returned by one call to UserEvents
+	MaxUserEvents = 1000
+	// MaxUserEventWait is the longest UserEvents will wait for new events
+	MaxUserEventWait = 30 * time.Second
+	// UserFeedIdleTimeout is how long a pubkey's feed is kept without being polled or published to
+	UserFeedIdleTimeout = time.Hour
+)
+
+// UserEventType is what happened to a user's orders or balances
+type UserEventType string
+
+const (
+	// OrderAck is an order being accepted and placed on the book
+	OrderAck = UserEventType("ack")
+	// OrderFill is an order being filled, partly or completely
+	OrderFill = UserEventType("fill")
+	// OrderCancel is an order being cancelled
+	OrderCancel = UserEventType("cancel")
+	// DepositConfirmed is a deposit getting enough confirmations to be credited
+	DepositConfirmed = UserEventType("deposit")
+	// WithdrawalSent is a withdrawal being sent, with its txid
+	WithdrawalSent = UserEventType("withdrawal")
+)
+
+// UserEvent is one event for a user. Order events have the order fields set, and deposits and
+// withdrawals have the asset fields set. For an ack the amounts are the whole order, and for a
+// fill they're what was just filled.
+type UserEvent struct {
+	Seq  uint64
+	Type UserEventType
+	Time time.Time
+
+	OrderID    match.OrderID
+	Pair       string
+	Side       string
+	Price      float64
+	AmountHave uint64
+	AmountWant uint64
+	// Filled is set for a fill that completes the order
+	Filled bool
+
+	Asset  string
+	Amount uint64
+	Txid   string
+}
+
+// userEventFeed is the event feed for a pubkey, and when it was last polled or published to
+type userEventFeed struct {
+	feed     *cxfeed.Feed
+	lastUsed time.Time
+}
+
+// userFeed returns the event feed for a pubkey. If it doesn't exist yet it's created if create is
+// true, and feed is nil otherwise. Whenever a feed is created, feeds that haven't been used for
+// UserFeedIdleTimeout are dropped, so there are only feeds for pubkeys that are being used.
+func (server *OpencxServer) userFeed(pubkey [33]byte, create bool) (feed *cxfeed.Feed, err error) {
+	now := time.Now()
+	server.userFeedMtx.Lock()
+	if currFeed, ok := server.userFeeds[pubkey]; ok {
+		currFeed.lastUsed = now
+		feed = currFeed.feed
+		server.userFeedMtx.Unlock()
+		return
+	}
+
+	if !create {
+		server.userFeedMtx.Unlock()
+		return
+	}
+
+	server.pruneUserFeeds(now)
+	if feed, err = cxfeed.NewFeed(UserFeedBacklog); err != nil {
+		err = fmt.Errorf("Error creating user feed: %s", err)
+		server.userFeedMtx.Unlock()
+		return
+	}
+	server.userFeeds[pubkey] = &userEventFeed{
+		feed:     feed,
+		lastUsed: now,
+	}
+	server.userFeedMtx.Unlock()
+	return
+}
+
+// pruneUserFeeds closes and drops the feeds that haven't been used for UserFeedIdleTimeout. A
+// client that comes back later gets a feed with a new epoch, so it's told it missed events.
+// userFeedMtx must be held.
+func (server *OpencxServer) pruneUserFeeds(now time.Time) {
+	for pubkey, currFeed := range server.userFeeds {
+		if now.Sub(currFeed.lastUsed) > UserFeedIdleTimeout {
+			currFeed.feed.Close()
+			delete(server.userFeeds, pubkey)
+		}
+	}
+	return
+}
+
+// publishUserEvent adds an event to the feed for a pubkey
+func (server *OpencxServer) publishUserEvent(pubkey [33]byte, event *UserEvent) {
+	feed, err := server.userFeed(pubkey, true)
+	if err != nil {
+		logging.Warnf("Error getting user feed, not publishing %s event: %s", event.Type, err)
+		return
+	}
+	event.Time = time.Now()
+	feed.Publish(event)
+	return
+}
+
+// publishOrderAck publishes an order being placed to its owner
+func (server *OpencxServer) publishOrderAck(order *match.LimitOrderIDPair) {
+	server.publishUserEvent(order.Order.Pubkey, &UserEvent{
+		Type:       OrderAck,
+		OrderID:    *order.OrderID,
+		Pair:       order.Order.TradingPair.String(),
+		Side:       order.Order.Side.String(),
+		Price:      order.Price,
+		AmountHave: order.Order.AmountHave,
+		AmountWant: order.Order.AmountWant,
+	})
+	return
+}
+
+// publishOrderFill publishes an execution to the owner of the order, given the order as it was
+// before the execution
+func (server *OpencxServer) publishOrderFill(before *match.LimitOrderIDPair, orderExec *match.OrderExecution) {
+	event := &UserEvent{
+		Type:       OrderFill,
+		OrderID:    orderExec.OrderID,
+		Pair:       before.Order.TradingPair.String(),
+		Side:       before.Order.Side.String(),
+		Price:      before.Price,
+		AmountHave: before.Order.AmountHave,
+		AmountWant: before.Order.AmountWant,
+		Filled:     orderExec.Filled,
+	}
+	if !orderExec.Filled {
+		event.AmountHave -= orderExec.NewAmountHave
+		event.AmountWant -= orderExec.NewAmountWant
+	}
+	server.publishUserEvent(before.Order.Pubkey, event)
+	return
+}
+
+// publishOrderCancel publishes an order being cancelled to its owner
+func (server *OpencxServer) publishOrderCancel(order *match.LimitOrderIDPair) {
+	server.publishUserEvent(order.Order.Pubkey, &UserEvent{
+		Type:    OrderCancel,
+		OrderID: *order.OrderID,
+		Pair:    order.Order.TradingPair.String(),
+		Side:    order.Order.Side.String(),
+		Price:   order.Price,
+	})
+	return
+}
+
+// publishDeposit publishes a confirmed deposit, which is credited with a settlement execution
+func (server *OpencxServer) publishDeposit(depositExec *match.SettlementExecution) {
+	server.publishUserEvent(depositExec.Pubkey, &UserEvent{
+		Type:   DepositConfirmed,
+		Asset:  depositExec.Asset.String(),
+		Amount: depositExec.Amount,
+	})
+	return
+}
+
+// publishWithdrawal publishes a withdrawal that was sent
+func (server *OpencxServer) publishWithdrawal(pubkey *koblitz.PublicKey, asset string, amount uint64, txid string) {
+	var pubkeyBytes [33]byte
+	copy(pubkeyBytes[:], pubkey.SerializeCompressed())
+	server.publishUserEvent(pubkeyBytes, &UserEvent{
+		Type:   WithdrawalSent,
+		Asset:  asset,
+		Amount: amount,
+		Txid:   txid,
+	})
+	return
+}
+
+// UserEvents returns the events for a pubkey after afterSeq in epoch, waiting up to wait for one
+// if there aren't any yet. The wait is capped at MaxUserEventWait. The epoch and seq are a cursor,
+// so a client that reconnects passes the last ones it saw, and a client that hasn't seen any
+// passes 0 for both. If gap is true the events after afterSeq are gone, or the feed was replaced,
+// like after a restart, and the client should check its orders and balances before continuing
+// from feedEpoch and lastSeq. Only registered pubkeys can poll for events.
+func (server *OpencxServer) UserEvents(pubkey *koblitz.PublicKey, epoch uint64, afterSeq uint64, wait time.Duration) (events []*UserEvent, gap bool, feedEpoch uint64, lastSeq uint64, err error) {
+	var pubkeyBytes [33]byte
+	copy(pubkeyBytes[:], pubkey.SerializeCompressed())
+
+	var feed *cxfeed.Feed
+	if feed, err = server.userFeed(pubkeyBytes, false); err != nil {
+		err = fmt.Errorf("Error getting feed for UserEvents: %s", err)
+		return
+	}
+
+	// Signing a poll with any key shouldn't make us keep a feed for it
+	if feed == nil {
+		if !server.IsRegistered(pubkey) {
+			err = fmt.Errorf("Pubkey %x is not registered, register before polling for events", pubkeyBytes)
+			return
+		}
+		if feed, err = server.userFeed(pubkeyBytes, true); err != nil {
+			err = fmt.Errorf("Error getting feed for UserEvents: %s", err)
+			return
+		}
+	}
+
+	if wait > MaxUserEventWait {
+		wait = MaxUserEventWait
+	}
+
+	var feedEvents []*cxfeed.Event
+	feedEvents, gap = feed.Wait(epoch, afterSeq, MaxUserEvents, wait)
+	feedEpoch = feed.Epoch()
+	lastSeq = feed.LastSeq()
+	for _, feedEvent := range feedEvents {
+		event := *feedEvent.Data.(*UserEvent)
+		event.Seq = feedEvent.Seq
+		events = append(events, &event)
+	}
+
+	return
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/mit-dci/lit/lnp2p"
 	"github.com/mit-dci/lit/qln"
 	"github.com/mit-dci/opencx/logging"
+	"github.com/mit-dci/opencx/match"
 
 	"github.com/mit-dci/lit/lnutil"
 
@@ -26,11 +27,14 @@ import (
 
 // WithdrawCoins inputs the correct parameters to return a withdraw txid
 func (server *OpencxServer) WithdrawCoins(address string, pubkey *koblitz.PublicKey, amount uint64, params *coinparam.Params) (txid string, err error) {
+	if err = server.checkStopping(); err != nil {
+		return
+	}
 
 	// TODO: change everything to int64 and just deal with the negatives in error handling. Casting is probably more dangerous
 	// if you try to withdraw an overflow amount then get out
 	if int64(amount) < 0 {
-		err = fmt.Errorf("That amount would have caused an overflow, enter something lower")
+		err = match.Errorf(match.ErrInvalidAmount, "That amount would have caused an overflow, enter something lower")
 		return
 	}
 
@@ -42,19 +46,23 @@ func (server *OpencxServer) WithdrawCoins(address string, pubkey *koblitz.Public
 	}
 	// Actually try to withdraw
 	if txid, err = withdrawFunction(address, pubkey, amount); err != nil {
-		err = fmt.Errorf("Error withdrawing coins: \n%s", err)
+		err = match.Wrapf(err, "Error withdrawing coins: \n%s", err)
 		return
 	}
+	server.publishWithdrawal(pubkey, params.Name, amount, txid)
 	return
 }
 
 // WithdrawLightning inputs the correct parameters to return a correct txid associated with a channel outpoint
 func (server *OpencxServer) WithdrawLightning(pubkey *koblitz.PublicKey, amount uint64, params *coinparam.Params) (txid string, err error) {
+	if err = server.checkStopping(); err != nil {
+		return
+	}
 
 	// TODO: change everything to int64 and just deal with the negatives in error handling. Casting is probably more dangerous
 	// if you try to withdraw an overflow amount then get out
 	if int64(amount) < 0 {
-		err = fmt.Errorf("That amount would have caused an overflow, enter something lower")
+		err = match.Errorf(match.ErrInvalidAmount, "That amount would have caused an overflow, enter something lower")
 		return
 	}
 
@@ -67,9 +75,10 @@ func (server *OpencxServer) WithdrawLightning(pubkey *koblitz.PublicKey, amount
 
 	// Actually try to withdraw
 	if txid, err = withdrawFunction(pubkey, int64(amount)); err != nil {
-		err = fmt.Errorf("Error withdrawing coins: \n%s", err)
+		err = match.Wrapf(err, "Error withdrawing coins: \n%s", err)
 		return
 	}
+	server.publishWithdrawal(pubkey, params.Name, amount, txid)
 	return
 }
 
@@ -86,15 +95,26 @@ func (server *OpencxServer) withdrawFromChain(params *coinparam.Params) (withdra
 	withdrawFunction = func(address string, pubkey *koblitz.PublicKey, amount uint64) (txid string, err error) {
 
 		if amount == 0 {
-			err = fmt.Errorf("You can't withdraw 0 %s", params.Name)
+			err = match.Errorf(match.ErrInvalidAmount, "You can't withdraw 0 %s", params.Name)
 			return
 		}
 
+		// The coins are still owed until they leave the wallet
+		server.holdWithdrawal(pubkey, amount, params)
+		defer server.releaseWithdrawal(pubkey, amount, params)
+
 		// clearing settlement layer
 		if err = server.CreditUser(pubkey, amount, params); err != nil {
-			err = fmt.Errorf("Error while crediting user for CreateChannel: %s\n", err)
+			err = match.Wrapf(err, "Error while crediting user for CreateChannel: %s\n", err)
 			return
 		}
+		// Nothing is sent unless the very last step works, so anything failing means the coins
+		// never left
+		defer func() {
+			if err != nil {
+				server.refundWithdrawal(pubkey, amount, params)
+			}
+		}()
 
 		// Decoding given address
 		var addr btcutil.Address
@@ -155,7 +175,7 @@ func (server *OpencxServer) withdrawFromLightning(params *coinparam.Params) (wit
 	withdrawFunction = func(pubkey *koblitz.PublicKey, amount int64) (txid string, err error) {
 
 		if amount <= 0 {
-			err = fmt.Errorf("Can't withdraw <= 0")
+			err = match.Errorf(match.ErrInvalidAmount, "Can't withdraw <= 0")
 			return
 		}
 
@@ -164,7 +184,7 @@ func (server *OpencxServer) withdrawFromLightning(params *coinparam.Params) (wit
 		// if that redundancy is necessary. It might be
 		fee := server.ExchangeNode.SubWallet[params.HDCoinType].Fee() * 1000
 		if amount < consts.MinOutput+fee {
-			err = fmt.Errorf("You can't withdraw any less than %d %s", consts.MinOutput+fee, params.Name)
+			err = match.Errorf(match.ErrInvalidAmount, "You can't withdraw any less than %d %s", consts.MinOutput+fee, params.Name)
 			return
 		}
 
@@ -192,9 +212,13 @@ func (server *OpencxServer) withdrawFromLightning(params *coinparam.Params) (wit
 
 		// TODO: this should only happen when we get a proof that the other person actually took the withdraw / updated the state. We don't have a guarantee that they will always accept
 
+		// The coins are still owed until the channel is funded
+		server.holdWithdrawal(pubkey, uint64(amount), params)
+		defer server.releaseWithdrawal(pubkey, uint64(amount), params)
+
 		// clearing settlement layer
 		if err = server.CreditUser(pubkey, uint64(amount), params); err != nil {
-			err = fmt.Errorf("Error while crediting user for CreateChannel: %s\n", err)
+			err = match.Wrapf(err, "Error while crediting user for CreateChannel: %s\n", err)
 			return
 		}
 
@@ -207,6 +231,7 @@ func (server *OpencxServer) withdrawFromLightning(params *coinparam.Params) (wit
 		// retrieve chanIdx because we need it for qchan for outpoint hash, if that's not useful anymore just make this chanIdx => _
 		var chanIdx uint32
 		if chanIdx, err = server.ExchangeNode.FundChannel(peerIdx, params.HDCoinType, ccap, amount, *noData); err != nil {
+			server.refundWithdrawal(pubkey, uint64(amount), params)
 			return
 		}
 
@@ -226,6 +251,48 @@ func (server *OpencxServer) withdrawFromLightning(params *coinparam.Params) (wit
 	return
 }
 
+// holdWithdrawal records a withdrawal that's about to be taken out of the balance, so it still
+// counts as owed to the user until the coins leave the wallet
+func (server *OpencxServer) holdWithdrawal(pubkey *koblitz.PublicKey, amount uint64, params *coinparam.Params) {
+	var pubkeyBytes [33]byte
+	copy(pubkeyBytes[:], pubkey.SerializeCompressed())
+
+	server.withdrawalMtx.Lock()
+	if _, ok := server.pendingWithdrawals[params]; !ok {
+		server.pendingWithdrawals[params] = make(map[[33]byte]uint64)
+	}
+	server.pendingWithdrawals[params][pubkeyBytes] += amount
+	server.withdrawalMtx.Unlock()
+	return
+}
+
+// releaseWithdrawal removes a withdrawal held by holdWithdrawal, once the coins have left the
+// wallet or gone back to the balance
+func (server *OpencxServer) releaseWithdrawal(pubkey *koblitz.PublicKey, amount uint64, params *coinparam.Params) {
+	var pubkeyBytes [33]byte
+	copy(pubkeyBytes[:], pubkey.SerializeCompressed())
+
+	server.withdrawalMtx.Lock()
+	if server.pendingWithdrawals[params][pubkeyBytes] <= amount {
+		delete(server.pendingWithdrawals[params], pubkeyBytes)
+	} else {
+		server.pendingWithdrawals[params][pubkeyBytes] -= amount
+	}
+	server.withdrawalMtx.Unlock()
+	return
+}
+
+// refundWithdrawal gives back the amount of a withdrawal that was taken out of the balance, for
+// when the withdrawal fails before the coins leave
+func (server *OpencxServer) refundWithdrawal(pubkey *koblitz.PublicKey, amount uint64, params *coinparam.Params) {
+	if err := server.DebitUser(pubkey, amount, params); err != nil {
+		logging.Errorf("Error giving back %d %s for failed withdrawal by %x: %s", amount, params.Name, pubkey.SerializeCompressed(), err)
+		return
+	}
+	logging.Infof("Gave back %d %s for failed withdrawal by %x", amount, params.Name, pubkey.SerializeCompressed())
+	return
+}
+
 // GetPeerFromPubkey gets a peer index from a pubkey.
 func (server *OpencxServer) GetPeerFromPubkey(pubkey *koblitz.PublicKey) (peerIdx uint32, err error) {
 
@@ -6,6 +6,9 @@ import "time"
 type AuctionBatch struct {
 	Batch     []*OrderPuzzleResult
 	AuctionID [32]byte
+	// Err is set if the batch couldn't be solved or retrieved, in which case Batch should not be
+	// used, since it may be missing orders.
+	Err error
 }
 
 // AuctionBatcher is an interface for a service that collects orders and handles batching per auction.
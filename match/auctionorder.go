@@ -4,8 +4,9 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"math/big"
 
-	"github.com/mit-dci/opencx/crypto/timelockencoders"
+	"github.com/mit-dci/opencx/crypto/threshold"
 )
 
 // OrderPuzzleResult is a struct that is used as the type for a channel so we can atomically
@@ -13,7 +14,10 @@ import (
 type OrderPuzzleResult struct {
 	Encrypted *EncryptedAuctionOrder
 	Auction   *AuctionOrder
-	Err       error
+	// Solution is Y = A^(2^T) mod N if the solver solved an RSW puzzle. A proof that the puzzle was
+	// solved correctly can be made from it later, without solving the puzzle again.
+	Solution *big.Int
+	Err      error
 }
 
 // AuctionOrder represents a batch order
@@ -36,17 +40,43 @@ type AuctionOrder struct {
 
 // TODO: create an order ID method that hashes the Nonce and Signature? People should be able to verify the signature whenever, even if partially filled.
 
-// TurnIntoEncryptedOrder creates a puzzle for this auction order given the time. We make no assumptions about whether or not the order is signed.
+// TurnIntoEncryptedOrder creates a puzzle for this auction order given the time, using the default scheme. We make no assumptions about whether or not the order is signed.
 func (a *AuctionOrder) TurnIntoEncryptedOrder(t uint64) (encrypted *EncryptedAuctionOrder, err error) {
+	return a.TurnIntoEncryptedOrderWithScheme(t, DefaultEncryptionScheme)
+}
+
+// TurnIntoEncryptedOrderWithScheme creates a puzzle for this auction order given the time, encrypting with the scheme.
+func (a *AuctionOrder) TurnIntoEncryptedOrderWithScheme(t uint64, scheme EncryptionScheme) (encrypted *EncryptedAuctionOrder, err error) {
 	encrypted = new(EncryptedAuctionOrder)
-	if encrypted.OrderCiphertext, encrypted.OrderPuzzle, err = timelockencoders.CreateRSW2048A2PuzzleRC5(t, a.Serialize()); err != nil {
+	encrypted.EnvelopeVersion = CurrentEnvelopeVersion
+	encrypted.Scheme = scheme
+
+	// make sure they match, we set these first because AEAD ciphers authenticate them
+	encrypted.IntendedAuction = a.AuctionID
+	encrypted.IntendedPair = a.TradingPair
+
+	if encrypted.OrderCiphertext, encrypted.OrderPuzzle, err = scheme.encrypt(t, a.Serialize(), encrypted.AssociatedData()); err != nil {
 		err = fmt.Errorf("Error creating puzzle from auction order: %s", err)
 		return
 	}
+	return
+}
+
+// TurnIntoCommitteeEncryptedOrder encrypts this auction order to a decryption committee with the
+// scheme, instead of with a timelock puzzle. The scheme has to be a committee scheme.
+func (a *AuctionOrder) TurnIntoCommitteeEncryptedOrder(scheme EncryptionScheme, committee *threshold.CommitteeKey) (encrypted *EncryptedAuctionOrder, err error) {
+	encrypted = new(EncryptedAuctionOrder)
+	encrypted.EnvelopeVersion = CurrentEnvelopeVersion
+	encrypted.Scheme = scheme
 
-	// make sure they match
+	// make sure they match, we set these first because AEAD ciphers authenticate them
 	encrypted.IntendedAuction = a.AuctionID
 	encrypted.IntendedPair = a.TradingPair
+
+	if encrypted.OrderCiphertext, encrypted.OrderPuzzle, err = scheme.encryptToCommittee(committee, a.Serialize(), encrypted.AssociatedData()); err != nil {
+		err = fmt.Errorf("Error encrypting auction order to committee: %s", err)
+		return
+	}
 	return
 }
 
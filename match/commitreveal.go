@@ -0,0 +1,132 @@
+package match
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// OrderCommitment is a commitment to a signed auction order, for auctions that don't use timelock
+// puzzles. The order is hidden by a hash until the auction is over, then revealed. The commitment
+// is signed by the pubkey that placed it, since that's who puts up the bond.
+type OrderCommitment struct {
+	Pubkey          [33]byte
+	IntendedAuction [32]byte
+	IntendedPair    Pair
+	Commitment      [32]byte
+	Signature       []byte
+}
+
+// OrderReveal opens an order commitment, with the order and the salt that hid it
+type OrderReveal struct {
+	Order *AuctionOrder
+	Salt  [32]byte
+}
+
+// CommitToOrder hashes the salt and the serialized order into a commitment
+func CommitToOrder(order *AuctionOrder, salt [32]byte) (commitment [32]byte) {
+	hasher := sha256.New()
+	hasher.Write(salt[:])
+	hasher.Write(order.Serialize())
+	copy(commitment[:], hasher.Sum(nil))
+	return
+}
+
+// TurnIntoCommitment creates a commitment to this order with a random salt, and the reveal that
+// opens it. The order should already be signed, and the commitment still needs to be signed by
+// the same key before it can be submitted.
+func (a *AuctionOrder) TurnIntoCommitment() (commitment *OrderCommitment, reveal *OrderReveal, err error) {
+	reveal = &OrderReveal{
+		Order: a,
+	}
+	if _, err = rand.Read(reveal.Salt[:]); err != nil {
+		err = fmt.Errorf("Error getting random salt for order commitment: %s", err)
+		return
+	}
+
+	commitment = &OrderCommitment{
+		Pubkey:          a.Pubkey,
+		IntendedAuction: a.AuctionID,
+		IntendedPair:    a.TradingPair,
+		Commitment:      CommitToOrder(a, reveal.Salt),
+	}
+	return
+}
+
+// SerializeSignable serializes the fields of the commitment that get signed
+func (oc *OrderCommitment) SerializeSignable() (buf []byte) {
+	buf = append(buf, oc.Pubkey[:]...)
+	buf = append(buf, oc.IntendedAuction[:]...)
+	buf = append(buf, oc.IntendedPair.Serialize()...)
+	buf = append(buf, oc.Commitment[:]...)
+	return
+}
+
+// Opens returns an error if the reveal doesn't open the commitment
+func (or *OrderReveal) Opens(commitment *OrderCommitment) (err error) {
+	if or.Order == nil || commitment == nil {
+		err = fmt.Errorf("Cannot check a reveal with a nil order or commitment")
+		return
+	}
+
+	if CommitToOrder(or.Order, or.Salt) != commitment.Commitment {
+		err = fmt.Errorf("Revealed order and salt do not hash to the commitment")
+		return
+	}
+
+	if or.Order.Pubkey != commitment.Pubkey {
+		err = fmt.Errorf("Revealed order pubkey %x does not match committed pubkey %x", or.Order.Pubkey, commitment.Pubkey)
+		return
+	}
+
+	if or.Order.AuctionID != commitment.IntendedAuction {
+		err = fmt.Errorf("Revealed order is for auction %x, commitment is for auction %x", or.Order.AuctionID, commitment.IntendedAuction)
+		return
+	}
+
+	if or.Order.TradingPair != commitment.IntendedPair {
+		err = fmt.Errorf("Revealed order is for pair %s, commitment is for pair %s", or.Order.TradingPair.String(), commitment.IntendedPair.String())
+		return
+	}
+
+	return
+}
+
+// BondStatus is what has happened to the bond for an order commitment
+type BondStatus uint8
+
+const (
+	// BondHeld is a bond that's taken out of the balance, waiting for its order to be revealed
+	BondHeld BondStatus = iota
+	// BondRefunded is a bond that was given back, because its order was revealed or its auction
+	// never ended
+	BondRefunded
+	// BondForfeited is a bond that was kept, because its order wasn't revealed in time
+	BondForfeited
+)
+
+// String returns the string representation of the bond status
+func (bs BondStatus) String() string {
+	switch bs {
+	case BondHeld:
+		return "held"
+	case BondRefunded:
+		return "refunded"
+	case BondForfeited:
+		return "forfeited"
+	default:
+		return "unknown"
+	}
+}
+
+// Bond is the funds held from a pubkey for an order commitment. Bonds are recorded in the
+// settlement store, so the ones still held after a restart can be given back, and every bond
+// taken and given back can be audited.
+type Bond struct {
+	Commitment [32]byte
+	AuctionID  [32]byte
+	Pubkey     [33]byte
+	Asset      Asset
+	Amount     uint64
+	Status     BondStatus
+}
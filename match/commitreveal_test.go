@@ -0,0 +1,51 @@
+package match
+
+import (
+	"testing"
+)
+
+func TestOrderCommitmentReveal(t *testing.T) {
+	var err error
+
+	var commitment *OrderCommitment
+	var reveal *OrderReveal
+	if commitment, reveal, err = origOrder.TurnIntoCommitment(); err != nil {
+		t.Errorf("Error committing to order: %s", err)
+		return
+	}
+
+	if err = reveal.Opens(commitment); err != nil {
+		t.Errorf("Reveal should open its own commitment: %s", err)
+		return
+	}
+
+	// a different salt is a different commitment
+	wrongSalt := *reveal
+	wrongSalt.Salt[0] ^= 0x01
+	if err = wrongSalt.Opens(commitment); err == nil {
+		t.Errorf("Reveal with the wrong salt should not open the commitment")
+		return
+	}
+
+	// so is a different order
+	changedOrder := *origOrder
+	changedOrder.AmountWant++
+	changedReveal := &OrderReveal{
+		Order: &changedOrder,
+		Salt:  reveal.Salt,
+	}
+	if err = changedReveal.Opens(commitment); err == nil {
+		t.Errorf("Reveal of a changed order should not open the commitment")
+		return
+	}
+
+	// the commitment has to be for the order's auction too
+	otherAuction := *commitment
+	otherAuction.IntendedAuction[0] ^= 0x01
+	if err = reveal.Opens(&otherAuction); err == nil {
+		t.Errorf("Reveal should not open a commitment for a different auction")
+		return
+	}
+
+	return
+}
@@ -4,11 +4,12 @@ import (
 	"bytes"
 	"encoding/gob"
 	"fmt"
+	"math/big"
 
 	"github.com/mit-dci/opencx/crypto"
 	"github.com/mit-dci/opencx/crypto/hashtimelock"
 	"github.com/mit-dci/opencx/crypto/rsw"
-	"github.com/mit-dci/opencx/crypto/timelockencoders"
+	"github.com/mit-dci/opencx/crypto/threshold"
 )
 
 // EncryptedAuctionOrder represents an encrypted Auction Order, so a ciphertext and a puzzle whos solution is a key, and an intended auction.
@@ -17,69 +18,354 @@ type EncryptedAuctionOrder struct {
 	OrderPuzzle     crypto.Puzzle
 	IntendedAuction [32]byte
 	IntendedPair    Pair
+	// EnvelopeVersion is the version of the encrypted order. Orders with the legacy version don't
+	// have a scheme, and are always RSW with RC5.
+	EnvelopeVersion uint8
+	// Scheme is the timelock and cipher the order was encrypted with
+	Scheme EncryptionScheme
 }
 
-// SolveRC5AuctionOrderAsync solves order puzzles and creates auction orders from them. This should be run in a goroutine.
-func SolveRC5AuctionOrderAsync(e *EncryptedAuctionOrder, puzzleResChan chan *OrderPuzzleResult) {
-	var err error
-	result := new(OrderPuzzleResult)
-	result.Encrypted = e
+// GetScheme returns the scheme the order was encrypted with, taking the envelope version into account
+func (e *EncryptedAuctionOrder) GetScheme() (scheme EncryptionScheme, err error) {
+	switch e.EnvelopeVersion {
+	case EnvelopeVersionLegacy:
+		scheme = DefaultEncryptionScheme
+	case EnvelopeVersionScheme:
+		scheme = e.Scheme
+	default:
+		err = fmt.Errorf("Unknown encrypted order envelope version %d", e.EnvelopeVersion)
+		return
+	}
+	return
+}
+
+// AssociatedData is the data that AEAD ciphers authenticate along with the order, the intended
+// auction ID and pair. This means an encrypted order can't be moved to a different auction or pair.
+func (e *EncryptedAuctionOrder) AssociatedData() (additionalData []byte) {
+	additionalData = append(additionalData, e.IntendedAuction[:]...)
+	additionalData = append(additionalData, e.IntendedPair.Serialize()...)
+	return
+}
+
+// Decrypt solves the puzzle and decrypts the order, using the scheme the order was encrypted with.
+func (e *EncryptedAuctionOrder) Decrypt() (order *AuctionOrder, err error) {
+	var scheme EncryptionScheme
+	if scheme, err = e.GetScheme(); err != nil {
+		err = fmt.Errorf("Error getting scheme to decrypt order: %s", err)
+		return
+	}
+
+	var orderBytes []byte
+	if orderBytes, err = scheme.decrypt(e.OrderCiphertext, e.AssociatedData(), e.OrderPuzzle); err != nil {
+		err = fmt.Errorf("Error solving puzzle for auction order: %s", err)
+		return
+	}
+
+	order = new(AuctionOrder)
+	if err = order.Deserialize(orderBytes); err != nil {
+		err = fmt.Errorf("Error deserializing order gotten from puzzle: %s", err)
+		return
+	}
+
+	return
+}
+
+// DecryptWithProof solves the puzzle, creating a proof that it was solved correctly, and decrypts the
+// order. Anyone with the proof can decrypt the order with DecryptFromProof without solving the puzzle.
+func (e *EncryptedAuctionOrder) DecryptWithProof() (order *AuctionOrder, proof *rsw.VDFProof, err error) {
+	var scheme EncryptionScheme
+	if scheme, err = e.GetScheme(); err != nil {
+		err = fmt.Errorf("Error getting scheme to decrypt order: %s", err)
+		return
+	}
+
+	if scheme.Timelock != TimelockRSW2048A2 {
+		err = fmt.Errorf("Only orders with RSW puzzles can be decrypted with a proof, scheme is %s", scheme.String())
+		return
+	}
+
+	if err = scheme.CheckPuzzleType(e.OrderPuzzle); err != nil {
+		err = fmt.Errorf("Error checking puzzle to decrypt order: %s", err)
+		return
+	}
+
+	// CheckPuzzleType makes sure this is an RSW puzzle
+	if _, proof, err = e.OrderPuzzle.(*rsw.PuzzleRSW).SolveWithProof(); err != nil {
+		err = fmt.Errorf("Error solving puzzle with proof for auction order: %s", err)
+		return
+	}
+
+	if order, err = e.DecryptFromProof(proof); err != nil {
+		return
+	}
+
+	return
+}
+
+// DecryptSolved decrypts an order whose RSW puzzle we already solved, using Y = A^(2^T) mod N.
+// Y isn't checked, so this is only for puzzles we solved ourselves, anyone else should use
+// DecryptFromProof.
+func (e *EncryptedAuctionOrder) DecryptSolved(y *big.Int) (order *AuctionOrder, err error) {
+	var scheme EncryptionScheme
+	if scheme, err = e.GetScheme(); err != nil {
+		err = fmt.Errorf("Error getting scheme to decrypt order: %s", err)
+		return
+	}
+
+	var orderBytes []byte
+	if orderBytes, err = scheme.decryptSolved(e.OrderCiphertext, e.AssociatedData(), e.OrderPuzzle, y); err != nil {
+		err = fmt.Errorf("Error decrypting solved auction order: %s", err)
+		return
+	}
+
+	order = new(AuctionOrder)
+	if err = order.Deserialize(orderBytes); err != nil {
+		err = fmt.Errorf("Error deserializing order gotten from puzzle: %s", err)
+		return
+	}
+
+	return
+}
+
+// DecryptFromProof checks the proof of the puzzle's solution and decrypts the order, without doing
+// the work of solving the puzzle. This lets users check that the exchange decrypted orders honestly.
+func (e *EncryptedAuctionOrder) DecryptFromProof(proof *rsw.VDFProof) (order *AuctionOrder, err error) {
+	var scheme EncryptionScheme
+	if scheme, err = e.GetScheme(); err != nil {
+		err = fmt.Errorf("Error getting scheme to decrypt order: %s", err)
+		return
+	}
 
 	var orderBytes []byte
-	if orderBytes, err = timelockencoders.SolvePuzzleRC5(e.OrderCiphertext, e.OrderPuzzle); err != nil {
-		result.Err = fmt.Errorf("Error solving RC5 puzzle for auction order: %s", err)
-		puzzleResChan <- result
+	if orderBytes, err = scheme.decryptWithProof(e.OrderCiphertext, e.AssociatedData(), e.OrderPuzzle, proof); err != nil {
+		err = fmt.Errorf("Error decrypting auction order with proof: %s", err)
 		return
 	}
 
-	result.Auction = new(AuctionOrder)
-	if err = result.Auction.Deserialize(orderBytes); err != nil {
-		result.Err = fmt.Errorf("Error deserializing order gotten from puzzle: %s", err)
-		puzzleResChan <- result
+	order = new(AuctionOrder)
+	if err = order.Deserialize(orderBytes); err != nil {
+		err = fmt.Errorf("Error deserializing order gotten from puzzle: %s", err)
 		return
 	}
 
+	return
+}
+
+// DecryptWithShares decrypts an order that was encrypted to a committee, by combining the
+// decryption shares from the committee's key holders. Every share is checked against the
+// committee key, so a bad key holder can't make the order decrypt to something else.
+func (e *EncryptedAuctionOrder) DecryptWithShares(committee *threshold.CommitteeKey, shares []*threshold.DecryptionShare) (order *AuctionOrder, err error) {
+	var scheme EncryptionScheme
+	if scheme, err = e.GetScheme(); err != nil {
+		err = fmt.Errorf("Error getting scheme to decrypt order: %s", err)
+		return
+	}
+
+	var orderBytes []byte
+	if orderBytes, err = scheme.decryptWithShares(e.OrderCiphertext, e.AssociatedData(), e.OrderPuzzle, committee, shares); err != nil {
+		err = fmt.Errorf("Error decrypting auction order with shares: %s", err)
+		return
+	}
+
+	order = new(AuctionOrder)
+	if err = order.Deserialize(orderBytes); err != nil {
+		err = fmt.Errorf("Error deserializing order decrypted with shares: %s", err)
+		return
+	}
+
+	return
+}
+
+// CommitteeCapsule returns the capsule of an order encrypted to a committee, after checking that
+// it's a good capsule for the committee and that it's bound to the auction and pair the order is
+// for. Key holders only give out shares for capsules that pass this.
+func (e *EncryptedAuctionOrder) CommitteeCapsule(committee *threshold.CommitteeKey) (capsule *threshold.Capsule, err error) {
+	var ok bool
+	if capsule, ok = e.OrderPuzzle.(*threshold.Capsule); !ok {
+		err = fmt.Errorf("Order is not encrypted to a committee")
+		return
+	}
+
+	if err = committee.CheckCapsule(capsule); err != nil {
+		err = fmt.Errorf("Capsule is not for the committee: %s", err)
+		return
+	}
+
+	if !bytes.Equal(capsule.Label, e.AssociatedData()) {
+		err = fmt.Errorf("Capsule is not bound to auction %x and pair %s", e.IntendedAuction, e.IntendedPair.String())
+		return
+	}
+
+	return
+}
+
+// SolveAuctionOrderAsync solves order puzzles and creates auction orders from them, with any
+// supported scheme. This should be run in a goroutine.
+func SolveAuctionOrderAsync(e *EncryptedAuctionOrder, puzzleResChan chan *OrderPuzzleResult) {
+	result := new(OrderPuzzleResult)
+	result.Encrypted = e
+	result.Auction, result.Err = e.Decrypt()
 	puzzleResChan <- result
+	return
+}
 
+// SerializePuzzle serializes any puzzle we know how to deserialize with the canonical encoding
+func SerializePuzzle(puzzle crypto.Puzzle) (raw []byte, err error) {
+	switch pz := puzzle.(type) {
+	case *rsw.PuzzleRSW:
+		raw, err = pz.Serialize()
+	case *hashtimelock.HashTimelock:
+		raw, err = pz.Serialize()
+	case *threshold.Capsule:
+		raw, err = pz.Serialize()
+	default:
+		err = fmt.Errorf("Cannot serialize puzzle of unknown type %T", puzzle)
+	}
 	return
 }
 
-// Serialize serializes the encrypted order using gob
+// DeserializePuzzle deserializes a puzzle of any type we know from the canonical encoding, using
+// the type tag in the encoding
+func DeserializePuzzle(raw []byte) (puzzle crypto.Puzzle, err error) {
+	var typeTag uint8
+	if typeTag, err = crypto.EncodedType(raw); err != nil {
+		err = fmt.Errorf("Error getting puzzle type: %s", err)
+		return
+	}
+
+	switch typeTag {
+	case crypto.TypeRSWPuzzle:
+		rswPuzzle := new(rsw.PuzzleRSW)
+		if err = rswPuzzle.Deserialize(raw); err != nil {
+			return
+		}
+		puzzle = rswPuzzle
+	case crypto.TypeHashPuzzle:
+		hashPuzzle := new(hashtimelock.HashTimelock)
+		if err = hashPuzzle.Deserialize(raw); err != nil {
+			return
+		}
+		puzzle = hashPuzzle
+	case crypto.TypeCommitteeCapsule:
+		capsule := new(threshold.Capsule)
+		if err = capsule.Deserialize(raw); err != nil {
+			return
+		}
+		puzzle = capsule
+	default:
+		err = fmt.Errorf("Cannot deserialize puzzle of unknown type %d", typeTag)
+	}
+	return
+}
+
+// Serialize serializes the encrypted order with the canonical encoding, which is also what we hash
+// when committing to orders
 func (e *EncryptedAuctionOrder) Serialize() (raw []byte, err error) {
-	var b bytes.Buffer
+	enc := crypto.NewEncoder(crypto.TypeEncryptedOrder)
+	enc.WriteUint8(e.EnvelopeVersion)
+	enc.WriteUint8(uint8(e.Scheme.Timelock))
+	enc.WriteUint8(uint8(e.Scheme.Cipher))
+	enc.WriteFixed(e.IntendedAuction[:])
+	enc.WriteFixed(e.IntendedPair.Serialize())
 
-	// register the rsw puzzle and hashtimelock puzzle
-	gob.Register(new(rsw.PuzzleRSW))
+	if err = enc.WriteBytes(e.OrderCiphertext); err != nil {
+		err = fmt.Errorf("Error encoding encrypted auction order ciphertext: %s", err)
+		return
+	}
 
-	// register the hashtimelock (puzzle and timelock are same thing)
-	gob.Register(new(hashtimelock.HashTimelock))
+	var puzzleBytes []byte
+	if puzzleBytes, err = SerializePuzzle(e.OrderPuzzle); err != nil {
+		err = fmt.Errorf("Error encoding encrypted auction order puzzle: %s", err)
+		return
+	}
 
-	// register the pair
-	gob.Register(new(Pair))
+	if err = enc.WriteBytes(puzzleBytes); err != nil {
+		err = fmt.Errorf("Error encoding encrypted auction order puzzle: %s", err)
+		return
+	}
 
-	// register the puzzle interface
-	gob.RegisterName("puzzle", new(crypto.Puzzle))
+	raw = enc.Bytes()
+	return
+}
 
-	// register the encrypted auction order interface with gob
-	gob.RegisterName("order", new(EncryptedAuctionOrder))
+// Deserialize deserializes the raw bytes into the encrypted auction order receiver. Orders that
+// were serialized with gob, before the canonical encoding, can still be deserialized.
+func (e *EncryptedAuctionOrder) Deserialize(raw []byte) (err error) {
+	if !crypto.IsCanonical(raw) {
+		err = e.deserializeGob(raw)
+		return
+	}
+
+	var dec *crypto.Decoder
+	if dec, err = crypto.NewDecoder(raw, crypto.TypeEncryptedOrder); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order: %s", err)
+		return
+	}
+
+	decoded := new(EncryptedAuctionOrder)
+	if decoded.EnvelopeVersion, err = dec.ReadUint8(); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order envelope version: %s", err)
+		return
+	}
+
+	var timelock, cipher uint8
+	if timelock, err = dec.ReadUint8(); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order timelock: %s", err)
+		return
+	}
+	if cipher, err = dec.ReadUint8(); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order cipher: %s", err)
+		return
+	}
+	decoded.Scheme = EncryptionScheme{
+		Timelock: TimelockScheme(timelock),
+		Cipher:   CipherScheme(cipher),
+	}
+
+	var auctionBytes []byte
+	if auctionBytes, err = dec.ReadFixed(len(decoded.IntendedAuction)); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order auction ID: %s", err)
+		return
+	}
+	copy(decoded.IntendedAuction[:], auctionBytes)
+
+	var pairBytes []byte
+	if pairBytes, err = dec.ReadFixed(decoded.IntendedPair.Size()); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order pair: %s", err)
+		return
+	}
+	if err = decoded.IntendedPair.Deserialize(pairBytes); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order pair: %s", err)
+		return
+	}
 
-	// create a new encoder writing to our buffer
-	enc := gob.NewEncoder(&b)
+	if decoded.OrderCiphertext, err = dec.ReadBytes(); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order ciphertext: %s", err)
+		return
+	}
 
-	// encode the encrypted auction order in the buffer
-	if err = enc.Encode(e); err != nil {
-		err = fmt.Errorf("Error encoding encrypted auction order :%s", err)
+	var puzzleBytes []byte
+	if puzzleBytes, err = dec.ReadBytes(); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order puzzle: %s", err)
 		return
 	}
 
-	// Get the bytes finally
-	raw = b.Bytes()
+	if decoded.OrderPuzzle, err = DeserializePuzzle(puzzleBytes); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order puzzle: %s", err)
+		return
+	}
 
+	if err = dec.Finish(); err != nil {
+		err = fmt.Errorf("Error decoding encrypted auction order: %s", err)
+		return
+	}
+
+	*e = *decoded
 	return
 }
 
-// Deserialize deserializes the raw bytes into the encrypted auction order receiver
-func (e *EncryptedAuctionOrder) Deserialize(raw []byte) (err error) {
+// deserializeGob deserializes an encrypted order that was serialized with gob
+func (e *EncryptedAuctionOrder) deserializeGob(raw []byte) (err error) {
 	var b *bytes.Buffer
 	b = bytes.NewBuffer(raw)
 
@@ -1,6 +1,18 @@
 package match
 
-import "testing"
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/gob"
+	"math/rand"
+	"testing"
+
+	"github.com/mit-dci/opencx/crypto"
+	"github.com/mit-dci/opencx/crypto/hashtimelock"
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/crypto/threshold"
+)
 
 func solveVariableRC5AuctionOrder(howMany uint64, timeToSolve uint64, t *testing.T) {
 
@@ -13,7 +25,7 @@ func solveVariableRC5AuctionOrder(howMany uint64, timeToSolve uint64, t *testing
 
 	puzzleResChan := make(chan *OrderPuzzleResult, howMany)
 	for i := uint64(0); i < howMany; i++ {
-		go SolveRC5AuctionOrderAsync(encOrder, puzzleResChan)
+		go SolveAuctionOrderAsync(encOrder, puzzleResChan)
 	}
 	for i := uint64(0); i < howMany; i++ {
 		var res *OrderPuzzleResult
@@ -45,3 +57,407 @@ func TestConcurrentSolvesN10_T1000000(t *testing.T) {
 	solveVariableRC5AuctionOrder(uint64(10), uint64(1000000), t)
 	return
 }
+
+// createTestCommittee deals a 2 of 3 decryption committee
+func createTestCommittee(t *testing.T) (committee *threshold.CommitteeKey, shares []*threshold.KeyShare) {
+	var err error
+	if committee, shares, err = threshold.Deal(elliptic.P256(), 2, 3); err != nil {
+		t.Fatalf("Error dealing test committee: %s", err)
+	}
+	return
+}
+
+// committeeShares gets a decryption share for the order from each of the key shares
+func committeeShares(t *testing.T, committee *threshold.CommitteeKey, shares []*threshold.KeyShare, encOrder *EncryptedAuctionOrder) (decShares []*threshold.DecryptionShare) {
+	capsule, ok := encOrder.OrderPuzzle.(*threshold.Capsule)
+	if !ok {
+		t.Fatalf("Order puzzle is not a committee capsule")
+	}
+
+	for _, share := range shares {
+		decShare, err := share.DecryptionShare(committee, capsule)
+		if err != nil {
+			t.Fatalf("Error creating decryption share: %s", err)
+		}
+		decShares = append(decShares, decShare)
+	}
+	return
+}
+
+func TestEncryptWithEverySupportedScheme(t *testing.T) {
+	var err error
+
+	committee, shares := createTestCommittee(t)
+	for _, scheme := range SupportedEncryptionSchemes {
+		var encOrder *EncryptedAuctionOrder
+		if scheme.Timelock == TimelockCommittee {
+			encOrder, err = origOrder.TurnIntoCommitteeEncryptedOrder(scheme, committee)
+		} else {
+			encOrder, err = origOrder.TurnIntoEncryptedOrderWithScheme(10000, scheme)
+		}
+		if err != nil {
+			t.Errorf("Error encrypting order with scheme %s: %s", scheme.String(), err)
+			return
+		}
+
+		if err = scheme.CheckCiphertext(encOrder.OrderCiphertext); err != nil {
+			t.Errorf("Ciphertext for scheme %s should pass the check: %s", scheme.String(), err)
+			return
+		}
+
+		// make sure the scheme survives going over the wire
+		var raw []byte
+		if raw, err = encOrder.Serialize(); err != nil {
+			t.Errorf("Error serializing order with scheme %s: %s", scheme.String(), err)
+			return
+		}
+		wireOrder := new(EncryptedAuctionOrder)
+		if err = wireOrder.Deserialize(raw); err != nil {
+			t.Errorf("Error deserializing order with scheme %s: %s", scheme.String(), err)
+			return
+		}
+
+		var decrypted *AuctionOrder
+		if scheme.Timelock == TimelockCommittee {
+			decrypted, err = wireOrder.DecryptWithShares(committee, committeeShares(t, committee, shares[1:], wireOrder))
+		} else {
+			decrypted, err = wireOrder.Decrypt()
+		}
+		if err != nil {
+			t.Errorf("Error decrypting order with scheme %s: %s", scheme.String(), err)
+			return
+		}
+
+		if decrypted.AmountHave != origOrder.AmountHave || decrypted.AuctionID != origOrder.AuctionID {
+			t.Errorf("Order decrypted with scheme %s does not match original order", scheme.String())
+			return
+		}
+	}
+
+	return
+}
+
+func TestLegacyEnvelopeIsRC5(t *testing.T) {
+	var err error
+
+	var encOrder *EncryptedAuctionOrder
+	if encOrder, err = origOrder.TurnIntoEncryptedOrder(10000); err != nil {
+		t.Errorf("Error turning original test order into encrypted order: %s", err)
+		return
+	}
+
+	// Orders from before the envelope was versioned have no version or scheme
+	encOrder.EnvelopeVersion = EnvelopeVersionLegacy
+	encOrder.Scheme = EncryptionScheme{Cipher: CipherAES}
+
+	var scheme EncryptionScheme
+	if scheme, err = encOrder.GetScheme(); err != nil {
+		t.Errorf("Error getting scheme for legacy order: %s", err)
+		return
+	}
+	if scheme != DefaultEncryptionScheme {
+		t.Errorf("Legacy order should use scheme %s, got %s", DefaultEncryptionScheme.String(), scheme.String())
+		return
+	}
+
+	if _, err = encOrder.Decrypt(); err != nil {
+		t.Errorf("Error decrypting legacy order: %s", err)
+		return
+	}
+
+	encOrder.EnvelopeVersion = CurrentEnvelopeVersion + 1
+	if _, err = encOrder.GetScheme(); err == nil {
+		t.Errorf("Getting the scheme for an unknown envelope version should fail")
+		return
+	}
+
+	return
+}
+
+func TestTamperedAEADOrder(t *testing.T) {
+	var err error
+
+	aeadSchemes := []EncryptionScheme{
+		{Timelock: TimelockRSW2048A2, Cipher: CipherAESGCM},
+		{Timelock: TimelockRSW2048A2, Cipher: CipherChaCha20Poly1305},
+	}
+
+	for _, scheme := range aeadSchemes {
+		var encOrder *EncryptedAuctionOrder
+		if encOrder, err = origOrder.TurnIntoEncryptedOrderWithScheme(10000, scheme); err != nil {
+			t.Errorf("Error encrypting order with scheme %s: %s", scheme.String(), err)
+			return
+		}
+
+		// Moving the order to a different auction should make it fail to decrypt
+		movedOrder := *encOrder
+		movedOrder.IntendedAuction[0] ^= 0x01
+		if _, err = movedOrder.Decrypt(); err == nil {
+			t.Errorf("Order with scheme %s moved to a different auction should not decrypt", scheme.String())
+			return
+		}
+
+		movedOrder = *encOrder
+		movedOrder.IntendedPair.AssetWant, movedOrder.IntendedPair.AssetHave = encOrder.IntendedPair.AssetHave, encOrder.IntendedPair.AssetWant
+		if _, err = movedOrder.Decrypt(); err == nil {
+			t.Errorf("Order with scheme %s moved to a different pair should not decrypt", scheme.String())
+			return
+		}
+
+		tamperedOrder := *encOrder
+		tamperedOrder.OrderCiphertext = append([]byte{}, encOrder.OrderCiphertext...)
+		tamperedOrder.OrderCiphertext[len(tamperedOrder.OrderCiphertext)/2] ^= 0x01
+		if _, err = tamperedOrder.Decrypt(); err == nil {
+			t.Errorf("Order with scheme %s and a tampered ciphertext should not decrypt", scheme.String())
+			return
+		}
+
+		if _, err = encOrder.Decrypt(); err != nil {
+			t.Errorf("Untampered order with scheme %s should decrypt: %s", scheme.String(), err)
+			return
+		}
+	}
+
+	return
+}
+
+func TestCommitteeOrder(t *testing.T) {
+	var err error
+
+	committee, shares := createTestCommittee(t)
+	scheme := EncryptionScheme{Timelock: TimelockCommittee, Cipher: CipherAESGCM}
+
+	var encOrder *EncryptedAuctionOrder
+	if encOrder, err = origOrder.TurnIntoCommitteeEncryptedOrder(scheme, committee); err != nil {
+		t.Errorf("Error encrypting order to committee: %s", err)
+		return
+	}
+
+	// Nobody can decrypt on their own, with a time, or with a proof
+	if _, err = encOrder.Decrypt(); err == nil {
+		t.Errorf("Committee order should not decrypt without shares")
+		return
+	}
+
+	if _, _, err = encOrder.DecryptWithProof(); err == nil {
+		t.Errorf("Committee order should not decrypt with a proof")
+		return
+	}
+
+	if _, err = origOrder.TurnIntoEncryptedOrderWithScheme(10000, scheme); err == nil {
+		t.Errorf("Should not encrypt with a committee scheme without a committee key")
+		return
+	}
+
+	decShares := committeeShares(t, committee, shares, encOrder)
+	if _, err = encOrder.DecryptWithShares(committee, decShares[:1]); err == nil {
+		t.Errorf("Committee order should not decrypt with fewer shares than the threshold")
+		return
+	}
+
+	movedOrder := *encOrder
+	movedOrder.IntendedAuction[0] ^= 0x01
+	if _, err = movedOrder.DecryptWithShares(committee, decShares); err == nil {
+		t.Errorf("Committee order moved to a different auction should not decrypt")
+		return
+	}
+
+	var decrypted *AuctionOrder
+	if decrypted, err = encOrder.DecryptWithShares(committee, decShares[:2]); err != nil {
+		t.Errorf("Error decrypting committee order with shares: %s", err)
+		return
+	}
+
+	if decrypted.AmountHave != origOrder.AmountHave || decrypted.AuctionID != origOrder.AuctionID {
+		t.Errorf("Committee order decrypted with shares does not match original order")
+		return
+	}
+
+	return
+}
+
+func TestCanonicalOrderEncoding(t *testing.T) {
+	var err error
+
+	var encOrder *EncryptedAuctionOrder
+	if encOrder, err = origOrder.TurnIntoEncryptedOrder(10000); err != nil {
+		t.Errorf("Error turning original test order into encrypted order: %s", err)
+		return
+	}
+
+	var raw []byte
+	if raw, err = encOrder.Serialize(); err != nil {
+		t.Errorf("Error serializing order: %s", err)
+		return
+	}
+
+	decoded := new(EncryptedAuctionOrder)
+	if err = decoded.Deserialize(raw); err != nil {
+		t.Errorf("Error deserializing order: %s", err)
+		return
+	}
+
+	var reencoded []byte
+	if reencoded, err = decoded.Serialize(); err != nil {
+		t.Errorf("Error serializing decoded order: %s", err)
+		return
+	}
+
+	if !bytes.Equal(raw, reencoded) {
+		t.Errorf("Order did not serialize to the same bytes after deserializing")
+		return
+	}
+
+	// Orders serialized with gob before the canonical encoding should still deserialize
+	var gobBuf bytes.Buffer
+	gob.Register(new(rsw.PuzzleRSW))
+	gob.Register(new(Pair))
+	gob.RegisterName("puzzle", new(crypto.Puzzle))
+	gob.RegisterName("order", new(EncryptedAuctionOrder))
+	if err = gob.NewEncoder(&gobBuf).Encode(encOrder); err != nil {
+		t.Errorf("Error encoding order with gob: %s", err)
+		return
+	}
+
+	gobDecoded := new(EncryptedAuctionOrder)
+	if err = gobDecoded.Deserialize(gobBuf.Bytes()); err != nil {
+		t.Errorf("Error deserializing gob order: %s", err)
+		return
+	}
+
+	if reencoded, err = gobDecoded.Serialize(); err != nil {
+		t.Errorf("Error serializing gob decoded order: %s", err)
+		return
+	}
+
+	if !bytes.Equal(raw, reencoded) {
+		t.Errorf("Gob order did not serialize to the same bytes as the original")
+		return
+	}
+
+	return
+}
+
+// TestFuzzDeserializeOrder mutates a serialized order in random ways. Deserializing should never
+// panic, and anything that does deserialize has to serialize back to exactly the same bytes, or the
+// encoding isn't canonical.
+func TestFuzzDeserializeOrder(t *testing.T) {
+	var err error
+
+	var encOrder *EncryptedAuctionOrder
+	if encOrder, err = origOrder.TurnIntoEncryptedOrder(10000); err != nil {
+		t.Errorf("Error turning original test order into encrypted order: %s", err)
+		return
+	}
+
+	var raw []byte
+	if raw, err = encOrder.Serialize(); err != nil {
+		t.Errorf("Error serializing order: %s", err)
+		return
+	}
+
+	// leave the header alone, so we fuzz the canonical decoder and not gob
+	headerLen := 5
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		mutated := append([]byte{}, raw...)
+		switch rng.Intn(4) {
+		case 0:
+			// flip a random bit
+			pos := headerLen + rng.Intn(len(mutated)-headerLen)
+			mutated[pos] ^= 1 << uint(rng.Intn(8))
+		case 1:
+			// truncate
+			mutated = mutated[:headerLen+rng.Intn(len(mutated)-headerLen)]
+		case 2:
+			// append garbage
+			garbage := make([]byte, 1+rng.Intn(16))
+			rng.Read(garbage)
+			mutated = append(mutated, garbage...)
+		case 3:
+			// overwrite a random run of bytes
+			pos := headerLen + rng.Intn(len(mutated)-headerLen)
+			end := pos + 1 + rng.Intn(8)
+			if end > len(mutated) {
+				end = len(mutated)
+			}
+			rng.Read(mutated[pos:end])
+		}
+
+		decoded := new(EncryptedAuctionOrder)
+		if err = decoded.Deserialize(mutated); err != nil {
+			continue
+		}
+
+		var reencoded []byte
+		if reencoded, err = decoded.Serialize(); err != nil {
+			t.Errorf("Deserialized order %x could not be serialized: %s", mutated, err)
+			return
+		}
+
+		if !bytes.Equal(mutated, reencoded) {
+			t.Errorf("Deserialized order %x serialized to different bytes %x", mutated, reencoded)
+			return
+		}
+	}
+
+	return
+}
+
+func TestFuzzDeserializePuzzle(t *testing.T) {
+	var err error
+
+	var timelock crypto.Timelock
+	if timelock, err = rsw.New2048A2(make([]byte, 16)); err != nil {
+		t.Errorf("Error creating timelock: %s", err)
+		return
+	}
+
+	var rswPuzzle crypto.Puzzle
+	if rswPuzzle, _, err = timelock.SetupTimelockPuzzle(1000); err != nil {
+		t.Errorf("Error creating puzzle: %s", err)
+		return
+	}
+
+	var hashPuzzle crypto.Puzzle
+	if hashPuzzle, _, err = hashtimelock.New(make([]byte, 32), sha256.New()).SetupTimelockPuzzle(1000); err != nil {
+		t.Errorf("Error creating hash puzzle: %s", err)
+		return
+	}
+
+	rng := rand.New(rand.NewSource(2))
+	for _, puzzle := range []crypto.Puzzle{rswPuzzle, hashPuzzle} {
+		var raw []byte
+		if raw, err = SerializePuzzle(puzzle); err != nil {
+			t.Errorf("Error serializing puzzle: %s", err)
+			return
+		}
+
+		for i := 0; i < 5000; i++ {
+			mutated := append([]byte{}, raw...)
+			pos := rng.Intn(len(mutated))
+			mutated[pos] ^= 1 << uint(rng.Intn(8))
+			if rng.Intn(2) == 0 {
+				mutated = mutated[:rng.Intn(len(mutated))]
+			}
+
+			var decoded crypto.Puzzle
+			if decoded, err = DeserializePuzzle(mutated); err != nil {
+				continue
+			}
+
+			var reencoded []byte
+			if reencoded, err = SerializePuzzle(decoded); err != nil {
+				t.Errorf("Deserialized puzzle %x could not be serialized: %s", mutated, err)
+				return
+			}
+
+			if !bytes.Equal(mutated, reencoded) {
+				t.Errorf("Deserialized puzzle %x serialized to different bytes %x", mutated, reencoded)
+				return
+			}
+		}
+	}
+
+	return
+}
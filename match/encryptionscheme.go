@@ -0,0 +1,373 @@
+package match
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/mit-dci/opencx/crypto"
+	"github.com/mit-dci/opencx/crypto/rsw"
+	"github.com/mit-dci/opencx/crypto/threshold"
+	"github.com/mit-dci/opencx/crypto/timelockencoders"
+)
+
+const (
+	// EnvelopeVersionLegacy is the version of encrypted orders from before the scheme was recorded in
+	// the order. These are always RSW puzzles with RC5.
+	EnvelopeVersionLegacy = uint8(0)
+	// EnvelopeVersionScheme is the version of encrypted orders that record their scheme.
+	EnvelopeVersionScheme = uint8(1)
+	// CurrentEnvelopeVersion is the version that new encrypted orders are created with
+	CurrentEnvelopeVersion = EnvelopeVersionScheme
+)
+
+// TimelockScheme is the timelock used to hide the key for an encrypted order
+type TimelockScheme uint8
+
+const (
+	// TimelockRSW2048A2 is an RSW puzzle with a 2048 bit modulus and a base of 2
+	TimelockRSW2048A2 TimelockScheme = iota
+	// TimelockCommittee isn't a timelock, the key is hidden in a capsule for a decryption
+	// committee, which decrypts with threshold decryption after the auction ends
+	TimelockCommittee
+)
+
+// String returns the string representation of the timelock scheme
+func (ts TimelockScheme) String() string {
+	switch ts {
+	case TimelockRSW2048A2:
+		return "rsw2048a2"
+	case TimelockCommittee:
+		return "committee"
+	default:
+		return "unknown"
+	}
+}
+
+// CipherScheme is the cipher used to encrypt an order with the key from the timelock
+type CipherScheme uint8
+
+const (
+	// CipherRC5 is RC5 in CFB mode with the IV prepended, as described in RSW96
+	CipherRC5 CipherScheme = iota
+	// CipherRC6 is RC6 in CFB mode with the IV prepended
+	CipherRC6
+	// CipherAES is AES in CFB mode with the IV prepended
+	CipherAES
+	// CipherRSA is RSA PKCS#1 v1.5, where the timelock hides the private key
+	CipherRSA
+	// CipherECIES is ECIES on secp256k1, where the timelock hides the private key
+	CipherECIES
+	// CipherAESGCM is AES-GCM with the nonce prepended, which authenticates the order along with
+	// its auction ID and pair
+	CipherAESGCM
+	// CipherChaCha20Poly1305 is ChaCha20-Poly1305 with the nonce prepended, which authenticates the
+	// order along with its auction ID and pair
+	CipherChaCha20Poly1305
+)
+
+// String returns the string representation of the cipher scheme
+func (cs CipherScheme) String() string {
+	switch cs {
+	case CipherRC5:
+		return "rc5"
+	case CipherRC6:
+		return "rc6"
+	case CipherAES:
+		return "aes"
+	case CipherRSA:
+		return "rsa"
+	case CipherECIES:
+		return "ecies"
+	case CipherAESGCM:
+		return "aesgcm"
+	case CipherChaCha20Poly1305:
+		return "chacha20poly1305"
+	default:
+		return "unknown"
+	}
+}
+
+// EncryptionScheme is a combination of a timelock and a cipher that an order can be encrypted with
+type EncryptionScheme struct {
+	Timelock TimelockScheme
+	Cipher   CipherScheme
+}
+
+var (
+	// DefaultEncryptionScheme is the scheme that orders are encrypted with unless otherwise specified,
+	// and the scheme for legacy orders.
+	DefaultEncryptionScheme = EncryptionScheme{
+		Timelock: TimelockRSW2048A2,
+		Cipher:   CipherRC5,
+	}
+
+	// SupportedEncryptionSchemes are all of the schemes we can create and solve
+	SupportedEncryptionSchemes = []EncryptionScheme{
+		{Timelock: TimelockRSW2048A2, Cipher: CipherRC5},
+		{Timelock: TimelockRSW2048A2, Cipher: CipherRC6},
+		{Timelock: TimelockRSW2048A2, Cipher: CipherAES},
+		{Timelock: TimelockRSW2048A2, Cipher: CipherRSA},
+		{Timelock: TimelockRSW2048A2, Cipher: CipherECIES},
+		{Timelock: TimelockRSW2048A2, Cipher: CipherAESGCM},
+		{Timelock: TimelockRSW2048A2, Cipher: CipherChaCha20Poly1305},
+		{Timelock: TimelockCommittee, Cipher: CipherAESGCM},
+		{Timelock: TimelockCommittee, Cipher: CipherChaCha20Poly1305},
+	}
+)
+
+// String returns the string representation of the scheme, like rsw2048a2-rc5
+func (es EncryptionScheme) String() string {
+	return fmt.Sprintf("%s-%s", es.Timelock.String(), es.Cipher.String())
+}
+
+// EncryptionSchemeFromString parses a scheme from its string representation, like rsw2048a2-rc5.
+// The scheme must be supported.
+func EncryptionSchemeFromString(schemeStr string) (scheme EncryptionScheme, err error) {
+	for _, supported := range SupportedEncryptionSchemes {
+		if strings.ToLower(schemeStr) == supported.String() {
+			scheme = supported
+			return
+		}
+	}
+
+	err = fmt.Errorf("Unsupported encryption scheme %s", schemeStr)
+	return
+}
+
+// Supported returns whether or not we can create and solve orders with this scheme
+func (es EncryptionScheme) Supported() (supported bool) {
+	for _, s := range SupportedEncryptionSchemes {
+		if es == s {
+			supported = true
+			return
+		}
+	}
+	return
+}
+
+// encrypt creates a timelock puzzle with time t and encrypts the message with the cipher. AEAD
+// ciphers also authenticate the additional data, the other ciphers ignore it.
+func (es EncryptionScheme) encrypt(t uint64, message []byte, additionalData []byte) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
+	if !es.Supported() {
+		err = fmt.Errorf("Cannot encrypt with unsupported scheme %s", es.String())
+		return
+	}
+
+	if es.Timelock != TimelockRSW2048A2 {
+		err = fmt.Errorf("Cannot encrypt with scheme %s using a time, it needs a committee key", es.String())
+		return
+	}
+
+	switch es.Cipher {
+	case CipherRC5:
+		ciphertext, puzzle, err = timelockencoders.CreateRSW2048A2PuzzleRC5(t, message)
+	case CipherRC6:
+		ciphertext, puzzle, err = timelockencoders.CreateRSW2048A2PuzzleRC6(t, message)
+	case CipherAES:
+		ciphertext, puzzle, err = timelockencoders.CreateRSW2048A2PuzzleAES(t, message)
+	case CipherRSA:
+		ciphertext, puzzle, err = timelockencoders.CreateRSW2048A2PuzzleRSA(t, message)
+	case CipherECIES:
+		ciphertext, puzzle, err = timelockencoders.CreateRSW2048A2PuzzleECIES(t, message)
+	case CipherAESGCM:
+		ciphertext, puzzle, err = timelockencoders.CreateRSW2048A2PuzzleAESGCM(t, message, additionalData)
+	case CipherChaCha20Poly1305:
+		ciphertext, puzzle, err = timelockencoders.CreateRSW2048A2PuzzleChaCha20Poly1305(t, message, additionalData)
+	}
+	if err != nil {
+		err = fmt.Errorf("Error encrypting with scheme %s: %s", es.String(), err)
+		return
+	}
+
+	return
+}
+
+// encryptToCommittee hides a key for the committee and encrypts the message with the cipher,
+// authenticating the additional data. The capsule is bound to the additional data too, so the
+// committee knows what it is giving out shares for. Only AEAD ciphers can be used with a
+// committee.
+func (es EncryptionScheme) encryptToCommittee(committee *threshold.CommitteeKey, message []byte, additionalData []byte) (ciphertext []byte, puzzle crypto.Puzzle, err error) {
+	if !es.Supported() || es.Timelock != TimelockCommittee {
+		err = fmt.Errorf("Cannot encrypt to a committee with scheme %s", es.String())
+		return
+	}
+
+	switch es.Cipher {
+	case CipherAESGCM:
+		ciphertext, puzzle, err = timelockencoders.CreatePuzzleAESGCM(0, message, additionalData, timelockencoders.CommitteePuzzleCreator(committee, additionalData))
+	case CipherChaCha20Poly1305:
+		ciphertext, puzzle, err = timelockencoders.CreatePuzzleChaCha20Poly1305(0, message, additionalData, timelockencoders.CommitteePuzzleCreator(committee, additionalData))
+	default:
+		err = fmt.Errorf("Cipher %s cannot be used with a committee", es.Cipher.String())
+	}
+	if err != nil {
+		err = fmt.Errorf("Error encrypting with scheme %s: %s", es.String(), err)
+		return
+	}
+
+	return
+}
+
+// CheckPuzzleType checks that the puzzle is the type of puzzle the timelock creates
+func (es EncryptionScheme) CheckPuzzleType(puzzle crypto.Puzzle) (err error) {
+	switch es.Timelock {
+	case TimelockRSW2048A2:
+		if _, ok := puzzle.(*rsw.PuzzleRSW); !ok {
+			err = fmt.Errorf("Puzzle is not an RSW puzzle, but scheme is %s", es.String())
+			return
+		}
+	case TimelockCommittee:
+		if _, ok := puzzle.(*threshold.Capsule); !ok {
+			err = fmt.Errorf("Puzzle is not a committee capsule, but scheme is %s", es.String())
+			return
+		}
+	default:
+		err = fmt.Errorf("Unknown timelock scheme %d", es.Timelock)
+		return
+	}
+
+	return
+}
+
+// CheckCiphertext checks that the ciphertext looks like it was created with the cipher
+func (es EncryptionScheme) CheckCiphertext(ciphertext []byte) (err error) {
+	switch es.Cipher {
+	case CipherRC5:
+		err = timelockencoders.CheckCiphertextRC5(ciphertext)
+	case CipherRC6:
+		err = timelockencoders.CheckCiphertextRC6(ciphertext)
+	case CipherAES:
+		err = timelockencoders.CheckCiphertextAES(ciphertext)
+	case CipherRSA:
+		err = timelockencoders.CheckCiphertextRSA(ciphertext)
+	case CipherECIES:
+		err = timelockencoders.CheckCiphertextECIES(ciphertext)
+	case CipherAESGCM:
+		err = timelockencoders.CheckCiphertextAESGCM(ciphertext)
+	case CipherChaCha20Poly1305:
+		err = timelockencoders.CheckCiphertextChaCha20Poly1305(ciphertext)
+	default:
+		err = fmt.Errorf("Unknown cipher scheme %d", es.Cipher)
+	}
+	return
+}
+
+// decrypt solves the puzzle and decrypts the ciphertext with the cipher, authenticating the
+// additional data if the cipher is an AEAD
+func (es EncryptionScheme) decrypt(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle) (message []byte, err error) {
+	if !es.Supported() {
+		err = fmt.Errorf("Cannot decrypt with unsupported scheme %s", es.String())
+		return
+	}
+
+	if err = es.CheckPuzzleType(puzzle); err != nil {
+		return
+	}
+
+	message, err = es.decryptCiphertext(ciphertext, additionalData, puzzle)
+	return
+}
+
+// decryptWithProof checks the proof of the puzzle's solution instead of solving it, and decrypts
+// the ciphertext with the cipher
+func (es EncryptionScheme) decryptWithProof(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle, proof *rsw.VDFProof) (message []byte, err error) {
+	if !es.Supported() {
+		err = fmt.Errorf("Cannot decrypt with unsupported scheme %s", es.String())
+		return
+	}
+
+	if es.Timelock != TimelockRSW2048A2 {
+		err = fmt.Errorf("Only RSW puzzles can be decrypted with a proof, scheme is %s", es.String())
+		return
+	}
+
+	if err = es.CheckPuzzleType(puzzle); err != nil {
+		return
+	}
+
+	// CheckPuzzleType makes sure this is an RSW puzzle
+	provenPuzzle := &rsw.ProvenPuzzle{
+		Puzzle: puzzle.(*rsw.PuzzleRSW),
+		Proof:  proof,
+	}
+
+	message, err = es.decryptCiphertext(ciphertext, additionalData, provenPuzzle)
+	return
+}
+
+// decryptSolved uses Y = A^(2^T) mod N instead of solving the puzzle, and decrypts the ciphertext
+// with the cipher
+func (es EncryptionScheme) decryptSolved(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle, y *big.Int) (message []byte, err error) {
+	if !es.Supported() {
+		err = fmt.Errorf("Cannot decrypt with unsupported scheme %s", es.String())
+		return
+	}
+
+	if es.Timelock != TimelockRSW2048A2 {
+		err = fmt.Errorf("Only RSW puzzles can be decrypted with a solution, scheme is %s", es.String())
+		return
+	}
+
+	if err = es.CheckPuzzleType(puzzle); err != nil {
+		return
+	}
+
+	// CheckPuzzleType makes sure this is an RSW puzzle
+	solvedPuzzle := &rsw.SolvedPuzzle{
+		Puzzle: puzzle.(*rsw.PuzzleRSW),
+		Y:      y,
+	}
+
+	message, err = es.decryptCiphertext(ciphertext, additionalData, solvedPuzzle)
+	return
+}
+
+// decryptWithShares opens the committee capsule with decryption shares, and decrypts the
+// ciphertext with the cipher
+func (es EncryptionScheme) decryptWithShares(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle, committee *threshold.CommitteeKey, shares []*threshold.DecryptionShare) (message []byte, err error) {
+	if !es.Supported() || es.Timelock != TimelockCommittee {
+		err = fmt.Errorf("Only committee capsules can be decrypted with shares, scheme is %s", es.String())
+		return
+	}
+
+	if err = es.CheckPuzzleType(puzzle); err != nil {
+		return
+	}
+
+	// CheckPuzzleType makes sure this is a capsule
+	openedCapsule := &threshold.OpenedCapsule{
+		Capsule:   puzzle.(*threshold.Capsule),
+		Committee: committee,
+		Shares:    shares,
+	}
+
+	message, err = es.decryptCiphertext(ciphertext, additionalData, openedCapsule)
+	return
+}
+
+// decryptCiphertext gets the key from the puzzle and decrypts the ciphertext with the cipher
+func (es EncryptionScheme) decryptCiphertext(ciphertext []byte, additionalData []byte, puzzle crypto.Puzzle) (message []byte, err error) {
+	switch es.Cipher {
+	case CipherRC5:
+		message, err = timelockencoders.SolvePuzzleRC5(ciphertext, puzzle)
+	case CipherRC6:
+		message, err = timelockencoders.SolvePuzzleRC6(ciphertext, puzzle)
+	case CipherAES:
+		message, err = timelockencoders.SolvePuzzleAES(ciphertext, puzzle)
+	case CipherRSA:
+		message, err = timelockencoders.SolvePuzzleRSA(ciphertext, puzzle)
+	case CipherECIES:
+		message, err = timelockencoders.SolvePuzzleECIES(ciphertext, puzzle)
+	case CipherAESGCM:
+		message, err = timelockencoders.SolvePuzzleAESGCM(ciphertext, additionalData, puzzle)
+	case CipherChaCha20Poly1305:
+		message, err = timelockencoders.SolvePuzzleChaCha20Poly1305(ciphertext, additionalData, puzzle)
+	}
+	if err != nil {
+		err = fmt.Errorf("Error decrypting with scheme %s: %s", es.String(), err)
+		return
+	}
+
+	return
+}
@@ -0,0 +1,130 @@
+package match
+
+import (
+	"fmt"
+)
+
+// ErrorCode is a stable code for a kind of error, so clients can tell errors apart without
+// matching on messages. The code is kept when an error is wrapped with Wrapf, and is sent to
+// clients in the ErrorReply of RPC replies, separate from the message.
+type ErrorCode string
+
+const (
+	// ErrInsufficientFunds means the balance isn't enough for an order, withdrawal or bond
+	ErrInsufficientFunds ErrorCode = "INSUFFICIENT_FUNDS"
+	// ErrUnknownPair means the exchange doesn't trade the pair
+	ErrUnknownPair ErrorCode = "UNKNOWN_PAIR"
+	// ErrUnknownAsset means the exchange doesn't have the asset
+	ErrUnknownAsset ErrorCode = "UNKNOWN_ASSET"
+	// ErrPriceOutOfBounds means an order's price is too high or too low
+	ErrPriceOutOfBounds ErrorCode = "PRICE_OUT_OF_BOUNDS"
+	// ErrInvalidAmount means an amount is zero, too small, or would overflow
+	ErrInvalidAmount ErrorCode = "INVALID_AMOUNT"
+	// ErrInvalidOrder means an order is malformed, or isn't the kind the pair takes
+	ErrInvalidOrder ErrorCode = "INVALID_ORDER"
+	// ErrUnknownOrder means there's no order with the ID
+	ErrUnknownOrder ErrorCode = "UNKNOWN_ORDER"
+	// ErrNotOwner means the order belongs to another pubkey
+	ErrNotOwner ErrorCode = "NOT_OWNER"
+	// ErrUnauthorized means a request wasn't signed, or its signature or nonce isn't valid
+	ErrUnauthorized ErrorCode = "UNAUTHORIZED"
+	// ErrAuctionClosed means the auction isn't taking orders, commitments or reveals
+	ErrAuctionClosed ErrorCode = "AUCTION_CLOSED"
+	// ErrAuctionFull means the auction already has as many orders as it can take
+	ErrAuctionFull ErrorCode = "AUCTION_FULL"
+	// ErrServerBusy means the server can't take more work right now, and the request can be retried
+	ErrServerBusy ErrorCode = "SERVER_BUSY"
+	// ErrRateLimited means the client made too many requests, and is the code of throttled requests
+	ErrRateLimited ErrorCode = "RATE_LIMITED"
+)
+
+// Error is an error with a code
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+// Errorf creates an error with a code, formatting the message like fmt.Errorf
+func Errorf(code ErrorCode, format string, args ...interface{}) (err error) {
+	err = &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+	}
+	return
+}
+
+// Wrapf formats a message like fmt.Errorf for an error that wraps inner, keeping the code of inner
+// if it has one. Errors that can have a code should be wrapped with this, so the code makes it
+// to the client.
+func Wrapf(inner error, format string, args ...interface{}) (err error) {
+	if code := CodeOf(inner); code != "" {
+		err = Errorf(code, format, args...)
+		return
+	}
+	err = fmt.Errorf(format, args...)
+	return
+}
+
+// Error returns the message
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// ErrorCode returns the code
+func (e *Error) ErrorCode() ErrorCode {
+	return e.Code
+}
+
+// CodedError is an error with a code. Errors from other packages can have a code by implementing
+// it.
+type CodedError interface {
+	error
+	ErrorCode() ErrorCode
+}
+
+// CodeOf returns the code of an error, or an empty code if it doesn't have one
+func CodeOf(err error) (code ErrorCode) {
+	if codedErr, ok := err.(CodedError); ok {
+		code = codedErr.ErrorCode()
+	}
+	return
+}
+
+// ErrorReply is embedded in every RPC reply, so coded errors get to clients with their code.
+// net/rpc only sends errors as strings, and drops the reply, so a method that fails with a coded
+// error puts it in the reply with SetError and returns no error. Clients get it back with
+// ReplyError.
+type ErrorReply struct {
+	ErrorCode    ErrorCode `json:",omitempty"`
+	ErrorMessage string    `json:",omitempty"`
+}
+
+// SetError moves err into the reply if it has a code, and clears it. Errors without a code are
+// left to be sent as strings. Methods defer it with their named error.
+func (reply *ErrorReply) SetError(err *error) {
+	if code := CodeOf(*err); code != "" {
+		reply.ErrorCode = code
+		reply.ErrorMessage = (*err).Error()
+		*err = nil
+	}
+	return
+}
+
+// Err returns the error in the reply, or nil if there isn't one
+func (reply *ErrorReply) Err() (err error) {
+	if reply.ErrorCode != "" {
+		err = &Error{
+			Code:    reply.ErrorCode,
+			Message: reply.ErrorMessage,
+		}
+	}
+	return
+}
+
+// ReplyError returns the error in an RPC reply, if the reply embeds an ErrorReply
+func ReplyError(reply interface{}) (err error) {
+	if errReply, ok := reply.(interface{ Err() error }); ok {
+		err = errReply.Err()
+	}
+	return
+}
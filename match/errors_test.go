@@ -0,0 +1,72 @@
+package match
+
+import (
+	"fmt"
+	"testing"
+)
+
+// TestWrapf makes sure codes are kept when errors are wrapped with Wrapf, and that errors without
+// a code don't get one
+func TestWrapf(t *testing.T) {
+	inner := Errorf(ErrInsufficientFunds, "Not enough balance for %d", 5)
+	if CodeOf(inner) != ErrInsufficientFunds {
+		t.Errorf("Code of coded error should be %s, got %s", ErrInsufficientFunds, CodeOf(inner))
+		return
+	}
+
+	wrapped := Wrapf(inner, "Error placing order for PlaceOrder RPC command: %s", inner)
+	wrapped = Wrapf(wrapped, "Error with order [NOT_A_CODE]: %s", wrapped)
+	if CodeOf(wrapped) != ErrInsufficientFunds {
+		t.Errorf("Code of wrapped error should be %s, got %s", ErrInsufficientFunds, CodeOf(wrapped))
+		return
+	}
+	if wrapped.Error() != "Error with order [NOT_A_CODE]: Error placing order for PlaceOrder RPC command: Not enough balance for 5" {
+		t.Errorf("Wrapped error should only have the messages, got %s", wrapped)
+		return
+	}
+
+	// a code in the message isn't a code
+	if code := CodeOf(fmt.Errorf("Some error [%s]", ErrInsufficientFunds)); code != "" {
+		t.Errorf("Error without a code should have an empty code, got %s", code)
+		return
+	}
+	if code := CodeOf(Wrapf(fmt.Errorf("Some error"), "Error wrapping: %s", "Some error")); code != "" {
+		t.Errorf("Wrapped error without a code should have an empty code, got %s", code)
+		return
+	}
+	if code := CodeOf(nil); code != "" {
+		t.Errorf("Nil error should have an empty code, got %s", code)
+		return
+	}
+}
+
+// TestErrorReply makes sure coded errors are moved into replies, and that errors without a code
+// are left alone
+func TestErrorReply(t *testing.T) {
+	reply := new(ErrorReply)
+	err := Errorf(ErrUnknownPair, "Could not find pair")
+	reply.SetError(&err)
+	if err != nil {
+		t.Errorf("Coded error should be moved into the reply, still have %s", err)
+		return
+	}
+
+	var replyErr error
+	if replyErr = ReplyError(reply); CodeOf(replyErr) != ErrUnknownPair || replyErr.Error() != "Could not find pair" {
+		t.Errorf("Reply should have error with code %s, got %v with code %s", ErrUnknownPair, replyErr, CodeOf(replyErr))
+		return
+	}
+
+	reply = new(ErrorReply)
+	err = fmt.Errorf("Some error without a code")
+	reply.SetError(&err)
+	if err == nil || reply.Err() != nil {
+		t.Errorf("Error without a code should not be moved into the reply")
+		return
+	}
+
+	if replyErr = ReplyError(new(int)); replyErr != nil {
+		t.Errorf("Reply without an ErrorReply should have no error, got %s", replyErr)
+		return
+	}
+}
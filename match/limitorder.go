@@ -30,17 +30,14 @@ func (l *LimitOrder) Price() (price float64, err error) {
 	return
 }
 
-// Serialize serializes an order, possible replay attacks here since this is what you're signing?
+// Serialize serializes every field of an order, this is what signed requests for the order commit to
 func (l *LimitOrder) Serialize() (buf []byte, err error) {
 	intermediate := new(bytes.Buffer)
 	if err = binary.Write(intermediate, binary.LittleEndian, *l); err != nil {
 		err = fmt.Errorf("Error writing limit order to binary for serialize: %s", err)
 		return
 	}
-	if _, err = intermediate.Read(buf); err != nil {
-		err = fmt.Errorf("Error reading from intermediate into buffer for serialize: %s", err)
-		return
-	}
+	buf = intermediate.Bytes()
 	return
 }
 
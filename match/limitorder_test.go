@@ -0,0 +1,52 @@
+package match
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestLimitOrderSerialize makes sure every field of a limit order changes what it serializes to,
+// since that's what signed requests commit to
+func TestLimitOrderSerialize(t *testing.T) {
+	orig := LimitOrder{
+		Pubkey:      [33]byte{0x02, 0x01},
+		Side:        Buy,
+		TradingPair: Pair{AssetWant: BTC, AssetHave: VTC},
+		AmountHave:  100000,
+		AmountWant:  200000,
+	}
+
+	var err error
+	var origBytes []byte
+	if origBytes, err = orig.Serialize(); err != nil {
+		t.Errorf("Error serializing order: %s", err)
+		return
+	}
+	if len(origBytes) == 0 {
+		t.Errorf("Serialized order should not be empty")
+		return
+	}
+
+	changes := map[string]func(l *LimitOrder){
+		"pubkey":      func(l *LimitOrder) { l.Pubkey[32] = 0xff },
+		"side":        func(l *LimitOrder) { l.Side = Sell },
+		"asset want":  func(l *LimitOrder) { l.TradingPair.AssetWant = LTCTest },
+		"asset have":  func(l *LimitOrder) { l.TradingPair.AssetHave = LTCTest },
+		"amount have": func(l *LimitOrder) { l.AmountHave++ },
+		"amount want": func(l *LimitOrder) { l.AmountWant++ },
+	}
+	for name, change := range changes {
+		changed := orig
+		change(&changed)
+
+		var changedBytes []byte
+		if changedBytes, err = changed.Serialize(); err != nil {
+			t.Errorf("Error serializing order with changed %s: %s", name, err)
+			return
+		}
+		if bytes.Equal(origBytes, changedBytes) {
+			t.Errorf("Changing the %s should change the serialized order", name)
+			return
+		}
+	}
+}
@@ -11,23 +11,23 @@ type Withdrawal struct {
 	Lightning bool
 }
 
-// Serialize serializes the withdrawal
+// Serialize serializes the withdrawal, this is what signed requests for the withdrawal commit to
 func (w *Withdrawal) Serialize() (buf []byte) {
-	// bool yes or no
+	// bool yes or no [1 byte]
 	// Asset [1 byte]
-	// Amount [64 bytes]
-	// len(address)
+	// Amount [8 bytes]
+	// len(address) [8 bytes]
 	// Address [len(address)]
 
 	var oneorzero byte
 	if w.Lightning {
 		oneorzero = 0xff
 	}
-	buf = make([]byte, 66+len(w.Address))
-	buf = append(buf, oneorzero)
-	buf = append(buf, byte(w.Asset))
-	binary.LittleEndian.PutUint64(buf, w.Amount)
-	binary.LittleEndian.PutUint64(buf, uint64(len(w.Address)))
-	buf = append(buf, []byte(w.Address)...)
+	buf = make([]byte, 18+len(w.Address))
+	buf[0] = oneorzero
+	buf[1] = byte(w.Asset)
+	binary.LittleEndian.PutUint64(buf[2:10], w.Amount)
+	binary.LittleEndian.PutUint64(buf[10:18], uint64(len(w.Address)))
+	copy(buf[18:], []byte(w.Address))
 	return
 }
@@ -0,0 +1,38 @@
+package match
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestWithdrawalSerialize makes sure every field of a withdrawal changes what it serializes to,
+// since that's what signed requests commit to
+func TestWithdrawalSerialize(t *testing.T) {
+	orig := Withdrawal{
+		Asset:   BTC,
+		Amount:  100000,
+		Address: "bc1qexampleaddress",
+	}
+	origBytes := orig.Serialize()
+
+	changes := map[string]func(w *Withdrawal){
+		"asset":     func(w *Withdrawal) { w.Asset = VTC },
+		"amount":    func(w *Withdrawal) { w.Amount++ },
+		"address":   func(w *Withdrawal) { w.Address = "bc1qotheraddress" },
+		"lightning": func(w *Withdrawal) { w.Lightning = true },
+	}
+	for name, change := range changes {
+		changed := orig
+		change(&changed)
+		if bytes.Equal(origBytes, changed.Serialize()) {
+			t.Errorf("Changing the %s should change the serialized withdrawal", name)
+			return
+		}
+	}
+
+	// the address is length prefixed, so the amount and address can't run into each other
+	if len(origBytes) != 18+len(orig.Address) {
+		t.Errorf("Serialized withdrawal should be %d bytes, got %d", 18+len(orig.Address), len(origBytes))
+		return
+	}
+}